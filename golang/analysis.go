@@ -0,0 +1,83 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"math"
+	"unsafe"
+)
+
+// LumaStats holds luma statistics of a frame or a whole input.
+// Luma is computed with BT.709 weights on a 0-255 scale.
+type LumaStats struct {
+	Histogram     [256]uint64 // Number of pixels at each luma level
+	PixelCount    uint64      // Total number of pixels
+	Mean          float64     // Mean luma (0-255)
+	ShadowClip    float64     // Fraction of pixels with clipped shadows (luma 0-2)
+	HighlightClip float64     // Fraction of pixels with clipped highlights (luma 253-255)
+}
+
+// LumaAnalysis holds the result of AnalyzeLuma
+type LumaAnalysis struct {
+	Frames    []LumaStats // Statistics of each frame (a single frame for images)
+	Aggregate LumaStats   // Statistics of all frames together
+}
+
+// Percentile returns the luma level below which the given fraction (0-1) of pixels lies
+func (s *LumaStats) Percentile(fraction float64) uint8 {
+	fraction = min(max(fraction, 0), 1)
+	target := max(uint64(math.Ceil(float64(s.PixelCount)*fraction)), 1)
+	var count uint64
+	for level, n := range s.Histogram {
+		count += n
+		if count >= target {
+			return uint8(level)
+		}
+	}
+	return 255
+}
+
+// AnalyzeLuma returns the luma histograms and clipping statistics of an image or video.
+// Images give a single frame; videos are decoded with ffmpeg and give one entry per frame.
+func AnalyzeLuma(path, ffmpegPath string) (*LumaAnalysis, error) {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	var cFfmpegPath *C.char
+	if ffmpegPath != "" {
+		cFfmpegPath = C.CString(ffmpegPath)
+		defer C.free(unsafe.Pointer(cFfmpegPath))
+	}
+
+	var aggregate C.LumaStats
+	var frames *C.LumaStats
+	var frameCount C.size_t
+	result := C.minmpeg_analyze_luma(cPath, cFfmpegPath, &aggregate, &frames, &frameCount)
+	if err := resultToError(result); err != nil {
+		return nil, err
+	}
+	defer C.minmpeg_free_luma_stats(frames, frameCount)
+
+	analysis := &LumaAnalysis{Aggregate: lumaStatsFromC(&aggregate)}
+	for _, f := range unsafe.Slice(frames, int(frameCount)) {
+		analysis.Frames = append(analysis.Frames, lumaStatsFromC(&f))
+	}
+	return analysis, nil
+}
+
+// lumaStatsFromC converts C luma statistics
+func lumaStatsFromC(c *C.LumaStats) LumaStats {
+	s := LumaStats{
+		PixelCount:    uint64(c.pixel_count),
+		Mean:          float64(c.mean),
+		ShadowClip:    float64(c.shadow_clip),
+		HighlightClip: float64(c.highlight_clip),
+	}
+	for i, n := range c.histogram {
+		s.Histogram[i] = uint64(n)
+	}
+	return s
+}
@@ -0,0 +1,225 @@
+package minmpeg
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultZipDurationMs is the duration of slides taken from a zip archive
+// when ZipSlides.DurationMs is 0
+const DefaultZipDurationMs = 3000
+
+// ZipOrder selects the order of the slides taken from a zip archive
+type ZipOrder int
+
+const (
+	ZipOrderNatural  ZipOrder = iota // By path, with numbers compared by value (photo2 before photo10)
+	ZipOrderName                     // By path, byte by byte
+	ZipOrderArchive                  // In the order the entries are stored
+	ZipOrderModified                 // By modification time, then naturally by path
+)
+
+// zipImageExtensions lists the extensions of the entries used as slides
+var zipImageExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".webp": true, ".gif": true,
+	".hdr": true, ".exr": true, ".heic": true, ".heif": true, ".avif": true, ".svg": true,
+}
+
+// ZipSlides configures how the images in a zip archive become slides
+type ZipSlides struct {
+	Order      ZipOrder          // Order of the slides
+	DurationMs uint32            // Duration of each slide (0 for DefaultZipDurationMs)
+	Durations  map[string]uint32 // Durations of single slides by their path in the archive
+}
+
+// SlideEntriesFromZip extracts the images of a zip archive into dir and
+// returns them as slides. Entries without an image extension, directories and
+// hidden files, such as the __MACOSX folder added by macOS, are ignored, and
+// the images are written under generated names so entry paths cannot escape
+// dir. It fails with ErrInvalidInput when the archive is invalid, holds no
+// images, or Durations names an image it does not hold.
+func SlideEntriesFromZip(r io.ReaderAt, size int64, dir string, slides ZipSlides) ([]SlideEntry, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, newError(ErrInvalidInput, fmt.Sprintf("invalid zip archive: %v", err))
+	}
+
+	var files []*zip.File
+	for _, f := range archive.File {
+		if isZipImage(f) {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return nil, newError(ErrInvalidInput, "zip archive contains no images")
+	}
+	for name := range slides.Durations {
+		if !containsZipFile(files, name) {
+			return nil, newError(ErrInvalidInput, fmt.Sprintf("zip archive has no image %q", name))
+		}
+	}
+
+	switch slides.Order {
+	case ZipOrderNatural:
+		sort.SliceStable(files, func(i, j int) bool { return naturalLess(files[i].Name, files[j].Name) })
+	case ZipOrderName:
+		sort.SliceStable(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	case ZipOrderModified:
+		sort.SliceStable(files, func(i, j int) bool {
+			a, b := files[i].Modified, files[j].Modified
+			if !a.Equal(b) {
+				return a.Before(b)
+			}
+			return naturalLess(files[i].Name, files[j].Name)
+		})
+	}
+
+	duration := slides.DurationMs
+	if duration == 0 {
+		duration = DefaultZipDurationMs
+	}
+	entries := make([]SlideEntry, len(files))
+	for i, f := range files {
+		name := fmt.Sprintf("%05d%s", i, strings.ToLower(path.Ext(f.Name)))
+		imagePath := filepath.Join(dir, name)
+		if err := extractZipFile(f, imagePath); err != nil {
+			return nil, err
+		}
+		entries[i] = SlideEntry{Path: imagePath, DurationMs: duration}
+		if d, ok := slides.Durations[f.Name]; ok {
+			entries[i].DurationMs = d
+		}
+	}
+	return entries, nil
+}
+
+// SlideshowFromZip creates a slideshow from the images of the zip archive at
+// zipPath, as arranged by slides. The images are extracted to a temporary
+// directory, which is removed afterwards.
+func SlideshowFromZip(zipPath string, slides ZipSlides, outputPath string, container Container, codec Codec, quality uint8, ffmpegPath string, opts ...Option) error {
+	o := newOptions(opts)
+	f, err := os.Open(zipPath)
+	if err != nil {
+		return o.newError(ErrIO, fmt.Sprintf("failed to open zip archive: %v", err))
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return o.newError(ErrIO, fmt.Sprintf("failed to read zip archive: %v", err))
+	}
+	return SlideshowFromZipReader(f, info.Size(), slides, outputPath, container, codec, quality, ffmpegPath, opts...)
+}
+
+// SlideshowFromZipReader creates a slideshow like SlideshowFromZip from a zip
+// archive of size bytes read from r, such as an upload held in memory
+// (bytes.Reader) or an object in storage.
+func SlideshowFromZipReader(r io.ReaderAt, size int64, slides ZipSlides, outputPath string, container Container, codec Codec, quality uint8, ffmpegPath string, opts ...Option) error {
+	o := newOptions(opts)
+	dir, err := os.MkdirTemp("", "minmpeg-zip-*")
+	if err != nil {
+		return o.newError(ErrIO, fmt.Sprintf("failed to create temporary directory: %v", err))
+	}
+	defer os.RemoveAll(dir)
+
+	entries, err := SlideEntriesFromZip(r, size, dir, slides)
+	if err != nil {
+		return o.localize(err)
+	}
+	return Slideshow(entries, outputPath, container, codec, quality, ffmpegPath, opts...)
+}
+
+// isZipImage reports whether an entry is an image file that is not hidden
+func isZipImage(f *zip.File) bool {
+	if f.FileInfo().IsDir() {
+		return false
+	}
+	for _, part := range strings.Split(f.Name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return false
+		}
+	}
+	return zipImageExtensions[strings.ToLower(path.Ext(f.Name))]
+}
+
+// containsZipFile reports whether files has an entry named name
+func containsZipFile(files []*zip.File, name string) bool {
+	for _, f := range files {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// extractZipFile writes the contents of an entry to a file
+func extractZipFile(f *zip.File, outputPath string) error {
+	src, err := f.Open()
+	if err != nil {
+		return newError(ErrInvalidInput, fmt.Sprintf("failed to read %s from zip archive: %v", f.Name, err))
+	}
+	defer src.Close()
+
+	dst, err := os.Create(outputPath)
+	if err != nil {
+		return newError(ErrIO, fmt.Sprintf("failed to create %s: %v", outputPath, err))
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return newError(ErrInvalidInput, fmt.Sprintf("failed to read %s from zip archive: %v", f.Name, err))
+	}
+	if err := dst.Close(); err != nil {
+		return newError(ErrIO, fmt.Sprintf("failed to write %s: %v", outputPath, err))
+	}
+	return nil
+}
+
+// naturalLess compares paths case-insensitively, with runs of digits compared
+// by their value
+func naturalLess(a, b string) bool {
+	x, y := strings.ToLower(a), strings.ToLower(b)
+	for x != "" && y != "" {
+		xd, yd := isDigit(x[0]), isDigit(y[0])
+		if xd && yd {
+			xn, xr := splitDigits(x)
+			yn, yr := splitDigits(y)
+			// Without leading zeros, longer numbers are larger
+			xv, yv := strings.TrimLeft(xn, "0"), strings.TrimLeft(yn, "0")
+			if len(xv) != len(yv) {
+				return len(xv) < len(yv)
+			}
+			if xv != yv {
+				return xv < yv
+			}
+			x, y = xr, yr
+			continue
+		}
+		if x[0] != y[0] {
+			return x[0] < y[0]
+		}
+		x, y = x[1:], y[1:]
+	}
+	if x != y {
+		return x == ""
+	}
+	return a < b
+}
+
+// splitDigits splits the leading run of digits off s
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
@@ -0,0 +1,102 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+#include <stdlib.h>
+*/
+import "C"
+import "unsafe"
+
+// AudioFit represents how audio is fitted to the video length
+type AudioFit int
+
+const (
+	AudioFitTrim AudioFit = C.AUDIO_FIT_TRIM
+	AudioFitLoop AudioFit = C.AUDIO_FIT_LOOP
+)
+
+// AudioCodec represents the codec of an extracted audio file
+type AudioCodec int
+
+const (
+	AudioCodecAuto AudioCodec = C.AUDIO_CODEC_AUTO // Chosen by the output extension
+	AudioCodecMP3  AudioCodec = C.AUDIO_CODEC_MP3
+	AudioCodecAAC  AudioCodec = C.AUDIO_CODEC_AAC
+	AudioCodecOpus AudioCodec = C.AUDIO_CODEC_OPUS
+	AudioCodecWAV  AudioCodec = C.AUDIO_CODEC_WAV
+)
+
+// ReplaceAudio replaces the audio track of a video with an audio file.
+// The video stream is copied and the output keeps the video length.
+// The output must be an .mp4, .m4v or .webm file. Requires ffmpeg.
+func ReplaceAudio(videoPath, audioPath, outputPath, ffmpegPath string, opts ...Option) error {
+	cVideoPath := C.CString(videoPath)
+	defer C.free(unsafe.Pointer(cVideoPath))
+
+	cAudioPath := C.CString(audioPath)
+	defer C.free(unsafe.Pointer(cAudioPath))
+
+	cOutputPath := C.CString(outputPath)
+	defer C.free(unsafe.Pointer(cOutputPath))
+
+	var cFfmpegPath *C.char
+	if ffmpegPath != "" {
+		cFfmpegPath = C.CString(ffmpegPath)
+		defer C.free(unsafe.Pointer(cFfmpegPath))
+	}
+
+	o := newOptions(opts)
+	params := o.cAudioParams()
+
+	return o.run(Job{Operation: OperationReplaceAudio, Output: outputPath}, func() error {
+		result := C.minmpeg_replace_audio(cVideoPath, cAudioPath, cOutputPath, cFfmpegPath, &params)
+		return resultToError(result)
+	})
+}
+
+// ExtractAudio writes the audio of a video to an audio file. The codec is
+// chosen by the output extension (.mp3, .m4a, .aac, .opus, .ogg or .wav)
+// unless set with WithAudioCodec. Requires ffmpeg.
+func ExtractAudio(inputPath, outputPath, ffmpegPath string, opts ...Option) error {
+	cInputPath := C.CString(inputPath)
+	defer C.free(unsafe.Pointer(cInputPath))
+
+	cOutputPath := C.CString(outputPath)
+	defer C.free(unsafe.Pointer(cOutputPath))
+
+	var cFfmpegPath *C.char
+	if ffmpegPath != "" {
+		cFfmpegPath = C.CString(ffmpegPath)
+		defer C.free(unsafe.Pointer(cFfmpegPath))
+	}
+
+	o := newOptions(opts)
+	params := o.cAudioParams()
+
+	return o.run(Job{Operation: OperationExtractAudio, Output: outputPath}, func() error {
+		result := C.minmpeg_extract_audio(cInputPath, cOutputPath, cFfmpegPath, &params)
+		return resultToError(result)
+	})
+}
+
+// RemoveAudio removes all audio from a video. The video stream is copied
+// when the output container supports its codec and re-encoded otherwise.
+// The output format follows the output extension. Requires ffmpeg.
+func RemoveAudio(inputPath, outputPath, ffmpegPath string) error {
+	cInputPath := C.CString(inputPath)
+	defer C.free(unsafe.Pointer(cInputPath))
+
+	cOutputPath := C.CString(outputPath)
+	defer C.free(unsafe.Pointer(cOutputPath))
+
+	var cFfmpegPath *C.char
+	if ffmpegPath != "" {
+		cFfmpegPath = C.CString(ffmpegPath)
+		defer C.free(unsafe.Pointer(cFfmpegPath))
+	}
+
+	return newOptions(nil).run(Job{Operation: OperationRemoveAudio, Output: outputPath}, func() error {
+		result := C.minmpeg_remove_audio(cInputPath, cOutputPath, cFfmpegPath)
+		return resultToError(result)
+	})
+}
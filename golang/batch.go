@@ -0,0 +1,72 @@
+package minmpeg
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// SlideshowJob is one slideshow of SlideshowBatch, with the arguments of
+// Slideshow
+type SlideshowJob struct {
+	Entries    []SlideEntry
+	OutputPath string
+	Container  Container
+	Codec      Codec
+	Quality    uint8
+	FFmpegPath string
+	Options    []Option
+}
+
+// SlideshowResult is the outcome of a job of SlideshowBatch
+type SlideshowResult struct {
+	OutputPath string
+	Err        error         // nil when the slideshow was written
+	Elapsed    time.Duration // Time the job ran, not counting the wait for a worker
+}
+
+// SlideshowBatch creates the slideshows of jobs on at most concurrency
+// workers at once (0 or less for GOMAXPROCS), so callers need not throttle
+// calls themselves. Jobs start in order as workers free up, and a failed job
+// does not stop the others. The results are in the order of jobs; the error
+// joins the errors of the failed jobs, each naming its index and output path,
+// and is nil when every job succeeded.
+//
+// Each job is a Slideshow call with its own options, so retries, limiters and
+// sessions apply per job; a Session shared by the jobs keeps their encoders
+// warm.
+func SlideshowBatch(jobs []SlideshowJob, concurrency int) ([]SlideshowResult, error) {
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	results := make([]SlideshowResult, len(jobs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(concurrency, len(jobs)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				job := jobs[i]
+				started := time.Now()
+				err := Slideshow(job.Entries, job.OutputPath, job.Container, job.Codec, job.Quality, job.FFmpegPath, job.Options...)
+				results[i] = SlideshowResult{OutputPath: job.OutputPath, Err: err, Elapsed: time.Since(started)}
+			}
+		}()
+	}
+	for i := range jobs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var errs []error
+	for i, result := range results {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("job %d (%s): %w", i, result.OutputPath, result.Err))
+		}
+	}
+	return results, errors.Join(errs...)
+}
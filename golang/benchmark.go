@@ -0,0 +1,127 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"time"
+	"unsafe"
+)
+
+// BenchmarkSpec describes the workload of Benchmark. Zero values select the defaults.
+type BenchmarkSpec struct {
+	Codecs     []Codec       // Codecs to measure, each in its usual container (every available codec when empty)
+	Width      int           // Frame width (1280 when 0)
+	Height     int           // Frame height (720 when 0)
+	Duration   time.Duration // Length of the workload (5 seconds when 0)
+	FPS        int           // Frame rate (DefaultFPS when 0)
+	Quality    uint8         // Quality (1-100, 50 when 0)
+	OutputDir  string        // Directory for the encoded files, removed after measuring (the temp dir when empty)
+	FfmpegPath string        // Path to ffmpeg (PATH when empty)
+}
+
+// BenchmarkResult holds the measurements of one codec. Metrics the platform or
+// ffmpeg cannot provide are -1.
+type BenchmarkResult struct {
+	Codec       Codec
+	Container   Container     // Container the output was written to
+	Err         error         // Error that stopped the encode (the measurements are zero then)
+	Frames      int           // Number of frames encoded
+	EncodeTime  time.Duration // Wall-clock time of the encode
+	EncodeFPS   float64       // Frames encoded per second
+	CPUPercent  float64       // CPU time over wall-clock time in percent of one core
+	OutputBytes uint64        // Size of the output in bytes
+	BitrateKbps int           // Average bitrate of the output in kbit/s
+	PSNR        float64       // PSNR of the RGB channels in dB (100 for identical frames)
+	SSIM        float64       // Mean SSIM of the luma (1.0 for identical frames)
+}
+
+// BenchmarkReport holds the result of Benchmark
+type BenchmarkReport struct {
+	Width    int               // Frame width of the workload
+	Height   int               // Frame height of the workload
+	FPS      int               // Frame rate of the workload
+	Frames   int               // Number of frames of the workload
+	CPUCount int               // Number of CPU cores available to the process
+	Results  []BenchmarkResult // Measurements of each codec, in the order of the spec
+}
+
+// Benchmark encodes a standard workload with each codec and measures encoding
+// speed, CPU use and quality, to qualify a machine type with one call. The
+// workload, a panning gradient with grain and a bouncing checkered square, goes
+// through the same encoders (hardware where available) as Slideshow; the output
+// is decoded with ffmpeg and compared with the workload for PSNR and SSIM. CPU
+// time includes ffmpeg processes, except on Windows.
+//
+// Codecs that fail, such as unavailable ones, are reported in their result's
+// Err; the returned error is for an invalid spec.
+func Benchmark(spec BenchmarkSpec) (*BenchmarkReport, error) {
+	cSpec := C.BenchmarkSpec{
+		width:       C.uint32_t(spec.Width),
+		height:      C.uint32_t(spec.Height),
+		duration_ms: C.uint32_t(spec.Duration.Milliseconds()),
+		fps:         C.uint32_t(spec.FPS),
+		quality:     C.uint8_t(spec.Quality),
+	}
+	if n := len(spec.Codecs); n > 0 {
+		// The codecs live in C memory because the spec points to them
+		codecs := (*C.Codec)(C.calloc(C.size_t(n), C.size_t(unsafe.Sizeof(C.Codec(0)))))
+		defer C.free(unsafe.Pointer(codecs))
+		for i, codec := range spec.Codecs {
+			unsafe.Slice(codecs, n)[i] = C.Codec(codec)
+		}
+		cSpec.codecs = codecs
+		cSpec.codec_count = C.size_t(n)
+	}
+	if spec.OutputDir != "" {
+		cSpec.output_dir = C.CString(spec.OutputDir)
+		defer C.free(unsafe.Pointer(cSpec.output_dir))
+	}
+	if spec.FfmpegPath != "" {
+		cSpec.ffmpeg_path = C.CString(spec.FfmpegPath)
+		defer C.free(unsafe.Pointer(cSpec.ffmpeg_path))
+	}
+
+	var cReport C.BenchmarkReport
+	err := newOptions(nil).run(Job{Operation: OperationBenchmark, Output: spec.OutputDir}, func() error {
+		return resultToError(C.minmpeg_benchmark(&cSpec, &cReport))
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer C.minmpeg_free_benchmark_report(&cReport)
+
+	report := &BenchmarkReport{
+		Width:    int(cReport.width),
+		Height:   int(cReport.height),
+		FPS:      int(cReport.fps),
+		Frames:   int(cReport.frames),
+		CPUCount: int(cReport.cpu_count),
+	}
+	for _, r := range unsafe.Slice(cReport.results, int(cReport.result_count)) {
+		res := BenchmarkResult{
+			Codec:       Codec(r.codec),
+			Container:   Container(r.container),
+			Frames:      int(r.frames),
+			EncodeTime:  time.Duration(r.encode_ms) * time.Millisecond,
+			EncodeFPS:   float64(r.encode_fps),
+			CPUPercent:  float64(r.cpu_percent),
+			OutputBytes: uint64(r.output_bytes),
+			BitrateKbps: int(r.bitrate_kbps),
+			PSNR:        float64(r.psnr),
+			SSIM:        float64(r.ssim),
+		}
+		// The messages are freed with the report
+		if r.error.code != C.MINMPEG_OK {
+			msg := "Unknown error"
+			if r.error.message != nil {
+				msg = C.GoString(r.error.message)
+			}
+			res.Err = libraryError(ErrorCode(r.error.code), msg)
+		}
+		report.Results = append(report.Results, res)
+	}
+	return report, nil
+}
@@ -0,0 +1,78 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+#include <stdlib.h>
+*/
+import "C"
+import "fmt"
+
+// DuplicateMatch represents how slides are compared when looking for duplicates
+type DuplicateMatch int
+
+const (
+	DuplicateMatchExact   DuplicateMatch = C.DUPLICATE_MATCH_EXACT   // Pixel-identical images
+	DuplicateMatchSimilar DuplicateMatch = C.DUPLICATE_MATCH_SIMILAR // Same-size images that differ only by noise
+)
+
+// FindDuplicateSlides returns, for each slide, the index of the slide it is merged
+// into: its own index when it is kept, or the first slide of its run of duplicates.
+// Slides with narration or a different caption are never merged.
+func FindDuplicateSlides(entries []SlideEntry, match DuplicateMatch) ([]int, error) {
+	if len(entries) == 0 {
+		return nil, newError(ErrInvalidInput, "no slides provided")
+	}
+
+	cEntries, freeEntries := cSlideEntries(entries)
+	defer freeEntries()
+
+	cMergedInto := make([]C.size_t, len(entries))
+	result := C.minmpeg_find_duplicate_slides(
+		&cEntries[0],
+		C.size_t(len(entries)),
+		C.DuplicateMatch(match),
+		&cMergedInto[0],
+	)
+	if err := resultToError(result); err != nil {
+		return nil, err
+	}
+
+	mergedInto := make([]int, len(entries))
+	for i, target := range cMergedInto {
+		mergedInto[i] = int(target)
+	}
+	return mergedInto, nil
+}
+
+// DedupeSlides merges identical or near-identical consecutive slides, summing
+// their durations, to shrink decks exported from presentation tools.
+// Each merged run is reported as a warning.
+func DedupeSlides(entries []SlideEntry, match DuplicateMatch) ([]SlideEntry, []string, error) {
+	mergedInto, err := FindDuplicateSlides(entries, match)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var deduped []SlideEntry
+	var firsts, lasts []int
+	for i, target := range mergedInto {
+		if target == i {
+			deduped = append(deduped, entries[i])
+			firsts = append(firsts, i)
+			lasts = append(lasts, i)
+			continue
+		}
+		deduped[len(deduped)-1].DurationMs += entries[i].DurationMs
+		lasts[len(lasts)-1] = i
+	}
+
+	var warnings []string
+	for i, entry := range deduped {
+		if lasts[i] > firsts[i] {
+			warnings = append(warnings, fmt.Sprintf(
+				"Slides %d-%d are duplicates and were merged into one slide of %d ms",
+				firsts[i]+1, lasts[i]+1, entry.DurationMs))
+		}
+	}
+	return deduped, warnings, nil
+}
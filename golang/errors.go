@@ -0,0 +1,117 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+*/
+import "C"
+
+// ErrorCode identifies the kind of an error returned by the library.
+//
+// The values come from the ErrorCode enum of minmpeg.h and are stable across
+// versions: existing codes never change meaning and new codes are only added,
+// so they can be used in alerting rules. Each code is also an error, so
+// errors.Is(err, ErrInvalidInput) checks the kind of an error.
+type ErrorCode int
+
+const (
+	ErrInvalidInput           ErrorCode = C.MINMPEG_ERR_INVALID_INPUT
+	ErrCodecUnavailable       ErrorCode = C.MINMPEG_ERR_CODEC_UNAVAILABLE
+	ErrContainerCodecMismatch ErrorCode = C.MINMPEG_ERR_CONTAINER_CODEC_MISMATCH
+	ErrIO                     ErrorCode = C.MINMPEG_ERR_IO_ERROR
+	ErrEncode                 ErrorCode = C.MINMPEG_ERR_ENCODE_ERROR
+	ErrDecode                 ErrorCode = C.MINMPEG_ERR_DECODE_ERROR
+	ErrInsufficientSpace      ErrorCode = C.MINMPEG_ERR_INSUFFICIENT_SPACE
+	ErrOutputMismatch         ErrorCode = C.MINMPEG_ERR_OUTPUT_MISMATCH
+)
+
+// Error implements the error interface with a generic message for the code
+func (c ErrorCode) Error() string {
+	switch c {
+	case ErrInvalidInput:
+		return "invalid input"
+	case ErrCodecUnavailable:
+		return "codec unavailable"
+	case ErrContainerCodecMismatch:
+		return "container does not support codec"
+	case ErrIO:
+		return "I/O error"
+	case ErrEncode:
+		return "encoding error"
+	case ErrDecode:
+		return "decoding error"
+	case ErrInsufficientSpace:
+		return "insufficient disk space"
+	case ErrOutputMismatch:
+		return "output does not match the job"
+	default:
+		return "unknown error"
+	}
+}
+
+// Error is an error returned by the library, with its code and message
+type Error struct {
+	code          ErrorCode
+	message       string
+	binding       bool     // Whether the binding made the message, which is then localized here
+	language      Language // Language of a message of the binding
+	slideIndex    int
+	slidePath     string
+	ffmpegCommand string
+	ffmpegStderr  string
+}
+
+// newError creates an error of the binding with a code and message in English,
+// translated into the language set by SetLanguage
+func newError(code ErrorCode, message string) *Error {
+	return &Error{
+		code:       code,
+		message:    message,
+		binding:    true,
+		language:   Language(processLanguage.Load()),
+		slideIndex: -1,
+	}
+}
+
+// libraryError creates an error with a code and a message from the library,
+// which is already in the language of the call
+func libraryError(code ErrorCode, message string) *Error {
+	return &Error{code: code, message: message, slideIndex: -1}
+}
+
+// Error returns the message, in the language set by WithLanguage or
+// SetLanguage. Only the kind of error is translated; details stay in English.
+func (e *Error) Error() string {
+	if !e.binding {
+		return e.message
+	}
+	if kind := e.code.kind(e.language); kind != "" {
+		return kind + ": " + e.message
+	}
+	return e.message
+}
+
+// Code returns the stable numeric code of the error (see ErrorCode)
+func (e *Error) Code() int {
+	return int(e.code)
+}
+
+// Slide returns the index and image path of the slide that caused the error, so
+// callers can tell users which upload is broken. ok is false when the error is not
+// about a single slide.
+func (e *Error) Slide() (index int, path string, ok bool) {
+	return e.slideIndex, e.slidePath, e.slideIndex >= 0
+}
+
+// FFmpeg returns the command line and the last lines of stderr of the ffmpeg
+// process that failed, so production failures can be reproduced and logged
+// as structured fields. ok is false when the error is not about a failed
+// ffmpeg run.
+func (e *Error) FFmpeg() (command, stderr string, ok bool) {
+	return e.ffmpegCommand, e.ffmpegStderr, e.ffmpegCommand != ""
+}
+
+// Is reports whether the error has the code target, for errors.Is
+func (e *Error) Is(target error) bool {
+	code, ok := target.(ErrorCode)
+	return ok && code == e.code
+}
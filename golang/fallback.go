@@ -0,0 +1,19 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+*/
+import "C"
+import (
+	"runtime/cgo"
+	"unsafe"
+)
+
+// minmpegFallback passes a fallback to the software encoder to the function
+// of WithHWFallback behind the handle in userData
+//
+//export minmpegFallback
+func minmpegFallback(code C.ErrorCode, message *C.char, userData unsafe.Pointer) {
+	warn := cgo.Handle(uintptr(userData)).Value().(func(err error))
+	warn(libraryError(ErrorCode(code), C.GoString(message)))
+}
@@ -0,0 +1,74 @@
+package minmpeg
+
+/*
+#include <stdlib.h>
+#include "../include/minmpeg.h"
+*/
+import "C"
+import (
+	"slices"
+	"strings"
+	"unsafe"
+)
+
+// FFmpegEnv is the environment variable with the path of ffmpeg used by every
+// function whose ffmpegPath is empty, so deployments configure it once
+// instead of passing it to every call
+const FFmpegEnv = "MINMPEG_FFMPEG"
+
+// SetNativeOnly forbids or allows running ffmpeg. In native-only mode no
+// external process is ever spawned, for environments where exec() is
+// prohibited: functions and codecs that need ffmpeg fail instead, and such
+// codecs are not reported by Available or Capabilities. It applies to all
+// goroutines.
+func SetNativeOnly(enabled bool) {
+	var cEnabled C.int
+	if enabled {
+		cEnabled = 1
+	}
+	C.minmpeg_set_native_only(cEnabled)
+}
+
+// FFmpeg describes an ffmpeg executable found by the library
+type FFmpeg struct {
+	Path     string   // Path of the executable, or its name when found in PATH
+	Version  string   // Version reported by ffmpeg, such as "6.1.1"
+	Encoders []string // Names of the enabled encoders, such as "libvpx-vp9" or "aac"
+}
+
+// HasEncoder reports whether ffmpeg has an encoder by its exact name
+func (f FFmpeg) HasEncoder(name string) bool {
+	return slices.Contains(f.Encoders, name)
+}
+
+// FindFFmpeg returns the path and version of the ffmpeg used by every function
+// whose ffmpegPath is empty: the one at the path of FFmpegEnv when it is set,
+// or else the one found in PATH or common install locations.
+// It fails when none is found, so services can check their setup at startup.
+func FindFFmpeg() (path, version string, err error) {
+	f, err := InspectFFmpeg("")
+	return f.Path, f.Version, err
+}
+
+// InspectFFmpeg reports the version and enabled encoders of the ffmpeg at
+// ffmpegPath, or of the one FindFFmpeg finds when it is empty
+func InspectFFmpeg(ffmpegPath string) (FFmpeg, error) {
+	var cPath *C.char
+	if ffmpegPath != "" {
+		cPath = C.CString(ffmpegPath)
+		defer C.free(unsafe.Pointer(cPath))
+	}
+
+	var info C.FFmpegInfo
+	result := C.minmpeg_find_ffmpeg(cPath, &info)
+	if err := resultToError(result); err != nil {
+		return FFmpeg{}, err
+	}
+	defer C.minmpeg_free_ffmpeg_info(&info)
+
+	return FFmpeg{
+		Path:     C.GoString(info.path),
+		Version:  C.GoString(info.version),
+		Encoders: strings.Fields(C.GoString(info.encoders)),
+	}, nil
+}
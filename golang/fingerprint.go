@@ -0,0 +1,69 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"math/bits"
+	"time"
+	"unsafe"
+)
+
+// FrameHashMatchDistance is the largest number of differing bits between hashes
+// of frames with the same content
+const FrameHashMatchDistance = C.MINMPEG_FRAME_HASH_MATCH_DISTANCE
+
+// FrameHash is the perceptual hash of a sampled frame
+type FrameHash struct {
+	Time time.Duration // Time of the frame
+	Hash uint64        // 64-bit perceptual hash
+}
+
+// Distance returns the number of differing bits between two hashes (0-64)
+func (h FrameHash) Distance(other FrameHash) int {
+	return bits.OnesCount64(h.Hash ^ other.Hash)
+}
+
+// Matches reports whether two frames show the same content
+func (h FrameHash) Matches(other FrameHash) bool {
+	return h.Distance(other) <= FrameHashMatchDistance
+}
+
+// FrameHashes returns perceptual hashes of count frames sampled evenly over an
+// image or video, in time order. The hashes survive re-encoding and scaling, so
+// comparing them detects duplicated content or verifies cache hits without
+// comparing whole files. Images give a single hash; videos are decoded with
+// ffmpeg, and short videos may give fewer hashes.
+func FrameHashes(path string, count int, ffmpegPath string) ([]FrameHash, error) {
+	if count < 1 {
+		return nil, newError(ErrInvalidInput, "frame count must be at least 1")
+	}
+
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	var cFfmpegPath *C.char
+	if ffmpegPath != "" {
+		cFfmpegPath = C.CString(ffmpegPath)
+		defer C.free(unsafe.Pointer(cFfmpegPath))
+	}
+
+	var cHashes *C.FrameHash
+	var cCount C.size_t
+	result := C.minmpeg_frame_hashes(cPath, C.uint32_t(count), cFfmpegPath, &cHashes, &cCount)
+	if err := resultToError(result); err != nil {
+		return nil, err
+	}
+	defer C.minmpeg_free_frame_hashes(cHashes, cCount)
+
+	hashes := make([]FrameHash, 0, int(cCount))
+	for _, h := range unsafe.Slice(cHashes, int(cCount)) {
+		hashes = append(hashes, FrameHash{
+			Time: time.Duration(h.time_ms) * time.Millisecond,
+			Hash: uint64(h.hash),
+		})
+	}
+	return hashes, nil
+}
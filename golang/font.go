@@ -0,0 +1,59 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+#include <stdint.h>
+#include <stdlib.h>
+
+// Exported by font_names.go
+extern void minmpegFontName(char* name, void* user_data);
+
+static NameCallback font_name_callback(void) {
+	return (NameCallback)minmpegFontName;
+}
+
+static void* font_names_user_data(uintptr_t handle) {
+	return (void*)handle;
+}
+*/
+import "C"
+import (
+	"runtime/cgo"
+	"unsafe"
+)
+
+// RegisterFont registers TrueType/OpenType font data under the given name.
+// Registered fonts can be selected for captions with WithFont.
+func RegisterFont(name string, data []byte) error {
+	if name == "" {
+		return newError(ErrInvalidInput, "font name is empty")
+	}
+	if len(data) == 0 {
+		return newError(ErrInvalidInput, "no font data provided")
+	}
+
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+
+	cData := C.CBytes(data)
+	defer C.free(cData)
+
+	result := C.minmpeg_register_font(cName, (*C.uint8_t)(cData), C.size_t(len(data)))
+	return resultToError(result)
+}
+
+// SystemFonts lists the fonts installed on the system by the names WithFont
+// finds them under: their file names without the extension, such as
+// "DejaVuSans" for DejaVuSans.ttf. Family names stored inside the fonts are not
+// read. The names are sorted.
+func SystemFonts() ([]string, error) {
+	var names []string
+	handle := cgo.NewHandle(&names)
+	defer handle.Delete()
+
+	result := C.minmpeg_system_fonts(C.font_name_callback(), C.font_names_user_data(C.uintptr_t(handle)))
+	if err := resultToError(result); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
@@ -0,0 +1,19 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+*/
+import "C"
+import (
+	"runtime/cgo"
+	"unsafe"
+)
+
+// minmpegFontName appends a font name to the list of SystemFonts behind the
+// handle in userData
+//
+//export minmpegFontName
+func minmpegFontName(name *C.char, userData unsafe.Pointer) {
+	names := cgo.Handle(uintptr(userData)).Value().(*[]string)
+	*names = append(*names, C.GoString(name))
+}
@@ -0,0 +1,102 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+*/
+import "C"
+import "sync/atomic"
+
+// Language represents languages of error messages
+type Language int
+
+const (
+	LanguageEnglish  Language = C.LANGUAGE_ENGLISH
+	LanguageJapanese Language = C.LANGUAGE_JAPANESE // The kind of error is translated; details stay in English
+)
+
+// processLanguage is the language set with SetLanguage
+var processLanguage atomic.Int32
+
+// SetLanguage sets the language of error messages, both of the library and of
+// this binding. It applies to all goroutines, except calls given WithLanguage.
+// Error codes do not change with the language, so programs should not depend
+// on the message text.
+func SetLanguage(language Language) {
+	processLanguage.Store(int32(language))
+	C.minmpeg_set_language(C.Language(language))
+}
+
+// WithLanguage sets the language of the error messages of the call, and of
+// the later calls of a VideoWriter, instead of the one set with SetLanguage
+func WithLanguage(language Language) Option {
+	return func(o *options) {
+		o.language = language
+		o.languageSet = true
+	}
+}
+
+// messageLanguage returns the language of the error messages of the call
+func (o *options) messageLanguage() Language {
+	if o.languageSet {
+		return o.language
+	}
+	return Language(processLanguage.Load())
+}
+
+// cMessageLanguage converts the language of the call to C, leaving it to the
+// library when not set
+func (o *options) cMessageLanguage() C.MessageLanguage {
+	if !o.languageSet {
+		return C.MESSAGE_LANGUAGE_AUTO
+	}
+	switch o.language {
+	case LanguageJapanese:
+		return C.MESSAGE_LANGUAGE_JAPANESE
+	default:
+		return C.MESSAGE_LANGUAGE_ENGLISH
+	}
+}
+
+// newError creates an error of the binding in the language of the call
+func (o *options) newError(code ErrorCode, message string) *Error {
+	err := newError(code, message)
+	err.language = o.messageLanguage()
+	return err
+}
+
+// localize puts an error the binding created in the language of the call
+func (o *options) localize(err error) error {
+	if e, ok := err.(*Error); ok && e.binding {
+		e.language = o.messageLanguage()
+	}
+	return err
+}
+
+// kind returns the kind of error of the code in language, which is put before
+// the messages of the binding as the library does, or "" to leave them as they
+// are
+func (c ErrorCode) kind(language Language) string {
+	if language != LanguageJapanese {
+		return ""
+	}
+	switch c {
+	case ErrInvalidInput:
+		return "入力が不正です"
+	case ErrCodecUnavailable:
+		return "コーデックを利用できません"
+	case ErrContainerCodecMismatch:
+		return "コンテナとコーデックの組み合わせに対応していません"
+	case ErrIO:
+		return "入出力エラー"
+	case ErrEncode:
+		return "エンコードエラー"
+	case ErrDecode:
+		return "デコードエラー"
+	case ErrInsufficientSpace:
+		return "ディスク容量が不足しています"
+	case ErrOutputMismatch:
+		return "出力がジョブと一致しません"
+	default:
+		return ""
+	}
+}
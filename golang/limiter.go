@@ -0,0 +1,149 @@
+package minmpeg
+
+import (
+	"context"
+	"sync"
+)
+
+// Operation names the call a Job belongs to
+type Operation string
+
+const (
+	OperationSlideshow                Operation = "slideshow"
+	OperationSlideshowWithinSize      Operation = "slideshow_within_size"
+	OperationSlideshowSkippingInvalid Operation = "slideshow_skipping_invalid"
+	OperationSlideshowStream          Operation = "slideshow_stream"
+	OperationPreviewSlideshow         Operation = "preview_slideshow"
+	OperationJuxtapose                Operation = "juxtapose"
+	OperationVisualizeAudio           Operation = "visualize_audio"
+	OperationImageWithAudio           Operation = "image_with_audio"
+	OperationReplaceAudio             Operation = "replace_audio"
+	OperationExtractAudio             Operation = "extract_audio"
+	OperationRemoveAudio              Operation = "remove_audio"
+	OperationBenchmark                Operation = "benchmark"
+	OperationVideoWriter              Operation = "video_writer"
+)
+
+// Job describes a call asking a Limiter to start
+type Job struct {
+	Operation Operation
+	Output    string    // Output path (the output directory for Benchmark)
+	Codec     Codec     // Video codec of calls that encode video (zero for audio operations, PreviewSlideshow and Benchmark)
+	Container Container // Container of calls that encode video (zero for audio operations, PreviewSlideshow and Benchmark)
+}
+
+// Limiter admits calls into the library, so host applications can fit
+// minmpeg into their own resource governors and admission control, such as a
+// process-wide cap on concurrent encodes or a CPU budget shared with other
+// work.
+//
+// Acquire is called before each attempt of a call and blocks until the job
+// may start, or returns an error to refuse it; the call then returns that
+// error without running. Release is called once the attempt ends, successful
+// or not, and never after a failed Acquire. Retries (WithRetry) release
+// between attempts, so waiting retries hold no slot. Both may be called from
+// many goroutines at once.
+type Limiter interface {
+	Acquire(ctx context.Context, job Job) error
+	Release(job Job)
+}
+
+var (
+	defaultLimiterMu sync.RWMutex
+	defaultLimiter   Limiter
+)
+
+// SetLimiter sets the Limiter consulted by every call without WithLimiter,
+// including RemoveAudio and Benchmark (nil for none, the default). It applies
+// to all goroutines.
+func SetLimiter(limiter Limiter) {
+	defaultLimiterMu.Lock()
+	defer defaultLimiterMu.Unlock()
+	defaultLimiter = limiter
+}
+
+// WithLimiter consults limiter before the call instead of the one set with
+// SetLimiter (nil to run the call without a limiter)
+func WithLimiter(limiter Limiter) Option {
+	return func(o *options) {
+		o.limiter = limiter
+		o.limiterSet = true
+	}
+}
+
+// WithContext sets the context passed to the Limiter, so a caller waiting for
+// admission or for the next attempt of WithRetry can give up when it is
+// cancelled. Encoding itself is not interrupted once started.
+func WithContext(ctx context.Context) Option {
+	return func(o *options) {
+		o.ctx = ctx
+	}
+}
+
+// run makes a call for job under the limiter, retrying it by the retry
+// policy and recording each attempt in the metrics
+func (o *options) run(job Job, call func() error) error {
+	return o.localize(o.retry.run(o.context(), func() error {
+		release, err := o.acquire(job)
+		if err != nil {
+			return err
+		}
+		defer release()
+		finish := o.measure(job)
+		err = call()
+		finish(err)
+		return err
+	}))
+}
+
+// context returns the context set with WithContext, or the background context
+func (o *options) context() context.Context {
+	if o.ctx == nil {
+		return context.Background()
+	}
+	return o.ctx
+}
+
+// acquire asks the limiter to admit job, returning the function releasing it
+func (o *options) acquire(job Job) (func(), error) {
+	limiter := o.limiter
+	if !o.limiterSet {
+		defaultLimiterMu.RLock()
+		limiter = defaultLimiter
+		defaultLimiterMu.RUnlock()
+	}
+	if limiter == nil {
+		return func() {}, nil
+	}
+	if err := limiter.Acquire(o.context(), job); err != nil {
+		return nil, err
+	}
+	return func() { limiter.Release(job) }, nil
+}
+
+// JobLimiter is a Limiter running at most a fixed number of calls at once,
+// admitting waiting calls as slots free up
+type JobLimiter struct {
+	slots chan struct{}
+}
+
+// NewJobLimiter creates a JobLimiter allowing maxJobs calls at once (at least 1)
+func NewJobLimiter(maxJobs int) *JobLimiter {
+	return &JobLimiter{slots: make(chan struct{}, max(maxJobs, 1))}
+}
+
+// Acquire waits for a free slot, or returns the context's error when it is
+// done first
+func (l *JobLimiter) Acquire(ctx context.Context, job Job) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees the slot of a job
+func (l *JobLimiter) Release(job Job) {
+	<-l.slots
+}
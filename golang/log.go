@@ -0,0 +1,81 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+*/
+import "C"
+import (
+	"context"
+	"log/slog"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// logTarget is the logger of WithLogger with the context of the call, and
+// the encoder choice recorded for Metrics
+type logTarget struct {
+	logger  *slog.Logger // nil without WithLogger
+	ctx     context.Context
+	encoder *encoderChoice // nil without Metrics
+}
+
+// WithLogger sends structured events of the call to logger: "stage started"
+// with a "stage" attribute (load, encode or mux), "encoder chosen" with the
+// "codec" and "encoder" used and "hardware" (1 for a hardware encoder),
+// "falling back to the software encoder" and "slide skipped" warnings, and
+// "job finished" with "elapsed_ms". Messages are fixed for each kind of event,
+// so they can be matched in log queries.
+// Records carry the context of WithContext. The logger may be called from
+// another goroutine.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// minmpegLog passes a log event to the logger of WithLogger behind the handle
+// in userData
+//
+//export minmpegLog
+func minmpegLog(level C.LogLevel, message *C.char, attrs *C.LogAttr, attrCount C.size_t, userData unsafe.Pointer) {
+	target := cgo.Handle(uintptr(userData)).Value().(logTarget)
+	if target.encoder != nil && C.GoString(message) == "encoder chosen" {
+		var name string
+		var hardware bool
+		for _, attr := range unsafe.Slice(attrs, int(attrCount)) {
+			switch C.GoString(attr.key) {
+			case "encoder":
+				name = C.GoString(attr.text)
+			case "hardware":
+				hardware = attr.number != 0
+			}
+		}
+		target.encoder.set(name, hardware)
+	}
+	if target.logger == nil {
+		return
+	}
+
+	slogAttrs := make([]slog.Attr, 0, int(attrCount))
+	for _, attr := range unsafe.Slice(attrs, int(attrCount)) {
+		key := C.GoString(attr.key)
+		if attr.text != nil {
+			slogAttrs = append(slogAttrs, slog.String(key, C.GoString(attr.text)))
+		} else {
+			slogAttrs = append(slogAttrs, slog.Int64(key, int64(attr.number)))
+		}
+	}
+	target.logger.LogAttrs(target.ctx, slogLevel(level), C.GoString(message), slogAttrs...)
+}
+
+// slogLevel converts a level of the library to its slog level
+func slogLevel(level C.LogLevel) slog.Level {
+	switch level {
+	case C.LOG_LEVEL_DEBUG:
+		return slog.LevelDebug
+	case C.LOG_LEVEL_WARN:
+		return slog.LevelWarn
+	default:
+		return slog.LevelInfo
+	}
+}
@@ -0,0 +1,177 @@
+package minmpeg
+
+import (
+	"expvar"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Metrics records calls into the library, so services can chart encoder
+// health, such as with Prometheus counters and histograms or expvar (see
+// ExpvarMetrics).
+//
+// JobStarted is called when an attempt of a call starts, after the Limiter
+// admitted it, and JobFinished once it ends, successful or not; retries
+// (WithRetry) are separate attempts. A VideoWriter is one attempt from its
+// opening to Close or Discard. Both may be called from many goroutines at
+// once.
+type Metrics interface {
+	JobStarted(job Job)
+	JobFinished(job Job, stats JobStats)
+}
+
+// JobStats describes a finished attempt of a call
+type JobStats struct {
+	Duration    time.Duration // Wall-clock time of the attempt
+	OutputBytes int64         // Size of the output file (0 on failure, and for outputs of several files)
+	Encoder     string        // Video encoder used last, such as "libx264" or "hevc_nvenc" (empty for calls without video encoding)
+	Hardware    bool          // Whether Encoder runs on a GPU or media engine
+	Err         error         // Error of the attempt, nil on success
+}
+
+var (
+	defaultMetricsMu sync.RWMutex
+	defaultMetrics   Metrics
+)
+
+// SetMetrics sets the Metrics recording every call without WithMetrics (nil
+// for none, the default). It applies to all goroutines.
+func SetMetrics(metrics Metrics) {
+	defaultMetricsMu.Lock()
+	defer defaultMetricsMu.Unlock()
+	defaultMetrics = metrics
+}
+
+// WithMetrics records the call in metrics instead of the one set with
+// SetMetrics (nil to record nothing)
+func WithMetrics(metrics Metrics) Option {
+	return func(o *options) {
+		o.metrics = metrics
+		o.metricsSet = true
+	}
+}
+
+// encoderChoice is the last video encoder chosen by a call, taken from its
+// "encoder chosen" log events
+type encoderChoice struct {
+	mu       sync.Mutex
+	name     string
+	hardware bool
+}
+
+// set records the encoder chosen
+func (c *encoderChoice) set(name string, hardware bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.name, c.hardware = name, hardware
+}
+
+// get returns the encoder chosen and clears it for the next attempt
+func (c *encoderChoice) get() (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name, hardware := c.name, c.hardware
+	c.name, c.hardware = "", false
+	return name, hardware
+}
+
+// recordedMetrics returns the Metrics of the call, or nil for none
+func (o *options) recordedMetrics() Metrics {
+	if o.metricsSet {
+		return o.metrics
+	}
+	defaultMetricsMu.RLock()
+	defer defaultMetricsMu.RUnlock()
+	return defaultMetrics
+}
+
+// measure starts an attempt of job in the metrics, returning the function
+// finishing it with the error of the attempt
+func (o *options) measure(job Job) func(err error) {
+	metrics := o.recordedMetrics()
+	if metrics == nil {
+		return func(error) {}
+	}
+	metrics.JobStarted(job)
+	started := time.Now()
+	return func(err error) {
+		stats := JobStats{Duration: time.Since(started), Err: err}
+		if o.encoder != nil {
+			stats.Encoder, stats.Hardware = o.encoder.get()
+		}
+		if err == nil {
+			if info, statErr := os.Stat(job.Output); statErr == nil && info.Mode().IsRegular() {
+				stats.OutputBytes = info.Size()
+			}
+		}
+		metrics.JobFinished(job, stats)
+	}
+}
+
+// ExpvarDurationBuckets are the upper bounds in seconds of the duration
+// histogram of ExpvarMetrics
+var ExpvarDurationBuckets = []float64{1, 5, 15, 60, 300, 900}
+
+// ExpvarMetrics is a Metrics publishing counters with expvar, served as JSON
+// at /debug/vars by net/http: "started", "succeeded" and "failed" attempts,
+// "output_bytes" written, "hardware" and "software" encodes, attempts by
+// "encoder" and by "operation", and "duration_seconds", a cumulative
+// histogram of attempt durations with a bucket for each of
+// ExpvarDurationBuckets ("le_5" counting attempts up to 5 s) and "le_inf",
+// beside the total "sum".
+type ExpvarMetrics struct {
+	vars       *expvar.Map
+	operations *expvar.Map
+	encoders   *expvar.Map
+	durations  *expvar.Map
+}
+
+// NewExpvarMetrics publishes the counters of calls under name, such as
+// "minmpeg". Like expvar.Publish, it panics if the name is already in use.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	m := &ExpvarMetrics{
+		vars:       expvar.NewMap(name),
+		operations: new(expvar.Map),
+		encoders:   new(expvar.Map),
+		durations:  new(expvar.Map),
+	}
+	m.vars.Set("operation", m.operations)
+	m.vars.Set("encoder", m.encoders)
+	m.vars.Set("duration_seconds", m.durations)
+	return m
+}
+
+// JobStarted counts a started attempt
+func (m *ExpvarMetrics) JobStarted(job Job) {
+	m.vars.Add("started", 1)
+}
+
+// JobFinished counts a finished attempt, its output and its duration
+func (m *ExpvarMetrics) JobFinished(job Job, stats JobStats) {
+	if stats.Err != nil {
+		m.vars.Add("failed", 1)
+	} else {
+		m.vars.Add("succeeded", 1)
+		m.vars.Add("output_bytes", stats.OutputBytes)
+	}
+	m.operations.Add(string(job.Operation), 1)
+	if stats.Encoder != "" {
+		m.encoders.Add(stats.Encoder, 1)
+		if stats.Hardware {
+			m.vars.Add("hardware", 1)
+		} else {
+			m.vars.Add("software", 1)
+		}
+	}
+
+	seconds := stats.Duration.Seconds()
+	for _, bound := range ExpvarDurationBuckets {
+		if seconds <= bound {
+			m.durations.Add("le_"+strconv.FormatFloat(bound, 'f', -1, 64), 1)
+		}
+	}
+	m.durations.Add("le_inf", 1)
+	m.durations.AddFloat("sum", seconds)
+}
@@ -6,11 +6,24 @@ package minmpeg
 #cgo darwin LDFLAGS: -framework VideoToolbox -framework CoreMedia -framework CoreVideo -framework CoreFoundation -framework Security
 
 #include "../include/minmpeg.h"
+#include <stdint.h>
 #include <stdlib.h>
+
+// Exported by slide_source.go
+extern int minmpegSlideSource(SlideEntry* entry, void* user_data);
+
+static SlideSource slide_source(void) {
+	return (SlideSource)minmpegSlideSource;
+}
+
+static void* slide_source_user_data(uintptr_t handle) {
+	return (void*)handle;
+}
 */
 import "C"
 import (
-	"errors"
+	"runtime/cgo"
+	"time"
 	"unsafe"
 )
 
@@ -20,14 +33,326 @@ type Container int
 const (
 	ContainerMP4  Container = C.CONTAINER_MP4
 	ContainerWebM Container = C.CONTAINER_WEBM
+	ContainerAVIF Container = C.CONTAINER_AVIF
+	ContainerAPNG Container = C.CONTAINER_APNG
+	ContainerMOV  Container = C.CONTAINER_MOV  // ProRes, H.264 or HEVC
+	ContainerMKV  Container = C.CONTAINER_MKV  // Every codec except PNG, for archives
+	ContainerHLS  Container = C.CONTAINER_HLS  // HLS playlist and segments; H.264, HEVC, or AV1 in fMP4
+	ContainerDASH Container = C.CONTAINER_DASH // MPEG-DASH manifest and fMP4 segments; H.264, HEVC or AV1
 )
 
 // Codec represents video codecs
 type Codec int
 
 const (
-	CodecAV1  Codec = C.CODEC_AV1
-	CodecH264 Codec = C.CODEC_H264
+	CodecAV1    Codec = C.CODEC_AV1
+	CodecH264   Codec = C.CODEC_H264
+	CodecPNG    Codec = C.CODEC_PNG
+	CodecVP9    Codec = C.CODEC_VP9    // WebM or MKV; requires ffmpeg with libvpx-vp9
+	CodecHEVC   Codec = C.CODEC_HEVC   // MP4, MOV or MKV; requires ffmpeg with a hardware HEVC encoder or libx265
+	CodecVP8    Codec = C.CODEC_VP8    // WebM or MKV; requires ffmpeg with libvpx, for older browsers
+	CodecProRes Codec = C.CODEC_PRORES // MOV or MKV; requires ffmpeg with prores_ks
+)
+
+// WritingMode represents caption writing modes
+type WritingMode int
+
+const (
+	WritingModeHorizontal WritingMode = C.WRITING_MODE_HORIZONTAL
+	WritingModeVertical   WritingMode = C.WRITING_MODE_VERTICAL
+)
+
+// TextAlign represents caption line alignments
+type TextAlign int
+
+const (
+	TextAlignCenter TextAlign = C.TEXT_ALIGN_CENTER
+	TextAlignStart  TextAlign = C.TEXT_ALIGN_START
+	TextAlignEnd    TextAlign = C.TEXT_ALIGN_END
+)
+
+// Anchor represents overlay positions inside the safe area
+type Anchor int
+
+const (
+	AnchorAuto         Anchor = C.ANCHOR_AUTO
+	AnchorTopLeft      Anchor = C.ANCHOR_TOP_LEFT
+	AnchorTopCenter    Anchor = C.ANCHOR_TOP_CENTER
+	AnchorTopRight     Anchor = C.ANCHOR_TOP_RIGHT
+	AnchorMiddleLeft   Anchor = C.ANCHOR_MIDDLE_LEFT
+	AnchorCenter       Anchor = C.ANCHOR_CENTER
+	AnchorMiddleRight  Anchor = C.ANCHOR_MIDDLE_RIGHT
+	AnchorBottomLeft   Anchor = C.ANCHOR_BOTTOM_LEFT
+	AnchorBottomCenter Anchor = C.ANCHOR_BOTTOM_CENTER
+	AnchorBottomRight  Anchor = C.ANCHOR_BOTTOM_RIGHT
+)
+
+// TextFit represents how captions are fitted into the frame
+type TextFit int
+
+const (
+	TextFitWrap       TextFit = C.TEXT_FIT_WRAP
+	TextFitShrink     TextFit = C.TEXT_FIT_SHRINK
+	TextFitWrapShrink TextFit = C.TEXT_FIT_WRAP_SHRINK
+)
+
+// DecodeMode represents how input videos are decoded
+type DecodeMode int
+
+const (
+	DecodeModeAuto     DecodeMode = C.DECODE_MODE_AUTO     // Hardware decoding when available
+	DecodeModeSoftware DecodeMode = C.DECODE_MODE_SOFTWARE // Always decode in software
+)
+
+// HWAccel represents the encoder implementation of H.264, HEVC and AV1
+type HWAccel int
+
+const (
+	HWAccelAuto         HWAccel = C.HW_ACCEL_AUTO         // The platform's choice; HEVC tries hardware encoders, then libx265; AV1 uses the AV1 backend
+	HWAccelSoftware     HWAccel = C.HW_ACCEL_SOFTWARE     // Always software, whose output does not depend on the GPU (the AV1 backend for AV1)
+	HWAccelVideoToolbox HWAccel = C.HW_ACCEL_VIDEOTOOLBOX // Apple VideoToolbox hardware encoder (macOS)
+	HWAccelNVENC        HWAccel = C.HW_ACCEL_NVENC        // NVIDIA NVENC
+	HWAccelQSV          HWAccel = C.HW_ACCEL_QSV          // Intel Quick Sync Video
+	HWAccelVAAPI        HWAccel = C.HW_ACCEL_VAAPI        // VA-API (Linux)
+	HWAccelAMF          HWAccel = C.HW_ACCEL_AMF          // AMD AMF
+)
+
+// HWFallback represents what happens when a hardware encoder of H.264, HEVC or
+// AV1 is unavailable or fails
+type HWFallback int
+
+const (
+	HWFallbackFail     HWFallback = C.HW_FALLBACK_FAIL     // Fail with the error of the hardware encoder
+	HWFallbackSoftware HWFallback = C.HW_FALLBACK_SOFTWARE // Encode again from the start with the software encoder
+)
+
+// AV1Backend represents the software encoder of AV1 video
+type AV1Backend int
+
+const (
+	AV1BackendRav1e  AV1Backend = C.AV1_BACKEND_RAV1E   // rav1e, built into the library
+	AV1BackendSVTAV1 AV1Backend = C.AV1_BACKEND_SVT_AV1 // SVT-AV1 through ffmpeg (libsvtav1); much faster
+	AV1BackendAOM    AV1Backend = C.AV1_BACKEND_AOM     // libaom through ffmpeg (libaom-av1); slowest, best compression
+)
+
+// H264Profile represents the profile of H.264 video
+type H264Profile int
+
+const (
+	H264ProfileAuto     H264Profile = C.H264_PROFILE_AUTO     // The encoder's choice (High for libx264, Main for VideoToolbox)
+	H264ProfileBaseline H264Profile = C.H264_PROFILE_BASELINE // Constrained Baseline, for the oldest hardware decoders
+	H264ProfileMain     H264Profile = C.H264_PROFILE_MAIN     // Main profile
+	H264ProfileHigh     H264Profile = C.H264_PROFILE_HIGH     // High profile, compressing best
+)
+
+// H264Preset represents the speed preset of libx264
+type H264Preset int
+
+const (
+	H264PresetAuto      H264Preset = C.H264_PRESET_AUTO // Picked by the time budget
+	H264PresetUltrafast H264Preset = C.H264_PRESET_ULTRAFAST
+	H264PresetSuperfast H264Preset = C.H264_PRESET_SUPERFAST
+	H264PresetVeryfast  H264Preset = C.H264_PRESET_VERYFAST
+	H264PresetFaster    H264Preset = C.H264_PRESET_FASTER
+	H264PresetFast      H264Preset = C.H264_PRESET_FAST
+	H264PresetMedium    H264Preset = C.H264_PRESET_MEDIUM
+	H264PresetSlow      H264Preset = C.H264_PRESET_SLOW
+	H264PresetSlower    H264Preset = C.H264_PRESET_SLOWER
+	H264PresetVeryslow  H264Preset = C.H264_PRESET_VERYSLOW
+)
+
+// H264Tune represents the tuning of libx264 for the kind of content
+type H264Tune int
+
+const (
+	H264TuneNone       H264Tune = C.H264_TUNE_NONE       // No tuning
+	H264TuneStillImage H264Tune = C.H264_TUNE_STILLIMAGE // Still images, as in slideshows
+	H264TuneAnimation  H264Tune = C.H264_TUNE_ANIMATION  // Animation with flat areas, as in screen recordings
+)
+
+// Priority represents the CPU and I/O priority of encoding work
+type Priority int
+
+const (
+	PriorityNormal Priority = C.PRIORITY_NORMAL // Priority of the calling thread
+	PriorityLow    Priority = C.PRIORITY_LOW    // Nice 10 and the lowest best-effort I/O priority on Linux
+	PriorityIdle   Priority = C.PRIORITY_IDLE   // Nice 19 and I/O only when the disk is idle on Linux
+)
+
+// OutputCheck represents whether the output is checked after rendering
+type OutputCheck int
+
+const (
+	OutputCheckNone  OutputCheck = C.OUTPUT_CHECK_NONE  // Trust the output
+	OutputCheckProbe OutputCheck = C.OUTPUT_CHECK_PROBE // Probe the output with ffprobe and compare it with the job
+)
+
+// Reproducibility represents whether output is byte-identical for the same
+// inputs and options
+type Reproducibility int
+
+const (
+	ReproducibilityAny           Reproducibility = C.REPRODUCIBILITY_ANY           // Output may differ between hosts and runs
+	ReproducibilityDeterministic Reproducibility = C.REPRODUCIBILITY_DETERMINISTIC // The same bytes on any host
+)
+
+// JuxtaposeLayout represents how Juxtapose arranges its two videos
+type JuxtaposeLayout int
+
+const (
+	JuxtaposeLayoutHorizontal JuxtaposeLayout = C.JUXTAPOSE_LAYOUT_HORIZONTAL // Side by side, the left video first
+	JuxtaposeLayoutVertical   JuxtaposeLayout = C.JUXTAPOSE_LAYOUT_VERTICAL   // Stacked, the left video on top
+	JuxtaposeLayoutAuto       JuxtaposeLayout = C.JUXTAPOSE_LAYOUT_AUTO       // Side by side for portrait videos, stacked for landscape ones
+)
+
+// SlideFit represents how slides with a different aspect ratio are fitted to the output size
+type SlideFit int
+
+const (
+	SlideFitStretch SlideFit = C.SLIDE_FIT_STRETCH // Resize, ignoring the aspect ratio
+	SlideFitCover   SlideFit = C.SLIDE_FIT_COVER   // Fill the frame, cropping the overflow
+	SlideFitContain SlideFit = C.SLIDE_FIT_CONTAIN // Fit inside the frame, padding with black
+)
+
+// CropFocus represents where cover-cropped slides keep their content
+type CropFocus int
+
+const (
+	CropFocusCenter  CropFocus = C.CROP_FOCUS_CENTER  // Keep the center of the image
+	CropFocusSalient CropFocus = C.CROP_FOCUS_SALIENT // Keep faces and detailed regions in frame
+)
+
+// IdleFrames represents how frames that repeat the previous frame are encoded
+type IdleFrames int
+
+const (
+	IdleFramesKeep  IdleFrames = C.IDLE_FRAMES_KEEP  // Encode every frame
+	IdleFramesMerge IdleFrames = C.IDLE_FRAMES_MERGE // Encode a run of identical frames once
+)
+
+// Compression represents whether encoding may lose detail
+type Compression int
+
+const (
+	CompressionLossy    Compression = C.COMPRESSION_LOSSY    // Lossy compression at the requested quality
+	CompressionLossless Compression = C.COMPRESSION_LOSSLESS // Lossless YUV 4:4:4 (AV1, H.264 on Linux, VP9 and PNG)
+)
+
+// Transparency represents what happens to transparent pixels of slides
+type Transparency int
+
+const (
+	TransparencyOpaque Transparency = C.TRANSPARENCY_OPAQUE // Drop the alpha channel
+	TransparencyKeep   Transparency = C.TRANSPARENCY_KEEP   // Keep the alpha channel (VP8/VP9 in WebM, PNG in APNG)
+)
+
+// PixelFormat represents the chroma resolution of the encoded video
+type PixelFormat int
+
+const (
+	PixelFormatAuto   PixelFormat = C.PIXEL_FORMAT_AUTO   // The codec's usual format (4:2:0; 4:4:4 when lossless, 4:2:2 for ProRes)
+	PixelFormatYUV420 PixelFormat = C.PIXEL_FORMAT_YUV420 // Chroma at half the width and height (yuv420p)
+	PixelFormatYUV422 PixelFormat = C.PIXEL_FORMAT_YUV422 // Chroma at half the width (AV1, H.264 on Linux, HEVC, VP9, ProRes)
+	PixelFormatYUV444 PixelFormat = C.PIXEL_FORMAT_YUV444 // Full chroma resolution (AV1, H.264 on Linux, HEVC, VP9, ProRes 4444)
+)
+
+// BitDepth represents the bits per sample of the encoded video
+type BitDepth int
+
+const (
+	BitDepth8  BitDepth = C.BIT_DEPTH_8  // 8 bits
+	BitDepth10 BitDepth = C.BIT_DEPTH_10 // 10 bits, avoiding banding in gradients (AV1, H.264 on Linux, HEVC, VP9)
+)
+
+// DynamicRange represents the dynamic range of the encoded video
+type DynamicRange int
+
+const (
+	DynamicRangeSDR   DynamicRange = C.DYNAMIC_RANGE_SDR   // Standard dynamic range (BT.709); HDR inputs are tone mapped
+	DynamicRangeHDR10 DynamicRange = C.DYNAMIC_RANGE_HDR10 // HDR10: PQ and BT.2020 with mastering display metadata (AV1, VP9, HEVC at 10 bits)
+)
+
+// ColorPrimaries represents the color primaries tagged on the encoded video
+type ColorPrimaries int
+
+const (
+	ColorPrimariesAuto      ColorPrimaries = C.COLOR_PRIMARIES_AUTO       // BT.709 (sRGB), or BT.2020 for HDR10
+	ColorPrimariesBT709     ColorPrimaries = C.COLOR_PRIMARIES_BT709      // BT.709, shared with sRGB
+	ColorPrimariesBT601     ColorPrimaries = C.COLOR_PRIMARIES_BT601      // BT.601 525-line (SMPTE 170M)
+	ColorPrimariesBT2020    ColorPrimaries = C.COLOR_PRIMARIES_BT2020     // BT.2020
+	ColorPrimariesDisplayP3 ColorPrimaries = C.COLOR_PRIMARIES_DISPLAY_P3 // Display P3, as photos from recent phones use (not H.264 on Windows)
+)
+
+// ColorTransfer represents the transfer characteristics tagged on the encoded video
+type ColorTransfer int
+
+const (
+	ColorTransferAuto  ColorTransfer = C.COLOR_TRANSFER_AUTO  // BT.709 for video, sRGB for AVIF, or PQ for HDR10
+	ColorTransferBT709 ColorTransfer = C.COLOR_TRANSFER_BT709 // BT.709, as video is usually tagged
+	ColorTransferSRGB  ColorTransfer = C.COLOR_TRANSFER_SRGB  // sRGB (IEC 61966-2-1), as images are usually tagged
+)
+
+// ColorMatrix represents the matrix converting the frames to YUV
+type ColorMatrix int
+
+const (
+	ColorMatrixAuto   ColorMatrix = C.COLOR_MATRIX_AUTO   // BT.709 for video, BT.601 for AVIF, or BT.2020 for HDR10
+	ColorMatrixBT709  ColorMatrix = C.COLOR_MATRIX_BT709  // BT.709
+	ColorMatrixBT601  ColorMatrix = C.COLOR_MATRIX_BT601  // BT.601, for older players that ignore the tags of SD video
+	ColorMatrixBT2020 ColorMatrix = C.COLOR_MATRIX_BT2020 // BT.2020 non-constant luminance
+)
+
+// ColorRange represents the range of the YUV samples of the encoded video
+type ColorRange int
+
+const (
+	ColorRangeAuto    ColorRange = C.COLOR_RANGE_AUTO    // Limited for video, full for AVIF
+	ColorRangeLimited ColorRange = C.COLOR_RANGE_LIMITED // Limited (studio) range, 16-235 at 8 bits
+	ColorRangeFull    ColorRange = C.COLOR_RANGE_FULL    // Full range, 0-255 at 8 bits (not ProRes, nor H.264 on macOS)
+)
+
+// HLSSegment represents the segment format of HLS output
+type HLSSegment int
+
+const (
+	HLSSegmentTS   HLSSegment = C.HLS_SEGMENT_TS   // MPEG-TS segments (.ts)
+	HLSSegmentFMP4 HLSSegment = C.HLS_SEGMENT_FMP4 // Fragmented MP4 segments (.m4s) with an initialization segment
+)
+
+// Passes represents the number of encoding passes
+type Passes int
+
+const (
+	PassesOne Passes = C.PASSES_ONE // A single pass
+	PassesTwo Passes = C.PASSES_TWO // Statistics pass and encoding pass, for consistent quality (VP9/VP8, slideshow only)
+)
+
+// MP4Layout represents the layout of MP4 output
+type MP4Layout int
+
+const (
+	MP4LayoutFaststart  MP4Layout = C.MP4_LAYOUT_FASTSTART   // Movie box before the media data, for playback while downloading
+	MP4LayoutFragmented MP4Layout = C.MP4_LAYOUT_FRAGMENTED  // Empty movie box and one fragment per keyframe interval
+	MP4LayoutMoovAtEnd  MP4Layout = C.MP4_LAYOUT_MOOV_AT_END // Movie box where the muxer wrote it, skipping the pass that moves it
+)
+
+// OutputMode represents whether the video is written as one file or as
+// separate segment files
+type OutputMode int
+
+const (
+	OutputModeSingle   OutputMode = C.OUTPUT_MODE_SINGLE   // A single file at the output path
+	OutputModeSegments OutputMode = C.OUTPUT_MODE_SEGMENTS // Keyframe-aligned segment files and a JSON manifest at the output path
+)
+
+// ToneMap represents the tone mapping operator for HDR inputs
+type ToneMap int
+
+const (
+	ToneMapHable    ToneMap = C.TONE_MAP_HABLE    // Filmic curve with soft highlights
+	ToneMapReinhard ToneMap = C.TONE_MAP_REINHARD // Simple curve that keeps more midtone contrast
+	ToneMapMobius   ToneMap = C.TONE_MAP_MOBIUS   // Linear up to the midtones, compressing only the highlights
+	ToneMapClip     ToneMap = C.TONE_MAP_CLIP     // Clip values above SDR white
 )
 
 // Color represents an RGB color
@@ -38,24 +363,59 @@ type Color struct {
 // SlideEntry represents a single slide in a slideshow
 type SlideEntry struct {
 	Path       string
-	DurationMs uint32
+	DurationMs uint32 // With Audio, 0 uses the narration length
+	Caption    string // Optional caption drawn near the bottom of the slide
+	Audio      string // Optional narration audio played from the start of the slide
+}
+
+// TimeRange is a span of the output video in milliseconds.
+// Negative values are measured back from the end of the video,
+// and an EndMs of 0 is the end of the video.
+type TimeRange struct {
+	StartMs int64
+	EndMs   int64
+}
+
+// Logo is an image drawn over every frame of the output.
+// Animated GIF, PNG and WebP logos play in a loop.
+type Logo struct {
+	Path   string
+	Anchor Anchor      // AnchorAuto for the top left
+	Width  int         // Width in percent of the frame width (0 or less for 15)
+	FadeMs int         // Fade in and out at the edges of each range (0 or less for none)
+	Ranges []TimeRange // Ranges in which the logo is shown (empty for the whole video)
+}
+
+// Chapter is a chapter marker players can jump to.
+type Chapter struct {
+	Start time.Duration // Start of the chapter, in whole milliseconds
+	Title string        // Title shown by players (up to 255 bytes in MP4)
 }
 
-// resultToError converts a C Result to a Go error
+// resultToError converts a C Result to a Go error of type *Error
 func resultToError(result C.Result) error {
 	if result.code == C.MINMPEG_OK {
 		return nil
 	}
 
-	var msg string
+	msg := "Unknown error"
 	if result.message != nil {
 		msg = C.GoString(result.message)
-		C.minmpeg_free_result(&result)
-	} else {
-		msg = "Unknown error"
 	}
+	err := libraryError(ErrorCode(result.code), msg)
+	if result.slide_index >= 0 {
+		err.slideIndex = int(result.slide_index)
+		if result.slide_path != nil {
+			err.slidePath = C.GoString(result.slide_path)
+		}
+	}
+	if result.ffmpeg_command != nil {
+		err.ffmpegCommand = C.GoString(result.ffmpeg_command)
+		err.ffmpegStderr = C.GoString(result.ffmpeg_stderr)
+	}
+	C.minmpeg_free_result(&result)
 
-	return errors.New(msg)
+	return err
 }
 
 // Available checks if a codec is available on this system
@@ -70,25 +430,91 @@ func Available(codec Codec, ffmpegPath string) error {
 	return resultToError(result)
 }
 
-// Slideshow creates a video from a sequence of images
-func Slideshow(entries []SlideEntry, outputPath string, container Container, codec Codec, quality uint8, ffmpegPath string) error {
-	if len(entries) == 0 {
-		return errors.New("no slides provided")
+// Capability is a container, codec and encoder combination usable on this
+// system
+type Capability struct {
+	Container Container
+	Codec     Codec
+	HWAccel   HWAccel // HWAccelSoftware, or the hardware encoder of H.264, HEVC and AV1 (never HWAccelAuto)
+}
+
+// Capabilities lists the container, codec and encoder combinations usable on
+// this system, ordered by container, codec and encoder, so services can offer
+// only options that will work. Each available codec is listed with every
+// container that holds it. H.264, HEVC and AV1 are listed once per working
+// encoder, found by encoding a frame with the software encoder and each
+// hardware encoder; this takes a moment, so keep the result instead of asking
+// again for every request.
+func Capabilities(ffmpegPath string) ([]Capability, error) {
+	var cPath *C.char
+	if ffmpegPath != "" {
+		cPath = C.CString(ffmpegPath)
+		defer C.free(unsafe.Pointer(cPath))
 	}
 
-	// Convert entries
+	var cCapabilities *C.Capability
+	var cCount C.size_t
+	result := C.minmpeg_capabilities(cPath, &cCapabilities, &cCount)
+	if err := resultToError(result); err != nil {
+		return nil, err
+	}
+	defer C.minmpeg_free_capabilities(cCapabilities, cCount)
+
+	capabilities := make([]Capability, 0, int(cCount))
+	for _, c := range unsafe.Slice(cCapabilities, int(cCount)) {
+		capabilities = append(capabilities, Capability{
+			Container: Container(c.container),
+			Codec:     Codec(c.codec),
+			HWAccel:   HWAccel(c.hw_accel),
+		})
+	}
+	return capabilities, nil
+}
+
+// cSlideEntries converts slide entries to C entries
+func cSlideEntries(entries []SlideEntry) ([]C.SlideEntry, func()) {
 	cEntries := make([]C.SlideEntry, len(entries))
-	cPaths := make([]*C.char, len(entries))
+	var allocs []unsafe.Pointer
 
 	for i, entry := range entries {
-		cPaths[i] = C.CString(entry.Path)
-		defer C.free(unsafe.Pointer(cPaths[i]))
+		cPath := C.CString(entry.Path)
+		allocs = append(allocs, unsafe.Pointer(cPath))
+
+		var cCaption *C.char
+		if entry.Caption != "" {
+			cCaption = C.CString(entry.Caption)
+			allocs = append(allocs, unsafe.Pointer(cCaption))
+		}
+
+		var cAudio *C.char
+		if entry.Audio != "" {
+			cAudio = C.CString(entry.Audio)
+			allocs = append(allocs, unsafe.Pointer(cAudio))
+		}
 
 		cEntries[i] = C.SlideEntry{
-			path:        cPaths[i],
+			path:        cPath,
 			duration_ms: C.uint32_t(entry.DurationMs),
+			caption:     cCaption,
+			audio:       cAudio,
+		}
+	}
+
+	return cEntries, func() {
+		for _, p := range allocs {
+			C.free(p)
 		}
 	}
+}
+
+// Slideshow creates a video from a sequence of images
+func Slideshow(entries []SlideEntry, outputPath string, container Container, codec Codec, quality uint8, ffmpegPath string, opts ...Option) error {
+	if len(entries) == 0 {
+		return newOptions(opts).newError(ErrInvalidInput, "no slides provided")
+	}
+
+	cEntries, freeEntries := cSlideEntries(entries)
+	defer freeEntries()
 
 	cOutputPath := C.CString(outputPath)
 	defer C.free(unsafe.Pointer(cOutputPath))
@@ -99,21 +525,82 @@ func Slideshow(entries []SlideEntry, outputPath string, container Container, cod
 		defer C.free(unsafe.Pointer(cFfmpegPath))
 	}
 
-	result := C.minmpeg_slideshow(
-		&cEntries[0],
-		C.size_t(len(entries)),
-		cOutputPath,
-		C.Container(container),
-		C.Codec(codec),
-		C.uint8_t(quality),
-		cFfmpegPath,
-	)
+	o := newOptions(opts)
+	cParams, freeParams := o.cParams()
+	defer freeParams()
 
-	return resultToError(result)
+	job := Job{Operation: OperationSlideshow, Output: outputPath, Codec: codec, Container: container}
+	return o.run(job, func() error {
+		result := C.minmpeg_slideshow(
+			&cEntries[0],
+			C.size_t(len(entries)),
+			cOutputPath,
+			C.Container(container),
+			C.Codec(codec),
+			C.uint8_t(quality),
+			cFfmpegPath,
+			cParams,
+		)
+		return resultToError(result)
+	})
+}
+
+// SlideshowFunc creates a slideshow from slides returned one at a time by
+// next, which returns the next slide and true, or false after the last one.
+// Each slide is loaded and encoded before next is called again, so very long
+// slideshows can be generated lazily without holding every slide in memory.
+// The first slide sets the output size.
+//
+// As the length of the video is only known at the end, slide number formats
+// with {total}, logo ranges measured from the end, WithTimeBudget, target
+// sizes, two-pass encoding and WithHWFallback(HWFallbackSoftware, ...) fail
+// with ErrInvalidInput, and HDR10 output has no content light levels. Calls
+// are not retried, as the slides cannot be taken again. An error returned by
+// next stops the slideshow and is returned.
+func SlideshowFunc(next func() (SlideEntry, bool, error), outputPath string, container Container, codec Codec, quality uint8, ffmpegPath string, opts ...Option) error {
+	cOutputPath := C.CString(outputPath)
+	defer C.free(unsafe.Pointer(cOutputPath))
+
+	var cFfmpegPath *C.char
+	if ffmpegPath != "" {
+		cFfmpegPath = C.CString(ffmpegPath)
+		defer C.free(unsafe.Pointer(cFfmpegPath))
+	}
+
+	o := newOptions(opts)
+	o.retry = RetryPolicy{}
+	cParams, freeParams := o.cParams()
+	defer freeParams()
+
+	// The callback finds the source through a handle, as C cannot hold Go
+	// pointers
+	source := &slideSource{next: next}
+	handle := cgo.NewHandle(source)
+	defer handle.Delete()
+	defer source.release()
+
+	job := Job{Operation: OperationSlideshowStream, Output: outputPath, Codec: codec, Container: container}
+	return o.run(job, func() error {
+		result := C.minmpeg_slideshow_stream(
+			C.slide_source(),
+			C.slide_source_user_data(C.uintptr_t(handle)),
+			cOutputPath,
+			C.Container(container),
+			C.Codec(codec),
+			C.uint8_t(quality),
+			cFfmpegPath,
+			cParams,
+		)
+		err := resultToError(result)
+		if source.err != nil {
+			return source.err
+		}
+		return err
+	})
 }
 
 // Juxtapose combines two videos side by side
-func Juxtapose(leftPath, rightPath, outputPath string, container Container, codec Codec, quality uint8, background *Color, ffmpegPath string) error {
+func Juxtapose(leftPath, rightPath, outputPath string, container Container, codec Codec, quality uint8, background *Color, ffmpegPath string, opts ...Option) error {
 	cLeftPath := C.CString(leftPath)
 	defer C.free(unsafe.Pointer(cLeftPath))
 
@@ -139,18 +626,25 @@ func Juxtapose(leftPath, rightPath, outputPath string, container Container, code
 		defer C.free(unsafe.Pointer(cFfmpegPath))
 	}
 
-	result := C.minmpeg_juxtapose(
-		cLeftPath,
-		cRightPath,
-		cOutputPath,
-		C.Container(container),
-		C.Codec(codec),
-		C.uint8_t(quality),
-		cBackground,
-		cFfmpegPath,
-	)
+	o := newOptions(opts)
+	cParams, freeParams := o.cParams()
+	defer freeParams()
 
-	return resultToError(result)
+	job := Job{Operation: OperationJuxtapose, Output: outputPath, Codec: codec, Container: container}
+	return o.run(job, func() error {
+		result := C.minmpeg_juxtapose(
+			cLeftPath,
+			cRightPath,
+			cOutputPath,
+			C.Container(container),
+			C.Codec(codec),
+			C.uint8_t(quality),
+			cBackground,
+			cFfmpegPath,
+			cParams,
+		)
+		return resultToError(result)
+	})
 }
 
 // Version returns the library version string
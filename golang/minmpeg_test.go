@@ -1,13 +1,29 @@
 package minmpeg
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"expvar"
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	"image/png"
+	"io"
+	"log/slog"
+	"math"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // createTestImage creates a simple colored PNG image for testing
@@ -29,6 +45,82 @@ func createTestImage(path string, width, height int, c color.Color) error {
 	return png.Encode(f, img)
 }
 
+// slideFixture creates a temporary directory with one solid 320x240 slide
+// shown for durationMs, returning the slide list and the path of output in
+// the directory
+func slideFixture(t *testing.T, c color.Color, durationMs uint32, output string) ([]SlideEntry, string) {
+	t.Helper()
+	tmpDir := t.TempDir()
+	imgPath := filepath.Join(tmpDir, "slide.png")
+	if err := createTestImage(imgPath, 320, 240, c); err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+	return []SlideEntry{{Path: imgPath, DurationMs: durationMs}}, filepath.Join(tmpDir, output)
+}
+
+// expectVideo checks properties of the first video stream of a file, as
+// ffprobe names them, such as codec_name, color_primaries or the number of
+// frames nb_read_frames; without ffprobe they are not checked
+func expectVideo(t *testing.T, path string, want map[string]string) {
+	t.Helper()
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		t.Log("ffprobe not available; video properties not checked")
+		return
+	}
+	out, err := exec.Command("ffprobe", "-v", "error", "-select_streams", "v:0", "-count_frames",
+		"-show_entries", "stream", "-of", "default=noprint_wrappers=1", path).Output()
+	if err != nil {
+		t.Fatalf("ffprobe failed on %s: %v", path, err)
+	}
+	got := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		if key, value, ok := strings.Cut(line, "="); ok {
+			got[key] = value
+		}
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("Expected %s %s of %s, got %q", key, value, filepath.Base(path), got[key])
+		}
+	}
+}
+
+// createTestWAV writes a mono 16-bit PCM WAV file with a sine tone
+func createTestWAV(path string, durationMs int) error {
+	const sampleRate = 44100
+	samples := sampleRate * durationMs / 1000
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header := []any{
+		[4]byte{'R', 'I', 'F', 'F'}, uint32(36 + samples*2), [4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '}, uint32(16), uint16(1), uint16(1),
+		uint32(sampleRate), uint32(sampleRate * 2), uint16(2), uint16(16),
+		[4]byte{'d', 'a', 't', 'a'}, uint32(samples * 2),
+	}
+	for _, v := range header {
+		if err := binary.Write(f, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+
+	data := make([]int16, samples)
+	for i := range data {
+		data[i] = int16(math.Sin(2*math.Pi*440*float64(i)/sampleRate) * 16000)
+	}
+	return binary.Write(f, binary.LittleEndian, data)
+}
+
+// ffmpegAvailable reports whether ffmpeg is in PATH
+func ffmpegAvailable() bool {
+	_, err := exec.LookPath("ffmpeg")
+	return err == nil
+}
+
 // verifyWebMHeader checks if a file starts with valid WebM/EBML header
 func verifyWebMHeader(path string) bool {
 	f, err := os.Open(path)
@@ -46,6 +138,22 @@ func verifyWebMHeader(path string) bool {
 	return header[0] == 0x1A && header[1] == 0x45 && header[2] == 0xDF && header[3] == 0xA3
 }
 
+// verifyMP4Header checks if a file starts with an MP4 ftyp box
+func verifyMP4Header(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, 8)
+	if _, err := f.Read(header); err != nil {
+		return false
+	}
+
+	return string(header[4:8]) == "ftyp"
+}
+
 func TestAvailable(t *testing.T) {
 	err := Available(CodecAV1, "")
 	if err != nil {
@@ -53,6 +161,274 @@ func TestAvailable(t *testing.T) {
 	}
 }
 
+func TestCapabilities(t *testing.T) {
+	capabilities, err := Capabilities("")
+	if err != nil {
+		t.Fatalf("Capabilities failed: %v", err)
+	}
+
+	want := Capability{Container: ContainerWebM, Codec: CodecAV1, HWAccel: HWAccelSoftware}
+	if !slices.Contains(capabilities, want) {
+		t.Errorf("Expected %+v in %+v", want, capabilities)
+	}
+	for _, c := range capabilities {
+		if c.HWAccel == HWAccelAuto {
+			t.Errorf("Capability %+v should name its encoder", c)
+		}
+	}
+}
+
+func TestFindFFmpeg(t *testing.T) {
+	if !ffmpegAvailable() {
+		if _, _, err := FindFFmpeg(); err == nil {
+			t.Error("FindFFmpeg should fail without ffmpeg")
+		}
+		t.Skip("ffmpeg not available")
+	}
+
+	path, version, err := FindFFmpeg()
+	if err != nil {
+		t.Fatalf("FindFFmpeg failed: %v", err)
+	}
+	if path == "" || version == "" {
+		t.Errorf("Expected a path and version, got %q and %q", path, version)
+	}
+	f, err := InspectFFmpeg(path)
+	if err != nil {
+		t.Fatalf("InspectFFmpeg failed: %v", err)
+	}
+	if f.Version != version || len(f.Encoders) == 0 {
+		t.Errorf("Unexpected ffmpeg %+v", f)
+	}
+	if f.HasEncoder("no-such-encoder") {
+		t.Error("HasEncoder should match names exactly")
+	}
+
+	if _, err := InspectFFmpeg(filepath.Join(t.TempDir(), "ffmpeg")); err == nil {
+		t.Error("Inspecting a missing ffmpeg should fail")
+	}
+}
+
+func TestFFmpegEnv(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "ffmpeg")
+	t.Setenv(FFmpegEnv, missing)
+
+	_, _, err := FindFFmpeg()
+	if err == nil || !strings.Contains(err.Error(), FFmpegEnv) {
+		t.Errorf("Expected an error naming %s, got %v", FFmpegEnv, err)
+	}
+	if err := Available(CodecVP9, ""); err == nil {
+		t.Error("VP9 should be unavailable with a missing ffmpeg configured")
+	}
+}
+
+func TestNativeOnly(t *testing.T) {
+	SetNativeOnly(true)
+	defer SetNativeOnly(false)
+
+	_, _, err := FindFFmpeg()
+	if err == nil || !strings.Contains(err.Error(), "native-only") {
+		t.Errorf("Expected a native-only error, got %v", err)
+	}
+	if err := Available(CodecVP9, ""); err == nil {
+		t.Error("VP9 should be unavailable in native-only mode")
+	}
+}
+
+func TestSetLanguage(t *testing.T) {
+	SetLanguage(LanguageJapanese)
+	defer SetLanguage(LanguageEnglish)
+
+	entries := []SlideEntry{{Path: "slide.png", DurationMs: 500}}
+	err := Slideshow(entries, "output.webm", ContainerWebM, CodecH264, 50, "")
+	if err == nil {
+		t.Fatal("WebM+H.264 should fail")
+	}
+	if !strings.Contains(err.Error(), "対応していません") {
+		t.Errorf("Expected a Japanese message, got %q", err.Error())
+	}
+
+	SetLanguage(LanguageEnglish)
+	err = Slideshow(entries, "output.webm", ContainerWebM, CodecH264, 50, "")
+	if err == nil || !strings.Contains(err.Error(), "does not support") {
+		t.Errorf("Expected an English message, got %v", err)
+	}
+}
+
+func TestWithLanguage(t *testing.T) {
+	entries := []SlideEntry{{Path: "slide.png", DurationMs: 500}}
+	err := Slideshow(entries, "output.webm", ContainerWebM, CodecH264, 50, "", WithLanguage(LanguageJapanese))
+	if err == nil || !strings.Contains(err.Error(), "対応していません") {
+		t.Errorf("Expected a Japanese message from the library, got %v", err)
+	}
+
+	// Messages of the binding are translated too
+	err = Slideshow(nil, "output.webm", ContainerWebM, CodecVP9, 50, "", WithLanguage(LanguageJapanese))
+	if err == nil || err.Error() != "入力が不正です: no slides provided" {
+		t.Errorf("Expected a Japanese message from the binding, got %v", err)
+	}
+	if err := RegisterFont("", []byte{0}); err == nil || err.Error() != "font name is empty" {
+		t.Errorf("Expected an English message without SetLanguage, got %v", err)
+	}
+
+	// The option takes precedence over SetLanguage for its call only
+	SetLanguage(LanguageJapanese)
+	defer SetLanguage(LanguageEnglish)
+	err = Slideshow(entries, "output.webm", ContainerWebM, CodecH264, 50, "", WithLanguage(LanguageEnglish))
+	if err == nil || !strings.Contains(err.Error(), "does not support") {
+		t.Errorf("Expected an English message, got %v", err)
+	}
+	if err := RegisterFont("", []byte{0}); err == nil || !strings.HasPrefix(err.Error(), "入力が不正です") {
+		t.Errorf("Expected a Japanese message after SetLanguage, got %v", err)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	entries := []SlideEntry{{Path: "slide.png", DurationMs: 500}}
+	err := Slideshow(entries, "output.webm", ContainerWebM, CodecH264, 50, "")
+
+	var mErr *Error
+	if !errors.As(err, &mErr) {
+		t.Fatalf("Expected *Error, got %v", err)
+	}
+	if mErr.Code() != int(ErrContainerCodecMismatch) {
+		t.Errorf("Expected code %d, got %d", ErrContainerCodecMismatch, mErr.Code())
+	}
+	if !errors.Is(err, ErrContainerCodecMismatch) || errors.Is(err, ErrInvalidInput) {
+		t.Errorf("errors.Is does not match the code of %v", err)
+	}
+
+	if _, err := FindDuplicateSlides(nil, DuplicateMatchExact); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput, got %v", err)
+	}
+	if _, _, ok := mErr.Slide(); ok {
+		t.Error("A codec mismatch should not be attributed to a slide")
+	}
+	if _, _, ok := mErr.FFmpeg(); ok {
+		t.Error("A codec mismatch should not report an ffmpeg run")
+	}
+}
+
+func TestFFmpegError(t *testing.T) {
+	if !ffmpegAvailable() {
+		t.Skip("ffmpeg not available")
+	}
+
+	input := filepath.Join(t.TempDir(), "not a video.mp4")
+	if err := os.WriteFile(input, []byte("not a video"), 0o644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	err := RemoveAudio(input, filepath.Join(t.TempDir(), "output.mp4"), "")
+	var mErr *Error
+	if !errors.As(err, &mErr) {
+		t.Fatalf("Expected *Error, got %v", err)
+	}
+	command, stderr, ok := mErr.FFmpeg()
+	if !ok || !strings.Contains(command, "'"+input+"'") {
+		t.Errorf("Expected a command line with the quoted input, got %q", command)
+	}
+	if stderr == "" || !strings.Contains(err.Error(), stderr) {
+		t.Errorf("Expected the stderr %q in the message %q", stderr, err.Error())
+	}
+}
+
+func TestSlideError(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imgPath := filepath.Join(tmpDir, "slide.png")
+	if err := createTestImage(imgPath, 320, 240, color.RGBA{0, 0, 255, 255}); err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+	missing := filepath.Join(tmpDir, "missing.png")
+	entries := []SlideEntry{{Path: imgPath, DurationMs: 300}, {Path: missing, DurationMs: 300}}
+
+	err = Slideshow(entries, filepath.Join(tmpDir, "output.webm"), ContainerWebM, CodecAV1, 50, "")
+	var mErr *Error
+	if !errors.As(err, &mErr) {
+		t.Fatalf("Expected *Error, got %v", err)
+	}
+	index, path, ok := mErr.Slide()
+	if !ok || index != 1 || path != missing {
+		t.Errorf("Expected slide 1 (%s), got %d (%s), %v", missing, index, path, ok)
+	}
+	if !strings.Contains(err.Error(), "Slide 2") {
+		t.Errorf("Expected the slide number in %q", err.Error())
+	}
+}
+
+func TestRetry(t *testing.T) {
+	policy := RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+
+	calls := 0
+	err := policy.run(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return newError(ErrIO, "busy")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Expected success on the third attempt, got %v after %d calls", err, calls)
+	}
+
+	// Codes outside RetryOn fail right away
+	calls = 0
+	err = policy.run(context.Background(), func() error {
+		calls++
+		return newError(ErrInvalidInput, "bad input")
+	})
+	if !errors.Is(err, ErrInvalidInput) || calls != 1 {
+		t.Errorf("Expected one attempt, got %v after %d calls", err, calls)
+	}
+
+	// The last error is returned once the attempts run out
+	calls = 0
+	policy.RetryOn = []ErrorCode{ErrEncode}
+	err = policy.run(context.Background(), func() error {
+		calls++
+		return newError(ErrEncode, fmt.Sprintf("attempt %d", calls))
+	})
+	if err == nil || !strings.Contains(err.Error(), "attempt 3") || calls != 3 {
+		t.Errorf("Expected the third error, got %v after %d calls", err, calls)
+	}
+
+	// Cancelling the context ends the wait for the next attempt
+	calls = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	slow := RetryPolicy{Attempts: 3, Backoff: time.Hour}
+	err = slow.run(ctx, func() error {
+		calls++
+		cancel()
+		return newError(ErrIO, "busy")
+	})
+	if !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Errorf("Expected context.Canceled after one call, got %v after %d calls", err, calls)
+	}
+
+	// Doubling the delay saturates rather than overflowing
+	delay := time.Hour
+	for i := 0; i < 100; i++ {
+		delay = slow.nextDelay(delay)
+	}
+	if delay != math.MaxInt64 {
+		t.Errorf("Expected the delay to saturate, got %v", delay)
+	}
+	capped := RetryPolicy{Backoff: time.Second, MaxBackoff: time.Minute}
+	if delay := capped.nextDelay(time.Minute); delay != time.Minute {
+		t.Errorf("Expected MaxBackoff, got %v", delay)
+	}
+
+	entries := []SlideEntry{{Path: "slide.png", DurationMs: 500}}
+	err = Slideshow(entries, "output.webm", ContainerWebM, CodecH264, 50, "", WithRetry(policy))
+	if !errors.Is(err, ErrContainerCodecMismatch) {
+		t.Errorf("Expected ErrContainerCodecMismatch, got %v", err)
+	}
+}
+
 func TestSlideshowCreatesValidVideo(t *testing.T) {
 	// Create temp directory
 	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
@@ -106,10 +482,2223 @@ func TestSlideshowCreatesValidVideo(t *testing.T) {
 	t.Logf("Created valid WebM file: %s (%d bytes)", outputPath, info.Size())
 }
 
-func TestVersion(t *testing.T) {
-	version := Version()
-	if version == "" {
-		t.Error("Version should not be empty")
+func TestSlideshowVP9(t *testing.T) {
+	if Available(CodecVP9, "") != nil {
+		t.Skip("ffmpeg with libvpx-vp9 not available")
+	}
+
+	entries, outputPath := slideFixture(t, color.RGBA{255, 128, 0, 255}, 500, "output.webm")
+
+	if err := Slideshow(entries, outputPath, ContainerWebM, CodecVP9, 50, ""); err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+	expectVideo(t, outputPath, map[string]string{"codec_name": "vp9"})
+}
+
+func TestSlideshowVP8(t *testing.T) {
+	if Available(CodecVP8, "") != nil {
+		t.Skip("ffmpeg with libvpx not available")
+	}
+
+	entries, outputPath := slideFixture(t, color.RGBA{0, 128, 255, 255}, 500, "output.webm")
+
+	if err := Slideshow(entries, outputPath, ContainerWebM, CodecVP8, 50, ""); err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+	expectVideo(t, outputPath, map[string]string{"codec_name": "vp8"})
+}
+
+func TestSlideshowHEVC(t *testing.T) {
+	if Available(CodecHEVC, "") != nil {
+		t.Skip("no HEVC encoder available")
+	}
+
+	entries, outputPath := slideFixture(t, color.RGBA{0, 64, 255, 255}, 500, "output.mp4")
+
+	if err := Slideshow(entries, outputPath, ContainerMP4, CodecHEVC, 50, ""); err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+	if !verifyMP4Header(outputPath) {
+		t.Fatal("Output file is not a valid MP4")
+	}
+	expectVideo(t, outputPath, map[string]string{"codec_name": "hevc"})
+}
+
+func TestSlideshowProRes(t *testing.T) {
+	if Available(CodecProRes, "") != nil {
+		t.Skip("ffmpeg with prores_ks not available")
+	}
+
+	entries, outputPath := slideFixture(t, color.RGBA{255, 64, 0, 255}, 500, "output.mov")
+
+	if err := Slideshow(entries, outputPath, ContainerMOV, CodecProRes, 90, ""); err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+	if !verifyMP4Header(outputPath) {
+		t.Fatal("Output file is not a valid MOV")
+	}
+	expectVideo(t, outputPath, map[string]string{"codec_name": "prores"})
+}
+
+func TestSlideshowWithCaptions(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	entries := make([]SlideEntry, 2)
+	for i := range entries {
+		imgPath := filepath.Join(tmpDir, fmt.Sprintf("slide_%d.png", i))
+		if err := createTestImage(imgPath, 320, 240, color.RGBA{0, 0, 128, 255}); err != nil {
+			t.Fatalf("Failed to create test image: %v", err)
+		}
+		entries[i] = SlideEntry{
+			Path:       imgPath,
+			DurationMs: 500,
+			Caption:    fmt.Sprintf("Slide %d", i+1),
+		}
+	}
+
+	outputPath := filepath.Join(tmpDir, "captions.webm")
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithFont("DejaVu Sans"))
+	if err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+}
+
+func TestSlideshowCaptionLayout(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imgPath := filepath.Join(tmpDir, "slide.png")
+	if err := createTestImage(imgPath, 320, 240, color.RGBA{0, 0, 128, 255}); err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+	entries := []SlideEntry{{
+		Path:       imgPath,
+		DurationMs: 500,
+		Caption:    "A caption that is much too long for one line",
+	}}
+
+	outputPath := filepath.Join(tmpDir, "layout.webm")
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+		WithCaptionAlign(TextAlignStart), WithCaptionFit(TextFitShrink), WithCaptionMaxWidth(80),
+		WithCaptionAnchor(AnchorTopLeft), WithSafeArea(10))
+	if err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithCaptionMaxWidth(150))
+	if err == nil {
+		t.Error("Caption max width over 100 should fail")
+	}
+
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithSafeArea(60))
+	if err == nil {
+		t.Error("Safe area over 40 should fail")
+	}
+}
+
+func TestSlideshowSlideNumber(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	entries := make([]SlideEntry, 2)
+	for i := range entries {
+		imgPath := filepath.Join(tmpDir, fmt.Sprintf("slide_%d.png", i))
+		if err := createTestImage(imgPath, 320, 240, color.RGBA{0, 128, 0, 255}); err != nil {
+			t.Fatalf("Failed to create test image: %v", err)
+		}
+		entries[i] = SlideEntry{Path: imgPath, DurationMs: 300}
+	}
+
+	outputPath := filepath.Join(tmpDir, "numbers.webm")
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+		WithSlideNumber("Slide {n} of {total}"), WithSlideNumberAnchor(AnchorBottomRight))
+	if err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+}
+
+func TestSlideshowLogo(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	entries := make([]SlideEntry, 2)
+	for i := range entries {
+		imgPath := filepath.Join(tmpDir, fmt.Sprintf("slide_%d.png", i))
+		if err := createTestImage(imgPath, 320, 240, color.RGBA{0, 0, 128, 255}); err != nil {
+			t.Fatalf("Failed to create test image: %v", err)
+		}
+		entries[i] = SlideEntry{Path: imgPath, DurationMs: 500}
+	}
+
+	logoPath := filepath.Join(tmpDir, "logo.png")
+	if err := createTestImage(logoPath, 64, 32, color.RGBA{255, 255, 0, 255}); err != nil {
+		t.Fatalf("Failed to create logo image: %v", err)
+	}
+
+	outputPath := filepath.Join(tmpDir, "logo.webm")
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+		WithLogo(Logo{
+			Path:   logoPath,
+			Anchor: AnchorBottomRight,
+			Width:  20,
+			FadeMs: 200,
+			Ranges: []TimeRange{{StartMs: 200, EndMs: -200}},
+		}))
+	if err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+		WithLogo(Logo{Path: filepath.Join(tmpDir, "missing.png")}))
+	if err == nil {
+		t.Error("Missing logo should fail")
+	}
+}
+
+func TestSlideshowIdleFrames(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{0, 128, 255, 255}, 1000, "output.webm")
+
+	err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+		WithIdleFrames(IdleFramesMerge))
+	if err != nil {
+		t.Fatalf("Slideshow with merged idle frames failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+}
+
+func TestSlideshowTimeBudget(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imgPath := filepath.Join(tmpDir, "slide.png")
+	if err := createTestImage(imgPath, 1280, 720, color.RGBA{0, 128, 255, 255}); err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+	entries := []SlideEntry{{Path: imgPath, DurationMs: 2000}}
+
+	outputPath := filepath.Join(tmpDir, "output.webm")
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+		WithTimeBudget(time.Second))
+	if err != nil {
+		t.Fatalf("Slideshow with a time budget failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+}
+
+func TestSlideshowLossless(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{0, 128, 255, 255}, 300, "output.webm")
+
+	err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+		WithCompression(CompressionLossless))
+	if err != nil {
+		t.Fatalf("Lossless slideshow failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+}
+
+func TestSlideshowMKV(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{255, 128, 0, 255}, 300, "output.mkv")
+
+	if err := Slideshow(entries, outputPath, ContainerMKV, CodecAV1, 50, ""); err != nil {
+		t.Fatalf("MKV slideshow failed: %v", err)
+	}
+	// MKV shares the EBML header of WebM
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid Matroska file")
+	}
+}
+
+func TestSlideshowHLS(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{255, 128, 0, 255}, 1000, "video.m3u8")
+	tmpDir := filepath.Dir(outputPath)
+
+	err := Slideshow(entries, outputPath, ContainerHLS, CodecAV1, 50, "",
+		WithHLSSegment(HLSSegmentFMP4), WithSegmentDuration(2*time.Second))
+	if err != nil {
+		t.Fatalf("HLS slideshow failed: %v", err)
+	}
+	playlist, err := os.ReadFile(outputPath)
+	if err != nil || !strings.HasPrefix(string(playlist), "#EXTM3U") {
+		t.Fatalf("Output is not an HLS playlist: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "video_00000.m4s")); err != nil {
+		t.Errorf("First segment is missing: %v", err)
+	}
+}
+
+func TestSlideshowDASH(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{255, 128, 0, 255}, 1000, "video.mpd")
+	tmpDir := filepath.Dir(outputPath)
+
+	err := Slideshow(entries, outputPath, ContainerDASH, CodecAV1, 50, "", WithSegmentDuration(2*time.Second))
+	if err != nil {
+		t.Fatalf("DASH slideshow failed: %v", err)
+	}
+	manifest, err := os.ReadFile(outputPath)
+	if err != nil || !strings.Contains(string(manifest), "<MPD ") {
+		t.Fatalf("Output is not a DASH manifest: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "video_init.mp4")); err != nil {
+		t.Errorf("Initialization segment is missing: %v", err)
+	}
+}
+
+func TestSlideshowFragmentedMP4(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{255, 128, 0, 255}, 500, "output.mp4")
+
+	err := Slideshow(entries, outputPath, ContainerMP4, CodecAV1, 50, "", WithMP4Layout(MP4LayoutFragmented))
+	if err != nil {
+		t.Fatalf("Fragmented MP4 slideshow failed: %v", err)
+	}
+	if !verifyMP4Header(outputPath) {
+		t.Fatal("Output file is not a valid MP4 file")
+	}
+	data, err := os.ReadFile(outputPath)
+	if err != nil || !strings.Contains(string(data), "moof") {
+		t.Errorf("Output has no movie fragments: %v", err)
+	}
+}
+
+func TestSlideshowBitrate(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{255, 128, 0, 255}, 500, "output.webm")
+
+	err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithBitrate(200), WithMaxBitrate(400, 0))
+	if err != nil {
+		t.Fatalf("Bitrate slideshow failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+
+	// The average must not exceed the peak
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithBitrate(500), WithMaxBitrate(400, 0))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for a bitrate above the peak, got %v", err)
+	}
+}
+
+func TestSlideshowFrameRate(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{255, 128, 0, 255}, 1000, "output.webm")
+
+	if err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithFrameRate(24)); err != nil {
+		t.Fatalf("24 fps slideshow failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+	// A second at 24 fps
+	expectVideo(t, outputPath, map[string]string{"nb_read_frames": "24"})
+
+	err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithFrameRate(MaxFPS+1))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for a frame rate above MaxFPS, got %v", err)
+	}
+}
+
+func TestSlideshowSegments(t *testing.T) {
+	entries, manifestPath := slideFixture(t, color.RGBA{255, 128, 0, 255}, 2000, "video.json")
+	tmpDir := filepath.Dir(manifestPath)
+
+	err := Slideshow(entries, manifestPath, ContainerWebM, CodecAV1, 50, "",
+		WithOutputMode(OutputModeSegments), WithSegmentDuration(time.Second))
+	if err != nil {
+		t.Fatalf("Segment slideshow failed: %v", err)
+	}
+	for _, name := range []string{"video_00000.webm", "video_00001.webm"} {
+		if !verifyWebMHeader(filepath.Join(tmpDir, name)) {
+			t.Errorf("Segment %s is not a valid WebM", name)
+		}
+	}
+	manifest, err := os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if !bytes.Contains(manifest, []byte(`"file": "video_00001.webm", "start_ms": 1000`)) {
+		t.Errorf("Manifest does not list the second segment: %s", manifest)
+	}
+
+	// Streaming containers have their own segments
+	err = Slideshow(entries, manifestPath, ContainerHLS, CodecH264, 50, "", WithOutputMode(OutputModeSegments))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for HLS segment output, got %v", err)
+	}
+}
+
+func TestSlideshowKeyframes(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{255, 128, 0, 255}, 2000, "output.webm")
+	tmpDir := filepath.Dir(outputPath)
+
+	err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+		WithKeyframeInterval(time.Second), WithKeyframeTimes(1500*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Slideshow with keyframe placement failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Error("Output is not a valid WebM")
+	}
+
+	// The interval must divide the segments
+	err = Slideshow(entries, filepath.Join(tmpDir, "video.json"), ContainerWebM, CodecAV1, 50, "",
+		WithOutputMode(OutputModeSegments), WithSegmentDuration(time.Second),
+		WithKeyframeInterval(300*time.Millisecond))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for an interval not dividing the segments, got %v", err)
+	}
+
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+		WithIdleFrames(IdleFramesMerge), WithKeyframeTimes(time.Second))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for forced keyframes with merged idle frames, got %v", err)
+	}
+}
+
+func TestSlideshowPixelFormat(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{40, 90, 200, 255}, 500, "output.webm")
+
+	err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+		WithPixelFormat(PixelFormatYUV444), WithBitDepth(BitDepth10))
+	if err != nil {
+		t.Fatalf("Slideshow with 10-bit 4:4:4 failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Error("Output is not a valid WebM")
+	}
+	expectVideo(t, outputPath, map[string]string{"pix_fmt": "yuv444p10le"})
+
+	// Lossless compression keeps full chroma resolution
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+		WithCompression(CompressionLossless), WithPixelFormat(PixelFormatYUV420))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for lossless 4:2:0, got %v", err)
+	}
+}
+
+func TestSlideshowColorSpace(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{220, 60, 40, 255}, 500, "output.webm")
+
+	// Video is tagged BT.709 in the limited range by default
+	if err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, ""); err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+	expectVideo(t, outputPath, map[string]string{
+		"color_primaries": "bt709",
+		"color_transfer":  "bt709",
+		"color_space":     "bt709",
+		"color_range":     "tv",
+	})
+
+	err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+		WithColorSpace(ColorPrimariesDisplayP3, ColorTransferSRGB, ColorMatrixBT709),
+		WithColorRange(ColorRangeFull))
+	if err != nil {
+		t.Fatalf("Slideshow with a color space failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Error("Output is not a valid WebM")
+	}
+	expectVideo(t, outputPath, map[string]string{
+		"color_primaries": "smpte432",
+		"color_transfer":  "iec61966-2-1",
+		"color_space":     "bt709",
+		"color_range":     "pc",
+	})
+
+	// HDR10 sets its own color space
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+		WithBitDepth(BitDepth10), WithDynamicRange(DynamicRangeHDR10),
+		WithColorSpace(ColorPrimariesBT709, ColorTransferAuto, ColorMatrixAuto))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for HDR10 with color primaries, got %v", err)
+	}
+}
+
+func TestSlideshowHWAccel(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{40, 120, 200, 255}, 500, "output.mp4")
+
+	// VideoToolbox has no AV1 encoder
+	err := Slideshow(entries, outputPath, ContainerMP4, CodecAV1, 50, "", WithHWAccel(HWAccelVideoToolbox))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for AV1 with VideoToolbox, got %v", err)
+	}
+
+	if Available(CodecH264, "") != nil {
+		t.Skip("no H.264 encoder available")
+	}
+	err = Slideshow(entries, outputPath, ContainerMP4, CodecH264, 50, "", WithHWAccel(HWAccelSoftware))
+	if err != nil {
+		t.Fatalf("Slideshow with the software encoder failed: %v", err)
+	}
+	if !verifyMP4Header(outputPath) {
+		t.Error("Output is not a valid MP4")
+	}
+}
+
+func TestSlideshowHWFallback(t *testing.T) {
+	if Available(CodecH264, "") != nil {
+		t.Skip("no H.264 encoder available")
+	}
+	entries, outputPath := slideFixture(t, color.RGBA{200, 80, 40, 255}, 500, "output.mp4")
+
+	var warnings []error
+	var mu sync.Mutex
+	warn := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		warnings = append(warnings, err)
+	}
+	err := Slideshow(entries, outputPath, ContainerMP4, CodecH264, 50, "",
+		WithHWAccel(HWAccelAMF), WithHWFallback(HWFallbackSoftware, warn))
+	if err != nil {
+		t.Fatalf("Slideshow with fallback failed: %v", err)
+	}
+	if !verifyMP4Header(outputPath) {
+		t.Error("Output is not a valid MP4")
+	}
+
+	// Without the fallback, the same encode fails where AMF is missing
+	err = Slideshow(entries, outputPath, ContainerMP4, CodecH264, 50, "", WithHWAccel(HWAccelAMF))
+	if (err != nil) != (len(warnings) == 1) {
+		t.Errorf("Expected an error only when falling back, got %v after warnings %v", err, warnings)
+	}
+}
+
+func TestSlideshowOutputCheck(t *testing.T) {
+	if !ffmpegAvailable() || Available(CodecAV1, "") != nil {
+		t.Skip("ffmpeg not available")
+	}
+	entries, outputPath := slideFixture(t, color.RGBA{40, 80, 200, 255}, 1000, "output.webm")
+
+	err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithOutputCheck(OutputCheckProbe))
+	if err != nil {
+		t.Fatalf("Checked slideshow failed: %v", err)
+	}
+}
+
+func TestSlideshowThreads(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{80, 160, 40, 255}, 500, "output.webm")
+
+	err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithThreads(1))
+	if err != nil {
+		t.Fatalf("Slideshow with one thread failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Error("Output is not a valid WebM")
+	}
+
+	// libx264 records its settings, with the thread count, in the stream
+	if runtime.GOOS != "linux" || Available(CodecH264, "") != nil {
+		t.Skip("thread counts are only recorded by libx264 on Linux")
+	}
+	h264Path := filepath.Join(filepath.Dir(outputPath), "output.mp4")
+	err = Slideshow(entries, h264Path, ContainerMP4, CodecH264, 50, "",
+		WithThreads(1), WithHWAccel(HWAccelSoftware))
+	if err != nil {
+		t.Fatalf("H.264 slideshow with one thread failed: %v", err)
+	}
+	data, err := os.ReadFile(h264Path)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !bytes.Contains(data, []byte(" threads=1 ")) {
+		t.Error("Expected threads=1 in the libx264 settings")
+	}
+}
+
+func TestSlideshowAV1Backend(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{120, 40, 160, 255}, 500, "output.webm")
+
+	err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithAV1Backend(AV1BackendRav1e, 10))
+	if err != nil {
+		t.Fatalf("Slideshow with rav1e preset 10 failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Error("Output is not a valid WebM")
+	}
+
+	// libaom stops at cpu-used 8
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithAV1Backend(AV1BackendAOM, 9))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for libaom preset 9, got %v", err)
+	}
+
+	if !ffmpegAvailable() {
+		t.Skip("ffmpeg not available")
+	}
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithAV1Backend(AV1BackendSVTAV1, 12))
+	if errors.Is(err, ErrCodecUnavailable) {
+		t.Skip("ffmpeg without libsvtav1")
+	}
+	if err != nil {
+		t.Fatalf("Slideshow with SVT-AV1 failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Error("Output is not a valid WebM")
+	}
+}
+
+func TestSlideshowFFmpegArgs(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{60, 60, 180, 255}, 500, "output.webm")
+
+	// rav1e does not run in ffmpeg
+	err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithFFmpegArgs("-lag-in-frames", "0"))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for AV1 with ffmpeg arguments, got %v", err)
+	}
+
+	if Available(CodecVP9, "") != nil {
+		t.Skip("ffmpeg with libvpx-vp9 not available")
+	}
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecVP9, 50, "", WithFFmpegArgs("-lag-in-frames", "0"))
+	if err != nil {
+		t.Fatalf("Slideshow with ffmpeg arguments failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Error("Output is not a valid WebM")
+	}
+}
+
+func TestSlideshowH264Profile(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{40, 160, 120, 255}, 500, "output.mp4")
+	tmpDir := filepath.Dir(outputPath)
+
+	// 3.5 is not a level
+	err := Slideshow(entries, outputPath, ContainerMP4, CodecH264, 50, "", WithH264Profile(H264ProfileMain, 35))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for level 35, got %v", err)
+	}
+	err = Slideshow(entries, filepath.Join(tmpDir, "output.webm"), ContainerWebM, CodecVP9, 50, "", WithH264Tuning(H264PresetAuto, H264TuneStillImage))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for a tune of VP9, got %v", err)
+	}
+
+	if Available(CodecH264, "") != nil {
+		t.Skip("no H.264 encoder available")
+	}
+	err = Slideshow(entries, outputPath, ContainerMP4, CodecH264, 50, "", WithH264Profile(H264ProfileBaseline, 31))
+	if err != nil {
+		t.Fatalf("Slideshow with the Baseline profile failed: %v", err)
+	}
+	if !verifyMP4Header(outputPath) {
+		t.Error("Output is not a valid MP4")
+	}
+	expectVideo(t, outputPath, map[string]string{"profile": "Constrained Baseline", "level": "31"})
+
+	if runtime.GOOS != "linux" {
+		t.Skip("presets and tunes need libx264 on Linux")
+	}
+	err = Slideshow(entries, outputPath, ContainerMP4, CodecH264, 50, "",
+		WithH264Profile(H264ProfileHigh, 40), WithH264Tuning(H264PresetSlow, H264TuneStillImage))
+	if err != nil {
+		t.Fatalf("Slideshow with stillimage tuning failed: %v", err)
+	}
+	if !verifyMP4Header(outputPath) {
+		t.Error("Output is not a valid MP4")
+	}
+	expectVideo(t, outputPath, map[string]string{"profile": "High", "level": "40"})
+}
+
+func TestSlideshowFunc(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var entries []SlideEntry
+	for i, c := range []color.RGBA{{200, 60, 60, 255}, {60, 200, 60, 255}, {60, 60, 200, 255}} {
+		imgPath := filepath.Join(tmpDir, fmt.Sprintf("slide%d.png", i))
+		if err := createTestImage(imgPath, 320, 240, c); err != nil {
+			t.Fatalf("Failed to create test image: %v", err)
+		}
+		entries = append(entries, SlideEntry{Path: imgPath, DurationMs: 300, Caption: fmt.Sprintf("Slide %d", i+1)})
+	}
+	source := func(entries []SlideEntry, err error) func() (SlideEntry, bool, error) {
+		return func() (SlideEntry, bool, error) {
+			if len(entries) == 0 {
+				return SlideEntry{}, false, err
+			}
+			entry := entries[0]
+			entries = entries[1:]
+			return entry, true, nil
+		}
+	}
+	outputPath := filepath.Join(tmpDir, "output.webm")
+
+	err = SlideshowFunc(source(entries, nil), outputPath, ContainerWebM, CodecAV1, 50, "")
+	if err != nil {
+		t.Fatalf("SlideshowFunc failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Error("Output is not a valid WebM")
+	}
+
+	// The error of next is returned as is
+	errSource := errors.New("no more slides in the database")
+	err = SlideshowFunc(source(entries[:1], errSource), outputPath, ContainerWebM, CodecAV1, 50, "")
+	if !errors.Is(err, errSource) {
+		t.Errorf("Expected the error of next, got %v", err)
+	}
+
+	err = SlideshowFunc(source(nil, nil), outputPath, ContainerWebM, CodecAV1, 50, "")
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput without slides, got %v", err)
+	}
+	// The total is not known up front
+	err = SlideshowFunc(source(entries, nil), outputPath, ContainerWebM, CodecAV1, 50, "", WithSlideNumber("{n} / {total}"))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for slide numbers with the total, got %v", err)
+	}
+}
+
+func TestSlideshowMemoryBudget(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var entries []SlideEntry
+	for i, c := range []color.RGBA{{200, 60, 60, 255}, {60, 200, 60, 255}} {
+		imgPath := filepath.Join(tmpDir, fmt.Sprintf("slide%d.png", i))
+		if err := createTestImage(imgPath, 320, 240, c); err != nil {
+			t.Fatalf("Failed to create test image: %v", err)
+		}
+		entries = append(entries, SlideEntry{Path: imgPath, DurationMs: 300})
+	}
+
+	// Slides loaded again when encoded render the same video
+	expectedPath := filepath.Join(tmpDir, "expected.webm")
+	if err := Slideshow(entries, expectedPath, ContainerWebM, CodecAV1, 50, "", WithThreads(1)); err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+	outputPath := filepath.Join(tmpDir, "output.webm")
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithThreads(1), WithMemoryBudget(512))
+	if err != nil {
+		t.Fatalf("Slideshow with a memory budget failed: %v", err)
+	}
+	expected, err := os.ReadFile(expectedPath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	output, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !bytes.Equal(output, expected) {
+		t.Error("Output with a memory budget differs")
+	}
+}
+
+func TestSession(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{200, 80, 40, 255}, 300, "closed.webm")
+	tmpDir := filepath.Dir(outputPath)
+
+	session := NewSession()
+	defer session.Close()
+	// Calls of a session may run at once
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			outputPath := filepath.Join(tmpDir, fmt.Sprintf("output%d.webm", i))
+			errs[i] = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithSession(session))
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Slideshow %d with a session failed: %v", i, err)
+		}
+	}
+
+	// A closed session starts encoders as usual
+	session.Close()
+	if err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithSession(session)); err != nil {
+		t.Errorf("Slideshow with a closed session failed: %v", err)
+	}
+}
+
+func TestSlideshowBatch(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imgPath := filepath.Join(tmpDir, "slide.png")
+	if err := createTestImage(imgPath, 160, 120, color.RGBA{40, 120, 200, 255}); err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+	var jobs []SlideshowJob
+	for i := 0; i < 5; i++ {
+		path := imgPath
+		if i == 3 {
+			path = filepath.Join(tmpDir, "missing.png")
+		}
+		jobs = append(jobs, SlideshowJob{
+			Entries:    []SlideEntry{{Path: path, DurationMs: 300}},
+			OutputPath: filepath.Join(tmpDir, fmt.Sprintf("output%d.webm", i)),
+			Container:  ContainerWebM,
+			Codec:      CodecAV1,
+			Quality:    50,
+		})
+	}
+
+	results, err := SlideshowBatch(jobs, 2)
+	if len(results) != len(jobs) {
+		t.Fatalf("Expected %d results, got %d", len(jobs), len(results))
+	}
+	for i, result := range results {
+		if result.OutputPath != jobs[i].OutputPath {
+			t.Errorf("Result %d is of %s", i, result.OutputPath)
+		}
+		if (result.Err != nil) != (i == 3) {
+			t.Errorf("Job %d: unexpected error %v", i, result.Err)
+		}
+		if result.Err == nil && !verifyWebMHeader(result.OutputPath) {
+			t.Errorf("Output %d is not a valid WebM", i)
+		}
+	}
+	// The error names the failed job and keeps its kind
+	var mErr *Error
+	if !errors.As(err, &mErr) || !strings.Contains(err.Error(), "job 3") {
+		t.Errorf("Expected the error of job 3, got %v", err)
+	}
+
+	results, err = SlideshowBatch(nil, 0)
+	if len(results) != 0 || err != nil {
+		t.Errorf("Expected no results for no jobs, got %v, %v", results, err)
+	}
+}
+
+func TestSlideshowPriority(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{80, 160, 40, 255}, 300, "output.webm")
+
+	for _, priority := range []Priority{PriorityLow, PriorityIdle} {
+		if err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithPriority(priority)); err != nil {
+			t.Fatalf("Slideshow with priority %d failed: %v", priority, err)
+		}
+		if !verifyWebMHeader(outputPath) {
+			t.Error("Output is not a valid WebM")
+		}
+	}
+}
+
+func TestSlideshowTempDir(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	scratch := filepath.Join(tmpDir, "scratch")
+	if err := os.Mkdir(scratch, 0o755); err != nil {
+		t.Fatalf("Failed to create scratch dir: %v", err)
+	}
+	imgPath := filepath.Join(tmpDir, "slide.png")
+	if err := createTestImage(imgPath, 160, 120, color.RGBA{40, 80, 160, 255}); err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+	entries := []SlideEntry{{Path: imgPath, DurationMs: 300}}
+	outputPath := filepath.Join(tmpDir, "output.webm")
+
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithTempDir(filepath.Join(tmpDir, "missing")))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for a missing directory, got %v", err)
+	}
+
+	if err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithTempDir(scratch)); err != nil {
+		t.Fatalf("Slideshow with a temp dir failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Error("Output is not a valid WebM")
+	}
+	if files, _ := os.ReadDir(scratch); len(files) != 0 {
+		t.Errorf("Temporary files left behind: %v", files)
+	}
+}
+
+func TestVideoWriter(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	outputPath := filepath.Join(tmpDir, "output.webm")
+	w, err := OpenVideoWriter(outputPath, 64, 48, 10, ContainerWebM, CodecAV1, 50, "")
+	if err != nil {
+		t.Fatalf("OpenVideoWriter failed: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		// RGBA images are converted; NRGBA images are used as they are
+		var img draw.Image = image.NewRGBA(image.Rect(0, 0, 64, 48))
+		if i%2 == 1 {
+			img = image.NewNRGBA(image.Rect(0, 0, 64, 48))
+		}
+		draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{uint8(i * 20), 128, 200, 255}}, image.Point{}, draw.Src)
+		if err := w.WriteFrame(img); err != nil {
+			t.Fatalf("WriteFrame %d failed: %v", i, err)
+		}
+	}
+	if err := w.WriteFrame(image.NewNRGBA(image.Rect(0, 0, 32, 32))); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for a frame of another size, got %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Error("Output is not a valid WebM")
+	}
+	if err := w.Close(); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput closing twice, got %v", err)
+	}
+
+	if _, err := OpenVideoWriter(outputPath, 63, 48, 10, ContainerWebM, CodecAV1, 50, ""); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for an odd width, got %v", err)
+	}
+
+	// A discarded writer writes nothing
+	discardedPath := filepath.Join(tmpDir, "discarded.webm")
+	w, err = OpenVideoWriter(discardedPath, 64, 48, 0, ContainerWebM, CodecAV1, 50, "")
+	if err != nil {
+		t.Fatalf("OpenVideoWriter failed: %v", err)
+	}
+	if err := w.WriteRGBA(make([]byte, 64*48*4)); err != nil {
+		t.Fatalf("WriteRGBA failed: %v", err)
+	}
+	w.Discard()
+	if _, err := os.Stat(discardedPath); !os.IsNotExist(err) {
+		t.Errorf("Discarded writer left an output: %v", err)
+	}
+}
+
+func TestVideoWriterAudio(t *testing.T) {
+	if !ffmpegAvailable() || Available(CodecAV1, "") != nil {
+		t.Skip("ffmpeg not available")
+	}
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	outputPath := filepath.Join(tmpDir, "output.webm")
+	w, err := OpenVideoWriter(outputPath, 64, 48, 10, ContainerWebM, CodecAV1, 50, "")
+	if err != nil {
+		t.Fatalf("OpenVideoWriter failed: %v", err)
+	}
+	defer w.Discard()
+	if err := w.WriteAudio(make([]int16, 3), 48000, 2); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for samples not filling every channel, got %v", err)
+	}
+	// A second of a 440 Hz mono tone with ten frames
+	for i := 0; i < 10; i++ {
+		if err := w.WriteRGBA(make([]byte, 64*48*4)); err != nil {
+			t.Fatalf("WriteRGBA %d failed: %v", i, err)
+		}
+		samples := make([]int16, 4800)
+		for n := range samples {
+			samples[n] = int16(8000 * math.Sin(2*math.Pi*440*float64(i*4800+n)/48000))
+		}
+		if err := w.WriteAudio(samples, 48000, 1); err != nil {
+			t.Fatalf("WriteAudio %d failed: %v", i, err)
+		}
+	}
+	if err := w.WriteAudio(make([]int16, 10), 44100, 1); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for another sample rate, got %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Error("Output is not a valid WebM")
+	}
+}
+
+func TestVideoReader(t *testing.T) {
+	if !ffmpegAvailable() || Available(CodecAV1, "") != nil {
+		t.Skip("ffmpeg not available")
+	}
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	videoPath := filepath.Join(tmpDir, "video.webm")
+	w, err := OpenVideoWriter(videoPath, 64, 48, 10, ContainerWebM, CodecAV1, 50, "")
+	if err != nil {
+		t.Fatalf("OpenVideoWriter failed: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		img := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+		draw.Draw(img, img.Bounds(), &image.Uniform{color.Gray{uint8(20 + i*20)}}, image.Point{}, draw.Src)
+		if err := w.WriteFrame(img); err != nil {
+			t.Fatalf("WriteFrame %d failed: %v", i, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	r, err := OpenVideoReader(videoPath, 0, "")
+	if err != nil {
+		t.Fatalf("OpenVideoReader failed: %v", err)
+	}
+	defer r.Close()
+	if r.Bounds() != image.Rect(0, 0, 64, 48) {
+		t.Errorf("Unexpected bounds %v", r.Bounds())
+	}
+	var frames []VideoFrame
+	for {
+		frame, err := r.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadFrame failed: %v", err)
+		}
+		frames = append(frames, frame)
+	}
+	if len(frames) != 10 {
+		t.Fatalf("Expected 10 frames, got %d", len(frames))
+	}
+	if frames[5].Time != 500*time.Millisecond {
+		t.Errorf("Expected the sixth frame at 500ms, got %v", frames[5].Time)
+	}
+	if first, last := frames[0].Image.NRGBAAt(32, 24).R, frames[9].Image.NRGBAAt(32, 24).R; first >= last {
+		t.Errorf("Expected frames to grow brighter, got %d then %d", first, last)
+	}
+
+	if _, err := OpenVideoReader(filepath.Join(tmpDir, "missing.webm"), 0, ""); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for a missing video, got %v", err)
+	}
+}
+
+func TestSlideshowLogger(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{40, 160, 80, 255}, 300, "output.webm")
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+		WithLogger(logger))
+	if err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+
+	for _, want := range []string{
+		`"msg":"stage started","stage":"encode","width":320,"height":240`,
+		`"msg":"encoder chosen","codec":"Av1","encoder":"rav1e"`,
+		`"msg":"job finished"`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Expected %s in the log:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestSlideshowWarnings(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imgPath := filepath.Join(tmpDir, "slide.png")
+	if err := createTestImage(imgPath, 321, 241, color.RGBA{40, 160, 80, 255}); err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+	entries := []SlideEntry{{Path: imgPath, DurationMs: 300}}
+
+	var mu sync.Mutex
+	var warnings []Warning
+	err = Slideshow(entries, filepath.Join(tmpDir, "output.webm"), ContainerWebM, CodecAV1, 50, "",
+		WithWarnings(func(w Warning) {
+			mu.Lock()
+			defer mu.Unlock()
+			warnings = append(warnings, w)
+		}))
+	if err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+
+	if len(warnings) != 1 || warnings[0].Kind != WarningSizeRounded {
+		t.Fatalf("Expected a size rounded warning, got %+v", warnings)
+	}
+	if !strings.Contains(warnings[0].Message, "321x241 rounded down to 320x240") {
+		t.Errorf("Unexpected warning message: %s", warnings[0].Message)
+	}
+}
+
+func TestSlideshowDeterministic(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{200, 120, 40, 255}, 500, "output.webm")
+	tmpDir := filepath.Dir(outputPath)
+
+	var outputs [][]byte
+	for i := 0; i < 2; i++ {
+		err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+			WithReproducibility(ReproducibilityDeterministic))
+		if err != nil {
+			t.Fatalf("Slideshow failed: %v", err)
+		}
+		data, err := os.ReadFile(outputPath)
+		if err != nil {
+			t.Fatalf("Failed to read output: %v", err)
+		}
+		outputs = append(outputs, data)
+	}
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Error("Deterministic outputs differ")
+	}
+
+	// A time budget depends on the speed of the host
+	err := Slideshow(entries, filepath.Join(tmpDir, "budget.webm"), ContainerWebM, CodecAV1, 50, "",
+		WithReproducibility(ReproducibilityDeterministic), WithTimeBudget(time.Second))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput with a time budget, got %v", err)
+	}
+}
+
+func TestSlideshowChapters(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imgPath := filepath.Join(tmpDir, "slide.png")
+	if err := createTestImage(imgPath, 320, 240, color.RGBA{40, 120, 200, 255}); err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+	entries := []SlideEntry{
+		{Path: imgPath, DurationMs: 500, Caption: "Introduction"},
+		{Path: imgPath, DurationMs: 500},
+	}
+
+	outputPath := filepath.Join(tmpDir, "slides.mkv")
+	err = Slideshow(entries, outputPath, ContainerMKV, CodecAV1, 50, "", WithSlideChapters())
+	if err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	for _, title := range []string{"Introduction", "Slide 2"} {
+		if !bytes.Contains(data, []byte(title)) {
+			t.Errorf("Output has no chapter %q", title)
+		}
+	}
+
+	// Listed chapters must be in order
+	err = Slideshow(entries, filepath.Join(tmpDir, "unordered.mkv"), ContainerMKV, CodecAV1, 50, "",
+		WithChapters(Chapter{Start: time.Second, Title: "Late"}, Chapter{Title: "Early"}))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput with unordered chapters, got %v", err)
+	}
+}
+
+func TestSlideshowHDR10(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{200, 160, 40, 255}, 500, "output.webm")
+
+	err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+		WithBitDepth(BitDepth10), WithDynamicRange(DynamicRangeHDR10), WithMaxLuminance(4000))
+	if err != nil {
+		t.Fatalf("Slideshow with HDR10 failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Error("Output is not a valid WebM")
+	}
+	expectVideo(t, outputPath, map[string]string{
+		"pix_fmt":         "yuv420p10le",
+		"color_transfer":  "smpte2084",
+		"color_primaries": "bt2020",
+	})
+
+	// HDR10 is 10-bit only
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+		WithDynamicRange(DynamicRangeHDR10))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for 8-bit HDR10, got %v", err)
+	}
+
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+		WithBitDepth(BitDepth10), WithDynamicRange(DynamicRangeHDR10), WithMaxLuminance(100))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for a mastering peak below 400 cd/m², got %v", err)
+	}
+}
+
+func TestSlideshowTwoPass(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{255, 128, 0, 255}, 500, "output.webm")
+
+	// Only VP9 and VP8 have two passes
+	err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithPasses(PassesTwo))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for two-pass AV1, got %v", err)
+	}
+
+	if Available(CodecVP9, "") != nil {
+		t.Skip("ffmpeg with libvpx-vp9 not available")
+	}
+	if err := Slideshow(entries, outputPath, ContainerWebM, CodecVP9, 50, "", WithPasses(PassesTwo)); err != nil {
+		t.Fatalf("Two-pass slideshow failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+	if matches, _ := filepath.Glob(outputPath + ".pass*"); len(matches) > 0 {
+		t.Errorf("Statistics files left behind: %v", matches)
+	}
+}
+
+func TestSlideshowFromZip(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imgPath := filepath.Join(tmpDir, "slide.png")
+	if err := createTestImage(imgPath, 320, 240, color.RGBA{255, 128, 0, 255}); err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+	img, err := os.ReadFile(imgPath)
+	if err != nil {
+		t.Fatalf("Failed to read test image: %v", err)
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range []string{"photos/img10.PNG", "photos/img2.png", "notes.txt", "__MACOSX/photos/._img2.png", "photos/img1.png"} {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to add %s: %v", name, err)
+		}
+		f.Write(img)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to write zip: %v", err)
+	}
+	archive := bytes.NewReader(buf.Bytes())
+
+	// Natural order, with a duration for one slide
+	slides := ZipSlides{DurationMs: 400, Durations: map[string]uint32{"photos/img2.png": 600}}
+	dir := filepath.Join(tmpDir, "extracted")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	entries, err := SlideEntriesFromZip(archive, archive.Size(), dir, slides)
+	if err != nil {
+		t.Fatalf("SlideEntriesFromZip failed: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 slides, got %d", len(entries))
+	}
+	durations := []uint32{entries[0].DurationMs, entries[1].DurationMs, entries[2].DurationMs}
+	if durations[0] != 400 || durations[1] != 600 || durations[2] != 400 {
+		t.Errorf("Unexpected durations %v", durations)
+	}
+
+	// Byte order puts img10 before img2
+	slides.Order = ZipOrderName
+	slides.DurationMs = 0
+	entries, err = SlideEntriesFromZip(archive, archive.Size(), dir, slides)
+	if err != nil {
+		t.Fatalf("SlideEntriesFromZip failed: %v", err)
+	}
+	if entries[1].DurationMs != DefaultZipDurationMs || entries[2].DurationMs != 600 {
+		t.Errorf("Unexpected slides %+v", entries)
+	}
+	if filepath.Ext(entries[1].Path) != ".png" {
+		t.Errorf("Extension not normalized: %s", entries[1].Path)
+	}
+
+	_, err = SlideEntriesFromZip(archive, archive.Size(), dir, ZipSlides{Durations: map[string]uint32{"missing.png": 1}})
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for unknown entry, got %v", err)
+	}
+	_, err = SlideEntriesFromZip(bytes.NewReader(img), int64(len(img)), dir, slides)
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for non-zip input, got %v", err)
+	}
+
+	if !naturalLess("img2.png", "img10.png") || naturalLess("img10.png", "img2.png") || !naturalLess("a01", "a1b") {
+		t.Error("Unexpected natural order")
+	}
+
+	zipPath := filepath.Join(tmpDir, "photos.zip")
+	if err := os.WriteFile(zipPath, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("Failed to write zip: %v", err)
+	}
+	outputPath := filepath.Join(tmpDir, "output.webm")
+	if err := SlideshowFromZip(zipPath, ZipSlides{DurationMs: 300}, outputPath, ContainerWebM, CodecAV1, 50, ""); err != nil {
+		t.Fatalf("SlideshowFromZip failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+}
+
+func TestSlideshowFromPresentation(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dir := filepath.Join(tmpDir, "deck")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	for _, name := range []string{"Slide10.PNG", "Slide2.png", "Slide1.png"} {
+		if err := createTestImage(filepath.Join(dir, name), 320, 240, color.RGBA{0, 128, 255, 255}); err != nil {
+			t.Fatalf("Failed to create test image: %v", err)
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "cover.png"), nil, 0o644)
+	os.WriteFile(filepath.Join(dir, "Slide3.txt"), nil, 0o644)
+
+	jsonPath := filepath.Join(tmpDir, "notes.json")
+	os.WriteFile(jsonPath, []byte(`[{"slide": 2, "duration_ms": 400, "caption": "Agenda"}, {"slide": 10, "audio": "audio/10.wav"}]`), 0o644)
+	entries, err := SlideEntriesFromPresentation(dir, jsonPath)
+	if err != nil {
+		t.Fatalf("SlideEntriesFromPresentation failed: %v", err)
+	}
+	if len(entries) != 3 || filepath.Base(entries[2].Path) != "Slide10.PNG" {
+		t.Fatalf("Unexpected slides %+v", entries)
+	}
+	if entries[0].DurationMs != DefaultPresentationDurationMs || entries[1].DurationMs != 400 || entries[1].Caption != "Agenda" {
+		t.Errorf("Unexpected slides %+v", entries)
+	}
+	if entries[2].DurationMs != 0 || entries[2].Audio != filepath.Join(tmpDir, "audio", "10.wav") {
+		t.Errorf("Narrated slide should last as long as its narration: %+v", entries[2])
+	}
+
+	csvPath := filepath.Join(tmpDir, "notes.csv")
+	os.WriteFile(csvPath, []byte("\ufeffSlide,Caption,Duration_ms\n1,\"Hello, world\",500\n2,,\n"), 0o644)
+	entries, err = SlideEntriesFromPresentation(dir, csvPath)
+	if err != nil {
+		t.Fatalf("SlideEntriesFromPresentation failed: %v", err)
+	}
+	if entries[0].Caption != "Hello, world" || entries[0].DurationMs != 500 || entries[1].DurationMs != DefaultPresentationDurationMs {
+		t.Errorf("Unexpected slides %+v", entries)
+	}
+
+	for name, notes := range map[string]string{
+		"missing.json": `[{"slide": 3}]`,
+		"twice.json":   `[{"slide": 1}, {"slide": 1}]`,
+		"invalid.csv":  "slide,duration_ms\n1,soon\n",
+		"nocolumn.csv": "caption\nHello\n",
+		"notes.txt":    "",
+	} {
+		notesPath := filepath.Join(tmpDir, name)
+		os.WriteFile(notesPath, []byte(notes), 0o644)
+		if _, err := SlideEntriesFromPresentation(dir, notesPath); !errors.Is(err, ErrInvalidInput) {
+			t.Errorf("Expected ErrInvalidInput for %s, got %v", name, err)
+		}
+	}
+	if _, err := SlideEntriesFromPresentation(tmpDir, ""); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput without numbered images, got %v", err)
+	}
+
+	outputPath := filepath.Join(tmpDir, "output.webm")
+	if err := SlideshowFromPresentation(dir, csvPath, outputPath, ContainerWebM, CodecAV1, 50, ""); err != nil {
+		t.Fatalf("SlideshowFromPresentation failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+}
+
+func TestSlideshowTransparency(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.NRGBA{0, 0, 255, 128}, 300, "output.webm")
+
+	// AV1 has no alpha channel support
+	err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+		WithTransparency(TransparencyKeep))
+	if err == nil {
+		t.Fatal("Expected an error for transparent AV1 output")
+	}
+
+	if Available(CodecVP9, "") != nil {
+		t.Skip("ffmpeg with libvpx-vp9 not available")
+	}
+
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecVP9, 50, "",
+		WithTransparency(TransparencyKeep))
+	if err != nil {
+		t.Fatalf("Transparent slideshow failed: %v", err)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+}
+
+func TestSlideshowBackgroundMusic(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imgPath := filepath.Join(tmpDir, "slide.png")
+	if err := createTestImage(imgPath, 320, 240, color.RGBA{0, 128, 0, 255}); err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+	musicPath := filepath.Join(tmpDir, "music.wav")
+	if err := createTestWAV(musicPath, 500); err != nil {
+		t.Fatalf("Failed to create test audio: %v", err)
+	}
+	entries := []SlideEntry{{Path: imgPath, DurationMs: 1000}}
+
+	// Containers without audio are rejected
+	err = Slideshow(entries, filepath.Join(tmpDir, "music.png"), ContainerAPNG, CodecPNG, 50, "",
+		WithBackgroundMusic(musicPath))
+	if err == nil {
+		t.Error("APNG with background music should fail")
+	}
+
+	err = Slideshow(entries, filepath.Join(tmpDir, "music.webm"), ContainerWebM, CodecAV1, 50, "",
+		WithBackgroundMusic(musicPath), WithLoudness(3))
+	if err == nil {
+		t.Error("Positive loudness should fail")
+	}
+
+	if !ffmpegAvailable() {
+		t.Skip("ffmpeg not available")
+	}
+
+	outputPath := filepath.Join(tmpDir, "music.webm")
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "",
+		WithBackgroundMusic(musicPath), WithLoudness(-16))
+	if err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+}
+
+func TestSlideshowNarration(t *testing.T) {
+	if !ffmpegAvailable() {
+		t.Skip("ffmpeg not available")
+	}
+
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	entries := make([]SlideEntry, 2)
+	for i := range entries {
+		imgPath := filepath.Join(tmpDir, fmt.Sprintf("slide_%d.png", i))
+		if err := createTestImage(imgPath, 320, 240, color.RGBA{128, 0, 0, 255}); err != nil {
+			t.Fatalf("Failed to create test image: %v", err)
+		}
+		audioPath := filepath.Join(tmpDir, fmt.Sprintf("narration_%d.wav", i))
+		if err := createTestWAV(audioPath, 600); err != nil {
+			t.Fatalf("Failed to create test audio: %v", err)
+		}
+		entries[i] = SlideEntry{Path: imgPath, Audio: audioPath}
+	}
+
+	outputPath := filepath.Join(tmpDir, "narration.webm")
+	if err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, ""); err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+}
+
+func TestReplaceAudio(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imgPath := filepath.Join(tmpDir, "slide.png")
+	if err := createTestImage(imgPath, 320, 240, color.RGBA{0, 0, 128, 255}); err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+	videoPath := filepath.Join(tmpDir, "video.webm")
+	entries := []SlideEntry{{Path: imgPath, DurationMs: 1500}}
+	if err := Slideshow(entries, videoPath, ContainerWebM, CodecAV1, 50, ""); err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+
+	outputPath := filepath.Join(tmpDir, "output.webm")
+	missingPath := filepath.Join(tmpDir, "missing.wav")
+	if err := ReplaceAudio(videoPath, missingPath, outputPath, ""); err == nil {
+		t.Error("Replacing audio with a missing file should fail")
+	}
+
+	if !ffmpegAvailable() {
+		t.Skip("ffmpeg not available")
+	}
+
+	audioPath := filepath.Join(tmpDir, "audio.wav")
+	if err := createTestWAV(audioPath, 500); err != nil {
+		t.Fatalf("Failed to create test audio: %v", err)
+	}
+	if err := ReplaceAudio(videoPath, audioPath, outputPath, "", WithAudioFit(AudioFitLoop)); err != nil {
+		t.Fatalf("ReplaceAudio failed: %v", err)
+	}
+
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+}
+
+func TestRemoveAudio(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	outputPath := filepath.Join(tmpDir, "output.webm")
+	if err := RemoveAudio(filepath.Join(tmpDir, "missing.webm"), outputPath, ""); err == nil {
+		t.Error("Removing audio from a missing video should fail")
+	}
+
+	if !ffmpegAvailable() {
+		t.Skip("ffmpeg not available")
+	}
+
+	imgPath := filepath.Join(tmpDir, "slide.png")
+	if err := createTestImage(imgPath, 320, 240, color.RGBA{0, 0, 128, 255}); err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+	audioPath := filepath.Join(tmpDir, "audio.wav")
+	if err := createTestWAV(audioPath, 500); err != nil {
+		t.Fatalf("Failed to create test audio: %v", err)
+	}
+	videoPath := filepath.Join(tmpDir, "video.webm")
+	entries := []SlideEntry{{Path: imgPath, DurationMs: 500}}
+	if err := Slideshow(entries, videoPath, ContainerWebM, CodecAV1, 50, "", WithBackgroundMusic(audioPath)); err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+
+	if err := RemoveAudio(videoPath, outputPath, ""); err != nil {
+		t.Fatalf("RemoveAudio failed: %v", err)
+	}
+
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+}
+
+func TestExtractAudio(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imgPath := filepath.Join(tmpDir, "slide.png")
+	if err := createTestImage(imgPath, 320, 240, color.RGBA{0, 0, 128, 255}); err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+	videoPath := filepath.Join(tmpDir, "video.webm")
+	entries := []SlideEntry{{Path: imgPath, DurationMs: 500}}
+	if err := Slideshow(entries, videoPath, ContainerWebM, CodecAV1, 50, ""); err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+
+	err = ExtractAudio(videoPath, filepath.Join(tmpDir, "audio.wav"), "", WithAudioCodec(AudioCodecMP3))
+	if err == nil {
+		t.Error("MP3 codec with a .wav file should fail")
+	}
+
+	if !ffmpegAvailable() {
+		t.Skip("ffmpeg not available")
+	}
+
+	audioPath := filepath.Join(tmpDir, "tone.wav")
+	if err := createTestWAV(audioPath, 500); err != nil {
+		t.Fatalf("Failed to create test audio: %v", err)
+	}
+	withAudioPath := filepath.Join(tmpDir, "with_audio.webm")
+	if err := ReplaceAudio(videoPath, audioPath, withAudioPath, ""); err != nil {
+		t.Fatalf("ReplaceAudio failed: %v", err)
+	}
+
+	outputPath := filepath.Join(tmpDir, "audio.m4a")
+	if err := ExtractAudio(withAudioPath, outputPath, "", WithAudioBitrate(96)); err != nil {
+		t.Fatalf("ExtractAudio failed: %v", err)
+	}
+
+	info, err := os.Stat(outputPath)
+	if err != nil || info.Size() == 0 {
+		t.Fatal("Extracted audio file is missing or empty")
+	}
+
+	fadedPath := filepath.Join(tmpDir, "faded.wav")
+	if err := ExtractAudio(withAudioPath, fadedPath, "", WithAudioGain(-6), WithAudioFade(100, 200)); err != nil {
+		t.Fatalf("ExtractAudio with gain and fades failed: %v", err)
+	}
+
+	err = ExtractAudio(withAudioPath, filepath.Join(tmpDir, "loud.wav"), "", WithAudioGain(40))
+	if err == nil {
+		t.Error("Gain above 20 dB should fail")
+	}
+}
+
+func TestAnalyzeLuma(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imgPath := filepath.Join(tmpDir, "dark.png")
+	if err := createTestImage(imgPath, 64, 48, color.RGBA{0, 0, 0, 255}); err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+
+	analysis, err := AnalyzeLuma(imgPath, "")
+	if err != nil {
+		t.Fatalf("AnalyzeLuma failed: %v", err)
+	}
+	if len(analysis.Frames) != 1 {
+		t.Errorf("Expected 1 frame, got %d", len(analysis.Frames))
+	}
+	if analysis.Aggregate.PixelCount != 64*48 {
+		t.Errorf("Unexpected pixel count %d", analysis.Aggregate.PixelCount)
+	}
+	if analysis.Aggregate.ShadowClip < 0.99 {
+		t.Errorf("Black image should have clipped shadows, got %f", analysis.Aggregate.ShadowClip)
+	}
+	if p := analysis.Aggregate.Percentile(0.5); p != 0 {
+		t.Errorf("Median luma of a black image should be 0, got %d", p)
+	}
+
+	if _, err := AnalyzeLuma(filepath.Join(tmpDir, "missing.png"), ""); err == nil {
+		t.Error("Analyzing a missing file should fail")
+	}
+}
+
+func TestFrameHashes(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imgPath := filepath.Join(tmpDir, "slide.png")
+	if err := createTestImage(imgPath, 64, 48, color.RGBA{255, 128, 0, 255}); err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+
+	hashes, err := FrameHashes(imgPath, 4, "")
+	if err != nil {
+		t.Fatalf("FrameHashes failed: %v", err)
+	}
+	if len(hashes) != 1 || hashes[0].Time != 0 {
+		t.Fatalf("Expected 1 hash at 0, got %+v", hashes)
+	}
+	if !hashes[0].Matches(hashes[0]) {
+		t.Error("A hash should match itself")
+	}
+
+	a, b := FrameHash{Hash: 0}, FrameHash{Hash: 0xFFFF}
+	if a.Distance(b) != 16 || a.Matches(b) {
+		t.Errorf("Unexpected distance %d", a.Distance(b))
+	}
+
+	if _, err := FrameHashes(imgPath, 0, ""); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for 0 frames, got %v", err)
+	}
+	if _, err := FrameHashes(filepath.Join(tmpDir, "missing.mp4"), 1, ""); err == nil {
+		t.Error("Hashing a missing file should fail")
+	}
+}
+
+func TestBenchmark(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	report, err := Benchmark(BenchmarkSpec{
+		Codecs:     []Codec{CodecPNG, CodecVP9},
+		Width:      64,
+		Height:     48,
+		Duration:   500 * time.Millisecond,
+		FPS:        10,
+		OutputDir:  tmpDir,
+		FfmpegPath: filepath.Join(tmpDir, "missing-ffmpeg"),
+	})
+	if err != nil {
+		t.Fatalf("Benchmark failed: %v", err)
+	}
+	if report.Frames != 5 || len(report.Results) != 2 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+
+	png := report.Results[0]
+	if png.Err != nil {
+		t.Fatalf("PNG benchmark failed: %v", png.Err)
+	}
+	if png.Container != ContainerAPNG || png.Frames != 5 || png.OutputBytes == 0 || png.EncodeFPS <= 0 {
+		t.Errorf("Unexpected PNG result %+v", png)
+	}
+	// Without ffmpeg the quality cannot be measured
+	if png.PSNR != -1 || png.SSIM != -1 {
+		t.Errorf("Expected no quality metrics, got %v dB, %v", png.PSNR, png.SSIM)
+	}
+	if report.Results[1].Err == nil {
+		t.Error("VP9 without ffmpeg should fail")
+	}
+
+	if _, err := Benchmark(BenchmarkSpec{Width: 33, Height: 32}); !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for an odd width, got %v", err)
+	}
+}
+
+func TestSlideshowWithinSize(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{0, 200, 100, 255}, 500, "output.webm")
+
+	settings, err := SlideshowWithinSize(entries, outputPath, ContainerWebM, CodecAV1, 50, EmailMaxBytes, "")
+	if err != nil {
+		t.Fatalf("SlideshowWithinSize failed: %v", err)
+	}
+	if settings.Quality != 50 || settings.Scale != 100 || settings.Width != 320 || settings.Height != 240 {
+		t.Errorf("Unexpected settings: %+v", settings)
+	}
+	info, err := os.Stat(outputPath)
+	if err != nil || uint64(info.Size()) != settings.Size {
+		t.Errorf("Output size does not match settings: %+v, %v", settings, err)
+	}
+
+	if _, err := SlideshowWithinSize(entries, outputPath, ContainerWebM, CodecAV1, 50, 1, ""); err == nil {
+		t.Error("An impossible size limit should fail")
+	}
+}
+
+func TestSlideshowTargetSize(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{0, 200, 100, 255}, 2000, "output.webm")
+
+	const target = 40000
+	if err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithTargetSize(target)); err != nil {
+		t.Fatalf("Target size slideshow failed: %v", err)
+	}
+	info, err := os.Stat(outputPath)
+	if err != nil || info.Size() > target*11/10 {
+		t.Errorf("Output is far over the target size: %v, %v", info, err)
+	}
+
+	// The target size sets the bitrate itself
+	err = Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithTargetSize(target), WithBitrate(200))
+	if !errors.Is(err, ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for a target size with a bitrate, got %v", err)
+	}
+}
+
+func TestSlideshowSkippingInvalid(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imgPath := filepath.Join(tmpDir, "slide.png")
+	if err := createTestImage(imgPath, 320, 240, color.RGBA{0, 200, 100, 255}); err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+	entries := []SlideEntry{
+		{Path: imgPath, DurationMs: 300},
+		{Path: filepath.Join(tmpDir, "missing.png"), DurationMs: 300},
+		{Path: imgPath, DurationMs: 300},
+	}
+
+	outputPath := filepath.Join(tmpDir, "output.webm")
+	skipped, err := SlideshowSkippingInvalid(entries, outputPath, ContainerWebM, CodecAV1, 50, "")
+	if err != nil {
+		t.Fatalf("SlideshowSkippingInvalid failed: %v", err)
+	}
+	if len(skipped) != 1 || skipped[0].Index != 1 {
+		t.Errorf("Unexpected skipped slides: %+v", skipped)
+	}
+	if !verifyWebMHeader(outputPath) {
+		t.Error("Output file is not a valid WebM")
+	}
+
+	if _, err := SlideshowSkippingInvalid(entries[1:2], outputPath, ContainerWebM, CodecAV1, 50, ""); err == nil {
+		t.Error("A slideshow without usable slides should fail")
+	}
+}
+
+func TestDedupeSlides(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	colors := []color.RGBA{{255, 0, 0, 255}, {255, 0, 0, 255}, {0, 0, 255, 255}}
+	var entries []SlideEntry
+	for i, c := range colors {
+		imgPath := filepath.Join(tmpDir, fmt.Sprintf("slide_%d.png", i))
+		if err := createTestImage(imgPath, 64, 48, c); err != nil {
+			t.Fatalf("Failed to create test image: %v", err)
+		}
+		entries = append(entries, SlideEntry{Path: imgPath, DurationMs: 500})
+	}
+
+	deduped, warnings, err := DedupeSlides(entries, DuplicateMatchExact)
+	if err != nil {
+		t.Fatalf("DedupeSlides failed: %v", err)
+	}
+	if len(deduped) != 2 || deduped[0].DurationMs != 1000 || deduped[1].DurationMs != 500 {
+		t.Errorf("Unexpected deduped slides: %+v", deduped)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("Expected 1 warning, got %v", warnings)
+	}
+
+	if _, _, err := DedupeSlides(nil, DuplicateMatchExact); err == nil {
+		t.Error("Deduping no slides should fail")
+	}
+}
+
+func TestPreviewSlideshow(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	colors := []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}}
+	var entries []SlideEntry
+	for i, c := range colors {
+		imgPath := filepath.Join(tmpDir, fmt.Sprintf("slide_%d.png", i))
+		if err := createTestImage(imgPath, 64, 48, c); err != nil {
+			t.Fatalf("Failed to create test image: %v", err)
+		}
+		entries = append(entries, SlideEntry{Path: imgPath, DurationMs: 500})
+	}
+
+	stripPath := filepath.Join(tmpDir, "deck.png")
+	if err := PreviewSlideshow(entries, stripPath, PreviewLayoutStrip, 24, ""); err != nil {
+		t.Fatalf("PreviewSlideshow failed: %v", err)
+	}
+	f, err := os.Open(stripPath)
+	if err != nil {
+		t.Fatalf("Failed to open preview: %v", err)
+	}
+	defer f.Close()
+	config, err := png.DecodeConfig(f)
+	if err != nil {
+		t.Fatalf("Preview is not a PNG: %v", err)
+	}
+	if config.Width != 96 || config.Height != 24 {
+		t.Errorf("Expected a 96x24 strip, got %dx%d", config.Width, config.Height)
+	}
+
+	if err := PreviewSlideshow(entries, stripPath, PreviewLayoutFiles, 0, ""); err != nil {
+		t.Fatalf("PreviewSlideshow with files failed: %v", err)
+	}
+	for i := range entries {
+		slidePath := filepath.Join(tmpDir, fmt.Sprintf("deck_%05d.png", i+1))
+		if _, err := os.Stat(slidePath); err != nil {
+			t.Errorf("Missing slide preview: %v", err)
+		}
+	}
+
+	if err := PreviewSlideshow(nil, stripPath, PreviewLayoutStrip, 0, ""); err == nil {
+		t.Error("Previewing no slides should fail")
+	}
+}
+
+func TestVisualizeAudio(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	audioPath := filepath.Join(tmpDir, "podcast.wav")
+	if err := createTestWAV(audioPath, 1000); err != nil {
+		t.Fatalf("Failed to create test audio: %v", err)
+	}
+
+	err = VisualizeAudio(audioPath, filepath.Join(tmpDir, "waveform.avif"), ContainerAVIF, CodecAV1, 50, Visualization{}, "")
+	if err == nil {
+		t.Error("AVIF output should fail")
+	}
+
+	if !ffmpegAvailable() {
+		t.Skip("ffmpeg not available")
+	}
+
+	outputPath := filepath.Join(tmpDir, "waveform.webm")
+	visual := Visualization{
+		Style:      VisualStyleSpectrum,
+		Width:      320,
+		Height:     180,
+		Color:      &Color{R: 255, G: 200, B: 0},
+		Background: &Color{R: 20, G: 20, B: 40},
+	}
+	if err := VisualizeAudio(audioPath, outputPath, ContainerWebM, CodecAV1, 50, visual, ""); err != nil {
+		t.Fatalf("VisualizeAudio failed: %v", err)
+	}
+
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+}
+
+func TestImageWithAudio(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "minmpeg-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	imgPath := filepath.Join(tmpDir, "cover.png")
+	if err := createTestImage(imgPath, 320, 240, color.RGBA{0, 128, 0, 255}); err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+	audioPath := filepath.Join(tmpDir, "song.wav")
+	if err := createTestWAV(audioPath, 800); err != nil {
+		t.Fatalf("Failed to create test audio: %v", err)
+	}
+
+	err = ImageWithAudio(imgPath, audioPath, filepath.Join(tmpDir, "song.apng"), ContainerAPNG, CodecPNG, 50, "")
+	if err == nil {
+		t.Error("APNG output should fail")
+	}
+
+	if !ffmpegAvailable() {
+		t.Skip("ffmpeg not available")
+	}
+
+	outputPath := filepath.Join(tmpDir, "song.webm")
+	if err := ImageWithAudio(imgPath, audioPath, outputPath, ContainerWebM, CodecAV1, 50, "", WithAudioFade(0, 200)); err != nil {
+		t.Fatalf("ImageWithAudio failed: %v", err)
+	}
+
+	if !verifyWebMHeader(outputPath) {
+		t.Fatal("Output file is not a valid WebM")
+	}
+}
+
+func TestRegisterFontInvalid(t *testing.T) {
+	if err := RegisterFont("broken", []byte{0, 1, 2, 3}); err == nil {
+		t.Error("Registering invalid font data should fail")
+	}
+	if err := RegisterFont("", []byte{0, 1, 2, 3}); err == nil {
+		t.Error("Registering a font without a name should fail")
+	}
+}
+
+func TestSystemFonts(t *testing.T) {
+	names, err := SystemFonts()
+	if err != nil {
+		t.Fatalf("SystemFonts failed: %v", err)
+	}
+	if !slices.IsSorted(names) {
+		t.Errorf("Expected sorted names, got %v", names)
+	}
+	t.Logf("%d system fonts", len(names))
+}
+
+func TestVersion(t *testing.T) {
+	version := Version()
+	if version == "" {
+		t.Error("Version should not be empty")
+	}
+	t.Logf("Library version: %s", version)
+}
+
+func TestRequireVersion(t *testing.T) {
+	if err := RequireVersion(""); err != nil {
+		t.Fatalf("The library should match the binding's ABI: %v", err)
+	}
+	if err := RequireVersion(">=0.1, " + Version()); err != nil {
+		t.Errorf("RequireVersion with the library version failed: %v", err)
+	}
+	if err := RequireVersion(">=999"); !errors.Is(err, ErrIncompatibleVersion) {
+		t.Errorf("Expected ErrIncompatibleVersion for a newer requirement, got %v", err)
+	}
+	err := RequireVersion("about 1")
+	if err == nil || errors.Is(err, ErrIncompatibleVersion) {
+		t.Errorf("Expected a constraint error, got %v", err)
+	}
+}
+
+func TestVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{"0.2", "0.2.5", true},
+		{"=0.2.1", "0.2.5", false},
+		{"!=0.2", "0.3.0", true},
+		{">=0.2.0, <0.4", "0.3.9", true},
+		{">=0.2.0, <0.4", "0.4.0", false},
+		{"^0.2.1", "0.2.9", true},
+		{"^0.2.1", "0.3.0", false},
+		{"^1.2", "1.9.0", true},
+		{"^0.0.3", "0.0.4", false},
+		{"~1.2", "1.2.7", true},
+		{"~1.2.3", "1.3.0", false},
+		{"> v1.0.0-beta", "1.0.1", true},
+	}
+	for _, tt := range tests {
+		clauses, err := parseConstraint(tt.constraint)
+		if err != nil {
+			t.Fatalf("parseConstraint(%q): %v", tt.constraint, err)
+		}
+		version, _, err := parseVersion(tt.version)
+		if err != nil {
+			t.Fatalf("parseVersion(%q): %v", tt.version, err)
+		}
+		got := true
+		for _, clause := range clauses {
+			got = got && clause.matches(version)
+		}
+		if got != tt.want {
+			t.Errorf("%q on %s = %v, want %v", tt.constraint, tt.version, got, tt.want)
+		}
+	}
+
+	for _, constraint := range []string{"=>1.0", "1.x", "1.2.3.4"} {
+		if _, err := parseConstraint(constraint); err == nil {
+			t.Errorf("parseConstraint(%q) should fail", constraint)
+		}
+	}
+}
+
+// countingLimiter records the jobs it admits, refusing all when refuse is set
+type countingLimiter struct {
+	mu       sync.Mutex
+	acquired []Job
+	released int
+	refuse   error
+}
+
+func (l *countingLimiter) Acquire(ctx context.Context, job Job) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.refuse != nil {
+		return l.refuse
+	}
+	l.acquired = append(l.acquired, job)
+	return nil
+}
+
+func (l *countingLimiter) Release(job Job) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.released++
+}
+
+func TestLimiter(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{40, 90, 200, 255}, 500, "output.webm")
+	tmpDir := filepath.Dir(outputPath)
+
+	limiter := &countingLimiter{}
+	if err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithLimiter(limiter)); err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+	want := Job{Operation: OperationSlideshow, Output: outputPath, Codec: CodecAV1, Container: ContainerWebM}
+	if len(limiter.acquired) != 1 || limiter.acquired[0] != want {
+		t.Errorf("Expected one acquired job %+v, got %+v", want, limiter.acquired)
+	}
+	if limiter.released != 1 {
+		t.Errorf("Expected 1 release, got %d", limiter.released)
+	}
+
+	// A refused job does not run and is not released
+	refused := errors.New("over budget")
+	limiter = &countingLimiter{refuse: refused}
+	SetLimiter(limiter)
+	defer SetLimiter(nil)
+	err := Slideshow(entries, filepath.Join(tmpDir, "refused.webm"), ContainerWebM, CodecAV1, 50, "")
+	if !errors.Is(err, refused) {
+		t.Errorf("Expected the limiter's error, got %v", err)
+	}
+	if limiter.released != 0 {
+		t.Errorf("Expected no release after a refused job, got %d", limiter.released)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "refused.webm")); !os.IsNotExist(err) {
+		t.Error("Refused job should not write output")
+	}
+
+	// WithLimiter(nil) opts a call out of the global limiter
+	if err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithLimiter(nil)); err != nil {
+		t.Errorf("Slideshow without limiter failed: %v", err)
+	}
+}
+
+func TestJobLimiter(t *testing.T) {
+	limiter := NewJobLimiter(1)
+	job := Job{Operation: OperationSlideshow}
+	if err := limiter.Acquire(context.Background(), job); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// The only slot is taken, so a second job waits until its context ends
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := limiter.Acquire(ctx, job); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	limiter.Release(job)
+	if err := limiter.Acquire(context.Background(), job); err != nil {
+		t.Errorf("Acquire after release failed: %v", err)
+	}
+	limiter.Release(job)
+}
+
+// recordingMetrics records the attempts it is told about
+type recordingMetrics struct {
+	mu       sync.Mutex
+	started  []Job
+	finished []JobStats
+}
+
+func (m *recordingMetrics) JobStarted(job Job) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.started = append(m.started, job)
+}
+
+func (m *recordingMetrics) JobFinished(job Job, stats JobStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.finished = append(m.finished, stats)
+}
+
+func TestMetrics(t *testing.T) {
+	entries, outputPath := slideFixture(t, color.RGBA{40, 90, 200, 255}, 500, "output.webm")
+	tmpDir := filepath.Dir(outputPath)
+
+	metrics := &recordingMetrics{}
+	if err := Slideshow(entries, outputPath, ContainerWebM, CodecAV1, 50, "", WithMetrics(metrics)); err != nil {
+		t.Fatalf("Slideshow failed: %v", err)
+	}
+	if len(metrics.started) != 1 || metrics.started[0].Operation != OperationSlideshow {
+		t.Fatalf("Expected one started slideshow, got %+v", metrics.started)
+	}
+	if len(metrics.finished) != 1 {
+		t.Fatalf("Expected one finished attempt, got %d", len(metrics.finished))
+	}
+	stats := metrics.finished[0]
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		t.Fatalf("Output not written: %v", err)
+	}
+	if stats.Err != nil || stats.OutputBytes != info.Size() || stats.Duration <= 0 {
+		t.Errorf("Unexpected stats %+v for a %d byte output", stats, info.Size())
+	}
+	if stats.Encoder != "rav1e" || stats.Hardware {
+		t.Errorf("Expected the rav1e software encoder, got %q (hardware %v)", stats.Encoder, stats.Hardware)
+	}
+
+	// A failed attempt is recorded with its error and no output
+	metrics = &recordingMetrics{}
+	SetMetrics(metrics)
+	defer SetMetrics(nil)
+	missing := []SlideEntry{{Path: filepath.Join(tmpDir, "missing.png"), DurationMs: 500}}
+	err = Slideshow(missing, filepath.Join(tmpDir, "failed.webm"), ContainerWebM, CodecAV1, 50, "")
+	if err == nil {
+		t.Fatal("Expected an error for a missing slide")
+	}
+	if len(metrics.finished) != 1 || metrics.finished[0].Err == nil || metrics.finished[0].OutputBytes != 0 {
+		t.Errorf("Expected one failed attempt, got %+v", metrics.finished)
+	}
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	metrics := NewExpvarMetrics("minmpeg_test")
+	job := Job{Operation: OperationSlideshow}
+	metrics.JobStarted(job)
+	metrics.JobFinished(job, JobStats{Duration: 3 * time.Second, OutputBytes: 1000, Encoder: "hevc_nvenc", Hardware: true})
+	metrics.JobStarted(job)
+	metrics.JobFinished(job, JobStats{Duration: 20 * time.Second, Err: errors.New("failed")})
+
+	vars := expvar.Get("minmpeg_test").(*expvar.Map)
+	for key, want := range map[string]string{
+		"started":      "2",
+		"succeeded":    "1",
+		"failed":       "1",
+		"output_bytes": "1000",
+		"hardware":     "1",
+	} {
+		if got := vars.Get(key).String(); got != want {
+			t.Errorf("Expected %s of %s, got %s", key, want, got)
+		}
+	}
+	durations := vars.Get("duration_seconds").(*expvar.Map)
+	for key, want := range map[string]string{"le_1": "<nil>", "le_5": "1", "le_60": "2", "le_inf": "2", "sum": "23"} {
+		got := "<nil>"
+		if v := durations.Get(key); v != nil {
+			got = v.String()
+		}
+		if got != want {
+			t.Errorf("Expected duration bucket %s of %s, got %s", key, want, got)
+		}
+	}
+	if got := vars.Get("encoder").(*expvar.Map).Get("hevc_nvenc").String(); got != "1" {
+		t.Errorf("Expected 1 hevc_nvenc encode, got %s", got)
 	}
-	t.Logf("Library version: %s", version)
 }
@@ -0,0 +1,969 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+#include <stdint.h>
+#include <stdlib.h>
+
+// Exported by fallback.go
+extern void minmpegFallback(ErrorCode code, char* message, void* user_data);
+
+// Exported by log.go
+extern void minmpegLog(LogLevel level, char* message, LogAttr* attrs, size_t attr_count, void* user_data);
+
+// Exported by warning.go
+extern void minmpegWarning(WarningKind kind, char* message, void* user_data);
+static FallbackCallback fallback_callback(void) {
+	return (FallbackCallback)minmpegFallback;
+}
+
+static LogCallback log_callback(void) {
+	return (LogCallback)minmpegLog;
+}
+
+static WarningCallback warning_callback(void) {
+	return (WarningCallback)minmpegWarning;
+}
+static void* handle_user_data(uintptr_t handle) {
+	return (void*)handle;
+}
+*/
+import "C"
+import (
+	"context"
+	"log/slog"
+	"runtime/cgo"
+	"time"
+	"unsafe"
+)
+
+// DefaultFPS is the frame rate of output when WithFrameRate is not used
+const DefaultFPS = C.MINMPEG_DEFAULT_FPS
+
+// MaxFPS is the highest frame rate accepted by WithFrameRate
+const MaxFPS = C.MINMPEG_MAX_FPS
+
+// Option configures optional parameters for Slideshow, Juxtapose and the audio functions
+type Option func(*options)
+
+// options holds the optional encoding parameters
+type options struct {
+	font              string
+	writingMode       WritingMode
+	captionAlign      TextAlign
+	captionFit        TextFit
+	captionMaxWidth   int
+	backgroundMusic   string
+	captionAnchor     Anchor
+	safeArea          int
+	slideNumber       string
+	slideNumberAnchor Anchor
+	logo              *Logo
+	decodeMode        DecodeMode
+	loudness          float64
+	toneMap           ToneMap
+	audioFit          AudioFit
+	audioCodec        AudioCodec
+	audioBitrate      int
+	audioGain         float64
+	audioFadeInMs     int
+	audioFadeOutMs    int
+	duckThreshold     float64
+	duckAmount        float64
+	enhance           int
+	slideFit          SlideFit
+	cropFocus         CropFocus
+	idleFrames        IdleFrames
+	timeBudget        time.Duration
+	compression       Compression
+	transparency      Transparency
+	hlsSegment        HLSSegment
+	segmentDuration   time.Duration
+	mp4Layout         MP4Layout
+	passes            Passes
+	bitrate           int
+	maxBitrate        int
+	bufferSize        int
+	outputMode        OutputMode
+	targetSize        uint64
+	fps               int
+	leftSubtitles     string
+	rightSubtitles    string
+	keyframeInterval  time.Duration
+	keyframeTimes     []time.Duration
+	pixelFormat       PixelFormat
+	bitDepth          BitDepth
+	dynamicRange      DynamicRange
+	maxLuminance      uint32
+	colorPrimaries    ColorPrimaries
+	colorTransfer     ColorTransfer
+	colorMatrix       ColorMatrix
+	colorRange        ColorRange
+	juxtaposeLayout   JuxtaposeLayout
+	hwAccel           HWAccel
+	hwFallback        HWFallback
+	onFallback        func(err error)
+	outputCheck       OutputCheck
+	reproducibility   Reproducibility
+	slideChapters     bool
+	chapters          []Chapter
+	threads           int
+	av1Backend        AV1Backend
+	av1Preset         int
+	h264Profile       H264Profile
+	h264Level         int
+	h264Preset        H264Preset
+	h264Tune          H264Tune
+	memoryBudget      int
+	session           *Session
+	priority          Priority
+	tempDir           string
+	ffmpegArgs        []string
+	retry             RetryPolicy
+	limiter           Limiter
+	limiterSet        bool
+	language          Language
+	languageSet       bool
+	ctx               context.Context
+	logger            *slog.Logger
+	onWarning         func(w Warning)
+	metrics           Metrics
+	metricsSet        bool
+	encoder           *encoderChoice
+}
+
+// WithFont selects the font used for captions.
+// The name can refer to a font registered with RegisterFont or a system font.
+func WithFont(name string) Option {
+	return func(o *options) {
+		o.font = name
+	}
+}
+
+// WithWritingMode selects horizontal or vertical captions
+func WithWritingMode(mode WritingMode) Option {
+	return func(o *options) {
+		o.writingMode = mode
+	}
+}
+
+// WithCaptionAlign aligns caption lines to the start, center or end
+func WithCaptionAlign(align TextAlign) Option {
+	return func(o *options) {
+		o.captionAlign = align
+	}
+}
+
+// WithCaptionFit selects how long captions are wrapped or shrunk to fit the frame
+func WithCaptionFit(fit TextFit) Option {
+	return func(o *options) {
+		o.captionFit = fit
+	}
+}
+
+// WithCaptionMaxWidth limits the caption width to a percentage (1-100) of the frame;
+// 0 or less keeps the default of 90. For vertical captions it limits the column height.
+func WithCaptionMaxWidth(percent int) Option {
+	return func(o *options) {
+		o.captionMaxWidth = percent
+	}
+}
+
+// WithCaptionAnchor places captions at the given position inside the safe area
+func WithCaptionAnchor(anchor Anchor) Option {
+	return func(o *options) {
+		o.captionAnchor = anchor
+	}
+}
+
+// WithSafeArea sets the margin kept free on each edge, in percent (1-40) of the frame size.
+// Overlays keep their relative position at any output resolution. 0 or less keeps
+// the default of 5.
+func WithSafeArea(percent int) Option {
+	return func(o *options) {
+		o.safeArea = percent
+	}
+}
+
+// WithBackgroundMusic adds an audio file (MP3, AAC, Opus, WAV, ...) as background music
+// to a slideshow. The music is trimmed or looped to the video length and faded out at the end.
+// Requires ffmpeg and an MP4 or WebM container.
+func WithBackgroundMusic(path string) Option {
+	return func(o *options) {
+		o.backgroundMusic = path
+	}
+}
+
+// WithSlideNumber draws the slide number on each slide (Slideshow only).
+// The format may contain {n} for the slide number and {total} for the
+// number of slides; an empty format uses "{n} / {total}".
+func WithSlideNumber(format string) Option {
+	return func(o *options) {
+		if format == "" {
+			format = "{n} / {total}"
+		}
+		o.slideNumber = format
+	}
+}
+
+// WithSlideNumberAnchor sets the slide number position (AnchorAuto for the
+// corner away from captions)
+func WithSlideNumberAnchor(anchor Anchor) Option {
+	return func(o *options) {
+		o.slideNumberAnchor = anchor
+	}
+}
+
+// WithLogo draws a logo over the video (Slideshow and Juxtapose)
+func WithLogo(logo Logo) Option {
+	return func(o *options) {
+		o.logo = &logo
+	}
+}
+
+// WithLoudness normalizes slideshow audio to a target loudness in LUFS
+// (-70 to -5, for example -16 for online video)
+func WithLoudness(lufs float64) Option {
+	return func(o *options) {
+		o.loudness = lufs
+	}
+}
+
+// WithDecodeMode selects how input videos are decoded (Juxtapose only).
+// DecodeModeSoftware disables hardware decoding.
+func WithDecodeMode(mode DecodeMode) Option {
+	return func(o *options) {
+		o.decodeMode = mode
+	}
+}
+
+// WithHWAccel selects the encoder of H.264, HEVC and AV1 video instead of the
+// platform's choice. HWAccelSoftware keeps the output independent of the GPU,
+// for reproducible CI output; a hardware API fails with ErrCodecUnavailable
+// when this machine or ffmpeg lacks it. Hardware encoders only encode lossy
+// 4:2:0 SDR video; AV1 has NVENC, Quick Sync and AMF encoders.
+func WithHWAccel(accel HWAccel) Option {
+	return func(o *options) {
+		o.hwAccel = accel
+	}
+}
+
+// WithHWFallback sets what happens when the hardware encoder of H.264, HEVC or
+// AV1 video is unavailable or fails during the encode, whether requested with
+// WithHWAccel or chosen by the platform. HWFallbackFail (the default) returns
+// its error; HWFallbackSoftware encodes the video again from the start with the
+// software encoder, as with HWAccelSoftware. warn, if not nil, is called with
+// the error of the hardware encoder before falling back, possibly from another
+// goroutine, e.g. to log or count fallbacks.
+func WithHWFallback(policy HWFallback, warn func(err error)) Option {
+	return func(o *options) {
+		o.hwFallback = policy
+		o.onFallback = warn
+	}
+}
+
+// WithOutputCheck sets whether the output is checked after rendering. With
+// OutputCheckProbe, the written file is probed with ffprobe (next to ffmpeg)
+// and the call fails with ErrOutputMismatch when its codec, frame size, frame
+// rate or duration differs from the job, e.g. after an ffmpeg or platform
+// upgrade changes what an encoder writes. The duration may differ by two
+// frames or 1%, whichever is larger, and the frame rate by 1%. Segmented, HLS
+// and DASH outputs cannot be checked.
+func WithOutputCheck(check OutputCheck) Option {
+	return func(o *options) {
+		o.outputCheck = check
+	}
+}
+
+// WithReproducibility sets whether the same inputs and options give
+// byte-identical output, for golden-file tests and content-addressed storage.
+// With ReproducibilityDeterministic, H.264, HEVC and AV1 use the software
+// encoders, the video encoder runs on four threads unless WithThreads is set,
+// so hosts with any number of cores agree, and ffmpeg writes no version or
+// random identifiers. Hardware encoders and WithTimeBudget fail with
+// ErrInvalidInput. Output still changes with the library, ffmpeg and encoder
+// versions.
+func WithReproducibility(r Reproducibility) Option {
+	return func(o *options) {
+		o.reproducibility = r
+	}
+}
+
+// WithChapters writes chapter markers into MP4, WebM and MKV output, so players
+// can jump between sections of the video. The chapters must be in order of
+// their start. Other containers, fragmented MP4 and segment output fail with
+// ErrInvalidInput.
+func WithChapters(chapters ...Chapter) Option {
+	return func(o *options) {
+		o.chapters = chapters
+	}
+}
+
+// WithSlideChapters writes one chapter marker per slide into MP4, WebM and MKV
+// output of a slideshow, titled with the first line of the slide's caption, or
+// "Slide N" for slides without one. It cannot be combined with WithChapters.
+func WithSlideChapters() Option {
+	return func(o *options) {
+		o.slideChapters = true
+	}
+}
+
+// WithThreads limits the video encoder to threads threads, so one call on a
+// shared server leaves cores for other work. The default (0) lets the encoder
+// choose, usually about one thread per core. Not honoured by the macOS and
+// Windows H.264 encoders. Slides are also decoded and scaled on this many
+// threads, one per core by default.
+func WithThreads(threads int) Option {
+	return func(o *options) {
+		o.threads = threads
+	}
+}
+
+// WithAV1Backend selects the software encoder of AV1 video and its speed
+// preset, from 1 (slowest, best quality) to 10 for rav1e, 13 for SVT-AV1 and 8
+// for libaom (its cpu-used); 0 keeps the default, picked by the time budget.
+// rav1e (the default) is built in; SVT-AV1 is much faster at a similar
+// quality, and libaom compresses best at its slow presets. SVT-AV1 and libaom
+// run in ffmpeg, which must have libsvtav1 or libaom-av1, and encode SDR
+// video only; SVT-AV1 takes lossy 4:2:0 video.
+func WithAV1Backend(backend AV1Backend, preset int) Option {
+	return func(o *options) {
+		o.av1Backend = backend
+		o.av1Preset = preset
+	}
+}
+
+// WithFFmpegArgs adds arguments to the ffmpeg command of the video encoder,
+// after the library's own so they override them, such as private options of
+// the codec (e.g. "-x265-params", "aq-mode=3") for settings without an option
+// yet. This is an unsupported escape hatch: the arguments may conflict with
+// those of the library, and ffmpeg may change their meaning between versions.
+// Encoders that do not run in ffmpeg (PNG, AV1 with rav1e, and H.264 on macOS
+// and Windows) fail with ErrInvalidInput.
+func WithFFmpegArgs(args ...string) Option {
+	return func(o *options) {
+		o.ffmpegArgs = args
+	}
+}
+
+// WithH264Profile sets the profile and level of H.264 video, for players and
+// devices that only decode some of them. The level is the level times ten (31
+// for level 3.1), or 0 for the encoder's choice from the size and frame rate;
+// VideoToolbox on macOS takes levels 3.0 to 5.2. Profiles other than
+// H264ProfileAuto take lossy 8-bit 4:2:0 video.
+func WithH264Profile(profile H264Profile, level int) Option {
+	return func(o *options) {
+		o.h264Profile = profile
+		o.h264Level = level
+	}
+}
+
+// WithH264Tuning sets the speed preset of libx264, overriding the one picked
+// by the time budget, and tunes it for the kind of content. Slideshows gain
+// much from H264TuneStillImage, which spends far fewer bits on frames that
+// barely change. Only the software encoder on Linux (libx264) takes these;
+// elsewhere they fail with ErrInvalidInput or ErrCodecUnavailable.
+func WithH264Tuning(preset H264Preset, tune H264Tune) Option {
+	return func(o *options) {
+		o.h264Preset = preset
+		o.h264Tune = tune
+	}
+}
+
+// WithMemoryBudget keeps the slide images within about megabytes of memory,
+// for small containers where large source photos would otherwise exhaust it.
+// Slides are decoded on as many threads as fit in half of the budget, an
+// image too large to decode within its share, or an animated WebP slide whose
+// frames do not fit in it, fails with an error instead, and Slideshow loads
+// each slide again when it encodes it rather than keeping them all in memory,
+// which takes longer. 0 sets no budget.
+//
+// The budget covers only the slide images. The frames being composed and
+// encoded, the encoders, the ffmpeg processes and the encoded video are not
+// counted against it. Encoded video is muxed as it comes, except by
+// SlideshowFunc, which keeps it until the last slide as the chapters and
+// audio are only known then.
+func WithMemoryBudget(megabytes int) Option {
+	return func(o *options) {
+		o.memoryBudget = megabytes
+	}
+}
+
+// WithPriority lowers the CPU and I/O priority of the call, so background video
+// generation does not starve other work on the host, such as a web server.
+// The call runs on a thread of its own, as lowering the calling thread could
+// not be undone. On Linux, PriorityLow sets nice 10 and the lowest best-effort
+// I/O priority, and PriorityIdle nice 19 and the idle I/O class; the encoder
+// threads and ffmpeg processes of the call inherit them. On macOS and Windows
+// both run the call's own thread in the background, while ffmpeg keeps the
+// normal priority. SlideshowFunc fails with ErrInvalidInput for lowered
+// priorities, as it calls next on the calling goroutine's thread.
+func WithPriority(priority Priority) Option {
+	return func(o *options) {
+		o.priority = priority
+	}
+}
+
+// WithTempDir writes the temporary files of the call, the statistics of
+// two-pass encodes and the audio given to VideoWriter.WriteAudio, to dir instead of next to the output, such as a scratch
+// volume when the output's is small. They are removed when the call ends,
+// whether it succeeds, fails or is cancelled; video passed to ffmpeg, as when
+// audio is added, goes through pipes instead. dir must exist, or the call
+// fails with ErrInvalidInput.
+func WithTempDir(dir string) Option {
+	return func(o *options) {
+		o.tempDir = dir
+	}
+}
+
+// WithJuxtaposeLayout selects how Juxtapose arranges its two videos. The
+// default is JuxtaposeLayoutHorizontal; JuxtaposeLayoutAuto places portrait
+// videos side by side and stacks landscape ones, whichever is closer to square.
+func WithJuxtaposeLayout(layout JuxtaposeLayout) Option {
+	return func(o *options) {
+		o.juxtaposeLayout = layout
+	}
+}
+
+// WithToneMap selects how HDR inputs (PQ/HLG videos, floating-point images)
+// are mapped to SDR. The default is ToneMapHable.
+func WithToneMap(op ToneMap) Option {
+	return func(o *options) {
+		o.toneMap = op
+	}
+}
+
+// WithAudioFit selects whether audio shorter than the video is looped (audio functions)
+func WithAudioFit(fit AudioFit) Option {
+	return func(o *options) {
+		o.audioFit = fit
+	}
+}
+
+// WithAudioCodec selects the codec of extracted audio (ExtractAudio)
+func WithAudioCodec(codec AudioCodec) Option {
+	return func(o *options) {
+		o.audioCodec = codec
+	}
+}
+
+// WithAudioBitrate sets the audio bitrate in kbit/s (ExtractAudio; ignored for WAV);
+// 0 or less keeps the encoder default.
+func WithAudioBitrate(kbps int) Option {
+	return func(o *options) {
+		o.audioBitrate = kbps
+	}
+}
+
+// WithAudioGain changes the audio volume by a gain in dB (-60 to 20).
+// Applies to slideshow audio, ReplaceAudio and ExtractAudio.
+func WithAudioGain(db float64) Option {
+	return func(o *options) {
+		o.audioGain = db
+	}
+}
+
+// WithAudioFade fades the audio in at the start and out at the end, in milliseconds.
+// Negative durations count as 0, no fade. Applies to slideshow audio,
+// ReplaceAudio and ExtractAudio.
+func WithAudioFade(inMs, outMs int) Option {
+	return func(o *options) {
+		o.audioFadeInMs = inMs
+		o.audioFadeOutMs = outMs
+	}
+}
+
+// WithDucking sets how background music is lowered under narration (Slideshow only).
+// Music is lowered by about amountDb (1-18, default 12) while narration is above
+// thresholdDb (-60 to -1 dBFS, default -30); 0 keeps the default.
+func WithDucking(thresholdDb, amountDb float64) Option {
+	return func(o *options) {
+		o.duckThreshold = thresholdDb
+		o.duckAmount = amountDb
+	}
+}
+
+// WithAutoEnhance corrects the white balance and stretches the contrast of each
+// slide (Slideshow only). The strength (0-100) blends the correction with the original;
+// values outside the range are clamped to it.
+func WithAutoEnhance(strength int) Option {
+	return func(o *options) {
+		o.enhance = strength
+	}
+}
+
+// WithSlideFit selects how slides with a different aspect ratio are fitted
+// to the output size (Slideshow only). The default is SlideFitStretch.
+func WithSlideFit(fit SlideFit) Option {
+	return func(o *options) {
+		o.slideFit = fit
+	}
+}
+
+// WithCropFocus selects where slides cropped by SlideFitCover keep their content.
+// CropFocusSalient keeps faces and detailed regions in frame instead of the center.
+func WithCropFocus(focus CropFocus) Option {
+	return func(o *options) {
+		o.cropFocus = focus
+	}
+}
+
+// WithIdleFrames selects how frames that repeat the previous frame are encoded.
+// IdleFramesMerge encodes a run of identical frames once, which makes mostly
+// static videos such as slideshows much smaller.
+func WithIdleFrames(mode IdleFrames) Option {
+	return func(o *options) {
+		o.idleFrames = mode
+	}
+}
+
+// WithTimeBudget asks for the encode to finish within d, for interactive previews.
+// Faster encoder presets, and for Slideshow a lower resolution, are chosen as needed.
+// The budget is a target based on typical encoder speeds rather than a guarantee.
+func WithTimeBudget(d time.Duration) Option {
+	return func(o *options) {
+		o.timeBudget = d
+	}
+}
+
+// WithCompression selects lossy or lossless encoding. CompressionLossless stores
+// frames without loss for archival masters and golden-file tests, ignoring the quality;
+// it is supported by AV1, H.264 on Linux, VP9 and PNG.
+func WithCompression(compression Compression) Option {
+	return func(o *options) {
+		o.compression = compression
+	}
+}
+
+// WithTransparency selects what happens to transparent pixels of slides.
+// TransparencyKeep produces video with an alpha channel that can be overlaid on
+// web pages; it is supported by VP9 and VP8 in WebM and by PNG in APNG, and slides
+// fitted with SlideFitContain are padded with transparent pixels.
+func WithTransparency(transparency Transparency) Option {
+	return func(o *options) {
+		o.transparency = transparency
+	}
+}
+
+// WithHLSSegment selects the segment format of ContainerHLS output: MPEG-TS
+// (the default), or fMP4, which also carries AV1.
+func WithHLSSegment(segment HLSSegment) Option {
+	return func(o *options) {
+		o.hlsSegment = segment
+	}
+}
+
+// WithSegmentDuration sets the target duration of streaming segments (6 seconds
+// by default). Segments start at keyframes, so they can run past the target.
+func WithSegmentDuration(d time.Duration) Option {
+	return func(o *options) {
+		o.segmentDuration = d
+	}
+}
+
+// WithMP4Layout selects the layout of ContainerMP4 output. The default,
+// MP4LayoutFaststart, puts the movie box before the media data so playback
+// can start while downloading. MP4LayoutFragmented writes an empty movie box
+// and then one fragment per keyframe interval as encoding goes, so the file
+// can be streamed before it is complete.
+func WithMP4Layout(layout MP4Layout) Option {
+	return func(o *options) {
+		o.mp4Layout = layout
+	}
+}
+
+// WithPasses sets the number of encoding passes of slideshows. PassesTwo
+// analyzes the whole video before encoding it, giving consistent quality
+// across slides of very different complexity at about twice the encoding time.
+// It requires CodecVP9 or CodecVP8.
+func WithPasses(passes Passes) Option {
+	return func(o *options) {
+		o.passes = passes
+	}
+}
+
+// WithBitrate encodes at an average video bitrate in kbit/s instead of the
+// quality; 0 or less keeps the quality. Not supported by CodecPNG,
+// CodecProRes and lossless compression.
+func WithBitrate(kbps int) Option {
+	return func(o *options) {
+		o.bitrate = kbps
+	}
+}
+
+// WithMaxBitrate limits the peak video bitrate in kbit/s, as measured by a
+// decoder buffer of bufferKbits (0 for two seconds at the peak), for players and
+// networks with a bandwidth ceiling; 0 or less sets no peak. Without
+// WithBitrate the quality still applies below the peak. Not supported by
+// CodecPNG, CodecProRes and lossless compression.
+func WithMaxBitrate(kbps, bufferKbits int) Option {
+	return func(o *options) {
+		o.maxBitrate = kbps
+		o.bufferSize = bufferKbits
+	}
+}
+
+// WithOutputMode selects whether the video is written as one file or as
+// segment files. OutputModeSegments writes standalone files of the segment
+// duration set by WithSegmentDuration (6 seconds by default), each starting
+// with a keyframe, named after the output path (name_00000.mp4), and a JSON
+// manifest listing them to the output path, for feeding an external packager.
+// It requires ContainerMP4, ContainerMOV, ContainerWebM or ContainerMKV and
+// does not support audio or IdleFramesMerge.
+func WithOutputMode(mode OutputMode) Option {
+	return func(o *options) {
+		o.outputMode = mode
+	}
+}
+
+// WithTargetSize aims for an output file of about bytes, such as 25 MB for a
+// chat upload limit. The bitrate is computed from the length of the video, with
+// room for audio and container overhead, and the video is encoded once with it
+// as average and peak, so the file usually lands a little under the target. Use
+// SlideshowWithinSize when the limit must never be exceeded. Cannot be combined
+// with WithBitrate or WithMaxBitrate, and not supported by CodecPNG,
+// CodecProRes and lossless compression.
+func WithTargetSize(bytes uint64) Option {
+	return func(o *options) {
+		o.targetSize = bytes
+	}
+}
+
+// WithFrameRate sets the output frame rate, such as 24, 30 or 60 fps (DefaultFPS
+// for 0 or less, up to MaxFPS). Slide durations are rounded to whole frames, and
+// juxtaposed videos are resampled to the rate.
+func WithFrameRate(fps int) Option {
+	return func(o *options) {
+		o.fps = fps
+	}
+}
+
+// WithSubtitles draws SRT or WebVTT subtitle files on the two sides of a
+// Juxtapose output, such as the transcripts of two speech-to-text models. Each
+// file is timed by its own side and shown while that video plays, laid out like
+// captions (WithCaptionAnchor and the other caption options apply). Pass "" to
+// leave a side without subtitles.
+func WithSubtitles(left, right string) Option {
+	return func(o *options) {
+		o.leftSubtitles = left
+		o.rightSubtitles = right
+	}
+}
+
+// WithKeyframeInterval places a keyframe every interval, such as every 2
+// seconds, for streaming packagers that cut segments at keyframes. By default
+// the encoder places keyframes itself, except in segment output
+// (WithOutputMode(OutputModeSegments)), where they are a segment apart; there
+// the interval must divide the segment duration. Not honoured by the Windows
+// H.264 encoder.
+func WithKeyframeInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.keyframeInterval = interval
+	}
+}
+
+// WithKeyframeTimes forces keyframes at the given times, rounded to the nearest
+// frame, besides those of the keyframe interval, such as at chapter starts or
+// ad breaks. Not honoured by the Windows H.264 encoder.
+func WithKeyframeTimes(times ...time.Duration) Option {
+	return func(o *options) {
+		o.keyframeTimes = times
+	}
+}
+
+// WithPixelFormat selects the chroma resolution of the encoded video.
+// PixelFormatYUV444 keeps colored text and thin lines of screenshots sharp;
+// PixelFormatYUV420, the default of most codecs, plays everywhere. VP8 only
+// encodes 4:2:0, and ProRes 4:2:2 or 4:4:4 (ProRes 4444). Lossless compression
+// needs 4:4:4 and transparency 4:2:0.
+func WithPixelFormat(format PixelFormat) Option {
+	return func(o *options) {
+		o.pixelFormat = format
+	}
+}
+
+// WithBitDepth selects the bits per sample of the encoded video. BitDepth10
+// keeps smooth gradients, such as slide backgrounds, from breaking into bands,
+// at the cost of players that only decode 8-bit video; it is meant for AV1 and
+// HEVC, and also applies to VP9 and H.264 on Linux. ProRes is always 10-bit.
+func WithBitDepth(depth BitDepth) Option {
+	return func(o *options) {
+		o.bitDepth = depth
+	}
+}
+
+// WithDynamicRange selects the dynamic range of the encoded video.
+// DynamicRangeHDR10 keeps the highlights of HDR photos and videos instead of
+// tone mapping them, tagging the output as PQ with BT.2020 primaries and
+// mastering display metadata; SDR images, captions and logos are placed at the
+// reference white of 203 cd/m². It needs AV1, VP9 or HEVC (with libx265) and
+// BitDepth10, and applies to Slideshow and Juxtapose.
+func WithDynamicRange(dynamicRange DynamicRange) Option {
+	return func(o *options) {
+		o.dynamicRange = dynamicRange
+	}
+}
+
+// WithMaxLuminance sets the mastering display peak of HDR10 output in cd/m²
+// (400 to 10000; 0 for the default of 1000). Brighter highlights are clipped.
+func WithMaxLuminance(nits uint32) Option {
+	return func(o *options) {
+		o.maxLuminance = nits
+	}
+}
+
+// WithColorSpace sets the color description of the encoded video: the matrix
+// and range converting the frames to YUV, and the primaries and transfer the
+// input images are in, which are tagged without converting the frames. Every
+// value is written into the stream and the container, so players convert the
+// video back the same way instead of guessing. Auto values take the defaults:
+// BT.709 in the limited range for video, and sRGB with the BT.601 matrix in
+// the full range for AVIF. Not available with HDR10, which sets its own, nor
+// for APNG and GIF.
+func WithColorSpace(primaries ColorPrimaries, transfer ColorTransfer, matrix ColorMatrix) Option {
+	return func(o *options) {
+		o.colorPrimaries = primaries
+		o.colorTransfer = transfer
+		o.colorMatrix = matrix
+	}
+}
+
+// WithColorRange sets the range of the YUV samples. ColorRangeFull keeps the
+// whole 0-255 range of the frames, but players ignoring the range tag show it
+// with crushed blacks and clipped whites; not available for ProRes and H.264
+// on macOS.
+func WithColorRange(colorRange ColorRange) Option {
+	return func(o *options) {
+		o.colorRange = colorRange
+	}
+}
+
+// newOptions applies the given options over the defaults
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// cParams converts the options to C encoding parameters.
+// The returned function frees the C memory and must be called after use.
+func (o *options) cParams() (*C.EncodeParams, func()) {
+	params := (*C.EncodeParams)(C.calloc(1, C.size_t(unsafe.Sizeof(C.EncodeParams{}))))
+	var allocs []unsafe.Pointer
+
+	if o.font != "" {
+		params.font = C.CString(o.font)
+		allocs = append(allocs, unsafe.Pointer(params.font))
+	}
+	if o.backgroundMusic != "" {
+		params.background_music = C.CString(o.backgroundMusic)
+		allocs = append(allocs, unsafe.Pointer(params.background_music))
+	}
+	if o.slideNumber != "" {
+		params.slide_number = C.CString(o.slideNumber)
+		allocs = append(allocs, unsafe.Pointer(params.slide_number))
+	}
+	if o.tempDir != "" {
+		params.temp_dir = C.CString(o.tempDir)
+		allocs = append(allocs, unsafe.Pointer(params.temp_dir))
+	}
+	if o.leftSubtitles != "" {
+		params.left_subtitles = C.CString(o.leftSubtitles)
+		allocs = append(allocs, unsafe.Pointer(params.left_subtitles))
+	}
+	if o.rightSubtitles != "" {
+		params.right_subtitles = C.CString(o.rightSubtitles)
+		allocs = append(allocs, unsafe.Pointer(params.right_subtitles))
+	}
+	params.writing_mode = C.WritingMode(o.writingMode)
+	params.caption_align = C.TextAlign(o.captionAlign)
+	params.caption_fit = C.TextFit(o.captionFit)
+	params.caption_max_width = C.uint32_t(max(o.captionMaxWidth, 0))
+	params.caption_anchor = C.Anchor(o.captionAnchor)
+	params.safe_area = C.uint32_t(max(o.safeArea, 0))
+	params.slide_number_anchor = C.Anchor(o.slideNumberAnchor)
+	params.decode_mode = C.DecodeMode(o.decodeMode)
+	params.loudness = C.float(o.loudness)
+	params.tone_map = C.ToneMap(o.toneMap)
+	params.audio_gain = C.float(o.audioGain)
+	params.audio_fade_in_ms = C.uint32_t(max(o.audioFadeInMs, 0))
+	params.audio_fade_out_ms = C.uint32_t(max(o.audioFadeOutMs, 0))
+	params.duck_threshold = C.float(o.duckThreshold)
+	params.duck_amount = C.float(o.duckAmount)
+	params.enhance = C.uint32_t(min(max(o.enhance, 0), 100))
+	params.slide_fit = C.SlideFit(o.slideFit)
+	params.crop_focus = C.CropFocus(o.cropFocus)
+	params.idle_frames = C.IdleFrames(o.idleFrames)
+	params.time_budget_ms = C.uint32_t(o.timeBudget.Milliseconds())
+	params.compression = C.Compression(o.compression)
+	params.transparency = C.Transparency(o.transparency)
+	params.hls_segment = C.HlsSegment(o.hlsSegment)
+	params.segment_duration_ms = C.uint32_t(o.segmentDuration.Milliseconds())
+	params.mp4_layout = C.Mp4Layout(o.mp4Layout)
+	params.passes = C.Passes(o.passes)
+	params.bitrate_kbps = C.uint32_t(max(o.bitrate, 0))
+	params.max_bitrate_kbps = C.uint32_t(max(o.maxBitrate, 0))
+	params.buffer_size_kbits = C.uint32_t(max(o.bufferSize, 0))
+	params.output_mode = C.OutputMode(o.outputMode)
+	params.target_size_bytes = C.uint64_t(o.targetSize)
+	params.fps = C.uint32_t(max(o.fps, 0))
+	params.keyframe_interval_ms = C.uint32_t(o.keyframeInterval.Milliseconds())
+	if n := len(o.keyframeTimes); n > 0 {
+		// The times live in C memory because the parameters point to them
+		times := (*C.uint64_t)(C.calloc(C.size_t(n), C.size_t(unsafe.Sizeof(C.uint64_t(0)))))
+		allocs = append(allocs, unsafe.Pointer(times))
+		cTimes := unsafe.Slice(times, n)
+		for i, t := range o.keyframeTimes {
+			cTimes[i] = C.uint64_t(max(t.Milliseconds(), 0))
+		}
+		params.keyframe_times_ms = times
+		params.keyframe_time_count = C.size_t(n)
+	}
+	params.pixel_format = C.PixelFormat(o.pixelFormat)
+	params.bit_depth = C.BitDepth(o.bitDepth)
+	params.dynamic_range = C.DynamicRange(o.dynamicRange)
+	params.max_luminance = C.uint32_t(o.maxLuminance)
+	params.color_primaries = C.ColorPrimaries(o.colorPrimaries)
+	params.color_transfer = C.ColorTransfer(o.colorTransfer)
+	params.color_matrix = C.ColorMatrix(o.colorMatrix)
+	params.color_range = C.ColorRange(o.colorRange)
+	params.juxtapose_layout = C.JuxtaposeLayout(o.juxtaposeLayout)
+	params.hw_accel = C.HwAccel(o.hwAccel)
+	params.hw_fallback = C.HwFallback(o.hwFallback)
+	var fallbackHandle cgo.Handle
+	if o.onFallback != nil {
+		// The callback finds the function through a handle, as C cannot hold
+		// Go pointers
+		fallbackHandle = cgo.NewHandle(o.onFallback)
+		params.on_fallback = C.fallback_callback()
+		params.fallback_user_data = C.handle_user_data(C.uintptr_t(fallbackHandle))
+	}
+	var logHandle cgo.Handle
+	if o.recordedMetrics() != nil {
+		// The encoder of the metrics is taken from the log events
+		o.encoder = &encoderChoice{}
+	}
+	if o.logger != nil || o.encoder != nil {
+		ctx := o.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		logHandle = cgo.NewHandle(logTarget{logger: o.logger, ctx: ctx, encoder: o.encoder})
+		params.on_log = C.log_callback()
+		params.log_user_data = C.handle_user_data(C.uintptr_t(logHandle))
+	}
+	var warningHandle cgo.Handle
+	if o.onWarning != nil {
+		warningHandle = cgo.NewHandle(o.onWarning)
+		params.on_warning = C.warning_callback()
+		params.warning_user_data = C.handle_user_data(C.uintptr_t(warningHandle))
+	}
+	params.output_check = C.OutputCheck(o.outputCheck)
+	params.reproducibility = C.Reproducibility(o.reproducibility)
+	if o.slideChapters {
+		params.chapter_mode = C.CHAPTER_MODE_SLIDES
+	}
+	if n := len(o.chapters); n > 0 {
+		// The chapters and their titles live in C memory because the
+		// parameters point to them
+		chapters := (*C.Chapter)(C.calloc(C.size_t(n), C.size_t(unsafe.Sizeof(C.Chapter{}))))
+		allocs = append(allocs, unsafe.Pointer(chapters))
+		cChapters := unsafe.Slice(chapters, n)
+		for i, chapter := range o.chapters {
+			cChapters[i] = C.Chapter{
+				start_ms: C.uint64_t(max(chapter.Start.Milliseconds(), 0)),
+				title:    C.CString(chapter.Title),
+			}
+			allocs = append(allocs, unsafe.Pointer(cChapters[i].title))
+		}
+		params.chapters = chapters
+		params.chapter_count = C.size_t(n)
+	}
+	params.language = o.cMessageLanguage()
+	params.threads = C.uint32_t(max(o.threads, 0))
+	params.av1_backend = C.Av1Backend(o.av1Backend)
+	params.av1_preset = C.uint8_t(min(max(o.av1Preset, 0), 255))
+	params.h264_profile = C.H264Profile(o.h264Profile)
+	params.h264_level = C.uint8_t(min(max(o.h264Level, 0), 255))
+	params.h264_preset = C.H264Preset(o.h264Preset)
+	params.h264_tune = C.H264Tune(o.h264Tune)
+	params.memory_budget_mb = C.uint32_t(max(o.memoryBudget, 0))
+	params.priority = C.Priority(o.priority)
+	if o.session != nil {
+		// Held until the call ends, so Close waits for it
+		o.session.mu.RLock()
+		params.session = o.session.c
+	}
+	if n := len(o.ffmpegArgs); n > 0 {
+		// The array and its strings live in C memory because the parameters
+		// point to them
+		args := (**C.char)(C.calloc(C.size_t(n), C.size_t(unsafe.Sizeof((*C.char)(nil)))))
+		allocs = append(allocs, unsafe.Pointer(args))
+		cArgs := unsafe.Slice(args, n)
+		for i, arg := range o.ffmpegArgs {
+			cArgs[i] = C.CString(arg)
+			allocs = append(allocs, unsafe.Pointer(cArgs[i]))
+		}
+		params.ffmpeg_args = args
+		params.ffmpeg_arg_count = C.size_t(n)
+	}
+
+	if o.logo != nil {
+		logo := (*C.LogoParams)(C.calloc(1, C.size_t(unsafe.Sizeof(C.LogoParams{}))))
+		allocs = append(allocs, unsafe.Pointer(logo))
+		logo.path = C.CString(o.logo.Path)
+		allocs = append(allocs, unsafe.Pointer(logo.path))
+		logo.anchor = C.Anchor(o.logo.Anchor)
+		logo.width = C.uint32_t(max(o.logo.Width, 0))
+		logo.fade_ms = C.uint32_t(max(o.logo.FadeMs, 0))
+
+		if n := len(o.logo.Ranges); n > 0 {
+			// The ranges live in C memory because the C structures point to them
+			ranges := (*C.TimeRange)(C.calloc(C.size_t(n), C.size_t(unsafe.Sizeof(C.TimeRange{}))))
+			allocs = append(allocs, unsafe.Pointer(ranges))
+			cRanges := unsafe.Slice(ranges, n)
+			for i, r := range o.logo.Ranges {
+				cRanges[i] = C.TimeRange{
+					start_ms: C.int64_t(r.StartMs),
+					end_ms:   C.int64_t(r.EndMs),
+				}
+			}
+			logo.ranges = ranges
+			logo.range_count = C.size_t(n)
+		}
+		params.logo = logo
+	}
+
+	return params, func() {
+		for _, p := range allocs {
+			C.free(p)
+		}
+		C.free(unsafe.Pointer(params))
+		if fallbackHandle != 0 {
+			fallbackHandle.Delete()
+		}
+		if logHandle != 0 {
+			logHandle.Delete()
+		}
+		if warningHandle != 0 {
+			warningHandle.Delete()
+		}
+		if o.session != nil {
+			o.session.mu.RUnlock()
+		}
+	}
+}
+
+// cAudioParams converts the options to C audio parameters
+func (o *options) cAudioParams() C.AudioParams {
+	return C.AudioParams{
+		fit:         C.AudioFit(o.audioFit),
+		codec:       C.AudioCodec(o.audioCodec),
+		bitrate:     C.uint32_t(max(o.audioBitrate, 0)),
+		gain:        C.float(o.audioGain),
+		fade_in_ms:  C.uint32_t(max(o.audioFadeInMs, 0)),
+		fade_out_ms: C.uint32_t(max(o.audioFadeOutMs, 0)),
+	}
+}
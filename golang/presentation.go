@@ -0,0 +1,224 @@
+package minmpeg
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultPresentationDurationMs is the duration of presentation slides that
+// have neither a duration nor narration in the notes
+const DefaultPresentationDurationMs = 3000
+
+// presentationNote is the row of a notes file for one slide
+type presentationNote struct {
+	Slide      int     `json:"slide"`       // Slide number, from 1
+	DurationMs *uint32 `json:"duration_ms"` // Duration (nil when unset)
+	Caption    string  `json:"caption"`
+	Audio      string  `json:"audio"` // Narration, relative to the notes file
+}
+
+// SlideEntriesFromPresentation returns the slides of a presentation exported
+// as numbered images, such as Slide1.png, Slide2.png, ... from PowerPoint or
+// Deck.001.jpeg, Deck.002.jpeg, ... from Keynote. Each image in dir whose name
+// contains a number becomes the slide of that number (the last number in the
+// name), in order; hidden files and other files are ignored.
+//
+// notesPath names an optional notes file ("" for none) giving the duration,
+// caption and narration of slides by number, as a JSON array of objects or a
+// CSV file with a header row, chosen by the .json or .csv extension. Both use
+// the fields slide, duration_ms, caption and audio; other fields are ignored,
+// and audio paths are relative to the notes file. Slides without a duration
+// last DefaultPresentationDurationMs, or as long as their narration.
+//
+// It fails with ErrInvalidInput when dir holds no numbered images, two images
+// have the same number, or the notes are invalid or name a slide that does
+// not exist.
+func SlideEntriesFromPresentation(dir, notesPath string) ([]SlideEntry, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, newError(ErrIO, fmt.Sprintf("failed to read presentation directory: %v", err))
+	}
+
+	paths := make(map[int]string)
+	var numbers []int
+	for _, f := range files {
+		name := f.Name()
+		ext := strings.ToLower(filepath.Ext(name))
+		if f.IsDir() || strings.HasPrefix(name, ".") || !zipImageExtensions[ext] {
+			continue
+		}
+		number, ok := slideNumber(strings.TrimSuffix(name, filepath.Ext(name)))
+		if !ok {
+			continue
+		}
+		if other, ok := paths[number]; ok {
+			return nil, newError(ErrInvalidInput, fmt.Sprintf("slides %s and %s have the same number %d", other, name, number))
+		}
+		paths[number] = name
+		numbers = append(numbers, number)
+	}
+	if len(numbers) == 0 {
+		return nil, newError(ErrInvalidInput, "presentation directory contains no numbered images")
+	}
+	sort.Ints(numbers)
+
+	notes := make(map[int]presentationNote)
+	if notesPath != "" {
+		rows, err := readPresentationNotes(notesPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, note := range rows {
+			if _, ok := paths[note.Slide]; !ok {
+				return nil, newError(ErrInvalidInput, fmt.Sprintf("notes name slide %d, which has no image", note.Slide))
+			}
+			if _, ok := notes[note.Slide]; ok {
+				return nil, newError(ErrInvalidInput, fmt.Sprintf("notes have slide %d more than once", note.Slide))
+			}
+			if note.Audio != "" && !filepath.IsAbs(note.Audio) {
+				note.Audio = filepath.Join(filepath.Dir(notesPath), note.Audio)
+			}
+			notes[note.Slide] = note
+		}
+	}
+
+	entries := make([]SlideEntry, len(numbers))
+	for i, number := range numbers {
+		note := notes[number]
+		entries[i] = SlideEntry{
+			Path:    filepath.Join(dir, paths[number]),
+			Caption: note.Caption,
+			Audio:   note.Audio,
+		}
+		switch {
+		case note.DurationMs != nil:
+			entries[i].DurationMs = *note.DurationMs
+		case note.Audio == "":
+			entries[i].DurationMs = DefaultPresentationDurationMs
+		}
+	}
+	return entries, nil
+}
+
+// SlideshowFromPresentation creates a slideshow from a presentation exported
+// as numbered images, with the notes file at notesPath ("" for none), as read
+// by SlideEntriesFromPresentation
+func SlideshowFromPresentation(dir, notesPath, outputPath string, container Container, codec Codec, quality uint8, ffmpegPath string, opts ...Option) error {
+	entries, err := SlideEntriesFromPresentation(dir, notesPath)
+	if err != nil {
+		return err
+	}
+	return Slideshow(entries, outputPath, container, codec, quality, ffmpegPath, opts...)
+}
+
+// slideNumber returns the value of the last run of digits in a file name
+func slideNumber(name string) (int, bool) {
+	end := len(name)
+	for end > 0 && !isDigit(name[end-1]) {
+		end--
+	}
+	start := end
+	for start > 0 && isDigit(name[start-1]) {
+		start--
+	}
+	if start == end {
+		return 0, false
+	}
+	number, err := strconv.Atoi(name[start:end])
+	return number, err == nil
+}
+
+// readPresentationNotes reads the rows of a JSON or CSV notes file
+func readPresentationNotes(notesPath string) ([]presentationNote, error) {
+	f, err := os.Open(notesPath)
+	if err != nil {
+		return nil, newError(ErrIO, fmt.Sprintf("failed to open notes: %v", err))
+	}
+	defer f.Close()
+
+	var notes []presentationNote
+	switch strings.ToLower(filepath.Ext(notesPath)) {
+	case ".json":
+		if err := json.NewDecoder(f).Decode(&notes); err != nil {
+			return nil, newError(ErrInvalidInput, fmt.Sprintf("invalid notes %s: %v", notesPath, err))
+		}
+	case ".csv":
+		notes, err = readPresentationCSV(f)
+		if err != nil {
+			return nil, newError(ErrInvalidInput, fmt.Sprintf("invalid notes %s: %v", notesPath, err))
+		}
+	default:
+		return nil, newError(ErrInvalidInput, fmt.Sprintf("notes must be a .json or .csv file: %s", notesPath))
+	}
+
+	for _, note := range notes {
+		if note.Slide < 1 {
+			return nil, newError(ErrInvalidInput, fmt.Sprintf("invalid notes %s: slide numbers start at 1, got %d", notesPath, note.Slide))
+		}
+	}
+	return notes, nil
+}
+
+// readPresentationCSV reads notes from CSV with a header row naming the
+// columns. Empty cells leave their field unset.
+func readPresentationCSV(r io.Reader) ([]presentationNote, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		// Spreadsheets often start UTF-8 CSV with a byte order mark
+		name = strings.TrimPrefix(name, "\ufeff")
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["slide"]; !ok {
+		return nil, errors.New("no slide column")
+	}
+	cell := func(record []string, name string) string {
+		if i, ok := columns[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var notes []presentationNote
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return notes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		note := presentationNote{
+			Caption: cell(record, "caption"),
+			Audio:   cell(record, "audio"),
+		}
+		if note.Slide, err = strconv.Atoi(cell(record, "slide")); err != nil {
+			return nil, fmt.Errorf("line %d: invalid slide number %q", line, cell(record, "slide"))
+		}
+		if s := cell(record, "duration_ms"); s != "" {
+			d, err := strconv.ParseUint(s, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid duration %q", line, s)
+			}
+			duration := uint32(d)
+			note.DurationMs = &duration
+		}
+		notes = append(notes, note)
+	}
+}
@@ -0,0 +1,60 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+#include <stdlib.h>
+*/
+import "C"
+import "unsafe"
+
+// PreviewLayout represents how a slideshow preview lays out its slides
+type PreviewLayout int
+
+const (
+	PreviewLayoutStrip PreviewLayout = C.PREVIEW_LAYOUT_STRIP // All slides side by side in one image
+	PreviewLayoutFiles PreviewLayout = C.PREVIEW_LAYOUT_FILES // One image per slide, numbered from 1 beside the preview path
+)
+
+// PreviewSlideshow renders the first frame of each slide as PNG, as Slideshow
+// would draw it with the same options, without encoding a video, so UIs can
+// show the deck before committing to a full render. HDR10 output is previewed
+// as SDR.
+//
+// With PreviewLayoutFiles, the slides are written beside outputPath numbered
+// after its name: "deck.png" gives "deck_00001.png", "deck_00002.png", ...
+// A height above 0 scales each slide to it, keeping the aspect ratio. ffmpeg
+// is only needed to time a logo by slide narration.
+func PreviewSlideshow(entries []SlideEntry, outputPath string, layout PreviewLayout, height uint32, ffmpegPath string, opts ...Option) error {
+	if len(entries) == 0 {
+		return newOptions(opts).newError(ErrInvalidInput, "no slides provided")
+	}
+
+	cEntries, freeEntries := cSlideEntries(entries)
+	defer freeEntries()
+
+	cOutputPath := C.CString(outputPath)
+	defer C.free(unsafe.Pointer(cOutputPath))
+
+	var cFfmpegPath *C.char
+	if ffmpegPath != "" {
+		cFfmpegPath = C.CString(ffmpegPath)
+		defer C.free(unsafe.Pointer(cFfmpegPath))
+	}
+
+	o := newOptions(opts)
+	cParams, freeParams := o.cParams()
+	defer freeParams()
+
+	return o.run(Job{Operation: OperationPreviewSlideshow, Output: outputPath}, func() error {
+		result := C.minmpeg_preview_slideshow(
+			&cEntries[0],
+			C.size_t(len(entries)),
+			cOutputPath,
+			C.PreviewLayout(layout),
+			C.uint32_t(height),
+			cFfmpegPath,
+			cParams,
+		)
+		return resultToError(result)
+	})
+}
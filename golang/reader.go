@@ -0,0 +1,92 @@
+package minmpeg
+
+/*
+#include <stdlib.h>
+#include "../include/minmpeg.h"
+*/
+import "C"
+import (
+	"image"
+	"io"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// VideoFrame is a frame decoded by a VideoReader
+type VideoFrame struct {
+	Image *image.NRGBA  // Pixels of the frame
+	Time  time.Duration // Time of the frame
+}
+
+// VideoReader decodes the frames of a video with ffmpeg, for analysis such as
+// diffing or OCR of rendered videos. Frames are sampled at a constant rate;
+// variable frame rate videos repeat or drop frames to fit it. HDR videos are
+// tone mapped to SDR. A reader may be used by many goroutines, one call at a
+// time.
+type VideoReader struct {
+	mu     sync.Mutex
+	c      *C.VideoReader
+	width  int
+	height int
+}
+
+// OpenVideoReader starts decoding a video at fps frames per second (0 for the
+// frame rate of the video). Close the reader when done, even before the last
+// frame.
+func OpenVideoReader(path string, fps float64, ffmpegPath string) (*VideoReader, error) {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	var cFfmpegPath *C.char
+	if ffmpegPath != "" {
+		cFfmpegPath = C.CString(ffmpegPath)
+		defer C.free(unsafe.Pointer(cFfmpegPath))
+	}
+
+	var c *C.VideoReader
+	var width, height C.uint32_t
+	result := C.minmpeg_reader_open(cPath, C.double(fps), cFfmpegPath, &c, &width, &height)
+	if err := resultToError(result); err != nil {
+		return nil, err
+	}
+	return &VideoReader{c: c, width: int(width), height: int(height)}, nil
+}
+
+// Bounds returns the bounds of the frames
+func (r *VideoReader) Bounds() image.Rectangle {
+	return image.Rect(0, 0, r.width, r.height)
+}
+
+// ReadFrame decodes the next frame into a new image. It returns io.EOF after
+// the last frame.
+func (r *VideoReader) ReadFrame() (VideoFrame, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.c == nil {
+		return VideoFrame{}, newError(ErrInvalidInput, "video reader is closed")
+	}
+
+	img := image.NewNRGBA(r.Bounds())
+	var timeMs C.int64_t
+	result := C.minmpeg_reader_read(r.c, (*C.uint8_t)(unsafe.Pointer(&img.Pix[0])), C.size_t(len(img.Pix)), &timeMs)
+	if err := resultToError(result); err != nil {
+		return VideoFrame{}, err
+	}
+	if timeMs < 0 {
+		return VideoFrame{}, io.EOF
+	}
+	return VideoFrame{Image: img, Time: time.Duration(timeMs) * time.Millisecond}, nil
+}
+
+// Close stops decoding and frees the reader. Closing a closed reader does
+// nothing.
+func (r *VideoReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.c != nil {
+		C.minmpeg_reader_free(r.c)
+		r.c = nil
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package minmpeg
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// DefaultRetryOn lists the error codes retried when RetryPolicy.RetryOn is nil:
+// I/O errors, such as a network file system dropping out, and encoding errors,
+// which include hardware encoders failing to start while busy.
+var DefaultRetryOn = []ErrorCode{ErrIO, ErrEncode}
+
+// RetryPolicy configures automatic retries of failed calls
+type RetryPolicy struct {
+	Attempts   int           // Total number of attempts, including the first (0 or 1 for no retries)
+	Backoff    time.Duration // Delay before the first retry, doubled after each retry
+	MaxBackoff time.Duration // Longest delay between attempts (0 for no limit)
+	RetryOn    []ErrorCode   // Error codes worth retrying (nil for DefaultRetryOn)
+}
+
+// WithRetry retries calls that fail with a transient error, waiting with
+// exponential backoff between attempts, so callers do not need their own retry
+// loops. Errors such as invalid input are returned right away. The error of the
+// last attempt is returned when every attempt fails.
+func WithRetry(policy RetryPolicy) Option {
+	return func(o *options) {
+		o.retry = policy
+	}
+}
+
+// shouldRetry reports whether err has one of the retried codes
+func (p *RetryPolicy) shouldRetry(err error) bool {
+	retryOn := p.RetryOn
+	if retryOn == nil {
+		retryOn = DefaultRetryOn
+	}
+	for _, code := range retryOn {
+		if errors.Is(err, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// run calls call until it succeeds, fails with an error that is not retried,
+// or runs out of attempts. Waiting between attempts ends with ctx.Err() when
+// ctx is cancelled.
+func (p *RetryPolicy) run(ctx context.Context, call func() error) error {
+	delay := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil || attempt >= p.Attempts || !p.shouldRetry(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = p.nextDelay(delay)
+	}
+}
+
+// nextDelay doubles delay up to MaxBackoff, saturating instead of overflowing
+// when there is no limit
+func (p *RetryPolicy) nextDelay(delay time.Duration) time.Duration {
+	if delay > math.MaxInt64/2 {
+		delay = math.MaxInt64
+	} else {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
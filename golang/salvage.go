@@ -0,0 +1,69 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+#include <stdlib.h>
+*/
+import "C"
+import "unsafe"
+
+// SkippedSlide is a slide left out by SlideshowSkippingInvalid
+type SkippedSlide struct {
+	Index int       // Index of the slide in the entries
+	Code  ErrorCode // Why the slide could not be used
+}
+
+// SlideshowSkippingInvalid creates a slideshow like Slideshow, but a slide whose
+// image cannot be loaded or whose narration cannot be read is left out instead
+// of failing the whole video, so one corrupt file does not discard the work on
+// the other slides. It returns the skipped slides, and fails when no slide can
+// be used or on errors unrelated to a single slide.
+func SlideshowSkippingInvalid(entries []SlideEntry, outputPath string, container Container, codec Codec, quality uint8, ffmpegPath string, opts ...Option) ([]SkippedSlide, error) {
+	if len(entries) == 0 {
+		return nil, newOptions(opts).newError(ErrInvalidInput, "no slides provided")
+	}
+
+	cEntries, freeEntries := cSlideEntries(entries)
+	defer freeEntries()
+
+	cOutputPath := C.CString(outputPath)
+	defer C.free(unsafe.Pointer(cOutputPath))
+
+	var cFfmpegPath *C.char
+	if ffmpegPath != "" {
+		cFfmpegPath = C.CString(ffmpegPath)
+		defer C.free(unsafe.Pointer(cFfmpegPath))
+	}
+
+	o := newOptions(opts)
+	cParams, freeParams := o.cParams()
+	defer freeParams()
+
+	cSkipped := make([]C.ErrorCode, len(entries))
+	job := Job{Operation: OperationSlideshowSkippingInvalid, Output: outputPath, Codec: codec, Container: container}
+	err := o.run(job, func() error {
+		result := C.minmpeg_slideshow_skipping_invalid(
+			&cEntries[0],
+			C.size_t(len(entries)),
+			cOutputPath,
+			C.Container(container),
+			C.Codec(codec),
+			C.uint8_t(quality),
+			cFfmpegPath,
+			cParams,
+			&cSkipped[0],
+		)
+		return resultToError(result)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var skipped []SkippedSlide
+	for i, code := range cSkipped {
+		if code != C.MINMPEG_OK {
+			skipped = append(skipped, SkippedSlide{Index: i, Code: ErrorCode(code)})
+		}
+	}
+	return skipped, nil
+}
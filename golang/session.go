@@ -0,0 +1,41 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+*/
+import "C"
+import "sync"
+
+// Session keeps encoders warm across calls, for workloads of many small jobs
+// where starting an encoder (an ffmpeg process, or a native encoder) takes
+// longer than encoding. A call given the session with WithSession takes a
+// spare encoder of its configuration if the session has one, and the session
+// starts another in the background for the next call. Jobs of the same size,
+// codec and options benefit; others start their encoder as usual. A session
+// may be used by many goroutines at once.
+type Session struct {
+	mu sync.RWMutex
+	c  *C.Session
+}
+
+// NewSession creates a session without spare encoders. Close it to stop them.
+func NewSession() *Session {
+	return &Session{c: C.minmpeg_session_new()}
+}
+
+// Close stops the spare encoders of the session once the calls using it end.
+// Calls given a closed session start their encoders as usual.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	C.minmpeg_session_free(s.c)
+	s.c = nil
+	return nil
+}
+
+// WithSession runs the call with encoders kept warm by session (nil for none).
+func WithSession(session *Session) Option {
+	return func(o *options) {
+		o.session = session
+	}
+}
@@ -0,0 +1,74 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+#include <stdlib.h>
+*/
+import "C"
+import "unsafe"
+
+// EmailMaxBytes is the size limit of common mail services for attachments (8 MiB)
+const EmailMaxBytes = C.MINMPEG_EMAIL_MAX_BYTES
+
+// SizedSettings holds the settings chosen by SlideshowWithinSize
+type SizedSettings struct {
+	Quality uint8  // Quality (0-100)
+	Scale   int    // Resolution in percent of the first slide
+	Width   int    // Output width in pixels
+	Height  int    // Output height in pixels
+	Size    uint64 // Output file size in bytes
+}
+
+// SlideshowWithinSize creates a slideshow no larger than maxBytes. While the
+// output is too large it is rendered again with a lower quality (down to 20)
+// and then a lower resolution. Pass EmailMaxBytes for videos sent by email.
+func SlideshowWithinSize(entries []SlideEntry, outputPath string, container Container, codec Codec, quality uint8, maxBytes uint64, ffmpegPath string, opts ...Option) (*SizedSettings, error) {
+	if len(entries) == 0 {
+		return nil, newOptions(opts).newError(ErrInvalidInput, "no slides provided")
+	}
+
+	cEntries, freeEntries := cSlideEntries(entries)
+	defer freeEntries()
+
+	cOutputPath := C.CString(outputPath)
+	defer C.free(unsafe.Pointer(cOutputPath))
+
+	var cFfmpegPath *C.char
+	if ffmpegPath != "" {
+		cFfmpegPath = C.CString(ffmpegPath)
+		defer C.free(unsafe.Pointer(cFfmpegPath))
+	}
+
+	o := newOptions(opts)
+	cParams, freeParams := o.cParams()
+	defer freeParams()
+
+	var cSettings C.SizedSettings
+	job := Job{Operation: OperationSlideshowWithinSize, Output: outputPath, Codec: codec, Container: container}
+	err := o.run(job, func() error {
+		result := C.minmpeg_slideshow_within_size(
+			&cEntries[0],
+			C.size_t(len(entries)),
+			cOutputPath,
+			C.Container(container),
+			C.Codec(codec),
+			C.uint8_t(quality),
+			C.uint64_t(maxBytes),
+			cFfmpegPath,
+			cParams,
+			&cSettings,
+		)
+		return resultToError(result)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &SizedSettings{
+		Quality: uint8(cSettings.quality),
+		Scale:   int(cSettings.scale),
+		Width:   int(cSettings.width),
+		Height:  int(cSettings.height),
+		Size:    uint64(cSettings.size),
+	}, nil
+}
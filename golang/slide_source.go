@@ -0,0 +1,48 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+*/
+import "C"
+import (
+	"runtime/cgo"
+	"unsafe"
+)
+
+// slideSource yields the slides of SlideshowFunc to the library
+type slideSource struct {
+	next func() (SlideEntry, bool, error)
+	err  error  // Error of next, which ended the slideshow
+	free func() // Frees the C strings of the last slide
+}
+
+// release frees the C strings of the last slide
+func (s *slideSource) release() {
+	if s.free != nil {
+		s.free()
+		s.free = nil
+	}
+}
+
+// minmpegSlideSource fills entry with the next slide of the slideSource
+// behind the handle in userData. The strings of the slide stay valid until
+// the next call.
+//
+//export minmpegSlideSource
+func minmpegSlideSource(entry *C.SlideEntry, userData unsafe.Pointer) C.int {
+	source := cgo.Handle(uintptr(userData)).Value().(*slideSource)
+	source.release()
+
+	slide, ok, err := source.next()
+	if err != nil {
+		source.err = err
+		return -1
+	}
+	if !ok {
+		return 0
+	}
+	cEntries, free := cSlideEntries([]SlideEntry{slide})
+	source.free = free
+	*entry = cEntries[0]
+	return 1
+}
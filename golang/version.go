@@ -0,0 +1,175 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+
+// Libraries from before ABI versioning lack minmpeg_abi_version, so it is
+// referenced weakly where the toolchain allows and reads as 0 there
+#if defined(__GNUC__) && !defined(_WIN32)
+extern uint32_t minmpeg_abi_version(void) __attribute__((weak));
+
+static uint32_t library_abi_version(void) {
+	return minmpeg_abi_version ? minmpeg_abi_version() : 0;
+}
+#else
+static uint32_t library_abi_version(void) {
+	return minmpeg_abi_version();
+}
+#endif
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ABIVersion is the ABI version of the C API this binding was built against
+// (MINMPEG_ABI_VERSION of minmpeg.h)
+const ABIVersion = C.MINMPEG_ABI_VERSION
+
+// ErrIncompatibleVersion is returned by RequireVersion when the loaded library
+// cannot be used by this binding or does not satisfy the version constraint
+var ErrIncompatibleVersion = errors.New("incompatible minmpeg library")
+
+// RequireVersion checks that the loaded libminmpeg matches the ABI this binding
+// was built against and that its version satisfies constraint, so a stale
+// shared library on the host is reported with a clear error at startup instead
+// of crashing the first call that passes it newer parameters. Call it before
+// any other function.
+//
+// The constraint is a comma-separated list of comparisons that must all hold,
+// such as ">=0.2.0, <0.4". Operators are =, !=, <, <=, >, >=, ^ (same major
+// version, or same minor version below 1.0) and ~ (same minor version); a
+// bare version means =, and a version with fewer parts matches every version
+// it prefixes ("=0.2" matches 0.2.5). An empty constraint checks the ABI only.
+//
+// Errors about the library match ErrIncompatibleVersion with errors.Is; an
+// invalid constraint returns a different error.
+func RequireVersion(constraint string) error {
+	clauses, err := parseConstraint(constraint)
+	if err != nil {
+		return err
+	}
+
+	version := Version()
+	switch abi := uint32(C.library_abi_version()); {
+	case abi == 0:
+		return fmt.Errorf("%w: libminmpeg %s predates ABI version %d of this binding; update the library",
+			ErrIncompatibleVersion, version, ABIVersion)
+	case abi != ABIVersion:
+		return fmt.Errorf("%w: libminmpeg %s has ABI version %d, this binding needs %d; use the library and binding of the same release",
+			ErrIncompatibleVersion, version, abi, ABIVersion)
+	}
+
+	parsed, _, err := parseVersion(version)
+	if err != nil {
+		return fmt.Errorf("%w: cannot parse library version %q", ErrIncompatibleVersion, version)
+	}
+	for _, clause := range clauses {
+		if !clause.matches(parsed) {
+			return fmt.Errorf("%w: libminmpeg %s does not satisfy %q", ErrIncompatibleVersion, version, constraint)
+		}
+	}
+	return nil
+}
+
+// versionClause is one comparison of a version constraint
+type versionClause struct {
+	op      string
+	version [3]int
+	parts   int // Number of parts given, 1 to 3
+}
+
+// parseConstraint splits a version constraint into its comparisons
+func parseConstraint(constraint string) ([]versionClause, error) {
+	var clauses []versionClause
+	for _, field := range strings.Split(constraint, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		rest := strings.TrimLeft(field, "<>=!^~")
+		op := field[:len(field)-len(rest)]
+		switch op {
+		case "", "=", "==":
+			op = "="
+		case "!=", "<", "<=", ">", ">=", "^", "~":
+		default:
+			return nil, fmt.Errorf("invalid version constraint %q: unknown operator %q", constraint, op)
+		}
+		version, parts, err := parseVersion(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("invalid version constraint %q: %v", constraint, err)
+		}
+		clauses = append(clauses, versionClause{op: op, version: version, parts: parts})
+	}
+	return clauses, nil
+}
+
+// parseVersion parses a version of 1 to 3 numeric parts, ignoring a leading v
+// and any pre-release or build suffix, and returns the number of parts given
+func parseVersion(s string) ([3]int, int, error) {
+	var version [3]int
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return version, 0, fmt.Errorf("invalid version %q", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return version, 0, fmt.Errorf("invalid version %q", s)
+		}
+		version[i] = n
+	}
+	return version, len(parts), nil
+}
+
+// compareVersions returns -1, 0 or 1 as a is before, equal to or after b in
+// their first parts
+func compareVersions(a, b [3]int, parts int) int {
+	for i := 0; i < parts; i++ {
+		switch {
+		case a[i] < b[i]:
+			return -1
+		case a[i] > b[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+// matches reports whether a version satisfies the comparison
+func (c versionClause) matches(v [3]int) bool {
+	switch c.op {
+	case "=":
+		return compareVersions(v, c.version, c.parts) == 0
+	case "!=":
+		return compareVersions(v, c.version, c.parts) != 0
+	case "<":
+		return compareVersions(v, c.version, 3) < 0
+	case "<=":
+		return compareVersions(v, c.version, 3) <= 0
+	case ">":
+		return compareVersions(v, c.version, 3) > 0
+	case ">=":
+		return compareVersions(v, c.version, 3) >= 0
+	}
+
+	// ^ keeps the first non-zero part (or the last given one), ~ the minor
+	// version (or the major version when only it is given)
+	fixed := 1
+	if c.op == "^" {
+		for fixed < c.parts && c.version[fixed-1] == 0 {
+			fixed++
+		}
+	} else if c.parts >= 2 {
+		fixed = 2
+	}
+	return compareVersions(v, c.version, 3) >= 0 && compareVersions(v, c.version, fixed) == 0
+}
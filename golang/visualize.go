@@ -0,0 +1,140 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+#include <stdlib.h>
+*/
+import "C"
+import "unsafe"
+
+// VisualStyle represents the style of an audio visualization
+type VisualStyle int
+
+const (
+	VisualStyleWaveform VisualStyle = C.VISUAL_STYLE_WAVEFORM // Waveform of the audio
+	VisualStyleSpectrum VisualStyle = C.VISUAL_STYLE_SPECTRUM // Frequency spectrum bars
+)
+
+// Visualization holds the settings of an audio visualization video
+type Visualization struct {
+	Style           VisualStyle
+	Width           uint32 // Output width in pixels (0 for 1280)
+	Height          uint32 // Output height in pixels (0 for 720)
+	Color           *Color // Color of the visualization (nil for white)
+	Background      *Color // Background color (nil for black)
+	BackgroundImage string // Background image, cropped to fill the frame (empty for none)
+}
+
+// VisualizeAudio creates a video of the waveform or spectrum of an audio file
+// over a background color or image. The video lasts as long as the audio,
+// which is muxed in with the loudness, gain and fade options. Requires ffmpeg.
+func VisualizeAudio(audioPath, outputPath string, container Container, codec Codec, quality uint8, visual Visualization, ffmpegPath string, opts ...Option) error {
+	cAudioPath := C.CString(audioPath)
+	defer C.free(unsafe.Pointer(cAudioPath))
+
+	cOutputPath := C.CString(outputPath)
+	defer C.free(unsafe.Pointer(cOutputPath))
+
+	var cFfmpegPath *C.char
+	if ffmpegPath != "" {
+		cFfmpegPath = C.CString(ffmpegPath)
+		defer C.free(unsafe.Pointer(cFfmpegPath))
+	}
+
+	cVisual, freeVisual := visual.cParams()
+	defer freeVisual()
+
+	o := newOptions(opts)
+	cParams, freeParams := o.cParams()
+	defer freeParams()
+
+	job := Job{Operation: OperationVisualizeAudio, Output: outputPath, Codec: codec, Container: container}
+	return o.run(job, func() error {
+		result := C.minmpeg_visualize_audio(
+			cAudioPath,
+			cOutputPath,
+			C.Container(container),
+			C.Codec(codec),
+			C.uint8_t(quality),
+			cVisual,
+			cFfmpegPath,
+			cParams,
+		)
+		return resultToError(result)
+	})
+}
+
+// ImageWithAudio creates a video showing a still image for the length of an
+// audio file, e.g. to upload a podcast episode or a song with its cover.
+// The image keeps its size (rounded down to even dimensions). Requires ffmpeg.
+func ImageWithAudio(imagePath, audioPath, outputPath string, container Container, codec Codec, quality uint8, ffmpegPath string, opts ...Option) error {
+	cImagePath := C.CString(imagePath)
+	defer C.free(unsafe.Pointer(cImagePath))
+
+	cAudioPath := C.CString(audioPath)
+	defer C.free(unsafe.Pointer(cAudioPath))
+
+	cOutputPath := C.CString(outputPath)
+	defer C.free(unsafe.Pointer(cOutputPath))
+
+	var cFfmpegPath *C.char
+	if ffmpegPath != "" {
+		cFfmpegPath = C.CString(ffmpegPath)
+		defer C.free(unsafe.Pointer(cFfmpegPath))
+	}
+
+	o := newOptions(opts)
+	cParams, freeParams := o.cParams()
+	defer freeParams()
+
+	job := Job{Operation: OperationImageWithAudio, Output: outputPath, Codec: codec, Container: container}
+	return o.run(job, func() error {
+		result := C.minmpeg_image_with_audio(
+			cImagePath,
+			cAudioPath,
+			cOutputPath,
+			C.Container(container),
+			C.Codec(codec),
+			C.uint8_t(quality),
+			cFfmpegPath,
+			cParams,
+		)
+		return resultToError(result)
+	})
+}
+
+// cParams converts the visualization settings to C parameters
+func (v *Visualization) cParams() (*C.VisualizeParams, func()) {
+	// The parameters live in C memory because they point to the colors
+	params := (*C.VisualizeParams)(C.calloc(1, C.size_t(unsafe.Sizeof(C.VisualizeParams{}))))
+	allocs := []unsafe.Pointer{unsafe.Pointer(params)}
+
+	params.style = C.VisualStyle(v.Style)
+	params.width = C.uint32_t(v.Width)
+	params.height = C.uint32_t(v.Height)
+
+	cColor := func(c *Color) *C.Color {
+		if c == nil {
+			return nil
+		}
+		color := (*C.Color)(C.calloc(1, C.size_t(unsafe.Sizeof(C.Color{}))))
+		allocs = append(allocs, unsafe.Pointer(color))
+		color.r = C.uint8_t(c.R)
+		color.g = C.uint8_t(c.G)
+		color.b = C.uint8_t(c.B)
+		return color
+	}
+	params.color = cColor(v.Color)
+	params.background = cColor(v.Background)
+
+	if v.BackgroundImage != "" {
+		params.background_image = C.CString(v.BackgroundImage)
+		allocs = append(allocs, unsafe.Pointer(params.background_image))
+	}
+
+	return params, func() {
+		for _, p := range allocs {
+			C.free(p)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package minmpeg
+
+/*
+#include "../include/minmpeg.h"
+*/
+import "C"
+import (
+	"runtime/cgo"
+	"unsafe"
+)
+
+// WarningKind represents what a non-fatal warning is about
+type WarningKind int
+
+const (
+	WarningSizeRounded      WarningKind = C.WARNING_SIZE_ROUNDED      // Frame size rounded down to even dimensions
+	WarningAudioTruncated   WarningKind = C.WARNING_AUDIO_TRUNCATED   // Background music or narration cut to the video or its slide
+	WarningSlideSkipped     WarningKind = C.WARNING_SLIDE_SKIPPED     // Slide left out as its image or narration could not be read
+	WarningHardwareFallback WarningKind = C.WARNING_HARDWARE_FALLBACK // Hardware encoder failed and the video was encoded in software
+)
+
+// Warning is a non-fatal problem of a call, which carries on
+type Warning struct {
+	Kind    WarningKind
+	Message string // Description in English
+}
+
+// WithWarnings calls warn with the non-fatal warnings of the call, such as a
+// frame size rounded down to even dimensions, background music or narration
+// cut to the length of the video or its slide, a skipped slide or a fallback
+// to the software encoder. They do not make the call fail. Audio is only
+// probed for its length when warnings are requested. The function may be
+// called from another goroutine.
+func WithWarnings(warn func(w Warning)) Option {
+	return func(o *options) {
+		o.onWarning = warn
+	}
+}
+
+// minmpegWarning passes a warning to the function of WithWarnings behind the
+// handle in userData
+//
+//export minmpegWarning
+func minmpegWarning(kind C.WarningKind, message *C.char, userData unsafe.Pointer) {
+	warn := cgo.Handle(uintptr(userData)).Value().(func(w Warning))
+	warn(Warning{Kind: WarningKind(kind), Message: C.GoString(message)})
+}
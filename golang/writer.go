@@ -0,0 +1,188 @@
+package minmpeg
+
+/*
+#include <stdlib.h>
+#include "../include/minmpeg.h"
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"sync"
+	"unsafe"
+)
+
+// VideoWriter writes a video from frames rendered by the program, such as
+// charts or animations, without writing images to disk first. Frames are
+// encoded as they are written, each shown for one frame period; Close writes
+// the output. WriteAudio adds generated sound without writing audio files. The
+// logo and background music of the options are added, while options about
+// slides, such as captions, do not apply.
+//
+// Logo ranges measured from the end, WithTimeBudget, target sizes, two-pass
+// encoding, WithHWFallback(HWFallbackSoftware, ...), WithPriority and HDR10
+// output fail with ErrInvalidInput, as the length of the video is only known
+// at the end. A Limiter admits the writer when it is opened and is released
+// when it is closed or discarded; calls are not retried. A writer may be used
+// by many goroutines, one call at a time.
+type VideoWriter struct {
+	mu       sync.Mutex
+	c        *C.VideoWriter
+	width    int
+	height   int
+	release  func()                                      // Releases the writer's limiter slot
+	finish   func(err error)                             // Records the writer in the metrics
+	newError func(code ErrorCode, message string) *Error // Creates errors in the language of the writer's options
+	frame    *image.NRGBA
+}
+
+// errWriterDiscarded is recorded in the metrics as the error of a discarded
+// writer, which wrote no output
+var errWriterDiscarded = errors.New("video writer discarded")
+
+// OpenVideoWriter starts writing a video of width by height pixels, both even,
+// at fps frames per second (0 for the rate of WithFrameRate, or DefaultFPS).
+func OpenVideoWriter(outputPath string, width, height, fps int, container Container, codec Codec, quality uint8, ffmpegPath string, opts ...Option) (*VideoWriter, error) {
+	o := newOptions(opts)
+	if width <= 0 || height <= 0 {
+		return nil, o.newError(ErrInvalidInput, fmt.Sprintf("invalid video size %dx%d", width, height))
+	}
+
+	cOutputPath := C.CString(outputPath)
+	defer C.free(unsafe.Pointer(cOutputPath))
+
+	var cFfmpegPath *C.char
+	if ffmpegPath != "" {
+		cFfmpegPath = C.CString(ffmpegPath)
+		defer C.free(unsafe.Pointer(cFfmpegPath))
+	}
+
+	if fps > 0 {
+		o.fps = fps
+	}
+	cParams, freeParams := o.cParams()
+	defer freeParams()
+
+	job := Job{Operation: OperationVideoWriter, Output: outputPath, Codec: codec, Container: container}
+	release, err := o.acquire(job)
+	if err != nil {
+		return nil, err
+	}
+	finish := o.measure(job)
+	var c *C.VideoWriter
+	result := C.minmpeg_writer_new(
+		cOutputPath,
+		C.uint32_t(width),
+		C.uint32_t(height),
+		C.Container(container),
+		C.Codec(codec),
+		C.uint8_t(quality),
+		cFfmpegPath,
+		cParams,
+		&c,
+	)
+	if err := resultToError(result); err != nil {
+		finish(err)
+		release()
+		return nil, err
+	}
+	return &VideoWriter{c: c, width: width, height: height, release: release, finish: finish, newError: o.newError}, nil
+}
+
+// WriteFrame encodes an image as the next frame. Its bounds must have the size
+// of the video; images other than *image.NRGBA are converted.
+func (w *VideoWriter) WriteFrame(img image.Image) error {
+	bounds := img.Bounds()
+	if bounds.Dx() != w.width || bounds.Dy() != w.height {
+		return w.newError(ErrInvalidInput, fmt.Sprintf("frame is %dx%d, the video %dx%d", bounds.Dx(), bounds.Dy(), w.width, w.height))
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if nrgba, ok := img.(*image.NRGBA); ok && nrgba.Stride == w.width*4 {
+		return w.write(nrgba.Pix[nrgba.PixOffset(bounds.Min.X, bounds.Min.Y):][:w.width*w.height*4])
+	}
+	// The frame is converted into a buffer kept for the next frames
+	if w.frame == nil {
+		w.frame = image.NewNRGBA(image.Rect(0, 0, w.width, w.height))
+	}
+	draw.Draw(w.frame, w.frame.Bounds(), img, bounds.Min, draw.Src)
+	return w.write(w.frame.Pix)
+}
+
+// WriteRGBA encodes width * height * 4 bytes of non-premultiplied RGBA pixels,
+// row by row from the top left, as the next frame.
+func (w *VideoWriter) WriteRGBA(pix []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.write(pix)
+}
+
+// WriteAudio adds interleaved 16-bit PCM samples, one per channel at a time,
+// to the audio of the video; it requires ffmpeg. The audio plays from the start
+// of the video and is cut or padded with silence to its length, with the
+// background music of WithBackgroundMusic lowered under it. Every call must
+// give the same sample rate and channels. With segment output, audio must be
+// written before the first frame; otherwise audio first written after frames
+// makes Close mux the output again with it.
+func (w *VideoWriter) WriteAudio(samples []int16, sampleRate, channels int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.c == nil {
+		return w.newError(ErrInvalidInput, "video writer is closed")
+	}
+	if sampleRate <= 0 || int64(sampleRate) > math.MaxUint32 || channels <= 0 || channels > math.MaxUint16 {
+		return w.newError(ErrInvalidInput, fmt.Sprintf("invalid audio format %d Hz with %d channels", sampleRate, channels))
+	}
+	if len(samples) == 0 {
+		return nil
+	}
+	result := C.minmpeg_writer_write_audio(w.c, (*C.int16_t)(unsafe.Pointer(&samples[0])), C.size_t(len(samples)), C.uint32_t(sampleRate), C.uint16_t(channels))
+	return resultToError(result)
+}
+
+// write encodes a frame, with the writer locked
+func (w *VideoWriter) write(pix []byte) error {
+	if w.c == nil {
+		return w.newError(ErrInvalidInput, "video writer is closed")
+	}
+	if len(pix) == 0 {
+		return w.newError(ErrInvalidInput, "empty frame")
+	}
+	result := C.minmpeg_writer_write(w.c, (*C.uint8_t)(unsafe.Pointer(&pix[0])), C.size_t(len(pix)))
+	return resultToError(result)
+}
+
+// Close flushes the encoder and writes the output. The writer cannot be used
+// afterwards.
+func (w *VideoWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.c == nil {
+		return w.newError(ErrInvalidInput, "video writer is closed")
+	}
+	result := C.minmpeg_writer_finish(w.c)
+	w.c = nil
+	err := resultToError(result)
+	w.finish(err)
+	w.release()
+	return err
+}
+
+// Discard stops the writer without writing the output, as after a failed
+// frame. Fragmented MP4 output keeps the frames written so far. Discarding a
+// closed writer does nothing.
+func (w *VideoWriter) Discard() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.c == nil {
+		return
+	}
+	C.minmpeg_writer_free(w.c)
+	w.c = nil
+	w.finish(errWriterDiscarded)
+	w.release()
+}
@@ -4,6 +4,9 @@
  * This library provides two main functions:
  * - slideshow: Create a video from a sequence of images
  * - juxtapose: Combine two videos side by side
+ * - visualize_audio: Render an audio file as a waveform or spectrum video
+ * - image_with_audio: Show a still image for the length of an audio file
+ * - slideshow_within_size: Create a slideshow that fits a file size limit
  */
 
 #ifndef MINMPEG_H
@@ -16,93 +19,1185 @@
 extern "C" {
 #endif
 
+/**
+ * ABI version of this header
+ *
+ * Incremented with every change that breaks programs built against an
+ * older header: a field added to a parameter struct, a changed enum value
+ * or function signature. Compare it with minmpeg_abi_version() to detect a
+ * stale shared library.
+ */
+#define MINMPEG_ABI_VERSION 21
+
 /**
  * Container format types
  */
-typedef enum {
-    CONTAINER_MP4 = 0,
-    CONTAINER_WEBM = 1,
-} Container;
+typedef enum {
+    CONTAINER_MP4 = 0,
+    CONTAINER_WEBM = 1,
+    CONTAINER_AVIF = 2,  /* Animated AVIF image sequence (AV1 only) */
+    CONTAINER_APNG = 3,  /* Animated PNG (PNG only) */
+    CONTAINER_MOV = 4,   /* QuickTime (ProRes, H.264 or HEVC) */
+    CONTAINER_MKV = 5,   /* Matroska (every codec except PNG) */
+    CONTAINER_HLS = 6,   /* HLS playlist with segment files beside it (H.264, HEVC; AV1 in fMP4) */
+    CONTAINER_DASH = 7,  /* MPEG-DASH manifest with fMP4 segment files beside it (H.264, HEVC, AV1) */
+} Container;
+
+/**
+ * Video codec types
+ */
+typedef enum {
+    CODEC_AV1 = 0,
+    CODEC_H264 = 1,
+    CODEC_PNG = 2,       /* Lossless with alpha (APNG only) */
+    CODEC_VP9 = 3,       /* WebM or MKV; encoded by ffmpeg with libvpx-vp9 */
+    CODEC_HEVC = 4,      /* MP4, MOV or MKV; encoded by ffmpeg with a hardware encoder or libx265 */
+    CODEC_VP8 = 5,       /* WebM or MKV; encoded by ffmpeg with libvpx, for older browsers */
+    CODEC_PRORES = 6,    /* MOV or MKV; Apple ProRes 422 encoded by ffmpeg with prores_ks */
+} Codec;
+
+/**
+ * Caption writing modes
+ */
+typedef enum {
+    WRITING_MODE_HORIZONTAL = 0,  /* Horizontal lines (right-to-left runs reordered) */
+    WRITING_MODE_VERTICAL = 1,    /* Vertical columns running right to left */
+} WritingMode;
+
+/**
+ * Caption line alignment
+ */
+typedef enum {
+    TEXT_ALIGN_CENTER = 0,  /* Lines centered in the caption box */
+    TEXT_ALIGN_START = 1,   /* Left edge (top edge for vertical text) */
+    TEXT_ALIGN_END = 2,     /* Right edge (bottom edge for vertical text) */
+} TextAlign;
+
+/**
+ * Overlay positions inside the safe area
+ */
+typedef enum {
+    ANCHOR_AUTO = 0,           /* Default for the overlay (captions: bottom center, vertical: top right) */
+    ANCHOR_TOP_LEFT = 1,
+    ANCHOR_TOP_CENTER = 2,
+    ANCHOR_TOP_RIGHT = 3,
+    ANCHOR_MIDDLE_LEFT = 4,
+    ANCHOR_CENTER = 5,
+    ANCHOR_MIDDLE_RIGHT = 6,
+    ANCHOR_BOTTOM_LEFT = 7,
+    ANCHOR_BOTTOM_CENTER = 8,
+    ANCHOR_BOTTOM_RIGHT = 9,
+} Anchor;
+
+/**
+ * Caption fitting
+ */
+typedef enum {
+    TEXT_FIT_WRAP = 0,         /* Wrap lines at the maximum width */
+    TEXT_FIT_SHRINK = 1,       /* Shrink the font until each line fits */
+    TEXT_FIT_WRAP_SHRINK = 2,  /* Wrap and shrink until the caption fits a third of the frame */
+} TextFit;
+
+/**
+ * Error codes
+ *
+ * Codes are stable across versions: existing values never change meaning
+ * and new codes are only appended, so they can be used in alerting rules.
+ */
+typedef enum {
+    MINMPEG_OK = 0,
+    MINMPEG_ERR_INVALID_INPUT = 1,
+    MINMPEG_ERR_CODEC_UNAVAILABLE = 2,
+    MINMPEG_ERR_CONTAINER_CODEC_MISMATCH = 3,
+    MINMPEG_ERR_IO_ERROR = 4,
+    MINMPEG_ERR_ENCODE_ERROR = 5,
+    MINMPEG_ERR_DECODE_ERROR = 6,
+    MINMPEG_ERR_INSUFFICIENT_SPACE = 7,
+    MINMPEG_ERR_OUTPUT_MISMATCH = 8,
+} ErrorCode;
+
+/**
+ * Language of error messages
+ */
+typedef enum {
+    LANGUAGE_ENGLISH = 0,
+    LANGUAGE_JAPANESE = 1,   /* The kind of error is translated; details stay in English */
+} Language;
+
+/**
+ * Language of the error messages of a single call
+ */
+typedef enum {
+    MESSAGE_LANGUAGE_AUTO = 0,      /* The language set with minmpeg_set_language */
+    MESSAGE_LANGUAGE_ENGLISH = 1,
+    MESSAGE_LANGUAGE_JAPANESE = 2,  /* The kind of error is translated; details stay in English */
+} MessageLanguage;
+
+/**
+ * Result structure returned by minmpeg functions
+ */
+typedef struct {
+    ErrorCode code;
+    char* message;        /* Error message (must be freed with minmpeg_free_result) */
+    int64_t slide_index;  /* Index of the slide that caused the error, -1 otherwise */
+    char* slide_path;     /* Image path of that slide, NULL otherwise (freed with minmpeg_free_result) */
+    char* ffmpeg_command; /* Command line of the ffmpeg process that failed, NULL otherwise (freed with minmpeg_free_result) */
+    char* ffmpeg_stderr;  /* Last lines of that process's stderr, NULL otherwise (freed with minmpeg_free_result) */
+} Result;
+
+/**
+ * Slide entry for slideshow creation
+ */
+typedef struct {
+    const char* path;      /* Path to the image file */
+    uint32_t duration_ms;  /* Duration in milliseconds (0 with audio: narration length) */
+    const char* caption;   /* Caption drawn near the bottom (NULL for none) */
+    const char* audio;     /* Narration audio file (NULL for none, requires ffmpeg) */
+} SlideEntry;
+
+/**
+ * Yields the next slide of minmpeg_slideshow_stream: fills entry and returns
+ * 1, returns 0 after the last slide, or a negative value to stop with an
+ * error. The strings of the entry must stay valid until the next call.
+ */
+typedef int (*SlideSource)(SlideEntry* entry, void* user_data);
+
+/**
+ * How input videos are decoded
+ */
+typedef enum {
+    DECODE_MODE_AUTO = 0,      /* Hardware decoding when available, software otherwise */
+    DECODE_MODE_SOFTWARE = 1,  /* Always decode in software */
+} DecodeMode;
+
+/**
+ * Encoder implementation of H.264, HEVC and AV1
+ */
+typedef enum {
+    HW_ACCEL_AUTO = 0,          /* The platform's choice; HEVC tries hardware encoders, then libx265; AV1 uses the AV1 backend */
+    HW_ACCEL_SOFTWARE = 1,      /* Always software, whose output does not depend on the GPU (the AV1 backend for AV1) */
+    HW_ACCEL_VIDEOTOOLBOX = 2,  /* Apple VideoToolbox hardware encoder (macOS) */
+    HW_ACCEL_NVENC = 3,         /* NVIDIA NVENC */
+    HW_ACCEL_QSV = 4,           /* Intel Quick Sync Video */
+    HW_ACCEL_VAAPI = 5,         /* VA-API (Linux) */
+    HW_ACCEL_AMF = 6,           /* AMD AMF */
+} HwAccel;
+
+/**
+ * What happens when a hardware encoder of H.264, HEVC or AV1 is unavailable or fails
+ */
+typedef enum {
+    HW_FALLBACK_FAIL = 0,       /* Fail with the error of the hardware encoder */
+    HW_FALLBACK_SOFTWARE = 1,   /* Encode again from the start with the software encoder */
+} HwFallback;
+
+/**
+ * Software encoder of AV1 video
+ */
+typedef enum {
+    AV1_BACKEND_RAV1E = 0,      /* rav1e, built into the library */
+    AV1_BACKEND_SVT_AV1 = 1,    /* SVT-AV1 through ffmpeg (libsvtav1; lossy 4:2:0 SDR video); much faster */
+    AV1_BACKEND_AOM = 2,        /* libaom through ffmpeg (libaom-av1; SDR video); slowest, best compression */
+} Av1Backend;
+
+/**
+ * Profile of H.264 video
+ */
+typedef enum {
+    H264_PROFILE_AUTO = 0,      /* The encoder's choice (High for libx264, Main for VideoToolbox) */
+    H264_PROFILE_BASELINE = 1,  /* Constrained Baseline, for the oldest hardware decoders */
+    H264_PROFILE_MAIN = 2,      /* Main profile */
+    H264_PROFILE_HIGH = 3,      /* High profile, compressing best */
+} H264Profile;
+
+/**
+ * Speed preset of libx264 (Linux software encoder only)
+ */
+typedef enum {
+    H264_PRESET_AUTO = 0,       /* Picked by the time budget */
+    H264_PRESET_ULTRAFAST = 1,
+    H264_PRESET_SUPERFAST = 2,
+    H264_PRESET_VERYFAST = 3,
+    H264_PRESET_FASTER = 4,
+    H264_PRESET_FAST = 5,
+    H264_PRESET_MEDIUM = 6,
+    H264_PRESET_SLOW = 7,
+    H264_PRESET_SLOWER = 8,
+    H264_PRESET_VERYSLOW = 9,
+} H264Preset;
+
+/**
+ * Tuning of libx264 for the kind of content (Linux software encoder only)
+ */
+typedef enum {
+    H264_TUNE_NONE = 0,         /* No tuning */
+    H264_TUNE_STILLIMAGE = 1,   /* Still images, as in slideshows */
+    H264_TUNE_ANIMATION = 2,    /* Animation with flat areas, as in screen recordings */
+} H264Tune;
+
+/**
+ * CPU and I/O priority of encoding work
+ */
+typedef enum {
+    PRIORITY_NORMAL = 0,        /* Priority of the calling thread */
+    PRIORITY_LOW = 1,           /* Nice 10 and the lowest best-effort I/O priority on Linux; background thread on macOS and Windows */
+    PRIORITY_IDLE = 2,          /* Nice 19 and idle I/O on Linux; background thread on macOS and Windows */
+} Priority;
+
+/**
+ * Whether the output is checked after rendering
+ */
+typedef enum {
+    OUTPUT_CHECK_NONE = 0,      /* Trust the output */
+    OUTPUT_CHECK_PROBE = 1,     /* Probe the output with ffprobe and fail with MINMPEG_ERR_OUTPUT_MISMATCH when it differs */
+} OutputCheck;
+
+/**
+ * Whether output is byte-identical for the same inputs and options
+ */
+typedef enum {
+    REPRODUCIBILITY_ANY = 0,            /* Output may differ between hosts and runs */
+    REPRODUCIBILITY_DETERMINISTIC = 1,  /* The same bytes on any host: software encoders with fixed threads, no ffmpeg version or random IDs */
+} Reproducibility;
+
+/**
+ * Where the chapter markers of a video come from
+ */
+typedef enum {
+    CHAPTER_MODE_LIST = 0,    /* The chapters of the parameters, if any */
+    CHAPTER_MODE_SLIDES = 1,  /* One chapter per slide, titled with the first caption line or "Slide N" (slideshow only) */
+} ChapterMode;
+
+/**
+ * Called with the error of a hardware encoder before the encode is run again
+ * with the software encoder. The message is only valid during the call, which
+ * may come from another thread.
+ */
+typedef void (*FallbackCallback)(ErrorCode code, const char* message, void* user_data);
+
+/**
+ * Called with each name of a list. The name is only valid during the call.
+ */
+typedef void (*NameCallback)(const char* name, void* user_data);
+
+/**
+ * Severity of a log event
+ */
+typedef enum {
+    LOG_LEVEL_DEBUG = 0,  /* Details of the progress of a job */
+    LOG_LEVEL_INFO = 1,   /* Stages and choices of a job */
+    LOG_LEVEL_WARN = 2,   /* Something went wrong, but the job carries on */
+} LogLevel;
+
+/**
+ * Attribute of a log event: text, or a number when text is NULL
+ */
+typedef struct {
+    const char* key;   /* Name of the attribute, such as "stage" */
+    const char* text;  /* Text value, NULL for a number */
+    int64_t number;    /* Number value when text is NULL */
+} LogAttr;
+
+/**
+ * Called with a structured event of a job: a stage started ("stage started"
+ * with "stage" of load, encode or mux), the encoder chosen ("encoder chosen"
+ * with "codec", "encoder" and "hardware", 1 for a hardware encoder), a
+ * fallback to the software encoder, a slide skipped, or the job finished. The
+ * message is fixed for each kind of event.
+ * Strings are only valid during the call, which may come from another thread.
+ */
+typedef void (*LogCallback)(LogLevel level, const char* message, const LogAttr* attrs,
+                            size_t attr_count, void* user_data);
+
+/**
+ * What a non-fatal warning of a job is about
+ */
+typedef enum {
+    WARNING_SIZE_ROUNDED = 0,       /* Frame size rounded down to even dimensions */
+    WARNING_AUDIO_TRUNCATED = 1,    /* Background music or narration cut to the video or its slide */
+    WARNING_SLIDE_SKIPPED = 2,      /* Slide left out as its image or narration could not be read */
+    WARNING_HARDWARE_FALLBACK = 3,  /* Hardware encoder failed and the video was encoded in software */
+} WarningKind;
+
+/**
+ * Called with a non-fatal warning of a job, which carries on. The message, in
+ * English, is only valid during the call, which may come from another thread.
+ */
+typedef void (*WarningCallback)(WarningKind kind, const char* message, void* user_data);
+
+/**
+ * How juxtapose arranges its two videos
+ */
+typedef enum {
+    JUXTAPOSE_LAYOUT_HORIZONTAL = 0,  /* Side by side, the left video first */
+    JUXTAPOSE_LAYOUT_VERTICAL = 1,    /* Stacked, the left video on top */
+    JUXTAPOSE_LAYOUT_AUTO = 2,        /* Side by side for portrait videos, stacked for landscape ones */
+} JuxtaposeLayout;
+
+/**
+ * How slides with a different aspect ratio are fitted to the output size
+ */
+typedef enum {
+    SLIDE_FIT_STRETCH = 0,  /* Resize to the output size, ignoring the aspect ratio */
+    SLIDE_FIT_COVER = 1,    /* Fill the frame, cropping the overflow */
+    SLIDE_FIT_CONTAIN = 2,  /* Fit inside the frame, padding with black */
+} SlideFit;
+
+/**
+ * Where cover-cropped slides keep their content
+ */
+typedef enum {
+    CROP_FOCUS_CENTER = 0,   /* Keep the center of the image */
+    CROP_FOCUS_SALIENT = 1,  /* Keep faces (skin tones) and detailed regions in frame */
+} CropFocus;
+
+/**
+ * How frames that repeat the previous frame are encoded
+ */
+typedef enum {
+    IDLE_FRAMES_KEEP = 0,   /* Encode every frame */
+    IDLE_FRAMES_MERGE = 1,  /* Encode a run of identical frames once, shown for the whole run */
+} IdleFrames;
+
+/**
+ * Whether encoding may lose detail
+ */
+typedef enum {
+    COMPRESSION_LOSSY = 0,     /* Lossy compression at the requested quality */
+    COMPRESSION_LOSSLESS = 1,  /* Lossless YUV 4:4:4 (AV1, H.264 on Linux, VP9 and PNG) */
+} Compression;
+
+/**
+ * What happens to transparent pixels of slides
+ */
+typedef enum {
+    TRANSPARENCY_OPAQUE = 0,  /* Drop the alpha channel */
+    TRANSPARENCY_KEEP = 1,    /* Keep the alpha channel (VP8/VP9 in WebM, PNG in APNG; slideshow only) */
+} Transparency;
+
+/**
+ * Chroma resolution of the encoded video
+ */
+typedef enum {
+    PIXEL_FORMAT_AUTO = 0,    /* The codec's usual format (4:2:0; 4:4:4 when lossless, 4:2:2 for ProRes) */
+    PIXEL_FORMAT_YUV420 = 1,  /* Chroma at half the width and height (yuv420p) */
+    PIXEL_FORMAT_YUV422 = 2,  /* Chroma at half the width (AV1, H.264 on Linux, HEVC, VP9, ProRes) */
+    PIXEL_FORMAT_YUV444 = 3,  /* Full chroma resolution (AV1, H.264 on Linux, HEVC, VP9, ProRes 4444) */
+} PixelFormat;
+
+/**
+ * Bits per sample of the encoded video
+ */
+typedef enum {
+    BIT_DEPTH_8 = 0,   /* 8 bits */
+    BIT_DEPTH_10 = 1,  /* 10 bits, avoiding banding in gradients (AV1, H.264 on Linux, HEVC, VP9; ProRes is always 10-bit) */
+} BitDepth;
+
+/**
+ * Dynamic range of the encoded video
+ */
+typedef enum {
+    DYNAMIC_RANGE_SDR = 0,    /* Standard dynamic range (BT.709); HDR inputs are tone mapped */
+    DYNAMIC_RANGE_HDR10 = 1,  /* HDR10: PQ, BT.2020 and mastering display metadata (AV1, VP9, HEVC at 10 bits; slideshow and juxtapose) */
+} DynamicRange;
+
+/**
+ * Color primaries tagged on the encoded video (the frames are not converted)
+ */
+typedef enum {
+    COLOR_PRIMARIES_AUTO = 0,        /* BT.709 (sRGB), or BT.2020 for HDR10 */
+    COLOR_PRIMARIES_BT709 = 1,       /* BT.709, shared with sRGB */
+    COLOR_PRIMARIES_BT601 = 2,       /* BT.601 525-line (SMPTE 170M) */
+    COLOR_PRIMARIES_BT2020 = 3,      /* BT.2020 */
+    COLOR_PRIMARIES_DISPLAY_P3 = 4,  /* Display P3, as photos from recent phones use (not H.264 on Windows) */
+} ColorPrimaries;
+
+/**
+ * Transfer characteristics tagged on the encoded video (the frames are not converted)
+ */
+typedef enum {
+    COLOR_TRANSFER_AUTO = 0,   /* BT.709 for video, sRGB for AVIF, or PQ for HDR10 */
+    COLOR_TRANSFER_BT709 = 1,  /* BT.709, as video is usually tagged */
+    COLOR_TRANSFER_SRGB = 2,   /* sRGB (IEC 61966-2-1), as images are usually tagged */
+} ColorTransfer;
+
+/**
+ * Matrix converting the RGB frames to YUV
+ */
+typedef enum {
+    COLOR_MATRIX_AUTO = 0,    /* BT.709 for video, BT.601 for AVIF, or BT.2020 for HDR10 */
+    COLOR_MATRIX_BT709 = 1,   /* BT.709 */
+    COLOR_MATRIX_BT601 = 2,   /* BT.601, for older players that ignore the tags of SD video */
+    COLOR_MATRIX_BT2020 = 3,  /* BT.2020 non-constant luminance */
+} ColorMatrix;
+
+/**
+ * Range of the YUV samples of the encoded video
+ */
+typedef enum {
+    COLOR_RANGE_AUTO = 0,     /* Limited for video, full for AVIF */
+    COLOR_RANGE_LIMITED = 1,  /* Limited (studio) range, 16-235 at 8 bits */
+    COLOR_RANGE_FULL = 2,     /* Full range, 0-255 at 8 bits (not ProRes, nor H.264 on macOS) */
+} ColorRange;
+
+/**
+ * Segment format of HLS output
+ */
+typedef enum {
+    HLS_SEGMENT_TS = 0,    /* MPEG-TS segments (.ts) */
+    HLS_SEGMENT_FMP4 = 1,  /* Fragmented MP4 segments (.m4s) with an initialization segment */
+} HlsSegment;
+
+/**
+ * Number of encoding passes
+ */
+typedef enum {
+    PASSES_ONE = 0,  /* A single pass */
+    PASSES_TWO = 1,  /* Statistics pass and encoding pass, for consistent quality (VP9/VP8, slideshow only) */
+} Passes;
+
+/**
+ * Layout of MP4 output
+ */
+typedef enum {
+    MP4_LAYOUT_FASTSTART = 0,   /* Movie box before the media data, for playback while downloading */
+    MP4_LAYOUT_FRAGMENTED = 1,  /* Empty movie box and one fragment per keyframe interval, for streaming */
+    MP4_LAYOUT_MOOV_AT_END = 2, /* Movie box where the muxer wrote it, skipping the pass that moves it */
+} Mp4Layout;
+
+/**
+ * Whether the video is written as one file or as separate segment files
+ */
+typedef enum {
+    OUTPUT_MODE_SINGLE = 0,    /* A single file at the output path */
+    OUTPUT_MODE_SEGMENTS = 1,  /* Keyframe-aligned segment files and a JSON manifest at the output path (MP4/MOV/WebM/MKV, video only) */
+} OutputMode;
+
+/**
+ * Tone mapping operator for HDR inputs (PQ/HLG videos, floating-point images)
+ */
+typedef enum {
+    TONE_MAP_HABLE = 0,     /* Filmic curve with soft highlights */
+    TONE_MAP_REINHARD = 1,  /* Simple curve that keeps more midtone contrast */
+    TONE_MAP_MOBIUS = 2,    /* Linear up to the midtones, compressing only the highlights */
+    TONE_MAP_CLIP = 3,      /* Clip values above SDR white */
+} ToneMap;
+
+/**
+ * How slides are compared when looking for duplicates
+ */
+typedef enum {
+    DUPLICATE_MATCH_EXACT = 0,    /* Pixel-identical images */
+    DUPLICATE_MATCH_SIMILAR = 1,  /* Same-size images that differ only by noise (e.g. re-compression) */
+} DuplicateMatch;
+
+/**
+ * How a slideshow preview lays out its slides
+ */
+typedef enum {
+    PREVIEW_LAYOUT_STRIP = 0,  /* All slides side by side in one image */
+    PREVIEW_LAYOUT_FILES = 1,  /* One image per slide, numbered from 1 beside the preview path */
+} PreviewLayout;
+
+/**
+ * Time range in milliseconds
+ *
+ * Negative values are measured back from the end of the video.
+ */
+typedef struct {
+    int64_t start_ms;  /* Start of the range */
+    int64_t end_ms;    /* End of the range (0 for the end of the video) */
+} TimeRange;
+
+/**
+ * Chapter marker players can jump to
+ */
+typedef struct {
+    uint64_t start_ms;  /* Start of the chapter in milliseconds */
+    const char* title;  /* Title shown by players (up to 255 bytes in MP4) */
+} Chapter;
+
+/**
+ * Logo drawn over the video
+ *
+ * Animated GIF, PNG and WebP logos play in a loop.
+ */
+typedef struct {
+    const char* path;          /* Logo image file */
+    Anchor anchor;             /* Logo position (ANCHOR_AUTO for the top left) */
+    uint32_t width;            /* Logo width in percent of the frame width (0 for 15) */
+    uint32_t fade_ms;          /* Fade in and out at the edges of each range in milliseconds */
+    const TimeRange* ranges;   /* Ranges in which the logo is shown (NULL for the whole video) */
+    size_t range_count;        /* Number of ranges */
+} LogoParams;
+
+/**
+ * Frame rate of output when none is set, and the highest output frame rate
+ */
+#define MINMPEG_DEFAULT_FPS 30
+#define MINMPEG_MAX_FPS 120
+
+/**
+ * Encoders kept warm across jobs, created by minmpeg_session_new
+ */
+typedef struct Session Session;
+
+/**
+ * Optional encoding parameters
+ *
+ * Zero-initialize the structure and set only the fields you need.
+ * NULL pointers and zero values select the defaults.
+ */
+typedef struct {
+    const char* font;             /* Caption font name (NULL for the bundled default font) */
+    WritingMode writing_mode;     /* Caption writing mode */
+    TextAlign caption_align;      /* Caption line alignment */
+    TextFit caption_fit;          /* Caption fitting */
+    uint32_t caption_max_width;   /* Maximum caption width in percent of the frame (0 for 90) */
+    const char* background_music; /* Background music file for slideshows (NULL for none, requires ffmpeg) */
+    Anchor caption_anchor;        /* Caption position */
+    uint32_t safe_area;           /* Safe-area margin per edge in percent of the frame (0 for 5, max 40) */
+    const char* slide_number;     /* Slide number format with {n} and {total} (NULL for none, "" for "{n} / {total}") */
+    Anchor slide_number_anchor;   /* Slide number position (ANCHOR_AUTO for the corner away from captions) */
+    const LogoParams* logo;       /* Logo drawn over the video (NULL for none) */
+    DecodeMode decode_mode;       /* How juxtapose input videos are decoded */
+    float loudness;               /* Target slideshow audio loudness in LUFS (0 for none, -70 to -5) */
+    ToneMap tone_map;             /* Tone mapping operator for HDR inputs */
+    float audio_gain;             /* Gain of slideshow audio in dB (-60 to 20) */
+    uint32_t audio_fade_in_ms;    /* Fade-in at the start of slideshow audio in milliseconds */
+    uint32_t audio_fade_out_ms;   /* Fade-out at the end of slideshow audio in milliseconds */
+    float duck_threshold;         /* Narration level that lowers background music in dBFS (0 for -30, -60 to -1) */
+    float duck_amount;            /* Reduction of background music under narration in dB (0 for 12, 1 to 18) */
+    uint32_t enhance;             /* Auto white balance and contrast strength per slide in percent (0 for none) */
+    SlideFit slide_fit;           /* How slides are fitted to the output size */
+    CropFocus crop_focus;         /* Where cover-cropped slides keep their content */
+    IdleFrames idle_frames;       /* How frames that repeat the previous frame are encoded */
+    uint32_t time_budget_ms;      /* Wall-clock time to finish within in milliseconds (0 for none) */
+    Compression compression;      /* Whether encoding may lose detail */
+    Transparency transparency;    /* What happens to transparent pixels of slides */
+    HlsSegment hls_segment;       /* Segment format of HLS output */
+    uint32_t segment_duration_ms; /* Target duration of streaming segments in milliseconds (0 for 6000) */
+    Mp4Layout mp4_layout;         /* Layout of MP4 output */
+    Passes passes;                /* Number of encoding passes (slideshow only) */
+    uint32_t bitrate_kbps;        /* Average video bitrate in kbit/s, replacing the quality (0 for none) */
+    uint32_t max_bitrate_kbps;    /* Peak video bitrate in kbit/s (0 for none) */
+    uint32_t buffer_size_kbits;   /* Decoder buffer for the peak in kbit (0 for 2 s at the peak) */
+    OutputMode output_mode;       /* Whether the video is written as one file or as segment files */
+    uint64_t target_size_bytes;   /* File size to aim for in bytes, setting the bitrate (0 for none) */
+    uint32_t fps;                 /* Output frame rate (0 for MINMPEG_DEFAULT_FPS, up to MINMPEG_MAX_FPS) */
+    const char* left_subtitles;   /* SRT or WebVTT file drawn on the left video (juxtapose only, NULL for none) */
+    const char* right_subtitles;  /* SRT or WebVTT file drawn on the right video (juxtapose only, NULL for none) */
+    uint32_t keyframe_interval_ms; /* Milliseconds between keyframes (0 for the encoder's placement; a segment in segment output) */
+    const uint64_t* keyframe_times_ms; /* Times of forced keyframes in milliseconds (NULL for none) */
+    size_t keyframe_time_count;   /* Number of forced keyframe times */
+    PixelFormat pixel_format;     /* Chroma resolution of the encoded video */
+    BitDepth bit_depth;           /* Bits per sample of the encoded video */
+    DynamicRange dynamic_range;   /* Dynamic range of the encoded video */
+    uint32_t max_luminance;       /* Mastering display peak of HDR10 output in cd/m2 (400-10000; 0 for 1000) */
+    ColorPrimaries color_primaries; /* Color primaries tagged on the video */
+    ColorTransfer color_transfer; /* Transfer characteristics tagged on the video */
+    ColorMatrix color_matrix;     /* Matrix converting the frames to YUV */
+    ColorRange color_range;       /* Range of the YUV samples */
+    JuxtaposeLayout juxtapose_layout; /* How juxtapose arranges its two videos */
+    HwAccel hw_accel;             /* Encoder implementation of H.264, HEVC and AV1 */
+    HwFallback hw_fallback;       /* What happens when the hardware encoder is unavailable or fails */
+    FallbackCallback on_fallback; /* Called before falling back to software (NULL for none) */
+    void* fallback_user_data;     /* Passed to on_fallback */
+    OutputCheck output_check;     /* Whether the output is checked after rendering (requires ffmpeg) */
+    uint32_t threads;             /* Threads of the video encoder and of decoding slides (0 for the default, about one per core) */
+    Av1Backend av1_backend;       /* Software encoder of AV1 video */
+    uint8_t av1_preset;           /* Speed preset of the AV1 backend (1 slowest to 10 for rav1e, 13 for SVT-AV1, 8 for libaom; 0 for the default) */
+    const char* const* ffmpeg_args; /* Extra ffmpeg video encoder arguments, after the library's own (unsupported; NULL for none) */
+    size_t ffmpeg_arg_count;      /* Number of extra ffmpeg arguments */
+    H264Profile h264_profile;     /* Profile of H.264 video */
+    uint8_t h264_level;           /* Level of H.264 video times ten (31 for 3.1; 0 for the encoder's choice) */
+    H264Preset h264_preset;       /* Speed preset of libx264 */
+    H264Tune h264_tune;           /* Tuning of libx264 */
+    uint32_t memory_budget_mb;    /* Memory for decoding and keeping slide images in megabytes, half of it shared by the decoding threads; frames being encoded, encoders, ffmpeg and encoded video are not counted (0 for none) */
+    const Session* session;       /* Session keeping encoders warm across jobs (NULL for none) */
+    Priority priority;            /* CPU and I/O priority of the job (streaming slideshows take PRIORITY_NORMAL only) */
+    const char* temp_dir;         /* Directory of two-pass statistics and written audio files (NULL for the output's directory) */
+    LogCallback on_log;           /* Called with structured events of the job (NULL for none) */
+    void* log_user_data;          /* Passed to on_log */
+    WarningCallback on_warning;   /* Called with non-fatal warnings of the job (NULL for none) */
+    void* warning_user_data;      /* Passed to on_warning */
+    Reproducibility reproducibility; /* Byte-identical output between runs (not with hardware encoders or a time budget) */
+    ChapterMode chapter_mode;     /* Where the chapter markers of MP4, WebM and MKV output come from */
+    const Chapter* chapters;      /* Chapter markers in order of their start, with CHAPTER_MODE_LIST (NULL for none) */
+    size_t chapter_count;         /* Number of chapter markers */
+    MessageLanguage language;     /* Language of the error messages of the call, and of a writer's later calls */
+} EncodeParams;
+
+/**
+ * How audio is fitted to the video length
+ */
+typedef enum {
+    AUDIO_FIT_TRIM = 0,  /* Cut at the end of the video; shorter audio ends early */
+    AUDIO_FIT_LOOP = 1,  /* Repeat shorter audio until the end of the video */
+} AudioFit;
+
+/**
+ * Audio codecs for extracted audio files
+ */
+typedef enum {
+    AUDIO_CODEC_AUTO = 0,  /* Chosen by the output extension */
+    AUDIO_CODEC_MP3 = 1,   /* MP3 (.mp3) */
+    AUDIO_CODEC_AAC = 2,   /* AAC (.m4a or .aac) */
+    AUDIO_CODEC_OPUS = 3,  /* Opus (.opus or .ogg) */
+    AUDIO_CODEC_WAV = 4,   /* Uncompressed 16-bit PCM (.wav) */
+} AudioCodec;
+
+/**
+ * Optional audio parameters
+ *
+ * Zero-initialize the structure and set only the fields you need.
+ */
+typedef struct {
+    AudioFit fit;          /* How audio is fitted to the video length */
+    AudioCodec codec;      /* Codec of extracted audio */
+    uint32_t bitrate;      /* Bitrate in kbit/s (0 for the encoder default, ignored for WAV) */
+    float gain;            /* Gain in dB (-60 to 20) */
+    uint32_t fade_in_ms;   /* Fade-in at the start of the audio in milliseconds */
+    uint32_t fade_out_ms;  /* Fade-out at the end of the audio in milliseconds */
+} AudioParams;
+
+/**
+ * Luma statistics of a frame or a whole input
+ *
+ * Luma is computed with BT.709 weights on a 0-255 scale.
+ */
+typedef struct {
+    uint64_t histogram[256];  /* Number of pixels at each luma level */
+    uint64_t pixel_count;     /* Total number of pixels */
+    float mean;               /* Mean luma (0-255) */
+    float shadow_clip;        /* Fraction of pixels with clipped shadows (luma 0-2) */
+    float highlight_clip;     /* Fraction of pixels with clipped highlights (luma 253-255) */
+} LumaStats;
+
+/**
+ * Perceptual hash of a sampled frame
+ *
+ * Frames with the same content have hashes within
+ * MINMPEG_FRAME_HASH_MATCH_DISTANCE differing bits.
+ */
+typedef struct {
+    uint64_t time_ms;         /* Time of the frame in milliseconds */
+    uint64_t hash;            /* 64-bit perceptual hash */
+} FrameHash;
+
+/**
+ * Largest number of differing bits between hashes of frames with the same content
+ */
+#define MINMPEG_FRAME_HASH_MATCH_DISTANCE 10
+
+/**
+ * A container, codec and encoder combination usable on this machine
+ */
+typedef struct {
+    Container container;      /* Container of the output */
+    Codec codec;              /* Video codec in the container */
+    HwAccel hw_accel;         /* HW_ACCEL_SOFTWARE, or the hardware encoder of H.264, HEVC and AV1 (never AUTO) */
+} Capability;
+
+/**
+ * Workload of an encoder benchmark
+ *
+ * Zero values (NULL pointers, 0 numbers) select the defaults.
+ */
+typedef struct {
+    const Codec* codecs;      /* Codecs to measure, each in its usual container (NULL for all available) */
+    size_t codec_count;       /* Number of codecs */
+    uint32_t width;           /* Frame width (0 for 1280) */
+    uint32_t height;          /* Frame height (0 for 720) */
+    uint32_t duration_ms;     /* Duration of the workload (0 for 5 seconds) */
+    uint32_t fps;             /* Frame rate (0 for MINMPEG_DEFAULT_FPS) */
+    uint8_t quality;          /* Quality (1-100, 0 for 50) */
+    const char* output_dir;   /* Directory for the encoded files, removed after measuring (NULL for the temp dir) */
+    const char* ffmpeg_path;  /* Optional path to ffmpeg, NULL for PATH */
+} BenchmarkSpec;
+
+/**
+ * Measurements of one codec in a benchmark
+ *
+ * Metrics the platform or ffmpeg cannot provide are -1.
+ */
+typedef struct {
+    Codec codec;              /* Codec measured */
+    Container container;      /* Container the output was written to */
+    Result error;             /* Error that stopped the encode (code MINMPEG_OK when it finished) */
+    uint32_t frames;          /* Number of frames encoded */
+    uint64_t encode_ms;       /* Wall-clock time of the encode in milliseconds */
+    double encode_fps;        /* Frames encoded per second */
+    double cpu_percent;       /* CPU time over wall-clock time in percent of one core */
+    uint64_t output_bytes;    /* Size of the output in bytes */
+    uint32_t bitrate_kbps;    /* Average bitrate of the output in kbit/s */
+    double psnr;              /* PSNR of the RGB channels in dB (100 for identical frames) */
+    double ssim;              /* Mean SSIM of the luma (1.0 for identical frames) */
+} BenchmarkResult;
+
+/**
+ * Result of an encoder benchmark
+ */
+typedef struct {
+    uint32_t width;           /* Frame width of the workload */
+    uint32_t height;          /* Frame height of the workload */
+    uint32_t fps;             /* Frame rate of the workload */
+    uint32_t frames;          /* Number of frames of the workload */
+    uint32_t cpu_count;       /* Number of CPU cores available to the process */
+    BenchmarkResult* results; /* Measurements of each codec (freed with minmpeg_free_benchmark_report) */
+    size_t result_count;      /* Number of results */
+} BenchmarkReport;
+
+/**
+ * Size limit of common mail services for attachments (8 MiB)
+ */
+#define MINMPEG_EMAIL_MAX_BYTES (8ULL * 1024 * 1024)
+
+/**
+ * Settings chosen to fit a slideshow in a size limit
+ */
+typedef struct {
+    uint8_t quality;   /* Quality (0-100) */
+    uint32_t scale;    /* Resolution in percent of the first slide */
+    uint32_t width;    /* Output width in pixels */
+    uint32_t height;   /* Output height in pixels */
+    uint64_t size;     /* Output file size in bytes */
+} SizedSettings;
+
+/**
+ * RGB color
+ */
+typedef struct {
+    uint8_t r;
+    uint8_t g;
+    uint8_t b;
+} Color;
+
+/**
+ * Style of an audio visualization
+ */
+typedef enum {
+    VISUAL_STYLE_WAVEFORM = 0,  /* Waveform of the audio */
+    VISUAL_STYLE_SPECTRUM = 1,  /* Frequency spectrum bars */
+} VisualStyle;
+
+/**
+ * Audio visualization parameters
+ *
+ * Zero-initialize the structure and set only the fields you need.
+ */
+typedef struct {
+    VisualStyle style;             /* Waveform or spectrum */
+    uint32_t width;                /* Output width in pixels (0 for 1280) */
+    uint32_t height;               /* Output height in pixels (0 for 720) */
+    const Color* color;            /* Color of the visualization (NULL for white) */
+    const Color* background;       /* Background color (NULL for black) */
+    const char* background_image;  /* Background image, cropped to fill the frame (NULL for none) */
+} VisualizeParams;
+
+/**
+ * Environment variable with the path of ffmpeg used when a function is given
+ * no ffmpeg_path, before PATH and common install locations are searched
+ */
+#define MINMPEG_FFMPEG_ENV "MINMPEG_FFMPEG"
+
+/**
+ * An ffmpeg executable found by the library
+ */
+typedef struct {
+    char* path;      /* Path of the executable, or its name when found in PATH */
+    char* version;   /* Version reported by ffmpeg, such as "6.1.1" */
+    char* encoders;  /* Names of the enabled encoders separated by spaces */
+} FFmpegInfo;
+
+/**
+ * Check if a codec is available on this system
+ *
+ * @param codec         The codec to check
+ * @param ffmpeg_path   Optional path to ffmpeg executable (for H.264 on Linux, VP9, VP8 and HEVC)
+ *                      Pass NULL to search in PATH
+ * @return              Result with code MINMPEG_OK if available
+ */
+Result minmpeg_available(Codec codec, const char* ffmpeg_path);
+
+/**
+ * Find ffmpeg and report its version and encoders
+ *
+ * Without ffmpeg_path, ffmpeg is taken from the MINMPEG_FFMPEG environment
+ * variable or searched in PATH and common install locations, as every
+ * function does when its ffmpeg_path is NULL.
+ *
+ * @param ffmpeg_path   Optional path to ffmpeg executable, NULL to search
+ * @param info          Receives the executable; free its strings with
+ *                      minmpeg_free_ffmpeg_info
+ * @return              Result with code MINMPEG_OK on success
+ */
+Result minmpeg_find_ffmpeg(const char* ffmpeg_path, FFmpegInfo* info);
+
+/**
+ * Free the strings of an info filled by minmpeg_find_ffmpeg
+ *
+ * @param info          Info filled by minmpeg_find_ffmpeg (may be NULL)
+ */
+void minmpeg_free_ffmpeg_info(FFmpegInfo* info);
+
+/**
+ * List the container, codec and encoder combinations usable on this machine
+ *
+ * Each available codec is listed with every container that holds it. H.264
+ * and HEVC are listed once per working encoder, found by encoding a frame with
+ * the software encoder and each hardware encoder, which takes a moment; other
+ * codecs are listed with HW_ACCEL_SOFTWARE.
+ *
+ * @param ffmpeg_path       Optional path to ffmpeg executable, NULL for PATH
+ * @param capabilities      Receives an array of combinations ordered by container,
+ *                          codec and encoder; free it with minmpeg_free_capabilities
+ * @param capability_count  Receives the number of combinations
+ * @return                  Result with code MINMPEG_OK on success
+ */
+Result minmpeg_capabilities(
+    const char* ffmpeg_path,
+    Capability** capabilities,
+    size_t* capability_count
+);
+
+/**
+ * Free capabilities returned by minmpeg_capabilities
+ *
+ * @param capabilities      Array returned by minmpeg_capabilities (may be NULL)
+ * @param capability_count  Number of combinations in the array
+ */
+void minmpeg_free_capabilities(Capability* capabilities, size_t capability_count);
+
+/**
+ * Create a session keeping encoders warm across jobs
+ *
+ * Jobs given the session in EncodeParams.session take a spare encoder of
+ * their configuration if the session has one, skipping its startup, and the
+ * session starts another in the background for the next job. A session may
+ * be used by many jobs at once.
+ *
+ * @return  Session to free with minmpeg_session_free
+ */
+Session* minmpeg_session_new(void);
+
+/**
+ * Free a session, stopping its spare encoders once running jobs are done
+ *
+ * @param session  Session returned by minmpeg_session_new (may be NULL)
+ */
+void minmpeg_session_free(Session* session);
+
+/**
+ * Create a slideshow video from a sequence of images
+ *
+ * All images are resized to match the dimensions of the first image.
+ * The output video frame rate is 30 fps.
+ *
+ * @param entries       Array of slide entries
+ * @param entry_count   Number of entries in the array
+ * @param output_path   Path to the output video file
+ * @param container     Container format (MP4, WebM, AVIF or APNG)
+ * @param codec         Video codec (AV1, H264 or PNG)
+ * @param quality       Quality (0-100, where 100 is highest quality)
+ * @param ffmpeg_path   Optional path to ffmpeg (for H.264 on Linux), NULL for PATH
+ * @param params        Optional encoding parameters, NULL for defaults
+ * @return              Result with code MINMPEG_OK on success
+ */
+Result minmpeg_slideshow(
+    const SlideEntry* entries,
+    size_t entry_count,
+    const char* output_path,
+    Container container,
+    Codec codec,
+    uint8_t quality,
+    const char* ffmpeg_path,
+    const EncodeParams* params
+);
+
+/**
+ * Create a slideshow from slides yielded one at a time
+ *
+ * Like minmpeg_slideshow, but each slide is asked for, loaded and encoded
+ * before the next, so very long slideshows need not be held in memory. The
+ * first slide sets the output size. Slide number formats with {total}, logo
+ * ranges measured from the end, time budgets, target sizes, two-pass
+ * encoding and software fallback fail with MINMPEG_ERR_INVALID_INPUT, and
+ * HDR10 output has no content light levels.
+ *
+ * @param next          Called for each slide, on the calling thread
+ * @param user_data     Passed to next
+ * @param output_path   Path to the output video file
+ * @param container     Container format
+ * @param codec         Video codec
+ * @param quality       Quality (0-100, where 100 is highest quality)
+ * @param ffmpeg_path   Optional path to ffmpeg, NULL for PATH
+ * @param params        Optional encoding parameters, NULL for defaults
+ * @return              Result with code MINMPEG_OK on success
+ */
+Result minmpeg_slideshow_stream(
+    SlideSource next,
+    void* user_data,
+    const char* output_path,
+    Container container,
+    Codec codec,
+    uint8_t quality,
+    const char* ffmpeg_path,
+    const EncodeParams* params
+);
 
 /**
- * Video codec types
+ * Video written frame by frame, created by minmpeg_writer_new
  */
-typedef enum {
-    CODEC_AV1 = 0,
-    CODEC_H264 = 1,
-} Codec;
+typedef struct VideoWriter VideoWriter;
 
 /**
- * Error codes
+ * Start writing a video frame by frame
+ *
+ * Frames of 8-bit RGBA pixels are encoded as they are written, at the frame
+ * rate of params, and the output is written by minmpeg_writer_finish. The
+ * logo and background music of params are added. Logo ranges measured from
+ * the end, time budgets, target sizes, two-pass encoding, software
+ * fallback, lowered priorities and HDR10 output fail with
+ * MINMPEG_ERR_INVALID_INPUT. A writer is used by one thread at a time.
+ *
+ * @param output_path   Path to the output video file
+ * @param width         Frame width in pixels (even)
+ * @param height        Frame height in pixels (even)
+ * @param container     Container format
+ * @param codec         Video codec
+ * @param quality       Quality (0-100, where 100 is highest quality)
+ * @param ffmpeg_path   Optional path to ffmpeg, NULL for PATH
+ * @param params        Optional encoding parameters, NULL for defaults
+ * @param writer        Receives the writer, to finish with
+ *                      minmpeg_writer_finish or free with minmpeg_writer_free
+ * @return              Result with code MINMPEG_OK on success
  */
-typedef enum {
-    MINMPEG_OK = 0,
-    MINMPEG_ERR_INVALID_INPUT = 1,
-    MINMPEG_ERR_CODEC_UNAVAILABLE = 2,
-    MINMPEG_ERR_CONTAINER_CODEC_MISMATCH = 3,
-    MINMPEG_ERR_IO_ERROR = 4,
-    MINMPEG_ERR_ENCODE_ERROR = 5,
-    MINMPEG_ERR_DECODE_ERROR = 6,
-} ErrorCode;
+Result minmpeg_writer_new(
+    const char* output_path,
+    uint32_t width,
+    uint32_t height,
+    Container container,
+    Codec codec,
+    uint8_t quality,
+    const char* ffmpeg_path,
+    const EncodeParams* params,
+    VideoWriter** writer
+);
 
 /**
- * Result structure returned by minmpeg functions
+ * Encode a frame
+ *
+ * @param writer  Writer returned by minmpeg_writer_new
+ * @param rgba    RGBA pixels, row by row from the top left
+ * @param size    Size of rgba in bytes (width * height * 4)
+ * @return        Result with code MINMPEG_OK on success
  */
-typedef struct {
-    ErrorCode code;
-    char* message;  /* Error message (must be freed with minmpeg_free_result) */
-} Result;
+Result minmpeg_writer_write(VideoWriter* writer, const uint8_t* rgba, size_t size);
 
 /**
- * Slide entry for slideshow creation
+ * Add audio samples to the audio of a writer (requires ffmpeg)
+ *
+ * The audio plays from the start of the video and is cut or padded with
+ * silence to its length; background music is lowered under it. Every call
+ * must give the same sample rate and channels. With segment output, audio
+ * must be written before the first frame; otherwise audio first written
+ * after frames makes the finished output be muxed again with it.
+ *
+ * @param writer        Writer returned by minmpeg_writer_new
+ * @param samples       Interleaved 16-bit PCM samples, one per channel at a time
+ * @param sample_count  Number of samples of all channels
+ * @param sample_rate   Sample rate in Hz (8000-192000)
+ * @param channels      Number of channels (1-8)
+ * @return              Result with code MINMPEG_OK on success
  */
-typedef struct {
-    const char* path;      /* Path to the image file */
-    uint32_t duration_ms;  /* Duration to display this image in milliseconds */
-} SlideEntry;
+Result minmpeg_writer_write_audio(
+    VideoWriter* writer,
+    const int16_t* samples,
+    size_t sample_count,
+    uint32_t sample_rate,
+    uint16_t channels
+);
 
 /**
- * RGB color
+ * Write the output of a writer and free it, whether or not it succeeds
+ *
+ * @param writer  Writer returned by minmpeg_writer_new
+ * @return        Result with code MINMPEG_OK on success
  */
-typedef struct {
-    uint8_t r;
-    uint8_t g;
-    uint8_t b;
-} Color;
+Result minmpeg_writer_finish(VideoWriter* writer);
 
 /**
- * Check if a codec is available on this system
+ * Free a writer without writing its output
  *
- * @param codec         The codec to check
- * @param ffmpeg_path   Optional path to ffmpeg executable (for H.264 on Linux)
- *                      Pass NULL to search in PATH
- * @return              Result with code MINMPEG_OK if available
+ * @param writer  Writer returned by minmpeg_writer_new (may be NULL)
  */
-Result minmpeg_available(Codec codec, const char* ffmpeg_path);
+void minmpeg_writer_free(VideoWriter* writer);
 
 /**
- * Create a slideshow video from a sequence of images
+ * Video read frame by frame, created by minmpeg_reader_open
+ */
+typedef struct VideoReader VideoReader;
+
+/**
+ * Start reading the frames of a video (requires ffmpeg)
  *
- * All images are resized to match the dimensions of the first image.
- * The output video frame rate is 30 fps.
+ * Frames are decoded as 8-bit RGBA at a constant rate; variable frame rate
+ * videos repeat or drop frames to fit it. HDR videos are tone mapped to
+ * SDR. A reader is used by one thread at a time.
+ *
+ * @param path          Path to the video file
+ * @param fps           Frames per second to sample, 0 for the video's rate
+ * @param ffmpeg_path   Optional path to ffmpeg, NULL for PATH
+ * @param reader        Receives the reader, to free with minmpeg_reader_free
+ * @param width         Receives the frame width in pixels
+ * @param height        Receives the frame height in pixels
+ * @return              Result with code MINMPEG_OK on success
+ */
+Result minmpeg_reader_open(
+    const char* path,
+    double fps,
+    const char* ffmpeg_path,
+    VideoReader** reader,
+    uint32_t* width,
+    uint32_t* height
+);
+
+/**
+ * Decode the next frame
+ *
+ * @param reader   Reader returned by minmpeg_reader_open
+ * @param rgba     Receives RGBA pixels, row by row from the top left
+ * @param size     Size of rgba in bytes (width * height * 4)
+ * @param time_ms  Receives the time of the frame in milliseconds, or -1
+ *                 after the last frame
+ * @return         Result with code MINMPEG_OK on success
+ */
+Result minmpeg_reader_read(VideoReader* reader, uint8_t* rgba, size_t size, int64_t* time_ms);
+
+/**
+ * Stop reading a video and free its reader
+ *
+ * @param reader  Reader returned by minmpeg_reader_open (may be NULL)
+ */
+void minmpeg_reader_free(VideoReader* reader);
+
+/**
+ * Create a slideshow, skipping slides that cannot be used
+ *
+ * Like minmpeg_slideshow, but a slide whose image cannot be loaded or whose
+ * narration cannot be read is left out instead of failing the whole video.
+ * Fails when no slide can be used or on errors unrelated to a single slide.
  *
  * @param entries       Array of slide entries
  * @param entry_count   Number of entries in the array
  * @param output_path   Path to the output video file
- * @param container     Container format (MP4 or WebM)
- * @param codec         Video codec (AV1 or H264)
+ * @param container     Container format
+ * @param codec         Video codec
  * @param quality       Quality (0-100, where 100 is highest quality)
- * @param ffmpeg_path   Optional path to ffmpeg (for H.264 on Linux), NULL for PATH
+ * @param ffmpeg_path   Optional path to ffmpeg, NULL for PATH
+ * @param params        Optional encoding parameters, NULL for defaults
+ * @param skipped       Receives, for each slide, the code of why it was
+ *                      skipped (MINMPEG_OK when it is in the video); must
+ *                      hold entry_count elements
  * @return              Result with code MINMPEG_OK on success
  */
-Result minmpeg_slideshow(
+Result minmpeg_slideshow_skipping_invalid(
     const SlideEntry* entries,
     size_t entry_count,
     const char* output_path,
     Container container,
     Codec codec,
     uint8_t quality,
-    const char* ffmpeg_path
+    const char* ffmpeg_path,
+    const EncodeParams* params,
+    ErrorCode* skipped
+);
+
+/**
+ * Create a slideshow no larger than a file size limit
+ *
+ * Renders the slideshow and, while the file is too large, renders it again
+ * with a lower quality (down to 20) and then a lower resolution. Useful to
+ * attach videos to email with MINMPEG_EMAIL_MAX_BYTES.
+ *
+ * @param entries       Array of slide entries
+ * @param entry_count   Number of entries in the array
+ * @param output_path   Path to the output video file
+ * @param container     Container format (MP4, WebM, AVIF or APNG)
+ * @param codec         Video codec (AV1, H264, PNG, VP9, HEVC or VP8)
+ * @param quality       Quality of the first attempt (0-100)
+ * @param max_bytes     Largest allowed output file size in bytes
+ * @param ffmpeg_path   Optional path to ffmpeg, NULL for PATH
+ * @param params        Optional encoding parameters, NULL for defaults
+ * @param settings      Receives the settings of the output that fit
+ * @return              Result with code MINMPEG_OK on success
+ */
+Result minmpeg_slideshow_within_size(
+    const SlideEntry* entries,
+    size_t entry_count,
+    const char* output_path,
+    Container container,
+    Codec codec,
+    uint8_t quality,
+    uint64_t max_bytes,
+    const char* ffmpeg_path,
+    const EncodeParams* params,
+    SizedSettings* settings
+);
+
+/**
+ * Render a preview of a slideshow as PNG
+ *
+ * Draws the first frame of each slide as minmpeg_slideshow would with the
+ * same parameters, without encoding a video, so the deck can be checked
+ * quickly. HDR10 output is previewed as SDR. With PREVIEW_LAYOUT_FILES, the
+ * slides are written beside output_path numbered after its name:
+ * "deck.png" gives "deck_00001.png", "deck_00002.png", ...
+ *
+ * @param entries       Array of slide entries
+ * @param entry_count   Number of entries in the array
+ * @param output_path   Path to the output PNG file
+ * @param layout        Layout of the slides
+ * @param height        Height of each slide in pixels, keeping the aspect
+ *                      ratio (0 for the video size)
+ * @param ffmpeg_path   Optional path to ffmpeg, NULL for PATH (only needed
+ *                      to time a logo by slide narration)
+ * @param params        Optional encoding parameters, NULL for defaults
+ * @return              Result with code MINMPEG_OK on success
+ */
+Result minmpeg_preview_slideshow(
+    const SlideEntry* entries,
+    size_t entry_count,
+    const char* output_path,
+    PreviewLayout layout,
+    uint32_t height,
+    const char* ffmpeg_path,
+    const EncodeParams* params
+);
+
+/**
+ * Find duplicate consecutive slides
+ *
+ * Detects identical or near-identical consecutive slides, such as the
+ * repeated slides of decks exported from presentation tools. Slides with
+ * narration or a different caption are never merged. To merge, keep the
+ * slides with merged_into[i] == i and add the durations of the slides
+ * merged into them.
+ *
+ * @param entries       Array of slide entries
+ * @param entry_count   Number of entries in the array
+ * @param mode          How slides are compared
+ * @param merged_into   Receives, for each slide, the index of the slide it is
+ *                      merged into (its own index when kept); must hold
+ *                      entry_count elements
+ * @return              Result with code MINMPEG_OK on success
+ */
+Result minmpeg_find_duplicate_slides(
+    const SlideEntry* entries,
+    size_t entry_count,
+    DuplicateMatch mode,
+    size_t* merged_into
 );
 
 /**
@@ -119,11 +1214,12 @@ Result minmpeg_slideshow(
  * @param left_path     Path to the left video file
  * @param right_path    Path to the right video file
  * @param output_path   Path to the output video file
- * @param container     Container format (MP4 or WebM)
- * @param codec         Video codec (AV1 or H264)
+ * @param container     Container format (MP4, WebM, AVIF or APNG)
+ * @param codec         Video codec (AV1, H264 or PNG)
  * @param quality       Quality (0-100, where 100 is highest quality)
  * @param background    Background color for padding (NULL for white)
  * @param ffmpeg_path   Optional path to ffmpeg, NULL for PATH
+ * @param params        Optional encoding parameters, NULL for defaults
  * @return              Result with code MINMPEG_OK on success
  */
 Result minmpeg_juxtapose(
@@ -134,9 +1230,239 @@ Result minmpeg_juxtapose(
     Codec codec,
     uint8_t quality,
     const Color* background,
+    const char* ffmpeg_path,
+    const EncodeParams* params
+);
+
+/**
+ * Create a video visualizing an audio file
+ *
+ * The waveform or spectrum of the audio is drawn in a band along the bottom
+ * of the safe area, over the background image or color. The output lasts
+ * as long as the audio, which is muxed in with the loudness, gain and fades
+ * of the parameters. The output frame rate is 30 fps. Requires ffmpeg.
+ *
+ * @param audio_path    Path to the audio file
+ * @param output_path   Path to the output video file
+ * @param container     Container format (MP4 or WebM)
+ * @param codec         Video codec (AV1 or H264)
+ * @param quality       Quality (0-100, where 100 is highest quality)
+ * @param visual        Visualization parameters, NULL for defaults
+ * @param ffmpeg_path   Optional path to ffmpeg, NULL for PATH
+ * @param params        Optional encoding parameters, NULL for defaults
+ * @return              Result with code MINMPEG_OK on success
+ */
+Result minmpeg_visualize_audio(
+    const char* audio_path,
+    const char* output_path,
+    Container container,
+    Codec codec,
+    uint8_t quality,
+    const VisualizeParams* visual,
+    const char* ffmpeg_path,
+    const EncodeParams* params
+);
+
+/**
+ * Create a video showing a still image for the length of an audio file
+ *
+ * The image keeps its size (rounded down to even dimensions). The output
+ * lasts as long as the audio, which is muxed in with the loudness, gain and
+ * fades of the parameters. The output frame rate is 30 fps. Requires ffmpeg.
+ *
+ * @param image_path    Path to the image file
+ * @param audio_path    Path to the audio file
+ * @param output_path   Path to the output video file
+ * @param container     Container format (MP4 or WebM)
+ * @param codec         Video codec (AV1 or H264)
+ * @param quality       Quality (0-100, where 100 is highest quality)
+ * @param ffmpeg_path   Optional path to ffmpeg, NULL for PATH
+ * @param params        Optional encoding parameters, NULL for defaults
+ * @return              Result with code MINMPEG_OK on success
+ */
+Result minmpeg_image_with_audio(
+    const char* image_path,
+    const char* audio_path,
+    const char* output_path,
+    Container container,
+    Codec codec,
+    uint8_t quality,
+    const char* ffmpeg_path,
+    const EncodeParams* params
+);
+
+/**
+ * Replace the audio track of a video
+ *
+ * The video stream is copied without re-encoding and the output keeps the
+ * length of the video. Audio is encoded as AAC for .mp4/.m4v output and
+ * Opus for .webm output. Requires ffmpeg.
+ *
+ * @param video_path    Path to the input video file
+ * @param audio_path    Path to the new audio file (MP3, AAC, Opus, WAV, ...)
+ * @param output_path   Path to the output video file (.mp4, .m4v or .webm)
+ * @param ffmpeg_path   Optional path to ffmpeg, NULL for PATH
+ * @param params        Optional audio parameters, NULL for defaults
+ * @return              Result with code MINMPEG_OK on success
+ */
+Result minmpeg_replace_audio(
+    const char* video_path,
+    const char* audio_path,
+    const char* output_path,
+    const char* ffmpeg_path,
+    const AudioParams* params
+);
+
+/**
+ * Extract the audio of a video to an audio file
+ *
+ * The codec is chosen by the output extension (.mp3, .m4a, .aac, .opus,
+ * .ogg or .wav) unless set in the parameters. Requires ffmpeg.
+ *
+ * @param input_path    Path to the input video file
+ * @param output_path   Path to the output audio file
+ * @param ffmpeg_path   Optional path to ffmpeg, NULL for PATH
+ * @param params        Optional audio parameters (codec, bitrate), NULL for defaults
+ * @return              Result with code MINMPEG_OK on success
+ */
+Result minmpeg_extract_audio(
+    const char* input_path,
+    const char* output_path,
+    const char* ffmpeg_path,
+    const AudioParams* params
+);
+
+/**
+ * Remove all audio from a video
+ *
+ * The video stream is copied without re-encoding when the output container
+ * supports its codec, and re-encoded otherwise. The output format follows
+ * the output extension. Requires ffmpeg.
+ *
+ * @param input_path    Path to the input video file
+ * @param output_path   Path to the output video file
+ * @param ffmpeg_path   Optional path to ffmpeg, NULL for PATH
+ * @return              Result with code MINMPEG_OK on success
+ */
+Result minmpeg_remove_audio(
+    const char* input_path,
+    const char* output_path,
     const char* ffmpeg_path
 );
 
+/**
+ * Analyze the luma histogram and clipping of an image or video
+ *
+ * Images give a single frame; videos are decoded with ffmpeg and give one
+ * set of statistics per frame. Use the results to adjust slide exposure
+ * before rendering.
+ *
+ * @param path          Path to the image or video file
+ * @param ffmpeg_path   Optional path to ffmpeg (for videos), NULL for PATH
+ * @param aggregate     Receives the statistics of all frames together
+ * @param frames        Receives an array of per-frame statistics, or NULL to skip;
+ *                      free it with minmpeg_free_luma_stats
+ * @param frame_count   Receives the number of frames (required with frames)
+ * @return              Result with code MINMPEG_OK on success
+ */
+Result minmpeg_analyze_luma(
+    const char* path,
+    const char* ffmpeg_path,
+    LumaStats* aggregate,
+    LumaStats** frames,
+    size_t* frame_count
+);
+
+/**
+ * Free per-frame statistics returned by minmpeg_analyze_luma
+ *
+ * @param frames        Array returned by minmpeg_analyze_luma (may be NULL)
+ * @param frame_count   Number of frames in the array
+ */
+void minmpeg_free_luma_stats(LumaStats* frames, size_t frame_count);
+
+/**
+ * Compute perceptual hashes of frames sampled evenly over an image or video
+ *
+ * Each frame gives a 64-bit hash that survives re-encoding and scaling;
+ * compare hashes by the number of differing bits to detect duplicated
+ * content or to verify cache hits without comparing whole files.
+ *
+ * @param path          Path to the image or video file
+ * @param count         Number of frames to sample (at least 1; images give one hash)
+ * @param ffmpeg_path   Optional path to ffmpeg (for videos), NULL for PATH
+ * @param hashes        Receives an array of hashes in time order;
+ *                      free it with minmpeg_free_frame_hashes
+ * @param hash_count    Receives the number of hashes
+ * @return              Result with code MINMPEG_OK on success
+ */
+Result minmpeg_frame_hashes(
+    const char* path,
+    uint32_t count,
+    const char* ffmpeg_path,
+    FrameHash** hashes,
+    size_t* hash_count
+);
+
+/**
+ * Free hashes returned by minmpeg_frame_hashes
+ *
+ * @param hashes        Array returned by minmpeg_frame_hashes (may be NULL)
+ * @param hash_count    Number of hashes in the array
+ */
+void minmpeg_free_frame_hashes(FrameHash* hashes, size_t hash_count);
+
+/**
+ * Encode a standard workload with each codec and measure speed, CPU use and quality
+ *
+ * A panning gradient with grain and a bouncing checkered square is encoded
+ * with each codec, using the same encoders (hardware where available) as
+ * the other functions. Encoding is timed without drawing the workload, and
+ * the output is decoded with ffmpeg to compare it with the workload. CPU
+ * time includes ffmpeg processes, except on Windows. Codecs that fail are
+ * reported with their error in the result.
+ *
+ * @param spec          Workload (NULL for the defaults)
+ * @param report        Receives the measurements;
+ *                      free them with minmpeg_free_benchmark_report
+ * @return              Result with code MINMPEG_OK on success
+ */
+Result minmpeg_benchmark(const BenchmarkSpec* spec, BenchmarkReport* report);
+
+/**
+ * Free the results of a report filled by minmpeg_benchmark, including their error messages
+ *
+ * @param report        Report filled by minmpeg_benchmark (may be NULL)
+ */
+void minmpeg_free_benchmark_report(BenchmarkReport* report);
+
+/**
+ * Register a font for caption rendering
+ *
+ * Registered fonts take precedence over system fonts with the same name.
+ * Registering a font under an existing name replaces it.
+ *
+ * @param name          Name used to select the font in EncodeParams
+ * @param data          TrueType/OpenType font data (copied by the library)
+ * @param data_len      Length of the font data in bytes
+ * @return              Result with code MINMPEG_OK on success
+ */
+Result minmpeg_register_font(const char* name, const uint8_t* data, size_t data_len);
+
+/**
+ * List the fonts installed on the system
+ *
+ * Names are the font file names without their extension, such as
+ * "DejaVuSans" for DejaVuSans.ttf, which EncodeParams font names match
+ * ignoring case, spaces, hyphens and underscores. Family names stored inside
+ * the fonts are not read.
+ *
+ * @param callback      Called with each name, in sorted order
+ * @param user_data     Passed to the callback
+ * @return              Result with code MINMPEG_OK on success
+ */
+Result minmpeg_system_fonts(NameCallback callback, void* user_data);
+
 /**
  * Free resources associated with a Result
  *
@@ -146,6 +1472,29 @@ Result minmpeg_juxtapose(
  */
 void minmpeg_free_result(Result* result);
 
+/**
+ * Set the language of error messages
+ *
+ * Applies to all threads, except calls whose EncodeParams set another
+ * language. Error codes do not change with the language, so programs should
+ * check the code rather than the message.
+ *
+ * @param language  Language of later error messages (default LANGUAGE_ENGLISH)
+ */
+void minmpeg_set_language(Language language);
+
+/**
+ * Forbid or allow running ffmpeg
+ *
+ * In native-only mode no external process is ever spawned, for environments
+ * where exec() is prohibited: functions and codecs that need ffmpeg fail
+ * instead, and such codecs are not reported by minmpeg_available or
+ * minmpeg_capabilities. Applies to all threads.
+ *
+ * @param enabled  Nonzero to forbid ffmpeg (default 0)
+ */
+void minmpeg_set_native_only(int enabled);
+
 /**
  * Get the library version string
  *
@@ -153,6 +1502,14 @@ void minmpeg_free_result(Result* result);
  */
 const char* minmpeg_version(void);
 
+/**
+ * Get the ABI version the library was built with
+ *
+ * @return  MINMPEG_ABI_VERSION of the library's header; programs should
+ *          refuse to call other functions when it differs from their own
+ */
+uint32_t minmpeg_abi_version(void);
+
 #ifdef __cplusplus
 }
 #endif
@@ -0,0 +1,208 @@
+//! Luma histogram and exposure analysis
+//!
+//! Images are analyzed directly. Videos are decoded to 8-bit luma frames by
+//! ffmpeg, giving one set of statistics per frame.
+
+use crate::ffmpeg::{find_ffmpeg, path_arg, probe_video_size, ProcessLog};
+use crate::image_loader::LoadedImage;
+use crate::{Error, LumaAnalysis, LumaStats, Result};
+use image::ImageFormat;
+use std::io::Read;
+use std::path::Path;
+use std::process::{Command, Stdio};
+
+/// Highest luma level counted as clipped shadows
+const SHADOW_CLIP_LEVEL: usize = 2;
+
+/// Lowest luma level counted as clipped highlights
+const HIGHLIGHT_CLIP_LEVEL: usize = 253;
+
+impl LumaStats {
+    /// Statistics from a luma histogram
+    pub(crate) fn from_histogram(histogram: [u64; 256]) -> Self {
+        let pixel_count: u64 = histogram.iter().sum();
+        let total = pixel_count.max(1) as f64;
+        let sum: u64 = histogram
+            .iter()
+            .enumerate()
+            .map(|(level, &count)| level as u64 * count)
+            .sum();
+        let shadows: u64 = histogram[..=SHADOW_CLIP_LEVEL].iter().sum();
+        let highlights: u64 = histogram[HIGHLIGHT_CLIP_LEVEL..].iter().sum();
+
+        Self {
+            histogram,
+            pixel_count,
+            mean: (sum as f64 / total) as f32,
+            shadow_clip: (shadows as f64 / total) as f32,
+            highlight_clip: (highlights as f64 / total) as f32,
+        }
+    }
+
+    /// Luma level below which the given fraction (0.0-1.0) of pixels lies
+    pub fn percentile(&self, fraction: f32) -> u8 {
+        let target = (self.pixel_count as f64 * fraction.clamp(0.0, 1.0) as f64).ceil() as u64;
+        let mut count = 0;
+        for (level, &n) in self.histogram.iter().enumerate() {
+            count += n;
+            if count >= target.max(1) {
+                return level as u8;
+            }
+        }
+        255
+    }
+}
+
+/// Luma histogram of RGBA pixels using BT.709 weights
+pub(crate) fn rgba_histogram(data: &[u8]) -> [u64; 256] {
+    let mut histogram = [0u64; 256];
+    for p in data.chunks_exact(4) {
+        let luma = 0.2126 * p[0] as f32 + 0.7152 * p[1] as f32 + 0.0722 * p[2] as f32;
+        histogram[luma.round() as usize] += 1;
+    }
+    histogram
+}
+
+/// Histogram of 8-bit luma values
+fn luma_histogram(data: &[u8]) -> [u64; 256] {
+    let mut histogram = [0u64; 256];
+    for &luma in data {
+        histogram[luma as usize] += 1;
+    }
+    histogram
+}
+
+/// Analyze the luma histogram and clipping of an image or video
+///
+/// Images give a single frame. Videos are decoded with ffmpeg (found in
+/// PATH unless `ffmpeg_path` is set) and give one set of statistics per
+/// frame. The aggregate covers all pixels of all frames.
+pub fn analyze_luma<P: AsRef<Path>>(path: P, ffmpeg_path: Option<&str>) -> Result<LumaAnalysis> {
+    let path = path.as_ref();
+    if !path.is_file() {
+        return Err(Error::InvalidInput(format!(
+            "Input not found: {}",
+            path.display()
+        )));
+    }
+
+    let frames = if ImageFormat::from_path(path).is_ok() {
+        let image = LoadedImage::from_path(path)?;
+        vec![rgba_histogram(&image.data)]
+    } else {
+        video_histograms(path, ffmpeg_path)?
+    };
+
+    let mut total = [0u64; 256];
+    for histogram in &frames {
+        for (sum, count) in total.iter_mut().zip(histogram) {
+            *sum += count;
+        }
+    }
+
+    Ok(LumaAnalysis {
+        frames: frames.into_iter().map(LumaStats::from_histogram).collect(),
+        aggregate: LumaStats::from_histogram(total),
+    })
+}
+
+/// Decode a video to luma frames and build a histogram per frame
+fn video_histograms(path: &Path, ffmpeg_path: Option<&str>) -> Result<Vec<[u64; 256]>> {
+    let ffmpeg = find_ffmpeg(ffmpeg_path)?;
+    let (width, height) = probe_video_size(&ffmpeg, path)?;
+
+    let (mut process, mut log) = ProcessLog::spawn(
+        Command::new(&ffmpeg)
+            .args(["-v", "error", "-nostdin", "-i"])
+            .arg(path_arg(path))
+            .args(["-map", "0:v:0"])
+            .args(["-f", "rawvideo", "-pix_fmt", "gray", "pipe:1"])
+            .stdout(Stdio::piped()),
+    )?;
+
+    let mut stdout = process
+        .stdout
+        .take()
+        .ok_or_else(|| Error::Ffmpeg("Failed to read ffmpeg output".to_string()))?;
+
+    let mut buffer = vec![0u8; (width * height) as usize];
+    let mut frames = Vec::new();
+    loop {
+        match stdout.read_exact(&mut buffer) {
+            Ok(()) => frames.push(luma_histogram(&buffer)),
+            Err(e) if e.kind() == std::io::ErrorKind::UnexpectedEof => break,
+            Err(e) => return Err(Error::Decode(format!("Failed to read frame: {}", e))),
+        }
+    }
+    let status = process.wait()?;
+
+    if frames.is_empty() {
+        let error = Error::Decode(format!(
+            "No video frames decoded from {} ({})",
+            path.display(),
+            status
+        ));
+        return Err(log.error(&mut process, error));
+    }
+    Ok(frames)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_rgba_histogram() {
+        let data = [
+            0, 0, 0, 255, // black
+            255, 255, 255, 255, // white
+            255, 0, 0, 255, // red
+            0, 255, 0, 0, // green (alpha is ignored)
+        ];
+        let histogram = rgba_histogram(&data);
+        assert_eq!(histogram[0], 1);
+        assert_eq!(histogram[255], 1);
+        assert_eq!(histogram[54], 1);
+        assert_eq!(histogram[182], 1);
+        assert_eq!(histogram.iter().sum::<u64>(), 4);
+    }
+
+    #[test]
+    fn test_stats_clipping() {
+        let mut histogram = [0u64; 256];
+        histogram[0] = 10;
+        histogram[2] = 10;
+        histogram[128] = 60;
+        histogram[254] = 20;
+        let stats = LumaStats::from_histogram(histogram);
+
+        assert_eq!(stats.pixel_count, 100);
+        assert_eq!(stats.shadow_clip, 0.2);
+        assert_eq!(stats.highlight_clip, 0.2);
+        assert!((stats.mean - 127.8).abs() < 0.01);
+    }
+
+    #[test]
+    fn test_stats_empty() {
+        let stats = LumaStats::from_histogram([0; 256]);
+        assert_eq!(stats.pixel_count, 0);
+        assert_eq!(stats.mean, 0.0);
+        assert_eq!(stats.shadow_clip, 0.0);
+    }
+
+    #[test]
+    fn test_percentile() {
+        let mut histogram = [0u64; 256];
+        histogram[10] = 50;
+        histogram[100] = 40;
+        histogram[250] = 10;
+        let stats = LumaStats::from_histogram(histogram);
+
+        assert_eq!(stats.percentile(0.0), 10);
+        assert_eq!(stats.percentile(0.5), 10);
+        assert_eq!(stats.percentile(0.51), 100);
+        assert_eq!(stats.percentile(0.9), 100);
+        assert_eq!(stats.percentile(0.95), 250);
+        assert_eq!(stats.percentile(1.0), 250);
+    }
+}
@@ -0,0 +1,1015 @@
+//! Audio tracks handled with ffmpeg
+//!
+//! For slideshows the video is encoded and muxed as Matroska into a pipe to
+//! ffmpeg, which copies the video stream unchanged and encodes only the
+//! audio, using AAC for MP4 and Opus for WebM, so no intermediate file is
+//! written. The same approach edits the audio of existing videos.
+
+use crate::encoder::Packet;
+use crate::ffmpeg::{self, path_arg, PipedFfmpeg};
+use crate::muxer::mkv::MkvMuxer;
+use crate::muxer::{Muxer, MuxerConfig};
+use crate::{
+    AudioCodec, AudioFit, AudioOptions, Container, EncodeOptions, Error, Mp4Layout, Result,
+    WarningKind,
+};
+use std::path::{Path, PathBuf};
+
+/// Length of the fade-out at the end of background music in milliseconds
+const BACKGROUND_MUSIC_FADE_OUT_MS: u64 = 2000;
+
+/// Supported target loudness in LUFS
+pub(crate) const LOUDNESS_RANGE: std::ops::RangeInclusive<f32> = -70.0..=-5.0;
+
+/// Maximum true peak of normalized audio in dBTP
+const LOUDNESS_TRUE_PEAK: f32 = -1.5;
+
+/// Sample rate of normalized audio (loudnorm upsamples internally)
+const LOUDNESS_SAMPLE_RATE: u32 = 48000;
+
+/// Supported threshold of music ducking in dBFS
+pub(crate) const DUCK_THRESHOLD_RANGE: std::ops::RangeInclusive<f32> = -60.0..=-1.0;
+
+/// Supported amount of music ducking in dB
+pub(crate) const DUCK_AMOUNT_RANGE: std::ops::RangeInclusive<f32> = 1.0..=18.0;
+
+/// Narration level above which music is ducked, in dBFS
+const DEFAULT_DUCK_THRESHOLD: f32 = -30.0;
+
+/// Reduction of music under narration in dB
+const DEFAULT_DUCK_AMOUNT: f32 = 12.0;
+
+/// Assumed level of speech above the ducking threshold in dB, used to turn
+/// the ducking amount into a compression ratio
+const DUCK_SPEECH_HEADROOM: f32 = 20.0;
+
+/// Attack and release of music ducking in milliseconds
+const DUCK_ATTACK_MS: u32 = 20;
+const DUCK_RELEASE_MS: u32 = 400;
+
+/// Supported audio gain in dB
+pub(crate) const GAIN_RANGE: std::ops::RangeInclusive<f32> = -60.0..=20.0;
+
+/// Check that an audio gain is in the supported range
+pub(crate) fn check_gain(gain_db: f32) -> Result<()> {
+    if !GAIN_RANGE.contains(&gain_db) {
+        return Err(Error::InvalidInput(format!(
+            "Audio gain must be {} to {} dB, got {}",
+            GAIN_RANGE.start(),
+            GAIN_RANGE.end(),
+            gain_db
+        )));
+    }
+    Ok(())
+}
+
+/// Gain and fades applied to a whole audio track
+#[derive(Debug, Clone, Copy, Default)]
+pub(crate) struct Envelope {
+    /// Gain in dB
+    pub gain_db: f32,
+    /// Length of the fade-in at the start in milliseconds
+    pub fade_in_ms: u32,
+    /// Length of the fade-out at the end in milliseconds
+    pub fade_out_ms: u32,
+}
+
+impl Envelope {
+    /// Filters for a track of the given length, each followed by a comma
+    ///
+    /// Fades longer than the track are shortened to its length.
+    fn filters(&self, duration_ms: u64) -> String {
+        let mut filters = String::new();
+        if self.gain_db != 0.0 {
+            filters.push_str(&format!("volume={}dB,", self.gain_db));
+        }
+        if self.fade_in_ms > 0 {
+            let fade_ms = (self.fade_in_ms as u64).min(duration_ms);
+            filters.push_str(&format!("afade=t=in:st=0:d={},", seconds(fade_ms)));
+        }
+        if self.fade_out_ms > 0 {
+            let fade_ms = (self.fade_out_ms as u64).min(duration_ms);
+            filters.push_str(&format!(
+                "afade=t=out:st={}:d={},",
+                seconds(duration_ms - fade_ms),
+                seconds(fade_ms)
+            ));
+        }
+        filters
+    }
+
+    /// ffmpeg `-af` arguments for a track of the given length
+    fn args(&self, duration_ms: u64) -> Vec<String> {
+        let filters = self.filters(duration_ms);
+        match filters.strip_suffix(',') {
+            Some(chain) => vec!["-af".into(), chain.into()],
+            None => Vec::new(),
+        }
+    }
+
+    /// Check if fading out requires the track length
+    fn needs_duration(&self) -> bool {
+        self.fade_out_ms > 0
+    }
+}
+
+impl From<&AudioOptions> for Envelope {
+    fn from(options: &AudioOptions) -> Self {
+        Self {
+            gain_db: options.gain,
+            fade_in_ms: options.fade_in_ms,
+            fade_out_ms: options.fade_out_ms,
+        }
+    }
+}
+
+/// Lowering of background music under narration
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub(crate) struct Ducking {
+    /// Narration level above which music is lowered, in dBFS
+    pub threshold_db: f32,
+    /// Approximate reduction of the music in dB
+    pub amount_db: f32,
+}
+
+impl Ducking {
+    /// Ducking with the given settings (0 for the defaults)
+    pub fn new(threshold_db: f32, amount_db: f32) -> Self {
+        let or_default = |value: f32, default| if value == 0.0 { default } else { value };
+        Self {
+            threshold_db: or_default(threshold_db, DEFAULT_DUCK_THRESHOLD),
+            amount_db: or_default(amount_db, DEFAULT_DUCK_AMOUNT),
+        }
+    }
+
+    /// `sidechaincompress` filter that lowers the first input while the
+    /// second input is above the threshold
+    ///
+    /// Speech peaking about 20 dB above the threshold lowers the music by
+    /// the ducking amount.
+    fn filter(&self) -> String {
+        let threshold = 10f32.powf(self.threshold_db / 20.0);
+        let ratio = DUCK_SPEECH_HEADROOM / (DUCK_SPEECH_HEADROOM - self.amount_db);
+        format!(
+            "sidechaincompress=threshold={:.6}:ratio={:.2}:attack={}:release={}",
+            threshold, ratio, DUCK_ATTACK_MS, DUCK_RELEASE_MS
+        )
+    }
+}
+
+impl Default for Ducking {
+    fn default() -> Self {
+        Self::new(0.0, 0.0)
+    }
+}
+
+/// ffmpeg audio encoder for a container
+pub(crate) fn audio_encoder(container: Container) -> Result<&'static str> {
+    match container {
+        Container::Mp4 => Ok("aac"),
+        Container::WebM => Ok("libopus"),
+        // Uncompressed audio for editing
+        Container::Mov => Ok("pcm_s16le"),
+        // Lossless audio for archives
+        Container::Mkv => Ok("flac"),
+        _ => Err(Error::InvalidInput(format!(
+            "Container {:?} does not support audio",
+            container
+        ))),
+    }
+}
+
+/// ffmpeg output format name for a container with audio
+fn output_format(container: Container) -> Result<&'static str> {
+    match container {
+        Container::Mp4 => Ok("mp4"),
+        Container::WebM => Ok("webm"),
+        Container::Mov => Ok("mov"),
+        Container::Mkv => Ok("matroska"),
+        _ => Err(Error::InvalidInput(format!(
+            "Container {:?} does not support audio",
+            container
+        ))),
+    }
+}
+
+/// ffmpeg arguments arranging MP4 and MOV output in a layout
+fn layout_args(container: Container, mp4_layout: Mp4Layout) -> Vec<String> {
+    let flags = match (container, mp4_layout) {
+        (Container::Mp4, Mp4Layout::Fragmented) => "frag_keyframe+empty_moov",
+        (Container::Mp4 | Container::Mov, Mp4Layout::Faststart) => "+faststart",
+        _ => return Vec::new(),
+    };
+    vec!["-movflags".into(), flags.into()]
+}
+
+/// Container of an output file, from its extension
+fn container_from_path(path: &Path) -> Result<Container> {
+    let ext = path
+        .extension()
+        .map(|e| e.to_string_lossy().to_ascii_lowercase())
+        .unwrap_or_default();
+    match ext.as_str() {
+        "mp4" | "m4v" => Ok(Container::Mp4),
+        "webm" => Ok(Container::WebM),
+        "mov" => Ok(Container::Mov),
+        "mkv" => Ok(Container::Mkv),
+        _ => Err(Error::InvalidInput(format!(
+            "Unsupported output format for audio: {}",
+            path.display()
+        ))),
+    }
+}
+
+/// Codec and ffmpeg output format of an audio file, from its extension
+fn audio_format_from_path(path: &Path) -> Option<(AudioCodec, &'static str)> {
+    let ext = path
+        .extension()
+        .map(|e| e.to_string_lossy().to_ascii_lowercase())
+        .unwrap_or_default();
+    match ext.as_str() {
+        "mp3" => Some((AudioCodec::Mp3, "mp3")),
+        "m4a" => Some((AudioCodec::Aac, "ipod")),
+        "aac" => Some((AudioCodec::Aac, "adts")),
+        "opus" => Some((AudioCodec::Opus, "opus")),
+        "ogg" => Some((AudioCodec::Opus, "ogg")),
+        "wav" => Some((AudioCodec::Wav, "wav")),
+        _ => None,
+    }
+}
+
+/// Codec and ffmpeg output format for extracting audio to a file
+///
+/// An explicit codec must match a known extension; with an unknown
+/// extension the codec's own format is written.
+fn extract_format(path: &Path, codec: AudioCodec) -> Result<(AudioCodec, &'static str)> {
+    match (audio_format_from_path(path), codec) {
+        (Some(format), AudioCodec::Auto) => Ok(format),
+        (Some((ext_codec, format)), codec) if ext_codec == codec => Ok((codec, format)),
+        (Some(_), codec) => Err(Error::InvalidInput(format!(
+            "Audio codec {:?} does not match the output file: {}",
+            codec,
+            path.display()
+        ))),
+        (None, AudioCodec::Auto) => Err(Error::InvalidInput(format!(
+            "Unsupported output format for audio: {}",
+            path.display()
+        ))),
+        (None, AudioCodec::Mp3) => Ok((AudioCodec::Mp3, "mp3")),
+        (None, AudioCodec::Aac) => Ok((AudioCodec::Aac, "adts")),
+        (None, AudioCodec::Opus) => Ok((AudioCodec::Opus, "opus")),
+        (None, AudioCodec::Wav) => Ok((AudioCodec::Wav, "wav")),
+    }
+}
+
+/// ffmpeg encoder for an audio codec
+fn codec_encoder(codec: AudioCodec) -> &'static str {
+    match codec {
+        AudioCodec::Mp3 => "libmp3lame",
+        AudioCodec::Aac => "aac",
+        AudioCodec::Opus | AudioCodec::Auto => "libopus",
+        AudioCodec::Wav => "pcm_s16le",
+    }
+}
+
+/// Check that an input file exists and differs from the output
+pub(crate) fn check_input(path: &Path, output: &Path, kind: &str) -> Result<()> {
+    if !path.is_file() {
+        return Err(Error::InvalidInput(format!(
+            "{} not found: {}",
+            kind,
+            path.display()
+        )));
+    }
+    if path == output {
+        return Err(Error::InvalidInput(format!(
+            "Output must differ from the {} file: {}",
+            kind.to_lowercase(),
+            output.display()
+        )));
+    }
+    Ok(())
+}
+
+/// A narration clip placed on the slideshow timeline
+#[derive(Debug, Clone)]
+pub(crate) struct Narration {
+    /// Audio file
+    pub path: PathBuf,
+    /// Start of the slide in milliseconds
+    pub start_ms: u64,
+    /// Length of the slide in milliseconds; longer clips are cut off
+    pub duration_ms: u64,
+}
+
+/// Audio to add to a slideshow video
+#[derive(Debug, Clone, Default)]
+pub(crate) struct SlideshowAudio {
+    /// Background music, trimmed or looped to the video length
+    pub music: Option<PathBuf>,
+    /// Per-slide narration clips
+    pub narrations: Vec<Narration>,
+    /// Target loudness of the mix in LUFS
+    pub loudness: Option<f32>,
+    /// Gain and fades applied to the mix after normalization
+    pub envelope: Envelope,
+    /// Lowering of the music under narration
+    pub ducking: Ducking,
+    /// Raw PCM audio, such as samples written to a video writer; mixed like
+    /// narration from the start
+    pub pcm: Option<PcmInput>,
+}
+
+/// File of interleaved 16-bit little-endian PCM samples
+#[derive(Debug, Clone)]
+pub(crate) struct PcmInput {
+    pub path: PathBuf,
+    pub sample_rate: u32,
+    pub channels: u16,
+}
+
+impl SlideshowAudio {
+    /// Check whether there is any audio to add
+    pub fn is_empty(&self) -> bool {
+        self.music.is_none() && self.narrations.is_empty() && self.pcm.is_none()
+    }
+
+    /// Warn about background music longer than a video of `duration_ms` and
+    /// narration longer than its slide, which are cut off
+    ///
+    /// The clips are only probed when the options have a warning handler;
+    /// clips that cannot be probed are not reported.
+    pub fn warn_truncated(&self, ffmpeg: &str, duration_ms: u64, options: &EncodeOptions) {
+        if options.on_warning.is_none() {
+            return;
+        }
+        let length_ms =
+            |path: &Path| ffmpeg::probe_duration(ffmpeg, path).map(|s| (s * 1000.0) as u64);
+
+        if let Some(music) = &self.music {
+            if let Ok(music_ms) = length_ms(music) {
+                if music_ms > duration_ms {
+                    options.warn(
+                        WarningKind::AudioTruncated,
+                        format!(
+                            "Background music {} of {} s cut to the video length of {} s",
+                            music.display(),
+                            seconds(music_ms),
+                            seconds(duration_ms)
+                        ),
+                    );
+                }
+            }
+        }
+        for narration in &self.narrations {
+            if let Ok(narration_ms) = length_ms(&narration.path) {
+                if narration_ms > narration.duration_ms {
+                    options.warn(
+                        WarningKind::AudioTruncated,
+                        format!(
+                            "Narration {} of {} s cut to the slide length of {} s",
+                            narration.path.display(),
+                            seconds(narration_ms),
+                            seconds(narration.duration_ms)
+                        ),
+                    );
+                }
+            }
+        }
+    }
+
+    /// Build the ffmpeg input arguments and filter graph
+    ///
+    /// Input 0 is the video; audio inputs follow in order. The graph ends
+    /// in the `[aout]` label and is padded with silence, so the output
+    /// length is set by `-t`.
+    fn inputs_and_filter(&self, video: &Path, duration_ms: u64) -> (Vec<String>, String) {
+        let mut inputs: Vec<String> = vec!["-i".into(), path_arg(video)];
+        let mut filters = Vec::new();
+        let mut labels = Vec::new();
+        let mut index = 0;
+
+        if let Some(music) = &self.music {
+            index += 1;
+            inputs.extend([
+                "-stream_loop".into(),
+                "-1".into(),
+                "-i".into(),
+                path_arg(music),
+            ]);
+
+            let fade_ms = BACKGROUND_MUSIC_FADE_OUT_MS.min(duration_ms / 2);
+            filters.push(format!(
+                "[{}:a:0]atrim=duration={},afade=t=out:st={}:d={}[bgm]",
+                index,
+                seconds(duration_ms),
+                seconds(duration_ms - fade_ms),
+                seconds(fade_ms)
+            ));
+            labels.push("[bgm]".to_string());
+        }
+
+        let mut narration_labels = Vec::new();
+        if let Some(pcm) = &self.pcm {
+            index += 1;
+            inputs.extend([
+                "-f".into(),
+                "s16le".into(),
+                "-ar".into(),
+                pcm.sample_rate.to_string(),
+                "-ac".into(),
+                pcm.channels.to_string(),
+                "-i".into(),
+                path_arg(&pcm.path),
+            ]);
+            narration_labels.push(format!("[{}:a:0]", index));
+        }
+        for (i, narration) in self.narrations.iter().enumerate() {
+            index += 1;
+            inputs.extend(["-i".into(), path_arg(&narration.path)]);
+
+            filters.push(format!(
+                "[{}:a:0]atrim=duration={},adelay=delays={}:all=1[n{}]",
+                index,
+                seconds(narration.duration_ms),
+                narration.start_ms,
+                i
+            ));
+            narration_labels.push(format!("[n{}]", i));
+        }
+
+        if self.music.is_some() && !narration_labels.is_empty() {
+            // Duck the music with the combined narration as the sidechain
+            let voice = if narration_labels.len() == 1 {
+                narration_labels.concat()
+            } else {
+                format!(
+                    "{}amix=inputs={}:duration=longest:normalize=0,",
+                    narration_labels.concat(),
+                    narration_labels.len()
+                )
+            };
+            filters.push(format!("{}asplit=2[voice][sc]", voice));
+            filters.push(format!("[bgm][sc]{}[ducked]", self.ducking.filter()));
+            labels = vec!["[ducked]".to_string(), "[voice]".to_string()];
+        } else {
+            labels.extend(narration_labels);
+        }
+
+        let norm = match self.loudness {
+            Some(lufs) => format!(
+                "loudnorm=I={}:TP={}:LRA=11,aresample={},",
+                lufs, LOUDNESS_TRUE_PEAK, LOUDNESS_SAMPLE_RATE
+            ),
+            None => String::new(),
+        };
+        let envelope = self.envelope.filters(duration_ms);
+        let mix = if labels.len() == 1 {
+            format!("{}{}{}apad[aout]", labels[0], norm, envelope)
+        } else {
+            format!(
+                "{}amix=inputs={}:duration=longest:normalize=0,{}{}apad[aout]",
+                labels.concat(),
+                labels.len(),
+                norm,
+                envelope
+            )
+        };
+        filters.push(mix);
+
+        (inputs, filters.join(";"))
+    }
+}
+
+/// Add audio to a slideshow video
+///
+/// Background music is trimmed when it is longer than the video and looped
+/// when it is shorter, and fades out over the last two seconds (or the
+/// second half of shorter videos). Narration clips start with their slides
+/// and are mixed over the music, which is lowered while narration plays. The mix is normalized to the target
+/// loudness, if set, before the gain and fades are applied. MP4 output keeps
+/// the layout of the options. Raw PCM audio is read from its file and mixed
+/// like narration.
+///
+/// The muxer writes the video packets to ffmpeg as they come and finishes
+/// the output when finalized; dropping it stops ffmpeg.
+pub(crate) struct AudioMuxer {
+    muxer: MkvMuxer,
+    ffmpeg: PipedFfmpeg,
+}
+
+impl AudioMuxer {
+    /// Start ffmpeg writing the output of the options with the audio of a
+    /// video of `duration_ms` and the configuration of the muxer
+    pub fn new(
+        ffmpeg_path: &str,
+        audio: &SlideshowAudio,
+        options: &EncodeOptions,
+        duration_ms: u64,
+        config: MuxerConfig,
+    ) -> Result<Self> {
+        let args = slideshow_audio_args(audio, options, duration_ms, None)?;
+        let (ffmpeg, input) = PipedFfmpeg::spawn(ffmpeg_path, &args)?;
+        Ok(Self {
+            muxer: MkvMuxer::to_writer(Box::new(input), config)?,
+            ffmpeg,
+        })
+    }
+}
+
+impl Muxer for AudioMuxer {
+    fn write_packet(&mut self, packet: &Packet) -> Result<()> {
+        self.muxer
+            .write_packet(packet)
+            .map_err(|e| self.ffmpeg.write_error(e))
+    }
+
+    fn finalize(self: Box<Self>) -> Result<()> {
+        let Self { muxer, mut ffmpeg } = *self;
+        // Finalizing closes the input, so ffmpeg finishes the output
+        Box::new(muxer)
+            .finalize()
+            .map_err(|e| ffmpeg.write_error(e))?;
+        ffmpeg.wait()
+    }
+}
+
+/// Add the audio of a slideshow to the video file `video` of `duration_ms`,
+/// writing the output of the options
+///
+/// Like [`AudioMuxer`], for video muxed to a file before the audio is known.
+pub(crate) fn mux_audio(
+    ffmpeg_path: &str,
+    video: &Path,
+    audio: &SlideshowAudio,
+    options: &EncodeOptions,
+    duration_ms: u64,
+) -> Result<()> {
+    let args = slideshow_audio_args(audio, options, duration_ms, Some(video))?;
+    ffmpeg::run(ffmpeg_path, &args)
+}
+
+/// ffmpeg arguments adding the audio of a slideshow to the video file
+/// `video`, or to Matroska video read from the input pipe, writing the
+/// output of the options
+fn slideshow_audio_args(
+    audio: &SlideshowAudio,
+    options: &EncodeOptions,
+    duration_ms: u64,
+    video: Option<&Path>,
+) -> Result<Vec<String>> {
+    let encoder = audio_encoder(options.container)?;
+    let format = output_format(options.container)?;
+
+    let mut args: Vec<String> = Vec::new();
+    let video = match video {
+        Some(video) => video,
+        None => {
+            args.extend(["-f".into(), "matroska".into()]);
+            Path::new("pipe:0")
+        }
+    };
+    let (inputs, filter) = audio.inputs_and_filter(video, duration_ms);
+    args.extend(inputs);
+    args.extend([
+        "-filter_complex".into(),
+        filter,
+        "-map".into(),
+        "0:v:0".into(),
+        "-map".into(),
+        "[aout]".into(),
+        "-c:v".into(),
+        "copy".into(),
+        "-c:a".into(),
+        encoder.into(),
+        "-t".into(),
+        seconds(duration_ms),
+    ]);
+    if options.deterministic() {
+        // Without the ffmpeg version and random segment identifiers
+        args.extend([
+            "-fflags".into(),
+            "+bitexact".into(),
+            "-flags:a".into(),
+            "+bitexact".into(),
+        ]);
+    }
+    args.extend(layout_args(options.container, options.mp4_layout));
+    args.extend([
+        "-f".into(),
+        format.into(),
+        path_arg(Path::new(&options.output_path)),
+    ]);
+    Ok(args)
+}
+
+/// Replace the audio of a video with an audio file
+///
+/// The video stream is copied and the output has the length of the video.
+/// Any audio in the video is dropped. The gain and fades of the options are
+/// applied to the new audio, with the fade-out ending at the end of the
+/// video. The output container is chosen by the output extension
+/// (`.mp4`/`.m4v` or `.webm`), and MP4 output has its movie box first.
+pub fn replace_audio<P: AsRef<Path>>(
+    video_path: P,
+    audio_path: P,
+    output_path: P,
+    options: &AudioOptions,
+) -> Result<()> {
+    let (video, audio, output) = (
+        video_path.as_ref(),
+        audio_path.as_ref(),
+        output_path.as_ref(),
+    );
+    check_input(video, output, "Video")?;
+    check_input(audio, output, "Audio")?;
+    check_gain(options.gain)?;
+    let container = container_from_path(output)?;
+    let ffmpeg_path = ffmpeg::find_ffmpeg(options.ffmpeg_path.as_deref())?;
+
+    let duration = ffmpeg::probe_duration(&ffmpeg_path, video)?;
+
+    let mut args: Vec<String> = vec!["-i".into(), path_arg(video)];
+    if options.fit == AudioFit::Loop {
+        args.extend(["-stream_loop".into(), "-1".into()]);
+    }
+    args.extend([
+        "-i".into(),
+        path_arg(audio),
+        "-map".into(),
+        "0:v:0".into(),
+        "-map".into(),
+        "1:a:0".into(),
+    ]);
+    args.extend(Envelope::from(options).args((duration * 1000.0) as u64));
+    args.extend([
+        "-c:v".into(),
+        "copy".into(),
+        "-c:a".into(),
+        audio_encoder(container)?.into(),
+        "-t".into(),
+        format!("{:.3}", duration),
+    ]);
+    args.extend(layout_args(container, Mp4Layout::Faststart));
+    args.extend([
+        "-f".into(),
+        output_format(container)?.into(),
+        path_arg(output),
+    ]);
+
+    ffmpeg::run(&ffmpeg_path, &args)
+}
+
+/// Remove all audio from a video
+///
+/// The video stream is copied when the output container supports its codec;
+/// otherwise it is re-encoded with ffmpeg's default encoder for the output
+/// format, which is chosen by the output extension. MP4 output has its movie
+/// box first.
+pub fn remove_audio<P: AsRef<Path>>(
+    input_path: P,
+    output_path: P,
+    options: &AudioOptions,
+) -> Result<()> {
+    let (input, output) = (input_path.as_ref(), output_path.as_ref());
+    check_input(input, output, "Video")?;
+    let ffmpeg_path = ffmpeg::find_ffmpeg(options.ffmpeg_path.as_deref())?;
+
+    let args = |video_codec: Option<&str>| {
+        let mut args: Vec<String> =
+            vec!["-i".into(), path_arg(input), "-map".into(), "0:v:0".into()];
+        if let Some(codec) = video_codec {
+            args.extend(["-c:v".into(), codec.into()]);
+        }
+        args.push("-an".into());
+        if let Ok(container) = container_from_path(output) {
+            args.extend(layout_args(container, Mp4Layout::Faststart));
+        }
+        args.push(path_arg(output));
+        args
+    };
+
+    match ffmpeg::run(&ffmpeg_path, &args(Some("copy"))) {
+        Ok(()) => Ok(()),
+        Err(_) => ffmpeg::run(&ffmpeg_path, &args(None)),
+    }
+}
+
+/// Extract the audio of a video to an audio file
+///
+/// The codec is chosen by the output extension (`.mp3`, `.m4a`, `.aac`,
+/// `.opus`, `.ogg` or `.wav`) unless set in the options. The gain and fades
+/// of the options are applied to the extracted audio.
+pub fn extract_audio<P: AsRef<Path>>(
+    input_path: P,
+    output_path: P,
+    options: &AudioOptions,
+) -> Result<()> {
+    let (input, output) = (input_path.as_ref(), output_path.as_ref());
+    check_input(input, output, "Video")?;
+    check_gain(options.gain)?;
+    let (codec, format) = extract_format(output, options.codec)?;
+    let ffmpeg_path = ffmpeg::find_ffmpeg(options.ffmpeg_path.as_deref())?;
+
+    let envelope = Envelope::from(options);
+    let duration_ms = if envelope.needs_duration() {
+        (ffmpeg::probe_duration(&ffmpeg_path, input)? * 1000.0) as u64
+    } else {
+        0
+    };
+
+    let mut args: Vec<String> = vec![
+        "-i".into(),
+        path_arg(input),
+        "-map".into(),
+        "0:a:0".into(),
+        "-vn".into(),
+    ];
+    args.extend(envelope.args(duration_ms));
+    args.extend(["-c:a".into(), codec_encoder(codec).into()]);
+    if options.bitrate > 0 && codec != AudioCodec::Wav {
+        args.extend(["-b:a".into(), format!("{}k", options.bitrate)]);
+    }
+    args.extend(["-f".into(), format.into(), path_arg(output)]);
+
+    ffmpeg::run(&ffmpeg_path, &args)
+}
+
+/// Format milliseconds as seconds for ffmpeg arguments
+fn seconds(ms: u64) -> String {
+    format!("{:.3}", ms as f64 / 1000.0)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::Reproducibility;
+
+    #[test]
+    fn test_audio_encoder() {
+        assert_eq!(audio_encoder(Container::Mp4).unwrap(), "aac");
+        assert_eq!(audio_encoder(Container::WebM).unwrap(), "libopus");
+        assert_eq!(audio_encoder(Container::Mov).unwrap(), "pcm_s16le");
+        assert_eq!(audio_encoder(Container::Mkv).unwrap(), "flac");
+        assert!(audio_encoder(Container::Avif).is_err());
+        assert!(audio_encoder(Container::Apng).is_err());
+    }
+
+    #[test]
+    fn test_layout_args() {
+        assert_eq!(
+            layout_args(Container::Mp4, Mp4Layout::Faststart),
+            ["-movflags", "+faststart"]
+        );
+        assert_eq!(
+            layout_args(Container::Mp4, Mp4Layout::Fragmented),
+            ["-movflags", "frag_keyframe+empty_moov"]
+        );
+        assert!(layout_args(Container::Mp4, Mp4Layout::MoovAtEnd).is_empty());
+        assert!(layout_args(Container::WebM, Mp4Layout::Faststart).is_empty());
+    }
+
+    #[test]
+    fn test_slideshow_audio_args() {
+        let audio = SlideshowAudio {
+            music: Some(PathBuf::from("music.mp3")),
+            ..Default::default()
+        };
+        let options = EncodeOptions {
+            output_path: "out.mp4".to_string(),
+            container: Container::Mp4,
+            ..Default::default()
+        };
+        let args = slideshow_audio_args(&audio, &options, 10000, None).unwrap();
+
+        // The video is read from the pipe and copied
+        assert_eq!(args[..4], ["-f", "matroska", "-i", "pipe:0"]);
+        assert!(args.windows(2).any(|w| w == ["-c:v", "copy"]));
+        assert!(args.ends_with(&["-f".into(), "mp4".into(), "out.mp4".into()]));
+        assert!(!args.contains(&"+bitexact".to_string()));
+
+        // Deterministic output leaves out the ffmpeg version
+        let deterministic = EncodeOptions {
+            reproducibility: Reproducibility::Deterministic,
+            ..options.clone()
+        };
+        let args = slideshow_audio_args(&audio, &deterministic, 10000, None).unwrap();
+        assert!(args.windows(2).any(|w| w == ["-fflags", "+bitexact"]));
+
+        // A video file is read in its own format
+        let args =
+            slideshow_audio_args(&audio, &options, 10000, Some(Path::new("video.mkv"))).unwrap();
+        assert_eq!(args[..2], ["-i", "video.mkv"]);
+
+        let apng = EncodeOptions {
+            container: Container::Apng,
+            ..options
+        };
+        assert!(slideshow_audio_args(&audio, &apng, 10000, None).is_err());
+    }
+
+    #[test]
+    fn test_music_filter() {
+        let audio = SlideshowAudio {
+            music: Some(PathBuf::from("music.mp3")),
+            ..Default::default()
+        };
+        let (inputs, filter) = audio.inputs_and_filter(Path::new("video.tmp"), 10000);
+
+        assert_eq!(
+            inputs,
+            vec!["-i", "video.tmp", "-stream_loop", "-1", "-i", "music.mp3"]
+        );
+        assert_eq!(
+            filter,
+            "[1:a:0]atrim=duration=10.000,afade=t=out:st=8.000:d=2.000[bgm];[bgm]apad[aout]"
+        );
+    }
+
+    #[test]
+    fn test_narration_filter() {
+        let audio = SlideshowAudio {
+            music: Some(PathBuf::from("music.mp3")),
+            narrations: vec![
+                Narration {
+                    path: PathBuf::from("a.wav"),
+                    start_ms: 0,
+                    duration_ms: 1500,
+                },
+                Narration {
+                    path: PathBuf::from("b.wav"),
+                    start_ms: 1500,
+                    duration_ms: 2000,
+                },
+            ],
+            ..Default::default()
+        };
+        let (inputs, filter) = audio.inputs_and_filter(Path::new("video.tmp"), 3500);
+
+        assert_eq!(inputs.len(), 10);
+        assert_eq!(inputs[9], "b.wav");
+        assert!(filter.contains("[2:a:0]atrim=duration=1.500,adelay=delays=0:all=1[n0]"));
+        assert!(filter.contains("[3:a:0]atrim=duration=2.000,adelay=delays=1500:all=1[n1]"));
+        assert!(filter
+            .contains("[n0][n1]amix=inputs=2:duration=longest:normalize=0,asplit=2[voice][sc]"));
+        assert!(filter.contains(
+            "[bgm][sc]sidechaincompress=threshold=0.031623:ratio=2.50:attack=20:release=400[ducked]"
+        ));
+        assert!(filter
+            .ends_with("[ducked][voice]amix=inputs=2:duration=longest:normalize=0,apad[aout]"));
+    }
+
+    #[test]
+    fn test_narration_without_music() {
+        let audio = SlideshowAudio {
+            narrations: vec![Narration {
+                path: PathBuf::from("a.wav"),
+                start_ms: 500,
+                duration_ms: 1500,
+            }],
+            ..Default::default()
+        };
+        let (_, filter) = audio.inputs_and_filter(Path::new("video.tmp"), 2000);
+
+        // Nothing to duck without music
+        assert_eq!(
+            filter,
+            "[1:a:0]atrim=duration=1.500,adelay=delays=500:all=1[n0];[n0]apad[aout]"
+        );
+    }
+
+    #[test]
+    fn test_pcm_audio_filter() {
+        let pcm = PcmInput {
+            path: PathBuf::from("audio.pcm"),
+            sample_rate: 48000,
+            channels: 2,
+        };
+        let audio = SlideshowAudio {
+            music: Some(PathBuf::from("music.mp3")),
+            pcm: Some(pcm.clone()),
+            ..Default::default()
+        };
+        let (inputs, filter) = audio.inputs_and_filter(Path::new("pipe:0"), 2000);
+
+        // The PCM audio ducks the music
+        assert_eq!(inputs.len(), 14);
+        assert_eq!(
+            inputs[6..],
+            ["-f", "s16le", "-ar", "48000", "-ac", "2", "-i", "audio.pcm"]
+        );
+        assert!(filter.contains("[2:a:0]asplit=2[voice][sc]"));
+        assert!(filter
+            .ends_with("[ducked][voice]amix=inputs=2:duration=longest:normalize=0,apad[aout]"));
+
+        let audio = SlideshowAudio {
+            pcm: Some(pcm),
+            ..Default::default()
+        };
+        assert!(!audio.is_empty());
+        let (_, filter) = audio.inputs_and_filter(Path::new("pipe:0"), 2000);
+        assert_eq!(filter, "[1:a:0]apad[aout]");
+    }
+
+    #[test]
+    fn test_ducking_settings() {
+        let ducking = Ducking::new(-40.0, 18.0);
+        assert_eq!(
+            ducking.filter(),
+            "sidechaincompress=threshold=0.010000:ratio=10.00:attack=20:release=400"
+        );
+        assert_eq!(Ducking::new(0.0, 6.0).threshold_db, -30.0);
+        assert_eq!(Ducking::default().amount_db, 12.0);
+    }
+
+    #[test]
+    fn test_loudness_filter() {
+        let audio = SlideshowAudio {
+            music: Some(PathBuf::from("music.mp3")),
+            loudness: Some(-16.0),
+            ..Default::default()
+        };
+        let (_, filter) = audio.inputs_and_filter(Path::new("video.tmp"), 10000);
+
+        assert!(filter.ends_with("[bgm]loudnorm=I=-16:TP=-1.5:LRA=11,aresample=48000,apad[aout]"));
+    }
+
+    #[test]
+    fn test_envelope_filter() {
+        let audio = SlideshowAudio {
+            music: Some(PathBuf::from("music.mp3")),
+            loudness: Some(-16.0),
+            envelope: Envelope {
+                gain_db: -6.0,
+                fade_in_ms: 500,
+                fade_out_ms: 1000,
+            },
+            ..Default::default()
+        };
+        let (_, filter) = audio.inputs_and_filter(Path::new("video.tmp"), 10000);
+
+        assert!(filter.ends_with(
+            "aresample=48000,volume=-6dB,afade=t=in:st=0:d=0.500,\
+             afade=t=out:st=9.000:d=1.000,apad[aout]"
+        ));
+    }
+
+    #[test]
+    fn test_envelope_args() {
+        assert!(Envelope::default().args(10000).is_empty());
+
+        // Fades are limited to the track length
+        let envelope = Envelope {
+            fade_in_ms: 20000,
+            ..Default::default()
+        };
+        assert_eq!(envelope.args(3000), vec!["-af", "afade=t=in:st=0:d=3.000"]);
+        assert!(!envelope.needs_duration());
+    }
+
+    #[test]
+    fn test_check_gain() {
+        assert!(check_gain(0.0).is_ok());
+        assert!(check_gain(-60.0).is_ok());
+        assert!(check_gain(12.5).is_ok());
+        assert!(check_gain(21.0).is_err());
+        assert!(check_gain(f32::NAN).is_err());
+    }
+
+    #[test]
+    fn test_container_from_path() {
+        assert_eq!(
+            container_from_path(Path::new("a/out.MP4")).unwrap(),
+            Container::Mp4
+        );
+        assert_eq!(
+            container_from_path(Path::new("out.webm")).unwrap(),
+            Container::WebM
+        );
+        assert!(container_from_path(Path::new("out.png")).is_err());
+        assert!(container_from_path(Path::new("out")).is_err());
+    }
+
+    #[test]
+    fn test_extract_format() {
+        let format = |path, codec| extract_format(Path::new(path), codec);
+        assert_eq!(
+            format("a.mp3", AudioCodec::Auto).unwrap(),
+            (AudioCodec::Mp3, "mp3")
+        );
+        assert_eq!(
+            format("a.M4A", AudioCodec::Aac).unwrap(),
+            (AudioCodec::Aac, "ipod")
+        );
+        assert_eq!(
+            format("a.ogg", AudioCodec::Auto).unwrap(),
+            (AudioCodec::Opus, "ogg")
+        );
+        assert_eq!(
+            format("a.bin", AudioCodec::Wav).unwrap(),
+            (AudioCodec::Wav, "wav")
+        );
+        assert!(format("a.mp3", AudioCodec::Opus).is_err());
+        assert!(format("a.bin", AudioCodec::Auto).is_err());
+    }
+}
@@ -0,0 +1,588 @@
+//! Encoder benchmarks
+//!
+//! A synthetic workload, a panning gradient with grain and a bouncing
+//! checkered square, is encoded with each codec the same way as a
+//! slideshow: frames go through the encoder of the codec (hardware where
+//! the library uses it) and the packets into its usual container. The
+//! wall-clock and CPU time of the encode are measured, and the output is
+//! decoded with ffmpeg to compare it with the workload.
+
+use crate::colorspace::ColorSpace;
+use crate::encoder::{create_encoder, Bitrate, EncoderConfig, Frame, Speed};
+use crate::ffmpeg::{find_ffmpeg, path_arg};
+use crate::muxer::{create_muxer, MuxerConfig};
+use crate::{
+    available, Av1Backend, BenchmarkReport, BenchmarkResult, BenchmarkSpec, BitDepth, Codec,
+    Container, EncodeOptions, Error, H264Preset, H264Profile, H264Tune, HlsSegment, HwAccel,
+    Mp4Layout, OutputMode, PixelFormat, Result, DEFAULT_FPS, MAX_FPS,
+};
+use std::io::Read;
+use std::path::{Path, PathBuf};
+use std::process::{Command, Stdio};
+use std::time::{Duration, Instant};
+
+/// Frame width of the workload when none is set
+const DEFAULT_WIDTH: u32 = 1280;
+
+/// Frame height of the workload when none is set
+const DEFAULT_HEIGHT: u32 = 720;
+
+/// Duration of the workload when none is set
+const DEFAULT_DURATION_MS: u32 = 5000;
+
+/// Quality of the encodes when none is set
+const DEFAULT_QUALITY: u8 = 50;
+
+/// Smallest frame width and height, one SSIM window of each
+const MIN_SIZE: u32 = 16;
+
+/// PSNR reported for outputs identical to the workload
+const MAX_PSNR: f64 = 100.0;
+
+/// Codecs measured when none are selected, skipping unavailable ones
+const ALL_CODECS: [Codec; 7] = [
+    Codec::Av1,
+    Codec::H264,
+    Codec::Hevc,
+    Codec::Vp9,
+    Codec::Vp8,
+    Codec::Prores,
+    Codec::Png,
+];
+
+/// Workload settings with the defaults applied
+struct Workload {
+    width: u32,
+    height: u32,
+    fps: u32,
+    frames: u32,
+    quality: u8,
+    ffmpeg_path: Option<String>,
+}
+
+/// Encode a standard workload with each codec and measure speed, CPU use
+/// and quality
+///
+/// Codecs of `spec.codecs` that fail are reported with their error; with
+/// no codecs selected, every codec available on the host is measured. The
+/// encoded files are written to `spec.output_dir` (the system temporary
+/// directory by default) and removed after measuring.
+pub fn benchmark(spec: &BenchmarkSpec) -> Result<BenchmarkReport> {
+    let or = |value: u32, default: u32| if value == 0 { default } else { value };
+    let fps = or(spec.fps, DEFAULT_FPS);
+    let workload = Workload {
+        width: or(spec.width, DEFAULT_WIDTH),
+        height: or(spec.height, DEFAULT_HEIGHT),
+        fps,
+        frames: (or(spec.duration_ms, DEFAULT_DURATION_MS) as u64 * fps as u64 / 1000) as u32,
+        quality: if spec.quality == 0 {
+            DEFAULT_QUALITY
+        } else {
+            spec.quality.min(100)
+        },
+        ffmpeg_path: spec.ffmpeg_path.clone(),
+    };
+
+    if workload.width < MIN_SIZE || workload.height < MIN_SIZE {
+        return Err(Error::InvalidInput(format!(
+            "Benchmark frames must be at least {}x{}",
+            MIN_SIZE, MIN_SIZE
+        )));
+    }
+    if (workload.width | workload.height) & 1 != 0 {
+        return Err(Error::InvalidInput(
+            "Benchmark frame width and height must be even".to_string(),
+        ));
+    }
+    if fps > MAX_FPS {
+        return Err(Error::InvalidInput(format!(
+            "Frame rate must be at most {} fps",
+            MAX_FPS
+        )));
+    }
+    if workload.frames == 0 {
+        return Err(Error::InvalidInput(
+            "Benchmark is shorter than one frame".to_string(),
+        ));
+    }
+
+    let dir = match &spec.output_dir {
+        Some(dir) => PathBuf::from(dir),
+        None => std::env::temp_dir(),
+    };
+    if !dir.is_dir() {
+        return Err(Error::InvalidInput(format!(
+            "Benchmark directory not found: {}",
+            dir.display()
+        )));
+    }
+
+    let codecs: Vec<Codec> = if spec.codecs.is_empty() {
+        ALL_CODECS
+            .into_iter()
+            .filter(|&codec| available(codec, workload.ffmpeg_path.as_deref()).is_ok())
+            .collect()
+    } else {
+        spec.codecs.clone()
+    };
+
+    let results = codecs
+        .into_iter()
+        .map(|codec| measure(&workload, codec, &dir))
+        .collect();
+
+    Ok(BenchmarkReport {
+        width: workload.width,
+        height: workload.height,
+        fps: workload.fps,
+        frames: workload.frames,
+        cpu_count: std::thread::available_parallelism().map_or(1, |n| n.get() as u32),
+        results,
+    })
+}
+
+/// Container each codec is measured in
+fn container_for(codec: Codec) -> (Container, &'static str) {
+    match codec {
+        Codec::Av1 | Codec::Vp9 | Codec::Vp8 => (Container::WebM, "webm"),
+        Codec::H264 | Codec::Hevc => (Container::Mp4, "mp4"),
+        Codec::Prores => (Container::Mov, "mov"),
+        Codec::Png => (Container::Apng, "png"),
+    }
+}
+
+/// Encode the workload with a codec and measure it
+fn measure(workload: &Workload, codec: Codec, dir: &Path) -> BenchmarkResult {
+    let (container, extension) = container_for(codec);
+    let mut result = BenchmarkResult {
+        codec,
+        container,
+        error: None,
+        frames: 0,
+        encode_ms: 0,
+        encode_fps: 0.0,
+        cpu_percent: None,
+        output_bytes: 0,
+        bitrate_kbps: 0,
+        psnr: None,
+        ssim: None,
+    };
+
+    let output = OutputFile(dir.join(format!(
+        "minmpeg-benchmark-{}-{}.{}",
+        std::process::id(),
+        format!("{:?}", codec).to_lowercase(),
+        extension
+    )));
+    let stopwatch = match encode(workload, codec, container, &output.0) {
+        Ok(stopwatch) => stopwatch,
+        Err(e) => {
+            result.error = Some(e);
+            return result;
+        }
+    };
+
+    let seconds = stopwatch.wall.as_secs_f64().max(1e-6);
+    let duration_ms = (workload.frames as u64 * 1000 / workload.fps as u64).max(1);
+    result.frames = workload.frames;
+    result.encode_ms = stopwatch.wall.as_millis() as u64;
+    result.encode_fps = workload.frames as f64 / seconds;
+    result.cpu_percent = stopwatch.cpu.map(|cpu| cpu.as_secs_f64() / seconds * 100.0);
+    result.output_bytes = std::fs::metadata(&output.0).map_or(0, |m| m.len());
+    result.bitrate_kbps = (result.output_bytes * 8 / duration_ms) as u32;
+
+    if let Some((psnr, ssim)) = compare_output(workload, &output.0) {
+        result.psnr = Some(psnr);
+        result.ssim = Some(ssim);
+    }
+    result
+}
+
+/// Encode and mux the workload, timing only the encoder and muxer
+fn encode(
+    workload: &Workload,
+    codec: Codec,
+    container: Container,
+    path: &Path,
+) -> Result<Stopwatch> {
+    available(codec, workload.ffmpeg_path.as_deref())?;
+
+    // The color defaults of the container, as an encode without options gets
+    let color = ColorSpace::new(&EncodeOptions {
+        container,
+        ..Default::default()
+    });
+    let config = EncoderConfig {
+        width: workload.width,
+        height: workload.height,
+        fps: workload.fps,
+        quality: workload.quality,
+        ffmpeg_path: workload.ffmpeg_path.clone(),
+        speed: Speed::Default,
+        lossless: false,
+        alpha: false,
+        two_pass: None,
+        bitrate: Bitrate::default(),
+        keyframe_interval: 0,
+        forced_keyframes: Vec::new(),
+        pixel_format: PixelFormat::Auto,
+        bit_depth: BitDepth::Eight,
+        hdr: None,
+        color,
+        hw_accel: HwAccel::Auto,
+        threads: 0,
+        av1_backend: Av1Backend::Rav1e,
+        av1_preset: 0,
+        h264_profile: H264Profile::Auto,
+        h264_level: 0,
+        h264_preset: H264Preset::Auto,
+        h264_tune: H264Tune::None,
+        ffmpeg_args: Vec::new(),
+    };
+
+    let mut stopwatch = Stopwatch::new();
+    let mut encoder = stopwatch.time(|| create_encoder(codec, config))?;
+    let mut packets = Vec::new();
+    for index in 0..workload.frames {
+        let frame = Frame {
+            width: workload.width,
+            height: workload.height,
+            data: workload_frame(workload.width, workload.height, index),
+            pts_ms: index as u64 * 1000 / workload.fps as u64,
+        };
+        packets.extend(stopwatch.time(|| encoder.encode(&frame))?);
+    }
+
+    stopwatch.time(|| {
+        packets.extend(encoder.flush()?);
+        let mut muxer = create_muxer(
+            container,
+            path,
+            MuxerConfig {
+                width: workload.width,
+                height: workload.height,
+                fps: workload.fps,
+                codec,
+                codec_config: encoder.codec_config(),
+                pps: encoder.pps(),
+                alpha: false,
+                hls_segment: HlsSegment::default(),
+                segment_ms: 0,
+                mp4_layout: Mp4Layout::default(),
+                output_mode: OutputMode::Single,
+                hdr: None,
+                color,
+                chapters: Vec::new(),
+            },
+        )?;
+        for packet in &packets {
+            muxer.write_packet(packet)?;
+        }
+        muxer.finalize()
+    })?;
+    Ok(stopwatch)
+}
+
+/// RGBA frame `index` of the workload
+///
+/// A gradient with grain pans to the left behind a checkered square
+/// bouncing from side to side, so encoders see both motion and detail.
+fn workload_frame(width: u32, height: u32, index: u32) -> Vec<u8> {
+    let pan = index * 4;
+    let size = height / 4;
+    let travel = (width - size).max(1);
+    let bounce = index * 8 % (travel * 2);
+    let square_x = if bounce < travel {
+        bounce
+    } else {
+        travel * 2 - bounce
+    };
+    let square_y = (height - size) / 2;
+
+    let mut data = Vec::with_capacity((width * height * 4) as usize);
+    for y in 0..height {
+        for x in 0..width {
+            let pixel = if (square_x..square_x + size).contains(&x)
+                && (square_y..square_y + size).contains(&y)
+            {
+                let v = if ((x - square_x) / 8 + (y - square_y) / 8) & 1 == 0 {
+                    240
+                } else {
+                    16
+                };
+                [v, v, v]
+            } else {
+                let u = x + pan;
+                let grain = (grain(u, y) % 32) as i32 - 16;
+                let shade = |v: u32| (v as i32 % 256 + grain).clamp(0, 255) as u8;
+                [shade(u), shade(y * 255 / height), shade((u + y) / 2 + 64)]
+            };
+            data.extend_from_slice(&pixel);
+            data.push(255);
+        }
+    }
+    data
+}
+
+/// Pseudo-random value of a point of the workload
+fn grain(x: u32, y: u32) -> u32 {
+    let mut h = x.wrapping_mul(0x9E37_79B1) ^ y.wrapping_mul(0x85EB_CA77);
+    h ^= h >> 15;
+    h = h.wrapping_mul(0x2C1B_3C6D);
+    h ^ (h >> 12)
+}
+
+/// PSNR and SSIM of the encoded output against the workload (None when it
+/// cannot be decoded)
+fn compare_output(workload: &Workload, path: &Path) -> Option<(f64, f64)> {
+    let ffmpeg = find_ffmpeg(workload.ffmpeg_path.as_deref()).ok()?;
+    let mut process = Command::new(&ffmpeg)
+        .args(["-v", "error", "-nostdin", "-i"])
+        .arg(path_arg(path))
+        .args(["-map", "0:v:0", "-r", &workload.fps.to_string()])
+        .args(["-f", "rawvideo", "-pix_fmt", "rgba", "pipe:1"])
+        .stdout(Stdio::piped())
+        .stderr(Stdio::null())
+        .spawn()
+        .ok()?;
+    let mut stdout = process.stdout.take()?;
+
+    let (width, height) = (workload.width as usize, workload.height as usize);
+    let mut buffer = vec![0u8; width * height * 4];
+    let mut squared_error = 0u64;
+    let mut ssim_sum = 0.0;
+    let mut frames = 0;
+    while frames < workload.frames && stdout.read_exact(&mut buffer).is_ok() {
+        let expected = workload_frame(workload.width, workload.height, frames);
+        squared_error += rgb_squared_error(&expected, &buffer);
+        ssim_sum += ssim(&expected, &buffer, width, height);
+        frames += 1;
+    }
+    drop(stdout);
+    let _ = process.kill();
+    let _ = process.wait();
+
+    if frames == 0 {
+        return None;
+    }
+    let samples = (width * height * 3) as f64 * frames as f64;
+    Some((
+        psnr(squared_error as f64 / samples),
+        ssim_sum / frames as f64,
+    ))
+}
+
+/// Sum of squared differences of the color channels of RGBA pixels
+fn rgb_squared_error(a: &[u8], b: &[u8]) -> u64 {
+    a.chunks_exact(4)
+        .zip(b.chunks_exact(4))
+        .flat_map(|(p, q)| (0..3).map(move |c| p[c].abs_diff(q[c]) as u64))
+        .map(|d| d * d)
+        .sum()
+}
+
+/// PSNR in dB of a mean squared error of 8-bit samples
+fn psnr(mse: f64) -> f64 {
+    if mse <= 0.0 {
+        return MAX_PSNR;
+    }
+    (10.0 * (255.0 * 255.0 / mse).log10()).min(MAX_PSNR)
+}
+
+/// Mean SSIM of the luma of RGBA frames over 8x8 windows
+fn ssim(a: &[u8], b: &[u8], width: usize, height: usize) -> f64 {
+    const C1: f64 = (0.01 * 255.0) * (0.01 * 255.0);
+    const C2: f64 = (0.03 * 255.0) * (0.03 * 255.0);
+    let luma = |data: &[u8], x: usize, y: usize| {
+        let p = &data[(y * width + x) * 4..];
+        0.2126 * p[0] as f64 + 0.7152 * p[1] as f64 + 0.0722 * p[2] as f64
+    };
+
+    let mut total = 0.0;
+    let mut windows = 0;
+    for wy in (0..height - 7).step_by(8) {
+        for wx in (0..width - 7).step_by(8) {
+            let (mut sa, mut sb, mut saa, mut sbb, mut sab) = (0.0, 0.0, 0.0, 0.0, 0.0);
+            for y in wy..wy + 8 {
+                for x in wx..wx + 8 {
+                    let (va, vb) = (luma(a, x, y), luma(b, x, y));
+                    sa += va;
+                    sb += vb;
+                    saa += va * va;
+                    sbb += vb * vb;
+                    sab += va * vb;
+                }
+            }
+            let n = 64.0;
+            let (ma, mb) = (sa / n, sb / n);
+            let (va, vb) = (saa / n - ma * ma, sbb / n - mb * mb);
+            let cov = sab / n - ma * mb;
+            total += ((2.0 * ma * mb + C1) * (2.0 * cov + C2))
+                / ((ma * ma + mb * mb + C1) * (va + vb + C2));
+            windows += 1;
+        }
+    }
+    total / windows.max(1) as f64
+}
+
+/// Encoded file of a codec, removed when dropped
+struct OutputFile(PathBuf);
+
+impl Drop for OutputFile {
+    fn drop(&mut self) {
+        let _ = std::fs::remove_file(&self.0);
+    }
+}
+
+/// Wall-clock and CPU time spent in timed calls
+struct Stopwatch {
+    wall: Duration,
+    /// None when the platform does not report CPU time
+    cpu: Option<Duration>,
+}
+
+impl Stopwatch {
+    fn new() -> Self {
+        Self {
+            wall: Duration::ZERO,
+            cpu: cpu_time().map(|_| Duration::ZERO),
+        }
+    }
+
+    /// Run `f`, adding its time to the totals
+    fn time<T>(&mut self, f: impl FnOnce() -> T) -> T {
+        let cpu_start = cpu_time();
+        let start = Instant::now();
+        let value = f();
+        self.wall += start.elapsed();
+        if let (Some(total), Some(start), Some(end)) = (&mut self.cpu, cpu_start, cpu_time()) {
+            *total += end.saturating_sub(start);
+        }
+        value
+    }
+}
+
+/// CPU time used by the process and its finished child processes (ffmpeg
+/// encoders)
+#[cfg(unix)]
+#[allow(clippy::unnecessary_cast)] // The timeval field types vary by platform
+fn cpu_time() -> Option<Duration> {
+    let usage = |who| {
+        let mut usage: libc::rusage = unsafe { std::mem::zeroed() };
+        // SAFETY: `usage` is a writable rusage
+        if unsafe { libc::getrusage(who, &mut usage) } != 0 {
+            return None;
+        }
+        let time = |t: libc::timeval| {
+            Duration::from_secs(t.tv_sec as u64) + Duration::from_micros(t.tv_usec as u64)
+        };
+        Some(time(usage.ru_utime) + time(usage.ru_stime))
+    };
+    Some(usage(libc::RUSAGE_SELF)? + usage(libc::RUSAGE_CHILDREN)?)
+}
+
+/// CPU time used by the process (child processes are not counted)
+#[cfg(windows)]
+fn cpu_time() -> Option<Duration> {
+    use windows::Win32::Foundation::FILETIME;
+    use windows::Win32::System::Threading::{GetCurrentProcess, GetProcessTimes};
+
+    let (mut creation, mut exit, mut kernel, mut user): (FILETIME, FILETIME, FILETIME, FILETIME) =
+        Default::default();
+    // SAFETY: the pointers refer to writable FILETIMEs
+    unsafe {
+        GetProcessTimes(
+            GetCurrentProcess(),
+            &mut creation,
+            &mut exit,
+            &mut kernel,
+            &mut user,
+        )
+    }
+    .ok()?;
+    let ticks = |t: FILETIME| ((t.dwHighDateTime as u64) << 32) | t.dwLowDateTime as u64;
+    // FILETIME counts 100 ns intervals
+    Some(Duration::from_nanos((ticks(kernel) + ticks(user)) * 100))
+}
+
+/// CPU time is not reported on other platforms
+#[cfg(not(any(unix, windows)))]
+fn cpu_time() -> Option<Duration> {
+    None
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_workload_frame() {
+        let first = workload_frame(64, 32, 0);
+        assert_eq!(first.len(), 64 * 32 * 4);
+        assert_eq!(first, workload_frame(64, 32, 0));
+        assert_ne!(first, workload_frame(64, 32, 1));
+        assert!(first.chunks_exact(4).all(|p| p[3] == 255));
+    }
+
+    #[test]
+    fn test_metrics() {
+        let a = workload_frame(32, 32, 0);
+        assert_eq!(rgb_squared_error(&a, &a), 0);
+        assert_eq!(psnr(0.0), MAX_PSNR);
+        assert!((ssim(&a, &a, 32, 32) - 1.0).abs() < 1e-9);
+
+        // A uniform error of 1 gives 48.13 dB
+        let b: Vec<u8> = a
+            .iter()
+            .enumerate()
+            .map(|(i, &v)| if i % 4 == 3 { v } else { v ^ 1 })
+            .collect();
+        assert_eq!(rgb_squared_error(&a, &b), 32 * 32 * 3);
+        assert!((psnr(1.0) - 48.13).abs() < 0.01);
+
+        let noisy = workload_frame(32, 32, 40);
+        let score = ssim(&a, &noisy, 32, 32);
+        assert!(score < ssim(&a, &b, 32, 32) && score < 1.0);
+    }
+
+    #[test]
+    fn test_invalid_spec() {
+        let spec = |width, height, duration_ms| BenchmarkSpec {
+            codecs: vec![Codec::Png],
+            width,
+            height,
+            duration_ms,
+            ..Default::default()
+        };
+        assert!(benchmark(&spec(8, 8, 100)).is_err());
+        assert!(benchmark(&spec(33, 32, 100)).is_err());
+        assert!(benchmark(&spec(32, 32, 10)).is_err());
+        assert!(benchmark(&BenchmarkSpec {
+            output_dir: Some("/nonexistent/minmpeg".to_string()),
+            ..spec(32, 32, 100)
+        })
+        .is_err());
+    }
+
+    #[test]
+    fn test_png() {
+        let report = benchmark(&BenchmarkSpec {
+            codecs: vec![Codec::Png],
+            width: 32,
+            height: 32,
+            duration_ms: 200,
+            fps: 10,
+            ..Default::default()
+        })
+        .unwrap();
+        assert_eq!((report.frames, report.fps), (2, 10));
+        assert!(report.cpu_count >= 1);
+
+        let result = &report.results[0];
+        assert!(result.error.is_none(), "{:?}", result.error);
+        assert_eq!(result.container, Container::Apng);
+        assert_eq!(result.frames, 2);
+        assert!(result.output_bytes > 0);
+        assert!(result.encode_fps > 0.0);
+    }
+}
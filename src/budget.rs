@@ -0,0 +1,138 @@
+//! Render-time budgets
+//!
+//! Interactive previews need a video within a few seconds more than they
+//! need the best quality. Given a wall-clock budget, the encoder speed
+//! preset, and for slideshows the output resolution, are chosen from a
+//! rough throughput model so that encoding is expected to finish in time.
+//! The model assumes a typical 4-core machine, so the budget is a target
+//! rather than a guarantee.
+
+use crate::encoder::Speed;
+use crate::Codec;
+use std::time::Duration;
+
+/// Share of the remaining budget planned for encoding; the rest covers
+/// muxing, audio and estimation error
+const ENCODE_SHARE: f64 = 0.8;
+
+/// Lowest resolution chosen, in percent of the normal output
+const MIN_SCALE: u32 = 25;
+
+/// Speed presets from slowest to fastest with their estimated speedup
+/// over the default preset
+const SPEEDS: [(Speed, f64); 4] = [
+    (Speed::Default, 1.0),
+    (Speed::Fast, 2.0),
+    (Speed::Faster, 4.0),
+    (Speed::Fastest, 8.0),
+];
+
+/// Encoder speed and output scale expected to meet a budget
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub(crate) struct Plan {
+    pub speed: Speed,
+    /// Resolution in percent of the normal output
+    pub scale: u32,
+}
+
+impl Default for Plan {
+    fn default() -> Self {
+        Self {
+            speed: Speed::Default,
+            scale: 100,
+        }
+    }
+}
+
+/// Estimated pixels per second of each codec at its default preset
+fn throughput(codec: Codec) -> f64 {
+    match codec {
+        Codec::Av1 => 2.0e6,
+        Codec::Vp9 => 5.0e6,
+        Codec::Vp8 => 12.0e6,
+        Codec::Hevc => 8.0e6,
+        Codec::H264 => 20.0e6,
+        Codec::Png => 30.0e6,
+        Codec::Prores => 40.0e6,
+    }
+}
+
+/// Plan an encode of `frames` frames of `pixels` pixels each
+///
+/// `remaining` is the part of the budget left for encoding and muxing, or
+/// None when there is no budget.
+pub(crate) fn plan(codec: Codec, pixels: u64, frames: u64, remaining: Option<Duration>) -> Plan {
+    let remaining = match remaining {
+        Some(remaining) => remaining,
+        None => return Plan::default(),
+    };
+
+    let seconds = (remaining.as_secs_f64() * ENCODE_SHARE).max(0.001);
+    let required = (pixels * frames) as f64 / seconds;
+    let base = throughput(codec);
+
+    for (speed, speedup) in SPEEDS {
+        if base * speedup >= required {
+            return Plan { speed, scale: 100 };
+        }
+    }
+
+    // Even the fastest preset is too slow: also reduce the pixel count
+    let (speed, speedup) = SPEEDS[SPEEDS.len() - 1];
+    let scale = (100.0 * (base * speedup / required).sqrt()) as u32;
+    Plan {
+        speed,
+        scale: scale.clamp(MIN_SCALE, 100),
+    }
+}
+
+/// Remaining part of a budget of `budget_ms` milliseconds (0 for no budget)
+pub(crate) fn remaining(budget_ms: u32, elapsed: Duration) -> Option<Duration> {
+    if budget_ms == 0 {
+        None
+    } else {
+        Some(Duration::from_millis(budget_ms as u64).saturating_sub(elapsed))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const HD: u64 = 1280 * 720;
+
+    #[test]
+    fn test_no_budget() {
+        assert_eq!(plan(Codec::Av1, HD, 300, None), Plan::default());
+        assert_eq!(remaining(0, Duration::from_secs(5)), None);
+    }
+
+    #[test]
+    fn test_generous_budget_keeps_defaults() {
+        let budget = Some(Duration::from_secs(600));
+        assert_eq!(plan(Codec::Av1, HD, 300, budget), Plan::default());
+    }
+
+    #[test]
+    fn test_tight_budget_speeds_up() {
+        // 300 HD frames of AV1 take about 140 s at the default preset
+        let plan_30s = plan(Codec::Av1, HD, 300, Some(Duration::from_secs(30)));
+        assert_eq!(plan_30s.speed, Speed::Fastest);
+        assert_eq!(plan_30s.scale, 100);
+
+        let plan_60s = plan(Codec::Av1, HD, 300, Some(Duration::from_secs(60)));
+        assert_eq!(plan_60s.speed, Speed::Faster);
+    }
+
+    #[test]
+    fn test_very_tight_budget_scales_down() {
+        let plan = plan(Codec::Av1, HD, 300, Some(Duration::from_secs(5)));
+        assert_eq!(plan.speed, Speed::Fastest);
+        assert!(plan.scale < 100 && plan.scale >= MIN_SCALE);
+
+        let exhausted = remaining(1000, Duration::from_secs(2)).unwrap();
+        assert_eq!(exhausted, Duration::ZERO);
+        let plan = super::plan(Codec::Av1, HD, 300, Some(exhausted));
+        assert_eq!(plan.scale, MIN_SCALE);
+    }
+}
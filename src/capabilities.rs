@@ -0,0 +1,154 @@
+//! Capabilities of the host
+//!
+//! A codec counts when `available` accepts it, and a container with each
+//! codec it can hold. H.264, HEVC and AV1 have several encoders, software
+//! and hardware; each is tried by encoding a frame, since an encoder listed
+//! by ffmpeg or the platform may still lack the device or driver to run.
+
+use crate::colorspace::ColorSpace;
+use crate::encoder::{create_encoder, Bitrate, EncoderConfig, Frame, Speed};
+use crate::{
+    available, Av1Backend, BitDepth, Capability, Codec, Container, Error, H264Preset, H264Profile,
+    H264Tune, HwAccel, PixelFormat, Result,
+};
+
+/// Every codec, in the order capabilities are listed
+const CODECS: [Codec; 7] = [
+    Codec::Av1,
+    Codec::H264,
+    Codec::Png,
+    Codec::Vp9,
+    Codec::Hevc,
+    Codec::Vp8,
+    Codec::Prores,
+];
+
+/// Every container, in the order capabilities are listed
+const CONTAINERS: [Container; 8] = [
+    Container::Mp4,
+    Container::WebM,
+    Container::Avif,
+    Container::Apng,
+    Container::Mov,
+    Container::Mkv,
+    Container::Hls,
+    Container::Dash,
+];
+
+/// Encoders tried for H.264, HEVC and AV1
+const ENCODERS: [HwAccel; 6] = [
+    HwAccel::Software,
+    HwAccel::VideoToolbox,
+    HwAccel::Nvenc,
+    HwAccel::Qsv,
+    HwAccel::Vaapi,
+    HwAccel::Amf,
+];
+
+/// Size of the frame encoded to try an encoder, above the minimum of the
+/// hardware encoders
+const PROBE_SIZE: u32 = 256;
+
+/// List the container, codec and encoder combinations usable on this
+/// machine, ordered by container, then codec, then encoder
+///
+/// Codecs without a choice of encoder are listed with `HwAccel::Software`.
+/// Trying the hardware encoders takes a moment, so callers should keep the
+/// result rather than ask for every request.
+pub fn capabilities(ffmpeg_path: Option<&str>) -> Vec<Capability> {
+    let mut encoders = Vec::new();
+    for codec in CODECS {
+        if available(codec, ffmpeg_path).is_err() {
+            continue;
+        }
+        match codec {
+            Codec::H264 | Codec::Hevc | Codec::Av1 => encoders.extend(
+                ENCODERS
+                    .into_iter()
+                    .filter(|&hw_accel| {
+                        // rav1e is built in, so AV1 always has a software encoder
+                        (codec == Codec::Av1 && hw_accel == HwAccel::Software)
+                            || probe(codec, hw_accel, ffmpeg_path).is_ok()
+                    })
+                    .map(|hw_accel| (codec, hw_accel)),
+            ),
+            _ => encoders.push((codec, HwAccel::Software)),
+        }
+    }
+
+    CONTAINERS
+        .into_iter()
+        .flat_map(|container| {
+            encoders
+                .iter()
+                .filter(move |(codec, _)| container.supports_codec(*codec))
+                .map(move |&(codec, hw_accel)| Capability {
+                    container,
+                    codec,
+                    hw_accel,
+                })
+        })
+        .collect()
+}
+
+/// Encode a single frame with an encoder of a codec
+fn probe(codec: Codec, hw_accel: HwAccel, ffmpeg_path: Option<&str>) -> Result<()> {
+    let config = EncoderConfig {
+        width: PROBE_SIZE,
+        height: PROBE_SIZE,
+        fps: 1,
+        quality: 50,
+        ffmpeg_path: ffmpeg_path.map(str::to_string),
+        speed: Speed::Fastest,
+        lossless: false,
+        alpha: false,
+        two_pass: None,
+        bitrate: Bitrate::default(),
+        keyframe_interval: 0,
+        forced_keyframes: Vec::new(),
+        pixel_format: PixelFormat::Auto,
+        bit_depth: BitDepth::Eight,
+        hdr: None,
+        color: ColorSpace::default(),
+        hw_accel,
+        threads: 0,
+        av1_backend: Av1Backend::Rav1e,
+        av1_preset: 0,
+        h264_profile: H264Profile::Auto,
+        h264_level: 0,
+        h264_preset: H264Preset::Auto,
+        h264_tune: H264Tune::None,
+        ffmpeg_args: Vec::new(),
+    };
+    let mut encoder = create_encoder(codec, config)?;
+    let frame = Frame {
+        width: PROBE_SIZE,
+        height: PROBE_SIZE,
+        data: vec![128; (PROBE_SIZE * PROBE_SIZE * 4) as usize],
+        pts_ms: 0,
+    };
+    let mut packets = encoder.encode(&frame)?;
+    packets.extend(encoder.flush()?);
+    if packets.is_empty() {
+        return Err(Error::Encode("No packets from the encoder".to_string()));
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_lists_png() {
+        let capabilities = capabilities(Some("/nonexistent/ffmpeg"));
+        assert!(capabilities.contains(&Capability {
+            container: Container::Apng,
+            codec: Codec::Png,
+            hw_accel: HwAccel::Software,
+        }));
+        for capability in &capabilities {
+            assert!(capability.container.supports_codec(capability.codec));
+        }
+    }
+}
@@ -0,0 +1,231 @@
+//! Color description of the encoded video
+//!
+//! Frames are drawn as RGBA and converted to YUV by the encoders. The matrix
+//! and range of that conversion, with the primaries and transfer the pixels
+//! are in, are written into the bitstream and the container as H.273 code
+//! points, so every player converts back the same way. Untagged video is
+//! guessed at by players, which differ: some assume BT.601, others BT.709,
+//! and colors shift between them.
+
+use crate::{
+    ColorMatrix, ColorPrimaries, ColorRange, ColorTransfer, Container, DynamicRange, EncodeOptions,
+};
+
+/// Color description of an output: H.273 code points and the range of the
+/// YUV samples
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct ColorSpace {
+    /// Color primaries (H.273 ColourPrimaries)
+    pub primaries: u8,
+    /// Transfer characteristics (H.273 TransferCharacteristics)
+    pub transfer: u8,
+    /// Matrix coefficients (H.273 MatrixCoefficients)
+    pub matrix: u8,
+    /// Samples use the full range instead of the limited (studio) range
+    pub full_range: bool,
+}
+
+impl Default for ColorSpace {
+    /// BT.709 primaries, transfer and matrix in the limited range, as video
+    /// is usually tagged
+    fn default() -> Self {
+        Self {
+            primaries: 1,
+            transfer: 1,
+            matrix: 1,
+            full_range: false,
+        }
+    }
+}
+
+impl ColorSpace {
+    /// BT.2020 primaries and matrix with the PQ transfer in the limited range
+    pub const HDR10: Self = Self {
+        primaries: 9,
+        transfer: 16,
+        matrix: 9,
+        full_range: false,
+    };
+
+    /// sRGB, as still images are usually tagged: BT.709 primaries, the sRGB
+    /// transfer and the BT.601 matrix in the full range
+    const IMAGE: Self = Self {
+        primaries: 1,
+        transfer: 13,
+        matrix: 6,
+        full_range: true,
+    };
+
+    /// Resolve the color options, filling unset ones with the defaults of
+    /// the output
+    pub fn new(options: &EncodeOptions) -> Self {
+        if options.dynamic_range == DynamicRange::Hdr10 {
+            return Self::HDR10;
+        }
+        let defaults = match options.container {
+            Container::Avif => Self::IMAGE,
+            _ => Self::default(),
+        };
+        Self {
+            primaries: match options.color_primaries {
+                ColorPrimaries::Auto => defaults.primaries,
+                ColorPrimaries::Bt709 => 1,
+                ColorPrimaries::Bt601 => 6,
+                ColorPrimaries::Bt2020 => 9,
+                ColorPrimaries::DisplayP3 => 12,
+            },
+            transfer: match options.color_transfer {
+                ColorTransfer::Auto => defaults.transfer,
+                ColorTransfer::Bt709 => 1,
+                ColorTransfer::Srgb => 13,
+            },
+            matrix: match options.color_matrix {
+                ColorMatrix::Auto => defaults.matrix,
+                ColorMatrix::Bt709 => 1,
+                ColorMatrix::Bt601 => 6,
+                ColorMatrix::Bt2020 => 9,
+            },
+            full_range: match options.color_range {
+                ColorRange::Auto => defaults.full_range,
+                ColorRange::Limited => false,
+                ColorRange::Full => true,
+            },
+        }
+    }
+
+    /// Luma weights of red and blue (Kr, Kb) of the matrix
+    fn weights(&self) -> (f32, f32) {
+        match self.matrix {
+            6 => (0.299, 0.114),
+            9 => (0.2627, 0.0593),
+            _ => (0.2126, 0.0722),
+        }
+    }
+
+    /// Luma of an 8-bit RGB color on the 8-bit scale
+    pub fn luma(&self, rgb: [f32; 3]) -> f32 {
+        let (kr, kb) = self.weights();
+        let y = kr * rgb[0] + (1.0 - kr - kb) * rgb[1] + kb * rgb[2];
+        if self.full_range {
+            y
+        } else {
+            16.0 + y * 219.0 / 255.0
+        }
+    }
+
+    /// Blue- and red-difference chroma (Cb, Cr) of an 8-bit RGB color on the
+    /// 8-bit scale
+    pub fn chroma(&self, rgb: [f32; 3]) -> [f32; 2] {
+        let (kr, kb) = self.weights();
+        let y = kr * rgb[0] + (1.0 - kr - kb) * rgb[1] + kb * rgb[2];
+        let scale = if self.full_range { 1.0 } else { 224.0 / 255.0 };
+        [
+            128.0 + scale * (rgb[2] - y) / (2.0 * (1.0 - kb)),
+            128.0 + scale * (rgb[0] - y) / (2.0 * (1.0 - kr)),
+        ]
+    }
+
+    /// ffmpeg output arguments converting RGBA input with the matrix and
+    /// range, and tagging the stream with the color description
+    pub fn ffmpeg_args(&self) -> Vec<String> {
+        // Names of the matrix in the scale filter and in the stream tags
+        let (scale_matrix, matrix) = match self.matrix {
+            6 => ("bt601", "smpte170m"),
+            9 => ("bt2020", "bt2020nc"),
+            _ => ("bt709", "bt709"),
+        };
+        let primaries = match self.primaries {
+            6 => "smpte170m",
+            9 => "bt2020",
+            12 => "smpte432",
+            _ => "bt709",
+        };
+        let transfer = match self.transfer {
+            13 => "iec61966-2-1",
+            16 => "smpte2084",
+            _ => "bt709",
+        };
+        let range = if self.full_range { "pc" } else { "tv" };
+        vec![
+            "-vf".to_string(),
+            format!(
+                "scale=out_color_matrix={}:out_range={}",
+                scale_matrix, range
+            ),
+            "-color_primaries".to_string(),
+            primaries.to_string(),
+            "-color_trc".to_string(),
+            transfer.to_string(),
+            "-colorspace".to_string(),
+            matrix.to_string(),
+            "-color_range".to_string(),
+            range.to_string(),
+        ]
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_resolve() {
+        let options = EncodeOptions::default();
+        assert_eq!(ColorSpace::new(&options), ColorSpace::default());
+
+        let options = EncodeOptions {
+            container: Container::Avif,
+            color_range: ColorRange::Limited,
+            ..Default::default()
+        };
+        let color = ColorSpace::new(&options);
+        assert_eq!((color.primaries, color.transfer, color.matrix), (1, 13, 6));
+        assert!(!color.full_range);
+
+        let options = EncodeOptions {
+            dynamic_range: DynamicRange::Hdr10,
+            ..Default::default()
+        };
+        assert_eq!(ColorSpace::new(&options), ColorSpace::HDR10);
+    }
+
+    #[test]
+    fn test_conversion() {
+        let limited = ColorSpace::default();
+        assert!((limited.luma([0.0; 3]) - 16.0).abs() < 0.01);
+        assert!((limited.luma([255.0; 3]) - 235.0).abs() < 0.01);
+        for value in limited.chroma([255.0, 255.0, 255.0]) {
+            assert!((value - 128.0).abs() < 0.01);
+        }
+        let [cb, cr] = limited.chroma([0.0, 0.0, 255.0]);
+        assert!((cb - 240.0).abs() < 0.01);
+        assert!(cr < 128.0);
+
+        let full = ColorSpace {
+            full_range: true,
+            ..Default::default()
+        };
+        assert!((full.luma([255.0; 3]) - 255.0).abs() < 0.01);
+        let [_, cr] = full.chroma([255.0, 0.0, 0.0]);
+        assert!((cr - 255.5).abs() < 0.01);
+    }
+
+    #[test]
+    fn test_ffmpeg_args() {
+        let args = ColorSpace::HDR10.ffmpeg_args();
+        assert_eq!(args[1], "scale=out_color_matrix=bt2020:out_range=tv");
+        assert_eq!(
+            &args[2..],
+            [
+                "-color_primaries",
+                "bt2020",
+                "-color_trc",
+                "smpte2084",
+                "-colorspace",
+                "bt2020nc",
+                "-color_range",
+                "tv"
+            ]
+        );
+    }
+}
@@ -0,0 +1,195 @@
+//! Cover cropping of slides
+//!
+//! A slide with a different aspect ratio than the output is scaled to fill
+//! the frame and the overflow is cropped. The crop window is centered, or
+//! placed on the most salient part of the image: regions with skin tones
+//! (faces) and fine detail. The saliency is a fast heuristic, not a face
+//! detection model.
+
+use crate::image_loader::LoadedImage;
+use crate::CropFocus;
+
+/// Longest side of the grid the saliency is measured on
+const SALIENCY_GRID: u32 = 256;
+
+/// Weight of skin-tone pixels relative to the maximum gradient
+const SKIN_WEIGHT: f32 = 2.0;
+
+/// Scale an image to fill the target size, cropping the overflow
+pub(crate) fn cover(image: &LoadedImage, width: u32, height: u32, focus: CropFocus) -> LoadedImage {
+    let (crop_w, crop_h) = crop_size(image.width, image.height, width, height);
+    let (x, y) = match focus {
+        CropFocus::Center => ((image.width - crop_w) / 2, (image.height - crop_h) / 2),
+        CropFocus::Salient => salient_offset(image, crop_w, crop_h),
+    };
+    image.crop(x, y, crop_w, crop_h).resize(width, height)
+}
+
+/// Largest window of the target aspect ratio inside the image
+fn crop_size(image_w: u32, image_h: u32, width: u32, height: u32) -> (u32, u32) {
+    let (iw, ih) = (image_w as u64, image_h as u64);
+    let (w, h) = (width.max(1) as u64, height.max(1) as u64);
+    if iw * h > ih * w {
+        // Wider than the target: crop the sides
+        (((ih * w / h) as u32).clamp(1, image_w), image_h)
+    } else {
+        (image_w, ((iw * h / w) as u32).clamp(1, image_h))
+    }
+}
+
+/// Offset of the crop window that keeps the most salient content
+fn salient_offset(image: &LoadedImage, crop_w: u32, crop_h: u32) -> (u32, u32) {
+    let step = (image.width.max(image.height) / SALIENCY_GRID).max(1);
+    let grid_w = image.width.div_ceil(step) as usize;
+    let grid_h = image.height.div_ceil(step) as usize;
+    let scores = saliency(image, step, grid_w, grid_h);
+
+    if crop_w < image.width {
+        let columns: Vec<f32> = (0..grid_w)
+            .map(|gx| (0..grid_h).map(|gy| scores[gy * grid_w + gx]).sum())
+            .collect();
+        let window = (crop_w / step).max(1) as usize;
+        let x = best_window(&columns, window) as u32 * step;
+        (x.min(image.width - crop_w), 0)
+    } else if crop_h < image.height {
+        let rows: Vec<f32> = (0..grid_h)
+            .map(|gy| scores[gy * grid_w..(gy + 1) * grid_w].iter().sum())
+            .collect();
+        let window = (crop_h / step).max(1) as usize;
+        let y = best_window(&rows, window) as u32 * step;
+        (0, y.min(image.height - crop_h))
+    } else {
+        (0, 0)
+    }
+}
+
+/// Saliency of each grid cell: luma gradient plus a bonus for skin tones
+fn saliency(image: &LoadedImage, step: u32, grid_w: usize, grid_h: usize) -> Vec<f32> {
+    let pixel = |x: usize, y: usize| {
+        let i = (y * image.width as usize + x) * 4;
+        [
+            image.data[i] as f32,
+            image.data[i + 1] as f32,
+            image.data[i + 2] as f32,
+        ]
+    };
+    let luma = |p: [f32; 3]| 0.2126 * p[0] + 0.7152 * p[1] + 0.0722 * p[2];
+
+    let mut scores = vec![0.0; grid_w * grid_h];
+    for gy in 0..grid_h {
+        for gx in 0..grid_w {
+            let x = gx * step as usize;
+            let y = gy * step as usize;
+            let p = pixel(x, y);
+            let right = pixel((x + step as usize).min(image.width as usize - 1), y);
+            let below = pixel(x, (y + step as usize).min(image.height as usize - 1));
+
+            let l = luma(p);
+            let gradient = (luma(right) - l).abs() + (luma(below) - l).abs();
+            let skin = if is_skin(p) { SKIN_WEIGHT * 255.0 } else { 0.0 };
+            scores[gy * grid_w + gx] = gradient + skin;
+        }
+    }
+    scores
+}
+
+/// Skin-tone test in YCbCr (Chai and Ngan)
+fn is_skin([r, g, b]: [f32; 3]) -> bool {
+    let cb = 128.0 - 0.168736 * r - 0.331264 * g + 0.5 * b;
+    let cr = 128.0 + 0.5 * r - 0.418688 * g - 0.081312 * b;
+    (77.0..=127.0).contains(&cb) && (133.0..=173.0).contains(&cr)
+}
+
+/// Start of the window with the highest total, preferring the center on ties
+fn best_window(values: &[f32], window: usize) -> usize {
+    if window >= values.len() {
+        return 0;
+    }
+    let center = (values.len() - window) as f32 / 2.0;
+    let mut sum: f32 = values[..window].iter().sum();
+    let mut best = (sum, -center, 0);
+    for start in 1..=values.len() - window {
+        sum += values[start + window - 1] - values[start - 1];
+        let candidate = (sum, -(start as f32 - center).abs(), start);
+        if candidate.0 > best.0 || (candidate.0 == best.0 && candidate.1 > best.1) {
+            best = candidate;
+        }
+    }
+    best.2
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn solid(width: u32, height: u32, color: [u8; 3]) -> LoadedImage {
+        LoadedImage {
+            width,
+            height,
+            data: (0..width * height)
+                .flat_map(|_| [color[0], color[1], color[2], 255])
+                .collect(),
+        }
+    }
+
+    fn paint(image: &mut LoadedImage, x0: u32, x1: u32, y0: u32, y1: u32, color: [u8; 3]) {
+        for y in y0..y1 {
+            for x in x0..x1 {
+                let i = ((y * image.width + x) * 4) as usize;
+                image.data[i..i + 3].copy_from_slice(&color);
+            }
+        }
+    }
+
+    #[test]
+    fn test_crop_size() {
+        assert_eq!(crop_size(400, 100, 200, 100), (200, 100));
+        assert_eq!(crop_size(100, 400, 200, 100), (100, 50));
+        assert_eq!(crop_size(200, 100, 400, 200), (200, 100));
+    }
+
+    #[test]
+    fn test_skin_tone() {
+        assert!(is_skin([224.0, 172.0, 140.0]));
+        assert!(is_skin([141.0, 85.0, 36.0]));
+        assert!(!is_skin([40.0, 90.0, 200.0]));
+        assert!(!is_skin([128.0, 128.0, 128.0]));
+    }
+
+    #[test]
+    fn test_salient_offset_follows_face() {
+        // A skin-toned patch near the right edge of a wide gray image
+        let mut image = solid(400, 100, [90, 90, 90]);
+        paint(&mut image, 320, 370, 30, 80, [224, 172, 140]);
+
+        let (x, y) = salient_offset(&image, 100, 100);
+        assert_eq!(y, 0);
+        assert!((270..=320).contains(&x), "x = {}", x);
+    }
+
+    #[test]
+    fn test_salient_offset_vertical() {
+        // Detail at the top of a tall image
+        let mut image = solid(100, 300, [90, 90, 90]);
+        for y in (0..60).step_by(4) {
+            paint(&mut image, 0, 100, y, y + 2, [250, 250, 250]);
+        }
+
+        let (x, y) = salient_offset(&image, 100, 100);
+        assert_eq!(x, 0);
+        assert!(y < 20, "y = {}", y);
+    }
+
+    #[test]
+    fn test_salient_offset_flat_is_centered() {
+        let image = solid(400, 100, [90, 90, 90]);
+        assert_eq!(salient_offset(&image, 100, 100), (150, 0));
+    }
+
+    #[test]
+    fn test_best_window() {
+        assert_eq!(best_window(&[0.0, 0.0, 5.0, 5.0, 0.0], 2), 2);
+        assert_eq!(best_window(&[1.0; 5], 3), 1);
+        assert_eq!(best_window(&[1.0; 2], 3), 0);
+    }
+}
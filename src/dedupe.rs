@@ -0,0 +1,221 @@
+//! Detection of duplicate consecutive slides
+//!
+//! Decks exported from presentation tools often repeat a slide, once per
+//! animation step that changes nothing visible or with only compression
+//! noise between the copies. Merging such runs into one slide with the
+//! summed duration gives the same video with fewer slides to encode.
+
+use crate::image_loader::LoadedImage;
+use crate::{DedupedSlides, DuplicateMatch, Result, SlideEntry};
+
+/// Side of the luma grid compared for near-identical slides
+const GRID_SIZE: u32 = 32;
+
+/// Largest difference of a grid cell (0-255) for near-identical slides
+///
+/// Above JPEG noise, below a line of text added to the slide.
+const SIMILAR_TOLERANCE: u8 = 8;
+
+/// Fingerprint of a slide for comparison
+enum Fingerprint {
+    Exact(LoadedImage),
+    Similar {
+        width: u32,
+        height: u32,
+        grid: Vec<u8>,
+    },
+}
+
+impl Fingerprint {
+    fn new(image: LoadedImage, mode: DuplicateMatch) -> Self {
+        match mode {
+            DuplicateMatch::Exact => Fingerprint::Exact(image),
+            DuplicateMatch::Similar => Fingerprint::Similar {
+                width: image.width,
+                height: image.height,
+                grid: luma_grid(&image),
+            },
+        }
+    }
+
+    /// Check if two slides look the same
+    fn matches(&self, other: &Fingerprint) -> bool {
+        match (self, other) {
+            (Fingerprint::Exact(a), Fingerprint::Exact(b)) => {
+                a.width == b.width && a.height == b.height && a.data == b.data
+            }
+            (
+                Fingerprint::Similar {
+                    width,
+                    height,
+                    grid,
+                },
+                Fingerprint::Similar {
+                    width: other_width,
+                    height: other_height,
+                    grid: other_grid,
+                },
+            ) => {
+                width == other_width
+                    && height == other_height
+                    && grid
+                        .iter()
+                        .zip(other_grid)
+                        .all(|(a, b)| a.abs_diff(*b) <= SIMILAR_TOLERANCE)
+            }
+            _ => false,
+        }
+    }
+}
+
+/// Mean luma of each cell of a grid laid over the image
+fn luma_grid(image: &LoadedImage) -> Vec<u8> {
+    let cells = (GRID_SIZE * GRID_SIZE) as usize;
+    let mut sums = vec![0u64; cells];
+    let mut counts = vec![0u64; cells];
+    for y in 0..image.height {
+        let cy = (y * GRID_SIZE / image.height.max(1)) as usize;
+        for x in 0..image.width {
+            let cx = (x * GRID_SIZE / image.width.max(1)) as usize;
+            let i = ((y * image.width + x) * 4) as usize;
+            let p = &image.data[i..i + 4];
+            let luma = 0.2126 * p[0] as f32 + 0.7152 * p[1] as f32 + 0.0722 * p[2] as f32;
+            let cell = cy * GRID_SIZE as usize + cx;
+            sums[cell] += luma.round() as u64;
+            counts[cell] += 1;
+        }
+    }
+    sums.iter()
+        .zip(&counts)
+        .map(|(&sum, &count)| (sum / count.max(1)) as u8)
+        .collect()
+}
+
+/// Check if a slide may be merged into the slide before it
+///
+/// Slides with narration keep their own timing, and a changed caption is a
+/// visible change even when the images match.
+fn can_merge(kept: &SlideEntry, entry: &SlideEntry) -> bool {
+    kept.audio.is_none() && entry.audio.is_none() && kept.caption == entry.caption
+}
+
+/// Find duplicate consecutive slides
+///
+/// Returns, for each slide, the index of the slide it is merged into: its
+/// own index when it is kept, or the index of the first slide of its run
+/// of duplicates.
+pub fn find_duplicate_slides(entries: &[SlideEntry], mode: DuplicateMatch) -> Result<Vec<usize>> {
+    let mut merged_into = Vec::with_capacity(entries.len());
+    let mut kept: Option<(usize, Fingerprint)> = None;
+
+    for (i, entry) in entries.iter().enumerate() {
+        let fingerprint = Fingerprint::new(LoadedImage::from_path(&entry.path)?, mode);
+        match &kept {
+            Some((k, kept_fingerprint))
+                if can_merge(&entries[*k], entry) && kept_fingerprint.matches(&fingerprint) =>
+            {
+                merged_into.push(*k);
+            }
+            _ => {
+                merged_into.push(i);
+                kept = Some((i, fingerprint));
+            }
+        }
+    }
+    Ok(merged_into)
+}
+
+/// Merge duplicate consecutive slides, summing their durations
+///
+/// Each merged run is reported as a warning, so the caller can tell that
+/// the deck had repeated slides.
+pub fn dedupe_slides(entries: &[SlideEntry], mode: DuplicateMatch) -> Result<DedupedSlides> {
+    let merged_into = find_duplicate_slides(entries, mode)?;
+
+    let mut result = DedupedSlides::default();
+    let mut runs: Vec<(usize, usize)> = Vec::new();
+    for (i, (entry, &target)) in entries.iter().zip(&merged_into).enumerate() {
+        if target == i {
+            result.entries.push(entry.clone());
+            runs.push((i, i));
+        } else if let (Some(kept), Some(run)) = (result.entries.last_mut(), runs.last_mut()) {
+            kept.duration_ms = kept.duration_ms.saturating_add(entry.duration_ms);
+            run.1 = i;
+        }
+    }
+
+    for (&(first, last), entry) in runs.iter().zip(&result.entries) {
+        if last > first {
+            result.warnings.push(format!(
+                "Slides {}-{} are duplicates and were merged into one slide of {} ms",
+                first + 1,
+                last + 1,
+                entry.duration_ms
+            ));
+        }
+    }
+    Ok(result)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn solid(width: u32, height: u32, value: u8) -> LoadedImage {
+        LoadedImage {
+            width,
+            height,
+            data: [value, value, value, 255].repeat((width * height) as usize),
+        }
+    }
+
+    #[test]
+    fn test_exact_match() {
+        let a = Fingerprint::new(solid(64, 48, 100), DuplicateMatch::Exact);
+        let b = Fingerprint::new(solid(64, 48, 100), DuplicateMatch::Exact);
+        let c = Fingerprint::new(solid(64, 48, 101), DuplicateMatch::Exact);
+        let d = Fingerprint::new(solid(48, 64, 100), DuplicateMatch::Exact);
+        assert!(a.matches(&b));
+        assert!(!a.matches(&c));
+        assert!(!a.matches(&d));
+    }
+
+    #[test]
+    fn test_similar_match() {
+        let base = solid(64, 64, 100);
+        let a = Fingerprint::new(base.clone(), DuplicateMatch::Similar);
+
+        // Slight noise everywhere matches
+        let noisy = Fingerprint::new(solid(64, 64, 104), DuplicateMatch::Similar);
+        assert!(a.matches(&noisy));
+
+        // A bright block covering a grid cell does not
+        let mut changed = base;
+        for y in 0..4 {
+            for x in 0..4 {
+                let i = ((y * 64 + x) * 4) as usize;
+                changed.data[i..i + 3].copy_from_slice(&[255, 255, 255]);
+            }
+        }
+        let changed = Fingerprint::new(changed, DuplicateMatch::Similar);
+        assert!(!a.matches(&changed));
+    }
+
+    #[test]
+    fn test_can_merge() {
+        let plain = SlideEntry::default();
+        let captioned = SlideEntry {
+            caption: Some("Title".to_string()),
+            ..Default::default()
+        };
+        let narrated = SlideEntry {
+            audio: Some("voice.wav".to_string()),
+            ..Default::default()
+        };
+        assert!(can_merge(&plain, &plain));
+        assert!(can_merge(&captioned, &captioned));
+        assert!(!can_merge(&plain, &captioned));
+        assert!(!can_merge(&plain, &narrated));
+        assert!(!can_merge(&narrated, &plain));
+    }
+}
@@ -0,0 +1,179 @@
+//! Disk space preflight checks
+//!
+//! Running out of space halfway through an encode fails with a write error
+//! that says little about the cause, after most of the work is done. Before
+//! encoding, the output size is estimated from the codec, quality and frame
+//! count, and the encode fails early when the output volume has less free
+//! space. The estimate errs on the large side, since typical content
+//! compresses better than the model assumes.
+
+use crate::{Codec, Container, EncodeOptions, Error, Mp4Layout, OutputMode, Result};
+use std::path::Path;
+
+/// Estimated encoded bytes per pixel of a frame
+fn bytes_per_pixel(codec: Codec, quality: u8, lossless: bool) -> f64 {
+    match codec {
+        Codec::Png => 2.0,
+        Codec::Prores => 0.5,
+        _ if lossless => 1.5,
+        _ => {
+            // Grows steeply towards the highest qualities
+            let q = quality.min(100) as f64 / 100.0;
+            0.005 + 0.25 * q * q * q
+        }
+    }
+}
+
+/// Estimate the size in bytes of `frames` encoded frames of `pixels` pixels
+pub(crate) fn estimate_output(
+    codec: Codec,
+    quality: u8,
+    lossless: bool,
+    pixels: u64,
+    frames: u64,
+) -> u64 {
+    (bytes_per_pixel(codec, quality, lossless) * pixels as f64 * frames as f64) as u64
+}
+
+/// Peak disk space taken by writing an output of `size` bytes
+///
+/// Moving the movie box of MOV and MP4 files to the front copies the file
+/// next to the output, so until the copy replaces it both are on disk.
+pub(crate) fn peak_space(options: &EncodeOptions, size: u64) -> u64 {
+    let copied = options.output_mode == OutputMode::Single
+        && match options.container {
+            Container::Mov => true,
+            Container::Mp4 => {
+                options.mp4_layout == Mp4Layout::Faststart
+                    || (options.codec == Codec::Hevc && options.mp4_layout != Mp4Layout::Fragmented)
+            }
+            _ => false,
+        };
+    if copied {
+        size.saturating_mul(2)
+    } else {
+        size
+    }
+}
+
+/// Check that the volume of `output_path` has at least `required` bytes free
+///
+/// Passes when the free space cannot be determined.
+pub(crate) fn check_space(output_path: &Path, required: u64) -> Result<()> {
+    let dir = match output_path.parent() {
+        Some(dir) if !dir.as_os_str().is_empty() => dir,
+        _ => Path::new("."),
+    };
+
+    match available_space(dir) {
+        Some(available) if available < required => Err(Error::InsufficientSpace {
+            required,
+            available,
+        }),
+        _ => Ok(()),
+    }
+}
+
+/// Free space in bytes available to the current user on the volume of `dir`
+#[cfg(unix)]
+#[allow(clippy::unnecessary_cast)] // The statvfs field types vary by platform
+fn available_space(dir: &Path) -> Option<u64> {
+    use std::ffi::CString;
+    use std::os::unix::ffi::OsStrExt;
+
+    let path = CString::new(dir.as_os_str().as_bytes()).ok()?;
+    let mut stat: libc::statvfs = unsafe { std::mem::zeroed() };
+    // SAFETY: `path` is a valid C string and `stat` is a writable statvfs
+    if unsafe { libc::statvfs(path.as_ptr(), &mut stat) } != 0 {
+        return None;
+    }
+    Some(stat.f_bavail as u64 * stat.f_frsize as u64)
+}
+
+/// Free space in bytes available to the current user on the volume of `dir`
+#[cfg(windows)]
+fn available_space(dir: &Path) -> Option<u64> {
+    use std::os::windows::ffi::OsStrExt;
+    use windows::core::PCWSTR;
+    use windows::Win32::Storage::FileSystem::GetDiskFreeSpaceExW;
+
+    let path: Vec<u16> = dir.as_os_str().encode_wide().chain(Some(0)).collect();
+    let mut available = 0u64;
+    // SAFETY: `path` is NUL-terminated and outlives the call
+    unsafe {
+        GetDiskFreeSpaceExW(
+            PCWSTR(path.as_ptr()),
+            Some(&mut available as *mut u64),
+            None,
+            None,
+        )
+    }
+    .ok()?;
+    Some(available)
+}
+
+/// Free space is not checked on other platforms
+#[cfg(not(any(unix, windows)))]
+fn available_space(_dir: &Path) -> Option<u64> {
+    None
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_estimate_output() {
+        // Higher quality and lossless outputs are estimated larger
+        let low = estimate_output(Codec::Av1, 20, false, 1920 * 1080, 300);
+        let high = estimate_output(Codec::Av1, 90, false, 1920 * 1080, 300);
+        let lossless = estimate_output(Codec::Av1, 90, true, 1920 * 1080, 300);
+        assert!(low < high);
+        assert!(high < lossless);
+
+        // PNG ignores the quality
+        assert_eq!(
+            estimate_output(Codec::Png, 0, false, 100, 10),
+            estimate_output(Codec::Png, 100, false, 100, 10)
+        );
+        assert_eq!(estimate_output(Codec::H264, 50, false, 100, 0), 0);
+    }
+
+    #[test]
+    fn test_peak_space() {
+        let mut options = EncodeOptions {
+            container: Container::Mp4,
+            codec: Codec::H264,
+            ..Default::default()
+        };
+        assert_eq!(peak_space(&options, 100), 200);
+        options.mp4_layout = Mp4Layout::MoovAtEnd;
+        assert_eq!(peak_space(&options, 100), 100);
+
+        // The HEVC muxer always moves the movie box
+        options.codec = Codec::Hevc;
+        assert_eq!(peak_space(&options, 100), 200);
+        options.mp4_layout = Mp4Layout::Fragmented;
+        assert_eq!(peak_space(&options, 100), 100);
+
+        options.container = Container::Mov;
+        assert_eq!(peak_space(&options, 100), 200);
+        options.output_mode = OutputMode::Segments;
+        assert_eq!(peak_space(&options, 100), 100);
+        options.container = Container::WebM;
+        options.output_mode = OutputMode::Single;
+        assert_eq!(peak_space(&options, 100), 100);
+    }
+
+    #[test]
+    fn test_check_space() {
+        let dir = std::env::temp_dir();
+        assert!(check_space(&dir.join("out.webm"), 0).is_ok());
+        if available_space(&dir).is_some() {
+            let err = check_space(&dir.join("out.webm"), u64::MAX).unwrap_err();
+            assert!(matches!(err, Error::InsufficientSpace { .. }));
+        }
+        // Relative paths are checked in the current directory
+        assert!(check_space(Path::new("out.webm"), 0).is_ok());
+    }
+}
@@ -1,49 +1,93 @@
 //! AV1 encoder using rav1e
 
-use super::{Encoder, EncoderConfig, Frame, Packet};
-use crate::{Error, Result};
+use super::{Encoder, EncoderConfig, Frame, Packet, Speed};
+use crate::colorspace::ColorSpace;
+use crate::hdr::{self, Hdr10};
+use crate::{BitDepth, Error, PixelFormat, Result};
 use rav1e::prelude::*;
 
-/// AV1 encoder using rav1e
-pub struct Av1Encoder {
-    context: Context<u8>,
-    #[allow(dead_code)]
+/// AV1 encoder using rav1e, with `u8` samples for 8-bit output and `u16`
+/// for 10-bit
+pub struct Av1Encoder<T: Pixel> {
+    context: Context<T>,
     config: EncoderConfig,
     frame_count: u64,
 }
 
-impl Av1Encoder {
+impl<T: Pixel> Av1Encoder<T> {
     /// Create a new AV1 encoder
     pub fn new(config: EncoderConfig) -> Result<Self> {
         // Map quality (0-100) to quantizer (255-0)
-        // Higher quality = lower quantizer
-        let quantizer = ((100 - config.quality.min(100)) as usize * 255) / 100;
+        // Higher quality = lower quantizer; quantizer 0 codes losslessly
+        let quantizer = if config.lossless {
+            0
+        } else {
+            ((100 - config.quality.min(100)) as usize * 255) / 100
+        };
         let min_quantizer = (quantizer.saturating_sub(10)) as u8;
 
+        // Lossless output keeps full chroma resolution unless another is set
+        let chroma_sampling = match config.chroma() {
+            PixelFormat::Yuv444 => ChromaSampling::Cs444,
+            PixelFormat::Yuv422 => ChromaSampling::Cs422,
+            _ => ChromaSampling::Cs420,
+        };
+
+        // rav1e has no peak limit, so a peak without an average is used as
+        // the average, with the buffer as its rate control reservoir
+        let bitrate = &config.bitrate;
+        let rate_kbps = if bitrate.target_kbps > 0 {
+            bitrate.target_kbps
+        } else {
+            bitrate.max_kbps
+        };
+        let reservoir_frame_delay = (bitrate.max_kbps > 0).then(|| {
+            let frames = bitrate.buffer_size() as u64 * config.fps as u64 / bitrate.max_kbps as u64;
+            frames.clamp(12, 131_072) as i32
+        });
+
+        let preset = match (config.av1_preset, config.speed) {
+            (0, Speed::Default) => 6, // Balance speed/quality
+            (0, Speed::Fast) => 8,
+            (0, Speed::Faster) => 9,
+            (0, Speed::Fastest) => 10,
+            (preset, _) => preset,
+        };
+
         let enc_config = rav1e::config::EncoderConfig {
             width: config.width as usize,
             height: config.height as usize,
-            speed_settings: SpeedSettings::from_preset(6), // Balance speed/quality
+            speed_settings: SpeedSettings::from_preset(preset),
             time_base: Rational::new(1, config.fps as u64),
             sample_aspect_ratio: Rational::new(1, 1),
-            bit_depth: 8,
-            chroma_sampling: ChromaSampling::Cs420,
+            bit_depth: bit_depth(config.bit_depth),
+            chroma_sampling,
             chroma_sample_position: ChromaSamplePosition::Unknown,
-            pixel_range: PixelRange::Limited,
-            color_description: None,
-            mastering_display: None,
-            content_light: None,
+            pixel_range: if config.color.full_range {
+                PixelRange::Full
+            } else {
+                PixelRange::Limited
+            },
+            color_description: Some(color_description(&config.color)),
+            mastering_display: config.hdr.as_ref().map(mastering_display),
+            content_light: config.hdr.map(|hdr| ContentLight {
+                max_content_light_level: hdr.max_cll,
+                max_frame_average_light_level: hdr.max_fall,
+            }),
             enable_timing_info: false,
             still_picture: false,
             error_resilient: false,
             switch_frame_interval: 0,
-            min_key_frame_interval: 0,
-            max_key_frame_interval: 240,
-            reservoir_frame_delay: None,
+            min_key_frame_interval: config.keyframe_interval as u64,
+            max_key_frame_interval: match config.keyframe_interval {
+                0 => 240,
+                interval => interval as u64,
+            },
+            reservoir_frame_delay,
             low_latency: false,
             quantizer,
             min_quantizer,
-            bitrate: 0,
+            bitrate: (rate_kbps as i64 * 1000).min(i32::MAX as i64) as i32,
             tune: Tune::Psychovisual,
             tile_cols: 0,
             tile_rows: 0,
@@ -53,7 +97,7 @@ impl Av1Encoder {
 
         let rav1e_config = Config::new()
             .with_encoder_config(enc_config)
-            .with_threads(0);
+            .with_threads(config.threads as usize);
 
         let context = rav1e_config
             .new_context()
@@ -66,67 +110,71 @@ impl Av1Encoder {
         })
     }
 
-    /// Convert RGBA frame to YUV420
-    fn rgba_to_yuv420(&self, frame: &Frame) -> rav1e::Frame<u8> {
+    /// Convert an RGBA frame to YUV at the configured chroma resolution and
+    /// bit depth, with the matrix and range of the color space
+    fn to_yuv(&self, frame: &Frame) -> rav1e::Frame<T> {
         let mut yuv_frame = self.context.new_frame();
 
         let width = frame.width as usize;
         let height = frame.height as usize;
+        // Chroma samples cover 2x2 pixels in 4:2:0 and 2x1 in 4:2:2
+        let (xdec, ydec) = match self.config.chroma() {
+            PixelFormat::Yuv444 => (0, 0),
+            PixelFormat::Yuv422 => (1, 0),
+            _ => (1, 1),
+        };
+        let chroma_width = (width + xdec) >> xdec;
+        let chroma_height = (height + ydec) >> ydec;
 
-        // Y plane
-        for y in 0..height {
-            for x in 0..width {
-                let idx = (y * width + x) * 4;
-                let r = frame.data[idx] as f32;
-                let g = frame.data[idx + 1] as f32;
-                let b = frame.data[idx + 2] as f32;
-
-                // BT.601 conversion
-                let y_val = (0.299 * r + 0.587 * g + 0.114 * b).clamp(0.0, 255.0) as u8;
-                yuv_frame.planes[0].data_origin_mut()[y * width + x] = y_val;
-            }
-        }
-
-        // U and V planes (subsampled 2x2)
-        let uv_width = width.div_ceil(2);
-        let uv_height = height.div_ceil(2);
+        // Samples are written as little-endian bytes of the pixel type,
+        // scaled up from the 8-bit range for 10-bit output
+        let bytewidth = std::mem::size_of::<T>();
+        let bit_depth = bit_depth(self.config.bit_depth);
+        let scale = (1 << (bit_depth - 8)) as f32;
+        let max = ((1 << bit_depth) - 1) as f32;
+        let push = |plane: &mut Vec<u8>, value: f32| {
+            let sample = (value * scale).clamp(0.0, max) as u16;
+            plane.extend_from_slice(&sample.to_le_bytes()[..bytewidth]);
+        };
+        let color = self.config.color;
 
-        for y in 0..uv_height {
-            for x in 0..uv_width {
-                let src_x = x * 2;
-                let src_y = y * 2;
+        let mut planes = [
+            Vec::with_capacity(width * height * bytewidth),
+            Vec::with_capacity(chroma_width * chroma_height * bytewidth),
+            Vec::with_capacity(chroma_width * chroma_height * bytewidth),
+        ];
+        for pixel in frame.data.chunks_exact(4) {
+            let rgb = [pixel[0] as f32, pixel[1] as f32, pixel[2] as f32];
+            push(&mut planes[0], color.luma(rgb));
+        }
 
-                // Average 2x2 block
-                let mut r_sum = 0u32;
-                let mut g_sum = 0u32;
-                let mut b_sum = 0u32;
+        for y in 0..chroma_height {
+            for x in 0..chroma_width {
+                // Average the pixels the sample covers
+                let mut sum = [0u32; 3];
                 let mut count = 0u32;
-
-                for dy in 0..2 {
-                    for dx in 0..2 {
-                        let sx = (src_x + dx).min(width - 1);
-                        let sy = (src_y + dy).min(height - 1);
+                for sy in (y << ydec)..((y + 1) << ydec).min(height) {
+                    for sx in (x << xdec)..((x + 1) << xdec).min(width) {
                         let idx = (sy * width + sx) * 4;
-                        r_sum += frame.data[idx] as u32;
-                        g_sum += frame.data[idx + 1] as u32;
-                        b_sum += frame.data[idx + 2] as u32;
+                        for (total, &value) in sum.iter_mut().zip(&frame.data[idx..idx + 3]) {
+                            *total += value as u32;
+                        }
                         count += 1;
                     }
                 }
+                let rgb = sum.map(|total| (total / count) as f32);
 
-                let r = (r_sum / count) as f32;
-                let g = (g_sum / count) as f32;
-                let b = (b_sum / count) as f32;
-
-                // BT.601 conversion
-                let u = ((-0.169 * r - 0.331 * g + 0.500 * b) + 128.0).clamp(0.0, 255.0) as u8;
-                let v = ((0.500 * r - 0.419 * g - 0.081 * b) + 128.0).clamp(0.0, 255.0) as u8;
-
-                yuv_frame.planes[1].data_origin_mut()[y * uv_width + x] = u;
-                yuv_frame.planes[2].data_origin_mut()[y * uv_width + x] = v;
+                let [cb, cr] = color.chroma(rgb);
+                push(&mut planes[1], cb);
+                push(&mut planes[2], cr);
             }
         }
 
+        let strides = [width, chroma_width, chroma_width];
+        for ((plane, data), stride) in yuv_frame.planes.iter_mut().zip(&planes).zip(strides) {
+            plane.copy_from_raw_u8(data, stride * bytewidth, bytewidth);
+        }
+
         yuv_frame
     }
 
@@ -141,6 +189,8 @@ impl Av1Encoder {
                         pts: pkt.input_frameno as i64,
                         dts: pkt.input_frameno as i64,
                         is_keyframe: pkt.frame_type == FrameType::KEY,
+                        duration: 1,
+                        alpha: None,
                     });
                 }
                 Err(EncoderStatus::Encoded) => continue,
@@ -156,12 +206,24 @@ impl Av1Encoder {
     }
 }
 
-impl Encoder for Av1Encoder {
+impl<T: Pixel> Encoder for Av1Encoder<T> {
+    fn name(&self) -> &str {
+        "rav1e"
+    }
+
     fn encode(&mut self, frame: &Frame) -> Result<Vec<Packet>> {
-        let yuv_frame = self.rgba_to_yuv420(frame);
+        let yuv_frame = self.to_yuv(frame);
 
+        // Forced keyframes are requested per frame
+        let params = self
+            .config
+            .forces_keyframe(self.frame_count)
+            .then(|| FrameParameters {
+                frame_type_override: FrameTypeOverride::Key,
+                ..Default::default()
+            });
         self.context
-            .send_frame(yuv_frame)
+            .send_frame((yuv_frame, params))
             .map_err(|e| Error::Encode(format!("Failed to send frame: {}", e)))?;
 
         self.frame_count += 1;
@@ -181,6 +243,8 @@ impl Encoder for Av1Encoder {
                         pts: pkt.input_frameno as i64,
                         dts: pkt.input_frameno as i64,
                         is_keyframe: pkt.frame_type == FrameType::KEY,
+                        duration: 1,
+                        alpha: None,
                     });
                 }
                 Err(EncoderStatus::Encoded) => continue,
@@ -193,3 +257,48 @@ impl Encoder for Av1Encoder {
         Ok(packets)
     }
 }
+
+/// AV1 color description of a color space
+fn color_description(color: &ColorSpace) -> ColorDescription {
+    ColorDescription {
+        color_primaries: match color.primaries {
+            6 => ColorPrimaries::BT601,
+            9 => ColorPrimaries::BT2020,
+            12 => ColorPrimaries::SMPTE432,
+            _ => ColorPrimaries::BT709,
+        },
+        transfer_characteristics: match color.transfer {
+            13 => TransferCharacteristics::SRGB,
+            16 => TransferCharacteristics::SMPTE2084,
+            _ => TransferCharacteristics::BT709,
+        },
+        matrix_coefficients: match color.matrix {
+            6 => MatrixCoefficients::BT601,
+            9 => MatrixCoefficients::BT2020NCL,
+            _ => MatrixCoefficients::BT709,
+        },
+    }
+}
+
+/// AV1 mastering display metadata: chromaticities in 0.16, the peak in 24.8
+/// and the black level in 18.14 fixed point
+fn mastering_display(hdr: &Hdr10) -> MasteringDisplay {
+    let point = |(x, y): (f64, f64)| ChromaticityPoint {
+        x: (x * 65536.0).round() as u16,
+        y: (y * 65536.0).round() as u16,
+    };
+    MasteringDisplay {
+        primaries: hdr::PRIMARIES.map(point),
+        white_point: point(hdr::WHITE_POINT),
+        max_luminance: hdr.max_luminance * 256,
+        min_luminance: (hdr::MIN_LUMINANCE * 16384.0).round() as u32,
+    }
+}
+
+/// Bits per sample of a bit depth
+fn bit_depth(depth: BitDepth) -> usize {
+    match depth {
+        BitDepth::Eight => 8,
+        BitDepth::Ten => 10,
+    }
+}
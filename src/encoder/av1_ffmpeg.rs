@@ -0,0 +1,207 @@
+//! AV1 encoder using ffmpeg external process
+//!
+//! SVT-AV1 (libsvtav1), libaom (libaom-av1) and the hardware AV1 encoders of
+//! NVENC, Quick Sync and AMF run in ffmpeg, which writes an IVF stream of
+//! temporal units, each showing one frame. The sequence header is repeated
+//! on keyframes, where the muxers find it as they do in rav1e's packets.
+
+use super::hevc::{pixel_format, select_encoder};
+use super::vpx::IvfProcess;
+use super::{hardware_encoder, is_hardware_encoder, Encoder, EncoderConfig, Frame, Packet, Speed};
+use crate::ffmpeg::find_ffmpeg;
+use crate::muxer::obu::{self, OBU_FRAME, OBU_FRAME_HEADER};
+use crate::{Av1Backend, Codec, Error, Result};
+
+/// FFmpeg-based AV1 encoder
+pub struct FfmpegAv1Encoder {
+    encoder: &'static str,
+    process: IvfProcess,
+}
+
+impl FfmpegAv1Encoder {
+    pub fn new(config: EncoderConfig) -> Result<Self> {
+        let ffmpeg = find_ffmpeg(config.ffmpeg_path.as_deref())?;
+        let output_format = config.ffmpeg_pixel_format();
+        let (encoder, input_format) = match hardware_encoder(Codec::Av1, config.hw_accel) {
+            Some(name) => (
+                select_encoder(&ffmpeg, &output_format, &[name], None)?,
+                pixel_format(name, &output_format),
+            ),
+            None if config.hw_accel.is_hardware() => {
+                return Err(Error::CodecUnavailable(format!(
+                    "AV1 has no {:?} encoder",
+                    config.hw_accel
+                )))
+            }
+            None => (
+                select_encoder(
+                    &ffmpeg,
+                    &output_format,
+                    &[],
+                    Some(software_encoder(config.av1_backend)),
+                )?,
+                output_format.as_str(),
+            ),
+        };
+
+        let mut args = vec!["-c:v".to_string(), encoder.to_string()];
+        args.extend(preset_args(encoder, &config));
+        args.extend(rate_args(encoder, &config));
+        args.extend(config.keyframe_args());
+        args.extend(config.color.ffmpeg_args());
+        args.extend(config.ffmpeg_args.iter().cloned());
+        let process = IvfProcess::spawn(
+            &ffmpeg,
+            &config,
+            "rgba",
+            &args.iter().map(String::as_str).collect::<Vec<_>>(),
+            input_format,
+            "AV1",
+            is_keyframe,
+        )?;
+
+        Ok(Self { encoder, process })
+    }
+}
+
+impl Encoder for FfmpegAv1Encoder {
+    fn name(&self) -> &str {
+        self.encoder
+    }
+
+    fn hardware(&self) -> bool {
+        is_hardware_encoder(self.encoder)
+    }
+
+    fn encode(&mut self, frame: &Frame) -> Result<Vec<Packet>> {
+        self.process.write(&frame.data)
+    }
+
+    fn flush(&mut self) -> Result<Vec<Packet>> {
+        self.process.finish("AV1")
+    }
+}
+
+/// ffmpeg name of the encoder of a software backend
+fn software_encoder(backend: Av1Backend) -> &'static str {
+    match backend {
+        // rav1e is built in, so this is only used without it
+        Av1Backend::Rav1e => "librav1e",
+        Av1Backend::SvtAv1 => "libsvtav1",
+        Av1Backend::Aom => "libaom-av1",
+    }
+}
+
+/// Speed preset arguments of the software encoders, from the configured
+/// preset or else the speed
+fn preset_args(encoder: &str, config: &EncoderConfig) -> Vec<String> {
+    let preset = config.av1_preset;
+    match encoder {
+        "libsvtav1" => {
+            let preset = match (preset, config.speed) {
+                (0, Speed::Default) => 8,
+                (0, Speed::Fast) => 10,
+                (0, Speed::Faster) => 11,
+                (0, Speed::Fastest) => 12,
+                (preset, _) => preset,
+            };
+            vec!["-preset".to_string(), preset.to_string()]
+        }
+        "libaom-av1" => {
+            let cpu_used = match (preset, config.speed) {
+                (0, Speed::Default) => 4,
+                (0, Speed::Fast) => 6,
+                (0, Speed::Faster) => 7,
+                (0, Speed::Fastest) => 8,
+                (preset, _) => preset,
+            };
+            // The good quality usage stops at cpu-used 6
+            let usage = if cpu_used > 6 { "realtime" } else { "good" };
+            [
+                "-usage",
+                usage,
+                "-cpu-used",
+                &cpu_used.to_string(),
+                "-row-mt",
+                "1",
+            ]
+            .map(String::from)
+            .to_vec()
+        }
+        _ => Vec::new(),
+    }
+}
+
+/// Rate control arguments mapping quality (0-100) to each encoder's scale,
+/// or setting the average bitrate, with the peak bitrate limit
+fn rate_args(encoder: &str, config: &EncoderConfig) -> Vec<String> {
+    if config.lossless {
+        return ["-aom-params", "lossless=1"].map(String::from).to_vec();
+    }
+    let bitrate = &config.bitrate;
+    let quality = config.quality.min(100) as u32;
+    let target = format!("{}k", bitrate.target_kbps);
+    // CRF scale of 63-0, QP scales of 51-0 and 255-0
+    let crf = ((100 - quality) * 63 / 100).to_string();
+    let qp = ((100 - quality) * 51 / 100).max(1).to_string();
+    let amf_qp = ((100 - quality) * 255 / 100).to_string();
+
+    let args: &[&str] = match (encoder, bitrate.target_kbps > 0) {
+        ("av1_nvenc", true) => &["-rc", "vbr", "-b:v", &target],
+        ("av1_amf", true) => &["-rc", "vbr_latency", "-b:v", &target],
+        (_, true) => &["-b:v", &target],
+        ("libaom-av1", false) => &["-crf", &crf, "-b:v", "0"],
+        ("av1_nvenc", false) => &["-rc", "vbr", "-cq", &qp, "-b:v", "0"],
+        ("av1_qsv", false) => &["-global_quality", &qp],
+        ("av1_amf", false) => &["-rc", "cqp", "-qp_i", &amf_qp, "-qp_p", &amf_qp],
+        // SVT-AV1 takes CRF 1-63
+        (_, false) => &["-crf", if crf == "0" { "1" } else { &crf }],
+    };
+    let mut args: Vec<String> = args.iter().map(|s| s.to_string()).collect();
+    args.extend(bitrate.limit_args());
+    args
+}
+
+/// Check if a temporal unit holds a keyframe: whether its first frame
+/// header is neither a shown existing frame nor of another frame type
+fn is_keyframe(data: &[u8]) -> bool {
+    let Some(obus) = obu::parse_obus(data) else {
+        return false;
+    };
+    obus.iter()
+        .find(|o| matches!(o.kind, OBU_FRAME_HEADER | OBU_FRAME))
+        // show_existing_frame (1), frame_type (2) with KEY_FRAME 0
+        .and_then(|o| o.payload.first())
+        .is_some_and(|byte| byte & 0xE0 == 0)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// OBU with a size field
+    fn obu(kind: u8, payload: &[u8]) -> Vec<u8> {
+        let mut data = vec![(kind << 3) | 0x02, payload.len() as u8];
+        data.extend_from_slice(payload);
+        data
+    }
+
+    #[test]
+    fn test_is_keyframe() {
+        let delimiter = obu(2, &[]);
+        let sequence = obu(1, &[0, 0, 0]);
+
+        // Temporal delimiter, sequence header, then a key frame
+        let key = [delimiter.clone(), sequence, obu(OBU_FRAME, &[0x10, 0xFF])].concat();
+        assert!(is_keyframe(&key));
+        // Inter frame (frame_type 1)
+        let inter = [delimiter.clone(), obu(OBU_FRAME, &[0x20, 0xFF])].concat();
+        assert!(!is_keyframe(&inter));
+        // Shown existing frame
+        let existing = [delimiter, obu(OBU_FRAME_HEADER, &[0x80])].concat();
+        assert!(!is_keyframe(&existing));
+
+        assert!(!is_keyframe(&[]));
+        assert!(!is_keyframe(&[0x32, 0x80]));
+    }
+}
@@ -1,13 +1,21 @@
 //! Linux H.264 encoder using ffmpeg external process
-
-use super::super::{Encoder, EncoderConfig, Frame, Packet};
-use crate::{Error, Result};
+//!
+//! libx264 is used unless a hardware encoder is requested.
+
+use super::super::hevc::{
+    device_args, format_args, pixel_format, rate_args, select_encoder, x26x_preset,
+};
+use super::super::{hardware_encoder, is_hardware_encoder, Encoder, EncoderConfig, Frame, Packet};
+use crate::ffmpeg::ProcessLog;
+use crate::{Codec, Error, H264Preset, H264Profile, H264Tune, Result};
 use std::io::Write;
 use std::process::{Child, Command, Stdio};
 
 /// FFmpeg-based H.264 encoder for Linux
 pub struct FfmpegEncoder {
+    encoder: &'static str,
     process: Child,
+    log: ProcessLog,
     #[allow(dead_code)]
     config: EncoderConfig,
     frame_count: u64,
@@ -18,42 +26,75 @@ pub struct FfmpegEncoder {
 impl FfmpegEncoder {
     pub fn new(config: EncoderConfig, ffmpeg_path: Option<&str>) -> Result<Self> {
         let ffmpeg = find_ffmpeg(ffmpeg_path)?;
-
-        // Map quality (0-100) to CRF (51-0)
-        let crf = ((100 - config.quality.min(100)) as u32 * 51) / 100;
-
-        let process = Command::new(&ffmpeg)
-            .args([
-                "-f",
-                "rawvideo",
-                "-pix_fmt",
-                "rgba",
-                "-s",
-                &format!("{}x{}", config.width, config.height),
-                "-r",
-                &config.fps.to_string(),
-                "-i",
-                "pipe:0",
-                "-c:v",
-                "libx264",
-                "-preset",
-                "medium",
-                "-crf",
-                &crf.to_string(),
-                "-pix_fmt",
-                "yuv420p",
-                "-f",
-                "h264",
-                "pipe:1",
-            ])
-            .stdin(Stdio::piped())
-            .stdout(Stdio::piped())
-            .stderr(Stdio::null())
-            .spawn()
-            .map_err(|e| Error::Ffmpeg(format!("Failed to start ffmpeg: {}", e)))?;
+        let output_format = config.ffmpeg_pixel_format();
+        let encoder = match hardware_encoder(Codec::H264, config.hw_accel) {
+            Some(name) => select_encoder(&ffmpeg, &output_format, &[name], None)?,
+            None => "libx264",
+        };
+
+        // Map quality (0-100) to CRF (51-0); lossless uses QP 0 with full
+        // chroma resolution unless another is set. x264 picks the High
+        // 4:2:2, High 4:4:4 or High 10 profile the pixel format needs.
+        let rate_args = if encoder == "libx264" {
+            let crf = ((100 - config.quality.min(100)) as u32 * 51) / 100;
+            let (rate_control, rate_value) = if config.lossless {
+                ("-qp", "0".to_string())
+            } else if config.bitrate.target_kbps > 0 {
+                ("-b:v", format!("{}k", config.bitrate.target_kbps))
+            } else {
+                ("-crf", crf.to_string())
+            };
+            let mut args = vec![
+                "-preset".to_string(),
+                x264_preset(&config).to_string(),
+                rate_control.to_string(),
+                rate_value,
+            ];
+            if let Some(tune) = x264_tune(config.h264_tune) {
+                args.extend(["-tune".to_string(), tune.to_string()]);
+            }
+            args.extend(config.bitrate.limit_args());
+            args
+        } else {
+            rate_args(encoder, &config)
+        };
+
+        let (process, log) = ProcessLog::spawn(
+            Command::new(&ffmpeg)
+                .args(device_args(encoder))
+                .args([
+                    "-f",
+                    "rawvideo",
+                    "-pix_fmt",
+                    "rgba",
+                    "-s",
+                    &format!("{}x{}", config.width, config.height),
+                    "-r",
+                    &config.fps.to_string(),
+                    "-i",
+                    "pipe:0",
+                    "-c:v",
+                    encoder,
+                ])
+                .args(rate_args)
+                .args(profile_args(encoder, &config))
+                .args(config.keyframe_args())
+                .args(config.thread_args())
+                .args(format_args(
+                    encoder,
+                    config.color.ffmpeg_args(),
+                    pixel_format(encoder, &output_format),
+                ))
+                .args(&config.ffmpeg_args)
+                .args(["-f", "h264", "pipe:1"])
+                .stdin(Stdio::piped())
+                .stdout(Stdio::piped()),
+        )?;
 
         Ok(Self {
+            encoder,
             process,
+            log,
             config,
             frame_count: 0,
             output_buffer: Vec::new(),
@@ -87,7 +128,62 @@ impl FfmpegEncoder {
     }
 }
 
+/// libx264 preset, the configured one or else the one of the speed
+fn x264_preset(config: &EncoderConfig) -> &'static str {
+    match config.h264_preset {
+        H264Preset::Auto => x26x_preset(config.speed),
+        H264Preset::Ultrafast => "ultrafast",
+        H264Preset::Superfast => "superfast",
+        H264Preset::Veryfast => "veryfast",
+        H264Preset::Faster => "faster",
+        H264Preset::Fast => "fast",
+        H264Preset::Medium => "medium",
+        H264Preset::Slow => "slow",
+        H264Preset::Slower => "slower",
+        H264Preset::Veryslow => "veryslow",
+    }
+}
+
+/// libx264 tune, if any
+fn x264_tune(tune: H264Tune) -> Option<&'static str> {
+    match tune {
+        H264Tune::None => None,
+        H264Tune::StillImage => Some("stillimage"),
+        H264Tune::Animation => Some("animation"),
+    }
+}
+
+/// Profile and level arguments; VA-API and AMF name the baseline profile
+/// by its constrained form
+fn profile_args(encoder: &str, config: &EncoderConfig) -> Vec<String> {
+    let mut args = Vec::new();
+    let constrained = encoder.ends_with("_vaapi") || encoder.ends_with("_amf");
+    let profile = match config.h264_profile {
+        H264Profile::Auto => None,
+        H264Profile::Baseline if constrained => Some("constrained_baseline"),
+        H264Profile::Baseline => Some("baseline"),
+        H264Profile::Main => Some("main"),
+        H264Profile::High => Some("high"),
+    };
+    if let Some(profile) = profile {
+        args.extend(["-profile:v".to_string(), profile.to_string()]);
+    }
+    if config.h264_level != 0 {
+        let level = format!("{}.{}", config.h264_level / 10, config.h264_level % 10);
+        args.extend(["-level".to_string(), level]);
+    }
+    args
+}
+
 impl Encoder for FfmpegEncoder {
+    fn name(&self) -> &str {
+        self.encoder
+    }
+
+    fn hardware(&self) -> bool {
+        is_hardware_encoder(self.encoder)
+    }
+
     fn encode(&mut self, frame: &Frame) -> Result<Vec<Packet>> {
         let stdin = self
             .process
@@ -95,10 +191,11 @@ impl Encoder for FfmpegEncoder {
             .as_mut()
             .ok_or_else(|| Error::Ffmpeg("FFmpeg stdin not available".to_string()))?;
 
-        // Write raw RGBA frame data
-        stdin
-            .write_all(&frame.data)
-            .map_err(|e| Error::Ffmpeg(format!("Failed to write frame: {}", e)))?;
+        // Write raw RGBA frame data; ffmpeg closes its input when it fails
+        if let Err(e) = stdin.write_all(&frame.data) {
+            let error = Error::Ffmpeg(format!("Failed to write frame: {}", e));
+            return Err(self.log.error(&mut self.process, error));
+        }
 
         self.frame_count += 1;
 
@@ -129,9 +226,14 @@ impl Encoder for FfmpegEncoder {
         }
 
         // Wait for process to exit
-        self.process
+        let status = self
+            .process
             .wait()
             .map_err(|e| Error::Ffmpeg(format!("FFmpeg process error: {}", e)))?;
+        if !status.success() {
+            let error = Error::Ffmpeg(format!("FFmpeg H.264 encoding failed with {}", status));
+            return Err(self.log.error(&mut self.process, error));
+        }
 
         // Parse remaining packets
         let packets = parse_h264_packets(&output, self.frame_count);
@@ -179,6 +281,8 @@ fn parse_h264_packets(data: &[u8], base_pts: u64) -> Vec<Packet> {
                 pts,
                 dts: pts,
                 is_keyframe,
+                duration: 1,
+                alpha: None,
             });
 
             pts += 1;
@@ -210,33 +314,13 @@ fn find_start_code(data: &[u8], start: usize) -> Option<(usize, usize)> {
     None
 }
 
-/// Find ffmpeg executable
+/// Find ffmpeg executable, as for other codecs
 fn find_ffmpeg(custom_path: Option<&str>) -> Result<String> {
-    if let Some(path) = custom_path {
-        if std::path::Path::new(path).exists() {
-            return Ok(path.to_string());
-        }
-        return Err(Error::Ffmpeg(format!("FFmpeg not found at: {}", path)));
-    }
-
-    // Try to find ffmpeg in PATH
-    let paths = ["ffmpeg", "/usr/bin/ffmpeg", "/usr/local/bin/ffmpeg"];
-
-    for path in paths {
-        if Command::new(path)
-            .arg("-version")
-            .stdout(Stdio::null())
-            .stderr(Stdio::null())
-            .status()
-            .is_ok()
-        {
-            return Ok(path.to_string());
-        }
-    }
-
-    Err(Error::CodecUnavailable(
-        "FFmpeg not found in PATH".to_string(),
-    ))
+    crate::ffmpeg::find_ffmpeg(custom_path).map_err(|e| match (custom_path, e) {
+        // Without ffmpeg, H.264 has no encoder
+        (None, Error::Ffmpeg(message)) => Error::CodecUnavailable(message),
+        (_, e) => e,
+    })
 }
 
 /// Check if ffmpeg with H.264 support is available
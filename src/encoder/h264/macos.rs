@@ -1,8 +1,8 @@
 //! macOS H.264 encoder using VideoToolbox
 
 use super::super::{Encoder, EncoderConfig, Frame, Packet};
-use crate::{Error, Result};
-use std::ffi::c_void;
+use crate::{Error, H264Profile, HwAccel, Result};
+use std::ffi::{c_void, CString};
 use std::ptr;
 use std::sync::{Arc, Mutex};
 
@@ -42,6 +42,9 @@ extern "C" {
     fn VTCompressionSessionInvalidate(session: *mut c_void);
 
     fn VTSessionSetProperty(session: *mut c_void, key: *const c_void, value: *const c_void) -> i32;
+
+    static kVTVideoEncoderSpecification_EnableHardwareAcceleratedVideoEncoder: *const c_void;
+    static kVTVideoEncoderSpecification_RequireHardwareAcceleratedVideoEncoder: *const c_void;
 }
 
 #[link(name = "CoreMedia", kind = "framework")]
@@ -92,6 +95,16 @@ extern "C" {
     fn CVPixelBufferGetBaseAddress(pixel_buffer: *mut c_void) -> *mut u8;
     fn CVPixelBufferGetBytesPerRow(pixel_buffer: *mut c_void) -> usize;
     fn CVPixelBufferRelease(pixel_buffer: *mut c_void);
+
+    static kCVImageBufferColorPrimaries_ITU_R_709_2: *const c_void;
+    static kCVImageBufferColorPrimaries_SMPTE_C: *const c_void;
+    static kCVImageBufferColorPrimaries_ITU_R_2020: *const c_void;
+    static kCVImageBufferColorPrimaries_P3_D65: *const c_void;
+    static kCVImageBufferTransferFunction_ITU_R_709_2: *const c_void;
+    static kCVImageBufferTransferFunction_sRGB: *const c_void;
+    static kCVImageBufferYCbCrMatrix_ITU_R_709_2: *const c_void;
+    static kCVImageBufferYCbCrMatrix_ITU_R_601_4: *const c_void;
+    static kCVImageBufferYCbCrMatrix_ITU_R_2020: *const c_void;
 }
 
 #[link(name = "CoreFoundation", kind = "framework")]
@@ -99,8 +112,18 @@ extern "C" {
     fn CFDictionaryGetValue(dict: *const c_void, key: *const c_void) -> *const c_void;
     fn CFBooleanGetValue(boolean: *const c_void) -> bool;
     fn CFArrayGetCount(array: *const c_void) -> isize;
+    fn CFDictionaryCreate(
+        allocator: *const c_void,
+        keys: *const *const c_void,
+        values: *const *const c_void,
+        num_values: isize,
+        key_callbacks: *const CFDictionaryCallBacks,
+        value_callbacks: *const CFDictionaryCallBacks,
+    ) -> *mut c_void;
 
     static kCFBooleanTrue: *const c_void;
+    static kCFTypeDictionaryKeyCallBacks: CFDictionaryCallBacks;
+    static kCFTypeDictionaryValueCallBacks: CFDictionaryCallBacks;
     static kCFBooleanFalse: *const c_void;
 
     static kVTCompressionPropertyKey_RealTime: *const c_void;
@@ -108,12 +131,18 @@ extern "C" {
     static kVTCompressionPropertyKey_AllowFrameReordering: *const c_void;
     static kVTCompressionPropertyKey_MaxKeyFrameInterval: *const c_void;
     static kVTCompressionPropertyKey_AverageBitRate: *const c_void;
-
-    #[allow(dead_code)]
-    static kVTProfileLevel_H264_Baseline_AutoLevel: *const c_void;
-    static kVTProfileLevel_H264_Main_AutoLevel: *const c_void;
+    static kVTCompressionPropertyKey_ColorPrimaries: *const c_void;
+    static kVTCompressionPropertyKey_TransferFunction: *const c_void;
+    static kVTCompressionPropertyKey_YCbCrMatrix: *const c_void;
 
     static kCMSampleAttachmentKey_NotSync: *const c_void;
+    static kVTEncodeFrameOptionKey_ForceKeyFrame: *const c_void;
+}
+
+/// Key and value callbacks of a CFDictionary (only their address is used)
+#[repr(C)]
+struct CFDictionaryCallBacks {
+    _private: [u8; 0],
 }
 
 #[repr(C)]
@@ -149,6 +178,7 @@ unsafe impl Send for VideoToolboxEncoder {}
 
 impl VideoToolboxEncoder {
     pub fn new(config: EncoderConfig) -> Result<Self> {
+        let profile_level = profile_level_name(&config)?;
         let callback_data = Arc::new(Mutex::new(CallbackData {
             packets: Vec::new(),
             sps: None,
@@ -160,6 +190,33 @@ impl VideoToolboxEncoder {
 
         let mut session: *mut c_void = ptr::null_mut();
 
+        // VideoToolbox picks the hardware encoder when it can; a software
+        // or hardware encoder is asked for explicitly
+        let specification = unsafe {
+            let entry = match config.hw_accel {
+                HwAccel::Software => Some((
+                    kVTVideoEncoderSpecification_EnableHardwareAcceleratedVideoEncoder,
+                    kCFBooleanFalse,
+                )),
+                HwAccel::VideoToolbox => Some((
+                    kVTVideoEncoderSpecification_RequireHardwareAcceleratedVideoEncoder,
+                    kCFBooleanTrue,
+                )),
+                _ => None,
+            };
+            match entry {
+                Some((key, value)) => CFDictionaryCreate(
+                    ptr::null(),
+                    &key,
+                    &value,
+                    1,
+                    &kCFTypeDictionaryKeyCallBacks,
+                    &kCFTypeDictionaryValueCallBacks,
+                ),
+                None => ptr::null_mut(),
+            }
+        };
+
         // Create compression session
         let status = unsafe {
             VTCompressionSessionCreate(
@@ -167,7 +224,7 @@ impl VideoToolboxEncoder {
                 config.width as i32,
                 config.height as i32,
                 K_CMV_VIDEO_CODEC_TYPE_H264,
-                ptr::null(),
+                specification,
                 ptr::null(),
                 ptr::null(),
                 Some(compression_output_callback),
@@ -176,6 +233,10 @@ impl VideoToolboxEncoder {
             )
         };
 
+        if !specification.is_null() {
+            unsafe { CFRelease(specification) };
+        }
+
         if status != 0 {
             // Clean up the Arc we created
             unsafe {
@@ -189,12 +250,16 @@ impl VideoToolboxEncoder {
 
         // Configure encoder properties
         unsafe {
-            // Use Main profile for better compatibility
-            VTSessionSetProperty(
-                session,
-                kVTCompressionPropertyKey_ProfileLevel,
-                kVTProfileLevel_H264_Main_AutoLevel,
-            );
+            // Use Main profile for better compatibility unless set
+            let cf_profile_level = create_cf_string(&profile_level);
+            if !cf_profile_level.is_null() {
+                VTSessionSetProperty(
+                    session,
+                    kVTCompressionPropertyKey_ProfileLevel,
+                    cf_profile_level,
+                );
+                CFRelease(cf_profile_level);
+            }
 
             // Disable frame reordering for simpler output (no B-frames)
             VTSessionSetProperty(
@@ -203,8 +268,11 @@ impl VideoToolboxEncoder {
                 kCFBooleanFalse,
             );
 
-            // Set keyframe interval
-            let keyframe_interval = config.fps; // Keyframe every second
+            // Set keyframe interval, every second unless configured
+            let keyframe_interval = match config.keyframe_interval {
+                0 => config.fps,
+                interval => interval,
+            };
             let cf_number = create_cf_number(keyframe_interval as i64);
             if !cf_number.is_null() {
                 VTSessionSetProperty(
@@ -229,6 +297,32 @@ impl VideoToolboxEncoder {
 
             // Enable real-time encoding
             VTSessionSetProperty(session, kVTCompressionPropertyKey_RealTime, kCFBooleanTrue);
+
+            // Convert with the matrix and tag the color space, written into
+            // the stream's VUI
+            let color = &config.color;
+            let primaries = match color.primaries {
+                6 => kCVImageBufferColorPrimaries_SMPTE_C,
+                9 => kCVImageBufferColorPrimaries_ITU_R_2020,
+                12 => kCVImageBufferColorPrimaries_P3_D65,
+                _ => kCVImageBufferColorPrimaries_ITU_R_709_2,
+            };
+            let transfer = match color.transfer {
+                13 => kCVImageBufferTransferFunction_sRGB,
+                _ => kCVImageBufferTransferFunction_ITU_R_709_2,
+            };
+            let matrix = match color.matrix {
+                6 => kCVImageBufferYCbCrMatrix_ITU_R_601_4,
+                9 => kCVImageBufferYCbCrMatrix_ITU_R_2020,
+                _ => kCVImageBufferYCbCrMatrix_ITU_R_709_2,
+            };
+            VTSessionSetProperty(session, kVTCompressionPropertyKey_ColorPrimaries, primaries);
+            VTSessionSetProperty(
+                session,
+                kVTCompressionPropertyKey_TransferFunction,
+                transfer,
+            );
+            VTSessionSetProperty(session, kVTCompressionPropertyKey_YCbCrMatrix, matrix);
         }
 
         Ok(Self {
@@ -401,6 +495,8 @@ extern "C" fn compression_output_callback(
             pts: frame_count as i64,
             dts: frame_count as i64,
             is_keyframe,
+            duration: 1,
+            alpha: None,
         });
     }
 }
@@ -474,12 +570,45 @@ extern "C" {
         the_type: i32,
         value_ptr: *const c_void,
     ) -> *mut c_void;
+    fn CFStringCreateWithCString(
+        allocator: *const c_void,
+        c_str: *const std::ffi::c_char,
+        encoding: u32,
+    ) -> *mut c_void;
     fn CFRelease(cf: *mut c_void);
     fn CFArrayGetValueAtIndex(array: *const c_void, index: isize) -> *const c_void;
 }
 
 const K_CF_NUMBER_INT64_TYPE: i32 = 4;
 
+const K_CF_STRING_ENCODING_UTF8: u32 = 0x0800_0100;
+
+/// Name of the VideoToolbox profile and level, the value of its
+/// `kVTProfileLevel_H264_*` constant; Main unless another profile is set
+fn profile_level_name(config: &EncoderConfig) -> Result<String> {
+    let profile = match config.h264_profile {
+        H264Profile::Baseline => "Baseline",
+        H264Profile::Auto | H264Profile::Main => "Main",
+        H264Profile::High => "High",
+    };
+    match config.h264_level {
+        0 => Ok(format!("H264_{}_AutoLevel", profile)),
+        level @ 30..=52 => Ok(format!("H264_{}_{}_{}", profile, level / 10, level % 10)),
+        level => Err(Error::CodecUnavailable(format!(
+            "VideoToolbox encodes H.264 levels 3.0 to 5.2, not {}.{}",
+            level / 10,
+            level % 10
+        ))),
+    }
+}
+
+fn create_cf_string(value: &str) -> *mut c_void {
+    let Ok(value) = CString::new(value) else {
+        return ptr::null_mut();
+    };
+    unsafe { CFStringCreateWithCString(ptr::null(), value.as_ptr(), K_CF_STRING_ENCODING_UTF8) }
+}
+
 fn create_cf_number(value: i64) -> *mut c_void {
     unsafe {
         CFNumberCreate(
@@ -491,6 +620,11 @@ fn create_cf_number(value: i64) -> *mut c_void {
 }
 
 fn calculate_bitrate(config: &EncoderConfig) -> u32 {
+    // A set average bitrate replaces the estimate
+    if config.bitrate.target_kbps > 0 {
+        return config.bitrate.target_kbps.saturating_mul(1000);
+    }
+
     // Base bitrate calculation based on resolution and quality
     let pixels = config.width * config.height;
     let base_bitrate = match pixels {
@@ -503,23 +637,55 @@ fn calculate_bitrate(config: &EncoderConfig) -> u32 {
 
     // Adjust by quality (0-100)
     let quality_factor = (config.quality as u32 + 50) / 100; // 0.5x to 1.5x
-    base_bitrate * quality_factor.max(1)
+    let bitrate = base_bitrate * quality_factor.max(1);
+
+    // The estimate stays under a set peak
+    match config.bitrate.max_kbps {
+        0 => bitrate,
+        max => bitrate.min(max.saturating_mul(1000)),
+    }
 }
 
 impl Encoder for VideoToolboxEncoder {
+    fn name(&self) -> &str {
+        "VideoToolbox"
+    }
+
+    fn hardware(&self) -> bool {
+        // VideoToolbox uses the hardware encoder when it can, so only the
+        // software encoder asked for is known to run on the CPU
+        self.config.hw_accel != HwAccel::Software
+    }
+
     fn encode(&mut self, frame: &Frame) -> Result<Vec<Packet>> {
         let pixel_buffer = self.create_pixel_buffer(frame)?;
 
         let pts = unsafe { CMTimeMake(self.frame_count as i64, self.config.fps as i32) };
         let duration = unsafe { CMTimeMake(1, self.config.fps as i32) };
 
+        // Forced keyframes are requested through the frame properties
+        let properties = if self.config.forces_keyframe(self.frame_count) {
+            unsafe {
+                CFDictionaryCreate(
+                    ptr::null(),
+                    &kVTEncodeFrameOptionKey_ForceKeyFrame,
+                    &kCFBooleanTrue,
+                    1,
+                    &kCFTypeDictionaryKeyCallBacks,
+                    &kCFTypeDictionaryValueCallBacks,
+                )
+            }
+        } else {
+            ptr::null_mut()
+        };
+
         let status = unsafe {
             VTCompressionSessionEncodeFrame(
                 self.session,
                 pixel_buffer,
                 pts,
                 duration,
-                ptr::null(),
+                properties,
                 ptr::null_mut(),
                 ptr::null_mut(),
             )
@@ -527,6 +693,9 @@ impl Encoder for VideoToolboxEncoder {
 
         unsafe {
             CVPixelBufferRelease(pixel_buffer);
+            if !properties.is_null() {
+                CFRelease(properties);
+            }
         }
 
         if status != 0 {
@@ -40,6 +40,48 @@ pub fn check_available(ffmpeg_path: Option<&str>) -> Result<()> {
 
 /// Create an H.264 encoder for the current platform
 pub fn create_encoder(config: EncoderConfig) -> Result<Box<dyn Encoder>> {
+    #[cfg(not(target_os = "linux"))]
+    if config.lossless {
+        return Err(crate::Error::CodecUnavailable(
+            "Lossless H.264 needs the ffmpeg encoder used on Linux".to_string(),
+        ));
+    }
+    #[cfg(not(target_os = "linux"))]
+    if config.h264_preset != crate::H264Preset::Auto || config.h264_tune != crate::H264Tune::None {
+        return Err(crate::Error::CodecUnavailable(
+            "H.264 presets and tunes need the libx264 encoder used on Linux".to_string(),
+        ));
+    }
+    #[cfg(not(target_os = "linux"))]
+    if config.chroma() != crate::PixelFormat::Yuv420 || config.bit_depth != crate::BitDepth::Eight {
+        return Err(crate::Error::CodecUnavailable(
+            "H.264 other than 8-bit 4:2:0 needs the ffmpeg encoder used on Linux".to_string(),
+        ));
+    }
+
+    #[cfg(target_os = "macos")]
+    if !matches!(
+        config.hw_accel,
+        crate::HwAccel::Auto | crate::HwAccel::Software | crate::HwAccel::VideoToolbox
+    ) {
+        return Err(crate::Error::CodecUnavailable(format!(
+            "H.264 on macOS is encoded with VideoToolbox, not {:?}",
+            config.hw_accel
+        )));
+    }
+    #[cfg(target_os = "macos")]
+    if config.color.full_range {
+        return Err(crate::Error::CodecUnavailable(
+            "Full-range H.264 needs the ffmpeg encoder used on Linux".to_string(),
+        ));
+    }
+    #[cfg(target_os = "windows")]
+    if config.color.primaries == 12 {
+        return Err(crate::Error::CodecUnavailable(
+            "Display P3 H.264 needs the ffmpeg encoder used on Linux".to_string(),
+        ));
+    }
+
     #[cfg(target_os = "macos")]
     {
         Ok(Box::new(macos::VideoToolboxEncoder::new(config)?))
@@ -52,7 +94,11 @@ pub fn create_encoder(config: EncoderConfig) -> Result<Box<dyn Encoder>> {
 
     #[cfg(target_os = "linux")]
     {
-        Ok(Box::new(linux::FfmpegEncoder::new(config, None)?))
+        let ffmpeg_path = config.ffmpeg_path.clone();
+        Ok(Box::new(linux::FfmpegEncoder::new(
+            config,
+            ffmpeg_path.as_deref(),
+        )?))
     }
 
     #[cfg(not(any(target_os = "macos", target_os = "windows", target_os = "linux")))]
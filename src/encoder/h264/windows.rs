@@ -1,8 +1,10 @@
 //! Windows H.264 encoder using Media Foundation
 
 use super::super::{Encoder, EncoderConfig, Frame, Packet};
-use crate::{Error, Result};
+use crate::colorspace::ColorSpace;
+use crate::{Error, H264Profile, HwAccel, Result};
 use std::ptr;
+use windows::core::PWSTR;
 use windows::Win32::Media::MediaFoundation::*;
 use windows::Win32::System::Com::*;
 
@@ -16,6 +18,7 @@ pub struct MediaFoundationEncoder {
     initialized: bool,
     sps: Option<Vec<u8>>,
     pps: Option<Vec<u8>>,
+    hardware: bool,
 }
 
 unsafe impl Send for MediaFoundationEncoder {}
@@ -33,7 +36,7 @@ impl MediaFoundationEncoder {
                 .map_err(|e| Error::Platform(format!("Failed to start MF: {}", e)))?;
 
             // Find and create H.264 encoder
-            let transform = find_h264_encoder()?;
+            let (transform, hardware) = find_h264_encoder(config.hw_accel)?;
 
             // Create input media type (NV12)
             let input_type: IMFMediaType = MFCreateMediaType()
@@ -92,6 +95,31 @@ impl MediaFoundationEncoder {
                 .SetUINT32(&MF_MT_INTERLACE_MODE, MFVideoInterlace_Progressive.0 as u32)
                 .map_err(|e| Error::Encode(format!("Failed to set interlace mode: {}", e)))?;
 
+            // Profile and level, the encoder's choice unless set
+            let profile = match config.h264_profile {
+                H264Profile::Auto => None,
+                H264Profile::Baseline => Some(eAVEncH264VProfile_Base),
+                H264Profile::Main => Some(eAVEncH264VProfile_Main),
+                H264Profile::High => Some(eAVEncH264VProfile_High),
+            };
+            if let Some(profile) = profile {
+                output_type
+                    .SetUINT32(&MF_MT_MPEG2_PROFILE, profile.0 as u32)
+                    .map_err(|e| Error::Encode(format!("Failed to set profile: {}", e)))?;
+            }
+            // eAVEncH264VLevel values are the level times ten
+            if config.h264_level != 0 {
+                output_type
+                    .SetUINT32(&MF_MT_MPEG2_LEVEL, config.h264_level as u32)
+                    .map_err(|e| Error::Encode(format!("Failed to set level: {}", e)))?;
+            }
+
+            // Tag the color space, written into the stream's VUI
+            for media_type in [&input_type, &output_type] {
+                set_color_space(media_type, &config.color)
+                    .map_err(|e| Error::Encode(format!("Failed to set color space: {}", e)))?;
+            }
+
             // Set output type
             transform
                 .SetOutputType(0, &output_type, 0)
@@ -111,6 +139,7 @@ impl MediaFoundationEncoder {
                 initialized: true,
                 sps: None,
                 pps: None,
+                hardware,
             };
 
             // Try to extract SPS/PPS from output media type attributes
@@ -131,12 +160,13 @@ impl MediaFoundationEncoder {
         for y in 0..height {
             for x in 0..width {
                 let idx = (y * width + x) * 4;
-                let r = frame.data[idx] as f32;
-                let g = frame.data[idx + 1] as f32;
-                let b = frame.data[idx + 2] as f32;
+                let rgb = [
+                    frame.data[idx] as f32,
+                    frame.data[idx + 1] as f32,
+                    frame.data[idx + 2] as f32,
+                ];
 
-                // BT.601 conversion
-                let y_val = (0.299 * r + 0.587 * g + 0.114 * b).clamp(0.0, 255.0) as u8;
+                let y_val = self.config.color.luma(rgb).clamp(0.0, 255.0) as u8;
                 nv12[y * width + x] = y_val;
             }
         }
@@ -164,12 +194,13 @@ impl MediaFoundationEncoder {
                     }
                 }
 
-                let r = (r_sum / 4) as f32;
-                let g = (g_sum / 4) as f32;
-                let b = (b_sum / 4) as f32;
+                let rgb = [r_sum, g_sum, b_sum].map(|sum| (sum / 4) as f32);
 
-                let u = ((-0.169 * r - 0.331 * g + 0.500 * b) + 128.0).clamp(0.0, 255.0) as u8;
-                let v = ((0.500 * r - 0.419 * g - 0.081 * b) + 128.0).clamp(0.0, 255.0) as u8;
+                let [u, v] = self
+                    .config
+                    .color
+                    .chroma(rgb)
+                    .map(|c| c.clamp(0.0, 255.0) as u8);
 
                 nv12[uv_offset + y * uv_width * 2 + x * 2] = u;
                 nv12[uv_offset + y * uv_width * 2 + x * 2 + 1] = v;
@@ -181,6 +212,14 @@ impl MediaFoundationEncoder {
 }
 
 impl Encoder for MediaFoundationEncoder {
+    fn name(&self) -> &str {
+        "Media Foundation"
+    }
+
+    fn hardware(&self) -> bool {
+        self.hardware
+    }
+
     fn encode(&mut self, frame: &Frame) -> Result<Vec<Packet>> {
         let nv12_data = self.rgba_to_nv12(frame);
 
@@ -329,6 +368,8 @@ impl MediaFoundationEncoder {
                             pts: self.frame_count as i64 - 1,
                             dts: self.frame_count as i64 - 1,
                             is_keyframe: packets.is_empty(), // First packet is keyframe
+                            duration: 1,
+                            alpha: None,
                         });
                     }
                 }
@@ -607,7 +648,79 @@ impl MediaFoundationEncoder {
 // encoder is still active (in parallel tests) causes crashes.
 // COM/MF will be cleaned up when the process exits.
 
-fn find_h264_encoder() -> Result<IMFTransform> {
+/// Set the primaries, transfer, matrix and range of a media type
+unsafe fn set_color_space(
+    media_type: &IMFMediaType,
+    color: &ColorSpace,
+) -> windows::core::Result<()> {
+    let primaries = match color.primaries {
+        6 => MFVideoPrimaries_SMPTE170M,
+        9 => MFVideoPrimaries_BT2020,
+        _ => MFVideoPrimaries_BT709,
+    };
+    let transfer = match color.transfer {
+        13 => MFVideoTransFunc_sRGB,
+        16 => MFVideoTransFunc_2084,
+        _ => MFVideoTransFunc_709,
+    };
+    let matrix = match color.matrix {
+        6 => MFVideoTransferMatrix_BT601,
+        9 => MFVideoTransferMatrix_BT2020_10,
+        _ => MFVideoTransferMatrix_BT709,
+    };
+    let range = if color.full_range {
+        MFNominalRange_0_255
+    } else {
+        MFNominalRange_16_235
+    };
+    media_type.SetUINT32(&MF_MT_VIDEO_PRIMARIES, primaries.0 as u32)?;
+    media_type.SetUINT32(&MF_MT_TRANSFER_FUNCTION, transfer.0 as u32)?;
+    media_type.SetUINT32(&MF_MT_YUV_MATRIX, matrix.0 as u32)?;
+    media_type.SetUINT32(&MF_MT_VIDEO_NOMINAL_RANGE, range.0 as u32)
+}
+
+/// PCI vendor of the hardware encoders of each API, as Media Foundation
+/// reports them (None for any encoder)
+fn hardware_vendor(hw_accel: HwAccel) -> Result<Option<&'static str>> {
+    match hw_accel {
+        HwAccel::Auto | HwAccel::Software => Ok(None),
+        HwAccel::Nvenc => Ok(Some("VEN_10DE")),
+        HwAccel::Qsv => Ok(Some("VEN_8086")),
+        HwAccel::Amf => Ok(Some("VEN_1002")),
+        HwAccel::VideoToolbox | HwAccel::Vaapi => Err(Error::CodecUnavailable(format!(
+            "H.264 on Windows is encoded with Media Foundation, not {:?}",
+            hw_accel
+        ))),
+    }
+}
+
+/// PCI vendor of a hardware encoder ("VEN_10DE"), or None for software
+/// encoders
+unsafe fn vendor_id(activate: &IMFActivate) -> Option<String> {
+    let mut value = PWSTR::null();
+    let mut length = 0u32;
+    activate
+        .GetAllocatedString(
+            &MFT_ENUM_HARDWARE_VENDOR_ID_Attribute,
+            &mut value,
+            &mut length,
+        )
+        .ok()?;
+    let id = value.to_string().ok();
+    CoTaskMemFree(Some(value.0 as *const _));
+    id
+}
+
+/// Create the first H.264 encoder of the requested kind: any encoder, the
+/// hardware encoders listed first, for Auto; software encoders only for
+/// Software; the hardware encoders of a vendor for its API. Also returns
+/// whether the encoder is a hardware one.
+fn find_h264_encoder(hw_accel: HwAccel) -> Result<(IMFTransform, bool)> {
+    let vendor = hardware_vendor(hw_accel)?;
+    let flags = match hw_accel {
+        HwAccel::Software => MFT_ENUM_FLAG_SYNCMFT | MFT_ENUM_FLAG_ASYNCMFT,
+        _ => MFT_ENUM_FLAG_SYNCMFT | MFT_ENUM_FLAG_ASYNCMFT | MFT_ENUM_FLAG_HARDWARE,
+    };
     unsafe {
         let mut count = 0u32;
         let mut activates: *mut Option<IMFActivate> = ptr::null_mut();
@@ -624,7 +737,7 @@ fn find_h264_encoder() -> Result<IMFTransform> {
 
         MFTEnumEx(
             MFT_CATEGORY_VIDEO_ENCODER,
-            MFT_ENUM_FLAG_SYNCMFT | MFT_ENUM_FLAG_ASYNCMFT | MFT_ENUM_FLAG_HARDWARE,
+            flags,
             Some(&input_type),
             Some(&output_type),
             &mut activates,
@@ -638,13 +751,23 @@ fn find_h264_encoder() -> Result<IMFTransform> {
             ));
         }
 
-        // Get the first activate object
+        // Get the first activate object of the vendor
         let activate_slice = std::slice::from_raw_parts(activates, count as usize);
-        let activate = activate_slice[0]
-            .as_ref()
-            .ok_or_else(|| Error::CodecUnavailable("Invalid activate object".to_string()))?;
+        let activate = match vendor {
+            Some(vendor) => activate_slice
+                .iter()
+                .flatten()
+                .find(|activate| vendor_id(activate).as_deref() == Some(vendor))
+                .ok_or_else(|| {
+                    Error::CodecUnavailable(format!("No {:?} H.264 encoder found", hw_accel))
+                })?,
+            None => activate_slice[0]
+                .as_ref()
+                .ok_or_else(|| Error::CodecUnavailable("Invalid activate object".to_string()))?,
+        };
 
         // Create transform from activate
+        let hardware = vendor_id(activate).is_some();
         let transform: IMFTransform = activate
             .ActivateObject()
             .map_err(|e| Error::CodecUnavailable(format!("Failed to activate encoder: {}", e)))?;
@@ -655,16 +778,27 @@ fn find_h264_encoder() -> Result<IMFTransform> {
         }
         CoTaskMemFree(Some(activates as *const _));
 
-        Ok(transform)
+        Ok((transform, hardware))
     }
 }
 
 fn calculate_bitrate(config: &EncoderConfig) -> u32 {
+    // A set average bitrate replaces the estimate
+    if config.bitrate.target_kbps > 0 {
+        return config.bitrate.target_kbps.saturating_mul(1000);
+    }
+
     // Rough bitrate calculation based on resolution, fps, and quality
     let pixels = config.width * config.height;
     let base_bitrate = (pixels * config.fps) / 100;
     let quality_factor = (config.quality as u32 + 10) / 10;
-    base_bitrate * quality_factor
+    let bitrate = base_bitrate * quality_factor;
+
+    // The estimate stays under a set peak
+    match config.bitrate.max_kbps {
+        0 => bitrate,
+        max => bitrate.min(max.saturating_mul(1000)),
+    }
 }
 
 /// Encode a value using Exp-Golomb coding (unsigned)
@@ -695,7 +829,7 @@ pub fn check_available() -> Result<()> {
 
         // Just check if we can find an encoder
         // Don't call MFShutdown/CoUninitialize - it affects other encoders in parallel tests
-        match find_h264_encoder() {
+        match find_h264_encoder(HwAccel::Auto) {
             Ok(_transform) => Ok(()),
             Err(e) => Err(e),
         }
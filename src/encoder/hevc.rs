@@ -0,0 +1,961 @@
+//! H.265/HEVC encoder using ffmpeg external process
+//!
+//! VideoToolbox is used on macOS and NVENC, Quick Sync or AMF elsewhere
+//! when ffmpeg has them and they work on this machine, falling back to
+//! libx265, unless another encoder is requested; only libx265 is used for
+//! 4:2:2, 4:4:4 and HDR10. ffmpeg
+//! writes an Annex B stream, which is split into access units with
+//! length-prefixed NAL units as MP4 stores them while it arrives; the
+//! parameter sets go to the `hvcC` configuration record instead.
+
+use super::{hardware_encoder, is_hardware_encoder, Encoder, EncoderConfig, Frame, Packet, Speed};
+use crate::ffmpeg::{self, find_ffmpeg, ProcessLog};
+use crate::{Codec, Error, HwAccel, Result};
+use std::io::{ErrorKind, Read, Write};
+use std::process::{Child, ChildStdin, Command, Stdio};
+use std::sync::mpsc::{self, Receiver, Sender};
+use std::sync::Mutex;
+use std::thread::JoinHandle;
+
+/// Hardware encoders tried before libx265, in order of preference
+#[cfg(target_os = "macos")]
+const HARDWARE_ENCODERS: &[&str] = &["hevc_videotoolbox"];
+#[cfg(not(target_os = "macos"))]
+const HARDWARE_ENCODERS: &[&str] = &["hevc_nvenc", "hevc_qsv", "hevc_amf"];
+
+/// Software encoder used when no hardware encoder works
+const SOFTWARE_ENCODER: &str = "libx265";
+
+/// DRM render node of VA-API encoders
+const VAAPI_DEVICE: &str = "/dev/dri/renderD128";
+
+/// Size of the reads from ffmpeg's output
+const READ_SIZE: usize = 64 * 1024;
+
+/// Encoder chosen for each ffmpeg executable, output pixel format and list
+/// of candidates
+type SelectedEncoder = (String, String, Vec<&'static str>, &'static str);
+static SELECTED_ENCODERS: Mutex<Vec<SelectedEncoder>> = Mutex::new(Vec::new());
+
+// NAL unit types
+const NAL_IRAP_FIRST: u8 = 16;
+const NAL_IRAP_LAST: u8 = 23;
+const NAL_VPS: u8 = 32;
+const NAL_SPS: u8 = 33;
+const NAL_PPS: u8 = 34;
+const NAL_AUD: u8 = 35;
+const NAL_PREFIX_SEI: u8 = 39;
+
+/// FFmpeg-based HEVC encoder
+pub struct HevcEncoder {
+    encoder: &'static str,
+    process: Child,
+    log: ProcessLog,
+    stdin: Option<ChildStdin>,
+    reader: Option<JoinHandle<std::io::Result<()>>>,
+    /// Output of ffmpeg read so far
+    output: Receiver<Vec<u8>>,
+    parser: StreamParser,
+}
+
+impl HevcEncoder {
+    pub fn new(config: EncoderConfig) -> Result<Self> {
+        let ffmpeg = find_ffmpeg(config.ffmpeg_path.as_deref())?;
+        let output_format = config.ffmpeg_pixel_format();
+        // Only libx265 writes the HDR10 metadata into the stream
+        let encoder = match (&config.hdr, config.hw_accel) {
+            (Some(_), _) | (None, HwAccel::Software) => {
+                select_encoder(&ffmpeg, &output_format, &[], Some(SOFTWARE_ENCODER))?
+            }
+            (None, HwAccel::Auto) => {
+                let hardware = matches!(output_format.as_str(), "yuv420p" | "yuv420p10le");
+                let candidates = if hardware { HARDWARE_ENCODERS } else { &[] };
+                select_encoder(&ffmpeg, &output_format, candidates, Some(SOFTWARE_ENCODER))?
+            }
+            (None, hw_accel) => {
+                let candidates: Vec<_> = hardware_encoder(Codec::Hevc, hw_accel)
+                    .into_iter()
+                    .collect();
+                select_encoder(&ffmpeg, &output_format, &candidates, None)?
+            }
+        };
+
+        let mut args = device_args(encoder);
+        args.extend(
+            [
+                "-hide_banner",
+                "-f",
+                "rawvideo",
+                "-pix_fmt",
+                "rgba",
+                "-s",
+                &format!("{}x{}", config.width, config.height),
+                "-r",
+                &config.fps.to_string(),
+                "-i",
+                "pipe:0",
+                "-c:v",
+                encoder,
+                // No B-frames, so packets are in presentation order
+                "-bf",
+                "0",
+            ]
+            .iter()
+            .map(|s| s.to_string()),
+        );
+        args.extend(rate_args(encoder, &config));
+        args.extend(config.keyframe_args());
+        args.extend(config.thread_args());
+        if let Some(hdr) = &config.hdr {
+            args.extend(["-x265-params".to_string(), hdr.x265_params()]);
+        }
+        args.extend(format_args(
+            encoder,
+            config.color.ffmpeg_args(),
+            pixel_format(encoder, &output_format),
+        ));
+        args.extend(config.ffmpeg_args.iter().cloned());
+        args.extend(["-f", "hevc", "pipe:1"].iter().map(|s| s.to_string()));
+
+        let (mut process, log) = ProcessLog::spawn(
+            Command::new(&ffmpeg)
+                .args(&args)
+                .stdin(Stdio::piped())
+                .stdout(Stdio::piped()),
+        )?;
+
+        let stdin = process.stdin.take();
+        let stdout = process
+            .stdout
+            .take()
+            .ok_or_else(|| Error::Ffmpeg("FFmpeg stdout not available".to_string()))?;
+
+        // Drain the output while frames are written so the pipe never fills
+        let (sender, output) = mpsc::channel();
+        let reader = std::thread::spawn(move || read_output(stdout, sender));
+
+        Ok(Self {
+            encoder,
+            process,
+            log,
+            stdin,
+            reader: Some(reader),
+            output,
+            parser: StreamParser::default(),
+        })
+    }
+
+    /// Split the output read so far into packets
+    fn parse_output(&mut self) -> Result<Vec<Packet>> {
+        let mut packets = Vec::new();
+        for data in self.output.try_iter() {
+            packets.extend(self.parser.push(&data)?);
+        }
+        Ok(packets)
+    }
+}
+
+impl Encoder for HevcEncoder {
+    fn name(&self) -> &str {
+        self.encoder
+    }
+
+    fn hardware(&self) -> bool {
+        is_hardware_encoder(self.encoder)
+    }
+
+    fn encode(&mut self, frame: &Frame) -> Result<Vec<Packet>> {
+        let stdin = self
+            .stdin
+            .as_mut()
+            .ok_or_else(|| Error::Ffmpeg("FFmpeg stdin not available".to_string()))?;
+
+        if let Err(e) = stdin.write_all(&frame.data) {
+            // ffmpeg closes its input when it fails
+            let error = Error::Ffmpeg(format!("Failed to write frame: {}", e));
+            return Err(self.log.error(&mut self.process, error));
+        }
+
+        self.parse_output()
+    }
+
+    fn flush(&mut self) -> Result<Vec<Packet>> {
+        // Close stdin to signal end of input
+        drop(self.stdin.take());
+
+        match self.reader.take() {
+            Some(reader) => reader
+                .join()
+                .map_err(|_| Error::Ffmpeg("FFmpeg output reader panicked".to_string()))?
+                .map_err(|e| Error::Ffmpeg(format!("Failed to read output: {}", e)))?,
+            None => return Ok(Vec::new()),
+        }
+
+        let status = self
+            .process
+            .wait()
+            .map_err(|e| Error::Ffmpeg(format!("FFmpeg process error: {}", e)))?;
+        if !status.success() {
+            let error = Error::Ffmpeg(format!("FFmpeg HEVC encoding failed with {}", status));
+            return Err(self.log.error(&mut self.process, error));
+        }
+
+        let mut packets = self.parse_output()?;
+        packets.extend(self.parser.finish()?);
+        Ok(packets)
+    }
+
+    /// The `hvcC` configuration record, available with the first packet
+    fn codec_config(&self) -> Option<Vec<u8>> {
+        self.parser.config_record.clone()
+    }
+}
+
+impl Drop for HevcEncoder {
+    fn drop(&mut self) {
+        // Kill the process if it's still running
+        let _ = self.process.kill();
+        let _ = self.process.wait();
+    }
+}
+
+/// API of an encoder: the suffix of hardware encoders (`nvenc` for
+/// `hevc_nvenc`), or the name of software ones
+fn encoder_api(encoder: &str) -> &str {
+    encoder.split_once('_').map_or(encoder, |(_, api)| api)
+}
+
+/// Rate control arguments mapping quality (0-100) to each encoder's scale,
+/// or setting the average bitrate, with the peak bitrate limit and the
+/// speed preset of libx264 and libx265
+pub(crate) fn rate_args(encoder: &str, config: &EncoderConfig) -> Vec<String> {
+    let bitrate = &config.bitrate;
+    let mut args = if bitrate.target_kbps > 0 {
+        let target = format!("{}k", bitrate.target_kbps);
+        let args: &[&str] = match encoder_api(encoder) {
+            "nvenc" => &["-rc", "vbr", "-b:v", &target],
+            "amf" => &["-rc", "vbr_latency", "-b:v", &target],
+            "vaapi" => &["-rc_mode", "VBR", "-b:v", &target],
+            "videotoolbox" | "qsv" => &["-b:v", &target],
+            _ => &["-preset", x26x_preset(config.speed), "-b:v", &target],
+        };
+        args.iter().map(|s| s.to_string()).collect()
+    } else {
+        quality_args(encoder, config.quality, config.speed)
+    };
+    args.extend(bitrate.limit_args());
+    args
+}
+
+/// Rate control arguments mapping quality (0-100) to each encoder's scale,
+/// and the speed preset of libx264 and libx265
+fn quality_args(encoder: &str, quality: u8, speed: Speed) -> Vec<String> {
+    let quality = quality.min(100) as u32;
+    // CRF/QP scale of 51-0
+    let qp = (100 - quality) * 51 / 100;
+
+    let args: &[&str] = match encoder_api(encoder) {
+        "videotoolbox" => &["-q:v", &quality.max(1).to_string()],
+        "nvenc" => &["-rc", "vbr", "-cq", &qp.max(1).to_string(), "-b:v", "0"],
+        "qsv" => &["-global_quality", &qp.max(1).to_string()],
+        "vaapi" => &["-rc_mode", "CQP", "-qp", &qp.max(1).to_string()],
+        "amf" => &[
+            "-rc",
+            "cqp",
+            "-qp_i",
+            &qp.to_string(),
+            "-qp_p",
+            &qp.to_string(),
+        ],
+        _ => &["-preset", x26x_preset(speed), "-crf", &qp.to_string()],
+    };
+    args.iter().map(|s| s.to_string()).collect()
+}
+
+/// x264/x265 preset for a speed
+///
+/// Hardware encoders are fast at their default settings and ignore the speed.
+pub(crate) fn x26x_preset(speed: Speed) -> &'static str {
+    match speed {
+        Speed::Default => "medium",
+        Speed::Fast => "veryfast",
+        Speed::Faster => "superfast",
+        Speed::Fastest => "ultrafast",
+    }
+}
+
+/// Input pixel format of each encoder for an output pixel format: the
+/// semi-planar formats of hardware encoders for 4:2:0, or the output
+/// format itself
+pub(crate) fn pixel_format<'a>(encoder: &str, output_format: &'a str) -> &'a str {
+    match (encoder_api(encoder), output_format) {
+        ("libx264" | "libx265", _) => output_format,
+        ("qsv" | "vaapi", "yuv420p") => "nv12",
+        (_, "yuv420p10le") => "p010le",
+        _ => output_format,
+    }
+}
+
+/// ffmpeg arguments opening the device of an encoder, before the input
+pub(crate) fn device_args(encoder: &str) -> Vec<String> {
+    match encoder_api(encoder) {
+        "vaapi" => vec!["-vaapi_device".to_string(), VAAPI_DEVICE.to_string()],
+        _ => Vec::new(),
+    }
+}
+
+/// Output arguments converting the frames with `filter_args` (`-vf` and
+/// its filter) to the input pixel format of an encoder; VA-API encoders
+/// take the frames uploaded to the GPU
+pub(crate) fn format_args(encoder: &str, filter_args: Vec<String>, format: &str) -> Vec<String> {
+    let mut args = filter_args;
+    if encoder_api(encoder) == "vaapi" {
+        if let Some(i) = args.iter().position(|arg| arg == "-vf") {
+            args[i + 1] = format!("{},format={},hwupload", args[i + 1], format);
+        }
+    } else {
+        args.extend(["-pix_fmt".to_string(), format.to_string()]);
+    }
+    args
+}
+
+/// Pick the first of the candidate encoders that works with this ffmpeg for
+/// an output pixel format, or else the fallback when ffmpeg has it
+pub(crate) fn select_encoder(
+    ffmpeg: &str,
+    output_format: &str,
+    candidates: &[&'static str],
+    fallback: Option<&'static str>,
+) -> Result<&'static str> {
+    let mut selected = SELECTED_ENCODERS
+        .lock()
+        .map_err(|_| Error::Ffmpeg("Encoder cache is poisoned".to_string()))?;
+    let names: Vec<&'static str> = candidates.iter().copied().chain(fallback).collect();
+    if let Some((_, _, _, encoder)) = selected.iter().find(|(path, format, tried, _)| {
+        path == ffmpeg && format == output_format && *tried == names
+    }) {
+        return Ok(encoder);
+    }
+
+    let output = Command::new(ffmpeg)
+        .args(["-hide_banner", "-encoders"])
+        .output()
+        .map_err(|e| Error::Ffmpeg(format!("Failed to run ffmpeg: {}", e)))?;
+    let encoders = String::from_utf8_lossy(&output.stdout);
+
+    // Hardware encoders are often listed but unusable without the
+    // hardware, so try a short encode first
+    let encoder = candidates
+        .iter()
+        .copied()
+        .filter(|name| encoders.contains(name))
+        .find(|name| test_encode(ffmpeg, name, output_format))
+        .or_else(|| fallback.filter(|name| encoders.contains(name)))
+        .ok_or_else(|| {
+            Error::CodecUnavailable(format!(
+                "FFmpeg has no working encoder of {}",
+                names.join(", ")
+            ))
+        })?;
+
+    selected.push((
+        ffmpeg.to_string(),
+        output_format.to_string(),
+        names,
+        encoder,
+    ));
+    Ok(encoder)
+}
+
+/// Check that an encoder can encode a frame of a pixel format on this
+/// machine
+fn test_encode(ffmpeg: &str, encoder: &str, output_format: &str) -> bool {
+    let mut args = device_args(encoder);
+    args.extend(
+        [
+            "-f",
+            "lavfi",
+            "-i",
+            "color=c=black:s=256x256:d=0.1",
+            "-frames:v",
+            "1",
+            "-c:v",
+            encoder,
+        ]
+        .iter()
+        .map(|s| s.to_string()),
+    );
+    args.extend(format_args(
+        encoder,
+        vec!["-vf".to_string(), "null".to_string()],
+        pixel_format(encoder, output_format),
+    ));
+    args.extend(["-f", "null", "-"].iter().map(|s| s.to_string()));
+    ffmpeg::run(ffmpeg, &args).is_ok()
+}
+
+/// Check if ffmpeg with an HEVC encoder is available
+pub fn check_available(ffmpeg_path: Option<&str>) -> Result<()> {
+    let ffmpeg = find_ffmpeg(ffmpeg_path)?;
+    select_encoder(
+        &ffmpeg,
+        "yuv420p",
+        HARDWARE_ENCODERS,
+        Some(SOFTWARE_ENCODER),
+    )
+    .map(|_| ())
+}
+
+/// Type of a NAL unit
+fn nal_type(nal: &[u8]) -> u8 {
+    nal.first().map(|b| (b >> 1) & 0x3F).unwrap_or(0)
+}
+
+/// Split an Annex B stream into NAL units without start codes
+pub(crate) fn split_annex_b(data: &[u8]) -> Vec<&[u8]> {
+    let mut starts = Vec::new();
+    let mut i = 0;
+    while i + 3 <= data.len() {
+        if data[i] == 0 && data[i + 1] == 0 && data[i + 2] == 1 {
+            starts.push(i + 3);
+            i += 3;
+        } else {
+            i += 1;
+        }
+    }
+
+    starts
+        .iter()
+        .enumerate()
+        .map(|(n, &start)| {
+            let mut end = starts
+                .get(n + 1)
+                .map(|&next| next - 3)
+                .unwrap_or(data.len());
+            // Drop the leading zero of a 4-byte start code and trailing zeros
+            while end > start && data[end - 1] == 0 {
+                end -= 1;
+            }
+            &data[start..end]
+        })
+        .filter(|nal| nal.len() >= 2)
+        .collect()
+}
+
+/// Check if a NAL unit begins a new access unit after one with slices
+fn starts_access_unit(nal: &[u8]) -> bool {
+    match nal_type(nal) {
+        // Slice with first_slice_segment_in_pic_flag
+        0..=31 => nal.get(2).is_some_and(|b| b & 0x80 != 0),
+        NAL_VPS..=NAL_AUD | NAL_PREFIX_SEI | 41..=44 | 48..=55 => true,
+        _ => false,
+    }
+}
+
+/// Read ffmpeg's output as it is written, sending it in chunks
+fn read_output<R: Read>(mut input: R, sender: Sender<Vec<u8>>) -> std::io::Result<()> {
+    let mut buf = vec![0u8; READ_SIZE];
+    loop {
+        match input.read(&mut buf) {
+            Ok(0) => return Ok(()),
+            Ok(n) => {
+                if sender.send(buf[..n].to_vec()).is_err() {
+                    // The encoder was dropped
+                    return Ok(());
+                }
+            }
+            Err(e) if e.kind() == ErrorKind::Interrupted => {}
+            Err(e) => return Err(e),
+        }
+    }
+}
+
+/// Splitter of an HEVC stream into packets as it arrives, building its
+/// configuration record from the parameter sets before the first packet
+#[derive(Default)]
+struct StreamParser {
+    /// Stream from the start code of the first NAL unit not yet parsed
+    pending: Vec<u8>,
+    /// Position in `pending` up to which start codes have been looked for
+    scanned: usize,
+    parameter_sets: [Option<Vec<u8>>; 3],
+    config_record: Option<Vec<u8>>,
+    sample: Vec<u8>,
+    has_slice: bool,
+    is_keyframe: bool,
+    pts: i64,
+}
+
+impl StreamParser {
+    /// Add the next bytes of the stream, returning the access units
+    /// completed by them
+    fn push(&mut self, data: &[u8]) -> Result<Vec<Packet>> {
+        self.pending.extend_from_slice(data);
+
+        // A NAL unit is complete once the next start code is seen; the one
+        // after the last start code may still continue
+        let mut last = None;
+        let mut i = self.scanned.max(1);
+        while i + 3 <= self.pending.len() {
+            if self.pending[i..i + 3] == [0, 0, 1] {
+                last = Some(i);
+                i += 3;
+            } else {
+                i += 1;
+            }
+        }
+        self.scanned = i;
+
+        let mut packets = Vec::new();
+        if let Some(end) = last {
+            let complete: Vec<u8> = self.pending.drain(..end).collect();
+            self.scanned -= end;
+            for nal in split_annex_b(&complete) {
+                self.add_nal(nal, &mut packets)?;
+            }
+        }
+        Ok(packets)
+    }
+
+    /// Parse the rest of the stream once it has ended, returning the last
+    /// access units
+    fn finish(&mut self) -> Result<Vec<Packet>> {
+        let rest = std::mem::take(&mut self.pending);
+        self.scanned = 0;
+        let mut packets = Vec::new();
+        for nal in split_annex_b(&rest) {
+            self.add_nal(nal, &mut packets)?;
+        }
+        if self.has_slice {
+            packets.push(self.take_packet()?);
+        }
+        if self.config_record.is_none() {
+            self.config_record = Some(self.build_config_record()?);
+        }
+        Ok(packets)
+    }
+
+    /// Add a NAL unit to the access unit being built, completing the
+    /// previous one when it starts a new one
+    fn add_nal(&mut self, nal: &[u8], packets: &mut Vec<Packet>) -> Result<()> {
+        if self.has_slice && starts_access_unit(nal) {
+            packets.push(self.take_packet()?);
+        }
+
+        let kind = nal_type(nal);
+        match kind {
+            NAL_VPS | NAL_SPS | NAL_PPS => {
+                let slot = &mut self.parameter_sets[(kind - NAL_VPS) as usize];
+                if slot.is_none() {
+                    *slot = Some(nal.to_vec());
+                }
+                return Ok(());
+            }
+            NAL_AUD => return Ok(()),
+            0..=31 => {
+                self.has_slice = true;
+                self.is_keyframe |= (NAL_IRAP_FIRST..=NAL_IRAP_LAST).contains(&kind);
+            }
+            _ => {}
+        }
+        self.sample
+            .extend_from_slice(&(nal.len() as u32).to_be_bytes());
+        self.sample.extend_from_slice(nal);
+        Ok(())
+    }
+
+    /// Complete the access unit being built
+    fn take_packet(&mut self) -> Result<Packet> {
+        if self.config_record.is_none() {
+            self.config_record = Some(self.build_config_record()?);
+        }
+        let pts = self.pts;
+        self.pts += 1;
+        self.has_slice = false;
+        Ok(Packet {
+            data: std::mem::take(&mut self.sample),
+            pts,
+            dts: pts,
+            is_keyframe: std::mem::take(&mut self.is_keyframe),
+            duration: 1,
+            alpha: None,
+        })
+    }
+
+    /// Configuration record of the parameter sets seen so far
+    fn build_config_record(&self) -> Result<Vec<u8>> {
+        match &self.parameter_sets {
+            [Some(vps), Some(sps), Some(pps)] => config_record(vps, sps, pps)
+                .ok_or_else(|| Error::Ffmpeg("Invalid HEVC sequence parameter set".to_string())),
+            _ => Err(Error::Ffmpeg(
+                "HEVC stream has no parameter sets".to_string(),
+            )),
+        }
+    }
+}
+
+/// Build an HEVCDecoderConfigurationRecord (`hvcC` payload)
+fn config_record(vps: &[u8], sps: &[u8], pps: &[u8]) -> Option<Vec<u8>> {
+    let info = parse_sps(sps)?;
+
+    let mut record = vec![1]; // configurationVersion
+    record.extend_from_slice(&info.profile_tier_level);
+    record.extend_from_slice(&0xF000u16.to_be_bytes()); // min_spatial_segmentation_idc
+    record.push(0xFC); // parallelismType
+    record.push(0xFC | info.chroma_format_idc);
+    record.push(0xF8 | info.bit_depth_luma_minus8);
+    record.push(0xF8 | info.bit_depth_chroma_minus8);
+    record.extend_from_slice(&0u16.to_be_bytes()); // avgFrameRate
+                                                   // constantFrameRate 0, numTemporalLayers, temporalIdNested, lengthSizeMinusOne 3
+    record.push((info.temporal_layers << 3) | (info.temporal_id_nested << 2) | 3);
+
+    let arrays = [vps, sps, pps];
+    record.push(arrays.len() as u8);
+    for nal in arrays {
+        record.push(0x80 | nal_type(nal)); // array_completeness
+        record.extend_from_slice(&1u16.to_be_bytes());
+        record.extend_from_slice(&(nal.len() as u16).to_be_bytes());
+        record.extend_from_slice(nal);
+    }
+    Some(record)
+}
+
+/// Fields of a sequence parameter set needed for the configuration record
+#[derive(Debug, PartialEq)]
+struct SpsInfo {
+    /// general_profile_space through general_level_idc (12 bytes)
+    profile_tier_level: [u8; 12],
+    temporal_layers: u8,
+    temporal_id_nested: u8,
+    chroma_format_idc: u8,
+    bit_depth_luma_minus8: u8,
+    bit_depth_chroma_minus8: u8,
+}
+
+/// Parse the leading fields of a sequence parameter set NAL unit
+fn parse_sps(nal: &[u8]) -> Option<SpsInfo> {
+    let rbsp = unescape(nal.get(2..)?);
+    let mut reader = BitReader::new(&rbsp);
+
+    reader.bits(4)?; // sps_video_parameter_set_id
+    let max_sub_layers_minus1 = reader.bits(3)?;
+    let temporal_id_nested = reader.bits(1)? as u8;
+
+    let mut profile_tier_level = [0u8; 12];
+    for byte in profile_tier_level.iter_mut() {
+        *byte = reader.bits(8)? as u8;
+    }
+
+    // Sub-layer profile and level presence, then the sub-layer fields
+    let mut present = Vec::new();
+    for _ in 0..max_sub_layers_minus1 {
+        present.push((reader.bits(1)?, reader.bits(1)?));
+    }
+    if max_sub_layers_minus1 > 0 {
+        for _ in max_sub_layers_minus1..8 {
+            reader.bits(2)?;
+        }
+    }
+    for (profile_present, level_present) in present {
+        if profile_present == 1 {
+            reader.skip(88)?;
+        }
+        if level_present == 1 {
+            reader.skip(8)?;
+        }
+    }
+
+    reader.ue()?; // sps_seq_parameter_set_id
+    let chroma_format_idc = reader.ue()?;
+    if chroma_format_idc == 3 {
+        reader.bits(1)?; // separate_colour_plane_flag
+    }
+    reader.ue()?; // pic_width_in_luma_samples
+    reader.ue()?; // pic_height_in_luma_samples
+    if reader.bits(1)? == 1 {
+        // conformance window offsets
+        for _ in 0..4 {
+            reader.ue()?;
+        }
+    }
+    let bit_depth_luma_minus8 = reader.ue()?;
+    let bit_depth_chroma_minus8 = reader.ue()?;
+
+    Some(SpsInfo {
+        profile_tier_level,
+        temporal_layers: max_sub_layers_minus1 as u8 + 1,
+        temporal_id_nested,
+        chroma_format_idc: chroma_format_idc.min(3) as u8,
+        bit_depth_luma_minus8: bit_depth_luma_minus8.min(7) as u8,
+        bit_depth_chroma_minus8: bit_depth_chroma_minus8.min(7) as u8,
+    })
+}
+
+/// Remove emulation prevention bytes (00 00 03 -> 00 00)
+fn unescape(data: &[u8]) -> Vec<u8> {
+    let mut out = Vec::with_capacity(data.len());
+    let mut zeros = 0;
+    for &byte in data {
+        if zeros >= 2 && byte == 3 {
+            zeros = 0;
+            continue;
+        }
+        zeros = if byte == 0 { zeros + 1 } else { 0 };
+        out.push(byte);
+    }
+    out
+}
+
+/// MSB-first bit reader with Exp-Golomb codes
+struct BitReader<'a> {
+    data: &'a [u8],
+    pos: usize,
+}
+
+impl<'a> BitReader<'a> {
+    fn new(data: &'a [u8]) -> Self {
+        Self { data, pos: 0 }
+    }
+
+    fn bits(&mut self, n: u32) -> Option<u32> {
+        let mut value = 0;
+        for _ in 0..n {
+            let byte = *self.data.get(self.pos / 8)?;
+            value = (value << 1) | ((byte >> (7 - self.pos % 8)) & 1) as u32;
+            self.pos += 1;
+        }
+        Some(value)
+    }
+
+    fn skip(&mut self, n: usize) -> Option<()> {
+        self.pos += n;
+        (self.pos <= self.data.len() * 8).then_some(())
+    }
+
+    /// Unsigned Exp-Golomb code
+    fn ue(&mut self) -> Option<u32> {
+        let mut zeros = 0;
+        while self.bits(1)? == 0 {
+            zeros += 1;
+            if zeros > 31 {
+                return None;
+            }
+        }
+        Some((1u32 << zeros) - 1 + self.bits(zeros)?)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Build bytes from (value, bit count) pairs, MSB first
+    fn pack_bits(fields: &[(u32, u32)]) -> Vec<u8> {
+        let mut bits = Vec::new();
+        for &(value, count) in fields {
+            for i in (0..count).rev() {
+                bits.push((value >> i) & 1);
+            }
+        }
+        bits.chunks(8)
+            .map(|chunk| {
+                let mut byte = 0u8;
+                for (i, bit) in chunk.iter().enumerate() {
+                    byte |= (*bit as u8) << (7 - i);
+                }
+                byte
+            })
+            .collect()
+    }
+
+    /// Exp-Golomb code of a value as a (bits, count) field
+    fn ue(value: u32) -> (u32, u32) {
+        let code = value + 1;
+        let len = 32 - code.leading_zeros();
+        (code, len * 2 - 1)
+    }
+
+    fn nal(kind: u8, payload: &[u8]) -> Vec<u8> {
+        let mut data = vec![kind << 1, 1];
+        data.extend_from_slice(payload);
+        data
+    }
+
+    /// Main profile, level 3.1, 4:2:0, 8-bit, 1280x720
+    fn sps() -> Vec<u8> {
+        let mut fields = vec![(0, 4), (0, 3), (1, 1)];
+        fields.push((0x01, 8)); // profile_space 0, tier 0, profile_idc 1
+        fields.push((0x6000_0000, 32)); // compatibility flags
+        fields.push((0x9000, 16)); // constraint flags
+        fields.push((0, 32));
+        fields.push((93, 8)); // level 3.1
+        fields.extend([
+            ue(0),
+            ue(1),
+            ue(1280),
+            ue(720),
+            (0, 1),
+            ue(0),
+            ue(0),
+            (1, 1),
+        ]);
+        nal(NAL_SPS, &pack_bits(&fields))
+    }
+
+    fn annex_b(nals: &[Vec<u8>]) -> Vec<u8> {
+        let mut data = Vec::new();
+        for nal in nals {
+            data.extend_from_slice(&[0, 0, 0, 1]);
+            data.extend_from_slice(nal);
+        }
+        data
+    }
+
+    #[test]
+    fn test_split_annex_b() {
+        let data = [
+            0, 0, 0, 1, 0x40, 1, 7, 0, 0, 1, 0x42, 1, 0, 0, 0, 1, 0x44, 1,
+        ];
+        let nals = split_annex_b(&data);
+        assert_eq!(nals, vec![&[0x40, 1, 7][..], &[0x42, 1], &[0x44, 1]]);
+    }
+
+    #[test]
+    fn test_pixel_format() {
+        assert_eq!(pixel_format("hevc_qsv", "yuv420p"), "nv12");
+        assert_eq!(pixel_format("hevc_nvenc", "yuv420p"), "yuv420p");
+        assert_eq!(pixel_format("hevc_videotoolbox", "yuv420p10le"), "p010le");
+        assert_eq!(pixel_format("libx265", "yuv420p10le"), "yuv420p10le");
+        assert_eq!(pixel_format("libx265", "yuv444p"), "yuv444p");
+        assert_eq!(pixel_format("h264_vaapi", "yuv420p"), "nv12");
+    }
+
+    #[test]
+    fn test_format_args() {
+        let filter = || vec!["-vf".to_string(), "scale".to_string()];
+        assert_eq!(
+            format_args("hevc_nvenc", filter(), "yuv420p"),
+            ["-vf", "scale", "-pix_fmt", "yuv420p"]
+        );
+        assert_eq!(
+            format_args("h264_vaapi", filter(), "nv12"),
+            ["-vf", "scale,format=nv12,hwupload"]
+        );
+        assert_eq!(device_args("hevc_vaapi")[0], "-vaapi_device");
+        assert!(device_args("libx265").is_empty());
+    }
+
+    #[test]
+    fn test_unescape() {
+        assert_eq!(unescape(&[1, 0, 0, 3, 1, 0, 0, 3]), vec![1, 0, 0, 1, 0, 0]);
+    }
+
+    #[test]
+    fn test_parse_sps() {
+        let info = parse_sps(&sps()).unwrap();
+        assert_eq!(info.profile_tier_level[0], 0x01);
+        assert_eq!(info.profile_tier_level[11], 93);
+        assert_eq!(info.temporal_layers, 1);
+        assert_eq!(info.temporal_id_nested, 1);
+        assert_eq!(info.chroma_format_idc, 1);
+        assert_eq!(info.bit_depth_luma_minus8, 0);
+    }
+
+    /// Split a stream given in chunks of `chunk` bytes
+    fn parse(data: &[u8], chunk: usize) -> Result<(Vec<Packet>, Vec<u8>)> {
+        let mut parser = StreamParser::default();
+        let mut packets = Vec::new();
+        for data in data.chunks(chunk) {
+            packets.extend(parser.push(data)?);
+        }
+        packets.extend(parser.finish()?);
+        Ok((packets, parser.config_record.unwrap()))
+    }
+
+    #[test]
+    fn test_parse_stream() {
+        let vps = nal(NAL_VPS, &[0x0C]);
+        let pps = nal(NAL_PPS, &[0xC1]);
+        let idr = nal(19, &[0x80, 0xAA]);
+        let trail = nal(1, &[0x80, 0xBB]);
+        let trail_second_slice = nal(1, &[0x00, 0xCC]);
+        let data = annex_b(&[
+            nal(NAL_AUD, &[0x50]),
+            vps.clone(),
+            sps(),
+            pps.clone(),
+            idr.clone(),
+            nal(NAL_AUD, &[0x50]),
+            trail.clone(),
+            trail_second_slice.clone(),
+        ]);
+
+        let (packets, record) = parse(&data, data.len()).unwrap();
+        assert_eq!(packets.len(), 2);
+        assert!(packets[0].is_keyframe);
+        assert!(!packets[1].is_keyframe);
+        assert_eq!(packets[1].pts, 1);
+
+        // Parameter sets and delimiters are not in the samples
+        let mut expected = (idr.len() as u32).to_be_bytes().to_vec();
+        expected.extend_from_slice(&idr);
+        assert_eq!(packets[0].data, expected);
+        assert_eq!(
+            packets[1].data.len(),
+            8 + trail.len() + trail_second_slice.len()
+        );
+
+        assert_eq!(record[0], 1);
+        assert_eq!(record[1], 0x01);
+        assert_eq!(record[12], 93);
+        assert_eq!(record[21] & 0x03, 3); // 4-byte NAL lengths
+        assert_eq!(record[22], 3); // VPS, SPS, PPS arrays
+        assert_eq!(record[23], 0x80 | NAL_VPS);
+
+        // Start codes and NAL units split across reads give the same packets
+        for chunk in [1, 2, 3, 5] {
+            let (split, split_record) = parse(&data, chunk).unwrap();
+            assert_eq!(split_record, record);
+            assert_eq!(split.len(), packets.len());
+            for (a, b) in split.iter().zip(&packets) {
+                assert_eq!(a.data, b.data);
+                assert_eq!(a.is_keyframe, b.is_keyframe);
+            }
+        }
+    }
+
+    #[test]
+    fn test_parse_stream_incrementally() {
+        let data = annex_b(&[
+            nal(NAL_VPS, &[0x0C]),
+            sps(),
+            nal(NAL_PPS, &[0xC1]),
+            nal(19, &[0x80, 0xAA]),
+        ]);
+        let next = annex_b(&[nal(1, &[0x80, 0xBB]), nal(NAL_AUD, &[0x50])]);
+
+        // An access unit is returned once the first NAL unit of the next one
+        // is complete, with the configuration record available from then on
+        let mut parser = StreamParser::default();
+        assert!(parser.push(&data).unwrap().is_empty());
+        assert!(parser.config_record.is_none());
+        let packets = parser.push(&next).unwrap();
+        assert_eq!(packets.len(), 1);
+        assert!(packets[0].is_keyframe);
+        assert!(parser.config_record.is_some());
+        assert_eq!(parser.finish().unwrap()[0].pts, 1);
+    }
+
+    #[test]
+    fn test_parse_stream_without_parameter_sets() {
+        let data = annex_b(&[nal(19, &[0x80, 0xAA])]);
+        assert!(parse(&data, data.len()).is_err());
+    }
+
+    #[test]
+    fn test_read_output() {
+        let (sender, receiver) = mpsc::channel();
+        read_output(&[1u8, 2, 3][..], sender).unwrap();
+        assert_eq!(receiver.try_iter().flatten().collect::<Vec<_>>(), [1, 2, 3]);
+    }
+}
@@ -0,0 +1,201 @@
+//! Merging of idle (repeated) frames
+//!
+//! Slideshows and screen recordings hold the same picture for many frames.
+//! Instead of encoding each repeat, the frame is encoded once and shown for
+//! several frame periods, which the muxers write as a longer sample
+//! duration. The output plays the same at a fraction of the size.
+
+use super::{Encoder, Frame, Packet};
+use crate::Result;
+
+/// Longest time a merged frame is shown, in seconds
+///
+/// Keeps frame durations within what players and the WebM cluster
+/// timestamps handle comfortably.
+const MAX_IDLE_SECONDS: u32 = 10;
+
+/// Encoder wrapper that merges identical consecutive frames
+///
+/// Packets are held back until the frame after theirs is encoded, because
+/// until then more repeats may extend their duration.
+pub struct IdleFrameEncoder {
+    inner: Box<dyn Encoder>,
+    max_duration: u32,
+    /// Pixels of the last encoded frame
+    last: Option<Vec<u8>>,
+    /// Output frame at which each encoded frame starts
+    starts: Vec<i64>,
+    /// Number of frame periods each encoded frame is shown
+    durations: Vec<u32>,
+    /// Packets whose duration may still grow
+    held: Vec<Packet>,
+    /// Output frame after the last input frame
+    next_start: i64,
+}
+
+impl IdleFrameEncoder {
+    pub fn new(inner: Box<dyn Encoder>, fps: u32) -> Self {
+        Self {
+            inner,
+            max_duration: (fps * MAX_IDLE_SECONDS).max(1),
+            last: None,
+            starts: Vec::new(),
+            durations: Vec::new(),
+            held: Vec::new(),
+            next_start: 0,
+        }
+    }
+
+    /// Release the held packets of frames before `frame`, with their
+    /// durations and output timestamps
+    fn release(&mut self, frame: Option<i64>) -> Vec<Packet> {
+        let count = match frame {
+            Some(frame) => self.held.iter().take_while(|p| p.pts < frame).count(),
+            None => self.held.len(),
+        };
+
+        self.held
+            .drain(..count)
+            .map(|mut packet| {
+                let index = packet.pts as usize;
+                packet.duration = self.durations.get(index).copied().unwrap_or(1);
+                packet.pts = self.starts.get(index).copied().unwrap_or(packet.pts);
+                packet.dts = self
+                    .starts
+                    .get(packet.dts as usize)
+                    .copied()
+                    .unwrap_or(packet.dts);
+                packet
+            })
+            .collect()
+    }
+}
+
+impl Encoder for IdleFrameEncoder {
+    fn name(&self) -> &str {
+        self.inner.name()
+    }
+
+    fn hardware(&self) -> bool {
+        self.inner.hardware()
+    }
+
+    fn encode(&mut self, frame: &Frame) -> Result<Vec<Packet>> {
+        if let (Some(last), Some(duration)) = (&self.last, self.durations.last_mut()) {
+            if *last == frame.data && *duration < self.max_duration {
+                *duration += 1;
+                self.next_start += 1;
+                return Ok(Vec::new());
+            }
+        }
+
+        let packets = self.inner.encode(frame)?;
+        self.starts.push(self.next_start);
+        self.durations.push(1);
+        self.next_start += 1;
+        self.last = Some(frame.data.clone());
+        self.held.extend(packets);
+
+        let current = self.durations.len() as i64 - 1;
+        Ok(self.release(Some(current)))
+    }
+
+    fn flush(&mut self) -> Result<Vec<Packet>> {
+        let packets = self.inner.flush()?;
+        self.held.extend(packets);
+        Ok(self.release(None))
+    }
+
+    fn codec_config(&self) -> Option<Vec<u8>> {
+        self.inner.codec_config()
+    }
+
+    fn pps(&self) -> Option<Vec<u8>> {
+        self.inner.pps()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Encoder emitting one packet per frame, holding the last one until
+    /// the next frame like encoders with lookahead
+    struct DelayedEncoder {
+        count: i64,
+        pending: Option<Packet>,
+    }
+
+    impl Encoder for DelayedEncoder {
+        fn name(&self) -> &str {
+            "delayed"
+        }
+
+        fn encode(&mut self, frame: &Frame) -> Result<Vec<Packet>> {
+            let packet = Packet {
+                data: frame.data.clone(),
+                pts: self.count,
+                dts: self.count,
+                is_keyframe: self.count == 0,
+                duration: 1,
+                alpha: None,
+            };
+            self.count += 1;
+            Ok(self.pending.replace(packet).into_iter().collect())
+        }
+
+        fn flush(&mut self) -> Result<Vec<Packet>> {
+            Ok(self.pending.take().into_iter().collect())
+        }
+    }
+
+    fn frame(value: u8) -> Frame {
+        Frame {
+            width: 1,
+            height: 1,
+            data: vec![value, value, value, 255],
+            pts_ms: 0,
+        }
+    }
+
+    fn encode_all(encoder: &mut IdleFrameEncoder, values: &[u8]) -> Vec<Packet> {
+        let mut packets = Vec::new();
+        for &v in values {
+            packets.extend(encoder.encode(&frame(v)).unwrap());
+        }
+        packets.extend(encoder.flush().unwrap());
+        packets
+    }
+
+    #[test]
+    fn test_merges_repeated_frames() {
+        let inner = DelayedEncoder {
+            count: 0,
+            pending: None,
+        };
+        let mut encoder = IdleFrameEncoder::new(Box::new(inner), 30);
+        let packets = encode_all(&mut encoder, &[1, 1, 1, 2, 3, 3]);
+
+        let summary: Vec<(u8, i64, u32)> = packets
+            .iter()
+            .map(|p| (p.data[0], p.pts, p.duration))
+            .collect();
+        assert_eq!(summary, vec![(1, 0, 3), (2, 3, 1), (3, 4, 2)]);
+        assert_eq!(packets.iter().map(|p| p.duration).sum::<u32>(), 6);
+    }
+
+    #[test]
+    fn test_limits_duration() {
+        let inner = DelayedEncoder {
+            count: 0,
+            pending: None,
+        };
+        // 1 fps allows 10 frame periods per packet
+        let mut encoder = IdleFrameEncoder::new(Box::new(inner), 1);
+        let packets = encode_all(&mut encoder, &[7; 25]);
+
+        let durations: Vec<u32> = packets.iter().map(|p| p.duration).collect();
+        assert_eq!(durations, vec![10, 10, 5]);
+        assert_eq!(packets[2].pts, 20);
+    }
+}
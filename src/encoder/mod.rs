@@ -2,10 +2,25 @@
 
 #[cfg(feature = "av1")]
 pub mod av1;
+pub mod av1_ffmpeg;
 
 pub mod h264;
+pub mod hevc;
+pub mod idle;
+pub mod png;
+pub mod prores;
+pub mod vp8;
+pub mod vp9;
+mod vpx;
 
-use crate::{Codec, Result};
+use crate::colorspace::ColorSpace;
+use crate::hdr::Hdr10;
+use crate::{
+    Av1Backend, BitDepth, Codec, Compression, DynamicRange, EncodeOptions, Error, H264Preset,
+    H264Profile, H264Tune, HwAccel, HwFallback, IdleFrames, LogLevel, PixelFormat, Result,
+    WarningKind,
+};
+use std::path::{Path, PathBuf};
 
 /// Raw video frame in RGBA format
 #[derive(Debug, Clone)]
@@ -31,17 +46,33 @@ pub struct Packet {
     pub dts: i64,
     /// Is this a keyframe?
     pub is_keyframe: bool,
+    /// Number of frame periods the packet is shown (more than 1 when
+    /// repeated frames were merged into it)
+    pub duration: u32,
+    /// Encoded alpha channel of the frame, stored beside the color data
+    /// (VP8 and VP9 with transparency)
+    pub alpha: Option<Vec<u8>>,
 }
 
 /// Video encoder trait
 pub trait Encoder: Send {
+    /// Name of the encoder implementation, such as "libx264" or
+    /// "hevc_nvenc"
+    fn name(&self) -> &str;
+
+    /// Whether the encoder runs on a GPU or media engine rather than the CPU
+    fn hardware(&self) -> bool {
+        false
+    }
+
     /// Encode a frame
     fn encode(&mut self, frame: &Frame) -> Result<Vec<Packet>>;
 
     /// Flush remaining packets
     fn flush(&mut self) -> Result<Vec<Packet>>;
 
-    /// Get the codec-specific configuration data (SPS for H.264)
+    /// Get the codec-specific configuration data (SPS for H.264, `hvcC`
+    /// record for HEVC, sample entry type for ProRes)
     fn codec_config(&self) -> Option<Vec<u8>> {
         None
     }
@@ -52,8 +83,22 @@ pub trait Encoder: Send {
     }
 }
 
+/// Encoder speed preset, trading quality for encoding time
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum Speed {
+    /// Balance of speed and quality
+    #[default]
+    Default,
+    /// About twice as fast
+    Fast,
+    /// About four times as fast
+    Faster,
+    /// As fast as the encoder goes
+    Fastest,
+}
+
 /// Encoder configuration
-#[derive(Debug, Clone)]
+#[derive(Debug, Clone, PartialEq)]
 pub struct EncoderConfig {
     /// Frame width
     pub width: u32,
@@ -63,17 +108,332 @@ pub struct EncoderConfig {
     pub fps: u32,
     /// Quality (0-100)
     pub quality: u8,
+    /// Path to ffmpeg executable for ffmpeg-based encoders (None to search PATH)
+    pub ffmpeg_path: Option<String>,
+    /// Speed preset
+    pub speed: Speed,
+    /// Store the frames without loss (quality is ignored)
+    pub lossless: bool,
+    /// Encode the alpha channel of the frames (VP8 and VP9)
+    pub alpha: bool,
+    /// Pass of a two-pass encode (VP8 and VP9; None for a single pass)
+    pub two_pass: Option<TwoPass>,
+    /// Bitrate limits (all 0 for rate control by quality alone)
+    pub bitrate: Bitrate,
+    /// Frames from one keyframe to the next (0 for the encoder's own
+    /// placement; not honoured by the Windows H.264 encoder)
+    pub keyframe_interval: u32,
+    /// Frames forced to be keyframes besides the interval, in order (not
+    /// honoured by the Windows H.264 encoder)
+    pub forced_keyframes: Vec<u64>,
+    /// Chroma resolution of the encoded frames
+    pub pixel_format: PixelFormat,
+    /// Bits per sample of the encoded frames (ProRes is always 10-bit)
+    pub bit_depth: BitDepth,
+    /// HDR10 metadata of PQ-coded BT.2020 frames (AV1, VP9 and HEVC; None
+    /// for SDR)
+    pub hdr: Option<Hdr10>,
+    /// Color description of the encoded frames, giving the matrix and range
+    /// of the conversion to YUV
+    pub color: ColorSpace,
+    /// Encoder implementation of H.264, HEVC and AV1
+    pub hw_accel: HwAccel,
+    /// Threads of the encoder (0 for the encoder's default, about one per
+    /// core; not honoured by the macOS and Windows H.264 encoders)
+    pub threads: u32,
+    /// Software encoder of AV1
+    pub av1_backend: Av1Backend,
+    /// Speed preset of the AV1 backend (0 for the preset of `speed`)
+    pub av1_preset: u8,
+    /// Profile of H.264
+    pub h264_profile: H264Profile,
+    /// Level of H.264, times ten (0 for the encoder's choice)
+    pub h264_level: u8,
+    /// Speed preset of libx264 (Auto for the preset of `speed`)
+    pub h264_preset: H264Preset,
+    /// Tuning of libx264
+    pub h264_tune: H264Tune,
+    /// Extra arguments of ffmpeg-based encoders, after their own
+    pub ffmpeg_args: Vec<String>,
+}
+
+impl EncoderConfig {
+    /// Whether frame `n` must be a keyframe
+    pub fn forces_keyframe(&self, n: u64) -> bool {
+        n.checked_rem(self.keyframe_interval as u64) == Some(0)
+            || self.forced_keyframes.binary_search(&n).is_ok()
+    }
+
+    /// ffmpeg arguments placing a keyframe every `keyframe_interval` frames
+    /// and on the forced frames (empty for the encoder's own placement)
+    pub fn keyframe_args(&self) -> Vec<String> {
+        let mut args = Vec::new();
+        let mut terms = Vec::new();
+        if self.keyframe_interval > 0 {
+            args.extend(["-g".to_string(), self.keyframe_interval.to_string()]);
+            terms.push(format!("eq(mod(n,{}),0)", self.keyframe_interval));
+        }
+        terms.extend(self.forced_keyframes.iter().map(|n| format!("eq(n,{})", n)));
+        if !terms.is_empty() {
+            args.extend([
+                "-force_key_frames".to_string(),
+                format!("expr:{}", terms.join("+")),
+            ]);
+        }
+        args
+    }
+
+    /// ffmpeg arguments limiting the threads of the encoder (empty for its
+    /// default)
+    pub fn thread_args(&self) -> Vec<String> {
+        match self.threads {
+            0 => Vec::new(),
+            threads => vec!["-threads".to_string(), threads.to_string()],
+        }
+    }
+
+    /// Chroma resolution of the encoded frames with Auto resolved: 4:4:4
+    /// when lossless, 4:2:0 otherwise
+    pub fn chroma(&self) -> PixelFormat {
+        match self.pixel_format {
+            PixelFormat::Auto if self.lossless => PixelFormat::Yuv444,
+            PixelFormat::Auto => PixelFormat::Yuv420,
+            format => format,
+        }
+    }
+
+    /// ffmpeg pixel format of the encoded frames (`yuv420p10le` for 10-bit
+    /// 4:2:0)
+    pub fn ffmpeg_pixel_format(&self) -> String {
+        let format = match self.chroma() {
+            PixelFormat::Yuv422 => "yuv422p",
+            PixelFormat::Yuv444 => "yuv444p",
+            _ => "yuv420p",
+        };
+        match self.bit_depth {
+            BitDepth::Eight => format.to_string(),
+            BitDepth::Ten => format!("{}10le", format),
+        }
+    }
+}
+
+/// Bitrate limits of an encode
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub struct Bitrate {
+    /// Average bitrate in kbit/s, replacing the quality (0 for none)
+    pub target_kbps: u32,
+    /// Peak bitrate in kbit/s (0 for none)
+    pub max_kbps: u32,
+    /// Size of the decoder buffer the peak is measured over in kbit (0 for
+    /// two seconds at the peak bitrate)
+    pub buffer_kbits: u32,
+}
+
+impl Bitrate {
+    /// Whether an average or peak bitrate is set
+    pub fn is_set(&self) -> bool {
+        self.target_kbps > 0 || self.max_kbps > 0
+    }
+
+    /// Decoder buffer size in kbit
+    pub fn buffer_size(&self) -> u32 {
+        if self.buffer_kbits > 0 {
+            self.buffer_kbits
+        } else {
+            self.max_kbps.saturating_mul(2)
+        }
+    }
+
+    /// ffmpeg arguments limiting the peak bitrate (empty without a peak)
+    pub fn limit_args(&self) -> Vec<String> {
+        if self.max_kbps == 0 {
+            return Vec::new();
+        }
+        vec![
+            "-maxrate".to_string(),
+            format!("{}k", self.max_kbps),
+            "-bufsize".to_string(),
+            format!("{}k", self.buffer_size()),
+        ]
+    }
+}
+
+/// Pass of a two-pass encode
+#[derive(Debug, Clone, PartialEq)]
+pub struct TwoPass {
+    /// 1 for the pass gathering statistics, whose packets are discarded, or
+    /// 2 for the pass using them
+    pub pass: u8,
+    /// Prefix of the statistics files
+    pub stats_prefix: PathBuf,
+}
+
+/// Statistics files of a two-pass encode, removed when dropped
+pub struct PassStats {
+    prefix: PathBuf,
+}
+
+impl PassStats {
+    /// Statistics files named with a prefix, such as the output path
+    /// followed by `.pass`
+    pub fn new(prefix: PathBuf) -> Self {
+        Self { prefix }
+    }
+
+    /// Settings of one of the passes
+    pub fn pass(&self, pass: u8) -> TwoPass {
+        TwoPass {
+            pass,
+            stats_prefix: self.prefix.clone(),
+        }
+    }
+}
+
+impl Drop for PassStats {
+    fn drop(&mut self) {
+        // Encoders name their files after the prefix
+        let (Some(dir), Some(prefix)) = (self.prefix.parent(), self.prefix.file_name()) else {
+            return;
+        };
+        let dir = if dir.as_os_str().is_empty() {
+            Path::new(".")
+        } else {
+            dir
+        };
+        let Ok(entries) = std::fs::read_dir(dir) else {
+            return;
+        };
+        for entry in entries.flatten() {
+            if entry
+                .file_name()
+                .as_encoded_bytes()
+                .starts_with(prefix.as_encoded_bytes())
+            {
+                let _ = std::fs::remove_file(entry.path());
+            }
+        }
+    }
+}
+
+/// Whether an ffmpeg encoder is a hardware one, named after its API
+/// (`hevc_nvenc`) rather than its library (`libx265`)
+pub(crate) fn is_hardware_encoder(encoder: &str) -> bool {
+    encoder.contains('_')
+}
+
+/// ffmpeg encoder of a hardware encoder API for H.264 or HEVC
+pub(crate) fn hardware_encoder(codec: Codec, hw_accel: HwAccel) -> Option<&'static str> {
+    Some(match (codec, hw_accel) {
+        (Codec::H264, HwAccel::VideoToolbox) => "h264_videotoolbox",
+        (Codec::H264, HwAccel::Nvenc) => "h264_nvenc",
+        (Codec::H264, HwAccel::Qsv) => "h264_qsv",
+        (Codec::H264, HwAccel::Vaapi) => "h264_vaapi",
+        (Codec::H264, HwAccel::Amf) => "h264_amf",
+        (Codec::Hevc, HwAccel::VideoToolbox) => "hevc_videotoolbox",
+        (Codec::Hevc, HwAccel::Nvenc) => "hevc_nvenc",
+        (Codec::Hevc, HwAccel::Qsv) => "hevc_qsv",
+        (Codec::Hevc, HwAccel::Vaapi) => "hevc_vaapi",
+        (Codec::Hevc, HwAccel::Amf) => "hevc_amf",
+        (Codec::Av1, HwAccel::Nvenc) => "av1_nvenc",
+        (Codec::Av1, HwAccel::Qsv) => "av1_qsv",
+        (Codec::Av1, HwAccel::Amf) => "av1_amf",
+        _ => return None,
+    })
+}
+
+/// Run an encode, and when its hardware encoder is unavailable or fails,
+/// run it again with the software encoder if the options fall back
+///
+/// The handler of the options, if any, is called before the second run.
+pub(crate) fn with_software_fallback<T>(
+    options: &EncodeOptions,
+    encode: impl Fn(&EncodeOptions) -> Result<T>,
+) -> Result<T> {
+    match encode(options) {
+        // Errors of inputs and outputs would fail the software encode too
+        Err(err)
+            if options.hw_fallback == HwFallback::Software
+                && may_use_hardware(options)
+                && matches!(
+                    err,
+                    Error::CodecUnavailable(_)
+                        | Error::Encode(_)
+                        | Error::Ffmpeg(_)
+                        | Error::FfmpegFailure { .. }
+                ) =>
+        {
+            options.log(
+                LogLevel::Warn,
+                "falling back to the software encoder",
+                vec![("error", err.to_string().into())],
+            );
+            if let Some(handler) = &options.on_fallback {
+                handler.call(&err);
+            }
+            options.warn(
+                WarningKind::HardwareFallback,
+                format!("Hardware encoder failed, encoding in software: {}", err),
+            );
+            let software = EncodeOptions {
+                hw_accel: HwAccel::Software,
+                ..options.clone()
+            };
+            encode(&software)
+        }
+        result => result,
+    }
+}
+
+/// Whether the video of the options may be encoded by a hardware encoder
+fn may_use_hardware(options: &EncodeOptions) -> bool {
+    let hardware = match options.encoder_hw_accel() {
+        HwAccel::Software => false,
+        // The platform's choice of H.264 encoder on Linux is libx264
+        HwAccel::Auto => {
+            options.codec == Codec::Hevc
+                || (options.codec == Codec::H264 && !cfg!(target_os = "linux"))
+        }
+        _ => true,
+    };
+    // Other video only has software encoders
+    hardware
+        && options.compression == Compression::Lossy
+        && options.dynamic_range == DynamicRange::Sdr
+        && matches!(
+            options.pixel_format,
+            PixelFormat::Auto | PixelFormat::Yuv420
+        )
 }
 
 /// Create an encoder for the specified codec
 pub fn create_encoder(codec: Codec, config: EncoderConfig) -> Result<Box<dyn Encoder>> {
     match codec {
+        // Hardware encoders and the other backends run in ffmpeg
+        Codec::Av1 if config.hw_accel.is_hardware() || config.av1_backend != Av1Backend::Rav1e => {
+            Ok(Box::new(av1_ffmpeg::FfmpegAv1Encoder::new(config)?))
+        }
         #[cfg(feature = "av1")]
-        Codec::Av1 => Ok(Box::new(av1::Av1Encoder::new(config)?)),
+        Codec::Av1 => match config.bit_depth {
+            BitDepth::Eight => Ok(Box::new(av1::Av1Encoder::<u8>::new(config)?)),
+            BitDepth::Ten => Ok(Box::new(av1::Av1Encoder::<u16>::new(config)?)),
+        },
         #[cfg(not(feature = "av1"))]
         Codec::Av1 => Err(crate::Error::CodecUnavailable(
             "AV1 support not compiled in".to_string(),
         )),
         Codec::H264 => h264::create_encoder(config),
+        Codec::Png => Ok(Box::new(png::PngEncoder::new(config)?)),
+        Codec::Vp9 => Ok(Box::new(vp9::Vp9Encoder::new(config)?)),
+        Codec::Hevc => Ok(Box::new(hevc::HevcEncoder::new(config)?)),
+        Codec::Vp8 => Ok(Box::new(vp8::Vp8Encoder::new(config)?)),
+        Codec::Prores => Ok(Box::new(prores::ProresEncoder::new(config)?)),
+    }
+}
+
+/// Wrap an encoder to merge repeated frames if requested
+pub fn with_idle_frames(encoder: Box<dyn Encoder>, mode: IdleFrames, fps: u32) -> Box<dyn Encoder> {
+    match mode {
+        IdleFrames::Keep => encoder,
+        IdleFrames::Merge => Box::new(idle::IdleFrameEncoder::new(encoder, fps)),
     }
 }
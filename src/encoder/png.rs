@@ -0,0 +1,151 @@
+//! PNG frame encoder for APNG output
+//!
+//! Each frame is compressed losslessly with its alpha channel. Packets carry
+//! the zlib stream that goes into the frame's `IDAT`/`fdAT` chunks.
+
+use super::{Encoder, EncoderConfig, Frame, Packet, Speed};
+use crate::{Error, Result};
+use image::codecs::png::{CompressionType, FilterType, PngEncoder as ImagePngEncoder};
+use image::{ExtendedColorType, ImageEncoder};
+
+/// PNG signature preceding the first chunk
+const PNG_SIGNATURE_LEN: usize = 8;
+
+/// PNG frame encoder
+pub struct PngEncoder {
+    config: EncoderConfig,
+    compression: CompressionType,
+    frame_count: i64,
+    /// Last input frame and its encoded data, reused for repeated frames
+    last: Option<(Vec<u8>, Vec<u8>)>,
+}
+
+impl PngEncoder {
+    /// Create a new PNG encoder
+    ///
+    /// PNG is lossless, so quality only selects the compression effort:
+    /// low values favor speed, high values favor smaller files. Faster speed
+    /// presets lower the effort too.
+    pub fn new(config: EncoderConfig) -> Result<Self> {
+        let compression = match (config.quality, config.speed) {
+            (_, Speed::Faster | Speed::Fastest) | (0..=20, _) => CompressionType::Fast,
+            (21..=79, _) | (_, Speed::Fast) => CompressionType::Default,
+            _ => CompressionType::Best,
+        };
+
+        Ok(Self {
+            config,
+            compression,
+            frame_count: 0,
+            last: None,
+        })
+    }
+}
+
+impl Encoder for PngEncoder {
+    fn name(&self) -> &str {
+        "png"
+    }
+
+    fn encode(&mut self, frame: &Frame) -> Result<Vec<Packet>> {
+        if frame.width != self.config.width || frame.height != self.config.height {
+            return Err(Error::Encode(format!(
+                "Frame size {}x{} does not match encoder size {}x{}",
+                frame.width, frame.height, self.config.width, self.config.height
+            )));
+        }
+
+        let data = match &self.last {
+            Some((input, encoded)) if *input == frame.data => encoded.clone(),
+            _ => {
+                let mut png = Vec::new();
+                ImagePngEncoder::new_with_quality(&mut png, self.compression, FilterType::Adaptive)
+                    .write_image(
+                        &frame.data,
+                        frame.width,
+                        frame.height,
+                        ExtendedColorType::Rgba8,
+                    )?;
+
+                let encoded = idat_payload(&png).ok_or_else(|| {
+                    Error::Encode("PNG encoder produced no image data".to_string())
+                })?;
+                self.last = Some((frame.data.clone(), encoded.clone()));
+                encoded
+            }
+        };
+
+        let pts = self.frame_count;
+        self.frame_count += 1;
+
+        Ok(vec![Packet {
+            data,
+            pts,
+            dts: pts,
+            is_keyframe: true,
+            duration: 1,
+            alpha: None,
+        }])
+    }
+
+    fn flush(&mut self) -> Result<Vec<Packet>> {
+        Ok(Vec::new())
+    }
+}
+
+/// Concatenate the data of all `IDAT` chunks of a PNG file
+fn idat_payload(png: &[u8]) -> Option<Vec<u8>> {
+    let mut data = Vec::new();
+    let mut pos = PNG_SIGNATURE_LEN;
+
+    while pos + 8 <= png.len() {
+        let len = u32::from_be_bytes(png[pos..pos + 4].try_into().ok()?) as usize;
+        let kind = &png[pos + 4..pos + 8];
+        let body = png.get(pos + 8..pos + 8 + len)?;
+
+        if kind == b"IDAT" {
+            data.extend_from_slice(body);
+        }
+
+        // Chunk header, body and CRC
+        pos += 12 + len;
+    }
+
+    if data.is_empty() {
+        None
+    } else {
+        Some(data)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn chunk(kind: &[u8; 4], body: &[u8]) -> Vec<u8> {
+        let mut c = (body.len() as u32).to_be_bytes().to_vec();
+        c.extend_from_slice(kind);
+        c.extend_from_slice(body);
+        c.extend_from_slice(&[0; 4]);
+        c
+    }
+
+    #[test]
+    fn test_idat_payload() {
+        let mut png = vec![0x89, b'P', b'N', b'G', 0x0D, 0x0A, 0x1A, 0x0A];
+        png.extend(chunk(b"IHDR", &[0; 13]));
+        png.extend(chunk(b"IDAT", &[1, 2]));
+        png.extend(chunk(b"IDAT", &[3]));
+        png.extend(chunk(b"IEND", &[]));
+
+        assert_eq!(idat_payload(&png), Some(vec![1, 2, 3]));
+    }
+
+    #[test]
+    fn test_idat_payload_missing() {
+        let mut png = vec![0x89, b'P', b'N', b'G', 0x0D, 0x0A, 0x1A, 0x0A];
+        png.extend(chunk(b"IEND", &[]));
+
+        assert_eq!(idat_payload(&png), None);
+    }
+}
@@ -0,0 +1,266 @@
+//! Apple ProRes encoder using ffmpeg (prores_ks) external process
+//!
+//! ProRes is an intra-only editing codec, so every frame is a keyframe and
+//! editors can cut anywhere without re-encoding. ffmpeg writes the raw
+//! frames back to back; each starts with its size and the `icpf` marker,
+//! which MOV stores as part of the sample. Frames are split from the
+//! output as it arrives, so the muxer can write them while encoding.
+
+use super::{Encoder, EncoderConfig, Frame, Packet};
+use crate::ffmpeg::{self, find_ffmpeg, ProcessLog};
+use crate::{Error, PixelFormat, Result};
+use std::io::{ErrorKind, Read, Write};
+use std::process::{Child, ChildStdin, Command, Stdio};
+use std::sync::mpsc::{self, Receiver, Sender};
+use std::thread::JoinHandle;
+
+/// Size of the frame header fields read here (frame size and `icpf`)
+const FRAME_HEADER_SIZE: usize = 8;
+
+/// FFmpeg-based ProRes encoder
+pub struct ProresEncoder {
+    process: Child,
+    log: ProcessLog,
+    stdin: Option<ChildStdin>,
+    reader: Option<JoinHandle<Result<()>>>,
+    packets: Receiver<Packet>,
+    profile: Profile,
+}
+
+/// ProRes profile with its prores_ks number and MOV sample entry type
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+struct Profile {
+    number: u8,
+    fourcc: [u8; 4],
+}
+
+impl Profile {
+    /// Profile for a quality (0-100): Proxy, LT, 422 or 422 HQ
+    fn from_quality(quality: u8) -> Self {
+        let (number, fourcc) = match quality {
+            0..=24 => (0, *b"apco"),
+            25..=49 => (1, *b"apcs"),
+            50..=79 => (2, *b"apcn"),
+            _ => (3, *b"apch"),
+        };
+        Self { number, fourcc }
+    }
+
+    /// ProRes 4444, for full chroma resolution at any quality
+    const PRORES_4444: Self = Self {
+        number: 4,
+        fourcc: *b"ap4h",
+    };
+}
+
+impl ProresEncoder {
+    pub fn new(config: EncoderConfig) -> Result<Self> {
+        let ffmpeg = find_ffmpeg(config.ffmpeg_path.as_deref())?;
+        let (profile, pixel_format) = match config.pixel_format {
+            PixelFormat::Yuv444 => (Profile::PRORES_4444, "yuv444p10le"),
+            _ => (Profile::from_quality(config.quality), "yuv422p10le"),
+        };
+
+        let (mut process, log) = ProcessLog::spawn(
+            Command::new(&ffmpeg)
+                .args([
+                    "-hide_banner",
+                    "-f",
+                    "rawvideo",
+                    "-pix_fmt",
+                    "rgba",
+                    "-s",
+                    &format!("{}x{}", config.width, config.height),
+                    "-r",
+                    &config.fps.to_string(),
+                    "-i",
+                    "pipe:0",
+                    "-c:v",
+                    "prores_ks",
+                    "-profile:v",
+                    &profile.number.to_string(),
+                    "-vendor",
+                    "apl0",
+                ])
+                .args(config.thread_args())
+                .args(config.color.ffmpeg_args())
+                .args(["-pix_fmt", pixel_format])
+                .args(&config.ffmpeg_args)
+                .args(["-f", "rawvideo", "pipe:1"])
+                .stdin(Stdio::piped())
+                .stdout(Stdio::piped()),
+        )?;
+
+        let stdin = process.stdin.take();
+        let stdout = process
+            .stdout
+            .take()
+            .ok_or_else(|| Error::Ffmpeg("FFmpeg stdout not available".to_string()))?;
+
+        // Drain the output while frames are written so the pipe never fills
+        let (sender, packets) = mpsc::channel();
+        let reader = std::thread::spawn(move || read_frames(stdout, sender));
+
+        Ok(Self {
+            process,
+            log,
+            stdin,
+            reader: Some(reader),
+            packets,
+            profile,
+        })
+    }
+}
+
+impl Encoder for ProresEncoder {
+    fn name(&self) -> &str {
+        "prores_ks"
+    }
+
+    fn encode(&mut self, frame: &Frame) -> Result<Vec<Packet>> {
+        let stdin = self
+            .stdin
+            .as_mut()
+            .ok_or_else(|| Error::Ffmpeg("FFmpeg stdin not available".to_string()))?;
+
+        if let Err(e) = stdin.write_all(&frame.data) {
+            // ffmpeg closes its input when it fails
+            let error = Error::Ffmpeg(format!("Failed to write frame: {}", e));
+            return Err(self.log.error(&mut self.process, error));
+        }
+
+        Ok(self.packets.try_iter().collect())
+    }
+
+    fn flush(&mut self) -> Result<Vec<Packet>> {
+        // Close stdin to signal end of input
+        drop(self.stdin.take());
+
+        if let Some(reader) = self.reader.take() {
+            reader
+                .join()
+                .map_err(|_| Error::Ffmpeg("FFmpeg output reader panicked".to_string()))??;
+        }
+
+        let status = self
+            .process
+            .wait()
+            .map_err(|e| Error::Ffmpeg(format!("FFmpeg process error: {}", e)))?;
+        if !status.success() {
+            let error = Error::Ffmpeg(format!("FFmpeg ProRes encoding failed with {}", status));
+            return Err(self.log.error(&mut self.process, error));
+        }
+
+        Ok(self.packets.try_iter().collect())
+    }
+
+    /// The MOV sample entry type of the profile (`apch` for 422 HQ)
+    fn codec_config(&self) -> Option<Vec<u8>> {
+        Some(self.profile.fourcc.to_vec())
+    }
+}
+
+impl Drop for ProresEncoder {
+    fn drop(&mut self) {
+        // Kill the process if it's still running
+        let _ = self.process.kill();
+        let _ = self.process.wait();
+    }
+}
+
+/// Read ProRes frames from ffmpeg's output, sending one packet per frame
+fn read_frames<R: Read>(mut input: R, sender: Sender<Packet>) -> Result<()> {
+    let mut pts = 0;
+    loop {
+        let mut header = [0u8; FRAME_HEADER_SIZE];
+        match input.read_exact(&mut header) {
+            Ok(()) => {}
+            Err(e) if e.kind() == ErrorKind::UnexpectedEof && pts == 0 => {
+                return Err(Error::Ffmpeg("No ProRes output from ffmpeg".to_string()))
+            }
+            Err(e) if e.kind() == ErrorKind::UnexpectedEof => return Ok(()),
+            Err(e) => return Err(Error::Ffmpeg(format!("Failed to read output: {}", e))),
+        }
+
+        let size = u32::from_be_bytes([header[0], header[1], header[2], header[3]]) as usize;
+        if &header[4..8] != b"icpf" || size < FRAME_HEADER_SIZE {
+            return Err(Error::Encode("Invalid ProRes frame header".to_string()));
+        }
+        let mut data = vec![0u8; size];
+        data[..FRAME_HEADER_SIZE].copy_from_slice(&header);
+        input
+            .read_exact(&mut data[FRAME_HEADER_SIZE..])
+            .map_err(|_| Error::Encode("Truncated ProRes frame".to_string()))?;
+
+        let packet = Packet {
+            data,
+            pts,
+            dts: pts,
+            is_keyframe: true,
+            duration: 1,
+            alpha: None,
+        };
+        if sender.send(packet).is_err() {
+            // The encoder was dropped
+            return Ok(());
+        }
+        pts += 1;
+    }
+}
+
+/// Check if ffmpeg with ProRes support is available
+pub fn check_available(ffmpeg_path: Option<&str>) -> Result<()> {
+    ffmpeg::check_encoder(ffmpeg_path, "prores_ks")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn frame(payload: &[u8]) -> Vec<u8> {
+        let mut data = ((payload.len() + FRAME_HEADER_SIZE) as u32)
+            .to_be_bytes()
+            .to_vec();
+        data.extend_from_slice(b"icpf");
+        data.extend_from_slice(payload);
+        data
+    }
+
+    fn read(stream: &[u8]) -> Result<Vec<Packet>> {
+        let (sender, receiver) = mpsc::channel();
+        read_frames(stream, sender)?;
+        Ok(receiver.try_iter().collect())
+    }
+
+    #[test]
+    fn test_read_frames() {
+        let stream = [frame(&[1, 2, 3]), frame(&[4])].concat();
+        let packets = read(&stream).unwrap();
+        assert_eq!(packets.len(), 2);
+        assert_eq!(packets[0].data, frame(&[1, 2, 3]));
+        assert_eq!(packets[1].pts, 1);
+        assert!(packets.iter().all(|p| p.is_keyframe));
+    }
+
+    #[test]
+    fn test_read_frames_invalid() {
+        assert!(read(&[]).is_err());
+        assert!(read(&frame(&[1])[..6]).is_err());
+
+        let mut stream = frame(&[1]);
+        stream[4..8].copy_from_slice(b"xxxx");
+        assert!(read(&stream).is_err());
+
+        let mut stream = frame(&[1, 2]);
+        stream.truncate(9);
+        assert!(read(&stream).is_err());
+    }
+
+    #[test]
+    fn test_profile_from_quality() {
+        assert_eq!(Profile::from_quality(0).fourcc, *b"apco");
+        assert_eq!(Profile::from_quality(30).number, 1);
+        assert_eq!(Profile::from_quality(50).fourcc, *b"apcn");
+        assert_eq!(Profile::from_quality(100).fourcc, *b"apch");
+    }
+}
@@ -0,0 +1,95 @@
+//! VP8 encoder using ffmpeg (libvpx) external process
+//!
+//! VP8 compresses worse than VP9 and AV1 but decodes in older browsers and
+//! embedded web views that support neither.
+
+use super::vpx::VpxProcess;
+use super::{Encoder, EncoderConfig, Frame, Packet, Speed};
+use crate::{ffmpeg, Result};
+
+/// Start code following the frame tag of VP8 keyframes
+const KEYFRAME_START_CODE: [u8; 3] = [0x9d, 0x01, 0x2a];
+
+/// FFmpeg-based VP8 encoder
+pub struct Vp8Encoder {
+    process: VpxProcess,
+}
+
+impl Vp8Encoder {
+    pub fn new(config: EncoderConfig) -> Result<Self> {
+        // Map quality (0-100) to CRF (63-4); VP8 needs a bitrate as the
+        // upper bound of its constant quality mode
+        let crf = 4 + ((100 - config.quality.min(100)) as u32 * 59) / 100;
+
+        // The realtime deadline allows the fastest cpu-used levels
+        let (deadline, cpu_used) = match config.speed {
+            Speed::Default => ("good", "1"),
+            Speed::Fast => ("good", "3"),
+            Speed::Faster => ("realtime", "8"),
+            Speed::Fastest => ("realtime", "16"),
+        };
+
+        // Alternate reference frames are not shown, so they are disabled to
+        // keep one IVF frame per input frame
+        let process = VpxProcess::spawn(
+            "VP8",
+            &config,
+            &[
+                "-c:v",
+                "libvpx",
+                "-deadline",
+                deadline,
+                "-cpu-used",
+                cpu_used,
+                "-auto-alt-ref",
+                "0",
+            ],
+            Some(crf),
+            "20M",
+            "yuv420p",
+            is_keyframe,
+        )?;
+
+        Ok(Self { process })
+    }
+}
+
+impl Encoder for Vp8Encoder {
+    fn name(&self) -> &str {
+        "libvpx"
+    }
+
+    fn encode(&mut self, frame: &Frame) -> Result<Vec<Packet>> {
+        self.process.encode(frame)
+    }
+
+    fn flush(&mut self) -> Result<Vec<Packet>> {
+        self.process.flush()
+    }
+}
+
+/// Check if a VP8 frame is a keyframe
+fn is_keyframe(data: &[u8]) -> bool {
+    // frame_type is the lowest bit of the frame tag (0 for keyframes)
+    data.len() >= 6 && data[0] & 1 == 0 && data[3..6] == KEYFRAME_START_CODE
+}
+
+/// Check if ffmpeg with VP8 support is available
+pub fn check_available(ffmpeg_path: Option<&str>) -> Result<()> {
+    ffmpeg::check_encoder(ffmpeg_path, "libvpx")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_is_keyframe() {
+        assert!(is_keyframe(&[0x50, 0x42, 0x00, 0x9d, 0x01, 0x2a, 0x40]));
+        // Inter frame
+        assert!(!is_keyframe(&[0x51, 0x42, 0x00, 0x9d, 0x01, 0x2a]));
+        // Missing start code
+        assert!(!is_keyframe(&[0x50, 0x42, 0x00, 0x00, 0x00, 0x00]));
+        assert!(!is_keyframe(&[0x50]));
+    }
+}
@@ -0,0 +1,111 @@
+//! VP9 encoder using ffmpeg (libvpx-vp9) external process
+
+use super::vpx::VpxProcess;
+use super::{Encoder, EncoderConfig, Frame, Packet, Speed};
+use crate::{ffmpeg, Result};
+
+/// FFmpeg-based VP9 encoder
+pub struct Vp9Encoder {
+    process: VpxProcess,
+}
+
+impl Vp9Encoder {
+    pub fn new(config: EncoderConfig) -> Result<Self> {
+        // Map quality (0-100) to CRF (63-0); lossless keeps full chroma
+        // resolution unless another is set. libvpx picks the profile from
+        // the pixel format (1 for 4:2:2 and 4:4:4, 2 and 3 for 10-bit).
+        let crf = ((100 - config.quality.min(100)) as u32 * 63) / 100;
+        let crf = (!config.lossless).then_some(crf);
+        let pixel_format = config.ffmpeg_pixel_format();
+
+        // The realtime deadline allows the fastest cpu-used levels
+        let (deadline, cpu_used) = match config.speed {
+            Speed::Default => ("good", "4"),
+            Speed::Fast => ("good", "5"),
+            Speed::Faster => ("realtime", "7"),
+            Speed::Fastest => ("realtime", "8"),
+        };
+
+        let process = VpxProcess::spawn(
+            "VP9",
+            &config,
+            &[
+                "-c:v",
+                "libvpx-vp9",
+                "-deadline",
+                deadline,
+                "-cpu-used",
+                cpu_used,
+                "-row-mt",
+                "1",
+            ],
+            crf,
+            "0",
+            &pixel_format,
+            is_keyframe,
+        )?;
+
+        Ok(Self { process })
+    }
+}
+
+impl Encoder for Vp9Encoder {
+    fn name(&self) -> &str {
+        "libvpx-vp9"
+    }
+
+    fn encode(&mut self, frame: &Frame) -> Result<Vec<Packet>> {
+        self.process.encode(frame)
+    }
+
+    fn flush(&mut self) -> Result<Vec<Packet>> {
+        self.process.flush()
+    }
+}
+
+/// Check if a VP9 frame (or the first frame of a superframe) is a keyframe
+fn is_keyframe(data: &[u8]) -> bool {
+    let byte = match data.first() {
+        Some(&byte) => byte,
+        None => return false,
+    };
+
+    // frame_marker (2), profile_low_bit, profile_high_bit
+    if byte >> 6 != 2 {
+        return false;
+    }
+    let profile = ((byte >> 5) & 1) | (((byte >> 4) & 1) << 1);
+
+    // Profile 3 has a reserved zero bit before show_existing_frame
+    let shift = if profile == 3 { 2 } else { 3 };
+    let show_existing_frame = (byte >> shift) & 1;
+    let frame_type = (byte >> (shift - 1)) & 1;
+    show_existing_frame == 0 && frame_type == 0
+}
+
+/// Check if ffmpeg with VP9 support is available
+pub fn check_available(ffmpeg_path: Option<&str>) -> Result<()> {
+    ffmpeg::check_encoder(ffmpeg_path, "libvpx-vp9")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_is_keyframe() {
+        // Profile 0: marker 10, profile 00, show_existing 0, frame_type 0
+        assert!(is_keyframe(&[0b1000_0010]));
+        // Profile 0 inter frame
+        assert!(!is_keyframe(&[0b1000_0110]));
+        // Profile 0 shown existing frame
+        assert!(!is_keyframe(&[0b1000_1000]));
+        // Profile 3 keyframe: marker 10, profile 11, reserved 0, show_existing 0, frame_type 0
+        assert!(is_keyframe(&[0b1011_0000]));
+        // Profile 3 inter frame
+        assert!(!is_keyframe(&[0b1011_0010]));
+        // Not a VP9 frame
+        assert!(!is_keyframe(&[0x00]));
+        assert!(!is_keyframe(&[]));
+    }
+}
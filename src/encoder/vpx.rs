@@ -0,0 +1,503 @@
+//! Shared ffmpeg process handling of the VP8 and VP9 encoders, whose IVF
+//! output the ffmpeg AV1 encoders read as well
+//!
+//! Frames are piped to ffmpeg as raw RGBA and read back as an IVF stream,
+//! which frames each compressed picture with its size. A reader thread
+//! drains ffmpeg's output while frames are written, so neither pipe fills up.
+//! With an alpha channel, a second process encodes the alpha plane. In
+//! two-pass encodes each stream keeps its own statistics file.
+//! Bitrate limits and extra ffmpeg arguments apply to the color stream; the
+//! alpha plane, which is mostly flat, keeps constant quality.
+
+use super::{Bitrate, EncoderConfig, Frame, Packet};
+use crate::ffmpeg::{find_ffmpeg, path_arg, ProcessLog};
+use crate::{Error, Result};
+use std::collections::VecDeque;
+use std::io::{ErrorKind, Read, Write};
+use std::process::{Child, ChildStdin, Command, Stdio};
+use std::sync::mpsc::{self, Receiver, Sender};
+use std::thread::JoinHandle;
+
+/// Size of the IVF file header
+const IVF_HEADER_SIZE: usize = 32;
+
+/// Size of the IVF frame header (frame size and timestamp)
+const IVF_FRAME_HEADER_SIZE: usize = 12;
+
+/// Check if a compressed frame is a keyframe
+pub(super) type KeyframeCheck = fn(&[u8]) -> bool;
+
+/// Keyframe interval in seconds of encodes with an alpha channel
+///
+/// The color and alpha streams must place keyframes on the same frames, so
+/// both use a fixed interval instead of libvpx's content-based placement.
+const ALPHA_KEYFRAME_INTERVAL_SECONDS: u32 = 4;
+
+/// libvpx encoder, with a second ffmpeg process encoding the alpha channel
+/// when transparency is kept
+///
+/// The alpha channel is encoded as the luma plane of a separate stream, and
+/// each alpha frame is attached to the color packet of the same frame, as
+/// WebM stores it.
+pub(super) struct VpxProcess {
+    name: &'static str,
+    color: IvfProcess,
+    alpha: Option<IvfProcess>,
+    pending: VecDeque<Packet>,
+    pending_alpha: VecDeque<Packet>,
+}
+
+impl VpxProcess {
+    /// Start ffmpeg with the encoder arguments `codec_args`, encoding with
+    /// constant quality `crf` (None for lossless) up to the bitrate `cap`
+    /// unless the configuration sets bitrate limits
+    ///
+    /// `name` names the codec in error messages.
+    pub fn spawn(
+        name: &'static str,
+        config: &EncoderConfig,
+        codec_args: &[&str],
+        crf: Option<u32>,
+        cap: &str,
+        pixel_format: &str,
+        is_keyframe: KeyframeCheck,
+    ) -> Result<Self> {
+        let ffmpeg = find_ffmpeg(config.ffmpeg_path.as_deref())?;
+
+        // With an alpha channel, keep the two streams frame-aligned: fixed
+        // keyframes and no hidden alternate reference frames
+        let interval = match config.keyframe_interval {
+            0 => config.fps * ALPHA_KEYFRAME_INTERVAL_SECONDS,
+            interval => interval,
+        };
+        let keyframe_args = if config.alpha {
+            EncoderConfig {
+                keyframe_interval: interval,
+                ..config.clone()
+            }
+            .keyframe_args()
+        } else {
+            config.keyframe_args()
+        };
+        let interval = interval.to_string();
+        let mut args = codec_args.to_vec();
+        args.extend(keyframe_args.iter().map(String::as_str));
+        if config.alpha {
+            args.extend(["-keyint_min", &interval, "-auto-alt-ref", "0"]);
+        }
+
+        let mut color_args = rate_args(&config.bitrate, crf, cap);
+        color_args.extend(with_pass_args(&args, config, ""));
+        color_args.extend(config.color.ffmpeg_args());
+        color_args.extend(config.ffmpeg_args.iter().cloned());
+        let color = IvfProcess::spawn(
+            &ffmpeg,
+            config,
+            "rgba",
+            &color_args.iter().map(String::as_str).collect::<Vec<_>>(),
+            pixel_format,
+            name,
+            is_keyframe,
+        )?;
+
+        // The alpha plane is piped as the luma of YUV 4:2:0 frames, so it
+        // is encoded without a range conversion
+        let alpha = if config.alpha {
+            let mut alpha_args = rate_args(&Bitrate::default(), crf, cap);
+            alpha_args.extend(with_pass_args(&args, config, "-alpha"));
+            Some(IvfProcess::spawn(
+                &ffmpeg,
+                config,
+                "yuv420p",
+                &alpha_args.iter().map(String::as_str).collect::<Vec<_>>(),
+                "yuv420p",
+                name,
+                is_keyframe,
+            )?)
+        } else {
+            None
+        };
+
+        Ok(Self {
+            name,
+            color,
+            alpha,
+            pending: VecDeque::new(),
+            pending_alpha: VecDeque::new(),
+        })
+    }
+
+    pub fn encode(&mut self, frame: &Frame) -> Result<Vec<Packet>> {
+        let packets = self.color.write(&frame.data)?;
+        let alpha_packets = match &mut self.alpha {
+            Some(alpha) => alpha.write(&alpha_frame(frame))?,
+            None => return Ok(packets),
+        };
+
+        self.pending.extend(packets);
+        self.pending_alpha.extend(alpha_packets);
+        Ok(attach_alpha(&mut self.pending, &mut self.pending_alpha))
+    }
+
+    pub fn flush(&mut self) -> Result<Vec<Packet>> {
+        let packets = self.color.finish(self.name)?;
+        let alpha_packets = match &mut self.alpha {
+            Some(alpha) => alpha.finish(self.name)?,
+            None => return Ok(packets),
+        };
+
+        self.pending.extend(packets);
+        self.pending_alpha.extend(alpha_packets);
+        let packets = attach_alpha(&mut self.pending, &mut self.pending_alpha);
+        if !self.pending.is_empty() || !self.pending_alpha.is_empty() {
+            return Err(Error::Ffmpeg(format!(
+                "FFmpeg {} alpha stream does not match the color stream",
+                self.name
+            )));
+        }
+        Ok(packets)
+    }
+}
+
+/// ffmpeg process encoding raw frames to IVF
+pub(super) struct IvfProcess {
+    process: Child,
+    log: ProcessLog,
+    stdin: Option<ChildStdin>,
+    reader: Option<JoinHandle<Result<()>>>,
+    packets: Receiver<Packet>,
+}
+
+impl IvfProcess {
+    /// Start ffmpeg reading raw frames of `input_format` and writing IVF
+    /// with the encoder arguments `codec_args`
+    pub fn spawn(
+        ffmpeg: &str,
+        config: &EncoderConfig,
+        input_format: &str,
+        codec_args: &[&str],
+        pixel_format: &str,
+        name: &'static str,
+        is_keyframe: KeyframeCheck,
+    ) -> Result<Self> {
+        let (mut process, log) = ProcessLog::spawn(
+            Command::new(ffmpeg)
+                .args([
+                    "-hide_banner",
+                    "-f",
+                    "rawvideo",
+                    "-pix_fmt",
+                    input_format,
+                    "-s",
+                    &format!("{}x{}", config.width, config.height),
+                    "-r",
+                    &config.fps.to_string(),
+                    "-i",
+                    "pipe:0",
+                ])
+                .args(config.thread_args())
+                .args(codec_args)
+                .args(["-pix_fmt", pixel_format, "-f", "ivf", "pipe:1"])
+                .stdin(Stdio::piped())
+                .stdout(Stdio::piped()),
+        )?;
+
+        let stdin = process.stdin.take();
+        let stdout = process
+            .stdout
+            .take()
+            .ok_or_else(|| Error::Ffmpeg("FFmpeg stdout not available".to_string()))?;
+
+        let (sender, packets) = mpsc::channel();
+        let reader = std::thread::spawn(move || read_ivf(stdout, sender, name, is_keyframe));
+
+        Ok(Self {
+            process,
+            log,
+            stdin,
+            reader: Some(reader),
+            packets,
+        })
+    }
+
+    /// Write a raw frame, returning the packets read so far
+    pub fn write(&mut self, data: &[u8]) -> Result<Vec<Packet>> {
+        let stdin = self
+            .stdin
+            .as_mut()
+            .ok_or_else(|| Error::Ffmpeg("FFmpeg stdin not available".to_string()))?;
+
+        if let Err(e) = stdin.write_all(data) {
+            // ffmpeg closes its input when it fails
+            let error = Error::Ffmpeg(format!("Failed to write frame: {}", e));
+            return Err(self.log.error(&mut self.process, error));
+        }
+
+        Ok(self.packets.try_iter().collect())
+    }
+
+    /// Close the input and wait for ffmpeg, returning the last packets
+    pub fn finish(&mut self, name: &str) -> Result<Vec<Packet>> {
+        // Close stdin to signal end of input
+        drop(self.stdin.take());
+
+        if let Some(reader) = self.reader.take() {
+            reader
+                .join()
+                .map_err(|_| Error::Ffmpeg("FFmpeg output reader panicked".to_string()))??;
+        }
+
+        let status = self
+            .process
+            .wait()
+            .map_err(|e| Error::Ffmpeg(format!("FFmpeg process error: {}", e)))?;
+        if !status.success() {
+            let error = Error::Ffmpeg(format!("FFmpeg {} encoding failed with {}", name, status));
+            return Err(self.log.error(&mut self.process, error));
+        }
+
+        Ok(self.packets.try_iter().collect())
+    }
+}
+
+impl Drop for IvfProcess {
+    fn drop(&mut self) {
+        // Kill the process if it's still running
+        let _ = self.process.kill();
+        let _ = self.process.wait();
+    }
+}
+
+/// Rate control arguments: an average bitrate, or constant quality `crf`
+/// (None for lossless) up to the peak bitrate or else `cap`, which libvpx
+/// treats as the upper bound of its constrained quality mode
+fn rate_args(bitrate: &Bitrate, crf: Option<u32>, cap: &str) -> Vec<String> {
+    let Some(crf) = crf else {
+        return ["-lossless", "1", "-b:v", "0"].map(String::from).to_vec();
+    };
+    let mut args = if bitrate.target_kbps > 0 {
+        vec!["-b:v".to_string(), format!("{}k", bitrate.target_kbps)]
+    } else {
+        let cap = if bitrate.max_kbps > 0 {
+            format!("{}k", bitrate.max_kbps)
+        } else {
+            cap.to_string()
+        };
+        vec!["-crf".to_string(), crf.to_string(), "-b:v".to_string(), cap]
+    };
+    args.extend(bitrate.limit_args());
+    args
+}
+
+/// Encoder arguments with the pass of a two-pass encode, whose statistics
+/// file is named after the prefix and `suffix`
+fn with_pass_args(args: &[&str], config: &EncoderConfig, suffix: &str) -> Vec<String> {
+    let mut args: Vec<String> = args.iter().map(|arg| arg.to_string()).collect();
+    if let Some(two_pass) = &config.two_pass {
+        let mut log = two_pass.stats_prefix.as_os_str().to_os_string();
+        log.push(suffix);
+        args.extend([
+            "-pass".into(),
+            two_pass.pass.to_string(),
+            "-passlogfile".into(),
+            path_arg(&log),
+        ]);
+    }
+    args
+}
+
+/// Build a YUV 4:2:0 frame whose luma is the alpha channel of `frame`,
+/// with neutral chroma
+fn alpha_frame(frame: &Frame) -> Vec<u8> {
+    let chroma_size = frame.width.div_ceil(2) as usize * frame.height.div_ceil(2) as usize;
+    let mut data: Vec<u8> = frame.data.chunks_exact(4).map(|pixel| pixel[3]).collect();
+    data.resize(data.len() + 2 * chroma_size, 128);
+    data
+}
+
+/// Attach each pending alpha frame to the color packet of the same frame,
+/// returning the completed packets
+fn attach_alpha(packets: &mut VecDeque<Packet>, alpha: &mut VecDeque<Packet>) -> Vec<Packet> {
+    let count = packets.len().min(alpha.len());
+    packets
+        .drain(..count)
+        .zip(alpha.drain(..count))
+        .map(|(mut packet, alpha)| {
+            packet.alpha = Some(alpha.data);
+            packet
+        })
+        .collect()
+}
+
+/// Read frames from an IVF stream, sending one packet per frame
+fn read_ivf<R: Read>(
+    mut input: R,
+    sender: Sender<Packet>,
+    name: &str,
+    is_keyframe: KeyframeCheck,
+) -> Result<()> {
+    let mut header = [0u8; IVF_HEADER_SIZE];
+    if let Err(e) = input.read_exact(&mut header) {
+        return Err(Error::Ffmpeg(format!(
+            "No {} output from ffmpeg: {}",
+            name, e
+        )));
+    }
+    if &header[0..4] != b"DKIF" {
+        return Err(Error::Ffmpeg("FFmpeg output is not IVF".to_string()));
+    }
+
+    let mut pts = 0;
+    loop {
+        let mut frame_header = [0u8; IVF_FRAME_HEADER_SIZE];
+        match input.read_exact(&mut frame_header) {
+            Ok(()) => {}
+            Err(e) if e.kind() == ErrorKind::UnexpectedEof => return Ok(()),
+            Err(e) => return Err(Error::Ffmpeg(format!("Failed to read output: {}", e))),
+        }
+
+        let size = u32::from_le_bytes([
+            frame_header[0],
+            frame_header[1],
+            frame_header[2],
+            frame_header[3],
+        ]);
+        let mut data = vec![0u8; size as usize];
+        input
+            .read_exact(&mut data)
+            .map_err(|e| Error::Ffmpeg(format!("Failed to read output: {}", e)))?;
+
+        let packet = Packet {
+            is_keyframe: is_keyframe(&data),
+            data,
+            pts,
+            dts: pts,
+            duration: 1,
+            alpha: None,
+        };
+        if sender.send(packet).is_err() {
+            // The encoder was dropped
+            return Ok(());
+        }
+        pts += 1;
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn ivf(frames: &[&[u8]]) -> Vec<u8> {
+        let mut data = b"DKIF".to_vec();
+        data.resize(IVF_HEADER_SIZE, 0);
+        for (i, frame) in frames.iter().enumerate() {
+            data.extend_from_slice(&(frame.len() as u32).to_le_bytes());
+            data.extend_from_slice(&(i as u64).to_le_bytes());
+            data.extend_from_slice(frame);
+        }
+        data
+    }
+
+    fn first_is_key(data: &[u8]) -> bool {
+        data.first() == Some(&0)
+    }
+
+    #[test]
+    fn test_read_ivf() {
+        let (sender, receiver) = mpsc::channel();
+        read_ivf(
+            &ivf(&[&[0, 1, 2], &[1, 3]])[..],
+            sender,
+            "VP9",
+            first_is_key,
+        )
+        .unwrap();
+
+        let packets: Vec<Packet> = receiver.try_iter().collect();
+        assert_eq!(packets.len(), 2);
+        assert_eq!(packets[0].data, vec![0, 1, 2]);
+        assert!(packets[0].is_keyframe);
+        assert_eq!(packets[1].pts, 1);
+        assert!(!packets[1].is_keyframe);
+    }
+
+    fn frame(alphas: &[u8], width: u32, height: u32) -> Frame {
+        Frame {
+            width,
+            height,
+            data: alphas.iter().flat_map(|&a| [255, 0, 0, a]).collect(),
+            pts_ms: 0,
+        }
+    }
+
+    fn packet(data: u8) -> Packet {
+        Packet {
+            data: vec![data],
+            pts: 0,
+            dts: 0,
+            is_keyframe: false,
+            duration: 1,
+            alpha: None,
+        }
+    }
+
+    #[test]
+    fn test_rate_args() {
+        let quality = rate_args(&Bitrate::default(), Some(30), "0");
+        assert_eq!(quality, ["-crf", "30", "-b:v", "0"]);
+        assert_eq!(rate_args(&Bitrate::default(), None, "0")[0], "-lossless");
+
+        // A peak caps constant quality
+        let capped = Bitrate {
+            max_kbps: 2500,
+            ..Default::default()
+        };
+        assert_eq!(
+            rate_args(&capped, Some(30), "20M"),
+            ["-crf", "30", "-b:v", "2500k", "-maxrate", "2500k", "-bufsize", "5000k"]
+        );
+
+        // An average replaces the quality
+        let target = Bitrate {
+            target_kbps: 1000,
+            max_kbps: 2500,
+            buffer_kbits: 3000,
+        };
+        assert_eq!(
+            rate_args(&target, Some(30), "0"),
+            ["-b:v", "1000k", "-maxrate", "2500k", "-bufsize", "3000k"]
+        );
+    }
+
+    #[test]
+    fn test_alpha_frame() {
+        let data = alpha_frame(&frame(&[0, 64, 128, 255, 1, 2, 3, 4], 4, 2));
+        assert_eq!(&data[..8], &[0, 64, 128, 255, 1, 2, 3, 4]);
+        // Two 2x1 chroma planes
+        assert_eq!(&data[8..], &[128; 4]);
+    }
+
+    #[test]
+    fn test_attach_alpha() {
+        let mut packets: VecDeque<Packet> = [packet(1), packet(2)].into();
+        let mut alpha: VecDeque<Packet> = [packet(10)].into();
+
+        let attached = attach_alpha(&mut packets, &mut alpha);
+        assert_eq!(attached.len(), 1);
+        assert_eq!(attached[0].data, vec![1]);
+        assert_eq!(attached[0].alpha, Some(vec![10]));
+        // The second packet waits for its alpha frame
+        assert_eq!(packets.len(), 1);
+        assert!(alpha.is_empty());
+    }
+
+    #[test]
+    fn test_read_ivf_invalid() {
+        let (sender, _receiver) = mpsc::channel();
+        assert!(read_ivf(&b"RIFF"[..], sender.clone(), "VP9", first_is_key).is_err());
+
+        let mut data = ivf(&[]);
+        data[0..4].copy_from_slice(b"RIFF");
+        assert!(read_ivf(&data[..], sender, "VP9", first_is_key).is_err());
+    }
+}
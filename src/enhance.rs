@@ -0,0 +1,134 @@
+//! Automatic enhancement of photo slides
+//!
+//! Each slide is corrected on its own: a gray-world white balance removes
+//! color casts and a contrast stretch maps the darkest and brightest luma
+//! levels to black and white. The strength blends the corrected image with
+//! the original.
+
+use crate::analysis::rgba_histogram;
+use crate::image_loader::LoadedImage;
+use crate::LumaStats;
+
+/// Fraction of pixels allowed to clip at each end of the contrast stretch
+const STRETCH_CLIP: f32 = 0.005;
+
+/// Limits of the white balance gain per channel
+const MIN_CHANNEL_GAIN: f32 = 0.5;
+const MAX_CHANNEL_GAIN: f32 = 2.0;
+
+/// Smallest luma range that is stretched, to keep flat images flat
+const MIN_STRETCH_RANGE: f32 = 16.0;
+
+/// Auto-enhance an RGBA image with a strength in percent (0-100)
+pub(crate) fn auto_enhance(image: &mut LoadedImage, strength: u32) {
+    let strength = strength.min(100) as f32 / 100.0;
+    if strength == 0.0 || image.data.is_empty() {
+        return;
+    }
+
+    // The contrast stretch is measured on the white-balanced image
+    let gains = white_balance_gains(&image.data);
+    let mut balanced = image.data.clone();
+    for p in balanced.chunks_exact_mut(4) {
+        for c in 0..3 {
+            p[c] = (p[c] as f32 * gains[c]).round().min(255.0) as u8;
+        }
+    }
+    let (low, high) = stretch_range(&balanced);
+    let scale = 255.0 / (high - low);
+
+    for p in image.data.chunks_exact_mut(4) {
+        for c in 0..3 {
+            let original = p[c] as f32;
+            let corrected = ((original * gains[c] - low) * scale).clamp(0.0, 255.0);
+            p[c] = (original + (corrected - original) * strength).round() as u8;
+        }
+    }
+}
+
+/// Gray-world white balance gains for the RGB channels
+fn white_balance_gains(data: &[u8]) -> [f32; 3] {
+    let mut sums = [0u64; 3];
+    for p in data.chunks_exact(4) {
+        for c in 0..3 {
+            sums[c] += p[c] as u64;
+        }
+    }
+    let gray = sums.iter().sum::<u64>() as f32 / 3.0;
+    sums.map(|sum| {
+        if sum == 0 {
+            1.0
+        } else {
+            (gray / sum as f32).clamp(MIN_CHANNEL_GAIN, MAX_CHANNEL_GAIN)
+        }
+    })
+}
+
+/// Luma levels mapped to black and white by the contrast stretch
+fn stretch_range(data: &[u8]) -> (f32, f32) {
+    let stats = LumaStats::from_histogram(rgba_histogram(data));
+    let low = stats.percentile(STRETCH_CLIP) as f32;
+    let high = stats.percentile(1.0 - STRETCH_CLIP) as f32;
+    if high - low < MIN_STRETCH_RANGE {
+        return (0.0, 255.0);
+    }
+    (low, high)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn image(pixels: &[[u8; 3]]) -> LoadedImage {
+        LoadedImage {
+            width: pixels.len() as u32,
+            height: 1,
+            data: pixels
+                .iter()
+                .flat_map(|p| [p[0], p[1], p[2], 255])
+                .collect(),
+        }
+    }
+
+    #[test]
+    fn test_contrast_stretch() {
+        let mut img = image(&[[64, 64, 64], [128, 128, 128], [192, 192, 192]]);
+        auto_enhance(&mut img, 100);
+        assert_eq!(&img.data[0..3], &[0, 0, 0]);
+        assert_eq!(&img.data[4..7], &[128, 128, 128]);
+        assert_eq!(&img.data[8..11], &[255, 255, 255]);
+        assert_eq!(img.data[11], 255);
+    }
+
+    #[test]
+    fn test_white_balance_removes_cast() {
+        let mut img = image(&[[40, 20, 20], [200, 100, 100], [120, 60, 60]]);
+        auto_enhance(&mut img, 100);
+        for p in img.data.chunks(4) {
+            assert!(p[0].abs_diff(p[1]) <= 1, "{:?}", p);
+            assert!(p[1].abs_diff(p[2]) <= 1, "{:?}", p);
+        }
+    }
+
+    #[test]
+    fn test_strength() {
+        let original = image(&[[64, 64, 64], [192, 192, 192]]);
+
+        let mut img = original.clone();
+        auto_enhance(&mut img, 0);
+        assert_eq!(img.data, original.data);
+
+        let mut img = original.clone();
+        auto_enhance(&mut img, 50);
+        assert_eq!(img.data[0], 32);
+        assert_eq!(img.data[4], 224);
+    }
+
+    #[test]
+    fn test_flat_image_unchanged() {
+        let original = image(&[[128, 128, 128], [130, 130, 130]]);
+        let mut img = original.clone();
+        auto_enhance(&mut img, 100);
+        assert_eq!(img.data, original.data);
+    }
+}
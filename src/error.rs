@@ -48,9 +48,183 @@ pub enum Error {
     /// Platform-specific error
     #[error("Platform error: {0}")]
     Platform(String),
+
+    /// Not enough free disk space for the estimated output
+    #[error("Insufficient disk space: about {required} bytes needed, {available} bytes available")]
+    InsufficientSpace { required: u64, available: u64 },
+
+    /// Rendered output differs from what was encoded
+    #[error("Output does not match the job: {0}")]
+    OutputMismatch(String),
+
+    /// Error caused by one slide of a slideshow, numbered from 1 in the
+    /// message
+    #[error("Slide {} ({path}): {source}", .index + 1)]
+    Slide {
+        /// Index of the slide in the entries
+        index: usize,
+        /// Image path of the slide
+        path: String,
+        /// What went wrong with the slide
+        source: Box<Error>,
+    },
+
+    /// Error of an ffmpeg process that failed, followed by the last lines of
+    /// its stderr in the message
+    #[error("{}", with_stderr(source.to_string(), stderr))]
+    FfmpegFailure {
+        /// Command line that ran ffmpeg
+        command: String,
+        /// Last lines of ffmpeg's stderr
+        stderr: String,
+        /// What went wrong
+        source: Box<Error>,
+    },
+}
+
+/// Message followed by the stderr of the process that failed, if any
+fn with_stderr(message: String, stderr: &str) -> String {
+    if stderr.is_empty() {
+        message
+    } else {
+        format!("{}: {}", message, stderr)
+    }
+}
+
+/// Language of error messages
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum Language {
+    /// English
+    #[default]
+    English = 0,
+    /// Japanese
+    Japanese = 1,
+}
+
+/// Language of the error messages of a single call
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum MessageLanguage {
+    /// The language set for all calls
+    #[default]
+    Auto = 0,
+    /// English
+    English = 1,
+    /// Japanese
+    Japanese = 2,
+}
+
+impl MessageLanguage {
+    /// Language of the messages, or None for the language set for all calls
+    pub fn language(self) -> Option<Language> {
+        match self {
+            MessageLanguage::Auto => None,
+            MessageLanguage::English => Some(Language::English),
+            MessageLanguage::Japanese => Some(Language::Japanese),
+        }
+    }
+}
+
+impl Error {
+    /// Attribute the error to a slide
+    pub(crate) fn in_slide(self, index: usize, path: &str) -> Self {
+        Error::Slide {
+            index,
+            path: path.to_string(),
+            source: Box::new(self),
+        }
+    }
+
+    /// Index and image path of the slide that caused the error, if any
+    pub fn slide(&self) -> Option<(usize, &str)> {
+        match self {
+            Error::Slide { index, path, .. } => Some((*index, path)),
+            _ => None,
+        }
+    }
+
+    /// Attach the command line and stderr of the ffmpeg process that caused
+    /// the error
+    pub(crate) fn in_ffmpeg(self, command: String, stderr: String) -> Self {
+        Error::FfmpegFailure {
+            command,
+            stderr,
+            source: Box::new(self),
+        }
+    }
+
+    /// Command line and last lines of stderr of the ffmpeg process that
+    /// caused the error, if any
+    pub fn ffmpeg_failure(&self) -> Option<(&str, &str)> {
+        match self {
+            Error::FfmpegFailure {
+                command, stderr, ..
+            } => Some((command, stderr)),
+            Error::Slide { source, .. } => source.ffmpeg_failure(),
+            _ => None,
+        }
+    }
+
+    /// Error message in a language
+    ///
+    /// The kind of error is translated; details such as file names and
+    /// messages of ffmpeg and the platform encoders stay in English.
+    pub fn localized(&self, language: Language) -> String {
+        if language == Language::English {
+            return self.to_string();
+        }
+
+        let (kind, detail) = match self {
+            Error::ContainerCodecMismatch { container, codec } => {
+                return format!(
+                    "コンテナ {:?} はコーデック {:?} に対応していません",
+                    container, codec
+                )
+            }
+            Error::InsufficientSpace {
+                required,
+                available,
+            } => {
+                return format!(
+                    "ディスク容量が不足しています: 約 {} バイト必要ですが、空きは {} バイトです",
+                    required, available
+                )
+            }
+            Error::Slide {
+                index,
+                path,
+                source,
+            } => {
+                return format!(
+                    "スライド {} ({}): {}",
+                    index + 1,
+                    path,
+                    source.localized(language)
+                )
+            }
+            Error::FfmpegFailure { stderr, source, .. } => {
+                return with_stderr(source.localized(language), stderr)
+            }
+            Error::InvalidInput(detail) => ("入力が不正です", detail.clone()),
+            Error::CodecUnavailable(detail) => ("コーデックを利用できません", detail.clone()),
+            Error::Io(e) => ("入出力エラー", e.to_string()),
+            Error::Image(e) => ("画像エラー", e.to_string()),
+            Error::Encode(detail) => ("エンコードエラー", detail.clone()),
+            Error::Decode(detail) => ("デコードエラー", detail.clone()),
+            Error::Mux(detail) => ("多重化エラー", detail.clone()),
+            Error::Ffmpeg(detail) => ("FFmpeg エラー", detail.clone()),
+            Error::Platform(detail) => ("プラットフォームエラー", detail.clone()),
+            Error::OutputMismatch(detail) => ("出力がジョブと一致しません", detail.clone()),
+        };
+        format!("{}: {}", kind, detail)
+    }
 }
 
 /// Error code for FFI
+///
+/// Codes are stable across versions: existing values never change meaning
+/// and new codes are only appended.
 #[derive(Debug, Clone, Copy, PartialEq, Eq)]
 #[repr(C)]
 pub enum ErrorCode {
@@ -68,6 +242,10 @@ pub enum ErrorCode {
     EncodeError = 5,
     /// Decoding error
     DecodeError = 6,
+    /// Not enough free disk space
+    InsufficientSpace = 7,
+    /// Rendered output differs from what was encoded
+    OutputMismatch = 8,
 }
 
 impl From<&Error> for ErrorCode {
@@ -83,6 +261,122 @@ impl From<&Error> for ErrorCode {
             Error::Mux(_) => ErrorCode::EncodeError,
             Error::Ffmpeg(_) => ErrorCode::EncodeError,
             Error::Platform(_) => ErrorCode::EncodeError,
+            Error::InsufficientSpace { .. } => ErrorCode::InsufficientSpace,
+            Error::OutputMismatch(_) => ErrorCode::OutputMismatch,
+            Error::Slide { source, .. } => ErrorCode::from(source.as_ref()),
+            Error::FfmpegFailure { source, .. } => ErrorCode::from(source.as_ref()),
         }
     }
 }
+
+impl ErrorCode {
+    /// Message for an error code and detail in a language
+    ///
+    /// Used for errors found by the FFI layer itself, which have no `Error`.
+    pub fn message(self, detail: &str, language: Language) -> String {
+        let kind = match (language, self) {
+            (Language::English, _) => return detail.to_string(),
+            (Language::Japanese, ErrorCode::Ok) => return detail.to_string(),
+            (Language::Japanese, ErrorCode::InvalidInput) => "入力が不正です",
+            (Language::Japanese, ErrorCode::CodecUnavailable) => "コーデックを利用できません",
+            (Language::Japanese, ErrorCode::ContainerCodecMismatch) => {
+                "コンテナとコーデックの組み合わせに対応していません"
+            }
+            (Language::Japanese, ErrorCode::IoError) => "入出力エラー",
+            (Language::Japanese, ErrorCode::EncodeError) => "エンコードエラー",
+            (Language::Japanese, ErrorCode::DecodeError) => "デコードエラー",
+            (Language::Japanese, ErrorCode::InsufficientSpace) => "ディスク容量が不足しています",
+            (Language::Japanese, ErrorCode::OutputMismatch) => "出力がジョブと一致しません",
+        };
+        format!("{}: {}", kind, detail)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_localized() {
+        let err = Error::InvalidInput("No slides provided".to_string());
+        assert_eq!(
+            err.localized(Language::English),
+            "Invalid input: No slides provided"
+        );
+        assert_eq!(
+            err.localized(Language::Japanese),
+            "入力が不正です: No slides provided"
+        );
+
+        let err = Error::ContainerCodecMismatch {
+            container: Container::WebM,
+            codec: Codec::H264,
+        };
+        assert_eq!(err.localized(Language::English), err.to_string());
+        assert_eq!(
+            err.localized(Language::Japanese),
+            "コンテナ WebM はコーデック H264 に対応していません"
+        );
+
+        let err = Error::InsufficientSpace {
+            required: 2000,
+            available: 1000,
+        };
+        assert_eq!(ErrorCode::from(&err), ErrorCode::InsufficientSpace);
+        assert_eq!(
+            err.localized(Language::Japanese),
+            "ディスク容量が不足しています: 約 2000 バイト必要ですが、空きは 1000 バイトです"
+        );
+    }
+
+    #[test]
+    fn test_slide_error() {
+        let err = Error::Decode("Truncated image".to_string()).in_slide(36, "deck/37.png");
+        assert_eq!(err.slide(), Some((36, "deck/37.png")));
+        assert_eq!(ErrorCode::from(&err), ErrorCode::DecodeError);
+        assert_eq!(
+            err.to_string(),
+            "Slide 37 (deck/37.png): Decoding error: Truncated image"
+        );
+        assert_eq!(
+            err.localized(Language::Japanese),
+            "スライド 37 (deck/37.png): デコードエラー: Truncated image"
+        );
+        assert_eq!(Error::InvalidInput(String::new()).slide(), None);
+    }
+
+    #[test]
+    fn test_ffmpeg_failure() {
+        let err = Error::Decode("Failed to decode video after 0 frames".to_string()).in_ffmpeg(
+            "ffmpeg -i 'a b.mp4'".to_string(),
+            "a b.mp4: Invalid data".to_string(),
+        );
+        assert_eq!(
+            err.ffmpeg_failure(),
+            Some(("ffmpeg -i 'a b.mp4'", "a b.mp4: Invalid data"))
+        );
+        assert_eq!(ErrorCode::from(&err), ErrorCode::DecodeError);
+        assert_eq!(
+            err.to_string(),
+            "Decoding error: Failed to decode video after 0 frames: a b.mp4: Invalid data"
+        );
+        let err = err.in_slide(2, "deck/3.mp4");
+        assert_eq!(
+            err.ffmpeg_failure().map(|(_, s)| s),
+            Some("a b.mp4: Invalid data")
+        );
+        assert_eq!(Error::Ffmpeg(String::new()).ffmpeg_failure(), None);
+    }
+
+    #[test]
+    fn test_error_code_message() {
+        assert_eq!(
+            ErrorCode::InvalidInput.message("Output path is null", Language::English),
+            "Output path is null"
+        );
+        assert_eq!(
+            ErrorCode::InvalidInput.message("Output path is null", Language::Japanese),
+            "入力が不正です: Output path is null"
+        );
+    }
+}
@@ -1,17 +1,92 @@
 //! FFI (Foreign Function Interface) for C/Go interoperability
 
-use crate::error::ErrorCode;
-use crate::{available, juxtapose, slideshow, Codec, Color, Container, EncodeOptions, SlideEntry};
-use libc::{c_char, size_t};
+use crate::error::{ErrorCode, Language, MessageLanguage};
+use crate::{
+    analyze_luma, available, benchmark, capabilities, extract_audio, ffmpeg_info,
+    find_duplicate_slides, frame_hashes, image_with_audio, juxtapose, preview_slideshow,
+    register_font, remove_audio, replace_audio, slideshow, slideshow_from_iter,
+    slideshow_skipping_invalid, slideshow_within_size, system_fonts, visualize_audio, Anchor,
+    AudioCodec, AudioFit, AudioOptions, Av1Backend, BenchmarkSpec, BitDepth, Capability, Chapter,
+    ChapterMode, Codec, Color, ColorMatrix, ColorPrimaries, ColorRange, ColorTransfer, Compression,
+    Container, CropFocus, DecodeMode, DuplicateMatch, DynamicRange, EncodeOptions, Error,
+    FallbackHandler, FrameHash, H264Preset, H264Profile, H264Tune, HlsSegment, HwAccel, HwFallback,
+    IdleFrames, JuxtaposeLayout, LogHandler, LogLevel, LogValue, Logo, LumaStats, Mp4Layout,
+    OutputCheck, OutputMode, Passes, PixelFormat, PreviewLayout, PreviewOptions, Priority,
+    Reproducibility, Session, SizedSettings, SlideEntry, SlideFit, TextAlign, TextFit, TimeRange,
+    ToneMap, Transparency, VideoReader, VideoWriter, VisualStyle, Visualization, WarningHandler,
+    WarningKind, WritingMode,
+};
+use libc::{c_char, c_int, c_void, size_t};
+use std::cell::Cell;
 use std::ffi::{CStr, CString};
 use std::ptr;
 use std::slice;
+use std::sync::atomic::{AtomicU8, Ordering};
+
+/// Language of the messages of FFI results
+static LANGUAGE: AtomicU8 = AtomicU8::new(Language::English as u8);
+
+thread_local! {
+    /// Language of the messages of the FFI call running on this thread, when
+    /// its parameters set one
+    static CALL_LANGUAGE: Cell<Option<Language>> = const { Cell::new(None) };
+}
+
+/// Current language of error messages
+fn language() -> Language {
+    if let Some(language) = CALL_LANGUAGE.get() {
+        return language;
+    }
+    match LANGUAGE.load(Ordering::Relaxed) {
+        l if l == Language::Japanese as u8 => Language::Japanese,
+        _ => Language::English,
+    }
+}
+
+/// Language of the messages of an FFI call for as long as it is held
+struct CallLanguage {
+    previous: Option<Language>,
+}
+
+impl CallLanguage {
+    /// Use `language` for the messages of the call
+    fn enter(language: MessageLanguage) -> Self {
+        let previous = CALL_LANGUAGE.get();
+        if let Some(language) = language.language() {
+            CALL_LANGUAGE.set(Some(language));
+        }
+        Self { previous }
+    }
+
+    /// Use the language of the call's parameters, which may be null
+    unsafe fn of_params(params: *const FfiEncodeParams) -> Self {
+        Self::enter(
+            params
+                .as_ref()
+                .map_or(MessageLanguage::Auto, |p| p.language),
+        )
+    }
+}
+
+impl Drop for CallLanguage {
+    fn drop(&mut self) {
+        CALL_LANGUAGE.set(self.previous);
+    }
+}
 
 /// FFI result structure
 #[repr(C)]
 pub struct FfiResult {
     pub code: ErrorCode,
     pub message: *mut c_char,
+    /// Index of the slide that caused the error, or -1
+    pub slide_index: i64,
+    /// Image path of the slide that caused the error, or null
+    pub slide_path: *mut c_char,
+    /// Command line of the ffmpeg process that failed, or null
+    pub ffmpeg_command: *mut c_char,
+    /// Last lines of that process's stderr, or null
+    pub ffmpeg_stderr: *mut c_char,
 }
 
 impl FfiResult {
@@ -19,15 +94,38 @@ impl FfiResult {
         Self {
             code: ErrorCode::Ok,
             message: ptr::null_mut(),
+            slide_index: -1,
+            slide_path: ptr::null_mut(),
+            ffmpeg_command: ptr::null_mut(),
+            ffmpeg_stderr: ptr::null_mut(),
         }
     }
 
     fn error(code: ErrorCode, message: &str) -> Self {
+        Self::with_message(code, &code.message(message, language()))
+    }
+
+    fn from_error(err: &crate::Error) -> Self {
+        let mut result = Self::with_message(ErrorCode::from(err), &err.localized(language()));
+        if let Some((index, path)) = err.slide() {
+            result.slide_index = index as i64;
+            result.slide_path = CString::new(path).map_or(ptr::null_mut(), CString::into_raw);
+        }
+        if let Some((command, stderr)) = err.ffmpeg_failure() {
+            result.ffmpeg_command =
+                CString::new(command).map_or(ptr::null_mut(), CString::into_raw);
+            result.ffmpeg_stderr = CString::new(stderr).map_or(ptr::null_mut(), CString::into_raw);
+        }
+        result
+    }
+
+    fn with_message(code: ErrorCode, message: &str) -> Self {
         let c_message =
             CString::new(message).unwrap_or_else(|_| CString::new("Unknown error").unwrap());
         Self {
             code,
             message: c_message.into_raw(),
+            ..Self::ok()
         }
     }
 }
@@ -37,6 +135,467 @@ impl FfiResult {
 pub struct FfiSlideEntry {
     pub path: *const c_char,
     pub duration_ms: u32,
+    pub caption: *const c_char,
+    pub audio: *const c_char,
+}
+
+/// FFI callback yielding the next slide of a streaming slideshow: fills
+/// `entry` and returns 1, returns 0 after the last slide, or a negative
+/// value to stop with an error
+pub type FfiSlideSource =
+    unsafe extern "C" fn(entry: *mut FfiSlideEntry, user_data: *mut c_void) -> i32;
+
+/// FFI logo parameters
+#[repr(C)]
+pub struct FfiLogoParams {
+    pub path: *const c_char,
+    pub anchor: Anchor,
+    pub width: u32,
+    pub fade_ms: u32,
+    pub ranges: *const TimeRange,
+    pub range_count: size_t,
+}
+
+/// FFI chapter marker
+#[repr(C)]
+pub struct FfiChapter {
+    pub start_ms: u64,
+    pub title: *const c_char,
+}
+
+/// FFI optional encoding parameters
+///
+/// Zero values (null pointers, 0 numbers) select the defaults.
+#[repr(C)]
+pub struct FfiEncodeParams {
+    pub font: *const c_char,
+    pub writing_mode: WritingMode,
+    pub caption_align: TextAlign,
+    pub caption_fit: TextFit,
+    pub caption_max_width: u32,
+    pub background_music: *const c_char,
+    pub caption_anchor: Anchor,
+    pub safe_area: u32,
+    pub slide_number: *const c_char,
+    pub slide_number_anchor: Anchor,
+    pub logo: *const FfiLogoParams,
+    pub decode_mode: DecodeMode,
+    pub loudness: f32,
+    pub tone_map: ToneMap,
+    pub audio_gain: f32,
+    pub audio_fade_in_ms: u32,
+    pub audio_fade_out_ms: u32,
+    pub duck_threshold: f32,
+    pub duck_amount: f32,
+    pub enhance: u32,
+    pub slide_fit: SlideFit,
+    pub crop_focus: CropFocus,
+    pub idle_frames: IdleFrames,
+    pub time_budget_ms: u32,
+    pub compression: Compression,
+    pub transparency: Transparency,
+    pub hls_segment: HlsSegment,
+    pub segment_duration_ms: u32,
+    pub mp4_layout: Mp4Layout,
+    pub passes: Passes,
+    pub bitrate_kbps: u32,
+    pub max_bitrate_kbps: u32,
+    pub buffer_size_kbits: u32,
+    pub output_mode: OutputMode,
+    pub target_size_bytes: u64,
+    pub fps: u32,
+    pub left_subtitles: *const c_char,
+    pub right_subtitles: *const c_char,
+    pub keyframe_interval_ms: u32,
+    pub keyframe_times_ms: *const u64,
+    pub keyframe_time_count: size_t,
+    pub pixel_format: PixelFormat,
+    pub bit_depth: BitDepth,
+    pub dynamic_range: DynamicRange,
+    pub max_luminance: u32,
+    pub color_primaries: ColorPrimaries,
+    pub color_transfer: ColorTransfer,
+    pub color_matrix: ColorMatrix,
+    pub color_range: ColorRange,
+    pub juxtapose_layout: JuxtaposeLayout,
+    pub hw_accel: HwAccel,
+    pub hw_fallback: HwFallback,
+    pub on_fallback: Option<FfiFallbackCallback>,
+    pub fallback_user_data: *mut c_void,
+    pub output_check: OutputCheck,
+    pub threads: u32,
+    pub av1_backend: Av1Backend,
+    pub av1_preset: u8,
+    pub ffmpeg_args: *const *const c_char,
+    pub ffmpeg_arg_count: usize,
+    pub h264_profile: H264Profile,
+    pub h264_level: u8,
+    pub h264_preset: H264Preset,
+    pub h264_tune: H264Tune,
+    pub memory_budget_mb: u32,
+    pub session: *const Session,
+    pub priority: Priority,
+    pub temp_dir: *const c_char,
+    pub on_log: Option<FfiLogCallback>,
+    pub log_user_data: *mut c_void,
+    pub on_warning: Option<FfiWarningCallback>,
+    pub warning_user_data: *mut c_void,
+    pub reproducibility: Reproducibility,
+    pub chapter_mode: ChapterMode,
+    pub chapters: *const FfiChapter,
+    pub chapter_count: size_t,
+    pub language: MessageLanguage,
+}
+
+/// FFI callback reporting a fallback to the software encoder
+pub type FfiFallbackCallback =
+    unsafe extern "C" fn(code: ErrorCode, message: *const c_char, user_data: *mut c_void);
+
+/// FFI callback receiving the names of a list one at a time
+pub type FfiNameCallback = unsafe extern "C" fn(name: *const c_char, user_data: *mut c_void);
+
+/// FFI callback reporting a non-fatal warning of a job
+pub type FfiWarningCallback =
+    unsafe extern "C" fn(kind: WarningKind, message: *const c_char, user_data: *mut c_void);
+
+/// FFI attribute of a log event: text, or a number when `text` is null
+#[repr(C)]
+pub struct FfiLogAttr {
+    pub key: *const c_char,
+    pub text: *const c_char,
+    pub number: i64,
+}
+
+/// FFI callback receiving the structured events of a job
+pub type FfiLogCallback = unsafe extern "C" fn(
+    level: LogLevel,
+    message: *const c_char,
+    attrs: *const FfiLogAttr,
+    attr_count: size_t,
+    user_data: *mut c_void,
+);
+
+/// Apply optional encoding parameters to the encode options
+///
+/// # Safety
+/// - `params` must be null or point to a valid `FfiEncodeParams`
+unsafe fn apply_params(
+    options: &mut EncodeOptions,
+    params: *const FfiEncodeParams,
+) -> Result<(), FfiResult> {
+    if params.is_null() {
+        return Ok(());
+    }
+    let params = &*params;
+
+    if let Some(font) = optional_string(params.font, "Invalid font name")? {
+        options.font = Some(font);
+    }
+    if let Some(music) = optional_string(params.background_music, "Invalid background music path")?
+    {
+        options.background_music = Some(music);
+    }
+    if let Some(format) = optional_string(params.slide_number, "Invalid slide number format")? {
+        options.slide_number = Some(format);
+    }
+    if let Some(path) = optional_string(params.left_subtitles, "Invalid subtitles path")? {
+        options.left_subtitles = Some(path);
+    }
+    if let Some(path) = optional_string(params.right_subtitles, "Invalid subtitles path")? {
+        options.right_subtitles = Some(path);
+    }
+    if !params.logo.is_null() {
+        options.logo = Some(logo_from_params(&*params.logo)?);
+    }
+
+    options.writing_mode = params.writing_mode;
+    options.caption_align = params.caption_align;
+    options.caption_fit = params.caption_fit;
+    options.caption_max_width = params.caption_max_width;
+    options.caption_anchor = params.caption_anchor;
+    options.safe_area = params.safe_area;
+    options.slide_number_anchor = params.slide_number_anchor;
+    options.decode_mode = params.decode_mode;
+    options.loudness = params.loudness;
+    options.tone_map = params.tone_map;
+    options.audio_gain = params.audio_gain;
+    options.audio_fade_in_ms = params.audio_fade_in_ms;
+    options.audio_fade_out_ms = params.audio_fade_out_ms;
+    options.duck_threshold = params.duck_threshold;
+    options.duck_amount = params.duck_amount;
+    options.enhance = params.enhance;
+    options.slide_fit = params.slide_fit;
+    options.crop_focus = params.crop_focus;
+    options.idle_frames = params.idle_frames;
+    options.time_budget_ms = params.time_budget_ms;
+    options.compression = params.compression;
+    options.transparency = params.transparency;
+    options.hls_segment = params.hls_segment;
+    options.segment_duration_ms = params.segment_duration_ms;
+    options.mp4_layout = params.mp4_layout;
+    options.passes = params.passes;
+    options.bitrate_kbps = params.bitrate_kbps;
+    options.max_bitrate_kbps = params.max_bitrate_kbps;
+    options.buffer_size_kbits = params.buffer_size_kbits;
+    options.output_mode = params.output_mode;
+    options.target_size_bytes = params.target_size_bytes;
+    options.fps = params.fps;
+    options.keyframe_interval_ms = params.keyframe_interval_ms;
+    if !params.keyframe_times_ms.is_null() {
+        options.keyframe_times_ms =
+            slice::from_raw_parts(params.keyframe_times_ms, params.keyframe_time_count).to_vec();
+    }
+    options.pixel_format = params.pixel_format;
+    options.bit_depth = params.bit_depth;
+    options.dynamic_range = params.dynamic_range;
+    options.max_luminance = params.max_luminance;
+    options.color_primaries = params.color_primaries;
+    options.color_transfer = params.color_transfer;
+    options.color_matrix = params.color_matrix;
+    options.color_range = params.color_range;
+    options.juxtapose_layout = params.juxtapose_layout;
+    options.hw_accel = params.hw_accel;
+    options.hw_fallback = params.hw_fallback;
+    if let Some(callback) = params.on_fallback {
+        // The caller keeps the user data valid during the call
+        let user_data = params.fallback_user_data as usize;
+        let language = language();
+        options.on_fallback = Some(FallbackHandler::new(move |err| {
+            let message = CString::new(err.localized(language)).unwrap_or_default();
+            callback(
+                ErrorCode::from(err),
+                message.as_ptr(),
+                user_data as *mut c_void,
+            );
+        }));
+    }
+    if let Some(callback) = params.on_log {
+        // The caller keeps the user data valid during the call
+        let user_data = params.log_user_data as usize;
+        options.logger = Some(LogHandler::new(move |event| {
+            let message = CString::new(event.message).unwrap_or_default();
+            // The strings live until the callback returns
+            let strings: Vec<(CString, Option<CString>)> = event
+                .attrs
+                .iter()
+                .map(|(key, value)| {
+                    let text = match value {
+                        LogValue::Text(text) => {
+                            Some(CString::new(text.as_str()).unwrap_or_default())
+                        }
+                        LogValue::Int(_) => None,
+                    };
+                    (CString::new(*key).unwrap_or_default(), text)
+                })
+                .collect();
+            let attrs: Vec<FfiLogAttr> = event
+                .attrs
+                .iter()
+                .zip(&strings)
+                .map(|((_, value), (key, text))| FfiLogAttr {
+                    key: key.as_ptr(),
+                    text: text.as_ref().map_or(ptr::null(), |text| text.as_ptr()),
+                    number: match value {
+                        LogValue::Int(n) => *n,
+                        LogValue::Text(_) => 0,
+                    },
+                })
+                .collect();
+            callback(
+                event.level,
+                message.as_ptr(),
+                attrs.as_ptr(),
+                attrs.len(),
+                user_data as *mut c_void,
+            );
+        }));
+    }
+    if let Some(callback) = params.on_warning {
+        // The caller keeps the user data valid during the call
+        let user_data = params.warning_user_data as usize;
+        options.on_warning = Some(WarningHandler::new(move |warning| {
+            let message = CString::new(warning.message.as_str()).unwrap_or_default();
+            callback(warning.kind, message.as_ptr(), user_data as *mut c_void);
+        }));
+    }
+    options.output_check = params.output_check;
+    options.reproducibility = params.reproducibility;
+    options.chapter_mode = params.chapter_mode;
+    if !params.chapters.is_null() {
+        options.chapters = slice::from_raw_parts(params.chapters, params.chapter_count)
+            .iter()
+            .map(|chapter| {
+                Ok(Chapter {
+                    start_ms: chapter.start_ms,
+                    title: required_string(chapter.title, "Chapter title")?,
+                })
+            })
+            .collect::<Result<_, _>>()?;
+    }
+    options.threads = params.threads;
+    options.av1_backend = params.av1_backend;
+    options.av1_preset = params.av1_preset;
+    if !params.ffmpeg_args.is_null() {
+        options.ffmpeg_args = slice::from_raw_parts(params.ffmpeg_args, params.ffmpeg_arg_count)
+            .iter()
+            .map(|&arg| required_string(arg, "FFmpeg argument"))
+            .collect::<Result<_, _>>()?;
+    }
+    options.h264_profile = params.h264_profile;
+    options.h264_level = params.h264_level;
+    options.h264_preset = params.h264_preset;
+    options.h264_tune = params.h264_tune;
+    options.memory_budget_mb = params.memory_budget_mb;
+    options.session = params.session.as_ref().cloned();
+    options.priority = params.priority;
+    options.temp_dir = optional_string(params.temp_dir, "Invalid temporary directory")?;
+
+    Ok(())
+}
+
+/// Convert FFI logo parameters
+///
+/// # Safety
+/// - `params.ranges` must point to `params.range_count` ranges or be null
+unsafe fn logo_from_params(params: &FfiLogoParams) -> Result<Logo, FfiResult> {
+    let path = required_string(params.path, "Logo path")?;
+    let ranges = if params.ranges.is_null() || params.range_count == 0 {
+        Vec::new()
+    } else {
+        slice::from_raw_parts(params.ranges, params.range_count).to_vec()
+    };
+
+    Ok(Logo {
+        path,
+        anchor: params.anchor,
+        width: params.width,
+        fade_ms: params.fade_ms,
+        ranges,
+    })
+}
+
+/// Convert a nullable C string parameter
+///
+/// # Safety
+/// - `ptr` must be a valid null-terminated string or null
+unsafe fn optional_string(ptr: *const c_char, error: &str) -> Result<Option<String>, FfiResult> {
+    if ptr.is_null() {
+        return Ok(None);
+    }
+    match CStr::from_ptr(ptr).to_str() {
+        Ok(s) => Ok(Some(s.to_string())),
+        Err(_) => Err(FfiResult::error(ErrorCode::InvalidInput, error)),
+    }
+}
+
+/// Convert a required C string parameter
+///
+/// `name` describes the parameter in error messages, e.g. "Video path".
+///
+/// # Safety
+/// - `ptr` must be a valid null-terminated string or null
+unsafe fn required_string(ptr: *const c_char, name: &str) -> Result<String, FfiResult> {
+    if ptr.is_null() {
+        return Err(FfiResult::error(
+            ErrorCode::InvalidInput,
+            &format!("{} is null", name),
+        ));
+    }
+    match CStr::from_ptr(ptr).to_str() {
+        Ok(s) => Ok(s.to_string()),
+        Err(_) => Err(FfiResult::error(
+            ErrorCode::InvalidInput,
+            &format!("Invalid {}", name.to_lowercase()),
+        )),
+    }
+}
+
+/// FFI optional audio parameters
+///
+/// Zero values select the defaults.
+#[repr(C)]
+pub struct FfiAudioParams {
+    pub fit: AudioFit,
+    pub codec: AudioCodec,
+    pub bitrate: u32,
+    pub gain: f32,
+    pub fade_in_ms: u32,
+    pub fade_out_ms: u32,
+}
+
+/// FFI audio visualization parameters
+///
+/// Zero values (null pointers, 0 numbers) select the defaults.
+#[repr(C)]
+pub struct FfiVisualizeParams {
+    pub style: VisualStyle,
+    pub width: u32,
+    pub height: u32,
+    pub color: *const FfiColor,
+    pub background: *const FfiColor,
+    pub background_image: *const c_char,
+}
+
+/// Build visualization settings from optional parameters
+///
+/// # Safety
+/// - `params` must be null or point to a valid `FfiVisualizeParams`
+unsafe fn visualization(params: *const FfiVisualizeParams) -> Result<Visualization, FfiResult> {
+    let mut visual = Visualization::default();
+    if params.is_null() {
+        return Ok(visual);
+    }
+
+    let params = &*params;
+    visual.style = params.style;
+    visual.width = params.width;
+    visual.height = params.height;
+    if !params.color.is_null() {
+        let color = &*params.color;
+        visual.color = Color {
+            r: color.r,
+            g: color.g,
+            b: color.b,
+        };
+    }
+    if !params.background.is_null() {
+        let bg = &*params.background;
+        visual.background = Some(Color {
+            r: bg.r,
+            g: bg.g,
+            b: bg.b,
+        });
+    }
+    visual.background_image =
+        optional_string(params.background_image, "Invalid background image path")?;
+    Ok(visual)
+}
+
+/// Build audio options from the ffmpeg path and optional parameters
+///
+/// # Safety
+/// - `ffmpeg_path` must be a valid null-terminated string or null
+/// - `params` must be null or point to a valid `FfiAudioParams`
+unsafe fn audio_options(
+    ffmpeg_path: *const c_char,
+    params: *const FfiAudioParams,
+) -> Result<AudioOptions, FfiResult> {
+    let mut options = AudioOptions {
+        ffmpeg_path: optional_string(ffmpeg_path, "Invalid ffmpeg path")?,
+        ..Default::default()
+    };
+
+    if !params.is_null() {
+        let params = &*params;
+        options.fit = params.fit;
+        options.codec = params.codec;
+        options.bitrate = params.bitrate;
+        options.gain = params.gain;
+        options.fade_in_ms = params.fade_in_ms;
+        options.fade_out_ms = params.fade_out_ms;
+    }
+
+    Ok(options)
 }
 
 /// FFI color structure
@@ -64,8 +623,178 @@ pub unsafe extern "C" fn minmpeg_available(codec: Codec, ffmpeg_path: *const c_c
 
     match available(codec, ffmpeg_path) {
         Ok(_) => FfiResult::ok(),
-        Err(e) => FfiResult::error(ErrorCode::from(&e), &e.to_string()),
+        Err(e) => FfiResult::from_error(&e),
+    }
+}
+
+/// FFI description of an ffmpeg executable
+#[repr(C)]
+pub struct FfiFfmpegInfo {
+    pub path: *mut c_char,
+    pub version: *mut c_char,
+    /// Names of the enabled encoders separated by spaces
+    pub encoders: *mut c_char,
+}
+
+/// Find ffmpeg and report its version and encoders
+///
+/// The strings written to `info` are freed with `minmpeg_free_ffmpeg_info`.
+///
+/// # Safety
+/// - `ffmpeg_path` must be a valid null-terminated string or null
+/// - `info` must point to a writable `FfiFfmpegInfo`
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_find_ffmpeg(
+    ffmpeg_path: *const c_char,
+    info: *mut FfiFfmpegInfo,
+) -> FfiResult {
+    let ffmpeg_path = match optional_string(ffmpeg_path, "Invalid ffmpeg path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    if info.is_null() {
+        return FfiResult::error(ErrorCode::InvalidInput, "Output pointer is null");
+    }
+
+    let found = match ffmpeg_info(ffmpeg_path.as_deref()) {
+        Ok(found) => found,
+        Err(e) => return FfiResult::from_error(&e),
+    };
+    let string = |s: &str| CString::new(s).map_or(ptr::null_mut(), CString::into_raw);
+    *info = FfiFfmpegInfo {
+        path: string(&found.path),
+        version: string(&found.version),
+        encoders: string(&found.encoders.join(" ")),
+    };
+    FfiResult::ok()
+}
+
+/// Free the strings of an info filled by `minmpeg_find_ffmpeg`
+///
+/// # Safety
+/// - `info` must be null or filled by `minmpeg_find_ffmpeg`, and its strings
+///   must not be used afterwards
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_free_ffmpeg_info(info: *mut FfiFfmpegInfo) {
+    let Some(info) = info.as_mut() else {
+        return;
+    };
+    for string in [&mut info.path, &mut info.version, &mut info.encoders] {
+        if !string.is_null() {
+            let _ = CString::from_raw(*string);
+            *string = ptr::null_mut();
+        }
+    }
+}
+
+/// List the container, codec and encoder combinations usable on this machine
+///
+/// # Safety
+/// - `ffmpeg_path` must be a valid null-terminated string or null
+/// - `capabilities` and `capability_count` must be valid pointers
+/// - The array must be freed with `minmpeg_free_capabilities`
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_capabilities(
+    ffmpeg_path: *const c_char,
+    capabilities_out: *mut *mut Capability,
+    capability_count: *mut size_t,
+) -> FfiResult {
+    let ffmpeg_path = match optional_string(ffmpeg_path, "Invalid ffmpeg path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    if capabilities_out.is_null() || capability_count.is_null() {
+        return FfiResult::error(ErrorCode::InvalidInput, "Output pointer is null");
+    }
+
+    let result = capabilities(ffmpeg_path.as_deref()).into_boxed_slice();
+    *capability_count = result.len();
+    *capabilities_out = Box::into_raw(result) as *mut Capability;
+    FfiResult::ok()
+}
+
+/// Free capabilities returned by `minmpeg_capabilities`
+///
+/// # Safety
+/// - `capabilities` must be null or an array returned by
+///   `minmpeg_capabilities` with its `capability_count`, and must not be
+///   used afterwards
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_free_capabilities(
+    capabilities: *mut Capability,
+    capability_count: size_t,
+) {
+    if capabilities.is_null() {
+        return;
     }
+    let _ = Box::from_raw(ptr::slice_from_raw_parts_mut(
+        capabilities,
+        capability_count,
+    ));
+}
+
+/// Create a session keeping encoders warm across jobs, freed with
+/// `minmpeg_session_free`
+#[no_mangle]
+pub extern "C" fn minmpeg_session_new() -> *mut Session {
+    Box::into_raw(Box::new(Session::new()))
+}
+
+/// Free a session; jobs running with it keep its spare encoders until they
+/// end
+///
+/// # Safety
+/// - `session` must be null or returned by `minmpeg_session_new`, and must
+///   not be used afterwards
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_session_free(session: *mut Session) {
+    if !session.is_null() {
+        drop(Box::from_raw(session));
+    }
+}
+
+/// Convert an array of FFI slide entries
+///
+/// # Safety
+/// - `entries` must point to a valid array of `FfiSlideEntry` with `entry_count` elements
+unsafe fn slide_entries(
+    entries: *const FfiSlideEntry,
+    entry_count: size_t,
+) -> Result<Vec<SlideEntry>, FfiResult> {
+    slice::from_raw_parts(entries, entry_count)
+        .iter()
+        .map(|entry| slide_entry(entry))
+        .collect()
+}
+
+/// Convert an FFI slide entry
+///
+/// # Safety
+/// - The strings of `entry` must be valid null-terminated strings or null
+unsafe fn slide_entry(entry: &FfiSlideEntry) -> Result<SlideEntry, FfiResult> {
+    if entry.path.is_null() {
+        return Err(FfiResult::error(
+            ErrorCode::InvalidInput,
+            "Slide path is null",
+        ));
+    }
+
+    let path = match CStr::from_ptr(entry.path).to_str() {
+        Ok(s) => s.to_string(),
+        Err(_) => {
+            return Err(FfiResult::error(
+                ErrorCode::InvalidInput,
+                "Invalid slide path",
+            ))
+        }
+    };
+
+    Ok(SlideEntry {
+        path,
+        duration_ms: entry.duration_ms,
+        caption: optional_string(entry.caption, "Invalid caption")?,
+        audio: optional_string(entry.audio, "Invalid slide audio path")?,
+    })
 }
 
 /// Create a slideshow video from images
@@ -74,6 +803,7 @@ pub unsafe extern "C" fn minmpeg_available(codec: Codec, ffmpeg_path: *const c_c
 /// - `entries` must point to a valid array of `FfiSlideEntry` with `entry_count` elements
 /// - `output_path` must be a valid null-terminated string
 /// - `ffmpeg_path` must be a valid null-terminated string or null
+/// - `params` must point to a valid `FfiEncodeParams` or be null
 #[no_mangle]
 pub unsafe extern "C" fn minmpeg_slideshow(
     entries: *const FfiSlideEntry,
@@ -83,7 +813,9 @@ pub unsafe extern "C" fn minmpeg_slideshow(
     codec: Codec,
     quality: u8,
     ffmpeg_path: *const c_char,
+    params: *const FfiEncodeParams,
 ) -> FfiResult {
+    let _language = CallLanguage::of_params(params);
     // Validate inputs
     if entries.is_null() || entry_count == 0 {
         return FfiResult::error(ErrorCode::InvalidInput, "No slides provided");
@@ -110,123 +842,1178 @@ pub unsafe extern "C" fn minmpeg_slideshow(
     };
 
     // Convert slide entries
-    let ffi_entries = slice::from_raw_parts(entries, entry_count);
-    let mut slide_entries: Vec<SlideEntry> = Vec::with_capacity(entry_count);
-
-    for entry in ffi_entries {
-        if entry.path.is_null() {
-            return FfiResult::error(ErrorCode::InvalidInput, "Slide path is null");
-        }
-
-        let path = match CStr::from_ptr(entry.path).to_str() {
-            Ok(s) => s.to_string(),
-            Err(_) => return FfiResult::error(ErrorCode::InvalidInput, "Invalid slide path"),
-        };
-
-        slide_entries.push(SlideEntry {
-            path,
-            duration_ms: entry.duration_ms,
-        });
-    }
+    let slide_entries = match slide_entries(entries, entry_count) {
+        Ok(e) => e,
+        Err(e) => return e,
+    };
 
     // Create encode options
-    let options = EncodeOptions {
+    let mut options = EncodeOptions {
         output_path,
         container,
         codec,
         quality,
         ffmpeg_path,
+        ..Default::default()
     };
 
+    if let Err(e) = apply_params(&mut options, params) {
+        return e;
+    }
+
     // Run slideshow
     match slideshow(&slide_entries, &options) {
         Ok(_) => FfiResult::ok(),
-        Err(e) => FfiResult::error(ErrorCode::from(&e), &e.to_string()),
+        Err(e) => FfiResult::from_error(&e),
     }
 }
 
-/// Combine two videos side by side
+/// Create a slideshow video from slides yielded one at a time by a callback
+///
+/// Each slide is encoded before the next is asked for, so the slides need
+/// not be held in memory; see [`crate::slideshow_from_iter`] for the
+/// options that are unavailable.
 ///
 /// # Safety
-/// - `left_path`, `right_path`, and `output_path` must be valid null-terminated strings
-/// - `background` can be null (defaults to white)
-/// - `ffmpeg_path` can be null
+/// - `next` must be a valid callback filling the entry with strings that
+///   stay valid until it is called again
+/// - `output_path` must be a valid null-terminated string
+/// - `ffmpeg_path` must be a valid null-terminated string or null
+/// - `params` must point to a valid `FfiEncodeParams` or be null
 #[no_mangle]
-pub unsafe extern "C" fn minmpeg_juxtapose(
-    left_path: *const c_char,
-    right_path: *const c_char,
+pub unsafe extern "C" fn minmpeg_slideshow_stream(
+    next: Option<FfiSlideSource>,
+    user_data: *mut c_void,
     output_path: *const c_char,
     container: Container,
     codec: Codec,
     quality: u8,
-    background: *const FfiColor,
     ffmpeg_path: *const c_char,
+    params: *const FfiEncodeParams,
 ) -> FfiResult {
-    // Validate inputs
-    if left_path.is_null() {
-        return FfiResult::error(ErrorCode::InvalidInput, "Left video path is null");
-    }
-
-    if right_path.is_null() {
-        return FfiResult::error(ErrorCode::InvalidInput, "Right video path is null");
-    }
-
-    if output_path.is_null() {
-        return FfiResult::error(ErrorCode::InvalidInput, "Output path is null");
-    }
-
-    // Convert paths
-    let left_path = match CStr::from_ptr(left_path).to_str() {
-        Ok(s) => s,
-        Err(_) => return FfiResult::error(ErrorCode::InvalidInput, "Invalid left video path"),
+    let _language = CallLanguage::of_params(params);
+    let Some(next) = next else {
+        return FfiResult::error(ErrorCode::InvalidInput, "Slide source is null");
     };
-
-    let right_path = match CStr::from_ptr(right_path).to_str() {
+    let output_path = match required_string(output_path, "Output path") {
         Ok(s) => s,
-        Err(_) => return FfiResult::error(ErrorCode::InvalidInput, "Invalid right video path"),
-    };
-
-    let output_path = match CStr::from_ptr(output_path).to_str() {
-        Ok(s) => s.to_string(),
-        Err(_) => return FfiResult::error(ErrorCode::InvalidInput, "Invalid output path"),
-    };
-
-    // Convert ffmpeg path
-    let ffmpeg_path = if ffmpeg_path.is_null() {
-        None
-    } else {
-        match CStr::from_ptr(ffmpeg_path).to_str() {
-            Ok(s) => Some(s.to_string()),
-            Err(_) => return FfiResult::error(ErrorCode::InvalidInput, "Invalid ffmpeg path"),
-        }
+        Err(e) => return e,
     };
-
-    // Convert background color
-    let bg_color = if background.is_null() {
-        None
-    } else {
-        let bg = &*background;
-        Some(Color {
-            r: bg.r,
-            g: bg.g,
-            b: bg.b,
-        })
+    let ffmpeg_path = match optional_string(ffmpeg_path, "Invalid ffmpeg path") {
+        Ok(s) => s,
+        Err(e) => return e,
     };
 
-    // Create encode options
-    let options = EncodeOptions {
+    let mut options = EncodeOptions {
         output_path,
         container,
         codec,
         quality,
         ffmpeg_path,
+        ..Default::default()
     };
+    if let Err(e) = apply_params(&mut options, params) {
+        return e;
+    }
+
+    // An invalid entry is returned as it is, not as the error it ends the
+    // slideshow with
+    let mut invalid_entry = None;
+    let slides = std::iter::from_fn(|| {
+        let mut entry = FfiSlideEntry {
+            path: ptr::null(),
+            duration_ms: 0,
+            caption: ptr::null(),
+            audio: ptr::null(),
+        };
+        match next(&mut entry, user_data) {
+            0 => None,
+            n if n > 0 => Some(slide_entry(&entry).map_err(|e| {
+                invalid_entry = Some(e);
+                Error::InvalidInput("Invalid slide entry".to_string())
+            })),
+            _ => Some(Err(Error::InvalidInput(
+                "The slide source failed".to_string(),
+            ))),
+        }
+    });
+
+    match slideshow_from_iter(slides, &options) {
+        Ok(_) => FfiResult::ok(),
+        Err(e) => invalid_entry.unwrap_or_else(|| FfiResult::from_error(&e)),
+    }
+}
+
+/// Video writer of the C API, keeping the message language of the
+/// parameters it was created with for its later calls
+pub struct FfiVideoWriter {
+    writer: VideoWriter,
+    language: MessageLanguage,
+}
+
+/// Start writing a video frame by frame, finished with
+/// `minmpeg_writer_finish` or abandoned with `minmpeg_writer_free`
+///
+/// On success the writer is stored in `writer`; see [`crate::VideoWriter`]
+/// for the options that are unavailable.
+///
+/// # Safety
+/// - `output_path` must be a valid null-terminated string
+/// - `ffmpeg_path` must be a valid null-terminated string or null
+/// - `params` must point to a valid `FfiEncodeParams` or be null
+/// - `writer` must point to writable memory for a pointer
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_writer_new(
+    output_path: *const c_char,
+    width: u32,
+    height: u32,
+    container: Container,
+    codec: Codec,
+    quality: u8,
+    ffmpeg_path: *const c_char,
+    params: *const FfiEncodeParams,
+    writer: *mut *mut FfiVideoWriter,
+) -> FfiResult {
+    let _language = CallLanguage::of_params(params);
+    if writer.is_null() {
+        return FfiResult::error(ErrorCode::InvalidInput, "Writer pointer is null");
+    }
+    let output_path = match required_string(output_path, "Output path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let ffmpeg_path = match optional_string(ffmpeg_path, "Invalid ffmpeg path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+
+    let mut options = EncodeOptions {
+        output_path,
+        container,
+        codec,
+        quality,
+        ffmpeg_path,
+        ..Default::default()
+    };
+    if let Err(e) = apply_params(&mut options, params) {
+        return e;
+    }
+
+    match VideoWriter::new(&options, width, height) {
+        Ok(w) => {
+            let language = params
+                .as_ref()
+                .map_or(MessageLanguage::Auto, |p| p.language);
+            *writer = Box::into_raw(Box::new(FfiVideoWriter {
+                writer: w,
+                language,
+            }));
+            FfiResult::ok()
+        }
+        Err(e) => FfiResult::from_error(&e),
+    }
+}
+
+/// Encode a frame of `width * height * 4` bytes of RGBA pixels
+///
+/// # Safety
+/// - `writer` must be returned by `minmpeg_writer_new` and not yet finished
+///   or freed
+/// - `rgba` must point to `size` readable bytes
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_writer_write(
+    writer: *mut FfiVideoWriter,
+    rgba: *const u8,
+    size: size_t,
+) -> FfiResult {
+    if writer.is_null() || rgba.is_null() {
+        return FfiResult::error(ErrorCode::InvalidInput, "Writer or frame is null");
+    }
+    let _language = CallLanguage::enter((*writer).language);
+    match (*writer)
+        .writer
+        .write_frame(slice::from_raw_parts(rgba, size))
+    {
+        Ok(()) => FfiResult::ok(),
+        Err(e) => FfiResult::from_error(&e),
+    }
+}
+
+/// Add `sample_count` interleaved 16-bit PCM samples to the audio of a
+/// writer
+///
+/// # Safety
+/// - `writer` must be returned by `minmpeg_writer_new` and not yet finished
+///   or freed
+/// - `samples` must point to `sample_count` readable samples
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_writer_write_audio(
+    writer: *mut FfiVideoWriter,
+    samples: *const i16,
+    sample_count: size_t,
+    sample_rate: u32,
+    channels: u16,
+) -> FfiResult {
+    if writer.is_null() || samples.is_null() {
+        return FfiResult::error(ErrorCode::InvalidInput, "Writer or samples are null");
+    }
+    let _language = CallLanguage::enter((*writer).language);
+    match (*writer).writer.write_audio(
+        slice::from_raw_parts(samples, sample_count),
+        sample_rate,
+        channels,
+    ) {
+        Ok(()) => FfiResult::ok(),
+        Err(e) => FfiResult::from_error(&e),
+    }
+}
+
+/// Write the output of a writer and free it
+///
+/// # Safety
+/// - `writer` must be returned by `minmpeg_writer_new` and not yet finished
+///   or freed; it must not be used afterwards
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_writer_finish(writer: *mut FfiVideoWriter) -> FfiResult {
+    if writer.is_null() {
+        return FfiResult::error(ErrorCode::InvalidInput, "Writer is null");
+    }
+    let writer = Box::from_raw(writer);
+    let _language = CallLanguage::enter(writer.language);
+    match writer.writer.finish() {
+        Ok(()) => FfiResult::ok(),
+        Err(e) => FfiResult::from_error(&e),
+    }
+}
+
+/// Free a writer without writing its output
+///
+/// # Safety
+/// - `writer` must be null or returned by `minmpeg_writer_new` and not yet
+///   finished or freed; it must not be used afterwards
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_writer_free(writer: *mut FfiVideoWriter) {
+    if !writer.is_null() {
+        drop(Box::from_raw(writer));
+    }
+}
+
+/// Start reading the frames of a video, freed with `minmpeg_reader_free`
+///
+/// On success the reader is stored in `reader` and the frame size in
+/// `width` and `height`.
+///
+/// # Safety
+/// - `path` must be a valid null-terminated string
+/// - `ffmpeg_path` must be a valid null-terminated string or null
+/// - `reader` must point to writable memory for a pointer, and `width` and
+///   `height` to writable `u32`s
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_reader_open(
+    path: *const c_char,
+    fps: f64,
+    ffmpeg_path: *const c_char,
+    reader: *mut *mut VideoReader,
+    width: *mut u32,
+    height: *mut u32,
+) -> FfiResult {
+    let path = match required_string(path, "Input path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let ffmpeg_path = match optional_string(ffmpeg_path, "Invalid ffmpeg path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    if reader.is_null() || width.is_null() || height.is_null() {
+        return FfiResult::error(ErrorCode::InvalidInput, "Output pointer is null");
+    }
+
+    match VideoReader::open(&path, fps, ffmpeg_path.as_deref()) {
+        Ok(r) => {
+            *width = r.width();
+            *height = r.height();
+            *reader = Box::into_raw(Box::new(r));
+            FfiResult::ok()
+        }
+        Err(e) => FfiResult::from_error(&e),
+    }
+}
+
+/// Decode the next frame of `width * height * 4` bytes of RGBA pixels into
+/// `rgba`, writing its time in milliseconds to `time_ms`, or -1 after the
+/// last frame
+///
+/// # Safety
+/// - `reader` must be returned by `minmpeg_reader_open` and not yet freed
+/// - `rgba` must point to `size` writable bytes and `time_ms` to a writable
+///   `i64`
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_reader_read(
+    reader: *mut VideoReader,
+    rgba: *mut u8,
+    size: size_t,
+    time_ms: *mut i64,
+) -> FfiResult {
+    if reader.is_null() || rgba.is_null() || time_ms.is_null() {
+        return FfiResult::error(ErrorCode::InvalidInput, "Reader or frame is null");
+    }
+    match (*reader).read_frame(slice::from_raw_parts_mut(rgba, size)) {
+        Ok(time) => {
+            *time_ms = time.map_or(-1, |t| t as i64);
+            FfiResult::ok()
+        }
+        Err(e) => FfiResult::from_error(&e),
+    }
+}
+
+/// Stop reading a video and free its reader
+///
+/// # Safety
+/// - `reader` must be null or returned by `minmpeg_reader_open` and not yet
+///   freed; it must not be used afterwards
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_reader_free(reader: *mut VideoReader) {
+    if !reader.is_null() {
+        drop(Box::from_raw(reader));
+    }
+}
+
+/// Create a slideshow video from images, skipping slides that cannot be used
+///
+/// For each slide, the error code of why it was skipped is written to
+/// `skipped`, or `ErrorCode::Ok` when it is in the video.
+///
+/// # Safety
+/// - `entries` must point to a valid array of `FfiSlideEntry` with `entry_count` elements
+/// - `output_path` must be a valid null-terminated string
+/// - `ffmpeg_path` can be null
+/// - `params` can be null
+/// - `skipped` must point to a writable array of `entry_count` elements
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_slideshow_skipping_invalid(
+    entries: *const FfiSlideEntry,
+    entry_count: size_t,
+    output_path: *const c_char,
+    container: Container,
+    codec: Codec,
+    quality: u8,
+    ffmpeg_path: *const c_char,
+    params: *const FfiEncodeParams,
+    skipped: *mut ErrorCode,
+) -> FfiResult {
+    let _language = CallLanguage::of_params(params);
+    if entries.is_null() || entry_count == 0 {
+        return FfiResult::error(ErrorCode::InvalidInput, "No slides provided");
+    }
+    if skipped.is_null() {
+        return FfiResult::error(ErrorCode::InvalidInput, "Output array is null");
+    }
+    let output_path = match required_string(output_path, "Output path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let ffmpeg_path = match optional_string(ffmpeg_path, "Invalid ffmpeg path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let slide_entries = match slide_entries(entries, entry_count) {
+        Ok(e) => e,
+        Err(e) => return e,
+    };
+
+    let mut options = EncodeOptions {
+        output_path,
+        container,
+        codec,
+        quality,
+        ffmpeg_path,
+        ..Default::default()
+    };
+    if let Err(e) = apply_params(&mut options, params) {
+        return e;
+    }
+
+    match slideshow_skipping_invalid(&slide_entries, &options) {
+        Ok(skipped_slides) => {
+            let codes = slice::from_raw_parts_mut(skipped, entry_count);
+            codes.fill(ErrorCode::Ok);
+            for slide in &skipped_slides {
+                codes[slide.index] = ErrorCode::from(&slide.error);
+            }
+            FfiResult::ok()
+        }
+        Err(e) => FfiResult::from_error(&e),
+    }
+}
+
+/// Create a slideshow no larger than `max_bytes`
+///
+/// The quality, then the resolution, is lowered until the output fits. The
+/// chosen settings are written to `settings`.
+///
+/// # Safety
+/// - `entries` must point to a valid array of `FfiSlideEntry` with `entry_count` elements
+/// - `output_path` must be a valid null-terminated string
+/// - `ffmpeg_path` can be null
+/// - `params` can be null
+/// - `settings` must point to a writable `SizedSettings`
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_slideshow_within_size(
+    entries: *const FfiSlideEntry,
+    entry_count: size_t,
+    output_path: *const c_char,
+    container: Container,
+    codec: Codec,
+    quality: u8,
+    max_bytes: u64,
+    ffmpeg_path: *const c_char,
+    params: *const FfiEncodeParams,
+    settings: *mut SizedSettings,
+) -> FfiResult {
+    let _language = CallLanguage::of_params(params);
+    if entries.is_null() || entry_count == 0 {
+        return FfiResult::error(ErrorCode::InvalidInput, "No slides provided");
+    }
+    if settings.is_null() {
+        return FfiResult::error(ErrorCode::InvalidInput, "Output pointer is null");
+    }
+    let output_path = match required_string(output_path, "Output path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let ffmpeg_path = match optional_string(ffmpeg_path, "Invalid ffmpeg path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let slide_entries = match slide_entries(entries, entry_count) {
+        Ok(e) => e,
+        Err(e) => return e,
+    };
+
+    let mut options = EncodeOptions {
+        output_path,
+        container,
+        codec,
+        quality,
+        ffmpeg_path,
+        ..Default::default()
+    };
+    if let Err(e) = apply_params(&mut options, params) {
+        return e;
+    }
+
+    match slideshow_within_size(&slide_entries, &options, max_bytes) {
+        Ok(chosen) => {
+            *settings = chosen;
+            FfiResult::ok()
+        }
+        Err(e) => FfiResult::from_error(&e),
+    }
+}
+
+/// Render a preview of a slideshow as PNG
+///
+/// # Safety
+/// - `entries` must point to a valid array of `FfiSlideEntry` with `entry_count` elements
+/// - `output_path` must be a valid null-terminated string
+/// - `ffmpeg_path` can be null
+/// - `params` can be null
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_preview_slideshow(
+    entries: *const FfiSlideEntry,
+    entry_count: size_t,
+    output_path: *const c_char,
+    layout: PreviewLayout,
+    height: u32,
+    ffmpeg_path: *const c_char,
+    params: *const FfiEncodeParams,
+) -> FfiResult {
+    let _language = CallLanguage::of_params(params);
+    if entries.is_null() || entry_count == 0 {
+        return FfiResult::error(ErrorCode::InvalidInput, "No slides provided");
+    }
+    let output_path = match required_string(output_path, "Output path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let ffmpeg_path = match optional_string(ffmpeg_path, "Invalid ffmpeg path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let slide_entries = match slide_entries(entries, entry_count) {
+        Ok(e) => e,
+        Err(e) => return e,
+    };
+
+    let mut options = EncodeOptions {
+        ffmpeg_path,
+        ..Default::default()
+    };
+    if let Err(e) = apply_params(&mut options, params) {
+        return e;
+    }
+    let preview = PreviewOptions {
+        output_path,
+        layout,
+        height,
+    };
+
+    match preview_slideshow(&slide_entries, &options, &preview) {
+        Ok(_) => FfiResult::ok(),
+        Err(e) => FfiResult::from_error(&e),
+    }
+}
+
+/// Find duplicate consecutive slides
+///
+/// For each slide, the index of the slide it is merged into is written to
+/// `merged_into`: its own index when it is kept, or the index of the first
+/// slide of its run of duplicates.
+///
+/// # Safety
+/// - `entries` must point to a valid array of `FfiSlideEntry` with `entry_count` elements
+/// - `merged_into` must point to a writable array of `entry_count` elements
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_find_duplicate_slides(
+    entries: *const FfiSlideEntry,
+    entry_count: size_t,
+    mode: DuplicateMatch,
+    merged_into: *mut size_t,
+) -> FfiResult {
+    if entries.is_null() || entry_count == 0 {
+        return FfiResult::error(ErrorCode::InvalidInput, "No slides provided");
+    }
+    if merged_into.is_null() {
+        return FfiResult::error(ErrorCode::InvalidInput, "Output array is null");
+    }
+
+    let slide_entries = match slide_entries(entries, entry_count) {
+        Ok(e) => e,
+        Err(e) => return e,
+    };
+
+    match find_duplicate_slides(&slide_entries, mode) {
+        Ok(targets) => {
+            slice::from_raw_parts_mut(merged_into, entry_count).copy_from_slice(&targets);
+            FfiResult::ok()
+        }
+        Err(e) => FfiResult::from_error(&e),
+    }
+}
+
+/// Combine two videos side by side
+///
+/// # Safety
+/// - `left_path`, `right_path`, and `output_path` must be valid null-terminated strings
+/// - `background` can be null (defaults to white)
+/// - `ffmpeg_path` can be null
+/// - `params` can be null
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_juxtapose(
+    left_path: *const c_char,
+    right_path: *const c_char,
+    output_path: *const c_char,
+    container: Container,
+    codec: Codec,
+    quality: u8,
+    background: *const FfiColor,
+    ffmpeg_path: *const c_char,
+    params: *const FfiEncodeParams,
+) -> FfiResult {
+    let _language = CallLanguage::of_params(params);
+    // Validate inputs
+    if left_path.is_null() {
+        return FfiResult::error(ErrorCode::InvalidInput, "Left video path is null");
+    }
+
+    if right_path.is_null() {
+        return FfiResult::error(ErrorCode::InvalidInput, "Right video path is null");
+    }
+
+    if output_path.is_null() {
+        return FfiResult::error(ErrorCode::InvalidInput, "Output path is null");
+    }
+
+    // Convert paths
+    let left_path = match CStr::from_ptr(left_path).to_str() {
+        Ok(s) => s,
+        Err(_) => return FfiResult::error(ErrorCode::InvalidInput, "Invalid left video path"),
+    };
+
+    let right_path = match CStr::from_ptr(right_path).to_str() {
+        Ok(s) => s,
+        Err(_) => return FfiResult::error(ErrorCode::InvalidInput, "Invalid right video path"),
+    };
+
+    let output_path = match CStr::from_ptr(output_path).to_str() {
+        Ok(s) => s.to_string(),
+        Err(_) => return FfiResult::error(ErrorCode::InvalidInput, "Invalid output path"),
+    };
+
+    // Convert ffmpeg path
+    let ffmpeg_path = if ffmpeg_path.is_null() {
+        None
+    } else {
+        match CStr::from_ptr(ffmpeg_path).to_str() {
+            Ok(s) => Some(s.to_string()),
+            Err(_) => return FfiResult::error(ErrorCode::InvalidInput, "Invalid ffmpeg path"),
+        }
+    };
+
+    // Convert background color
+    let bg_color = if background.is_null() {
+        None
+    } else {
+        let bg = &*background;
+        Some(Color {
+            r: bg.r,
+            g: bg.g,
+            b: bg.b,
+        })
+    };
+
+    // Create encode options
+    let mut options = EncodeOptions {
+        output_path,
+        container,
+        codec,
+        quality,
+        ffmpeg_path,
+        ..Default::default()
+    };
+
+    if let Err(e) = apply_params(&mut options, params) {
+        return e;
+    }
 
     // Run juxtapose
     match juxtapose(left_path, right_path, &options, bg_color) {
         Ok(_) => FfiResult::ok(),
-        Err(e) => FfiResult::error(ErrorCode::from(&e), &e.to_string()),
+        Err(e) => FfiResult::from_error(&e),
+    }
+}
+
+/// Create a video visualizing an audio file
+///
+/// # Safety
+/// - `audio_path` and `output_path` must be valid null-terminated strings
+/// - `visual` must be null or point to a valid `FfiVisualizeParams`
+/// - `ffmpeg_path` must be a valid null-terminated string or null
+/// - `params` can be null
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_visualize_audio(
+    audio_path: *const c_char,
+    output_path: *const c_char,
+    container: Container,
+    codec: Codec,
+    quality: u8,
+    visual: *const FfiVisualizeParams,
+    ffmpeg_path: *const c_char,
+    params: *const FfiEncodeParams,
+) -> FfiResult {
+    let _language = CallLanguage::of_params(params);
+    let audio_path = match required_string(audio_path, "Audio path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let output_path = match required_string(output_path, "Output path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let ffmpeg_path = match optional_string(ffmpeg_path, "Invalid ffmpeg path") {
+        Ok(p) => p,
+        Err(e) => return e,
+    };
+    let visual = match visualization(visual) {
+        Ok(v) => v,
+        Err(e) => return e,
+    };
+
+    let mut options = EncodeOptions {
+        output_path,
+        container,
+        codec,
+        quality,
+        ffmpeg_path,
+        ..Default::default()
+    };
+
+    if let Err(e) = apply_params(&mut options, params) {
+        return e;
+    }
+
+    match visualize_audio(&audio_path, &options, &visual) {
+        Ok(_) => FfiResult::ok(),
+        Err(e) => FfiResult::from_error(&e),
+    }
+}
+
+/// Create a video showing a still image for the length of an audio file
+///
+/// # Safety
+/// - `image_path`, `audio_path` and `output_path` must be valid null-terminated strings
+/// - `ffmpeg_path` must be a valid null-terminated string or null
+/// - `params` can be null
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_image_with_audio(
+    image_path: *const c_char,
+    audio_path: *const c_char,
+    output_path: *const c_char,
+    container: Container,
+    codec: Codec,
+    quality: u8,
+    ffmpeg_path: *const c_char,
+    params: *const FfiEncodeParams,
+) -> FfiResult {
+    let _language = CallLanguage::of_params(params);
+    let image_path = match required_string(image_path, "Image path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let audio_path = match required_string(audio_path, "Audio path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let output_path = match required_string(output_path, "Output path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let ffmpeg_path = match optional_string(ffmpeg_path, "Invalid ffmpeg path") {
+        Ok(p) => p,
+        Err(e) => return e,
+    };
+
+    let mut options = EncodeOptions {
+        output_path,
+        container,
+        codec,
+        quality,
+        ffmpeg_path,
+        ..Default::default()
+    };
+
+    if let Err(e) = apply_params(&mut options, params) {
+        return e;
+    }
+
+    match image_with_audio(&image_path, &audio_path, &options) {
+        Ok(_) => FfiResult::ok(),
+        Err(e) => FfiResult::from_error(&e),
+    }
+}
+
+/// Replace the audio track of a video
+///
+/// # Safety
+/// - `video_path`, `audio_path` and `output_path` must be valid null-terminated strings
+/// - `ffmpeg_path` must be a valid null-terminated string or null
+/// - `params` must be null or point to a valid `FfiAudioParams`
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_replace_audio(
+    video_path: *const c_char,
+    audio_path: *const c_char,
+    output_path: *const c_char,
+    ffmpeg_path: *const c_char,
+    params: *const FfiAudioParams,
+) -> FfiResult {
+    let video_path = match required_string(video_path, "Video path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let audio_path = match required_string(audio_path, "Audio path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let output_path = match required_string(output_path, "Output path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let options = match audio_options(ffmpeg_path, params) {
+        Ok(o) => o,
+        Err(e) => return e,
+    };
+
+    match replace_audio(&video_path, &audio_path, &output_path, &options) {
+        Ok(_) => FfiResult::ok(),
+        Err(e) => FfiResult::from_error(&e),
+    }
+}
+
+/// Extract the audio of a video to an audio file
+///
+/// # Safety
+/// - `input_path` and `output_path` must be valid null-terminated strings
+/// - `ffmpeg_path` must be a valid null-terminated string or null
+/// - `params` must be null or point to a valid `FfiAudioParams`
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_extract_audio(
+    input_path: *const c_char,
+    output_path: *const c_char,
+    ffmpeg_path: *const c_char,
+    params: *const FfiAudioParams,
+) -> FfiResult {
+    let input_path = match required_string(input_path, "Input path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let output_path = match required_string(output_path, "Output path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let options = match audio_options(ffmpeg_path, params) {
+        Ok(o) => o,
+        Err(e) => return e,
+    };
+
+    match extract_audio(&input_path, &output_path, &options) {
+        Ok(_) => FfiResult::ok(),
+        Err(e) => FfiResult::from_error(&e),
+    }
+}
+
+/// Remove all audio from a video
+///
+/// # Safety
+/// - `input_path` and `output_path` must be valid null-terminated strings
+/// - `ffmpeg_path` must be a valid null-terminated string or null
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_remove_audio(
+    input_path: *const c_char,
+    output_path: *const c_char,
+    ffmpeg_path: *const c_char,
+) -> FfiResult {
+    let input_path = match required_string(input_path, "Input path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let output_path = match required_string(output_path, "Output path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let options = match audio_options(ffmpeg_path, ptr::null()) {
+        Ok(o) => o,
+        Err(e) => return e,
+    };
+
+    match remove_audio(&input_path, &output_path, &options) {
+        Ok(_) => FfiResult::ok(),
+        Err(e) => FfiResult::from_error(&e),
+    }
+}
+
+/// Analyze the luma histogram and clipping of an image or video
+///
+/// The aggregate statistics are written to `aggregate`. When `frames` is
+/// not null, an array of per-frame statistics is allocated and written to
+/// `frames` with its length in `frame_count`; free it with
+/// `minmpeg_free_luma_stats`.
+///
+/// # Safety
+/// - `path` must be a valid null-terminated string
+/// - `ffmpeg_path` must be a valid null-terminated string or null
+/// - `aggregate` must point to a writable `LumaStats`
+/// - `frames` must be null or point to a writable pointer, with `frame_count`
+///   pointing to a writable `size_t`
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_analyze_luma(
+    path: *const c_char,
+    ffmpeg_path: *const c_char,
+    aggregate: *mut LumaStats,
+    frames: *mut *mut LumaStats,
+    frame_count: *mut size_t,
+) -> FfiResult {
+    let path = match required_string(path, "Input path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let ffmpeg_path = match optional_string(ffmpeg_path, "Invalid ffmpeg path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    if aggregate.is_null() || (!frames.is_null() && frame_count.is_null()) {
+        return FfiResult::error(ErrorCode::InvalidInput, "Output pointer is null");
+    }
+
+    let analysis = match analyze_luma(&path, ffmpeg_path.as_deref()) {
+        Ok(a) => a,
+        Err(e) => return FfiResult::from_error(&e),
+    };
+
+    *aggregate = analysis.aggregate;
+    if !frames.is_null() {
+        let stats = analysis.frames.into_boxed_slice();
+        *frame_count = stats.len();
+        *frames = Box::into_raw(stats) as *mut LumaStats;
+    }
+    FfiResult::ok()
+}
+
+/// Free per-frame statistics returned by `minmpeg_analyze_luma`
+///
+/// # Safety
+/// - `frames` must be null or an array returned by `minmpeg_analyze_luma`
+///   with its `frame_count`, and must not be used afterwards
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_free_luma_stats(frames: *mut LumaStats, frame_count: size_t) {
+    if frames.is_null() {
+        return;
+    }
+    let _ = Box::from_raw(ptr::slice_from_raw_parts_mut(frames, frame_count));
+}
+
+/// Compute perceptual hashes of frames sampled evenly over an image or video
+///
+/// An array of up to `count` hashes is allocated and written to `hashes`
+/// with its length in `hash_count`; free it with `minmpeg_free_frame_hashes`.
+///
+/// # Safety
+/// - `path` must be a valid null-terminated string
+/// - `ffmpeg_path` must be a valid null-terminated string or null
+/// - `hashes` must point to a writable pointer and `hash_count` to a
+///   writable `size_t`
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_frame_hashes(
+    path: *const c_char,
+    count: u32,
+    ffmpeg_path: *const c_char,
+    hashes: *mut *mut FrameHash,
+    hash_count: *mut size_t,
+) -> FfiResult {
+    let path = match required_string(path, "Input path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    let ffmpeg_path = match optional_string(ffmpeg_path, "Invalid ffmpeg path") {
+        Ok(s) => s,
+        Err(e) => return e,
+    };
+    if hashes.is_null() || hash_count.is_null() {
+        return FfiResult::error(ErrorCode::InvalidInput, "Output pointer is null");
+    }
+
+    let result = match frame_hashes(&path, count, ffmpeg_path.as_deref()) {
+        Ok(h) => h.into_boxed_slice(),
+        Err(e) => return FfiResult::from_error(&e),
+    };
+    *hash_count = result.len();
+    *hashes = Box::into_raw(result) as *mut FrameHash;
+    FfiResult::ok()
+}
+
+/// Free hashes returned by `minmpeg_frame_hashes`
+///
+/// # Safety
+/// - `hashes` must be null or an array returned by `minmpeg_frame_hashes`
+///   with its `hash_count`, and must not be used afterwards
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_free_frame_hashes(hashes: *mut FrameHash, hash_count: size_t) {
+    if hashes.is_null() {
+        return;
+    }
+    let _ = Box::from_raw(ptr::slice_from_raw_parts_mut(hashes, hash_count));
+}
+
+/// FFI benchmark workload
+///
+/// Zero values (null pointers, 0 numbers) select the defaults.
+#[repr(C)]
+pub struct FfiBenchmarkSpec {
+    pub codecs: *const Codec,
+    pub codec_count: size_t,
+    pub width: u32,
+    pub height: u32,
+    pub duration_ms: u32,
+    pub fps: u32,
+    pub quality: u8,
+    pub output_dir: *const c_char,
+    pub ffmpeg_path: *const c_char,
+}
+
+/// FFI measurements of one codec in a benchmark
+///
+/// Metrics the platform or ffmpeg cannot provide are -1.
+#[repr(C)]
+pub struct FfiBenchmarkResult {
+    pub codec: Codec,
+    pub container: Container,
+    pub error: FfiResult,
+    pub frames: u32,
+    pub encode_ms: u64,
+    pub encode_fps: f64,
+    pub cpu_percent: f64,
+    pub output_bytes: u64,
+    pub bitrate_kbps: u32,
+    pub psnr: f64,
+    pub ssim: f64,
+}
+
+/// FFI result of a benchmark
+#[repr(C)]
+pub struct FfiBenchmarkReport {
+    pub width: u32,
+    pub height: u32,
+    pub fps: u32,
+    pub frames: u32,
+    pub cpu_count: u32,
+    pub results: *mut FfiBenchmarkResult,
+    pub result_count: size_t,
+}
+
+/// Encode a standard workload with each codec and measure speed, CPU use
+/// and quality
+///
+/// The measurements are written to `report`, with an array of results
+/// allocated for its `results`; free it with `minmpeg_free_benchmark_report`.
+///
+/// # Safety
+/// - `spec` can be null (defaults)
+/// - `spec.codecs` must point to `spec.codec_count` codecs or be null
+/// - `report` must point to a writable `FfiBenchmarkReport`
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_benchmark(
+    spec: *const FfiBenchmarkSpec,
+    report: *mut FfiBenchmarkReport,
+) -> FfiResult {
+    if report.is_null() {
+        return FfiResult::error(ErrorCode::InvalidInput, "Output pointer is null");
+    }
+
+    let mut benchmark_spec = BenchmarkSpec::default();
+    if let Some(spec) = spec.as_ref() {
+        if !spec.codecs.is_null() {
+            benchmark_spec.codecs = slice::from_raw_parts(spec.codecs, spec.codec_count).to_vec();
+        }
+        benchmark_spec.width = spec.width;
+        benchmark_spec.height = spec.height;
+        benchmark_spec.duration_ms = spec.duration_ms;
+        benchmark_spec.fps = spec.fps;
+        benchmark_spec.quality = spec.quality;
+        benchmark_spec.output_dir =
+            match optional_string(spec.output_dir, "Invalid benchmark directory") {
+                Ok(s) => s,
+                Err(e) => return e,
+            };
+        benchmark_spec.ffmpeg_path = match optional_string(spec.ffmpeg_path, "Invalid ffmpeg path")
+        {
+            Ok(s) => s,
+            Err(e) => return e,
+        };
+    }
+
+    let result = match benchmark(&benchmark_spec) {
+        Ok(r) => r,
+        Err(e) => return FfiResult::from_error(&e),
+    };
+
+    let results: Box<[FfiBenchmarkResult]> = result
+        .results
+        .iter()
+        .map(|r| FfiBenchmarkResult {
+            codec: r.codec,
+            container: r.container,
+            error: r
+                .error
+                .as_ref()
+                .map_or_else(FfiResult::ok, FfiResult::from_error),
+            frames: r.frames,
+            encode_ms: r.encode_ms,
+            encode_fps: r.encode_fps,
+            cpu_percent: r.cpu_percent.unwrap_or(-1.0),
+            output_bytes: r.output_bytes,
+            bitrate_kbps: r.bitrate_kbps,
+            psnr: r.psnr.unwrap_or(-1.0),
+            ssim: r.ssim.unwrap_or(-1.0),
+        })
+        .collect();
+    *report = FfiBenchmarkReport {
+        width: result.width,
+        height: result.height,
+        fps: result.fps,
+        frames: result.frames,
+        cpu_count: result.cpu_count,
+        result_count: results.len(),
+        results: Box::into_raw(results) as *mut FfiBenchmarkResult,
+    };
+    FfiResult::ok()
+}
+
+/// Free the results of a report filled by `minmpeg_benchmark`, including
+/// the messages of their errors
+///
+/// # Safety
+/// - `report` must be null or a report filled by `minmpeg_benchmark`, and
+///   its results must not be used afterwards
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_free_benchmark_report(report: *mut FfiBenchmarkReport) {
+    let Some(report) = report.as_mut() else {
+        return;
+    };
+    if report.results.is_null() {
+        return;
+    }
+    let mut results = Box::from_raw(ptr::slice_from_raw_parts_mut(
+        report.results,
+        report.result_count,
+    ));
+    for result in results.iter_mut() {
+        minmpeg_free_result(&mut result.error);
+    }
+    report.results = ptr::null_mut();
+    report.result_count = 0;
+}
+
+/// Register a font for caption rendering
+///
+/// # Safety
+/// - `name` must be a valid null-terminated string
+/// - `data` must point to `data_len` readable bytes
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_register_font(
+    name: *const c_char,
+    data: *const u8,
+    data_len: size_t,
+) -> FfiResult {
+    if name.is_null() {
+        return FfiResult::error(ErrorCode::InvalidInput, "Font name is null");
+    }
+
+    if data.is_null() || data_len == 0 {
+        return FfiResult::error(ErrorCode::InvalidInput, "No font data provided");
+    }
+
+    let name = match CStr::from_ptr(name).to_str() {
+        Ok(s) => s,
+        Err(_) => return FfiResult::error(ErrorCode::InvalidInput, "Invalid font name"),
+    };
+
+    let data = slice::from_raw_parts(data, data_len).to_vec();
+
+    match register_font(name, data) {
+        Ok(_) => FfiResult::ok(),
+        Err(e) => FfiResult::from_error(&e),
+    }
+}
+
+/// List the names of fonts installed on the system
+///
+/// # Safety
+/// - `callback` must be safe to call with `user_data` during this call
+#[no_mangle]
+pub unsafe extern "C" fn minmpeg_system_fonts(
+    callback: Option<FfiNameCallback>,
+    user_data: *mut c_void,
+) -> FfiResult {
+    let Some(callback) = callback else {
+        return FfiResult::error(ErrorCode::InvalidInput, "Callback is null");
+    };
+
+    for name in system_fonts() {
+        if let Ok(name) = CString::new(name) {
+            callback(name.as_ptr(), user_data);
+        }
     }
+    FfiResult::ok()
 }
 
 /// Free a result's message string
@@ -245,6 +2032,36 @@ pub unsafe extern "C" fn minmpeg_free_result(result: *mut FfiResult) {
         let _ = CString::from_raw(result.message);
         result.message = ptr::null_mut();
     }
+    if !result.slide_path.is_null() {
+        let _ = CString::from_raw(result.slide_path);
+        result.slide_path = ptr::null_mut();
+    }
+    if !result.ffmpeg_command.is_null() {
+        let _ = CString::from_raw(result.ffmpeg_command);
+        result.ffmpeg_command = ptr::null_mut();
+    }
+    if !result.ffmpeg_stderr.is_null() {
+        let _ = CString::from_raw(result.ffmpeg_stderr);
+        result.ffmpeg_stderr = ptr::null_mut();
+    }
+}
+
+/// Set the language of error messages for all threads
+///
+/// Calls whose parameters set a language use theirs instead. Error codes do
+/// not change with the language.
+#[no_mangle]
+pub extern "C" fn minmpeg_set_language(language: Language) {
+    LANGUAGE.store(language as u8, Ordering::Relaxed);
+}
+
+/// Forbid or allow running ffmpeg for all threads
+///
+/// In native-only mode functions and codecs that need ffmpeg fail instead of
+/// spawning it.
+#[no_mangle]
+pub extern "C" fn minmpeg_set_native_only(enabled: c_int) {
+    crate::set_native_only(enabled != 0);
 }
 
 /// Get version string
@@ -253,3 +2070,16 @@ pub extern "C" fn minmpeg_version() -> *const c_char {
     static VERSION: &[u8] = concat!(env!("CARGO_PKG_VERSION"), "\0").as_bytes();
     VERSION.as_ptr() as *const c_char
 }
+
+/// ABI version of the C API, `MINMPEG_ABI_VERSION` in minmpeg.h
+///
+/// Incremented with every change that breaks programs built against an
+/// older header: a field added to a parameter struct, a changed enum value
+/// or function signature.
+pub const ABI_VERSION: u32 = 21;
+
+/// Get the ABI version the library was built with
+#[no_mangle]
+pub extern "C" fn minmpeg_abi_version() -> u32 {
+    ABI_VERSION
+}
@@ -0,0 +1,599 @@
+//! Helpers for running the ffmpeg and ffprobe executables
+//!
+//! Video decoding and all audio processing are delegated to ffmpeg, so the
+//! library itself has no dependency on ffmpeg's libraries.
+
+use crate::{Error, FfmpegInfo, Result};
+use std::collections::VecDeque;
+use std::io::{self, Read};
+use std::path::Path;
+use std::process::{Child, ChildStdin, Command, Stdio};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Mutex;
+use std::thread::{self, JoinHandle};
+
+/// Number of trailing stderr lines attached to errors
+const ERROR_TAIL_LINES: usize = 5;
+
+/// Longest stderr line kept, in bytes; the rest of a longer line is dropped
+const ERROR_LINE_LIMIT: usize = 1024;
+
+/// ffmpeg found in the common paths, kept as each search runs ffmpeg
+static FOUND_FFMPEG: Mutex<Option<String>> = Mutex::new(None);
+
+/// Encoders listed by each ffmpeg executable
+static ENCODER_LISTS: Mutex<Vec<(String, String)>> = Mutex::new(Vec::new());
+
+/// Environment variable with the path of ffmpeg used when no path is given,
+/// so deployments configure it once instead of passing it to every call
+pub const FFMPEG_ENV: &str = "MINMPEG_FFMPEG";
+
+/// Whether running ffmpeg is forbidden
+static NATIVE_ONLY: AtomicBool = AtomicBool::new(false);
+
+/// Forbid or allow running ffmpeg for all threads
+///
+/// In native-only mode no external process is ever spawned: functions and
+/// codecs that need ffmpeg fail instead, for environments where exec() is
+/// prohibited.
+pub fn set_native_only(enabled: bool) {
+    NATIVE_ONLY.store(enabled, Ordering::Relaxed);
+}
+
+/// Whether native-only mode is on
+pub fn native_only() -> bool {
+    NATIVE_ONLY.load(Ordering::Relaxed)
+}
+
+/// Find ffmpeg executable: at `custom_path`, at the path of the
+/// `MINMPEG_FFMPEG` environment variable, or in the common paths.
+/// Every spawn of ffmpeg or ffprobe goes through here, so it also enforces
+/// native-only mode.
+pub(crate) fn find_ffmpeg(custom_path: Option<&str>) -> Result<String> {
+    check_allowed(native_only())?;
+    let configured = std::env::var(FFMPEG_ENV)
+        .ok()
+        .filter(|path| !path.is_empty());
+    find_ffmpeg_in(custom_path, configured.as_deref())
+}
+
+/// Fail when running ffmpeg is forbidden
+fn check_allowed(native_only: bool) -> Result<()> {
+    if native_only {
+        return Err(Error::Ffmpeg(
+            "FFmpeg is disabled in native-only mode".to_string(),
+        ));
+    }
+    Ok(())
+}
+
+/// Find ffmpeg executable with the path configured in the environment
+fn find_ffmpeg_in(custom_path: Option<&str>, configured: Option<&str>) -> Result<String> {
+    if let Some(path) = custom_path {
+        if Path::new(path).exists() {
+            return Ok(path.to_string());
+        }
+        return Err(Error::Ffmpeg(format!("FFmpeg not found at: {}", path)));
+    }
+    if let Some(path) = configured {
+        if Path::new(path).exists() {
+            return Ok(path.to_string());
+        }
+        return Err(Error::Ffmpeg(format!(
+            "FFmpeg not found at: {} (set by {})",
+            path, FFMPEG_ENV
+        )));
+    }
+
+    let mut found = FOUND_FFMPEG
+        .lock()
+        .map_err(|_| Error::Ffmpeg("FFmpeg cache is poisoned".to_string()))?;
+    if let Some(path) = found.as_ref() {
+        return Ok(path.clone());
+    }
+
+    // Try common paths
+    let paths = [
+        "ffmpeg",
+        "/usr/bin/ffmpeg",
+        "/usr/local/bin/ffmpeg",
+        "/opt/homebrew/bin/ffmpeg",
+    ];
+
+    for path in paths {
+        if Command::new(path)
+            .arg("-version")
+            .stdout(Stdio::null())
+            .stderr(Stdio::null())
+            .status()
+            .is_ok()
+        {
+            return Ok(found.insert(path.to_string()).clone());
+        }
+    }
+
+    Err(Error::Ffmpeg("FFmpeg not found in PATH".to_string()))
+}
+
+/// Find ffmpeg, at `ffmpeg_path` or as the library does when it is not set,
+/// and report its version and encoders
+pub fn ffmpeg_info(ffmpeg_path: Option<&str>) -> Result<FfmpegInfo> {
+    let ffmpeg = find_ffmpeg(ffmpeg_path)?;
+    let output = Command::new(&ffmpeg)
+        .arg("-version")
+        .output()
+        .map_err(|e| Error::Ffmpeg(format!("Failed to run ffmpeg: {}", e)))?;
+    let version = parse_version(&String::from_utf8_lossy(&output.stdout))
+        .ok_or_else(|| Error::Ffmpeg(format!("Failed to get the version of {}", ffmpeg)))?;
+    let encoders = with_encoders(&ffmpeg, encoder_names)?;
+    Ok(FfmpegInfo {
+        path: ffmpeg,
+        version,
+        encoders,
+    })
+}
+
+/// Check if ffmpeg has the encoder `encoder`
+pub(crate) fn check_encoder(ffmpeg_path: Option<&str>, encoder: &str) -> Result<()> {
+    let ffmpeg = find_ffmpeg(ffmpeg_path)?;
+    if with_encoders(&ffmpeg, |encoders| has_encoder(encoders, encoder))? {
+        Ok(())
+    } else {
+        Err(Error::CodecUnavailable(format!(
+            "FFmpeg does not have {} support",
+            encoder
+        )))
+    }
+}
+
+/// Call `f` with the `ffmpeg -encoders` output of an ffmpeg executable,
+/// listed once per executable
+fn with_encoders<T>(ffmpeg: &str, f: impl FnOnce(&str) -> T) -> Result<T> {
+    let mut lists = ENCODER_LISTS
+        .lock()
+        .map_err(|_| Error::Ffmpeg("Encoder cache is poisoned".to_string()))?;
+    let encoders = match lists.iter().find(|(path, _)| path == ffmpeg) {
+        Some((_, encoders)) => encoders,
+        None => {
+            let output = Command::new(ffmpeg)
+                .args(["-hide_banner", "-encoders"])
+                .output()
+                .map_err(|e| Error::Ffmpeg(format!("Failed to run ffmpeg: {}", e)))?;
+            lists.push((
+                ffmpeg.to_string(),
+                String::from_utf8_lossy(&output.stdout).into_owned(),
+            ));
+            &lists[lists.len() - 1].1
+        }
+    };
+    Ok(f(encoders))
+}
+
+/// Version of ffmpeg from the first line of `ffmpeg -version`, such as
+/// "6.1.1" or "N-113000-g0123abcd" for development builds
+fn parse_version(output: &str) -> Option<String> {
+    let mut words = output.lines().next()?.split_whitespace();
+    match (words.next(), words.next(), words.next()) {
+        (Some("ffmpeg"), Some("version"), Some(version)) => Some(version.to_string()),
+        _ => None,
+    }
+}
+
+/// Names of the encoders in `ffmpeg -encoders` output, after the legend
+fn encoder_names(encoders: &str) -> Vec<String> {
+    encoders
+        .lines()
+        .skip_while(|line| !line.trim_start().starts_with("---"))
+        .skip(1)
+        .filter_map(|line| line.split_whitespace().nth(1))
+        .map(str::to_string)
+        .collect()
+}
+
+/// Check if `ffmpeg -encoders` output lists an encoder by its exact name
+fn has_encoder(encoders: &str, encoder: &str) -> bool {
+    encoders
+        .lines()
+        .any(|line| line.split_whitespace().nth(1) == Some(encoder))
+}
+
+/// Derive the ffprobe path from the ffmpeg path
+pub(crate) fn ffprobe_path(ffmpeg: &str) -> String {
+    if ffmpeg.ends_with("ffmpeg") {
+        ffmpeg.replace("ffmpeg", "ffprobe")
+    } else {
+        "ffprobe".to_string()
+    }
+}
+
+/// Run ffmpeg with the given arguments and wait for it to finish
+///
+/// On failure the command line and the last lines of ffmpeg's stderr are
+/// attached to the error.
+pub(crate) fn run(ffmpeg: &str, args: &[String]) -> Result<()> {
+    execute(ffmpeg, args, Stdio::null()).map(|_| ())
+}
+
+/// Run ffmpeg with the given arguments and return what it wrote to stdout,
+/// such as an image decoded to `-`, with errors as for `run`
+pub(crate) fn run_output(ffmpeg: &str, args: &[String]) -> Result<Vec<u8>> {
+    execute(ffmpeg, args, Stdio::piped())
+}
+
+/// Run ffmpeg, returning its stdout, which is empty unless piped
+fn execute(ffmpeg: &str, args: &[String], stdout: Stdio) -> Result<Vec<u8>> {
+    let mut command = Command::new(ffmpeg);
+    command
+        .args(["-hide_banner", "-nostdin", "-y"])
+        .args(args)
+        .stdout(stdout)
+        .stderr(Stdio::piped());
+    let output = command
+        .output()
+        .map_err(|e| Error::Ffmpeg(format!("Failed to start ffmpeg: {}", e)))?;
+
+    if output.status.success() {
+        return Ok(output.stdout);
+    }
+    Err(
+        Error::Ffmpeg(format!("ffmpeg exited with {}", output.status))
+            .in_ffmpeg(command_line(&command), stderr_tail(&output.stderr[..])),
+    )
+}
+
+/// Command line of `command` as it would be typed in a shell, with the
+/// arguments that need it quoted
+pub(crate) fn command_line(command: &Command) -> String {
+    std::iter::once(command.get_program())
+        .chain(command.get_args())
+        .map(|arg| shell_quote(&arg.to_string_lossy()))
+        .collect::<Vec<_>>()
+        .join(" ")
+}
+
+/// Quote an argument for a POSIX shell unless it only has safe characters
+fn shell_quote(arg: &str) -> String {
+    let safe = |c: char| c.is_ascii_alphanumeric() || "-_./:=,+@%".contains(c);
+    if !arg.is_empty() && arg.chars().all(safe) {
+        return arg.to_string();
+    }
+    format!("'{}'", arg.replace('\'', "'\\''"))
+}
+
+/// Last non-empty lines of a process's stderr, read to its end while only
+/// those lines are kept. Carriage returns, which ffmpeg ends its progress
+/// updates with, also end lines.
+fn stderr_tail<R: Read>(mut stderr: R) -> String {
+    let mut lines: VecDeque<Vec<u8>> = VecDeque::with_capacity(ERROR_TAIL_LINES + 1);
+    let mut line = Vec::new();
+    let mut end_line = |line: &mut Vec<u8>| {
+        if !line.trim_ascii().is_empty() {
+            lines.push_back(std::mem::take(line));
+            if lines.len() > ERROR_TAIL_LINES {
+                lines.pop_front();
+            }
+        }
+        line.clear();
+    };
+
+    let mut buf = [0u8; 8192];
+    loop {
+        let n = match stderr.read(&mut buf) {
+            Ok(0) => break,
+            Ok(n) => n,
+            Err(e) if e.kind() == io::ErrorKind::Interrupted => continue,
+            Err(_) => break,
+        };
+        for &byte in &buf[..n] {
+            match byte {
+                b'\n' | b'\r' => end_line(&mut line),
+                _ if line.len() < ERROR_LINE_LIMIT => line.push(byte),
+                _ => {}
+            }
+        }
+    }
+    end_line(&mut line);
+
+    lines
+        .iter()
+        .map(|line| String::from_utf8_lossy(line))
+        .collect::<Vec<_>>()
+        .join("\n")
+}
+
+/// Command line and stderr of a running ffmpeg process, attached to the
+/// error when it fails
+pub(crate) struct ProcessLog {
+    command: String,
+    /// Reader keeping the last lines of stderr
+    stderr: Option<JoinHandle<String>>,
+}
+
+impl ProcessLog {
+    /// Start `command` with its stderr read as ffmpeg writes it, so a full
+    /// pipe never blocks it
+    pub fn spawn(command: &mut Command) -> Result<(Child, Self)> {
+        let mut process = command
+            .stderr(Stdio::piped())
+            .spawn()
+            .map_err(|e| Error::Ffmpeg(format!("Failed to start ffmpeg: {}", e)))?;
+        let stderr = process
+            .stderr
+            .take()
+            .map(|stderr| thread::spawn(move || stderr_tail(stderr)));
+        let log = Self {
+            command: command_line(command),
+            stderr,
+        };
+        Ok((process, log))
+    }
+
+    /// Stop `process` if it is still running and attach its command line
+    /// and the last lines of its stderr to `error`
+    pub fn error(&mut self, process: &mut Child, error: Error) -> Error {
+        let _ = process.kill();
+        let _ = process.wait();
+        let stderr = self
+            .stderr
+            .take()
+            .and_then(|reader| reader.join().ok())
+            .unwrap_or_default();
+        error.in_ffmpeg(self.command.clone(), stderr)
+    }
+}
+
+/// ffmpeg process reading its input from a pipe, such as a video muxed while
+/// it is encoded, instead of an intermediate file
+pub(crate) struct PipedFfmpeg {
+    process: Child,
+    log: ProcessLog,
+}
+
+impl PipedFfmpeg {
+    /// Start ffmpeg with the given arguments, which read `pipe:0`, returning
+    /// the process and its input; the process is killed when dropped
+    pub fn spawn(ffmpeg: &str, args: &[String]) -> Result<(Self, ChildStdin)> {
+        let (mut process, log) = ProcessLog::spawn(
+            Command::new(ffmpeg)
+                .args(["-hide_banner", "-y"])
+                .args(args)
+                .stdin(Stdio::piped())
+                .stdout(Stdio::null()),
+        )?;
+
+        let stdin = process
+            .stdin
+            .take()
+            .ok_or_else(|| Error::Ffmpeg("FFmpeg stdin not available".to_string()))?;
+        Ok((Self { process, log }, stdin))
+    }
+
+    /// Wait for ffmpeg to finish, after its input is closed
+    pub fn wait(&mut self) -> Result<()> {
+        let status = self
+            .process
+            .wait()
+            .map_err(|e| Error::Ffmpeg(format!("FFmpeg process error: {}", e)))?;
+        if status.success() {
+            return Ok(());
+        }
+        let error = Error::Ffmpeg(format!("ffmpeg exited with {}", status));
+        Err(self.log.error(&mut self.process, error))
+    }
+
+    /// Error of a failed write to the input: ffmpeg's own when it exited
+    /// early and closed the pipe, otherwise `error`
+    pub fn write_error(&mut self, error: Error) -> Error {
+        match &error {
+            Error::Io(e) if e.kind() == io::ErrorKind::BrokenPipe => {
+                self.wait().err().unwrap_or(error)
+            }
+            _ => error,
+        }
+    }
+}
+
+impl Drop for PipedFfmpeg {
+    fn drop(&mut self) {
+        // Kill the process if it's still running
+        let _ = self.process.kill();
+        let _ = self.process.wait();
+    }
+}
+
+/// Get the duration of a media file in seconds using ffprobe
+pub(crate) fn probe_duration<P: AsRef<Path>>(ffmpeg: &str, path: P) -> Result<f64> {
+    let output = Command::new(ffprobe_path(ffmpeg))
+        .args([
+            "-v",
+            "error",
+            "-show_entries",
+            "format=duration",
+            "-of",
+            "csv=p=0",
+        ])
+        .arg(path.as_ref())
+        .output()
+        .map_err(|e| Error::Ffmpeg(format!("Failed to run ffprobe: {}", e)))?;
+
+    let text = String::from_utf8_lossy(&output.stdout);
+    text.trim()
+        .parse::<f64>()
+        .ok()
+        .filter(|d| d.is_finite() && *d > 0.0)
+        .ok_or_else(|| {
+            Error::Decode(format!(
+                "Failed to get duration of {}",
+                path.as_ref().display()
+            ))
+        })
+}
+
+/// Get the frame size of the first video stream using ffprobe
+pub(crate) fn probe_video_size<P: AsRef<Path>>(ffmpeg: &str, path: P) -> Result<(u32, u32)> {
+    let output = Command::new(ffprobe_path(ffmpeg))
+        .args([
+            "-v",
+            "error",
+            "-select_streams",
+            "v:0",
+            "-show_entries",
+            "stream=width,height",
+            "-of",
+            "csv=p=0",
+        ])
+        .arg(path.as_ref())
+        .output()
+        .map_err(|e| Error::Ffmpeg(format!("Failed to run ffprobe: {}", e)))?;
+
+    let text = String::from_utf8_lossy(&output.stdout);
+    let mut parts = text.trim().split(',').map(|s| s.parse::<u32>().ok());
+    match (parts.next().flatten(), parts.next().flatten()) {
+        (Some(width), Some(height)) if width > 0 && height > 0 => Ok((width, height)),
+        _ => Err(Error::Decode(format!(
+            "Failed to get video size of {}",
+            path.as_ref().display()
+        ))),
+    }
+}
+
+/// Get the frame rate of the first video stream using ffprobe
+pub(crate) fn probe_frame_rate<P: AsRef<Path>>(ffmpeg: &str, path: P) -> Result<f64> {
+    let output = Command::new(ffprobe_path(ffmpeg))
+        .args([
+            "-v",
+            "error",
+            "-select_streams",
+            "v:0",
+            "-show_entries",
+            "stream=r_frame_rate",
+            "-of",
+            "csv=p=0",
+        ])
+        .arg(path.as_ref())
+        .output()
+        .map_err(|e| Error::Ffmpeg(format!("Failed to run ffprobe: {}", e)))?;
+
+    parse_rate(String::from_utf8_lossy(&output.stdout).trim()).ok_or_else(|| {
+        Error::Decode(format!(
+            "Failed to get frame rate of {}",
+            path.as_ref().display()
+        ))
+    })
+}
+
+/// Parse a frame rate such as "30/1", "30000/1001" or "25"
+fn parse_rate(text: &str) -> Option<f64> {
+    let rate = match text.split_once('/') {
+        Some((num, den)) => num.parse::<f64>().ok()? / den.parse::<f64>().ok()?,
+        None => text.parse().ok()?,
+    };
+    (rate.is_finite() && rate > 0.0).then_some(rate)
+}
+
+/// Path as a string argument for ffmpeg
+pub(crate) fn path_arg<P: AsRef<Path>>(path: P) -> String {
+    path.as_ref().to_string_lossy().to_string()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_has_encoder() {
+        let encoders = " V....D libvpx               libvpx VP8 (codec vp8)\n \
+                        V....D libvpx-vp9           libvpx VP9 (codec vp9)\n";
+        assert!(has_encoder(encoders, "libvpx"));
+        assert!(has_encoder(encoders, "libvpx-vp9"));
+        assert!(!has_encoder(" V....D libvpx-vp9  libvpx VP9\n", "libvpx"));
+    }
+
+    #[test]
+    fn test_configured_path() {
+        let exe = std::env::current_exe().unwrap();
+        let exe = exe.to_str().unwrap();
+        assert_eq!(find_ffmpeg_in(None, Some(exe)).unwrap(), exe);
+        // A path given to the call takes precedence
+        assert_eq!(
+            find_ffmpeg_in(Some(exe), Some("/missing/ffmpeg")).unwrap(),
+            exe
+        );
+        match find_ffmpeg_in(None, Some("/missing/ffmpeg")) {
+            Err(Error::Ffmpeg(message)) => assert!(message.contains(FFMPEG_ENV)),
+            other => panic!("Expected an ffmpeg error, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_native_only() {
+        assert!(check_allowed(false).is_ok());
+        match check_allowed(true) {
+            Err(Error::Ffmpeg(message)) => assert!(message.contains("native-only")),
+            other => panic!("Expected an ffmpeg error, got {:?}", other),
+        }
+    }
+
+    #[test]
+    fn test_command_line() {
+        let mut command = Command::new("/usr/bin/ffmpeg");
+        command.args([
+            "-i",
+            "my slides/a.png",
+            "-vf",
+            "[0:v]scale=2:2",
+            "-metadata",
+            "it's",
+            "",
+        ]);
+        assert_eq!(
+            command_line(&command),
+            "/usr/bin/ffmpeg -i 'my slides/a.png' -vf '[0:v]scale=2:2' -metadata 'it'\\''s' ''"
+        );
+    }
+
+    #[test]
+    fn test_stderr_tail() {
+        let stderr = b"line 1\nline 2\n\nline 3\nline 4\nline 5\nline 6\n";
+        assert_eq!(
+            stderr_tail(&stderr[..]),
+            "line 2\nline 3\nline 4\nline 5\nline 6"
+        );
+        assert_eq!(stderr_tail(&b""[..]), "");
+
+        // Progress updates end with carriage returns, and long lines are cut
+        let mut stderr = b"frame=1\rframe=2\r".to_vec();
+        stderr.extend(vec![b'x'; ERROR_LINE_LIMIT * 2]);
+        let tail = stderr_tail(&stderr[..]);
+        assert_eq!(tail.lines().count(), 3);
+        assert!(tail.starts_with("frame=1\nframe=2\n"));
+        assert_eq!(tail.lines().last().unwrap().len(), ERROR_LINE_LIMIT);
+    }
+
+    #[test]
+    fn test_parse_version() {
+        assert_eq!(
+            parse_version("ffmpeg version 6.1.1-3ubuntu5 Copyright (c) 2000-2023\nbuilt with gcc"),
+            Some("6.1.1-3ubuntu5".to_string())
+        );
+        assert_eq!(parse_version("ffprobe version 6.1.1"), None);
+        assert_eq!(parse_version(""), None);
+    }
+
+    #[test]
+    fn test_encoder_names() {
+        let encoders = "Encoders:\n V..... = Video\n A..... = Audio\n ------\n \
+                        V....D libvpx-vp9           libvpx VP9 (codec vp9)\n \
+                        A....D aac                  AAC (Advanced Audio Coding)\n";
+        assert_eq!(encoder_names(encoders), ["libvpx-vp9", "aac"]);
+    }
+
+    #[test]
+    fn test_parse_rate() {
+        assert_eq!(parse_rate("30/1"), Some(30.0));
+        assert!((parse_rate("30000/1001").unwrap() - 29.97).abs() < 0.01);
+        assert_eq!(parse_rate("25"), Some(25.0));
+        assert_eq!(parse_rate("0/0"), None);
+        assert_eq!(parse_rate(""), None);
+    }
+}
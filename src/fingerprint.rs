@@ -0,0 +1,252 @@
+//! Perceptual hashes of sampled frames
+//!
+//! Each sampled frame is reduced to a 32x32 luma grid, transformed with a
+//! DCT, and the signs of its 8x8 lowest frequencies against their median
+//! give a 64-bit hash. Re-encodes, scaling and compression noise change few
+//! bits, while different content changes about half of them, so the Hamming
+//! distance between hashes tells duplicated content from distinct content.
+
+use crate::ffmpeg::{find_ffmpeg, path_arg, probe_duration, ProcessLog};
+use crate::image_loader::LoadedImage;
+use crate::{Error, FrameHash, Result};
+use image::ImageFormat;
+use std::f64::consts::PI;
+use std::io::Read;
+use std::path::Path;
+use std::process::{Command, Stdio};
+
+/// Side of the luma grid that is transformed
+const GRID_SIZE: usize = 32;
+
+/// Side of the block of low frequencies giving the hash bits
+const HASH_SIZE: usize = 8;
+
+/// Largest Hamming distance between hashes of frames with the same content
+pub const FRAME_HASH_MATCH_DISTANCE: u32 = 10;
+
+impl FrameHash {
+    /// Number of differing bits between two hashes (0-64)
+    pub fn distance(&self, other: &FrameHash) -> u32 {
+        (self.hash ^ other.hash).count_ones()
+    }
+
+    /// Check if two frames show the same content
+    pub fn matches(&self, other: &FrameHash) -> bool {
+        self.distance(other) <= FRAME_HASH_MATCH_DISTANCE
+    }
+}
+
+/// Compute perceptual hashes of `count` frames sampled evenly over an image
+/// or video
+///
+/// Images give a single hash. Videos are decoded with ffmpeg (found in PATH
+/// unless `ffmpeg_path` is set); shorter videos may give fewer hashes.
+pub fn frame_hashes<P: AsRef<Path>>(
+    path: P,
+    count: u32,
+    ffmpeg_path: Option<&str>,
+) -> Result<Vec<FrameHash>> {
+    let path = path.as_ref();
+    if !path.is_file() {
+        return Err(Error::InvalidInput(format!(
+            "Input not found: {}",
+            path.display()
+        )));
+    }
+    if count == 0 {
+        return Err(Error::InvalidInput(
+            "Frame count must be at least 1".to_string(),
+        ));
+    }
+
+    if ImageFormat::from_path(path).is_ok() {
+        let image = LoadedImage::from_path(path)?;
+        return Ok(vec![FrameHash {
+            time_ms: 0,
+            hash: phash(&image_grid(&image)),
+        }]);
+    }
+    video_hashes(path, count, ffmpeg_path)
+}
+
+/// Decode sampled frames of a video as luma grids and hash them
+fn video_hashes(path: &Path, count: u32, ffmpeg_path: Option<&str>) -> Result<Vec<FrameHash>> {
+    let ffmpeg = find_ffmpeg(ffmpeg_path)?;
+    let duration = probe_duration(&ffmpeg, path)?;
+    let rate = count as f64 / duration;
+
+    let (mut process, mut log) = ProcessLog::spawn(
+        Command::new(&ffmpeg)
+            .args(["-v", "error", "-nostdin", "-i"])
+            .arg(path_arg(path))
+            .args(["-map", "0:v:0", "-vf"])
+            .arg(format!(
+                "fps={:.6},scale={}:{}:flags=area",
+                rate, GRID_SIZE, GRID_SIZE
+            ))
+            .args(["-f", "rawvideo", "-pix_fmt", "gray", "pipe:1"])
+            .stdout(Stdio::piped()),
+    )?;
+
+    let mut stdout = process
+        .stdout
+        .take()
+        .ok_or_else(|| Error::Ffmpeg("Failed to read ffmpeg output".to_string()))?;
+
+    let mut grid = [0u8; GRID_SIZE * GRID_SIZE];
+    let mut hashes = Vec::new();
+    while hashes.len() < count as usize {
+        match stdout.read_exact(&mut grid) {
+            Ok(()) => hashes.push(FrameHash {
+                time_ms: (hashes.len() as f64 * 1000.0 / rate) as u64,
+                hash: phash(&grid),
+            }),
+            Err(e) if e.kind() == std::io::ErrorKind::UnexpectedEof => break,
+            Err(e) => return Err(Error::Decode(format!("Failed to read frame: {}", e))),
+        }
+    }
+    // The remaining frames are not needed
+    drop(stdout);
+    let _ = process.kill();
+    let status = process.wait()?;
+
+    if hashes.is_empty() {
+        let error = Error::Decode(format!(
+            "No video frames decoded from {} ({})",
+            path.display(),
+            status
+        ));
+        return Err(log.error(&mut process, error));
+    }
+    Ok(hashes)
+}
+
+/// Mean BT.709 luma of each cell of a grid laid over the image
+fn image_grid(image: &LoadedImage) -> [u8; GRID_SIZE * GRID_SIZE] {
+    let size = GRID_SIZE as u64;
+    let mut sums = [0u64; GRID_SIZE * GRID_SIZE];
+    let mut counts = [0u64; GRID_SIZE * GRID_SIZE];
+    for y in 0..image.height as u64 {
+        let cy = (y * size / image.height.max(1) as u64) as usize;
+        for x in 0..image.width as u64 {
+            let cx = (x * size / image.width.max(1) as u64) as usize;
+            let i = ((y * image.width as u64 + x) * 4) as usize;
+            let p = &image.data[i..i + 4];
+            let luma = 2126 * p[0] as u64 + 7152 * p[1] as u64 + 722 * p[2] as u64;
+            sums[cy * GRID_SIZE + cx] += luma;
+            counts[cy * GRID_SIZE + cx] += 10_000;
+        }
+    }
+
+    let mut grid = [0u8; GRID_SIZE * GRID_SIZE];
+    for (cell, (sum, count)) in grid.iter_mut().zip(sums.iter().zip(&counts)) {
+        *cell = (sum / (*count).max(1)) as u8;
+    }
+    grid
+}
+
+/// Perceptual hash of a luma grid
+fn phash(grid: &[u8; GRID_SIZE * GRID_SIZE]) -> u64 {
+    // DCT-II basis of the low frequencies
+    let basis: Vec<[f64; GRID_SIZE]> = (0..HASH_SIZE)
+        .map(|u| {
+            let mut row = [0.0; GRID_SIZE];
+            for (x, value) in row.iter_mut().enumerate() {
+                *value = ((2 * x + 1) as f64 * u as f64 * PI / (2 * GRID_SIZE) as f64).cos();
+            }
+            row
+        })
+        .collect();
+
+    // Transform the rows, then the columns of the low frequencies
+    let mut rows = [[0.0; HASH_SIZE]; GRID_SIZE];
+    for (y, row) in rows.iter_mut().enumerate() {
+        for (u, value) in row.iter_mut().enumerate() {
+            *value = (0..GRID_SIZE)
+                .map(|x| grid[y * GRID_SIZE + x] as f64 * basis[u][x])
+                .sum();
+        }
+    }
+    let mut coefficients = [0.0; HASH_SIZE * HASH_SIZE];
+    for v in 0..HASH_SIZE {
+        for u in 0..HASH_SIZE {
+            coefficients[v * HASH_SIZE + u] =
+                (0..GRID_SIZE).map(|y| rows[y][u] * basis[v][y]).sum();
+        }
+    }
+
+    // The mean brightness (DC) would dominate the median
+    let mut sorted = coefficients[1..].to_vec();
+    sorted.sort_by(|a, b| a.total_cmp(b));
+    let median = sorted[sorted.len() / 2];
+
+    coefficients
+        .iter()
+        .enumerate()
+        .filter(|(_, &c)| c > median)
+        .fold(0u64, |hash, (i, _)| hash | 1 << i)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Grid with a bright rectangle on a dark background
+    fn rectangle(x0: usize, x1: usize, level: u8) -> [u8; GRID_SIZE * GRID_SIZE] {
+        let mut grid = [20u8; GRID_SIZE * GRID_SIZE];
+        for y in 8..24 {
+            for x in x0..x1 {
+                grid[y * GRID_SIZE + x] = level;
+            }
+        }
+        grid
+    }
+
+    #[test]
+    fn test_phash() {
+        let a = FrameHash {
+            time_ms: 0,
+            hash: phash(&rectangle(4, 14, 230)),
+        };
+
+        // A slightly darker copy matches
+        let b = FrameHash {
+            time_ms: 0,
+            hash: phash(&rectangle(4, 14, 210)),
+        };
+        assert!(a.matches(&b), "distance {}", a.distance(&b));
+
+        // Content on the other side does not
+        let c = FrameHash {
+            time_ms: 0,
+            hash: phash(&rectangle(18, 28, 230)),
+        };
+        assert!(!a.matches(&c), "distance {}", a.distance(&c));
+        assert_eq!(a.distance(&a), 0);
+    }
+
+    #[test]
+    fn test_image_grid() {
+        // 64x64 image, left half black and right half white
+        let image = LoadedImage {
+            width: 64,
+            height: 64,
+            data: (0..64 * 64)
+                .flat_map(|i| {
+                    let v = if i % 64 < 32 { 0 } else { 255 };
+                    [v, v, v, 255]
+                })
+                .collect(),
+        };
+        let grid = image_grid(&image);
+        assert_eq!(grid[0], 0);
+        assert_eq!(grid[GRID_SIZE - 1], 255);
+    }
+
+    #[test]
+    fn test_invalid_input() {
+        assert!(frame_hashes("/nonexistent.mp4", 4, None).is_err());
+        let file = tempfile::NamedTempFile::new().unwrap();
+        assert!(frame_hashes(file.path(), 0, None).is_err());
+    }
+}
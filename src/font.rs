@@ -0,0 +1,376 @@
+//! Font management for text rendering
+//!
+//! Fonts are looked up by name in the following order:
+//! 1. Fonts registered at runtime with [`register_font`]
+//! 2. Fonts installed on the system (matched by file name)
+//! 3. The bundled default font (DejaVu Sans)
+//!
+//! The bundled font guarantees that captions render the same way on hosts
+//! without any installed fonts, such as minimal Linux containers.
+//!
+//! Characters missing from the selected font (e.g. Japanese, Chinese,
+//! Arabic or Hebrew with the bundled font) are drawn with the first
+//! [`fallback_fonts`] entry that covers them.
+//!
+//! Emoji are drawn with a color emoji font when one is available: a font
+//! registered as [`EMOJI_FONT_NAME`], or else an installed system emoji font.
+
+use crate::{Error, Result};
+use ab_glyph::FontArc;
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+use std::sync::{Mutex, OnceLock};
+
+/// Name of the bundled default font
+pub const DEFAULT_FONT_NAME: &str = "DejaVu Sans";
+
+/// Bundled default font data
+static DEFAULT_FONT_DATA: &[u8] = include_bytes!("../assets/fonts/DejaVuSans.ttf");
+
+/// Registered name of a font that overrides the system emoji font
+pub const EMOJI_FONT_NAME: &str = "Emoji";
+
+/// Maximum directory depth searched for system fonts
+const MAX_SEARCH_DEPTH: usize = 4;
+
+/// System font files tried, in order, for characters the selected font lacks
+const FALLBACK_FONT_NAMES: &[&str] = &[
+    // Japanese / Chinese / Korean
+    "NotoSansCJK-Regular",
+    "NotoSansCJKjp-Regular",
+    "NotoSansJP-Regular",
+    "Hiragino Sans GB",
+    "YuGothR",
+    "meiryo",
+    "msgothic",
+    "msyh",
+    "ipagp",
+    "TakaoPGothic",
+    "DroidSansFallbackFull",
+    // Arabic
+    "NotoSansArabic-Regular",
+    "NotoNaskhArabic-Regular",
+    "GeezaPro",
+    // Hebrew
+    "NotoSansHebrew-Regular",
+    "ArialHB",
+    // Broad coverage (Windows)
+    "arial",
+    "segoeui",
+];
+
+/// System color emoji font files, in order of preference
+const EMOJI_FONT_NAMES: &[&str] = &[
+    "NotoColorEmoji",
+    "Apple Color Emoji",
+    "seguiemj",
+    "TwemojiMozilla",
+    "JoyPixels",
+];
+
+/// Registered fonts keyed by normalized name
+fn registry() -> &'static Mutex<HashMap<String, FontArc>> {
+    static REGISTRY: OnceLock<Mutex<HashMap<String, FontArc>>> = OnceLock::new();
+    REGISTRY.get_or_init(|| Mutex::new(HashMap::new()))
+}
+
+/// Register a font under the given name
+///
+/// Registering a font with an existing name replaces the previous font.
+pub fn register_font(name: &str, data: Vec<u8>) -> Result<()> {
+    let (key, font) = parse_font(name, data)?;
+    registry()
+        .lock()
+        .map_err(|_| Error::Platform("Font registry is poisoned".to_string()))?
+        .insert(key, font);
+
+    Ok(())
+}
+
+/// Registry key and font for font data registered under a name
+fn parse_font(name: &str, data: Vec<u8>) -> Result<(String, FontArc)> {
+    let key = normalize_name(name);
+    if key.is_empty() {
+        return Err(Error::InvalidInput("Font name is empty".to_string()));
+    }
+
+    let font = FontArc::try_from_vec(data)
+        .map_err(|e| Error::InvalidInput(format!("Invalid font data for {}: {}", name, e)))?;
+    Ok((key, font))
+}
+
+/// Get the bundled default font
+pub fn default_font() -> FontArc {
+    static DEFAULT_FONT: OnceLock<FontArc> = OnceLock::new();
+    DEFAULT_FONT
+        .get_or_init(|| FontArc::try_from_slice(DEFAULT_FONT_DATA).expect("Invalid bundled font"))
+        .clone()
+}
+
+/// Resolve a font by name, falling back to the bundled font when no name is given
+pub fn resolve_font(name: Option<&str>) -> Result<FontArc> {
+    let name = match name {
+        Some(n) if !n.trim().is_empty() => n,
+        _ => return Ok(default_font()),
+    };
+
+    let key = normalize_name(name);
+
+    if let Some(font) = registry()
+        .lock()
+        .map_err(|_| Error::Platform("Font registry is poisoned".to_string()))?
+        .get(&key)
+    {
+        return Ok(font.clone());
+    }
+
+    if key == normalize_name(DEFAULT_FONT_NAME) {
+        return Ok(default_font());
+    }
+
+    let path = find_system_font(name)
+        .ok_or_else(|| Error::InvalidInput(format!("Font not found: {}", name)))?;
+    let data = std::fs::read(&path)?;
+    let font = FontArc::try_from_vec(data)
+        .map_err(|e| Error::InvalidInput(format!("Invalid font file {}: {}", path.display(), e)))?;
+
+    // Cache the system font so later lookups skip the directory scan
+    if let Ok(mut fonts) = registry().lock() {
+        fonts.insert(key, font.clone());
+    }
+
+    Ok(font)
+}
+
+/// Fonts used for characters missing from the selected font
+///
+/// Contains the installed fonts from [`FALLBACK_FONT_NAMES`] followed by the
+/// bundled default font. The system is scanned once and the result cached.
+pub fn fallback_fonts() -> Vec<FontArc> {
+    static FALLBACKS: OnceLock<Vec<FontArc>> = OnceLock::new();
+    FALLBACKS
+        .get_or_init(|| {
+            let mut files = Vec::new();
+            for dir in system_font_dirs() {
+                collect_font_files(&dir, 0, &mut files);
+            }
+
+            let mut fonts = load_named_fonts(&files, FALLBACK_FONT_NAMES);
+            fonts.push(default_font());
+            fonts
+        })
+        .clone()
+}
+
+/// Font used for emoji, if any
+///
+/// A font registered as [`EMOJI_FONT_NAME`] takes precedence over the
+/// installed fonts from [`EMOJI_FONT_NAMES`]. Color bitmap glyphs
+/// (`CBDT` and `sbix` tables) are drawn in color; other emoji fonts are
+/// drawn as outlines in the text color.
+pub fn emoji_font() -> Option<FontArc> {
+    if let Some(font) = registry()
+        .lock()
+        .ok()
+        .and_then(|fonts| registered_emoji_font(&fonts))
+    {
+        return Some(font);
+    }
+
+    static SYSTEM_EMOJI: OnceLock<Option<FontArc>> = OnceLock::new();
+    SYSTEM_EMOJI
+        .get_or_init(|| {
+            let mut files = Vec::new();
+            for dir in system_font_dirs() {
+                collect_font_files(&dir, 0, &mut files);
+            }
+            load_named_fonts(&files, EMOJI_FONT_NAMES)
+                .into_iter()
+                .next()
+        })
+        .clone()
+}
+
+/// Font registered as [`EMOJI_FONT_NAME`] among `fonts`
+fn registered_emoji_font(fonts: &HashMap<String, FontArc>) -> Option<FontArc> {
+    fonts.get(&normalize_name(EMOJI_FONT_NAME)).cloned()
+}
+
+/// Load the fonts whose file names match the given names, in name order
+fn load_named_fonts(files: &[PathBuf], names: &[&str]) -> Vec<FontArc> {
+    names
+        .iter()
+        .filter_map(|name| {
+            let key = normalize_name(name);
+            files.iter().find(|p| {
+                p.file_stem()
+                    .map(|s| normalize_name(&s.to_string_lossy()) == key)
+                    .unwrap_or(false)
+            })
+        })
+        .filter_map(|path| std::fs::read(path).ok())
+        .filter_map(|data| FontArc::try_from_vec(data).ok())
+        .collect()
+}
+
+/// List the names of fonts installed on the system
+///
+/// Names are the font file names without their extension, such as
+/// `DejaVuSans` for `DejaVuSans.ttf`, as [`find_system_font`] matches them;
+/// family names stored inside the fonts are not read.
+pub fn system_fonts() -> Vec<String> {
+    let mut files = Vec::new();
+    for dir in system_font_dirs() {
+        collect_font_files(&dir, 0, &mut files);
+    }
+
+    let mut names: Vec<String> = files
+        .iter()
+        .filter_map(|p| p.file_stem())
+        .map(|s| s.to_string_lossy().to_string())
+        .collect();
+    names.sort();
+    names.dedup();
+    names
+}
+
+/// Find a system font file whose name matches the given font name
+///
+/// Names are compared ignoring case, spaces, hyphens and underscores,
+/// so "DejaVu Sans" matches `DejaVuSans.ttf`.
+pub fn find_system_font(name: &str) -> Option<PathBuf> {
+    let key = normalize_name(name);
+    let mut files = Vec::new();
+
+    for dir in system_font_dirs() {
+        collect_font_files(&dir, 0, &mut files);
+        if let Some(path) = files.iter().find(|p| {
+            p.file_stem()
+                .map(|s| normalize_name(&s.to_string_lossy()) == key)
+                .unwrap_or(false)
+        }) {
+            return Some(path.clone());
+        }
+        files.clear();
+    }
+
+    None
+}
+
+/// Directories where the current platform installs fonts
+fn system_font_dirs() -> Vec<PathBuf> {
+    let mut dirs = Vec::new();
+
+    #[cfg(target_os = "macos")]
+    {
+        dirs.push(PathBuf::from("/System/Library/Fonts"));
+        dirs.push(PathBuf::from("/Library/Fonts"));
+        if let Some(home) = std::env::var_os("HOME") {
+            dirs.push(PathBuf::from(home).join("Library/Fonts"));
+        }
+    }
+
+    #[cfg(target_os = "windows")]
+    {
+        let windir = std::env::var_os("WINDIR").unwrap_or_else(|| "C:\\Windows".into());
+        dirs.push(PathBuf::from(windir).join("Fonts"));
+        if let Some(local) = std::env::var_os("LOCALAPPDATA") {
+            dirs.push(PathBuf::from(local).join("Microsoft\\Windows\\Fonts"));
+        }
+    }
+
+    #[cfg(not(any(target_os = "macos", target_os = "windows")))]
+    {
+        dirs.push(PathBuf::from("/usr/share/fonts"));
+        dirs.push(PathBuf::from("/usr/local/share/fonts"));
+        if let Some(home) = std::env::var_os("HOME").map(PathBuf::from) {
+            dirs.push(home.join(".local/share/fonts"));
+            dirs.push(home.join(".fonts"));
+        }
+    }
+
+    dirs
+}
+
+/// Recursively collect font files under a directory
+fn collect_font_files(dir: &Path, depth: usize, out: &mut Vec<PathBuf>) {
+    if depth > MAX_SEARCH_DEPTH {
+        return;
+    }
+
+    let entries = match std::fs::read_dir(dir) {
+        Ok(e) => e,
+        Err(_) => return,
+    };
+
+    for entry in entries.flatten() {
+        let path = entry.path();
+        if path.is_dir() {
+            collect_font_files(&path, depth + 1, out);
+        } else if is_font_file(&path) {
+            out.push(path);
+        }
+    }
+}
+
+fn is_font_file(path: &Path) -> bool {
+    path.extension()
+        .map(|e| {
+            let e = e.to_string_lossy().to_ascii_lowercase();
+            e == "ttf" || e == "otf" || e == "ttc"
+        })
+        .unwrap_or(false)
+}
+
+/// Normalize a font name for lookup
+fn normalize_name(name: &str) -> String {
+    name.chars()
+        .filter(|c| !matches!(c, ' ' | '-' | '_'))
+        .flat_map(char::to_lowercase)
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_normalize_name() {
+        assert_eq!(normalize_name("DejaVu Sans"), "dejavusans");
+        assert_eq!(normalize_name("Noto_Sans-CJK JP"), "notosanscjkjp");
+    }
+
+    #[test]
+    fn test_resolve_default_font() {
+        assert!(resolve_font(None).is_ok());
+        assert!(resolve_font(Some("")).is_ok());
+        assert!(resolve_font(Some(DEFAULT_FONT_NAME)).is_ok());
+    }
+
+    #[test]
+    fn test_register_font() {
+        assert!(register_font("bundled-copy", DEFAULT_FONT_DATA.to_vec()).is_ok());
+        assert!(resolve_font(Some("Bundled Copy")).is_ok());
+    }
+
+    #[test]
+    fn test_register_invalid_font() {
+        assert!(register_font("broken", vec![0, 1, 2, 3]).is_err());
+        assert!(register_font("", DEFAULT_FONT_DATA.to_vec()).is_err());
+    }
+
+    #[test]
+    fn test_registered_emoji_font() {
+        // A registry of its own, so other tests keep the system emoji font
+        let mut fonts = HashMap::new();
+        assert!(registered_emoji_font(&fonts).is_none());
+        let (key, font) = parse_font(EMOJI_FONT_NAME, DEFAULT_FONT_DATA.to_vec()).unwrap();
+        fonts.insert(key, font);
+        assert!(registered_emoji_font(&fonts).is_some());
+    }
+
+    #[test]
+    fn test_resolve_missing_font() {
+        assert!(resolve_font(Some("No Such Font 12345")).is_err());
+    }
+}
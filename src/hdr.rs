@@ -0,0 +1,309 @@
+//! HDR10 output
+//!
+//! HDR10 frames are drawn as PQ-coded BT.2020 RGBA. Floating-point images
+//! (Radiance HDR, OpenEXR) keep their highlights up to the mastering display
+//! peak, while SDR images, overlays and videos are placed at the reference
+//! white of BT.2408 (203 cd/m²), so they look as they do in SDR output.
+//! Encoders tag the bitstream and muxers the container with the color
+//! description, the mastering display (SMPTE ST 2086) and the content light
+//! levels; without them players show PQ video as washed-out SDR.
+
+use crate::image_loader::LoadedImage;
+use crate::tonemap::{has_filter, is_hdr};
+use crate::{Error, Result};
+use image::DynamicImage;
+use std::ops::RangeInclusive;
+use std::path::Path;
+
+/// Peak luminance of the mastering display when none is set, in cd/m²
+pub const DEFAULT_MAX_LUMINANCE: u32 = 1000;
+
+/// Valid peak luminance of the mastering display in cd/m²
+pub const MAX_LUMINANCE_RANGE: RangeInclusive<u32> = 400..=10000;
+
+/// Black level of the mastering display in cd/m²
+pub const MIN_LUMINANCE: f64 = 0.005;
+
+/// BT.2020 primaries (red, green, blue) as CIE 1931 xy coordinates
+pub const PRIMARIES: [(f64, f64); 3] = [(0.708, 0.292), (0.170, 0.797), (0.131, 0.046)];
+
+/// D65 white point as CIE 1931 xy coordinates
+pub const WHITE_POINT: (f64, f64) = (0.3127, 0.3290);
+
+/// Luminance of SDR white in HDR output in cd/m² (BT.2408)
+const REFERENCE_WHITE: f32 = 203.0;
+
+/// Luminance of the largest PQ value in cd/m²
+const PQ_PEAK: f32 = 10000.0;
+
+/// Constants of the PQ transfer function (SMPTE ST 2084)
+const PQ_M1: f32 = 0.159_301_76;
+const PQ_M2: f32 = 78.843_75;
+const PQ_C1: f32 = 0.835_937_5;
+const PQ_C2: f32 = 18.851_563;
+const PQ_C3: f32 = 18.6875;
+
+/// Conversion of linear RGB from BT.709 to BT.2020 primaries (BT.2087)
+const BT709_TO_BT2020: [[f32; 3]; 3] = [
+    [0.6274, 0.3293, 0.0433],
+    [0.0691, 0.9195, 0.0114],
+    [0.0164, 0.0880, 0.8956],
+];
+
+/// HDR10 metadata of an output
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct Hdr10 {
+    /// Peak luminance of the mastering display in cd/m²
+    pub max_luminance: u32,
+    /// Maximum content light level (MaxCLL) in cd/m² (0 if unknown)
+    pub max_cll: u16,
+    /// Maximum frame-average light level (MaxFALL) in cd/m² (0 if unknown)
+    pub max_fall: u16,
+}
+
+impl Hdr10 {
+    /// libx265 parameters writing the mastering display and content light
+    /// level SEI messages
+    pub fn x265_params(&self) -> String {
+        let point =
+            |(x, y): (f64, f64)| format!("({},{})", (x * 50000.0).round(), (y * 50000.0).round());
+        let [red, green, blue] = PRIMARIES;
+        format!(
+            "hdr10=1:repeat-headers=1:master-display=G{}B{}R{}WP{}L({},{}):max-cll={},{}",
+            point(green),
+            point(blue),
+            point(red),
+            point(WHITE_POINT),
+            self.max_luminance as u64 * 10000,
+            (MIN_LUMINANCE * 10000.0).round(),
+            self.max_cll,
+            self.max_fall
+        )
+    }
+}
+
+/// PQ signal (0.0-1.0) of a luminance in cd/m²
+fn pq_encode(nits: f32) -> f32 {
+    let y = (nits / PQ_PEAK).clamp(0.0, 1.0).powf(PQ_M1);
+    ((PQ_C1 + PQ_C2 * y) / (1.0 + PQ_C3 * y)).powf(PQ_M2)
+}
+
+/// Luminance in cd/m² of a PQ signal (0.0-1.0)
+fn pq_decode(signal: f32) -> f32 {
+    let p = signal.clamp(0.0, 1.0).powf(1.0 / PQ_M2);
+    PQ_PEAK * ((p - PQ_C1).max(0.0) / (PQ_C2 - PQ_C3 * p)).powf(1.0 / PQ_M1)
+}
+
+/// Inverse of the sRGB transfer function
+fn srgb_decode(v: f32) -> f32 {
+    if v <= 0.040_45 {
+        v / 12.92
+    } else {
+        ((v + 0.055) / 1.055).powf(2.4)
+    }
+}
+
+/// Convert linear BT.709 light (1.0 for SDR white) to 8-bit PQ-coded
+/// BT.2020, clipping at `max_nits`
+fn linear_to_pq(rgb: [f32; 3], max_nits: f32) -> [u8; 3] {
+    BT709_TO_BT2020.map(|row| {
+        let v = row[0] * rgb[0] + row[1] * rgb[1] + row[2] * rgb[2];
+        let nits = (v.max(0.0) * REFERENCE_WHITE).min(max_nits);
+        (pq_encode(nits) * 255.0).round() as u8
+    })
+}
+
+/// Convert an 8-bit sRGB color to PQ-coded BT.2020
+pub(crate) fn sdr_color_to_pq(rgb: [u8; 3]) -> [u8; 3] {
+    linear_to_pq(rgb.map(|v| srgb_decode(v as f32 / 255.0)), f32::INFINITY)
+}
+
+/// Convert 8-bit sRGB RGBA pixels to PQ-coded BT.2020 in place, keeping
+/// the alpha channel
+pub(crate) fn sdr_to_pq(data: &mut [u8]) {
+    let linear: [f32; 256] = std::array::from_fn(|i| srgb_decode(i as f32 / 255.0));
+    for pixel in data.chunks_exact_mut(4) {
+        let rgb = [pixel[0], pixel[1], pixel[2]].map(|v| linear[v as usize]);
+        pixel[..3].copy_from_slice(&linear_to_pq(rgb, f32::INFINITY));
+    }
+}
+
+/// Convert linear RGBA float pixels (1.0 for SDR white) to PQ-coded BT.2020,
+/// clipping highlights above the mastering display peak `max_nits`
+pub(crate) fn linear_to_pq_pixels(pixels: &[f32], max_nits: u32) -> Vec<u8> {
+    pixels
+        .chunks_exact(4)
+        .flat_map(|p| {
+            let [r, g, b] = linear_to_pq([p[0], p[1], p[2]], max_nits as f32);
+            [r, g, b, (p[3].clamp(0.0, 1.0) * 255.0).round() as u8]
+        })
+        .collect()
+}
+
+/// Convert a floating-point image (Radiance HDR, OpenEXR), which holds
+/// linear light, to PQ-coded BT.2020; None for other images
+pub(crate) fn linear_image(img: &DynamicImage, max_nits: u32) -> Option<LoadedImage> {
+    match img {
+        DynamicImage::ImageRgb32F(_) | DynamicImage::ImageRgba32F(_) => Some(LoadedImage {
+            width: img.width(),
+            height: img.height(),
+            data: linear_to_pq_pixels(&img.to_rgba32f().into_raw(), max_nits),
+        }),
+        _ => None,
+    }
+}
+
+/// Draw SDR graphics, such as captions, over a PQ-coded image
+///
+/// `draw` draws on a transparent layer, which is converted to PQ and
+/// composited over the image, so the graphics keep their SDR colors.
+pub(crate) fn draw_sdr(image: &mut LoadedImage, draw: impl FnOnce(&mut LoadedImage)) {
+    let mut layer = LoadedImage {
+        width: image.width,
+        height: image.height,
+        data: vec![0; image.data.len()],
+    };
+    draw(&mut layer);
+
+    // Drawing on transparent black leaves the colors multiplied by alpha
+    for (dst, src) in image
+        .data
+        .chunks_exact_mut(4)
+        .zip(layer.data.chunks_exact(4))
+    {
+        if src[3] == 0 {
+            continue;
+        }
+        let alpha = src[3] as f32 / 255.0;
+        let color =
+            sdr_color_to_pq([0, 1, 2].map(|c| (src[c] as f32 / alpha).round().min(255.0) as u8));
+        for (d, &s) in dst.iter_mut().zip(&color) {
+            *d = (*d as f32 * (1.0 - alpha) + s as f32 * alpha).round() as u8;
+        }
+        let dst_alpha = dst[3] as f32 / 255.0;
+        dst[3] = ((alpha + dst_alpha * (1.0 - alpha)) * 255.0).round() as u8;
+    }
+}
+
+/// Content light levels (MaxCLL, MaxFALL) of PQ-coded RGBA frames in cd/m²
+///
+/// A pixel's light level is that of its brightest channel.
+pub(crate) fn content_light<'a>(frames: impl IntoIterator<Item = &'a [u8]>) -> (u16, u16) {
+    let nits: [f32; 256] = std::array::from_fn(|i| pq_decode(i as f32 / 255.0));
+    let (mut max_cll, mut max_fall) = (0.0f32, 0.0f64);
+    for data in frames {
+        let mut sum = 0.0f64;
+        let mut count = 0u64;
+        for p in data.chunks_exact(4) {
+            let level = nits[p[0].max(p[1]).max(p[2]) as usize];
+            max_cll = max_cll.max(level);
+            sum += level as f64;
+            count += 1;
+        }
+        if count > 0 {
+            max_fall = max_fall.max(sum / count as f64);
+        }
+    }
+    (
+        max_cll.round().min(u16::MAX as f32) as u16,
+        max_fall.round().min(u16::MAX as f64) as u16,
+    )
+}
+
+/// ffmpeg filter chain converting a video to PQ-coded BT.2020
+///
+/// HDR videos keep their light levels; SDR videos are placed at the
+/// reference white and assumed to be BT.709 when untagged.
+pub(crate) fn video_filter<P: AsRef<Path>>(ffmpeg: &str, path: P) -> Result<String> {
+    if !has_filter(ffmpeg, "zscale") {
+        return Err(Error::CodecUnavailable(
+            "HDR10 output of videos needs ffmpeg with the zscale filter".to_string(),
+        ));
+    }
+    let input = if is_hdr(ffmpeg, path) {
+        ""
+    } else {
+        "pin=bt709:tin=bt709:min=bt709:"
+    };
+    Ok(format!(
+        "zscale={input}t=linear:npl={white},format=gbrpf32le,\
+         zscale=p=bt2020:t=smpte2084:npl={white},format=gbrpf32le",
+        input = input,
+        white = REFERENCE_WHITE
+    ))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_pq_round_trip() {
+        for nits in [0.0, 0.1, 100.0, 203.0, 1000.0, 10000.0] {
+            let decoded = pq_decode(pq_encode(nits));
+            assert!((decoded - nits).abs() <= nits * 1e-3 + 1e-3, "{}", nits);
+        }
+        // Reference white sits at 58% of the signal range
+        assert!((pq_encode(REFERENCE_WHITE) - 0.58).abs() < 0.01);
+    }
+
+    #[test]
+    fn test_sdr_to_pq() {
+        let mut data = vec![255, 255, 255, 128, 0, 0, 0, 255];
+        sdr_to_pq(&mut data);
+        let white = (pq_encode(REFERENCE_WHITE) * 255.0).round() as u8;
+        assert_eq!(data, vec![white, white, white, 128, 0, 0, 0, 255]);
+
+        // Pure BT.709 red mixes in green and blue in BT.2020
+        let [r, g, b] = sdr_color_to_pq([255, 0, 0]);
+        assert!(r > g && g > 0 && b > 0);
+    }
+
+    #[test]
+    fn test_linear_to_pq_pixels() {
+        // Highlights above SDR white are kept up to the peak
+        let data = linear_to_pq_pixels(&[1.0, 1.0, 1.0, 1.0, 4.0, 4.0, 4.0, 1.0], 1000);
+        assert!(data[4] > data[0]);
+        let clipped = linear_to_pq_pixels(&[100.0, 100.0, 100.0, 1.0], 1000);
+        assert_eq!(clipped[0], (pq_encode(1000.0) * 255.0).round() as u8);
+    }
+
+    #[test]
+    fn test_draw_sdr() {
+        let mut image = LoadedImage {
+            width: 2,
+            height: 1,
+            data: vec![0, 0, 0, 255, 0, 0, 0, 255],
+        };
+        draw_sdr(&mut image, |layer| {
+            layer.data[..4].copy_from_slice(&[255, 255, 255, 255]);
+        });
+        let white = sdr_color_to_pq([255, 255, 255]);
+        assert_eq!(&image.data[..3], &white);
+        assert_eq!(&image.data[4..], &[0, 0, 0, 255]);
+    }
+
+    #[test]
+    fn test_content_light() {
+        let white = sdr_color_to_pq([255, 255, 255]);
+        let frame = [white[0], white[1], white[2], 255, 0, 0, 0, 255];
+        let (max_cll, max_fall) = content_light([&frame[..]]);
+        assert!((200..=206).contains(&max_cll), "{}", max_cll);
+        assert!((100..=103).contains(&max_fall), "{}", max_fall);
+        assert_eq!(content_light(std::iter::empty()), (0, 0));
+    }
+
+    #[test]
+    fn test_x265_params() {
+        let hdr = Hdr10 {
+            max_luminance: 1000,
+            max_cll: 800,
+            max_fall: 200,
+        };
+        assert_eq!(
+            hdr.x265_params(),
+            "hdr10=1:repeat-headers=1:master-display=G(8500,39850)B(6550,2300)R(35400,14600)\
+             WP(15635,16450)L(10000000,50):max-cll=800,200"
+        );
+    }
+}
@@ -1,9 +1,27 @@
 //! Image loading utilities
 
-use crate::{Error, Result};
-use image::{DynamicImage, GenericImageView, ImageReader};
+use crate::ffmpeg;
+use crate::tonemap::tone_map_pixels;
+use crate::{Error, Result, ToneMap};
+use image::codecs::webp::WebPDecoder;
+use image::{
+    AnimationDecoder, DynamicImage, Frame, GenericImageView, ImageDecoder, ImageFormat,
+    ImageReader, Limits,
+};
+use std::fs::File;
+use std::io::{BufRead, BufReader, Cursor, Read, Seek};
 use std::path::Path;
 
+/// Display time of animation frames without a delay, in milliseconds
+const DEFAULT_FRAME_DELAY_MS: u64 = 100;
+
+/// Major brands of HEIF images: HEVC-coded images and sequences, and
+/// generic image files
+const HEIF_BRANDS: [&[u8; 4]; 6] = [b"heic", b"heix", b"heim", b"heis", b"hevc", b"mif1"];
+
+/// Major brands of AVIF images and image sequences
+const AVIF_BRANDS: [&[u8; 4]; 2] = [b"avif", b"avis"];
+
 /// Loaded image in RGBA format
 #[derive(Debug, Clone)]
 pub struct LoadedImage {
@@ -17,19 +35,57 @@ pub struct LoadedImage {
 
 impl LoadedImage {
     /// Load an image from a file path
+    ///
+    /// HDR images are tone mapped with the default operator.
     pub fn from_path<P: AsRef<Path>>(path: P) -> Result<Self> {
-        let path = path.as_ref();
-
-        let img = ImageReader::open(path).map_err(Error::Io)?.decode()?;
+        Self::from_path_tone_mapped(path, ToneMap::default())
+    }
 
-        Ok(Self::from_dynamic_image(img))
+    /// Load an image from a file path, tone mapping HDR images with the given operator
+    pub fn from_path_tone_mapped<P: AsRef<Path>>(path: P, tone_map: ToneMap) -> Result<Self> {
+        Ok(Self::from_dynamic_image_tone_mapped(
+            decode(path)?,
+            tone_map,
+        ))
     }
 
     /// Create from a DynamicImage
     pub fn from_dynamic_image(img: DynamicImage) -> Self {
+        Self::from_dynamic_image_tone_mapped(img, ToneMap::default())
+    }
+
+    /// Create from a DynamicImage, tone mapping HDR images with the given operator
+    ///
+    /// Floating-point images (Radiance HDR, OpenEXR) hold linear light and
+    /// are tone mapped to sRGB; other images are converted directly.
+    pub fn from_dynamic_image_tone_mapped(img: DynamicImage, tone_map: ToneMap) -> Self {
         let (width, height) = img.dimensions();
-        let rgba = img.to_rgba8();
-        let data = rgba.into_raw();
+        let data = match img {
+            DynamicImage::ImageRgb32F(_) | DynamicImage::ImageRgba32F(_) => {
+                tone_map_pixels(&img.to_rgba32f().into_raw(), tone_map)
+            }
+            _ => img.to_rgba8().into_raw(),
+        };
+
+        Self {
+            width,
+            height,
+            data,
+        }
+    }
+
+    /// Copy a rectangle of the image (clamped to the image bounds)
+    pub fn crop(&self, x: u32, y: u32, width: u32, height: u32) -> Self {
+        let x = x.min(self.width);
+        let y = y.min(self.height);
+        let width = width.min(self.width - x);
+        let height = height.min(self.height - y);
+
+        let mut data = Vec::with_capacity((width * height * 4) as usize);
+        for row in y..y + height {
+            let start = ((row * self.width + x) * 4) as usize;
+            data.extend_from_slice(&self.data[start..start + (width * 4) as usize]);
+        }
 
         Self {
             width,
@@ -116,6 +172,227 @@ impl LoadedImage {
     }
 }
 
+/// Decode an image file, turned upright by its EXIF orientation
+///
+/// HEIF images are decoded by ffmpeg, found as for `find_ffmpeg`.
+pub fn decode<P: AsRef<Path>>(path: P) -> Result<DynamicImage> {
+    decode_with(path, None, None)
+}
+
+/// Decode an image file, turned upright by its EXIF orientation, failing
+/// instead of allocating more than `max_alloc` bytes for it
+pub fn decode_within<P: AsRef<Path>>(path: P, max_alloc: u64) -> Result<DynamicImage> {
+    decode_with(path, None, Some(max_alloc))
+}
+
+/// Decode an image file, with the ffmpeg at `ffmpeg_path` (None to find it)
+/// for HEIF, AVIF and SVG images and within `max_alloc` bytes if given
+///
+/// SVG images are rasterized at the size they declare.
+pub(crate) fn decode_with<P: AsRef<Path>>(
+    path: P,
+    ffmpeg_path: Option<&str>,
+    max_alloc: Option<u64>,
+) -> Result<DynamicImage> {
+    let mut limits = Limits::default();
+    if max_alloc.is_some() {
+        limits.max_alloc = max_alloc;
+    }
+
+    if is_heif(path.as_ref()) || is_avif(path.as_ref()) {
+        return decode_png_from(ffmpeg_path, &still_args(path.as_ref()), limits);
+    }
+    if is_svg(path.as_ref()) {
+        return decode_png_from(ffmpeg_path, &svg_args(path.as_ref(), None), limits);
+    }
+
+    let mut reader = ImageReader::open(path.as_ref()).map_err(Error::Io)?;
+    reader.limits(limits);
+    upright(reader)
+}
+
+/// Rasterize an SVG image at `width` x `height` pixels with the ffmpeg at
+/// `ffmpeg_path` (None to find it)
+pub(crate) fn rasterize_svg<P: AsRef<Path>>(
+    path: P,
+    ffmpeg_path: Option<&str>,
+    width: u32,
+    height: u32,
+) -> Result<DynamicImage> {
+    let args = svg_args(path.as_ref(), Some((width, height)));
+    decode_png_from(ffmpeg_path, &args, Limits::default())
+}
+
+/// Decode the PNG image ffmpeg writes to stdout when run with `args`
+fn decode_png_from(
+    ffmpeg_path: Option<&str>,
+    args: &[String],
+    limits: Limits,
+) -> Result<DynamicImage> {
+    let ffmpeg = ffmpeg::find_ffmpeg(ffmpeg_path)?;
+    let png = ffmpeg::run_output(&ffmpeg, args)?;
+    let mut reader = ImageReader::new(Cursor::new(png));
+    reader.set_format(ImageFormat::Png);
+    reader.limits(limits);
+    Ok(reader.decode()?)
+}
+
+/// Decode the frames of an animated WebP image with their display times in
+/// milliseconds, or None for other images, including still WebP images
+///
+/// With `max_alloc`, the frames together must fit within that many bytes.
+pub fn decode_animation<P: AsRef<Path>>(
+    path: P,
+    max_alloc: Option<u64>,
+) -> Result<Option<Vec<(DynamicImage, u64)>>> {
+    let reader = ImageReader::open(path.as_ref())
+        .map_err(Error::Io)?
+        .with_guessed_format()
+        .map_err(Error::Io)?;
+    if reader.format() != Some(ImageFormat::WebP) {
+        return Ok(None);
+    }
+    let mut decoder = WebPDecoder::new(BufReader::new(File::open(path.as_ref())?))?;
+    if !decoder.has_animation() {
+        return Ok(None);
+    }
+    let mut limits = Limits::default();
+    if max_alloc.is_some() {
+        limits.max_alloc = max_alloc;
+    }
+    decoder.set_limits(limits.clone())?;
+
+    // Frames are whole canvases, with earlier frames blended in; all of them
+    // are kept, so each one counts against the limit
+    let mut budget = match max_alloc {
+        Some(_) => limits,
+        None => Limits::no_limits(),
+    };
+    let mut frames = Vec::new();
+    for frame in decoder.into_frames() {
+        let frame = frame?;
+        budget.reserve(frame.buffer().as_raw().len() as u64)?;
+        let delay_ms = frame_delay_ms(&frame);
+        frames.push((DynamicImage::ImageRgba8(frame.into_buffer()), delay_ms));
+    }
+    Ok(Some(frames))
+}
+
+/// Display time of an animation frame in milliseconds, with frames that
+/// have no delay shown for `DEFAULT_FRAME_DELAY_MS`, as browsers do
+pub(crate) fn frame_delay_ms(frame: &Frame) -> u64 {
+    let (numer, denom) = frame.delay().numer_denom_ms();
+    match numer as u64 / denom.max(1) as u64 {
+        0 => DEFAULT_FRAME_DELAY_MS,
+        ms => ms,
+    }
+}
+
+/// Check whether a file is a HEIF image, such as a HEIC photo from an
+/// iPhone, by the brand of its file type box
+pub fn is_heif<P: AsRef<Path>>(path: P) -> bool {
+    major_brand(path).is_some_and(|brand| HEIF_BRANDS.contains(&&brand))
+}
+
+/// Check whether a file is an AVIF image by the brand of its file type box
+///
+/// The AV1 encoder linked into the library cannot decode, so AVIF images are
+/// decoded by ffmpeg, which needs an AV1 decoder such as libdav1d.
+pub fn is_avif<P: AsRef<Path>>(path: P) -> bool {
+    major_brand(path).is_some_and(|brand| AVIF_BRANDS.contains(&&brand))
+}
+
+/// Check whether a file is an SVG image by its root element
+///
+/// SVG images are rasterized by ffmpeg, which needs to be built with librsvg.
+pub fn is_svg<P: AsRef<Path>>(path: P) -> bool {
+    let mut head = Vec::new();
+    let read = File::open(path).and_then(|file| file.take(4096).read_to_end(&mut head));
+    if read.is_err() {
+        return false;
+    }
+    // The root element may follow an XML declaration, comments and a
+    // doctype, all of which start with "<"
+    let head = String::from_utf8_lossy(&head);
+    let head = head.trim_start_matches('\u{feff}').trim_start();
+    head.starts_with('<') && head.contains("<svg")
+}
+
+/// Major brand of the file type box at the start of an ISO media file
+fn major_brand<P: AsRef<Path>>(path: P) -> Option<[u8; 4]> {
+    let mut header = [0; 12];
+    File::open(path)
+        .and_then(|mut file| file.read_exact(&mut header))
+        .ok()?;
+    (&header[4..8] == b"ftyp").then(|| [header[8], header[9], header[10], header[11]])
+}
+
+/// Arguments of ffmpeg decoding the primary image of a HEIF or AVIF file to
+/// PNG on stdout; ffmpeg joins the tiles of the image and applies its
+/// rotation and mirroring
+fn still_args(path: &Path) -> Vec<String> {
+    [
+        "-i",
+        &ffmpeg::path_arg(path),
+        "-frames:v",
+        "1",
+        "-f",
+        "image2pipe",
+        "-c:v",
+        "png",
+        "-",
+    ]
+    .iter()
+    .map(|arg| arg.to_string())
+    .collect()
+}
+
+/// Arguments of ffmpeg rasterizing an SVG image to PNG on stdout, at the
+/// given width and height or else at the size the image declares
+fn svg_args(path: &Path, size: Option<(u32, u32)>) -> Vec<String> {
+    let mut args: Vec<String> = ["-f", "svg_pipe", "-c:v", "librsvg"]
+        .iter()
+        .map(|arg| arg.to_string())
+        .collect();
+    if let Some((width, height)) = size {
+        args.extend([
+            "-width".to_string(),
+            width.to_string(),
+            "-height".to_string(),
+            height.to_string(),
+        ]);
+    }
+    args.extend(
+        [
+            "-i",
+            &ffmpeg::path_arg(path),
+            "-frames:v",
+            "1",
+            "-f",
+            "image2pipe",
+            "-c:v",
+            "png",
+            "-",
+        ]
+        .iter()
+        .map(|arg| arg.to_string()),
+    );
+    args
+}
+
+/// Decode an image, rotating and flipping it as its EXIF orientation says
+///
+/// Cameras and phones store photos as the sensor saw them and record how to
+/// turn them in the orientation tag, so portrait photos would come out
+/// sideways without it. Images without the tag are left as they are.
+fn upright<R: BufRead + Seek>(reader: ImageReader<R>) -> Result<DynamicImage> {
+    let mut decoder = reader.into_decoder()?;
+    let orientation = decoder.orientation()?;
+    let mut img = DynamicImage::from_decoder(decoder)?;
+    img.apply_orientation(orientation);
+    Ok(img)
+}
+
 /// Load multiple images and normalize them to the same size
 pub fn load_and_normalize_images<P: AsRef<Path>>(paths: &[P]) -> Result<Vec<LoadedImage>> {
     if paths.is_empty() {
@@ -164,4 +441,27 @@ mod tests {
         assert_eq!(resized.height, 4);
         assert_eq!(resized.data.len(), 4 * 4 * 4);
     }
+
+    #[test]
+    fn test_crop() {
+        let img = LoadedImage {
+            width: 2,
+            height: 2,
+            data: vec![
+                255, 0, 0, 255, // Red
+                0, 255, 0, 255, // Green
+                0, 0, 255, 255, // Blue
+                255, 255, 0, 255, // Yellow
+            ],
+        };
+
+        let cropped = img.crop(1, 0, 1, 2);
+        assert_eq!((cropped.width, cropped.height), (1, 2));
+        assert_eq!(cropped.data, vec![0, 255, 0, 255, 255, 255, 0, 255]);
+
+        // Rectangles are clamped to the image
+        let cropped = img.crop(1, 1, 5, 5);
+        assert_eq!((cropped.width, cropped.height), (1, 1));
+        assert_eq!(cropped.data, vec![255, 255, 0, 255]);
+    }
 }
@@ -1,14 +1,24 @@
 //! Side-by-side video juxtaposition
 
-use crate::encoder::{create_encoder, EncoderConfig, Frame};
+use crate::colorspace::ColorSpace;
+use crate::encoder::{with_idle_frames, with_software_fallback, EncoderConfig, Frame};
+use crate::ffmpeg::{ffprobe_path, find_ffmpeg};
+use crate::image_loader::LoadedImage;
+use crate::logo::LogoOverlay;
 use crate::muxer::{create_muxer, MuxerConfig};
-use crate::{Color, EncodeOptions, Error, Result};
+use crate::subtitles::{self, Cue};
+use crate::text::{self, CaptionLayout};
+use crate::verify::{Expected, OutputChecker};
+use crate::{budget, disk, font, hdr, priority, session, tonemap, warning};
+use crate::{
+    Color, Compression, DecodeMode, DynamicRange, EncodeOptions, Error, JuxtaposeLayout, LogLevel,
+    Result, ToneMap, WarningKind,
+};
+use ab_glyph::FontArc;
 use std::io::Read;
 use std::path::Path;
 use std::process::{Command, Stdio};
-
-/// Default frame rate for output video
-const DEFAULT_FPS: u32 = 30;
+use std::time::Instant;
 
 /// Video frame from decoded video
 struct DecodedFrame {
@@ -47,19 +57,39 @@ impl VideoDecoder {
         })
     }
 
-    fn start_decode<P: AsRef<Path>>(&mut self, path: P, ffmpeg_path: Option<&str>) -> Result<()> {
+    fn start_decode<P: AsRef<Path>>(
+        &mut self,
+        path: P,
+        ffmpeg_path: Option<&str>,
+        mode: DecodeMode,
+        tone_map: ToneMap,
+        dynamic_range: DynamicRange,
+        output_fps: u32,
+    ) -> Result<()> {
         let ffmpeg = find_ffmpeg(ffmpeg_path)?;
 
+        // HDR videos are tone mapped to SDR before the RGBA conversion, or
+        // every video is converted to PQ-coded BT.2020 for HDR10 output
+        let filter = match dynamic_range {
+            DynamicRange::Sdr => tonemap::video_filter(&ffmpeg, &path, tone_map),
+            DynamicRange::Hdr10 => Some(hdr::video_filter(&ffmpeg, &path)?),
+        };
+        let filter_args = match filter {
+            Some(filter) => vec!["-vf".to_string(), filter],
+            None => Vec::new(),
+        };
+
         let process = Command::new(&ffmpeg)
+            .args(hwaccel_args(mode))
+            .args(["-i", path.as_ref().to_str().unwrap()])
+            .args(filter_args)
             .args([
-                "-i",
-                path.as_ref().to_str().unwrap(),
                 "-f",
                 "rawvideo",
                 "-pix_fmt",
                 "rgba",
                 "-r",
-                &DEFAULT_FPS.to_string(),
+                &output_fps.to_string(),
                 "pipe:1",
             ])
             .stdout(Stdio::piped())
@@ -111,8 +141,9 @@ impl VideoDecoder {
         }
     }
 
-    fn duration_frames(&self) -> u64 {
-        ((self.frame_count as f64 * DEFAULT_FPS as f64) / self.fps).ceil() as u64
+    /// Length of the video in frames at `output_fps`
+    fn duration_frames(&self, output_fps: u32) -> u64 {
+        ((self.frame_count as f64 * output_fps as f64) / self.fps).ceil() as u64
     }
 }
 
@@ -133,49 +164,212 @@ impl Drop for VideoDecoder {
 /// - Duration = max(left video duration, right video duration)
 ///
 /// If heights differ, videos are aligned to the top with the background color filling the bottom.
+/// With `options.juxtapose_layout`, the videos are stacked instead, the left
+/// one on top and both aligned to the left, or arranged by their aspect ratios.
 /// If durations differ, the shorter video continues showing its last frame.
+/// Subtitle files set in the options are drawn on their side like captions,
+/// timed by that video and only while it plays.
+/// A logo, if set, is drawn over the combined frames. HDR videos are tone
+/// mapped to SDR with `options.tone_map`, unless the output is HDR10, which
+/// keeps their light levels and places SDR videos and overlays at the HDR
+/// reference white. With a time budget, a faster encoder preset is chosen
+/// as needed to finish in time. A failed hardware encode is run again in
+/// software if the options fall back.
 pub fn juxtapose<P: AsRef<Path>>(
     left_path: P,
     right_path: P,
     options: &EncodeOptions,
     background: Option<Color>,
 ) -> Result<()> {
+    let (left_path, right_path) = (left_path.as_ref(), right_path.as_ref());
+    priority::run(options.priority, || {
+        with_software_fallback(options, |options| {
+            combine(left_path, right_path, options, background)
+        })
+    })
+}
+
+/// Combine two videos once with the encoder of the options
+fn combine(
+    left_path: &Path,
+    right_path: &Path,
+    options: &EncodeOptions,
+    background: Option<Color>,
+) -> Result<()> {
+    let started = Instant::now();
+
     // Validate options
     options.validate()?;
+    let fps = options.frame_rate();
+    let checker = OutputChecker::new(options)?;
+
+    // Read the subtitles and resolve their font before decoding starts, so
+    // an invalid file fails fast
+    let load = |path: &Option<String>| {
+        path.as_deref()
+            .map(|path| subtitles::load_subtitles(Path::new(path)))
+            .transpose()
+    };
+    let (left_cues, right_cues) = (
+        load(&options.left_subtitles)?,
+        load(&options.right_subtitles)?,
+    );
+    let subtitle_font = if left_cues.is_some() || right_cues.is_some() {
+        Some(font::resolve_font(options.font.as_deref())?)
+    } else {
+        None
+    };
+    let caption_layout = CaptionLayout::from_options(options);
 
+    // The light levels of videos are not known before decoding, so HDR10
+    // output leaves them out of its metadata
+    let hdr = options.hdr10(0, 0);
     let bg = background.unwrap_or_default();
+    let bg = match hdr {
+        Some(_) => {
+            let [r, g, b] = hdr::sdr_color_to_pq([bg.r, bg.g, bg.b]);
+            Color { r, g, b }
+        }
+        None => bg,
+    };
     let ffmpeg_path = options.ffmpeg_path.as_deref();
 
     // Open both video decoders
-    let mut left_decoder = VideoDecoder::new(&left_path, ffmpeg_path)?;
-    let mut right_decoder = VideoDecoder::new(&right_path, ffmpeg_path)?;
-
-    // Calculate output dimensions
-    let output_width = left_decoder.width + right_decoder.width;
-    let output_height = left_decoder.height.max(right_decoder.height);
+    let mut left_decoder = VideoDecoder::new(left_path, ffmpeg_path)?;
+    let mut right_decoder = VideoDecoder::new(right_path, ffmpeg_path)?;
+
+    // Calculate output dimensions and where the right video goes
+    let left_size = (left_decoder.width, left_decoder.height);
+    let right_size = (right_decoder.width, right_decoder.height);
+    let (output_width, output_height, right_offset) =
+        if stacks_vertically(options.juxtapose_layout, left_size, right_size) {
+            (
+                left_size.0.max(right_size.0),
+                left_size.1 + right_size.1,
+                (0, left_size.1),
+            )
+        } else {
+            (
+                left_size.0 + right_size.0,
+                left_size.1.max(right_size.1),
+                (left_size.0, 0),
+            )
+        };
 
     // Ensure dimensions are even
+    if output_width % 2 != 0 || output_height % 2 != 0 {
+        options.warn(
+            WarningKind::SizeRounded,
+            warning::size_rounded(output_width, output_height),
+        );
+    }
     let output_width = (output_width / 2) * 2;
     let output_height = (output_height / 2) * 2;
 
     // Calculate total frames (longer video duration)
-    let total_frames = left_decoder
-        .duration_frames()
-        .max(right_decoder.duration_frames());
+    let left_frames = left_decoder.duration_frames(fps);
+    let right_frames = right_decoder.duration_frames(fps);
+    let total_frames = left_frames.max(right_frames);
+
+    let logo = match &options.logo {
+        Some(logo) => {
+            let mut logo = LogoOverlay::new(
+                logo,
+                output_width,
+                output_height,
+                options.safe_area,
+                total_frames * 1000 / fps as u64,
+            )?;
+            if hdr.is_some() {
+                logo.convert_to_pq();
+            }
+            Some(logo)
+        }
+        None => None,
+    };
 
-    // Start decoding
-    left_decoder.start_decode(&left_path, ffmpeg_path)?;
-    right_decoder.start_decode(&right_path, ffmpeg_path)?;
+    // Fail early when the output volume is too full
+    let estimate = disk::estimate_output(
+        options.codec,
+        options.quality,
+        options.compression == Compression::Lossless,
+        output_width as u64 * output_height as u64,
+        total_frames,
+    );
+    disk::check_space(
+        Path::new(&options.output_path),
+        disk::peak_space(options, estimate),
+    )?;
+
+    options.log(
+        LogLevel::Info,
+        "stage started",
+        vec![
+            ("stage", "encode".into()),
+            ("width", output_width.into()),
+            ("height", output_height.into()),
+            ("frames", total_frames.into()),
+        ],
+    );
 
-    // Create encoder
+    // Start decoding
+    left_decoder.start_decode(
+        left_path,
+        ffmpeg_path,
+        options.decode_mode,
+        options.tone_map,
+        options.dynamic_range,
+        fps,
+    )?;
+    right_decoder.start_decode(
+        right_path,
+        ffmpeg_path,
+        options.decode_mode,
+        options.tone_map,
+        options.dynamic_range,
+        fps,
+    )?;
+
+    // Create encoder, faster if the time budget needs it
+    let plan = budget::plan(
+        options.codec,
+        output_width as u64 * output_height as u64,
+        total_frames,
+        budget::remaining(options.time_budget_ms, started.elapsed()),
+    );
     let encoder_config = EncoderConfig {
         width: output_width,
         height: output_height,
-        fps: DEFAULT_FPS,
+        fps,
         quality: options.quality,
+        ffmpeg_path: options.ffmpeg_path.clone(),
+        speed: plan.speed,
+        lossless: options.compression == Compression::Lossless,
+        alpha: false,
+        two_pass: None,
+        bitrate: options.video_bitrate(total_frames * 1000 / fps as u64, false)?,
+        keyframe_interval: options.keyframe_interval(fps),
+        forced_keyframes: options.forced_keyframes(fps),
+        pixel_format: options.pixel_format,
+        bit_depth: options.bit_depth,
+        hdr,
+        color: ColorSpace::new(options),
+        hw_accel: options.encoder_hw_accel(),
+        threads: options.encoder_threads(),
+        av1_backend: options.av1_backend,
+        av1_preset: options.av1_preset,
+        h264_profile: options.h264_profile,
+        h264_level: options.h264_level,
+        h264_preset: options.h264_preset,
+        h264_tune: options.h264_tune,
+        ffmpeg_args: options.ffmpeg_args.clone(),
     };
 
-    let mut encoder = create_encoder(options.codec, encoder_config.clone())?;
+    let mut encoder = with_idle_frames(
+        session::encoder(options, encoder_config.clone())?,
+        options.idle_frames,
+        fps,
+    );
 
     // Collect all packets first (to get SPS/PPS for H.264 muxer)
     let mut all_packets: Vec<crate::encoder::Packet> = Vec::new();
@@ -183,23 +377,43 @@ pub fn juxtapose<P: AsRef<Path>>(
     // Process frames
     for frame_idx in 0..total_frames {
         // Read frames from both videos
-        let left_frame = left_decoder.read_frame()?;
-        let right_frame = right_decoder.read_frame()?;
+        let mut left_frame = left_decoder.read_frame()?;
+        let mut right_frame = right_decoder.read_frame()?;
+
+        // Each side shows its subtitles while it plays
+        if let Some(font) = &subtitle_font {
+            let time_ms = frame_idx * 1000 / fps as u64;
+            let sides = [
+                (&mut left_frame, &left_cues, left_frames),
+                (&mut right_frame, &right_cues, right_frames),
+            ];
+            for (frame, cues, frames) in sides {
+                if let (Some(frame), Some(cues)) = (frame.as_mut(), cues) {
+                    if frame_idx < frames {
+                        draw_subtitles(frame, font, cues, time_ms, &caption_layout, hdr.is_some());
+                    }
+                }
+            }
+        }
 
         // Combine frames
-        let combined = combine_frames(
+        let mut combined = combine_frames(
             left_frame.as_ref(),
             right_frame.as_ref(),
             output_width,
             output_height,
+            right_offset,
             &bg,
         );
+        if let Some(logo) = &logo {
+            logo.draw(&mut combined, output_width, frame_idx * 1000 / fps as u64);
+        }
 
         let frame = Frame {
             width: output_width,
             height: output_height,
             data: combined,
-            pts_ms: frame_idx * 1000 / DEFAULT_FPS as u64,
+            pts_ms: frame_idx * 1000 / fps as u64,
         };
 
         let packets = encoder.encode(&frame)?;
@@ -214,12 +428,25 @@ pub fn juxtapose<P: AsRef<Path>>(
     let muxer_config = MuxerConfig {
         width: output_width,
         height: output_height,
-        fps: DEFAULT_FPS,
+        fps,
         codec: options.codec,
         codec_config: encoder.codec_config(),
         pps: encoder.pps(),
+        alpha: false,
+        hls_segment: options.hls_segment,
+        segment_ms: options.segment_duration_ms,
+        mp4_layout: options.mp4_layout,
+        output_mode: options.output_mode,
+        hdr,
+        color: ColorSpace::new(options),
+        chapters: options.chapters.clone(),
     };
 
+    options.log(
+        LogLevel::Info,
+        "stage started",
+        vec![("stage", "mux".into())],
+    );
     let mut muxer = create_muxer(options.container, &options.output_path, muxer_config)?;
 
     // Write all packets
@@ -230,104 +457,121 @@ pub fn juxtapose<P: AsRef<Path>>(
     // Finalize output
     muxer.finalize()?;
 
+    let duration_ms = total_frames * 1000 / fps as u64;
+    checker.check(&Expected {
+        width: output_width,
+        height: output_height,
+        fps,
+        duration_ms,
+    })?;
+    options.log(
+        LogLevel::Info,
+        "job finished",
+        vec![
+            ("elapsed_ms", (started.elapsed().as_millis() as u64).into()),
+            ("duration_ms", duration_ms.into()),
+        ],
+    );
     Ok(())
 }
 
-/// Combine two frames side by side
+/// ffmpeg input arguments for the decode mode
+///
+/// `-hwaccel auto` picks an available hardware decoder and falls back to
+/// software decoding when none can be initialized. Decoded frames are
+/// downloaded to system memory for the RGBA conversion.
+fn hwaccel_args(mode: DecodeMode) -> &'static [&'static str] {
+    match mode {
+        DecodeMode::Auto => &["-hwaccel", "auto"],
+        DecodeMode::Software => &[],
+    }
+}
+
+/// Draw the subtitles shown at `time_ms` on a frame of one side, which is
+/// PQ-coded with `pq`
+fn draw_subtitles(
+    frame: &mut DecodedFrame,
+    font: &FontArc,
+    cues: &[Cue],
+    time_ms: u64,
+    layout: &CaptionLayout,
+    pq: bool,
+) {
+    let Some(text) = subtitles::text_at(cues, time_ms) else {
+        return;
+    };
+    let mut image = LoadedImage {
+        width: frame.width,
+        height: frame.height,
+        data: std::mem::take(&mut frame.data),
+    };
+    let draw = |image: &mut LoadedImage| text::draw_caption(image, font, &text, layout);
+    if pq {
+        hdr::draw_sdr(&mut image, draw);
+    } else {
+        draw(&mut image);
+    }
+    frame.data = image.data;
+}
+
+/// Whether the layout stacks the videos of the given sizes (width, height)
+/// instead of placing them side by side
+///
+/// The automatic layout picks the arrangement whose output is closer to
+/// square: two portrait videos go side by side, two landscape ones are
+/// stacked, so the comparison is shown larger on a screen of either
+/// orientation.
+fn stacks_vertically(layout: JuxtaposeLayout, left: (u32, u32), right: (u32, u32)) -> bool {
+    match layout {
+        JuxtaposeLayout::Horizontal => false,
+        JuxtaposeLayout::Vertical => true,
+        JuxtaposeLayout::Auto => {
+            // Ratio of the longer side to the shorter one, 1 for a square
+            let elongation = |w: u32, h: u32| w.max(h) as f64 / w.min(h).max(1) as f64;
+            elongation(left.0.max(right.0), left.1 + right.1)
+                < elongation(left.0 + right.0, left.1.max(right.1))
+        }
+    }
+}
+
+/// Combine two frames, the left one at the top left corner and the right
+/// one at `right_offset` (x, y)
 fn combine_frames(
     left: Option<&DecodedFrame>,
     right: Option<&DecodedFrame>,
     output_width: u32,
     output_height: u32,
+    right_offset: (u32, u32),
     bg: &Color,
 ) -> Vec<u8> {
     let mut output = vec![0u8; (output_width * output_height * 4) as usize];
 
     // Fill with background color
-    for i in 0..(output_width * output_height) as usize {
-        output[i * 4] = bg.r;
-        output[i * 4 + 1] = bg.g;
-        output[i * 4 + 2] = bg.b;
-        output[i * 4 + 3] = 255;
-    }
-
-    // Copy left frame (top-aligned)
-    if let Some(left) = left {
-        for y in 0..left.height.min(output_height) {
-            for x in 0..left.width {
-                let src_idx = ((y * left.width + x) * 4) as usize;
-                let dst_idx = ((y * output_width + x) * 4) as usize;
-
-                output[dst_idx] = left.data[src_idx];
-                output[dst_idx + 1] = left.data[src_idx + 1];
-                output[dst_idx + 2] = left.data[src_idx + 2];
-                output[dst_idx + 3] = left.data[src_idx + 3];
-            }
-        }
+    for pixel in output.chunks_exact_mut(4) {
+        pixel.copy_from_slice(&[bg.r, bg.g, bg.b, 255]);
     }
 
-    // Copy right frame (top-aligned, offset by left width)
-    if let Some(right) = right {
-        let left_width = left.map(|l| l.width).unwrap_or(0);
-
-        for y in 0..right.height.min(output_height) {
-            for x in 0..right.width {
-                let src_idx = ((y * right.width + x) * 4) as usize;
-                let dst_idx = ((y * output_width + left_width + x) * 4) as usize;
-
-                if dst_idx + 3 < output.len() {
-                    output[dst_idx] = right.data[src_idx];
-                    output[dst_idx + 1] = right.data[src_idx + 1];
-                    output[dst_idx + 2] = right.data[src_idx + 2];
-                    output[dst_idx + 3] = right.data[src_idx + 3];
-                }
-            }
+    // Copy each frame, cutting what falls outside the (even) output size
+    let frames = [(left, (0, 0)), (right, right_offset)];
+    for (frame, (x0, y0)) in frames {
+        let Some(frame) = frame else {
+            continue;
+        };
+        let width = frame.width.min(output_width.saturating_sub(x0)) as usize;
+        let height = frame.height.min(output_height.saturating_sub(y0));
+        for y in 0..height {
+            let src = (y * frame.width) as usize * 4;
+            let dst = ((y0 + y) * output_width + x0) as usize * 4;
+            output[dst..dst + width * 4].copy_from_slice(&frame.data[src..src + width * 4]);
         }
     }
 
     output
 }
 
-/// Find ffmpeg executable
-fn find_ffmpeg(custom_path: Option<&str>) -> Result<String> {
-    if let Some(path) = custom_path {
-        if std::path::Path::new(path).exists() {
-            return Ok(path.to_string());
-        }
-        return Err(Error::Ffmpeg(format!("FFmpeg not found at: {}", path)));
-    }
-
-    // Try common paths
-    let paths = [
-        "ffmpeg",
-        "/usr/bin/ffmpeg",
-        "/usr/local/bin/ffmpeg",
-        "/opt/homebrew/bin/ffmpeg",
-    ];
-
-    for path in paths {
-        if Command::new(path)
-            .arg("-version")
-            .stdout(Stdio::null())
-            .stderr(Stdio::null())
-            .status()
-            .is_ok()
-        {
-            return Ok(path.to_string());
-        }
-    }
-
-    Err(Error::Ffmpeg("FFmpeg not found in PATH".to_string()))
-}
-
 /// Get video information using ffprobe
 fn get_video_info<P: AsRef<Path>>(path: P, ffmpeg: &str) -> Result<(u32, u32, f64, u64)> {
-    // Derive ffprobe path from ffmpeg path
-    let ffprobe = if ffmpeg.ends_with("ffmpeg") {
-        ffmpeg.replace("ffmpeg", "ffprobe")
-    } else {
-        "ffprobe".to_string()
-    };
+    let ffprobe = ffprobe_path(ffmpeg);
 
     let output = Command::new(&ffprobe)
         .args([
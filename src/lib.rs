@@ -4,18 +4,68 @@
 //! - `slideshow`: Create a video from a sequence of images with durations
 //! - `juxtapose`: Combine two videos side by side
 
+pub mod colorspace;
 pub mod encoder;
 pub mod error;
 pub mod ffi;
+pub mod font;
+pub mod hdr;
 pub mod image_loader;
 pub mod muxer;
+pub mod overlay;
+pub mod text;
 
+mod analysis;
+mod audio;
+mod benchmark;
+mod budget;
+mod capabilities;
+mod crop;
+mod dedupe;
+mod disk;
+mod enhance;
+mod ffmpeg;
+mod fingerprint;
 mod juxtapose;
+mod logging;
+mod logo;
+mod parallel;
+mod priority;
+mod reader;
+mod session;
+mod size_limit;
 mod slideshow;
+mod subtitles;
+mod temp;
+mod tonemap;
+mod verify;
+mod visualizer;
+mod warning;
+mod writer;
 
-pub use error::{Error, Result};
+pub use analysis::analyze_luma;
+pub use audio::{extract_audio, remove_audio, replace_audio};
+pub use benchmark::benchmark;
+pub use capabilities::capabilities;
+pub use dedupe::{dedupe_slides, find_duplicate_slides};
+pub use error::{Error, Language, MessageLanguage, Result};
+pub use ffmpeg::{ffmpeg_info, native_only, set_native_only, FFMPEG_ENV};
+pub use fingerprint::{frame_hashes, FRAME_HASH_MATCH_DISTANCE};
+pub use font::{register_font, system_fonts};
 pub use juxtapose::juxtapose;
-pub use slideshow::slideshow;
+pub use logging::{LogEvent, LogHandler, LogLevel, LogValue};
+pub use reader::{VideoFrame, VideoReader};
+pub use session::Session;
+pub use size_limit::{slideshow_within_size, EMAIL_MAX_BYTES};
+pub use slideshow::{
+    preview_slideshow, slideshow, slideshow_from_iter, slideshow_skipping_invalid,
+};
+pub use visualizer::{image_with_audio, visualize_audio};
+pub use warning::{Warning, WarningHandler, WarningKind};
+pub use writer::VideoWriter;
+
+use std::fmt;
+use std::sync::Arc;
 
 /// Video codec types
 #[derive(Debug, Clone, Copy, PartialEq, Eq)]
@@ -23,31 +73,691 @@ pub use slideshow::slideshow;
 pub enum Codec {
     /// AV1 codec (using rav1e/libaom)
     Av1 = 0,
-    /// H.264 codec (platform-specific implementation)
+    /// H.264 codec (platform-specific implementation, for MP4, MOV and MKV
+    /// output)
     H264 = 1,
+    /// PNG codec (lossless with alpha, for APNG output)
+    Png = 2,
+    /// VP9 codec (using ffmpeg with libvpx-vp9, for WebM and MKV output)
+    Vp9 = 3,
+    /// H.265/HEVC codec (using ffmpeg with VideoToolbox, NVENC, Quick Sync,
+    /// AMF or libx265, for MP4, MOV and MKV output)
+    Hevc = 4,
+    /// VP8 codec (using ffmpeg with libvpx, for WebM and MKV output to older
+    /// browsers without AV1 or VP9 support)
+    Vp8 = 5,
+    /// Apple ProRes 422 codec (using ffmpeg with prores_ks, for MOV and MKV
+    /// output to editing tools)
+    Prores = 6,
 }
 
 /// Container format types
 #[derive(Debug, Clone, Copy, PartialEq, Eq)]
 #[repr(C)]
 pub enum Container {
-    /// MP4 container (supports AV1 and H.264)
+    /// MP4 container (supports H.264 and HEVC)
     Mp4 = 0,
-    /// WebM container (supports AV1 only)
+    /// WebM container (supports AV1 and VP9)
     WebM = 1,
+    /// Animated AVIF image sequence (supports AV1 only)
+    Avif = 2,
+    /// Animated PNG (supports PNG only)
+    Apng = 3,
+    /// QuickTime container (supports ProRes, H.264 and HEVC), for editing tools
+    Mov = 4,
+    /// Matroska container (supports every codec except PNG), for archives
+    Mkv = 5,
+    /// HTTP Live Streaming playlist with segment files beside it (supports
+    /// H.264 and HEVC, and AV1 in fMP4 segments), for streaming CDNs
+    Hls = 6,
+    /// MPEG-DASH manifest with fMP4 segment files beside it (supports H.264,
+    /// HEVC and AV1), for web players
+    Dash = 7,
 }
 
 impl Container {
     /// Check if the container supports the given codec
     pub fn supports_codec(&self, codec: Codec) -> bool {
-        match (self, codec) {
-            (Container::Mp4, _) => true,
-            (Container::WebM, Codec::Av1) => true,
-            (Container::WebM, Codec::H264) => false,
+        matches!(
+            (self, codec),
+            (Container::Mp4, Codec::Av1 | Codec::H264 | Codec::Hevc)
+                | (Container::WebM, Codec::Av1 | Codec::Vp9 | Codec::Vp8)
+                | (Container::Avif, Codec::Av1)
+                | (Container::Apng, Codec::Png)
+                | (Container::Mov, Codec::H264 | Codec::Hevc | Codec::Prores)
+                | (Container::Hls, Codec::Av1 | Codec::H264 | Codec::Hevc)
+                | (Container::Dash, Codec::Av1 | Codec::H264 | Codec::Hevc)
+                | (
+                    Container::Mkv,
+                    Codec::Av1
+                        | Codec::H264
+                        | Codec::Vp9
+                        | Codec::Hevc
+                        | Codec::Vp8
+                        | Codec::Prores
+                )
+        )
+    }
+
+    /// Check if this container can carry an audio track
+    pub fn supports_audio(&self) -> bool {
+        matches!(
+            self,
+            Container::Mp4 | Container::WebM | Container::Mov | Container::Mkv
+        )
+    }
+
+    /// Check if this container can carry chapter markers
+    pub fn supports_chapters(&self) -> bool {
+        matches!(self, Container::Mp4 | Container::WebM | Container::Mkv)
+    }
+}
+
+/// Text writing mode for captions
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum WritingMode {
+    /// Horizontal lines, with right-to-left runs reordered
+    #[default]
+    Horizontal = 0,
+    /// Vertical columns running right to left (Japanese/Chinese tategaki)
+    Vertical = 1,
+}
+
+/// Alignment of caption lines along the writing direction
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum TextAlign {
+    /// Lines centered in the caption box
+    #[default]
+    Center = 0,
+    /// Lines start at the left edge (top edge for vertical text)
+    Start = 1,
+    /// Lines end at the right edge (bottom edge for vertical text)
+    End = 2,
+}
+
+/// Position of an overlay in the frame
+///
+/// Overlays are placed inside the safe area, so the same anchor keeps a
+/// proportional distance from the frame edges at any output resolution.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum Anchor {
+    /// Default position of the overlay (bottom center for horizontal
+    /// captions, top right for vertical captions)
+    #[default]
+    Auto = 0,
+    TopLeft = 1,
+    TopCenter = 2,
+    TopRight = 3,
+    MiddleLeft = 4,
+    Center = 5,
+    MiddleRight = 6,
+    BottomLeft = 7,
+    BottomCenter = 8,
+    BottomRight = 9,
+}
+
+/// How captions are fitted into the frame
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum TextFit {
+    /// Wrap lines at the maximum width and keep the font size
+    #[default]
+    Wrap = 0,
+    /// Keep explicit lines only and shrink the font until each line fits
+    Shrink = 1,
+    /// Wrap lines and shrink the font until the caption fits in a third of the frame
+    WrapShrink = 2,
+}
+
+/// How an audio track is fitted to the length of a video
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum AudioFit {
+    /// Cut audio at the end of the video; shorter audio ends early
+    #[default]
+    Trim = 0,
+    /// Repeat shorter audio until the end of the video
+    Loop = 1,
+}
+
+/// How input videos are decoded
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum DecodeMode {
+    /// Hardware decoding (VideoToolbox, NVDEC, VAAPI, ...) when available,
+    /// software decoding otherwise
+    #[default]
+    Auto = 0,
+    /// Always decode in software
+    Software = 1,
+}
+
+/// Which encoder implementation H.264, HEVC and AV1 video is encoded with
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum HwAccel {
+    /// The platform's choice: VideoToolbox on macOS, Media Foundation on
+    /// Windows and libx264 on Linux for H.264; for HEVC the first working
+    /// hardware encoder, falling back to libx265; for AV1 the software
+    /// encoder of `Av1Backend`
+    #[default]
+    Auto = 0,
+    /// Always the software encoder, whose output does not depend on the
+    /// GPU, for reproducible output in CI (libx264 or libx265 through
+    /// ffmpeg, or the software encoder of VideoToolbox or Media Foundation
+    /// for H.264; the `Av1Backend` for AV1)
+    Software = 1,
+    /// Apple VideoToolbox hardware encoder (macOS)
+    VideoToolbox = 2,
+    /// NVIDIA NVENC
+    Nvenc = 3,
+    /// Intel Quick Sync Video
+    Qsv = 4,
+    /// VA-API (Linux)
+    Vaapi = 5,
+    /// AMD AMF
+    Amf = 6,
+}
+
+impl HwAccel {
+    /// Whether a hardware encoder is required
+    pub fn is_hardware(self) -> bool {
+        !matches!(self, HwAccel::Auto | HwAccel::Software)
+    }
+}
+
+/// Software encoder of AV1 video
+///
+/// The encoders trade speed for quality differently: rav1e is built in,
+/// SVT-AV1 is much faster at a similar quality, and libaom is the slowest
+/// with the best compression at its slow presets.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum Av1Backend {
+    /// rav1e, built into the library
+    #[default]
+    Rav1e = 0,
+    /// SVT-AV1 through ffmpeg (libsvtav1; 4:2:0 SDR video without loss)
+    SvtAv1 = 1,
+    /// libaom through ffmpeg (libaom-av1; SDR video)
+    Aom = 2,
+}
+
+impl Av1Backend {
+    /// Fastest speed preset of the backend
+    pub fn max_preset(self) -> u8 {
+        match self {
+            Av1Backend::Rav1e => 10,
+            Av1Backend::SvtAv1 => 13,
+            Av1Backend::Aom => 8,
         }
     }
 }
 
+/// Profile of H.264 video, limiting the coding tools so older or simpler
+/// decoders can play it
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum H264Profile {
+    /// The encoder's choice: High for libx264, Main for VideoToolbox and
+    /// Media Foundation, or the profile the pixel format needs
+    #[default]
+    Auto = 0,
+    /// Constrained Baseline, for the oldest hardware decoders
+    Baseline = 1,
+    /// Main profile
+    Main = 2,
+    /// High profile, compressing best
+    High = 3,
+}
+
+/// Speed preset of libx264, overriding the one picked by the time budget
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum H264Preset {
+    /// Picked by the time budget
+    #[default]
+    Auto = 0,
+    Ultrafast = 1,
+    Superfast = 2,
+    Veryfast = 3,
+    Faster = 4,
+    Fast = 5,
+    Medium = 6,
+    Slow = 7,
+    Slower = 8,
+    Veryslow = 9,
+}
+
+/// Tuning of libx264 for the kind of content
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum H264Tune {
+    /// No tuning
+    #[default]
+    None = 0,
+    /// Still images, as in slideshows: spends far fewer bits on frames
+    /// that barely change
+    StillImage = 1,
+    /// Animation with flat areas, as in screen recordings and cartoons
+    Animation = 2,
+}
+
+/// Levels of H.264 video, as the level times ten (31 for level 3.1)
+pub const H264_LEVELS: [u8; 19] = [
+    10, 11, 12, 13, 20, 21, 22, 30, 31, 32, 40, 41, 42, 50, 51, 52, 60, 61, 62,
+];
+
+/// What happens when a hardware encoder of H.264, HEVC or AV1 is unavailable
+/// or fails during the encode
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum HwFallback {
+    /// Fail with the error of the hardware encoder
+    #[default]
+    Fail = 0,
+    /// Encode again from the start with the software encoder, as with
+    /// `HwAccel::Software`
+    Software = 1,
+}
+
+/// CPU and I/O priority of encoding work, so background jobs yield to other
+/// work on the host
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum Priority {
+    /// Priority of the calling thread
+    #[default]
+    Normal = 0,
+    /// Nice 10 and the lowest best-effort I/O priority on Linux, background
+    /// threads on macOS and Windows
+    Low = 1,
+    /// Nice 19 and I/O only when the disk is otherwise idle on Linux,
+    /// background threads on macOS and Windows
+    Idle = 2,
+}
+
+/// Whether output is byte-identical for the same inputs and options
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum Reproducibility {
+    /// Output may differ between hosts and runs, as hardware encoders, the
+    /// number of cores and time budgets change what is encoded
+    #[default]
+    Any = 0,
+    /// The same bytes on any host: software encoders with a fixed number of
+    /// threads, and no ffmpeg version or random identifiers in the output.
+    /// Cannot be combined with hardware encoders or a time budget
+    Deterministic = 1,
+}
+
+/// Where the chapter markers of a video come from
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum ChapterMode {
+    /// The chapters of the options, if any
+    #[default]
+    List = 0,
+    /// One chapter per slide, titled with the first line of its caption or
+    /// "Slide N" (slideshow only)
+    Slides = 1,
+}
+
+/// Whether a rendered output is checked against what was encoded
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum OutputCheck {
+    /// Trust the output
+    #[default]
+    None = 0,
+    /// Probe the output with ffprobe and fail with `Error::OutputMismatch`
+    /// when its codec, frame size, frame rate or duration differs
+    Probe = 1,
+}
+
+/// Called with the error of a hardware encoder before the encode is run
+/// again with the software encoder
+#[derive(Clone)]
+pub struct FallbackHandler(Arc<dyn Fn(&Error) + Send + Sync>);
+
+impl FallbackHandler {
+    /// Wrap a function called on each fallback
+    pub fn new(handler: impl Fn(&Error) + Send + Sync + 'static) -> Self {
+        Self(Arc::new(handler))
+    }
+
+    /// Report a fallback caused by an error
+    pub(crate) fn call(&self, err: &Error) {
+        (self.0)(err)
+    }
+}
+
+impl fmt::Debug for FallbackHandler {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str("FallbackHandler")
+    }
+}
+
+/// How juxtapose arranges its two videos
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum JuxtaposeLayout {
+    /// Side by side, the left video first
+    #[default]
+    Horizontal = 0,
+    /// Stacked, the left video on top
+    Vertical = 1,
+    /// Side by side for portrait videos, stacked for landscape ones: the
+    /// arrangement closer to square, which fills more of a screen
+    Auto = 2,
+}
+
+/// How slides with a different aspect ratio are fitted to the output size
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum SlideFit {
+    /// Resize to the output size, ignoring the aspect ratio
+    #[default]
+    Stretch = 0,
+    /// Fill the frame, cropping the overflow
+    Cover = 1,
+    /// Fit inside the frame, padding with black
+    Contain = 2,
+}
+
+/// Where cover-cropped slides keep their content
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum CropFocus {
+    /// Keep the center of the image
+    #[default]
+    Center = 0,
+    /// Keep faces (skin tones) and detailed regions in frame
+    Salient = 1,
+}
+
+/// How frames that repeat the previous frame are encoded
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum IdleFrames {
+    /// Encode every frame
+    #[default]
+    Keep = 0,
+    /// Encode a run of identical frames once, shown for the whole run
+    ///
+    /// Mostly static output such as slideshows and screen recordings
+    /// becomes much smaller.
+    Merge = 1,
+}
+
+/// Whether encoding may lose detail
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum Compression {
+    /// Lossy compression at the requested quality
+    #[default]
+    Lossy = 0,
+    /// Lossless compression with full chroma resolution (AV1, H.264 on
+    /// Linux, VP9 and PNG), for archival masters and golden-file tests
+    ///
+    /// The frames are stored exactly as converted to YUV 4:4:4, so repeated
+    /// encodes of the same input decode to identical pixels.
+    Lossless = 1,
+}
+
+/// Chroma resolution of the encoded video
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum PixelFormat {
+    /// The codec's usual format: 4:2:0, 4:4:4 with lossless compression
+    /// and 4:2:2 for ProRes
+    #[default]
+    Auto = 0,
+    /// Chroma at half the width and height (`yuv420p`), played everywhere
+    Yuv420 = 1,
+    /// Chroma at half the width (`yuv422p`; AV1, H.264 on Linux, HEVC, VP9
+    /// and ProRes)
+    Yuv422 = 2,
+    /// Chroma at full resolution (`yuv444p`), keeping colored text and
+    /// lines sharp (AV1, H.264 on Linux, HEVC, VP9 and ProRes 4444)
+    Yuv444 = 3,
+}
+
+/// Bits per sample of the encoded video
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum BitDepth {
+    /// 8 bits, played everywhere
+    #[default]
+    Eight = 0,
+    /// 10 bits, avoiding banding in smooth gradients (AV1, H.264 on Linux,
+    /// HEVC and VP9; ProRes is always 10-bit)
+    ///
+    /// The frames are still drawn with 8 bits per channel; the finer steps
+    /// of the conversion to YUV keep gradients from breaking into bands.
+    Ten = 1,
+}
+
+/// Dynamic range of the encoded video
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum DynamicRange {
+    /// Standard dynamic range (BT.709); HDR inputs are tone mapped
+    #[default]
+    Sdr = 0,
+    /// HDR10: PQ transfer, BT.2020 primaries and mastering display metadata
+    /// (AV1, VP9 and HEVC at 10 bits; slideshow and juxtapose)
+    ///
+    /// HDR inputs keep their highlights up to the mastering display peak.
+    /// SDR inputs and overlays are placed at the HDR reference white of
+    /// 203 cd/m², so they look as they do in SDR output.
+    Hdr10 = 1,
+}
+
+/// Color primaries of the encoded video, tagged for players
+///
+/// The frames are not converted: the primaries describe the colors of the
+/// input images, which are decoded without color management.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum ColorPrimaries {
+    /// BT.709 (sRGB), or BT.2020 for HDR10
+    #[default]
+    Auto = 0,
+    /// BT.709, shared with sRGB
+    Bt709 = 1,
+    /// BT.601 525-line (SMPTE 170M)
+    Bt601 = 2,
+    /// BT.2020
+    Bt2020 = 3,
+    /// Display P3, as photos from recent phones and cameras use (not H.264
+    /// on Windows)
+    DisplayP3 = 4,
+}
+
+/// Transfer characteristics of the encoded video, tagged for players
+///
+/// The frames are not converted: the transfer describes the gamma of the
+/// input images.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum ColorTransfer {
+    /// BT.709 for video, sRGB for AVIF, or PQ for HDR10
+    #[default]
+    Auto = 0,
+    /// BT.709, as video is usually tagged
+    Bt709 = 1,
+    /// sRGB (IEC 61966-2-1), as images are usually tagged
+    Srgb = 2,
+}
+
+/// Matrix converting the RGB frames to YUV, tagged for players
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum ColorMatrix {
+    /// BT.709 for video, BT.601 for AVIF, or BT.2020 for HDR10
+    #[default]
+    Auto = 0,
+    /// BT.709
+    Bt709 = 1,
+    /// BT.601, for older players that ignore the tags of SD video
+    Bt601 = 2,
+    /// BT.2020 non-constant luminance
+    Bt2020 = 3,
+}
+
+/// Range of the YUV samples of the encoded video
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum ColorRange {
+    /// Limited for video, full for AVIF
+    #[default]
+    Auto = 0,
+    /// Limited (studio) range, 16-235 at 8 bits, which every player expects
+    /// of video
+    Limited = 1,
+    /// Full range, 0-255 at 8 bits (not ProRes, nor H.264 on macOS)
+    Full = 2,
+}
+
+/// What happens to transparent pixels of slides
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum Transparency {
+    /// Drop the alpha channel, showing the color of transparent pixels
+    #[default]
+    Opaque = 0,
+    /// Keep the alpha channel so the video can be overlaid on a web page
+    /// (VP8 and VP9 in WebM, PNG in APNG; slideshow only)
+    ///
+    /// VP8 and VP9 store the alpha channel as a second stream beside each
+    /// frame, as browsers expect. Slides fitted with [`SlideFit::Contain`]
+    /// are padded with transparent pixels instead of black.
+    Keep = 1,
+}
+
+/// Segment format of HLS output
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum HlsSegment {
+    /// MPEG transport stream segments (`.ts`), played by every HLS client
+    #[default]
+    Ts = 0,
+    /// Fragmented MP4 segments (`.m4s`) with an initialization segment,
+    /// which also carry AV1 and can be shared with DASH
+    Fmp4 = 1,
+}
+
+/// Number of encoding passes
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum Passes {
+    /// A single pass
+    #[default]
+    One = 0,
+    /// A first pass gathering statistics of the whole video and a second
+    /// pass spending the bits where they are needed, for consistent quality
+    /// across slides of very different complexity at about twice the
+    /// encoding time (VP9 and VP8, slideshow only)
+    Two = 1,
+}
+
+/// Layout of MP4 output
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum Mp4Layout {
+    /// The movie box before the media data (`-movflags +faststart` in
+    /// ffmpeg), so browsers can start playback before the whole file is
+    /// downloaded
+    #[default]
+    Faststart = 0,
+    /// An empty movie box followed by one fragment per keyframe interval
+    /// (`-movflags frag_keyframe+empty_moov` in ffmpeg), written as encoding
+    /// goes, so the file can be streamed before it is complete
+    Fragmented = 1,
+    /// The movie box wherever the muxer wrote it, after the media data for
+    /// H.264, skipping the pass that moves it to the front
+    MoovAtEnd = 2,
+}
+
+/// Whether the video is written as one file or as separate segment files
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum OutputMode {
+    /// A single file at the output path
+    #[default]
+    Single = 0,
+    /// Standalone files of the segment duration, each starting with a
+    /// keyframe, beside a JSON manifest written to the output path, for
+    /// external packagers (MP4, MOV, WebM and MKV, video only)
+    ///
+    /// Keyframes are placed at every multiple of the segment duration, so
+    /// segments split exactly there, except with the Windows H.264 encoder,
+    /// whose segments run on to the next keyframe.
+    Segments = 1,
+}
+
+/// How slides are compared when looking for duplicates
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum DuplicateMatch {
+    /// Pixel-identical images
+    #[default]
+    Exact = 0,
+    /// Images of the same size that differ only by noise, such as
+    /// re-compressed copies of the same slide
+    Similar = 1,
+}
+
+/// Tone mapping operator for HDR inputs
+///
+/// HDR videos (PQ or HLG) and floating-point images are mapped to SDR so
+/// they can be shown next to SDR inputs.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum ToneMap {
+    /// Filmic curve with soft highlights
+    #[default]
+    Hable = 0,
+    /// Simple curve that keeps more midtone contrast
+    Reinhard = 1,
+    /// Linear up to the midtones, compressing only the highlights
+    Mobius = 2,
+    /// Clip values above SDR white
+    Clip = 3,
+}
+
+/// Style of an audio visualization
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum VisualStyle {
+    /// Waveform of the audio
+    #[default]
+    Waveform = 0,
+    /// Frequency spectrum bars
+    Spectrum = 1,
+}
+
+/// Audio codecs for extracted audio files
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum AudioCodec {
+    /// Chosen by the output extension
+    #[default]
+    Auto = 0,
+    /// MP3 (.mp3)
+    Mp3 = 1,
+    /// AAC (.m4a or .aac)
+    Aac = 2,
+    /// Opus (.opus or .ogg)
+    Opus = 3,
+    /// Uncompressed 16-bit PCM (.wav)
+    Wav = 4,
+}
+
 /// RGB color representation
 #[derive(Debug, Clone, Copy, PartialEq, Eq)]
 #[repr(C)]
@@ -68,14 +778,282 @@ impl Default for Color {
 }
 
 /// Slide entry for slideshow creation
-#[derive(Debug, Clone)]
+#[derive(Debug, Clone, Default)]
 pub struct SlideEntry {
     /// Path to the image file
     pub path: String,
     /// Duration to display this image in milliseconds
+    ///
+    /// With narration audio, 0 uses the length of the narration.
     pub duration_ms: u32,
+    /// Caption text drawn near the bottom of the slide
+    pub caption: Option<String>,
+    /// Narration audio played from the start of the slide (requires ffmpeg)
+    pub audio: Option<String>,
+}
+
+/// Slides with duplicates merged into them
+#[derive(Debug, Clone, Default)]
+pub struct DedupedSlides {
+    /// Remaining slides, with the durations of merged duplicates added
+    pub entries: Vec<SlideEntry>,
+    /// One message per merged run of slides
+    pub warnings: Vec<String>,
+}
+
+/// A slide left out of a slideshow because its input could not be used
+#[derive(Debug)]
+pub struct SkippedSlide {
+    /// Index of the slide in the entries
+    pub index: usize,
+    /// Why the slide could not be used
+    pub error: Error,
+}
+
+/// How a slideshow preview lays out its slides
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub enum PreviewLayout {
+    /// All slides side by side in one image
+    #[default]
+    Strip = 0,
+    /// One image per slide, numbered from 1 beside the preview path
+    Files = 1,
 }
 
+/// Options of a slideshow preview
+#[derive(Debug, Clone, Default)]
+pub struct PreviewOptions {
+    /// Output PNG path (the strip, or the name the slide images are numbered
+    /// after: `deck.png` gives `deck_00001.png`, `deck_00002.png`, ...)
+    pub output_path: String,
+    pub layout: PreviewLayout,
+    /// Height of each slide in pixels, keeping the aspect ratio (0 for the
+    /// video size)
+    pub height: u32,
+}
+
+/// Settings chosen to fit a slideshow in a size limit
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub struct SizedSettings {
+    /// Quality (0-100)
+    pub quality: u8,
+    /// Resolution in percent of the first slide
+    pub scale: u32,
+    /// Output width in pixels
+    pub width: u32,
+    /// Output height in pixels
+    pub height: u32,
+    /// Output file size in bytes
+    pub size: u64,
+}
+
+/// A span of the output video in milliseconds
+///
+/// Negative values are measured back from the end of the video, and an
+/// `end_ms` of 0 is the end of the video.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+#[repr(C)]
+pub struct TimeRange {
+    /// Start of the range
+    pub start_ms: i64,
+    /// End of the range (0 for the end of the video)
+    pub end_ms: i64,
+}
+
+/// Chapter marker players can jump to
+#[derive(Debug, Clone, PartialEq, Eq, Default)]
+pub struct Chapter {
+    /// Start of the chapter in milliseconds
+    pub start_ms: u64,
+    /// Title shown by players (up to 255 bytes in MP4)
+    pub title: String,
+}
+
+/// Logo drawn over every frame of the output
+#[derive(Debug, Clone, Default)]
+pub struct Logo {
+    /// Image file; animated GIF, PNG and WebP files play in a loop
+    pub path: String,
+    /// Logo position (Auto for the top left)
+    pub anchor: Anchor,
+    /// Logo width in percent of the frame width (0 for the default of 15)
+    pub width: u32,
+    /// Length of the fade in and out at the edges of each range in milliseconds
+    pub fade_ms: u32,
+    /// Ranges in which the logo is shown (empty for the whole video)
+    pub ranges: Vec<TimeRange>,
+}
+
+/// Settings of an audio visualization video
+#[derive(Debug, Clone, Default)]
+pub struct Visualization {
+    /// Waveform or spectrum
+    pub style: VisualStyle,
+    /// Output width in pixels (0 for 1280)
+    pub width: u32,
+    /// Output height in pixels (0 for 720)
+    pub height: u32,
+    /// Color of the waveform or spectrum
+    pub color: Color,
+    /// Background color (None for black)
+    pub background: Option<Color>,
+    /// Background image, cropped to fill the frame (overrides the background color)
+    pub background_image: Option<String>,
+}
+
+/// Luma statistics of a frame or a whole input
+///
+/// Luma is computed with BT.709 weights on a 0-255 scale.
+#[derive(Debug, Clone, Copy, PartialEq)]
+#[repr(C)]
+pub struct LumaStats {
+    /// Number of pixels at each luma level
+    pub histogram: [u64; 256],
+    /// Total number of pixels
+    pub pixel_count: u64,
+    /// Mean luma (0-255)
+    pub mean: f32,
+    /// Fraction of pixels with clipped shadows (luma 0-2)
+    pub shadow_clip: f32,
+    /// Fraction of pixels with clipped highlights (luma 253-255)
+    pub highlight_clip: f32,
+}
+
+/// Result of a luma analysis
+#[derive(Debug, Clone)]
+pub struct LumaAnalysis {
+    /// Statistics of each frame (a single frame for images)
+    pub frames: Vec<LumaStats>,
+    /// Statistics of all frames together
+    pub aggregate: LumaStats,
+}
+
+/// Perceptual hash of a sampled frame
+///
+/// Frames with the same content have hashes within
+/// `FRAME_HASH_MATCH_DISTANCE` differing bits.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+#[repr(C)]
+pub struct FrameHash {
+    /// Time of the frame in milliseconds
+    pub time_ms: u64,
+    /// 64-bit perceptual hash
+    pub hash: u64,
+}
+
+/// A container, codec and encoder combination usable on this machine
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+#[repr(C)]
+pub struct Capability {
+    /// Container of the output
+    pub container: Container,
+    /// Video codec in the container
+    pub codec: Codec,
+    /// Encoder of the codec: `Software`, or the hardware encoder of H.264,
+    /// HEVC and AV1 (never `Auto`)
+    pub hw_accel: HwAccel,
+}
+
+/// An ffmpeg executable found by the library
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct FfmpegInfo {
+    /// Path of the executable, or its name when found in PATH
+    pub path: String,
+    /// Version reported by ffmpeg, such as "6.1.1"
+    pub version: String,
+    /// Names of the enabled encoders, such as "libvpx-vp9" or "aac"
+    pub encoders: Vec<String>,
+}
+
+/// Workload of an encoder benchmark
+///
+/// Zero values select the defaults.
+#[derive(Debug, Clone, Default)]
+pub struct BenchmarkSpec {
+    /// Codecs to measure, each in its usual container (empty for every
+    /// codec available on the host)
+    pub codecs: Vec<Codec>,
+    /// Frame width (0 for 1280)
+    pub width: u32,
+    /// Frame height (0 for 720)
+    pub height: u32,
+    /// Duration of the workload in milliseconds (0 for 5 seconds)
+    pub duration_ms: u32,
+    /// Frame rate (0 for `DEFAULT_FPS`)
+    pub fps: u32,
+    /// Quality (1-100, 0 for 50)
+    pub quality: u8,
+    /// Directory the encoded files are written to and removed from (None
+    /// for the system temporary directory)
+    pub output_dir: Option<String>,
+    /// Path to ffmpeg (None to search PATH)
+    pub ffmpeg_path: Option<String>,
+}
+
+/// Measurements of one codec in a benchmark
+#[derive(Debug)]
+pub struct BenchmarkResult {
+    /// Codec measured
+    pub codec: Codec,
+    /// Container the output was written to
+    pub container: Container,
+    /// Error that stopped the encode (None when it finished; the
+    /// measurements are zero otherwise)
+    pub error: Option<Error>,
+    /// Number of frames encoded
+    pub frames: u32,
+    /// Wall-clock time of the encode in milliseconds
+    pub encode_ms: u64,
+    /// Frames encoded per second
+    pub encode_fps: f64,
+    /// CPU time over wall-clock time in percent of one core, including
+    /// ffmpeg processes except on Windows (None when the platform does not
+    /// report CPU time)
+    pub cpu_percent: Option<f64>,
+    /// Size of the output in bytes
+    pub output_bytes: u64,
+    /// Average bitrate of the output in kbit/s
+    pub bitrate_kbps: u32,
+    /// PSNR of the RGB channels against the workload in dB, 100 for
+    /// identical frames (None when ffmpeg cannot decode the output)
+    pub psnr: Option<f64>,
+    /// Mean SSIM of the luma against the workload, 1.0 for identical
+    /// frames (None when ffmpeg cannot decode the output)
+    pub ssim: Option<f64>,
+}
+
+/// Result of an encoder benchmark
+#[derive(Debug)]
+pub struct BenchmarkReport {
+    /// Frame width of the workload
+    pub width: u32,
+    /// Frame height of the workload
+    pub height: u32,
+    /// Frame rate of the workload
+    pub fps: u32,
+    /// Number of frames of the workload
+    pub frames: u32,
+    /// Number of CPU cores available to the process
+    pub cpu_count: u32,
+    /// Measurements of each codec, in the order of the spec
+    pub results: Vec<BenchmarkResult>,
+}
+
+/// Frame rate of output when none is set
+pub const DEFAULT_FPS: u32 = 30;
+
+/// Highest output frame rate
+pub const MAX_FPS: u32 = 120;
+
+/// Threads of the video encoder for deterministic output when none are set,
+/// so hosts with different numbers of cores write the same bytes
+const DETERMINISTIC_THREADS: u32 = 4;
+
+/// Most chapters the MP4 chapter list can hold
+const MAX_MP4_CHAPTERS: usize = 255;
+
 /// Options for video encoding
 #[derive(Debug, Clone)]
 pub struct EncodeOptions {
@@ -89,9 +1067,442 @@ pub struct EncodeOptions {
     pub quality: u8,
     /// Path to ffmpeg executable (for H.264 on Linux)
     pub ffmpeg_path: Option<String>,
+    /// Font name used for captions (None for the bundled default font)
+    pub font: Option<String>,
+    /// Writing mode for captions
+    pub writing_mode: WritingMode,
+    /// Alignment of caption lines
+    pub caption_align: TextAlign,
+    /// How captions are fitted into the frame
+    pub caption_fit: TextFit,
+    /// Maximum caption width in percent of the frame (0 for the default of 90)
+    pub caption_max_width: u32,
+    /// Caption position
+    pub caption_anchor: Anchor,
+    /// Safe-area margin on each edge in percent of the frame size (0 for the default of 5)
+    pub safe_area: u32,
+    /// Audio file played as background music (slideshow only, requires ffmpeg)
+    pub background_music: Option<String>,
+    /// Slide number format with `{n}` and `{total}` placeholders (slideshow
+    /// only; None for no slide numbers, empty for "{n} / {total}")
+    pub slide_number: Option<String>,
+    /// Slide number position
+    pub slide_number_anchor: Anchor,
+    /// Logo drawn over the video
+    pub logo: Option<Logo>,
+    /// How input videos are decoded (juxtapose only)
+    pub decode_mode: DecodeMode,
+    /// Target loudness of slideshow audio in LUFS (0 for no normalization)
+    pub loudness: f32,
+    /// Tone mapping operator for HDR inputs
+    pub tone_map: ToneMap,
+    /// Gain of slideshow audio in dB (-60 to 20)
+    pub audio_gain: f32,
+    /// Length of the fade-in at the start of slideshow audio in milliseconds
+    pub audio_fade_in_ms: u32,
+    /// Length of the fade-out at the end of slideshow audio in milliseconds
+    pub audio_fade_out_ms: u32,
+    /// Narration level above which background music is lowered, in dBFS
+    /// (-60 to -1; 0 for the default of -30)
+    pub duck_threshold: f32,
+    /// Approximate reduction of background music under narration in dB
+    /// (1 to 18; 0 for the default of 12)
+    pub duck_amount: f32,
+    /// Strength of the automatic white balance and contrast stretch applied
+    /// to each slide in percent (0 for none, up to 100)
+    pub enhance: u32,
+    /// How slides are fitted to the output size
+    pub slide_fit: SlideFit,
+    /// Where cover-cropped slides keep their content
+    pub crop_focus: CropFocus,
+    /// How frames that repeat the previous frame are encoded
+    pub idle_frames: IdleFrames,
+    /// Wall-clock time the encode should finish within in milliseconds
+    /// (0 for none); faster encoder presets, and for slideshows a lower
+    /// resolution, are chosen to meet it
+    pub time_budget_ms: u32,
+    /// Whether encoding may lose detail
+    pub compression: Compression,
+    /// What happens to transparent pixels of slides
+    pub transparency: Transparency,
+    /// Segment format of HLS output
+    pub hls_segment: HlsSegment,
+    /// Target duration of streaming segments and segment files in
+    /// milliseconds (0 for the default of 6000)
+    pub segment_duration_ms: u32,
+    /// Layout of MP4 output
+    pub mp4_layout: Mp4Layout,
+    /// Number of encoding passes (slideshow only)
+    pub passes: Passes,
+    /// Average video bitrate in kbit/s, replacing the quality (0 for none)
+    pub bitrate_kbps: u32,
+    /// Peak video bitrate in kbit/s (0 for none)
+    pub max_bitrate_kbps: u32,
+    /// Size of the decoder buffer the peak bitrate is measured over in kbit
+    /// (0 for two seconds at the peak bitrate)
+    pub buffer_size_kbits: u32,
+    /// Whether the video is written as one file or as segment files
+    pub output_mode: OutputMode,
+    /// File size to aim for in bytes, setting the bitrate from the length of
+    /// the video (0 for none)
+    pub target_size_bytes: u64,
+    /// Output frame rate in frames per second (0 for the default of 30, up
+    /// to 120)
+    pub fps: u32,
+    /// SRT or WebVTT subtitles drawn on the left video (juxtapose only)
+    pub left_subtitles: Option<String>,
+    /// SRT or WebVTT subtitles drawn on the right video (juxtapose only)
+    pub right_subtitles: Option<String>,
+    /// Time from one keyframe to the next in milliseconds, for packagers
+    /// that cut segments at keyframes (0 for the encoder's own placement,
+    /// or a segment apart in segment output; not honoured by the Windows
+    /// H.264 encoder)
+    pub keyframe_interval_ms: u32,
+    /// Times in milliseconds at which a keyframe is forced besides the
+    /// interval, rounded to the nearest frame (not honoured by the Windows
+    /// H.264 encoder)
+    pub keyframe_times_ms: Vec<u64>,
+    /// Chroma resolution of the encoded video
+    pub pixel_format: PixelFormat,
+    /// Bits per sample of the encoded video
+    pub bit_depth: BitDepth,
+    /// Dynamic range of the encoded video
+    pub dynamic_range: DynamicRange,
+    /// Peak luminance of the mastering display of HDR10 output in cd/m²
+    /// (400-10000; 0 for 1000); brighter highlights are clipped
+    pub max_luminance: u32,
+    /// Color primaries tagged on the video
+    pub color_primaries: ColorPrimaries,
+    /// Transfer characteristics tagged on the video
+    pub color_transfer: ColorTransfer,
+    /// Matrix converting the frames to YUV
+    pub color_matrix: ColorMatrix,
+    /// Range of the YUV samples
+    pub color_range: ColorRange,
+    /// How the two videos are arranged (juxtapose only)
+    pub juxtapose_layout: JuxtaposeLayout,
+    /// Encoder implementation of H.264, HEVC and AV1
+    pub hw_accel: HwAccel,
+    /// What happens when the hardware encoder is unavailable or fails
+    pub hw_fallback: HwFallback,
+    /// Called before falling back to the software encoder (None to fall
+    /// back silently)
+    pub on_fallback: Option<FallbackHandler>,
+    /// Whether the output is checked after rendering (requires ffmpeg)
+    pub output_check: OutputCheck,
+    /// Threads of the video encoder (0 for the encoder's default, about one
+    /// per core; not honoured by the macOS and Windows H.264 encoders), and
+    /// of decoding and scaling slides (0 for one per core)
+    pub threads: u32,
+    /// Software encoder of AV1 video
+    pub av1_backend: Av1Backend,
+    /// Speed preset of the AV1 backend, from 1 (slow, best quality) to its
+    /// `max_preset` (0 for the default, picked by the time budget)
+    pub av1_preset: u8,
+    /// Profile of H.264 video
+    pub h264_profile: H264Profile,
+    /// Level of H.264 video, as the level times ten from `H264_LEVELS` (31
+    /// for level 3.1; 0 for the encoder's choice, from the size and rate)
+    pub h264_level: u8,
+    /// Speed preset of libx264 (Linux software encoder only)
+    pub h264_preset: H264Preset,
+    /// Tuning of libx264 (Linux software encoder only)
+    pub h264_tune: H264Tune,
+    /// Memory for the slide images in megabytes (0 for none); slides are
+    /// decoded on as many threads as fit in half of it, fail instead of
+    /// exceeding their share, and are loaded again when encoded rather than
+    /// kept in memory. Frames being composed and encoded, the encoders,
+    /// ffmpeg processes and encoded video are not counted; encoded packets
+    /// are muxed as they come, except by streaming slideshows, which keep
+    /// them until the last slide as the chapters and audio are only known
+    /// then
+    pub memory_budget_mb: u32,
+    /// Session keeping encoders warm across jobs (None to start a new
+    /// encoder for each job)
+    pub session: Option<Session>,
+    /// CPU and I/O priority of the job, run on a thread of its own when
+    /// lowered (on Linux, also of its threads and ffmpeg processes)
+    pub priority: Priority,
+    /// Directory of temporary files, the statistics of two-pass encodes and
+    /// the audio written to video writers (None for the directory of the
+    /// output)
+    pub temp_dir: Option<String>,
+    /// Extra arguments of the ffmpeg video encoder, after the library's own
+    /// so they override them (unsupported: they may conflict with the
+    /// library's arguments or change in meaning between ffmpeg versions)
+    pub ffmpeg_args: Vec<String>,
+    /// Called with structured events of the job: stages, the encoder
+    /// chosen, fallbacks and warnings (None for no events)
+    pub logger: Option<LogHandler>,
+    /// Called with non-fatal warnings of the job, such as a frame size
+    /// rounded to even dimensions or audio cut to the video (None to ignore
+    /// them)
+    pub on_warning: Option<WarningHandler>,
+    /// Whether the same inputs and options give byte-identical output, for
+    /// golden-file tests and content-addressed storage
+    pub reproducibility: Reproducibility,
+    /// Where the chapter markers of MP4, WebM and MKV output come from
+    pub chapter_mode: ChapterMode,
+    /// Chapter markers in order of their start, with `ChapterMode::List`
+    /// (empty for none)
+    pub chapters: Vec<Chapter>,
+}
+
+impl Default for EncodeOptions {
+    fn default() -> Self {
+        Self {
+            output_path: String::new(),
+            container: Container::WebM,
+            codec: Codec::Av1,
+            quality: 50,
+            ffmpeg_path: None,
+            font: None,
+            writing_mode: WritingMode::Horizontal,
+            caption_align: TextAlign::Center,
+            caption_fit: TextFit::Wrap,
+            caption_max_width: 0,
+            caption_anchor: Anchor::Auto,
+            safe_area: 0,
+            background_music: None,
+            slide_number: None,
+            slide_number_anchor: Anchor::Auto,
+            logo: None,
+            decode_mode: DecodeMode::Auto,
+            loudness: 0.0,
+            tone_map: ToneMap::Hable,
+            audio_gain: 0.0,
+            audio_fade_in_ms: 0,
+            audio_fade_out_ms: 0,
+            duck_threshold: 0.0,
+            duck_amount: 0.0,
+            enhance: 0,
+            slide_fit: SlideFit::Stretch,
+            crop_focus: CropFocus::Center,
+            idle_frames: IdleFrames::Keep,
+            time_budget_ms: 0,
+            compression: Compression::Lossy,
+            transparency: Transparency::Opaque,
+            hls_segment: HlsSegment::Ts,
+            segment_duration_ms: 0,
+            mp4_layout: Mp4Layout::Faststart,
+            passes: Passes::One,
+            bitrate_kbps: 0,
+            max_bitrate_kbps: 0,
+            buffer_size_kbits: 0,
+            output_mode: OutputMode::Single,
+            target_size_bytes: 0,
+            fps: 0,
+            left_subtitles: None,
+            right_subtitles: None,
+            keyframe_interval_ms: 0,
+            keyframe_times_ms: Vec::new(),
+            pixel_format: PixelFormat::Auto,
+            bit_depth: BitDepth::Eight,
+            dynamic_range: DynamicRange::Sdr,
+            max_luminance: 0,
+            color_primaries: ColorPrimaries::Auto,
+            color_transfer: ColorTransfer::Auto,
+            color_matrix: ColorMatrix::Auto,
+            color_range: ColorRange::Auto,
+            juxtapose_layout: JuxtaposeLayout::Horizontal,
+            hw_accel: HwAccel::Auto,
+            hw_fallback: HwFallback::Fail,
+            on_fallback: None,
+            output_check: OutputCheck::None,
+            threads: 0,
+            av1_backend: Av1Backend::Rav1e,
+            av1_preset: 0,
+            h264_profile: H264Profile::Auto,
+            h264_level: 0,
+            h264_preset: H264Preset::Auto,
+            h264_tune: H264Tune::None,
+            memory_budget_mb: 0,
+            session: None,
+            priority: Priority::Normal,
+            temp_dir: None,
+            ffmpeg_args: Vec::new(),
+            logger: None,
+            on_warning: None,
+            reproducibility: Reproducibility::Any,
+            chapter_mode: ChapterMode::List,
+            chapters: Vec::new(),
+        }
+    }
+}
+
+/// Options for audio operations on existing videos
+#[derive(Debug, Clone, Default)]
+pub struct AudioOptions {
+    /// Path to ffmpeg executable (None to search PATH)
+    pub ffmpeg_path: Option<String>,
+    /// How audio is fitted to the video length
+    pub fit: AudioFit,
+    /// Codec of extracted audio
+    pub codec: AudioCodec,
+    /// Audio bitrate in kbit/s (0 for the encoder default; ignored for WAV)
+    pub bitrate: u32,
+    /// Gain in dB (-60 to 20)
+    pub gain: f32,
+    /// Length of the fade-in at the start of the audio in milliseconds
+    pub fade_in_ms: u32,
+    /// Length of the fade-out at the end of the audio in milliseconds
+    pub fade_out_ms: u32,
 }
 
 impl EncodeOptions {
+    /// Report an event to the logger, if any
+    pub(crate) fn log(
+        &self,
+        level: LogLevel,
+        message: &'static str,
+        attrs: Vec<(&'static str, LogValue)>,
+    ) {
+        if let Some(logger) = &self.logger {
+            logger.log(level, message, attrs);
+        }
+    }
+
+    /// Report a warning to the warning handler, if any
+    pub(crate) fn warn(&self, kind: WarningKind, message: String) {
+        if let Some(handler) = &self.on_warning {
+            handler.call(kind, message);
+        }
+    }
+
+    /// Bitrate limits of the video encoder
+    pub(crate) fn bitrate(&self) -> encoder::Bitrate {
+        encoder::Bitrate {
+            target_kbps: self.bitrate_kbps,
+            max_kbps: self.max_bitrate_kbps,
+            buffer_kbits: self.buffer_size_kbits,
+        }
+    }
+
+    /// Whether the output must be byte-identical between runs and hosts
+    pub(crate) fn deterministic(&self) -> bool {
+        self.reproducibility == Reproducibility::Deterministic
+    }
+
+    /// Encoder implementation of H.264, HEVC and AV1; deterministic output
+    /// takes the software encoders, as hardware encoders differ between GPUs
+    pub(crate) fn encoder_hw_accel(&self) -> HwAccel {
+        match self.hw_accel {
+            HwAccel::Auto if self.deterministic() => HwAccel::Software,
+            hw_accel => hw_accel,
+        }
+    }
+
+    /// Threads of the video encoder; deterministic output replaces the
+    /// encoder's default, which follows the number of cores and changes the
+    /// output of some encoders, with a fixed count
+    pub(crate) fn encoder_threads(&self) -> u32 {
+        match self.threads {
+            0 if self.deterministic() => DETERMINISTIC_THREADS,
+            threads => threads,
+        }
+    }
+
+    /// Whether chapter markers are written into the output
+    pub(crate) fn has_chapters(&self) -> bool {
+        self.chapter_mode == ChapterMode::Slides || !self.chapters.is_empty()
+    }
+
+    /// Check that chapters are in order and fit the container
+    pub(crate) fn check_chapters(&self, chapters: &[Chapter]) -> Result<()> {
+        if self.container == Container::Mp4 && chapters.len() > MAX_MP4_CHAPTERS {
+            return Err(Error::InvalidInput(format!(
+                "MP4 holds up to {} chapters, got {}",
+                MAX_MP4_CHAPTERS,
+                chapters.len()
+            )));
+        }
+        for pair in chapters.windows(2) {
+            if pair[1].start_ms <= pair[0].start_ms {
+                return Err(Error::InvalidInput(format!(
+                    "Chapter \"{}\" at {} ms must start after \"{}\" at {} ms",
+                    pair[1].title, pair[1].start_ms, pair[0].title, pair[0].start_ms
+                )));
+            }
+        }
+        Ok(())
+    }
+
+    /// Output frame rate
+    pub(crate) fn frame_rate(&self) -> u32 {
+        match self.fps {
+            0 => DEFAULT_FPS,
+            fps => fps,
+        }
+    }
+
+    /// HDR10 metadata of the output with the given content light levels,
+    /// or None for SDR output
+    pub(crate) fn hdr10(&self, max_cll: u16, max_fall: u16) -> Option<hdr::Hdr10> {
+        (self.dynamic_range == DynamicRange::Hdr10).then_some(hdr::Hdr10 {
+            max_luminance: match self.max_luminance {
+                0 => hdr::DEFAULT_MAX_LUMINANCE,
+                nits => nits,
+            },
+            max_cll,
+            max_fall,
+        })
+    }
+
+    /// Bitrate limits of the video encoder for a video of `duration_ms`,
+    /// fitting the target size when one is set
+    pub(crate) fn video_bitrate(
+        &self,
+        duration_ms: u64,
+        has_audio: bool,
+    ) -> Result<encoder::Bitrate> {
+        if self.target_size_bytes > 0 {
+            return size_limit::fit_bitrate(self.target_size_bytes, duration_ms, has_audio);
+        }
+        Ok(self.bitrate())
+    }
+
+    /// Whether the video encoder runs in ffmpeg: not for PNG, AV1 with
+    /// rav1e, or H.264 outside Linux, whose encoders are native
+    fn encodes_with_ffmpeg(&self) -> bool {
+        match self.codec {
+            Codec::Png => false,
+            Codec::Av1 => self.hw_accel.is_hardware() || self.av1_backend != Av1Backend::Rav1e,
+            Codec::H264 => cfg!(target_os = "linux"),
+            Codec::Vp9 | Codec::Hevc | Codec::Vp8 | Codec::Prores => true,
+        }
+    }
+
+    /// Frames from one keyframe to the next at `fps` (0 for the encoder's
+    /// own placement), a segment apart in segment output unless set
+    pub(crate) fn keyframe_interval(&self, fps: u32) -> u32 {
+        let interval_ms = match (self.keyframe_interval_ms, self.output_mode) {
+            (0, OutputMode::Segments) => self.segment_ms(),
+            (0, _) => return 0,
+            (ms, _) => ms,
+        };
+        (interval_ms as u64 * fps as u64 / 1000).clamp(1, u32::MAX as u64) as u32
+    }
+
+    /// Frames at `fps` forced to be keyframes, in order
+    pub(crate) fn forced_keyframes(&self, fps: u32) -> Vec<u64> {
+        let mut frames: Vec<u64> = self
+            .keyframe_times_ms
+            .iter()
+            .map(|&ms| (ms.saturating_mul(fps as u64) + 500) / 1000)
+            .collect();
+        frames.sort_unstable();
+        frames.dedup();
+        frames
+    }
+
+    /// Duration of each segment of segment output in milliseconds
+    fn segment_ms(&self) -> u32 {
+        match self.segment_duration_ms {
+            0 => muxer::hls::DEFAULT_SEGMENT_MS,
+            ms => ms,
+        }
+    }
+
     /// Validate the options
     pub fn validate(&self) -> Result<()> {
         if !self.container.supports_codec(self.codec) {
@@ -100,6 +1511,412 @@ impl EncodeOptions {
                 codec: self.codec,
             });
         }
+        if self.fps > MAX_FPS {
+            return Err(Error::InvalidInput(format!(
+                "Frame rate must be 1-{} fps, got {}",
+                MAX_FPS, self.fps
+            )));
+        }
+        if self.caption_max_width > 100 {
+            return Err(Error::InvalidInput(format!(
+                "Caption max width must be 0-100 percent, got {}",
+                self.caption_max_width
+            )));
+        }
+        if self.safe_area > overlay::MAX_SAFE_AREA {
+            return Err(Error::InvalidInput(format!(
+                "Safe area must be 0-{} percent, got {}",
+                overlay::MAX_SAFE_AREA,
+                self.safe_area
+            )));
+        }
+        if let Some(logo) = &self.logo {
+            logo.validate()?;
+        }
+        if self.loudness != 0.0 && !audio::LOUDNESS_RANGE.contains(&self.loudness) {
+            return Err(Error::InvalidInput(format!(
+                "Loudness must be {} to {} LUFS, got {}",
+                audio::LOUDNESS_RANGE.start(),
+                audio::LOUDNESS_RANGE.end(),
+                self.loudness
+            )));
+        }
+        if self.compression == Compression::Lossless
+            && matches!(self.codec, Codec::Vp8 | Codec::Hevc | Codec::Prores)
+        {
+            return Err(Error::InvalidInput(format!(
+                "Codec {:?} does not support lossless compression",
+                self.codec
+            )));
+        }
+        if self.transparency == Transparency::Keep
+            && !matches!(self.codec, Codec::Vp9 | Codec::Vp8 | Codec::Png)
+        {
+            return Err(Error::InvalidInput(format!(
+                "Codec {:?} does not support transparency; use VP9 or VP8 in WebM",
+                self.codec
+            )));
+        }
+        let pixel_format_supported = match self.codec {
+            Codec::Vp8 => matches!(self.pixel_format, PixelFormat::Auto | PixelFormat::Yuv420),
+            Codec::Png => self.pixel_format == PixelFormat::Auto,
+            Codec::Prores => self.pixel_format != PixelFormat::Yuv420,
+            _ => true,
+        };
+        if !pixel_format_supported {
+            return Err(Error::InvalidInput(format!(
+                "Codec {:?} does not support pixel format {:?}",
+                self.codec, self.pixel_format
+            )));
+        }
+        if self.bit_depth == BitDepth::Ten && matches!(self.codec, Codec::Vp8 | Codec::Png) {
+            return Err(Error::InvalidInput(format!(
+                "Codec {:?} does not support 10-bit encoding",
+                self.codec
+            )));
+        }
+        if self.compression == Compression::Lossless
+            && !matches!(self.pixel_format, PixelFormat::Auto | PixelFormat::Yuv444)
+        {
+            return Err(Error::InvalidInput(
+                "Lossless compression needs full chroma resolution (4:4:4)".to_string(),
+            ));
+        }
+        if self.transparency == Transparency::Keep
+            && (!matches!(self.pixel_format, PixelFormat::Auto | PixelFormat::Yuv420)
+                || self.bit_depth == BitDepth::Ten)
+        {
+            return Err(Error::InvalidInput(
+                "Transparency needs 8-bit 4:2:0 video".to_string(),
+            ));
+        }
+        if self.dynamic_range == DynamicRange::Hdr10 {
+            if !matches!(self.codec, Codec::Av1 | Codec::Vp9 | Codec::Hevc) {
+                return Err(Error::InvalidInput(format!(
+                    "Codec {:?} does not support HDR10; use AV1, VP9 or HEVC",
+                    self.codec
+                )));
+            }
+            if self.container == Container::Avif {
+                return Err(Error::InvalidInput(
+                    "AVIF output does not support HDR10".to_string(),
+                ));
+            }
+            if self.bit_depth != BitDepth::Ten {
+                return Err(Error::InvalidInput(
+                    "HDR10 needs 10-bit encoding".to_string(),
+                ));
+            }
+        }
+        let color_set = self.color_primaries != ColorPrimaries::Auto
+            || self.color_transfer != ColorTransfer::Auto
+            || self.color_matrix != ColorMatrix::Auto
+            || self.color_range != ColorRange::Auto;
+        if color_set && self.dynamic_range == DynamicRange::Hdr10 {
+            return Err(Error::InvalidInput(
+                "HDR10 sets its own color primaries, transfer, matrix and range".to_string(),
+            ));
+        }
+        if color_set && self.codec == Codec::Png {
+            return Err(Error::InvalidInput(
+                "PNG frames are RGB and take no color space options".to_string(),
+            ));
+        }
+        if self.color_range == ColorRange::Full && self.codec == Codec::Prores {
+            return Err(Error::InvalidInput(
+                "ProRes is always limited range".to_string(),
+            ));
+        }
+        if self.deterministic() && self.hw_accel.is_hardware() {
+            return Err(Error::InvalidInput(format!(
+                "Deterministic output needs a software encoder, got {:?}",
+                self.hw_accel
+            )));
+        }
+        if self.deterministic() && self.time_budget_ms > 0 {
+            return Err(Error::InvalidInput(
+                "Deterministic output cannot have a time budget, as it depends on the speed of the host"
+                    .to_string(),
+            ));
+        }
+        if self.has_chapters() {
+            if !self.container.supports_chapters()
+                || self.output_mode == OutputMode::Segments
+                || (self.container == Container::Mp4 && self.mp4_layout == Mp4Layout::Fragmented)
+            {
+                return Err(Error::InvalidInput(
+                    "Chapters need single-file MP4 (not fragmented), WebM or MKV output"
+                        .to_string(),
+                ));
+            }
+            if self.chapter_mode == ChapterMode::Slides && !self.chapters.is_empty() {
+                return Err(Error::InvalidInput(
+                    "Chapters cannot be listed when they are made from the slides".to_string(),
+                ));
+            }
+            self.check_chapters(&self.chapters)?;
+        }
+        if self.hw_accel.is_hardware() {
+            if !matches!(self.codec, Codec::H264 | Codec::Hevc | Codec::Av1) {
+                return Err(Error::InvalidInput(format!(
+                    "Codec {:?} has no hardware encoder; use H.264, HEVC or AV1",
+                    self.codec
+                )));
+            }
+            if self.codec == Codec::Av1
+                && !matches!(self.hw_accel, HwAccel::Nvenc | HwAccel::Qsv | HwAccel::Amf)
+            {
+                return Err(Error::InvalidInput(format!(
+                    "AV1 has no {:?} encoder; use NVENC, Quick Sync or AMF",
+                    self.hw_accel
+                )));
+            }
+            if self.compression == Compression::Lossless
+                || self.dynamic_range == DynamicRange::Hdr10
+                || !matches!(self.pixel_format, PixelFormat::Auto | PixelFormat::Yuv420)
+                || (self.codec == Codec::H264 && self.bit_depth == BitDepth::Ten)
+            {
+                return Err(Error::InvalidInput(
+                    "Hardware encoders only encode lossy 4:2:0 SDR video (8-bit for H.264)"
+                        .to_string(),
+                ));
+            }
+        }
+        if self.output_check == OutputCheck::Probe
+            && (self.output_mode == OutputMode::Segments
+                || matches!(self.container, Container::Hls | Container::Dash))
+        {
+            return Err(Error::InvalidInput(
+                "Output checks need a single output file".to_string(),
+            ));
+        }
+        if self.max_luminance != 0 && !hdr::MAX_LUMINANCE_RANGE.contains(&self.max_luminance) {
+            return Err(Error::InvalidInput(format!(
+                "Max luminance must be {}-{} cd/m², got {}",
+                hdr::MAX_LUMINANCE_RANGE.start(),
+                hdr::MAX_LUMINANCE_RANGE.end(),
+                self.max_luminance
+            )));
+        }
+        if self.codec != Codec::Av1
+            && (self.av1_backend != Av1Backend::Rav1e || self.av1_preset != 0)
+        {
+            return Err(Error::InvalidInput(
+                "AV1 backends and presets need the AV1 codec".to_string(),
+            ));
+        }
+        if self.codec == Codec::Av1 {
+            if self.av1_preset > self.av1_backend.max_preset() {
+                return Err(Error::InvalidInput(format!(
+                    "Preset of {:?} must be 1-{}, got {}",
+                    self.av1_backend,
+                    self.av1_backend.max_preset(),
+                    self.av1_preset
+                )));
+            }
+            if self.av1_preset != 0 && self.hw_accel.is_hardware() {
+                return Err(Error::InvalidInput(
+                    "Hardware AV1 encoders take no preset".to_string(),
+                ));
+            }
+            if self.av1_backend != Av1Backend::Rav1e && self.dynamic_range == DynamicRange::Hdr10 {
+                return Err(Error::InvalidInput(
+                    "HDR10 AV1 needs the rav1e backend".to_string(),
+                ));
+            }
+            if self.av1_backend == Av1Backend::SvtAv1
+                && (self.compression == Compression::Lossless
+                    || !matches!(self.pixel_format, PixelFormat::Auto | PixelFormat::Yuv420))
+            {
+                return Err(Error::InvalidInput(
+                    "SVT-AV1 only encodes lossy 4:2:0 video".to_string(),
+                ));
+            }
+        }
+        let x264_set = self.h264_preset != H264Preset::Auto || self.h264_tune != H264Tune::None;
+        if self.codec != Codec::H264
+            && (self.h264_profile != H264Profile::Auto || self.h264_level != 0 || x264_set)
+        {
+            return Err(Error::InvalidInput(
+                "H.264 profiles, levels, presets and tunes need the H.264 codec".to_string(),
+            ));
+        }
+        if self.codec == Codec::H264 {
+            if self.h264_level != 0 && !H264_LEVELS.contains(&self.h264_level) {
+                return Err(Error::InvalidInput(format!(
+                    "H.264 level must be one of {:?} (31 for level 3.1), got {}",
+                    H264_LEVELS, self.h264_level
+                )));
+            }
+            if self.h264_profile != H264Profile::Auto
+                && (self.compression == Compression::Lossless
+                    || !matches!(self.pixel_format, PixelFormat::Auto | PixelFormat::Yuv420)
+                    || self.bit_depth == BitDepth::Ten)
+            {
+                return Err(Error::InvalidInput(
+                    "H.264 profiles only encode lossy 8-bit 4:2:0 video".to_string(),
+                ));
+            }
+            if x264_set && self.hw_accel.is_hardware() {
+                return Err(Error::InvalidInput(
+                    "H.264 presets and tunes need the libx264 software encoder".to_string(),
+                ));
+            }
+        }
+        if !self.ffmpeg_args.is_empty() && !self.encodes_with_ffmpeg() {
+            return Err(Error::InvalidInput(format!(
+                "Codec {:?} is not encoded by ffmpeg here, so it takes no ffmpeg arguments",
+                self.codec
+            )));
+        }
+        if self.passes == Passes::Two && !matches!(self.codec, Codec::Vp9 | Codec::Vp8) {
+            return Err(Error::InvalidInput(format!(
+                "Codec {:?} does not support two-pass encoding; use VP9 or VP8",
+                self.codec
+            )));
+        }
+        let bitrate = self.bitrate();
+        if bitrate.is_set() && self.target_size_bytes > 0 {
+            return Err(Error::InvalidInput(
+                "A target size sets the bitrate; do not set bitrate limits with it".to_string(),
+            ));
+        }
+        if (bitrate.is_set() || self.target_size_bytes > 0)
+            && matches!(self.codec, Codec::Png | Codec::Prores)
+        {
+            return Err(Error::InvalidInput(format!(
+                "Codec {:?} does not support bitrate limits or target sizes",
+                self.codec
+            )));
+        }
+        if (bitrate.is_set() || self.target_size_bytes > 0)
+            && self.compression == Compression::Lossless
+        {
+            return Err(Error::InvalidInput(
+                "Bitrate limits and target sizes do not apply to lossless compression".to_string(),
+            ));
+        }
+        if bitrate.max_kbps > 0 && bitrate.target_kbps > bitrate.max_kbps {
+            return Err(Error::InvalidInput(format!(
+                "Bitrate of {} kbit/s exceeds the maximum bitrate of {} kbit/s",
+                bitrate.target_kbps, bitrate.max_kbps
+            )));
+        }
+        if bitrate.buffer_kbits > 0 && bitrate.max_kbps == 0 {
+            return Err(Error::InvalidInput(
+                "Buffer size needs a maximum bitrate".to_string(),
+            ));
+        }
+        if self.container == Container::Hls
+            && self.codec == Codec::Av1
+            && self.hls_segment == HlsSegment::Ts
+        {
+            return Err(Error::InvalidInput(
+                "AV1 in HLS needs fMP4 segments".to_string(),
+            ));
+        }
+        if self.output_mode == OutputMode::Segments {
+            if !matches!(
+                self.container,
+                Container::Mp4 | Container::Mov | Container::WebM | Container::Mkv
+            ) {
+                return Err(Error::InvalidInput(format!(
+                    "Container {:?} does not support segment output; use MP4, MOV, WebM or MKV",
+                    self.container
+                )));
+            }
+            if self.idle_frames == IdleFrames::Merge {
+                return Err(Error::InvalidInput(
+                    "Segment output needs every frame encoded; idle frames cannot be merged"
+                        .to_string(),
+                ));
+            }
+            if self.background_music.is_some() {
+                return Err(Error::InvalidInput(
+                    "Segment output does not support audio".to_string(),
+                ));
+            }
+            if self
+                .segment_ms()
+                .checked_rem(self.keyframe_interval_ms)
+                .is_some_and(|rest| rest != 0)
+            {
+                return Err(Error::InvalidInput(format!(
+                    "Keyframe interval of {} ms does not divide the segment duration of {} ms",
+                    self.keyframe_interval_ms,
+                    self.segment_ms()
+                )));
+            }
+        }
+        if (self.keyframe_interval_ms > 0 || !self.keyframe_times_ms.is_empty())
+            && self.idle_frames == IdleFrames::Merge
+        {
+            return Err(Error::InvalidInput(
+                "Keyframe placement needs every frame encoded; idle frames cannot be merged"
+                    .to_string(),
+            ));
+        }
+        if self.enhance > 100 {
+            return Err(Error::InvalidInput(format!(
+                "Enhance strength must be 0-100 percent, got {}",
+                self.enhance
+            )));
+        }
+        audio::check_gain(self.audio_gain)?;
+        if self.duck_threshold != 0.0 && !audio::DUCK_THRESHOLD_RANGE.contains(&self.duck_threshold)
+        {
+            return Err(Error::InvalidInput(format!(
+                "Ducking threshold must be {} to {} dBFS, got {}",
+                audio::DUCK_THRESHOLD_RANGE.start(),
+                audio::DUCK_THRESHOLD_RANGE.end(),
+                self.duck_threshold
+            )));
+        }
+        if self.duck_amount != 0.0 && !audio::DUCK_AMOUNT_RANGE.contains(&self.duck_amount) {
+            return Err(Error::InvalidInput(format!(
+                "Ducking amount must be {} to {} dB, got {}",
+                audio::DUCK_AMOUNT_RANGE.start(),
+                audio::DUCK_AMOUNT_RANGE.end(),
+                self.duck_amount
+            )));
+        }
+        if self.background_music.is_some() && !self.container.supports_audio() {
+            return Err(Error::InvalidInput(format!(
+                "Container {:?} does not support audio",
+                self.container
+            )));
+        }
+        if let Some(dir) = &self.temp_dir {
+            if !std::path::Path::new(dir).is_dir() {
+                return Err(Error::InvalidInput(format!(
+                    "Temporary directory not found: {}",
+                    dir
+                )));
+            }
+        }
+        Ok(())
+    }
+}
+
+impl Logo {
+    /// Validate the logo settings
+    pub fn validate(&self) -> Result<()> {
+        if self.width > 100 {
+            return Err(Error::InvalidInput(format!(
+                "Logo width must be 0-100 percent, got {}",
+                self.width
+            )));
+        }
+        for range in &self.ranges {
+            // Ends measured from different sides depend on the video length
+            let same_side = (range.start_ms >= 0) == (range.end_ms > 0);
+            if same_side && range.start_ms >= range.end_ms {
+                return Err(Error::InvalidInput(format!(
+                    "Logo range must end after it starts: {}..{}",
+                    range.start_ms, range.end_ms
+                )));
+            }
+        }
         Ok(())
     }
 }
@@ -120,5 +1937,10 @@ pub fn available(codec: Codec, ffmpeg_path: Option<&str>) -> Result<()> {
             }
         }
         Codec::H264 => encoder::h264::check_available(ffmpeg_path),
+        Codec::Png => Ok(()),
+        Codec::Vp9 => encoder::vp9::check_available(ffmpeg_path),
+        Codec::Hevc => encoder::hevc::check_available(ffmpeg_path),
+        Codec::Vp8 => encoder::vp8::check_available(ffmpeg_path),
+        Codec::Prores => encoder::prores::check_available(ffmpeg_path),
     }
 }
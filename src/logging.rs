@@ -0,0 +1,145 @@
+//! Structured events of an encode
+//!
+//! A `LogHandler` in the options receives an event when a stage of the job
+//! starts, when an encoder is chosen, when the job falls back to the software
+//! encoder and when something is left out, each with key-value attributes so
+//! services can forward them to their structured logger.
+
+use std::fmt;
+use std::sync::Arc;
+
+/// Severity of a log event
+#[derive(Debug, Clone, Copy, PartialEq, Eq, PartialOrd, Ord)]
+#[repr(C)]
+pub enum LogLevel {
+    /// Details of the progress of a job
+    Debug = 0,
+    /// Stages and choices of a job
+    Info = 1,
+    /// Something went wrong, but the job carries on
+    Warn = 2,
+}
+
+/// Value of an attribute of a log event
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum LogValue {
+    /// Text, such as a path or a name
+    Text(String),
+    /// Number, such as a count or milliseconds; 1 or 0 for flags
+    Int(i64),
+}
+
+impl fmt::Display for LogValue {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            LogValue::Text(text) => f.write_str(text),
+            LogValue::Int(n) => write!(f, "{}", n),
+        }
+    }
+}
+
+impl From<&str> for LogValue {
+    fn from(text: &str) -> Self {
+        LogValue::Text(text.to_string())
+    }
+}
+
+impl From<String> for LogValue {
+    fn from(text: String) -> Self {
+        LogValue::Text(text)
+    }
+}
+
+impl From<bool> for LogValue {
+    fn from(flag: bool) -> Self {
+        LogValue::Int(flag as i64)
+    }
+}
+
+impl From<u32> for LogValue {
+    fn from(n: u32) -> Self {
+        LogValue::Int(n as i64)
+    }
+}
+
+impl From<u64> for LogValue {
+    fn from(n: u64) -> Self {
+        LogValue::Int(n.min(i64::MAX as u64) as i64)
+    }
+}
+
+impl From<usize> for LogValue {
+    fn from(n: usize) -> Self {
+        LogValue::from(n as u64)
+    }
+}
+
+/// Event of a job reported to a [`LogHandler`]
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct LogEvent {
+    /// Severity of the event
+    pub level: LogLevel,
+    /// What happened, such as "stage started"; fixed for each kind of event
+    pub message: &'static str,
+    /// Details of the event, such as ("stage", "encode")
+    pub attrs: Vec<(&'static str, LogValue)>,
+}
+
+/// Called with the events of a job, possibly from another thread than the
+/// one that started it
+#[derive(Clone)]
+pub struct LogHandler(Arc<dyn Fn(&LogEvent) + Send + Sync>);
+
+impl LogHandler {
+    /// Wrap a function called with each event
+    pub fn new(handler: impl Fn(&LogEvent) + Send + Sync + 'static) -> Self {
+        Self(Arc::new(handler))
+    }
+
+    /// Report an event
+    pub(crate) fn log(
+        &self,
+        level: LogLevel,
+        message: &'static str,
+        attrs: Vec<(&'static str, LogValue)>,
+    ) {
+        (self.0)(&LogEvent {
+            level,
+            message,
+            attrs,
+        })
+    }
+}
+
+impl fmt::Debug for LogHandler {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str("LogHandler")
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::sync::Mutex;
+
+    #[test]
+    fn test_log_handler() {
+        let events = Arc::new(Mutex::new(Vec::new()));
+        let handler = {
+            let events = events.clone();
+            LogHandler::new(move |event| events.lock().unwrap().push(event.clone()))
+        };
+        handler.log(
+            LogLevel::Info,
+            "stage started",
+            vec![("stage", "encode".into()), ("frames", 120u64.into())],
+        );
+
+        let events = events.lock().unwrap();
+        assert_eq!(events.len(), 1);
+        assert_eq!(events[0].message, "stage started");
+        assert_eq!(events[0].attrs[0], ("stage", LogValue::from("encode")));
+        assert_eq!(events[0].attrs[1].1.to_string(), "120");
+        assert!(LogLevel::Warn > LogLevel::Info);
+    }
+}
@@ -0,0 +1,346 @@
+//! Logo overlay
+//!
+//! A logo is scaled relative to the frame width, placed by [`Anchor`] inside
+//! the safe area and drawn over every frame in its visible ranges. It fades
+//! in and out at the edges of each range. Animated GIF, PNG and WebP logos
+//! play in a loop from the start of the video.
+
+use crate::hdr;
+use crate::image_loader::{self, LoadedImage};
+use crate::overlay::SafeArea;
+use crate::{Anchor, Error, Logo, Result, TimeRange};
+use image::codecs::gif::GifDecoder;
+use image::codecs::png::PngDecoder;
+use image::codecs::webp::WebPDecoder;
+use image::{AnimationDecoder, DynamicImage, ImageFormat};
+use std::fs::File;
+use std::io::BufReader;
+use std::path::Path;
+
+/// Default logo width in percent of the frame width
+const DEFAULT_LOGO_WIDTH: u32 = 15;
+
+/// A logo frame with the time it ends within one animation cycle
+struct LogoFrame {
+    image: LoadedImage,
+    end_ms: u64,
+}
+
+/// A logo prepared for drawing on frames of a given size
+pub(crate) struct LogoOverlay {
+    frames: Vec<LogoFrame>,
+    /// Length of one animation cycle in milliseconds
+    cycle_ms: u64,
+    x: i64,
+    y: i64,
+    fade_ms: u64,
+    /// Visible ranges resolved against the video length
+    ranges: Vec<(u64, u64)>,
+}
+
+impl LogoOverlay {
+    /// Load and scale a logo for frames of the given size
+    pub fn new(
+        logo: &Logo,
+        frame_width: u32,
+        frame_height: u32,
+        safe_area: u32,
+        duration_ms: u64,
+    ) -> Result<Self> {
+        let frames = load_frames(Path::new(&logo.path))?;
+
+        // Scale every frame to the logo width, keeping the aspect ratio
+        let percent = match logo.width {
+            0 => DEFAULT_LOGO_WIDTH,
+            w => w.min(100),
+        };
+        let first = &frames[0].0;
+        let width = (frame_width * percent / 100).max(1);
+        let height = ((first.height as u64 * width as u64 / first.width.max(1) as u64) as u32)
+            .clamp(1, frame_height.max(1));
+
+        let mut end_ms = 0;
+        let frames: Vec<LogoFrame> = frames
+            .into_iter()
+            .map(|(image, delay_ms)| {
+                end_ms += delay_ms;
+                LogoFrame {
+                    image: image.resize(width, height),
+                    end_ms,
+                }
+            })
+            .collect();
+
+        let anchor = match logo.anchor {
+            Anchor::Auto => Anchor::TopLeft,
+            a => a,
+        };
+        let safe = SafeArea::new(frame_width, frame_height, safe_area);
+        let (x, y) = safe.place(anchor, width as f32, height as f32);
+
+        let ranges = if logo.ranges.is_empty() {
+            vec![(0, duration_ms)]
+        } else {
+            logo.ranges
+                .iter()
+                .map(|r| resolve_range(r, duration_ms))
+                .collect()
+        };
+
+        Ok(Self {
+            frames,
+            cycle_ms: end_ms,
+            x: x.round() as i64,
+            y: y.round() as i64,
+            fade_ms: logo.fade_ms as u64,
+            ranges,
+        })
+    }
+
+    /// Convert the logo to PQ-coded BT.2020 for drawing on HDR10 frames
+    pub fn convert_to_pq(&mut self) {
+        for frame in &mut self.frames {
+            hdr::sdr_to_pq(&mut frame.image.data);
+        }
+    }
+
+    /// Opacity of the logo at a time (0.0-1.0)
+    pub fn opacity(&self, time_ms: u64) -> f32 {
+        self.ranges
+            .iter()
+            .filter(|(start, end)| (*start..*end).contains(&time_ms))
+            .map(|(start, end)| {
+                let fade = self.fade_ms.min((end - start) / 2);
+                if fade == 0 {
+                    return 1.0;
+                }
+                let fade_in = (time_ms - start) as f32 / fade as f32;
+                let fade_out = (end - time_ms) as f32 / fade as f32;
+                fade_in.min(fade_out).min(1.0)
+            })
+            .fold(0.0, f32::max)
+    }
+
+    /// Logo image shown at a time
+    fn frame_at(&self, time_ms: u64) -> &LoadedImage {
+        if self.cycle_ms == 0 {
+            return &self.frames[0].image;
+        }
+        let t = time_ms % self.cycle_ms;
+        let frame = self
+            .frames
+            .iter()
+            .find(|f| t < f.end_ms)
+            .unwrap_or(&self.frames[self.frames.len() - 1]);
+        &frame.image
+    }
+
+    /// Draw the logo on RGBA frame data of the given width
+    pub fn draw(&self, data: &mut [u8], width: u32, time_ms: u64) {
+        let opacity = self.opacity(time_ms);
+        if opacity <= 0.0 {
+            return;
+        }
+
+        let logo = self.frame_at(time_ms);
+        let height = (data.len() / 4 / width.max(1) as usize) as i64;
+
+        for ly in 0..logo.height as i64 {
+            let y = self.y + ly;
+            if y < 0 || y >= height {
+                continue;
+            }
+            for lx in 0..logo.width as i64 {
+                let x = self.x + lx;
+                if x < 0 || x >= width as i64 {
+                    continue;
+                }
+
+                let src = ((ly * logo.width as i64 + lx) * 4) as usize;
+                let alpha = logo.data[src + 3] as f32 / 255.0 * opacity;
+                if alpha <= 0.0 {
+                    continue;
+                }
+
+                let dst = ((y * width as i64 + x) * 4) as usize;
+                for c in 0..3 {
+                    let blended =
+                        data[dst + c] as f32 * (1.0 - alpha) + logo.data[src + c] as f32 * alpha;
+                    data[dst + c] = blended.round() as u8;
+                }
+                let dst_alpha = data[dst + 3] as f32 / 255.0;
+                data[dst + 3] = ((alpha + dst_alpha * (1.0 - alpha)) * 255.0).round() as u8;
+            }
+        }
+    }
+}
+
+/// Resolve a time range against the video length
+fn resolve_range(range: &TimeRange, duration_ms: u64) -> (u64, u64) {
+    let resolve = |ms: i64| {
+        if ms < 0 {
+            duration_ms.saturating_sub(ms.unsigned_abs())
+        } else {
+            (ms as u64).min(duration_ms)
+        }
+    };
+    let start = resolve(range.start_ms);
+    let end = match range.end_ms {
+        0 => duration_ms,
+        ms => resolve(ms),
+    };
+    (start, end.max(start))
+}
+
+/// Load the frames of a logo with their display times in milliseconds
+///
+/// Still images have a single frame with a display time of 0.
+fn load_frames(path: &Path) -> Result<Vec<(LoadedImage, u64)>> {
+    if !path.is_file() {
+        return Err(Error::InvalidInput(format!(
+            "Logo not found: {}",
+            path.display()
+        )));
+    }
+
+    let open = || -> Result<BufReader<File>> { Ok(BufReader::new(File::open(path)?)) };
+    let frames = match ImageFormat::from_path(path).ok() {
+        Some(ImageFormat::Gif) => GifDecoder::new(open()?)?.into_frames().collect_frames()?,
+        Some(ImageFormat::Png) => {
+            let decoder = PngDecoder::new(open()?)?;
+            if decoder.is_apng()? {
+                decoder.apng()?.into_frames().collect_frames()?
+            } else {
+                Vec::new()
+            }
+        }
+        Some(ImageFormat::WebP) => {
+            let decoder = WebPDecoder::new(open()?)?;
+            if decoder.has_animation() {
+                decoder.into_frames().collect_frames()?
+            } else {
+                Vec::new()
+            }
+        }
+        _ => Vec::new(),
+    };
+
+    if frames.len() < 2 {
+        return Ok(vec![(LoadedImage::from_path(path)?, 0)]);
+    }
+
+    Ok(frames
+        .into_iter()
+        .map(|frame| {
+            let delay_ms = image_loader::frame_delay_ms(&frame);
+            let image =
+                LoadedImage::from_dynamic_image(DynamicImage::ImageRgba8(frame.into_buffer()));
+            (image, delay_ms)
+        })
+        .collect())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn overlay(fade_ms: u64, ranges: Vec<(u64, u64)>) -> LogoOverlay {
+        let image = LoadedImage {
+            width: 2,
+            height: 2,
+            data: vec![255; 16],
+        };
+        LogoOverlay {
+            frames: vec![LogoFrame { image, end_ms: 0 }],
+            cycle_ms: 0,
+            x: 1,
+            y: 1,
+            fade_ms,
+            ranges,
+        }
+    }
+
+    #[test]
+    fn test_resolve_range() {
+        let range = |start_ms, end_ms| TimeRange { start_ms, end_ms };
+        assert_eq!(resolve_range(&range(0, 0), 10000), (0, 10000));
+        assert_eq!(resolve_range(&range(2000, -3000), 10000), (2000, 7000));
+        assert_eq!(resolve_range(&range(-4000, 0), 10000), (6000, 10000));
+        assert_eq!(resolve_range(&range(1000, 20000), 10000), (1000, 10000));
+        assert_eq!(resolve_range(&range(8000, -5000), 10000), (8000, 8000));
+    }
+
+    #[test]
+    fn test_validate_logo() {
+        let logo = |width, start_ms, end_ms| Logo {
+            width,
+            ranges: vec![TimeRange { start_ms, end_ms }],
+            ..Default::default()
+        };
+        assert!(logo(20, 1000, 0).validate().is_ok());
+        assert!(logo(20, 1000, -1000).validate().is_ok());
+        assert!(logo(20, -3000, -1000).validate().is_ok());
+        assert!(logo(120, 0, 0).validate().is_err());
+        assert!(logo(20, 2000, 1000).validate().is_err());
+        assert!(logo(20, -1000, -3000).validate().is_err());
+    }
+
+    #[test]
+    fn test_opacity_fades() {
+        let logo = overlay(1000, vec![(2000, 8000)]);
+        assert_eq!(logo.opacity(1000), 0.0);
+        assert_eq!(logo.opacity(2000), 0.0);
+        assert_eq!(logo.opacity(2500), 0.5);
+        assert_eq!(logo.opacity(5000), 1.0);
+        assert_eq!(logo.opacity(7500), 0.5);
+        assert_eq!(logo.opacity(8000), 0.0);
+    }
+
+    #[test]
+    fn test_opacity_short_range() {
+        // The fade is limited to half of the range
+        let logo = overlay(1000, vec![(0, 1000), (3000, 4000)]);
+        assert_eq!(logo.opacity(500), 1.0);
+        assert_eq!(logo.opacity(250), 0.5);
+        assert_eq!(logo.opacity(2000), 0.0);
+        assert_eq!(logo.opacity(3500), 1.0);
+    }
+
+    #[test]
+    fn test_frame_at_loops() {
+        let frame = |value: u8, end_ms| LogoFrame {
+            image: LoadedImage {
+                width: 1,
+                height: 1,
+                data: vec![value; 4],
+            },
+            end_ms,
+        };
+        let mut logo = overlay(0, vec![(0, 10000)]);
+        logo.frames = vec![frame(1, 100), frame(2, 300)];
+        logo.cycle_ms = 300;
+
+        assert_eq!(logo.frame_at(0).data[0], 1);
+        assert_eq!(logo.frame_at(150).data[0], 2);
+        assert_eq!(logo.frame_at(350).data[0], 1);
+    }
+
+    #[test]
+    fn test_draw_blends_inside_bounds() {
+        let logo = overlay(0, vec![(0, 1000)]);
+        let mut data = vec![0u8; 3 * 3 * 4];
+
+        logo.draw(&mut data, 3, 500);
+
+        // The 2x2 logo covers the bottom-right of the 3x3 frame
+        for (i, pixel) in data.chunks(4).enumerate() {
+            let covered = i % 3 >= 1 && i / 3 >= 1;
+            assert_eq!(pixel[0] == 255, covered, "pixel {}", i);
+        }
+
+        // Nothing is drawn outside the visible range
+        let mut data = vec![0u8; 3 * 3 * 4];
+        logo.draw(&mut data, 3, 1500);
+        assert!(data.iter().all(|&v| v == 0));
+    }
+}
@@ -0,0 +1,143 @@
+//! APNG (animated PNG) container muxer
+
+use super::{Muxer, MuxerConfig};
+use crate::encoder::Packet;
+use crate::{Codec, Error, Result};
+use std::fs::File;
+use std::io::{BufWriter, Write};
+use std::path::{Path, PathBuf};
+
+/// PNG file signature
+const PNG_SIGNATURE: [u8; 8] = [0x89, b'P', b'N', b'G', 0x0D, 0x0A, 0x1A, 0x0A];
+
+/// Largest frame delay numerator allowed by `fcTL`
+const MAX_DELAY_FRAMES: u32 = u16::MAX as u32;
+
+/// A frame with the number of video frames it stays on screen
+struct ApngFrame {
+    data: Vec<u8>,
+    frames: u32,
+}
+
+/// APNG muxer (PNG codec only)
+///
+/// Frames are buffered because the frame count must be written before the
+/// first frame. Identical consecutive frames, such as a slide held for
+/// several seconds, are merged into one frame with a longer delay.
+pub struct ApngMuxer {
+    output_path: PathBuf,
+    config: MuxerConfig,
+    frames: Vec<ApngFrame>,
+}
+
+impl ApngMuxer {
+    pub fn new<P: AsRef<Path>>(output_path: P, config: MuxerConfig) -> Result<Self> {
+        if config.codec != Codec::Png {
+            return Err(Error::Mux(
+                "APNG container only supports PNG codec".to_string(),
+            ));
+        }
+
+        // Create the file now so an unwritable path fails early
+        File::create(output_path.as_ref()).map_err(Error::Io)?;
+
+        Ok(Self {
+            output_path: output_path.as_ref().to_path_buf(),
+            config,
+            frames: Vec::new(),
+        })
+    }
+
+    fn write_chunk<W: Write>(writer: &mut W, kind: &[u8; 4], body: &[u8]) -> Result<()> {
+        let mut crc = crc32fast::Hasher::new();
+        crc.update(kind);
+        crc.update(body);
+
+        writer
+            .write_all(&(body.len() as u32).to_be_bytes())
+            .map_err(Error::Io)?;
+        writer.write_all(kind).map_err(Error::Io)?;
+        writer.write_all(body).map_err(Error::Io)?;
+        writer
+            .write_all(&crc.finalize().to_be_bytes())
+            .map_err(Error::Io)?;
+        Ok(())
+    }
+
+    /// Frame control chunk body
+    fn fctl(&self, sequence: u32, delay_frames: u32) -> Vec<u8> {
+        let mut body = Vec::with_capacity(26);
+        body.extend_from_slice(&sequence.to_be_bytes());
+        body.extend_from_slice(&self.config.width.to_be_bytes());
+        body.extend_from_slice(&self.config.height.to_be_bytes());
+        body.extend_from_slice(&0u32.to_be_bytes()); // x_offset
+        body.extend_from_slice(&0u32.to_be_bytes()); // y_offset
+        body.extend_from_slice(&(delay_frames as u16).to_be_bytes()); // delay_num
+        body.extend_from_slice(&(self.config.fps as u16).to_be_bytes()); // delay_den
+        body.push(0); // dispose_op: none
+        body.push(0); // blend_op: source, so transparent pixels replace the previous frame
+        body
+    }
+}
+
+impl Muxer for ApngMuxer {
+    fn write_packet(&mut self, packet: &Packet) -> Result<()> {
+        if let Some(last) = self.frames.last_mut() {
+            if last.data == packet.data && last.frames + packet.duration <= MAX_DELAY_FRAMES {
+                last.frames += packet.duration;
+                return Ok(());
+            }
+        }
+
+        self.frames.push(ApngFrame {
+            data: packet.data.clone(),
+            frames: packet.duration.clamp(1, MAX_DELAY_FRAMES),
+        });
+        Ok(())
+    }
+
+    fn finalize(self: Box<Self>) -> Result<()> {
+        if self.frames.is_empty() {
+            return Err(Error::Mux("No frames to write".to_string()));
+        }
+
+        let file = File::create(&self.output_path).map_err(Error::Io)?;
+        let mut writer = BufWriter::new(file);
+
+        writer.write_all(&PNG_SIGNATURE).map_err(Error::Io)?;
+
+        // 8-bit RGBA, deflate, adaptive filtering, no interlace
+        let mut ihdr = Vec::with_capacity(13);
+        ihdr.extend_from_slice(&self.config.width.to_be_bytes());
+        ihdr.extend_from_slice(&self.config.height.to_be_bytes());
+        ihdr.extend_from_slice(&[8, 6, 0, 0, 0]);
+        Self::write_chunk(&mut writer, b"IHDR", &ihdr)?;
+
+        // Animation control: frame count, loop forever
+        let mut actl = (self.frames.len() as u32).to_be_bytes().to_vec();
+        actl.extend_from_slice(&0u32.to_be_bytes());
+        Self::write_chunk(&mut writer, b"acTL", &actl)?;
+
+        let mut sequence = 0u32;
+        for (i, frame) in self.frames.iter().enumerate() {
+            Self::write_chunk(&mut writer, b"fcTL", &self.fctl(sequence, frame.frames))?;
+            sequence += 1;
+
+            if i == 0 {
+                // The first frame doubles as the default image
+                Self::write_chunk(&mut writer, b"IDAT", &frame.data)?;
+            } else {
+                let mut fdat = Vec::with_capacity(frame.data.len() + 4);
+                fdat.extend_from_slice(&sequence.to_be_bytes());
+                fdat.extend_from_slice(&frame.data);
+                Self::write_chunk(&mut writer, b"fdAT", &fdat)?;
+                sequence += 1;
+            }
+        }
+
+        Self::write_chunk(&mut writer, b"IEND", &[])?;
+        writer.flush().map_err(Error::Io)?;
+
+        Ok(())
+    }
+}
@@ -0,0 +1,181 @@
+//! H.264 access units for MOV, MKV and HLS files
+//!
+//! H.264 encoders deliver Annex B streams or bare NAL units, with the
+//! parameter sets in the stream on some platforms. QuickTime and Matroska
+//! store length-prefixed access units with the parameter sets in an `avcC`
+//! record of the track header instead.
+
+use crate::encoder::hevc::split_annex_b;
+use crate::encoder::Packet;
+use crate::{Error, Result};
+
+/// H.264 NAL unit type of IDR slices
+const NAL_IDR: u8 = 5;
+/// H.264 NAL unit type of sequence parameter sets
+const NAL_SPS: u8 = 7;
+/// H.264 NAL unit type of picture parameter sets
+const NAL_PPS: u8 = 8;
+/// H.264 NAL unit type of access unit delimiters
+const NAL_AUD: u8 = 9;
+
+/// H.264 stream being converted to length-prefixed access units
+pub(super) struct AvcStream {
+    sps: Option<Vec<u8>>,
+    pps: Option<Vec<u8>>,
+    /// Length-prefixed NAL units waiting for the next picture
+    pending: Vec<u8>,
+}
+
+impl AvcStream {
+    /// Start a stream with the parameter sets given by the encoder, if any
+    pub fn new(sps: Option<Vec<u8>>, pps: Option<Vec<u8>>) -> Self {
+        Self {
+            sps,
+            pps,
+            pending: Vec::new(),
+        }
+    }
+
+    /// Convert a packet to a length-prefixed access unit
+    ///
+    /// Parameter sets are kept for the track header, and packets without a
+    /// picture are held until the next picture, returning None.
+    pub fn access_unit(&mut self, packet: &Packet) -> Option<Packet> {
+        let data = &packet.data;
+        let nals = if data.starts_with(&[0, 0, 1]) || data.starts_with(&[0, 0, 0, 1]) {
+            split_annex_b(data)
+        } else {
+            vec![&data[..]]
+        };
+
+        let mut has_picture = false;
+        let mut is_keyframe = packet.is_keyframe;
+        for nal in nals {
+            let kind = nal.first().map(|b| b & 0x1F).unwrap_or(0);
+            match kind {
+                NAL_SPS => {
+                    self.sps.get_or_insert_with(|| nal.to_vec());
+                    continue;
+                }
+                NAL_PPS => {
+                    self.pps.get_or_insert_with(|| nal.to_vec());
+                    continue;
+                }
+                NAL_AUD => continue,
+                1..=NAL_IDR => {
+                    has_picture = true;
+                    is_keyframe |= kind == NAL_IDR;
+                }
+                _ => {}
+            }
+            self.pending
+                .extend_from_slice(&(nal.len() as u32).to_be_bytes());
+            self.pending.extend_from_slice(nal);
+        }
+
+        has_picture.then(|| Packet {
+            data: std::mem::take(&mut self.pending),
+            pts: packet.pts,
+            dts: packet.dts,
+            is_keyframe,
+            duration: packet.duration,
+            alpha: None,
+        })
+    }
+
+    /// Parameter sets (SPS and PPS) found so far
+    pub fn parameter_sets(&self) -> Vec<&[u8]> {
+        self.sps.iter().chain(&self.pps).map(|p| &p[..]).collect()
+    }
+
+    /// AVC decoder configuration record (`avcC`) with the parameter sets
+    pub fn config_record(&self) -> Result<Vec<u8>> {
+        let (sps, pps) = match (&self.sps, &self.pps) {
+            (Some(sps), Some(pps)) if sps.len() >= 4 => (sps, pps),
+            _ => return Err(Error::Mux("H.264 parameter sets are missing".to_string())),
+        };
+
+        // Version, profile, compatibility and level from the SPS, 4-byte
+        // NAL unit lengths, one SPS and one PPS
+        let mut record = vec![1, sps[1], sps[2], sps[3], 0xFF, 0xE1];
+        record.extend_from_slice(&(sps.len() as u16).to_be_bytes());
+        record.extend_from_slice(sps);
+        record.push(1);
+        record.extend_from_slice(&(pps.len() as u16).to_be_bytes());
+        record.extend_from_slice(pps);
+        Ok(record)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn packet(data: &[u8], is_keyframe: bool) -> Packet {
+        Packet {
+            data: data.to_vec(),
+            pts: 0,
+            dts: 0,
+            is_keyframe,
+            duration: 1,
+            alpha: None,
+        }
+    }
+
+    fn stream() -> AvcStream {
+        AvcStream::new(None, None)
+    }
+
+    #[test]
+    fn test_access_unit_bare_nals() {
+        let mut avc = stream();
+
+        // Parameter sets and SEI in packets of their own
+        assert!(avc
+            .access_unit(&packet(&[0x67, 0x64, 0x00, 0x1F], false))
+            .is_none());
+        assert!(avc.access_unit(&packet(&[0x68, 0xEE], false)).is_none());
+        assert!(avc.access_unit(&packet(&[0x06, 0x05], false)).is_none());
+
+        let unit = avc.access_unit(&packet(&[0x65, 0x88], true)).unwrap();
+        assert!(unit.is_keyframe);
+        assert_eq!(
+            unit.data,
+            vec![0, 0, 0, 2, 0x06, 0x05, 0, 0, 0, 2, 0x65, 0x88]
+        );
+
+        assert_eq!(
+            avc.config_record().unwrap(),
+            vec![
+                1, 0x64, 0x00, 0x1F, 0xFF, 0xE1, 0, 4, 0x67, 0x64, 0x00, 0x1F, 1, 0, 2, 0x68, 0xEE
+            ]
+        );
+    }
+
+    #[test]
+    fn test_access_unit_annex_b() {
+        let mut avc = stream();
+
+        let data = [
+            0, 0, 0, 1, 0x09, 0xF0, // AUD
+            0, 0, 0, 1, 0x67, 0x42, 0x00, 0x1E, // SPS
+            0, 0, 1, 0x68, 0xCE, // PPS
+            0, 0, 1, 0x65, 0x88, 0x84, // IDR slice
+        ];
+        let unit = avc.access_unit(&packet(&data, false)).unwrap();
+        assert!(unit.is_keyframe);
+        assert_eq!(unit.data, vec![0, 0, 0, 3, 0x65, 0x88, 0x84]);
+        assert_eq!(avc.sps, Some(vec![0x67, 0x42, 0x00, 0x1E]));
+        assert_eq!(avc.pps, Some(vec![0x68, 0xCE]));
+
+        let unit = avc
+            .access_unit(&packet(&[0, 0, 0, 1, 0x41, 0x9A], false))
+            .unwrap();
+        assert!(!unit.is_keyframe);
+    }
+
+    #[test]
+    fn test_config_record_missing() {
+        assert!(stream().config_record().is_err());
+    }
+}
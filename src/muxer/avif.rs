@@ -0,0 +1,208 @@
+//! Animated AVIF (AV1 image sequence) muxer
+//!
+//! Writes an `avis` file with the frames as a `pict` track. The first frame
+//! is also exposed as the primary image item, so viewers without animation
+//! support still show a still image.
+
+use super::bmff::{self, make_box, make_container, make_full_box};
+use super::obu;
+use super::{Muxer, MuxerConfig};
+use crate::encoder::Packet;
+use crate::{Codec, Error, Result};
+use std::fs::File;
+use std::io::{BufWriter, Write};
+use std::path::{Path, PathBuf};
+
+/// Coding constraints: intra prediction used, any number of references
+const CCST_FLAGS: u32 = 0x7C00_0000;
+
+/// AVIF image sequence muxer (AV1 only)
+///
+/// Samples are buffered in memory because the sample tables and the
+/// primary item location must be written before the media data.
+pub struct AvifMuxer {
+    output_path: PathBuf,
+    config: MuxerConfig,
+    samples: Vec<Vec<u8>>,
+    /// Duration of each sample in frame periods
+    durations: Vec<u32>,
+    sync_samples: Vec<u32>,
+}
+
+impl AvifMuxer {
+    pub fn new<P: AsRef<Path>>(output_path: P, config: MuxerConfig) -> Result<Self> {
+        if config.codec != Codec::Av1 {
+            return Err(Error::Mux(
+                "AVIF container only supports AV1 codec".to_string(),
+            ));
+        }
+
+        // Create the file now so an unwritable path fails early
+        File::create(output_path.as_ref()).map_err(Error::Io)?;
+
+        Ok(Self {
+            output_path: output_path.as_ref().to_path_buf(),
+            config,
+            samples: Vec::new(),
+            durations: Vec::new(),
+            sync_samples: Vec::new(),
+        })
+    }
+
+    /// Build everything before the media data, given the offset of the first sample
+    fn build_header(&self, av1c: &[u8], data_offset: u32) -> Vec<u8> {
+        let mut header = bmff::ftyp(
+            b"avis",
+            0,
+            &[b"avif", b"avis", b"msf1", b"iso8", b"mif1", b"miaf"],
+        );
+        header.extend(self.build_meta(av1c, data_offset));
+        header.extend(self.build_moov(av1c, data_offset));
+        header
+    }
+
+    /// Meta box declaring the first frame as the primary image item
+    fn build_meta(&self, av1c: &[u8], data_offset: u32) -> Vec<u8> {
+        let first_len = self.samples.first().map(|s| s.len()).unwrap_or(0) as u32;
+
+        // Primary item
+        let pitm = make_full_box(b"pitm", 0, 0, &1u16.to_be_bytes());
+
+        // Item location: one extent at the start of the media data
+        let mut iloc = vec![0x44, 0x00]; // offset_size 4, length_size 4
+        iloc.extend_from_slice(&1u16.to_be_bytes()); // item_count
+        iloc.extend_from_slice(&1u16.to_be_bytes()); // item_ID
+        iloc.extend_from_slice(&0u16.to_be_bytes()); // data_reference_index
+        iloc.extend_from_slice(&1u16.to_be_bytes()); // extent_count
+        iloc.extend_from_slice(&data_offset.to_be_bytes());
+        iloc.extend_from_slice(&first_len.to_be_bytes());
+        let iloc = make_full_box(b"iloc", 0, 0, &iloc);
+
+        // Item info
+        let mut infe = Vec::new();
+        infe.extend_from_slice(&1u16.to_be_bytes()); // item_ID
+        infe.extend_from_slice(&0u16.to_be_bytes()); // item_protection_index
+        infe.extend_from_slice(b"av01");
+        infe.push(0); // item_name
+        let mut iinf = 1u16.to_be_bytes().to_vec();
+        iinf.extend(make_full_box(b"infe", 2, 0, &infe));
+        let iinf = make_full_box(b"iinf", 0, 0, &iinf);
+
+        // Item properties: ispe, pixi, av1C, colr; the bit depth is read
+        // from the high_bitdepth and twelve_bit flags of av1C
+        let mut ispe = self.config.width.to_be_bytes().to_vec();
+        ispe.extend_from_slice(&self.config.height.to_be_bytes());
+        let depth = match av1c.get(2).map(|flags| flags & 0x60) {
+            Some(0x60) => 12,
+            Some(0x40) => 10,
+            _ => 8,
+        };
+        let ipco = make_container(
+            b"ipco",
+            &[
+                make_full_box(b"ispe", 0, 0, &ispe),
+                make_full_box(b"pixi", 0, 0, &[3, depth, depth, depth]),
+                make_box(b"av1C", av1c),
+                bmff::colr_nclx(&self.config.color),
+            ],
+        );
+        let mut ipma = 1u32.to_be_bytes().to_vec(); // entry_count
+        ipma.extend_from_slice(&1u16.to_be_bytes()); // item_ID
+        ipma.extend_from_slice(&[4, 0x01, 0x02, 0x83, 0x04]); // av1C is essential
+        let ipma = make_full_box(b"ipma", 0, 0, &ipma);
+        let iprp = make_container(b"iprp", &[ipco, ipma]);
+
+        let mut meta = bmff::hdlr(b"pict", "");
+        meta.extend(pitm);
+        meta.extend(iloc);
+        meta.extend(iinf);
+        meta.extend(iprp);
+        make_full_box(b"meta", 0, 0, &meta)
+    }
+
+    /// Movie box with a single image sequence track
+    fn build_moov(&self, av1c: &[u8], data_offset: u32) -> Vec<u8> {
+        let width = self.config.width;
+        let height = self.config.height;
+        let timescale = self.config.fps;
+        let duration = self.durations.iter().sum();
+
+        let sample_entry = bmff::visual_sample_entry(
+            b"av01",
+            width,
+            height,
+            &[
+                make_box(b"av1C", av1c),
+                bmff::colr_nclx(&self.config.color),
+                make_full_box(b"ccst", 0, 0, &CCST_FLAGS.to_be_bytes()),
+            ],
+        );
+        let sizes: Vec<u32> = self.samples.iter().map(|s| s.len() as u32).collect();
+        let stbl = bmff::stbl(
+            sample_entry,
+            &sizes,
+            &self.durations,
+            &self.sync_samples,
+            data_offset,
+        );
+
+        let minf = make_container(b"minf", &[bmff::vmhd(), bmff::dinf(), stbl]);
+        let mdia = make_container(
+            b"mdia",
+            &[
+                bmff::mdhd(timescale, duration),
+                bmff::hdlr(b"pict", "minmpeg"),
+                minf,
+            ],
+        );
+        let trak = make_container(b"trak", &[bmff::tkhd(1, duration, width, height), mdia]);
+
+        make_container(b"moov", &[bmff::mvhd(timescale, duration, 2), trak])
+    }
+}
+
+impl Muxer for AvifMuxer {
+    fn write_packet(&mut self, packet: &Packet) -> Result<()> {
+        self.samples
+            .push(obu::strip_temporal_delimiters(&packet.data));
+        self.durations.push(packet.duration.max(1));
+        if packet.is_keyframe {
+            self.sync_samples.push(self.samples.len() as u32);
+        }
+        Ok(())
+    }
+
+    fn finalize(self: Box<Self>) -> Result<()> {
+        let first = self
+            .samples
+            .first()
+            .ok_or_else(|| Error::Mux("No frames to write".to_string()))?;
+        let av1c = obu::av1_config_record(first)
+            .ok_or_else(|| Error::Mux("First frame has no AV1 sequence header".to_string()))?;
+
+        let media_size: usize = self.samples.iter().map(|s| s.len()).sum();
+
+        // Box sizes do not depend on the offset values, so measure first
+        let header_len = self.build_header(&av1c, 0).len();
+        if header_len + media_size + 8 > u32::MAX as usize {
+            return Err(Error::Mux("AVIF output exceeds 4 GiB".to_string()));
+        }
+        let data_offset = (header_len + 8) as u32;
+        let header = self.build_header(&av1c, data_offset);
+
+        let file = File::create(&self.output_path).map_err(Error::Io)?;
+        let mut writer = BufWriter::new(file);
+
+        writer.write_all(&header).map_err(Error::Io)?;
+        writer
+            .write_all(&((media_size + 8) as u32).to_be_bytes())
+            .map_err(Error::Io)?;
+        writer.write_all(b"mdat").map_err(Error::Io)?;
+        for sample in &self.samples {
+            writer.write_all(sample).map_err(Error::Io)?;
+        }
+        writer.flush().map_err(Error::Io)?;
+
+        Ok(())
+    }
+}
@@ -0,0 +1,354 @@
+//! ISO base media file format (ISOBMFF) box helpers
+
+use crate::colorspace::ColorSpace;
+use crate::hdr::{self, Hdr10};
+use crate::Chapter;
+
+/// Identity transformation matrix used by `mvhd` and `tkhd`
+const UNITY_MATRIX: [u32; 9] = [0x0001_0000, 0, 0, 0, 0x0001_0000, 0, 0, 0, 0x4000_0000];
+
+/// Build a box from its four-character type and payload
+pub fn make_box(kind: &[u8; 4], payload: &[u8]) -> Vec<u8> {
+    let mut data = Vec::with_capacity(payload.len() + 8);
+    data.extend_from_slice(&((payload.len() + 8) as u32).to_be_bytes());
+    data.extend_from_slice(kind);
+    data.extend_from_slice(payload);
+    data
+}
+
+/// Build a full box (a box with version and flags)
+pub fn make_full_box(kind: &[u8; 4], version: u8, flags: u32, payload: &[u8]) -> Vec<u8> {
+    let mut data = Vec::with_capacity(payload.len() + 4);
+    data.extend_from_slice(&(((version as u32) << 24) | (flags & 0x00FF_FFFF)).to_be_bytes());
+    data.extend_from_slice(payload);
+    make_box(kind, &data)
+}
+
+/// Build a box containing the concatenated child boxes
+pub fn make_container(kind: &[u8; 4], children: &[Vec<u8>]) -> Vec<u8> {
+    make_box(kind, &children.concat())
+}
+
+/// File type box
+pub fn ftyp(major: &[u8; 4], minor_version: u32, compatible: &[&[u8; 4]]) -> Vec<u8> {
+    let mut data = Vec::new();
+    data.extend_from_slice(major);
+    data.extend_from_slice(&minor_version.to_be_bytes());
+    for brand in compatible {
+        data.extend_from_slice(*brand);
+    }
+    make_box(b"ftyp", &data)
+}
+
+/// Movie header box
+pub fn mvhd(timescale: u32, duration: u32, next_track_id: u32) -> Vec<u8> {
+    let mut data = Vec::new();
+    data.extend_from_slice(&0u32.to_be_bytes()); // creation_time
+    data.extend_from_slice(&0u32.to_be_bytes()); // modification_time
+    data.extend_from_slice(&timescale.to_be_bytes());
+    data.extend_from_slice(&duration.to_be_bytes());
+    data.extend_from_slice(&0x0001_0000u32.to_be_bytes()); // rate 1.0
+    data.extend_from_slice(&0x0100u16.to_be_bytes()); // volume 1.0
+    data.extend_from_slice(&[0; 10]); // reserved
+    for v in UNITY_MATRIX {
+        data.extend_from_slice(&v.to_be_bytes());
+    }
+    data.extend_from_slice(&[0; 24]); // pre_defined
+    data.extend_from_slice(&next_track_id.to_be_bytes());
+    make_full_box(b"mvhd", 0, 0, &data)
+}
+
+/// Track header box for a video track
+pub fn tkhd(track_id: u32, duration: u32, width: u32, height: u32) -> Vec<u8> {
+    let mut data = Vec::new();
+    data.extend_from_slice(&0u32.to_be_bytes()); // creation_time
+    data.extend_from_slice(&0u32.to_be_bytes()); // modification_time
+    data.extend_from_slice(&track_id.to_be_bytes());
+    data.extend_from_slice(&0u32.to_be_bytes()); // reserved
+    data.extend_from_slice(&duration.to_be_bytes());
+    data.extend_from_slice(&[0; 8]); // reserved
+    data.extend_from_slice(&0u16.to_be_bytes()); // layer
+    data.extend_from_slice(&0u16.to_be_bytes()); // alternate_group
+    data.extend_from_slice(&0u16.to_be_bytes()); // volume
+    data.extend_from_slice(&0u16.to_be_bytes()); // reserved
+    for v in UNITY_MATRIX {
+        data.extend_from_slice(&v.to_be_bytes());
+    }
+    data.extend_from_slice(&(width << 16).to_be_bytes());
+    data.extend_from_slice(&(height << 16).to_be_bytes());
+    // Flags: track enabled, in movie
+    make_full_box(b"tkhd", 0, 0x03, &data)
+}
+
+/// Media header box
+pub fn mdhd(timescale: u32, duration: u32) -> Vec<u8> {
+    let mut data = Vec::new();
+    data.extend_from_slice(&0u32.to_be_bytes()); // creation_time
+    data.extend_from_slice(&0u32.to_be_bytes()); // modification_time
+    data.extend_from_slice(&timescale.to_be_bytes());
+    data.extend_from_slice(&duration.to_be_bytes());
+    data.extend_from_slice(&0x55C4u16.to_be_bytes()); // language "und"
+    data.extend_from_slice(&0u16.to_be_bytes()); // pre_defined
+    make_full_box(b"mdhd", 0, 0, &data)
+}
+
+/// Handler reference box
+pub fn hdlr(handler: &[u8; 4], name: &str) -> Vec<u8> {
+    let mut data = Vec::new();
+    data.extend_from_slice(&0u32.to_be_bytes()); // pre_defined
+    data.extend_from_slice(handler);
+    data.extend_from_slice(&[0; 12]); // reserved
+    data.extend_from_slice(name.as_bytes());
+    data.push(0);
+    make_full_box(b"hdlr", 0, 0, &data)
+}
+
+/// Video media header box
+pub fn vmhd() -> Vec<u8> {
+    // graphicsmode + opcolor
+    make_full_box(b"vmhd", 0, 0x01, &[0; 8])
+}
+
+/// Data information box referencing the file itself
+pub fn dinf() -> Vec<u8> {
+    let url = make_full_box(b"url ", 0, 0x01, &[]);
+    let mut dref = 1u32.to_be_bytes().to_vec();
+    dref.extend(url);
+    make_container(b"dinf", &[make_full_box(b"dref", 0, 0, &dref)])
+}
+
+/// Visual sample entry with the given codec-specific child boxes
+pub fn visual_sample_entry(
+    kind: &[u8; 4],
+    width: u32,
+    height: u32,
+    children: &[Vec<u8>],
+) -> Vec<u8> {
+    let mut data = Vec::new();
+    data.extend_from_slice(&[0; 6]); // reserved
+    data.extend_from_slice(&1u16.to_be_bytes()); // data_reference_index
+    data.extend_from_slice(&[0; 16]); // pre_defined + reserved
+    data.extend_from_slice(&(width as u16).to_be_bytes());
+    data.extend_from_slice(&(height as u16).to_be_bytes());
+    data.extend_from_slice(&0x0048_0000u32.to_be_bytes()); // 72 dpi
+    data.extend_from_slice(&0x0048_0000u32.to_be_bytes()); // 72 dpi
+    data.extend_from_slice(&0u32.to_be_bytes()); // reserved
+    data.extend_from_slice(&1u16.to_be_bytes()); // frame_count
+    data.extend_from_slice(&[0; 32]); // compressorname
+    data.extend_from_slice(&0x0018u16.to_be_bytes()); // depth
+    data.extend_from_slice(&(-1i16).to_be_bytes()); // pre_defined
+    for child in children {
+        data.extend_from_slice(child);
+    }
+    make_box(kind, &data)
+}
+
+/// Color box (`colr`) with the H.273 code points and range of a color space
+pub fn colr_nclx(color: &ColorSpace) -> Vec<u8> {
+    let mut data = b"nclx".to_vec();
+    for index in [color.primaries, color.transfer, color.matrix] {
+        data.extend_from_slice(&(index as u16).to_be_bytes());
+    }
+    data.push((color.full_range as u8) << 7); // full_range_flag
+    make_box(b"colr", &data)
+}
+
+/// Mastering display color volume (`mdcv`) and content light level
+/// (`clli`) boxes of HDR10 video
+pub fn hdr10_metadata(hdr: &Hdr10) -> Vec<Vec<u8>> {
+    // Chromaticities in 0.00002 and luminance in 0.0001 units, with the
+    // primaries in green, blue, red order
+    let mut mdcv = Vec::new();
+    let [red, green, blue] = hdr::PRIMARIES;
+    for (x, y) in [green, blue, red, hdr::WHITE_POINT] {
+        mdcv.extend_from_slice(&((x * 50000.0).round() as u16).to_be_bytes());
+        mdcv.extend_from_slice(&((y * 50000.0).round() as u16).to_be_bytes());
+    }
+    mdcv.extend_from_slice(&(hdr.max_luminance * 10000).to_be_bytes());
+    mdcv.extend_from_slice(&((hdr::MIN_LUMINANCE * 10000.0).round() as u32).to_be_bytes());
+
+    let mut clli = hdr.max_cll.to_be_bytes().to_vec();
+    clli.extend_from_slice(&hdr.max_fall.to_be_bytes());
+
+    vec![make_box(b"mdcv", &mdcv), make_box(b"clli", &clli)]
+}
+
+/// User data box (`udta`) with a Nero chapter list (`chpl`), the chapters
+/// read by ffmpeg, VLC and most players, of which it holds up to 255
+pub fn udta_chapters(chapters: &[Chapter]) -> Vec<u8> {
+    let mut chpl = 0u32.to_be_bytes().to_vec(); // reserved
+    chpl.push(chapters.len().min(255) as u8);
+    for chapter in chapters.iter().take(255) {
+        // Start in 100-nanosecond units, then the title of up to 255 bytes
+        chpl.extend_from_slice(&chapter.start_ms.saturating_mul(10_000).to_be_bytes());
+        let mut len = chapter.title.len().min(255);
+        while !chapter.title.is_char_boundary(len) {
+            len -= 1;
+        }
+        chpl.push(len as u8);
+        chpl.extend_from_slice(&chapter.title.as_bytes()[..len]);
+    }
+    make_container(b"udta", &[make_full_box(b"chpl", 1, 0, &chpl)])
+}
+
+/// Sample table for samples stored in a single chunk
+///
+/// `sample_durations` holds the duration of each sample in media timescale
+/// units; runs of equal durations share one time-to-sample entry.
+pub fn stbl(
+    sample_entry: Vec<u8>,
+    sample_sizes: &[u32],
+    sample_durations: &[u32],
+    sync_samples: &[u32],
+    chunk_offset: u32,
+) -> Vec<u8> {
+    let count = sample_sizes.len() as u32;
+
+    let mut stsd = 1u32.to_be_bytes().to_vec();
+    stsd.extend(sample_entry);
+
+    let stts = stts(sample_durations);
+
+    let mut stss = (sync_samples.len() as u32).to_be_bytes().to_vec();
+    for s in sync_samples {
+        stss.extend_from_slice(&s.to_be_bytes());
+    }
+
+    let mut stsc = 1u32.to_be_bytes().to_vec();
+    stsc.extend_from_slice(&1u32.to_be_bytes()); // first_chunk
+    stsc.extend_from_slice(&count.to_be_bytes()); // samples_per_chunk
+    stsc.extend_from_slice(&1u32.to_be_bytes()); // sample_description_index
+
+    let mut stsz = 0u32.to_be_bytes().to_vec();
+    stsz.extend_from_slice(&count.to_be_bytes());
+    for s in sample_sizes {
+        stsz.extend_from_slice(&s.to_be_bytes());
+    }
+
+    let mut stco = 1u32.to_be_bytes().to_vec();
+    stco.extend_from_slice(&chunk_offset.to_be_bytes());
+
+    make_container(
+        b"stbl",
+        &[
+            make_full_box(b"stsd", 0, 0, &stsd),
+            make_full_box(b"stts", 0, 0, &stts),
+            make_full_box(b"stss", 0, 0, &stss),
+            make_full_box(b"stsc", 0, 0, &stsc),
+            make_full_box(b"stsz", 0, 0, &stsz),
+            make_full_box(b"stco", 0, 0, &stco),
+        ],
+    )
+}
+
+/// Time-to-sample table payload with run-length coded durations
+fn stts(sample_durations: &[u32]) -> Vec<u8> {
+    let mut runs: Vec<(u32, u32)> = Vec::new();
+    for &duration in sample_durations {
+        match runs.last_mut() {
+            Some((count, last)) if *last == duration => *count += 1,
+            _ => runs.push((1, duration)),
+        }
+    }
+
+    let mut data = (runs.len() as u32).to_be_bytes().to_vec();
+    for (count, duration) in runs {
+        data.extend_from_slice(&count.to_be_bytes());
+        data.extend_from_slice(&duration.to_be_bytes());
+    }
+    data
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_make_box() {
+        let b = make_box(b"free", &[1, 2, 3]);
+        assert_eq!(b, vec![0, 0, 0, 11, b'f', b'r', b'e', b'e', 1, 2, 3]);
+    }
+
+    #[test]
+    fn test_make_full_box() {
+        let b = make_full_box(b"test", 1, 0x03, &[9]);
+        assert_eq!(&b[0..4], &13u32.to_be_bytes());
+        assert_eq!(&b[8..12], &[1, 0, 0, 3]);
+        assert_eq!(b[12], 9);
+    }
+
+    #[test]
+    fn test_colr_nclx() {
+        let colr = colr_nclx(&ColorSpace::default());
+        assert_eq!(&colr[4..8], b"colr");
+        assert_eq!(&colr[8..], &[b'n', b'c', b'l', b'x', 0, 1, 0, 1, 0, 1, 0]);
+
+        let full = ColorSpace {
+            full_range: true,
+            ..Default::default()
+        };
+        assert_eq!(colr_nclx(&full)[18], 0x80);
+    }
+
+    #[test]
+    fn test_hdr10_boxes() {
+        let colr = colr_nclx(&ColorSpace::HDR10);
+        assert_eq!(&colr[8..], &[b'n', b'c', b'l', b'x', 0, 9, 0, 16, 0, 9, 0]);
+
+        let hdr = Hdr10 {
+            max_luminance: 1000,
+            max_cll: 800,
+            max_fall: 200,
+        };
+        let boxes = hdr10_metadata(&hdr);
+        assert_eq!(boxes[0].len(), 8 + 24);
+        assert_eq!(&boxes[0][4..8], b"mdcv");
+        // Green primary first, then the peak of 1000 cd/m²
+        assert_eq!(&boxes[0][8..12], &[0x21, 0x34, 0x9B, 0xAA]);
+        assert_eq!(&boxes[0][24..28], &10_000_000u32.to_be_bytes());
+        assert_eq!(boxes[1], make_box(b"clli", &[0x03, 0x20, 0x00, 0xC8]));
+    }
+
+    #[test]
+    fn test_udta_chapters() {
+        let chapters = [
+            Chapter {
+                start_ms: 0,
+                title: "Intro".to_string(),
+            },
+            Chapter {
+                start_ms: 1500,
+                title: "é".repeat(200),
+            },
+        ];
+        let udta = udta_chapters(&chapters);
+        assert_eq!(&udta[4..8], b"udta");
+        assert_eq!(&udta[12..16], b"chpl");
+        // Version 1, reserved, then the chapter count
+        assert_eq!(&udta[16..25], &[1, 0, 0, 0, 0, 0, 0, 0, 2]);
+        assert_eq!(&udta[25..33], &0u64.to_be_bytes());
+        assert_eq!(&udta[33..39], b"\x05Intro");
+        assert_eq!(&udta[39..47], &15_000_000u64.to_be_bytes());
+        // Titles are cut at a character boundary within 255 bytes
+        assert_eq!(udta[47], 254);
+        assert_eq!(udta.len(), 48 + 254);
+    }
+
+    #[test]
+    fn test_ftyp() {
+        let b = ftyp(b"avis", 0, &[b"avif", b"mif1"]);
+        assert_eq!(b.len(), 24);
+        assert_eq!(&b[8..12], b"avis");
+        assert_eq!(&b[16..20], b"avif");
+    }
+
+    #[test]
+    fn test_stts_runs() {
+        let data = stts(&[1, 1, 1, 5, 1, 1]);
+        let words: Vec<u32> = data
+            .chunks(4)
+            .map(|c| u32::from_be_bytes([c[0], c[1], c[2], c[3]]))
+            .collect();
+        assert_eq!(words, vec![3, 3, 1, 1, 5, 2, 1]);
+    }
+}
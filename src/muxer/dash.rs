@@ -0,0 +1,367 @@
+//! MPEG-DASH muxer
+//!
+//! Writes a static manifest (`.mpd`) and, beside it, fMP4 segments named
+//! after the manifest (`name_00000.m4s`) with an initialization segment
+//! (`name_init.mp4`), the same files as HLS fMP4 output. Segments start at
+//! keyframes, so a segment runs past the target duration until the next
+//! keyframe; the manifest lists each duration in a segment timeline.
+
+use super::avc::AvcStream;
+use super::fmp4::{self, Sample};
+use super::hls::DEFAULT_SEGMENT_MS;
+use super::{Muxer, MuxerConfig};
+use crate::encoder::Packet;
+use crate::{Codec, Error, Result};
+use std::fmt::Write as _;
+use std::fs::File;
+use std::path::{Path, PathBuf};
+
+/// DASH muxer
+pub struct DashMuxer {
+    manifest_path: PathBuf,
+    /// Segment file names start with this
+    prefix: String,
+    config: MuxerConfig,
+    /// Target segment duration in frame periods
+    target: u64,
+    avc: AvcStream,
+    /// Decoder configuration record, known once the first segment is written
+    record: Option<Vec<u8>>,
+    /// Packets of the segment being collected
+    pending: Vec<Packet>,
+    /// Start of the pending segment in frame periods
+    start: u64,
+    /// Duration of the pending segment in frame periods
+    duration: u64,
+    /// Duration and size in bytes of each written segment
+    segments: Vec<(u64, usize)>,
+}
+
+impl DashMuxer {
+    pub fn new<P: AsRef<Path>>(output_path: P, config: MuxerConfig) -> Result<Self> {
+        if !matches!(config.codec, Codec::H264 | Codec::Hevc | Codec::Av1) {
+            return Err(Error::Mux(format!(
+                "DASH does not support codec {:?}",
+                config.codec
+            )));
+        }
+
+        let manifest_path = output_path.as_ref().to_path_buf();
+        let prefix = manifest_path
+            .file_stem()
+            .and_then(|s| s.to_str())
+            .filter(|s| !s.is_empty())
+            .ok_or_else(|| Error::InvalidInput("DASH manifest needs a file name".to_string()))?
+            .to_string();
+
+        // Create the manifest now so an unwritable path fails early
+        File::create(&manifest_path).map_err(Error::Io)?;
+
+        let segment_ms = match config.segment_ms {
+            0 => DEFAULT_SEGMENT_MS,
+            ms => ms,
+        };
+        let target = (segment_ms as u64 * config.fps as u64 / 1000).max(1);
+
+        Ok(Self {
+            manifest_path,
+            prefix,
+            avc: AvcStream::new(config.codec_config.clone(), config.pps.clone()),
+            config,
+            target,
+            record: None,
+            pending: Vec::new(),
+            start: 0,
+            duration: 0,
+            segments: Vec::new(),
+        })
+    }
+
+    /// Path of a file beside the manifest
+    fn sibling(&self, name: &str) -> PathBuf {
+        self.manifest_path.with_file_name(name)
+    }
+
+    /// Write the pending packets as the next segment
+    fn write_segment(&mut self) -> Result<()> {
+        let packets = std::mem::take(&mut self.pending);
+        let index = self.segments.len();
+
+        if self.record.is_none() {
+            let record = fmp4::config_record(&self.config, &self.avc, packets.first())?;
+            let entry = fmp4::sample_entry(
+                self.config.codec,
+                self.config.width,
+                self.config.height,
+                &record,
+                &self.config.color,
+                self.config.hdr.as_ref(),
+            )?;
+            let init = fmp4::init_segment(
+                entry,
+                self.config.width,
+                self.config.height,
+                self.config.fps,
+            );
+            std::fs::write(self.sibling(&format!("{}_init.mp4", self.prefix)), init)
+                .map_err(Error::Io)?;
+            self.record = Some(record);
+        }
+
+        let samples: Vec<Sample> = packets
+            .into_iter()
+            .map(|packet| Sample::new(self.config.codec, packet))
+            .collect();
+        let data = fmp4::media_segment(index as u32 + 1, self.start, &samples);
+
+        let name = format!("{}_{:05}.m4s", self.prefix, index);
+        std::fs::write(self.sibling(&name), &data).map_err(Error::Io)?;
+        self.segments.push((self.duration, data.len()));
+        self.start += self.duration;
+        self.duration = 0;
+        Ok(())
+    }
+
+    /// Manifest of the written segments
+    fn manifest(&self) -> String {
+        let fps = self.config.fps as u64;
+        let total: u64 = self.segments.iter().map(|&(duration, _)| duration).sum();
+        let longest = self.segments.iter().map(|&(d, _)| d).max().unwrap_or(0);
+        // Peak bit rate of a segment, which players size their buffers for
+        let bandwidth = self
+            .segments
+            .iter()
+            .map(|&(duration, size)| size as u64 * 8 * fps / duration.max(1))
+            .max()
+            .unwrap_or(0);
+        let codecs = self
+            .record
+            .as_deref()
+            .map(|record| codecs(self.config.codec, record))
+            .unwrap_or_default();
+        // `$` starts template identifiers, so it is doubled in file names
+        let prefix = xml_escape(&self.prefix).replace('$', "$$");
+
+        let mut mpd = String::from("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n");
+        let _ = writeln!(
+            mpd,
+            "<MPD xmlns=\"urn:mpeg:dash:schema:mpd:2011\" profiles=\"urn:mpeg:dash:profile:isoff-live:2011\" type=\"static\" mediaPresentationDuration=\"{}\" minBufferTime=\"{}\">",
+            iso_duration(total, fps),
+            iso_duration(longest, fps)
+        );
+        mpd.push_str("  <Period id=\"0\" start=\"PT0S\">\n");
+        mpd.push_str("    <AdaptationSet id=\"0\" contentType=\"video\" mimeType=\"video/mp4\" segmentAlignment=\"true\" startWithSAP=\"1\">\n");
+        let _ = writeln!(
+            mpd,
+            "      <Representation id=\"0\" codecs=\"{}\" width=\"{}\" height=\"{}\" frameRate=\"{}\" bandwidth=\"{}\">",
+            codecs, self.config.width, self.config.height, fps, bandwidth
+        );
+        let _ = writeln!(
+            mpd,
+            "        <SegmentTemplate timescale=\"{}\" initialization=\"{}_init.mp4\" media=\"{}_$Number%05d$.m4s\" startNumber=\"0\">",
+            fps, prefix, prefix
+        );
+        mpd.push_str("          <SegmentTimeline>\n");
+
+        // Runs of equal durations share an entry
+        let mut runs: Vec<(u64, u64)> = Vec::new();
+        for &(duration, _) in &self.segments {
+            match runs.last_mut() {
+                Some((last, repeat)) if *last == duration => *repeat += 1,
+                _ => runs.push((duration, 0)),
+            }
+        }
+        for (i, &(duration, repeat)) in runs.iter().enumerate() {
+            mpd.push_str("            <S");
+            if i == 0 {
+                mpd.push_str(" t=\"0\"");
+            }
+            let _ = write!(mpd, " d=\"{}\"", duration);
+            if repeat > 0 {
+                let _ = write!(mpd, " r=\"{}\"", repeat);
+            }
+            mpd.push_str("/>\n");
+        }
+
+        mpd.push_str("          </SegmentTimeline>\n");
+        mpd.push_str("        </SegmentTemplate>\n");
+        mpd.push_str("      </Representation>\n");
+        mpd.push_str("    </AdaptationSet>\n");
+        mpd.push_str("  </Period>\n");
+        mpd.push_str("</MPD>\n");
+        mpd
+    }
+}
+
+impl Muxer for DashMuxer {
+    fn write_packet(&mut self, packet: &Packet) -> Result<()> {
+        // H.264 packets without a picture are held for the next frame
+        let packet = match self.config.codec {
+            Codec::H264 => match self.avc.access_unit(packet) {
+                Some(packet) => packet,
+                None => return Ok(()),
+            },
+            _ => packet.clone(),
+        };
+
+        // Segments end at the first keyframe after the target duration
+        if packet.is_keyframe && !self.pending.is_empty() && self.duration >= self.target {
+            self.write_segment()?;
+        }
+        self.duration += packet.duration.max(1) as u64;
+        self.pending.push(packet);
+        Ok(())
+    }
+
+    fn finalize(mut self: Box<Self>) -> Result<()> {
+        if !self.pending.is_empty() {
+            self.write_segment()?;
+        }
+        if self.segments.is_empty() {
+            return Err(Error::Mux("No frames to write".to_string()));
+        }
+        std::fs::write(&self.manifest_path, self.manifest()).map_err(Error::Io)
+    }
+}
+
+/// RFC 6381 codec string of a decoder configuration record, which players
+/// check before fetching any segment
+fn codecs(codec: Codec, record: &[u8]) -> String {
+    let byte = |at: usize| record.get(at).copied().unwrap_or(0);
+    match codec {
+        // Profile, compatibility and level
+        Codec::H264 => format!("avc1.{:02X}{:02X}{:02X}", byte(1), byte(2), byte(3)),
+        Codec::Hevc => {
+            let space = ["", "A", "B", "C"][(byte(1) >> 6) as usize];
+            let tier = if byte(1) & 0x20 != 0 { 'H' } else { 'L' };
+            let compatibility =
+                u32::from_be_bytes([byte(2), byte(3), byte(4), byte(5)]).reverse_bits();
+            let mut codecs = format!(
+                "hvc1.{}{}.{:X}.{}{}",
+                space,
+                byte(1) & 0x1F,
+                compatibility,
+                tier,
+                byte(12)
+            );
+            // Constraint flags, without trailing zero bytes
+            let constraints: Vec<u8> = (6..12).map(byte).collect();
+            let used = constraints
+                .iter()
+                .rposition(|&b| b != 0)
+                .map_or(0, |i| i + 1);
+            for flags in &constraints[..used] {
+                let _ = write!(codecs, ".{:X}", flags);
+            }
+            codecs
+        }
+        _ => {
+            let bit_depth = match (byte(2) & 0x40 != 0, byte(2) & 0x20 != 0) {
+                (true, true) => 12,
+                (true, false) => 10,
+                _ => 8,
+            };
+            let tier = if byte(2) & 0x80 != 0 { 'H' } else { 'M' };
+            format!(
+                "av01.{}.{:02}{}.{:02}",
+                byte(1) >> 5,
+                byte(1) & 0x1F,
+                tier,
+                bit_depth
+            )
+        }
+    }
+}
+
+/// ISO 8601 duration of a number of frame periods
+fn iso_duration(frames: u64, fps: u64) -> String {
+    format!("PT{:.3}S", frames as f64 / fps.max(1) as f64)
+}
+
+/// Text escaped for an XML attribute value
+fn xml_escape(text: &str) -> String {
+    text.replace('&', "&amp;")
+        .replace('<', "&lt;")
+        .replace('>', "&gt;")
+        .replace('"', "&quot;")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colorspace::ColorSpace;
+    use crate::{HlsSegment, Mp4Layout, OutputMode};
+
+    fn config() -> MuxerConfig {
+        // Configuration record of HEVC Main, level 3.1
+        let mut hvcc = vec![1, 0x01, 0x60, 0, 0, 0, 0x90, 0, 0, 0, 0, 0, 93];
+        hvcc.resize(23, 0);
+        MuxerConfig {
+            width: 320,
+            height: 240,
+            fps: 30,
+            codec: Codec::Hevc,
+            codec_config: Some(hvcc),
+            pps: None,
+            alpha: false,
+            hls_segment: HlsSegment::Ts,
+            segment_ms: 1000,
+            mp4_layout: Mp4Layout::Faststart,
+            output_mode: OutputMode::Single,
+            hdr: None,
+            color: ColorSpace::default(),
+            chapters: Vec::new(),
+        }
+    }
+
+    fn packet(is_keyframe: bool) -> Packet {
+        Packet {
+            data: vec![0, 0, 0, 3, 0x26, 0x01, 0xAF],
+            pts: 0,
+            dts: 0,
+            is_keyframe,
+            duration: 1,
+            alpha: None,
+        }
+    }
+
+    #[test]
+    fn test_segments() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("video.mpd");
+        let mut muxer = Box::new(DashMuxer::new(&path, config()).unwrap());
+        // Keyframes every 20 frames; one second is 30 frames
+        for i in 0..100 {
+            muxer.write_packet(&packet(i % 20 == 0)).unwrap();
+        }
+        muxer.finalize().unwrap();
+
+        let mpd = std::fs::read_to_string(&path).unwrap();
+        assert!(mpd.contains("mediaPresentationDuration=\"PT3.333S\""));
+        assert!(mpd.contains("codecs=\"hvc1.1.6.L93.90\""));
+        assert!(mpd.contains("initialization=\"video_init.mp4\""));
+        assert!(mpd.contains("media=\"video_$Number%05d$.m4s\""));
+        assert!(mpd.contains("<S t=\"0\" d=\"40\" r=\"1\"/>\n            <S d=\"20\"/>\n"));
+
+        let init = std::fs::read(dir.path().join("video_init.mp4")).unwrap();
+        assert!(init.windows(4).any(|w| w == b"hvcC"));
+        let segment = std::fs::read(dir.path().join("video_00002.m4s")).unwrap();
+        assert_eq!(&segment[4..8], b"moof");
+    }
+
+    #[test]
+    fn test_codecs() {
+        assert_eq!(codecs(Codec::H264, &[1, 0x64, 0x00, 0x28]), "avc1.640028");
+        // Main profile, level 4.0, 8-bit
+        assert_eq!(codecs(Codec::Av1, &[0x81, 0x08, 0x0C, 0]), "av01.0.08M.08");
+        assert_eq!(codecs(Codec::Av1, &[0x81, 0x08, 0x4C, 0]), "av01.0.08M.10");
+    }
+
+    #[test]
+    fn test_unsupported_codec() {
+        let dir = tempfile::tempdir().unwrap();
+        let mut config = config();
+        config.codec = Codec::Vp9;
+        assert!(DashMuxer::new(dir.path().join("video.mpd"), config).is_err());
+    }
+}
@@ -0,0 +1,401 @@
+//! Moving the movie box of an MP4 file before the media data
+//!
+//! Muxers that stream samples to the file write the movie box last, once
+//! the sample tables are known. Browsers then have to download the whole
+//! file before playback can start, so the box is moved to the front, like
+//! ffmpeg's `-movflags +faststart`, with the chunk offsets in its sample
+//! tables shifted by its size. Only the movie box is held in memory; the
+//! media data is streamed to a copy of the file, which then replaces it.
+
+use super::bmff::{make_container, make_full_box};
+use crate::{Error, Result};
+use std::fs::{self, File, OpenOptions};
+use std::io::{self, BufWriter, Read, Seek, SeekFrom, Write};
+use std::path::Path;
+
+/// Boxes on the path from the movie box to the chunk offset tables
+const CONTAINERS: [&[u8; 4]; 5] = [b"moov", b"trak", b"mdia", b"minf", b"stbl"];
+
+/// A box in a byte range of a file
+struct BoxRange {
+    kind: [u8; 4],
+    start: usize,
+    end: usize,
+    /// Start of the payload, after the size and type
+    payload: usize,
+}
+
+/// A top-level box of a file
+struct FileBox {
+    kind: [u8; 4],
+    start: u64,
+    end: u64,
+}
+
+/// Rewrite the movie box of the MP4 file at `path`, appending `child`, such
+/// as user data, to it if given, and with `faststart` moving it before the
+/// media data
+///
+/// Chunk offset tables that would point past 4 GiB become `co64` tables. A
+/// movie box that stays at the end of the file is rewritten in place.
+pub(super) fn rewrite_moov(path: &Path, child: Option<&[u8]>, faststart: bool) -> Result<()> {
+    let mut file = OpenOptions::new().read(true).write(true).open(path)?;
+    let boxes = scan_boxes(&mut file)?;
+    let moov = boxes
+        .iter()
+        .position(|b| &b.kind == b"moov")
+        .ok_or_else(|| Error::Mux("MP4 output has no movie box".to_string()))?;
+    let first_mdat = boxes.iter().position(|b| &b.kind == b"mdat");
+    let to_front = faststart && first_mdat.is_some_and(|mdat| mdat < moov);
+    if child.is_none() && !to_front {
+        return Ok(());
+    }
+
+    let (start, end) = (boxes[moov].start, boxes[moov].end);
+    let mut moov_data = vec![0; (end - start) as usize];
+    file.seek(SeekFrom::Start(start))?;
+    file.read_exact(&mut moov_data)?;
+    if let Some(child) = child {
+        moov_data = append_child(moov_data, child)?;
+    }
+
+    // All media data is before the movie box and moves by its size when it
+    // goes to the front; media data after it moves by as much as it grows
+    let old_len = end - start;
+    let media_after = boxes[moov + 1..].iter().any(|b| &b.kind == b"mdat");
+    if to_front {
+        moov_data = shift_moov(moov_data, |len| len)?;
+    } else if media_after {
+        moov_data = shift_moov(moov_data, |len| len - old_len)?;
+    }
+
+    if !to_front && moov == boxes.len() - 1 {
+        file.seek(SeekFrom::Start(start))?;
+        file.write_all(&moov_data)?;
+        file.set_len(start + moov_data.len() as u64)?;
+        return Ok(file.sync_all()?);
+    }
+
+    let position = match (to_front, first_mdat) {
+        (true, Some(mdat)) => mdat,
+        _ => moov,
+    };
+    let mut temp_path = path.as_os_str().to_owned();
+    temp_path.push(".faststart");
+    let copied = copy_with_moov(&mut file, &boxes, moov, position, &moov_data, &temp_path);
+    match copied.and_then(|()| Ok(fs::rename(&temp_path, path)?)) {
+        Ok(()) => Ok(()),
+        Err(e) => {
+            let _ = fs::remove_file(&temp_path);
+            Err(e)
+        }
+    }
+}
+
+/// Write the top-level boxes of `file` to a new file at `path`, with the
+/// movie box at index `moov` replaced by `moov_data` placed before the box
+/// at index `position`
+fn copy_with_moov(
+    file: &mut File,
+    boxes: &[FileBox],
+    moov: usize,
+    position: usize,
+    moov_data: &[u8],
+    path: &std::ffi::OsStr,
+) -> Result<()> {
+    let mut out = BufWriter::new(File::create(path)?);
+    for (i, b) in boxes.iter().enumerate() {
+        if i == position {
+            out.write_all(moov_data)?;
+        }
+        if i != moov {
+            file.seek(SeekFrom::Start(b.start))?;
+            let copied = io::copy(&mut (&mut *file).take(b.end - b.start), &mut out)?;
+            if copied != b.end - b.start {
+                return Err(Error::Mux("MP4 output ended early".to_string()));
+            }
+        }
+    }
+    out.into_inner().map_err(|e| e.into_error())?.sync_all()?;
+    Ok(())
+}
+
+/// Top-level boxes of a file, read from their headers
+fn scan_boxes(file: &mut File) -> Result<Vec<FileBox>> {
+    let invalid = || Error::Mux("MP4 output has an invalid box".to_string());
+    let len = file.metadata()?.len();
+    let mut boxes = Vec::new();
+    let mut at = 0;
+    while at < len {
+        let mut header = [0; 16];
+        let available = (len - at).min(16) as usize;
+        if available < 8 {
+            return Err(invalid());
+        }
+        file.seek(SeekFrom::Start(at))?;
+        file.read_exact(&mut header[..available])?;
+        let size = u32::from_be_bytes([header[0], header[1], header[2], header[3]]) as u64;
+        let kind = [header[4], header[5], header[6], header[7]];
+        let (size, header_len) = match size {
+            // Box running to the end of the file
+            0 => (len - at, 8),
+            // 64-bit size after the type
+            1 if available == 16 => (u64::from_be_bytes(header[8..16].try_into().unwrap()), 16),
+            1 => return Err(invalid()),
+            size => (size, 8),
+        };
+        let end = at
+            .checked_add(size)
+            .filter(|&e| e <= len && size >= header_len)
+            .ok_or_else(invalid)?;
+        boxes.push(FileBox {
+            kind,
+            start: at,
+            end,
+        });
+        at = end;
+    }
+    Ok(boxes)
+}
+
+/// Append a box to the payload of a movie box, updating its size
+fn append_child(mut moov: Vec<u8>, child: &[u8]) -> Result<Vec<u8>> {
+    moov.extend_from_slice(child);
+    let len = moov.len();
+    if u32::from_be_bytes(moov[..4].try_into().unwrap()) == 1 {
+        moov[8..16].copy_from_slice(&(len as u64).to_be_bytes());
+    } else {
+        let size = u32::try_from(len)
+            .map_err(|_| Error::Mux("MP4 movie box exceeds 4 GiB".to_string()))?;
+        moov[..4].copy_from_slice(&size.to_be_bytes());
+    }
+    Ok(moov)
+}
+
+/// Shift the chunk offsets of a movie box by `shift` of its final size,
+/// turning its `stco` tables into `co64` tables first when an offset would
+/// not fit in 32 bits
+fn shift_moov(mut moov: Vec<u8>, shift: impl Fn(u64) -> u64) -> Result<Vec<u8>> {
+    let max = max_stco_offset(&moov, 0, moov.len())?;
+    if max.is_some_and(|max| max + shift(moov.len() as u64) > u32::MAX as u64) {
+        moov = widen_chunk_offsets(&moov, 0, moov.len())?.concat();
+    }
+    let len = moov.len();
+    shift_chunk_offsets(&mut moov, 0, len, shift(len as u64))?;
+    Ok(moov)
+}
+
+/// Boxes in a byte range
+fn parse_boxes(data: &[u8], mut at: usize, end: usize) -> Result<Vec<BoxRange>> {
+    let invalid = || Error::Mux("MP4 output has an invalid box".to_string());
+    let mut boxes = Vec::new();
+    while at < end {
+        let header = data.get(at..at + 8).ok_or_else(invalid)?;
+        let size = u32::from_be_bytes([header[0], header[1], header[2], header[3]]) as u64;
+        let kind = [header[4], header[5], header[6], header[7]];
+        let (size, payload) = match size {
+            // Box running to the end of the range
+            0 => ((end - at) as u64, at + 8),
+            // 64-bit size after the type
+            1 => {
+                let large = data.get(at + 8..at + 16).ok_or_else(invalid)?;
+                (u64::from_be_bytes(large.try_into().unwrap()), at + 16)
+            }
+            size => (size, at + 8),
+        };
+        let box_end = at
+            .checked_add(size as usize)
+            .filter(|&e| e <= end && e >= payload)
+            .ok_or_else(invalid)?;
+        boxes.push(BoxRange {
+            kind,
+            start: at,
+            end: box_end,
+            payload,
+        });
+        at = box_end;
+    }
+    Ok(boxes)
+}
+
+/// Largest entry of the `stco` boxes in a byte range, if any
+fn max_stco_offset(data: &[u8], start: usize, end: usize) -> Result<Option<u64>> {
+    let mut max = None;
+    for b in parse_boxes(data, start, end)? {
+        let found = match &b.kind {
+            kind if CONTAINERS.contains(&kind) => max_stco_offset(data, b.payload, b.end)?,
+            b"stco" => data
+                .get(b.payload + 8..b.end)
+                .unwrap_or_default()
+                .chunks_exact(4)
+                .map(|entry| u32::from_be_bytes(entry.try_into().unwrap()) as u64)
+                .max(),
+            _ => None,
+        };
+        max = max.max(found);
+    }
+    Ok(max)
+}
+
+/// Boxes of a byte range rebuilt with `co64` boxes in place of the `stco`
+/// boxes, looking into the containers on the way to them
+fn widen_chunk_offsets(data: &[u8], start: usize, end: usize) -> Result<Vec<Vec<u8>>> {
+    let mut boxes = Vec::new();
+    for b in parse_boxes(data, start, end)? {
+        boxes.push(match &b.kind {
+            kind if CONTAINERS.contains(&kind) => {
+                make_container(kind, &widen_chunk_offsets(data, b.payload, b.end)?)
+            }
+            b"stco" => {
+                let entries = data.get(b.payload + 8..b.end).unwrap_or_default();
+                let mut payload = Vec::with_capacity(4 + entries.len() * 2);
+                payload.extend_from_slice(&((entries.len() / 4) as u32).to_be_bytes());
+                for entry in entries.chunks_exact(4) {
+                    let offset = u32::from_be_bytes(entry.try_into().unwrap()) as u64;
+                    payload.extend_from_slice(&offset.to_be_bytes());
+                }
+                make_full_box(b"co64", 0, 0, &payload)
+            }
+            _ => data[b.start..b.end].to_vec(),
+        });
+    }
+    Ok(boxes)
+}
+
+/// Add `shift` to the entries of every `stco` and `co64` box in a byte
+/// range, looking into the containers on the way to them
+fn shift_chunk_offsets(data: &mut [u8], start: usize, end: usize, shift: u64) -> Result<()> {
+    for b in parse_boxes(data, start, end)? {
+        match &b.kind {
+            kind if CONTAINERS.contains(&kind) => {
+                shift_chunk_offsets(data, b.payload, b.end, shift)?
+            }
+            b"stco" | b"co64" => {
+                let wide = &b.kind == b"co64";
+                let width = if wide { 8 } else { 4 };
+                // Version and flags, then the entry count
+                let mut at = b.payload + 8;
+                while at + width <= b.end {
+                    let entry = &mut data[at..at + width];
+                    if wide {
+                        let offset = u64::from_be_bytes((&*entry).try_into().unwrap()) + shift;
+                        entry.copy_from_slice(&offset.to_be_bytes());
+                    } else {
+                        let offset =
+                            u32::from_be_bytes((&*entry).try_into().unwrap()) as u64 + shift;
+                        let offset = u32::try_from(offset).map_err(|_| {
+                            Error::Mux("MP4 chunk offset exceeds 4 GiB".to_string())
+                        })?;
+                        entry.copy_from_slice(&offset.to_be_bytes());
+                    }
+                    at += width;
+                }
+            }
+            _ => {}
+        }
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::muxer::bmff::make_box;
+
+    /// Movie box with one chunk offset table
+    fn moov(offset: u32) -> Vec<u8> {
+        let mut stco = 1u32.to_be_bytes().to_vec();
+        stco.extend_from_slice(&offset.to_be_bytes());
+        let stbl = make_container(b"stbl", &[make_full_box(b"stco", 0, 0, &stco)]);
+        let minf = make_container(b"minf", &[stbl]);
+        let mdia = make_container(b"mdia", &[make_box(b"mdhd", &[0; 4]), minf]);
+        let trak = make_container(b"trak", &[mdia]);
+        make_container(b"moov", &[make_box(b"mvhd", &[0; 4]), trak])
+    }
+
+    /// Rewrite the movie box of a temporary file holding `data`
+    fn rewrite(data: &[u8], child: Option<&[u8]>, faststart: bool) -> Result<Vec<u8>> {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("out.mp4");
+        std::fs::write(&path, data).unwrap();
+        rewrite_moov(&path, child, faststart)?;
+        assert!(!dir.path().join("out.mp4.faststart").exists());
+        Ok(std::fs::read(&path).unwrap())
+    }
+
+    #[test]
+    fn test_move_moov_to_front() {
+        let ftyp = make_box(b"ftyp", b"isom");
+        let mdat = make_box(b"mdat", &[1, 2, 3]);
+        // The sample is right after the mdat header
+        let offset = (ftyp.len() + 8) as u32;
+        let data = [ftyp.clone(), mdat.clone(), moov(offset)].concat();
+
+        let moved = rewrite(&data, None, true).unwrap();
+        let moov_len = moov(0).len();
+        assert_eq!(moved.len(), data.len());
+        assert_eq!(&moved[ftyp.len() + 4..ftyp.len() + 8], b"moov");
+        assert_eq!(moved[moved.len() - 3..], [1, 2, 3]);
+
+        // The chunk offset points at the sample again
+        assert_eq!(
+            &moved[ftyp.len()..ftyp.len() + moov_len],
+            &moov(offset + moov_len as u32)[..]
+        );
+        let stco = moved.windows(4).position(|w| w == b"stco").unwrap();
+        let shifted = u32::from_be_bytes(moved[stco + 12..stco + 16].try_into().unwrap());
+        assert_eq!(moved[shifted as usize], 1);
+
+        // Files in order stay as they are, and so do files without faststart
+        assert_eq!(rewrite(&moved, None, true).unwrap(), moved);
+        assert_eq!(rewrite(&data, None, false).unwrap(), data);
+    }
+
+    #[test]
+    fn test_append_to_moov() {
+        let ftyp = make_box(b"ftyp", b"isom");
+        let mdat = make_box(b"mdat", &[1, 2, 3]);
+        let udta = make_container(b"udta", &[make_box(b"name", b"x")]);
+        let offset = (ftyp.len() + 8) as u32;
+
+        // A movie box after the media data keeps its offsets
+        let data = [ftyp.clone(), mdat.clone(), moov(offset)].concat();
+        let appended = rewrite(&data, Some(&udta), false).unwrap();
+        let mut expected = moov(offset);
+        expected.extend_from_slice(&udta);
+        let size = expected.len() as u32;
+        expected[..4].copy_from_slice(&size.to_be_bytes());
+        assert_eq!(appended, [ftyp.clone(), mdat.clone(), expected].concat());
+
+        // Before the media data, the offsets move by the size of the box
+        let moved = rewrite(&appended, None, true).unwrap();
+        let data = [ftyp.clone(), moov(offset + moov(0).len() as u32), mdat].concat();
+        assert_eq!(rewrite(&data, Some(&udta), false).unwrap(), moved);
+        assert_eq!(rewrite(&data, Some(&udta), true).unwrap(), moved);
+    }
+
+    #[test]
+    fn test_widen_chunk_offsets() {
+        // Offsets that would pass 4 GiB turn into 64-bit entries
+        let offset = u32::MAX - 10;
+        let shifted = shift_moov(moov(offset), |len| len).unwrap();
+        let co64 = shifted.windows(4).position(|w| w == b"co64").unwrap();
+        assert!(!shifted.windows(4).any(|w| w == b"stco"));
+        assert_eq!(shifted.len(), moov(0).len() + 4);
+        assert_eq!(&shifted[co64 + 8..co64 + 12], &1u32.to_be_bytes());
+        let entry = u64::from_be_bytes(shifted[co64 + 12..co64 + 20].try_into().unwrap());
+        assert_eq!(entry, offset as u64 + shifted.len() as u64);
+
+        // Offsets that fit stay 32-bit
+        let shifted = shift_moov(moov(100), |len| len).unwrap();
+        assert_eq!(shifted, moov(100 + moov(0).len() as u32));
+    }
+
+    #[test]
+    fn test_invalid_boxes() {
+        assert!(rewrite(&make_box(b"mdat", &[0]), None, true).is_err());
+        let mut data = make_box(b"ftyp", b"isom");
+        data.extend_from_slice(&[0, 0, 0, 99, b'm', b'o', b'o', b'v']);
+        assert!(rewrite(&data, None, true).is_err());
+    }
+}
@@ -0,0 +1,238 @@
+//! Fragmented MP4 (fMP4) segments
+//!
+//! Streaming formats split a video into an initialization segment, holding
+//! the movie box without samples, and media segments of one movie fragment
+//! each, so every segment can be fetched and decoded on its own.
+
+use super::avc::AvcStream;
+use super::bmff::{self, make_box, make_container, make_full_box};
+use super::{obu, MuxerConfig};
+use crate::colorspace::ColorSpace;
+use crate::encoder::Packet;
+use crate::hdr::Hdr10;
+use crate::{Codec, Error, Result};
+
+/// Sample flags of sync samples (depends on no other sample)
+const SYNC_SAMPLE_FLAGS: u32 = 0x0200_0000;
+/// Sample flags of other samples (depends on others, not a sync sample)
+const NON_SYNC_SAMPLE_FLAGS: u32 = 0x0101_0000;
+
+/// A sample of a media segment
+pub(super) struct Sample {
+    /// Sample data as stored in MP4
+    pub data: Vec<u8>,
+    /// Duration in media timescale units
+    pub duration: u32,
+    /// Whether the sample is a sync sample (keyframe)
+    pub is_sync: bool,
+}
+
+impl Sample {
+    /// Sample of an encoded packet
+    pub fn new(codec: Codec, packet: Packet) -> Self {
+        Self {
+            duration: packet.duration.max(1),
+            is_sync: packet.is_keyframe,
+            data: match codec {
+                Codec::Av1 => obu::strip_temporal_delimiters(&packet.data),
+                _ => packet.data,
+            },
+        }
+    }
+}
+
+/// Decoder configuration record of a stream: built from the parameter sets
+/// for H.264, given by the encoder for HEVC, and taken from the sequence
+/// header of the first frame for AV1
+pub(super) fn config_record(
+    config: &MuxerConfig,
+    avc: &AvcStream,
+    first: Option<&Packet>,
+) -> Result<Vec<u8>> {
+    match config.codec {
+        Codec::H264 => avc.config_record(),
+        Codec::Hevc => config
+            .codec_config
+            .clone()
+            .ok_or_else(|| Error::Mux("HEVC configuration record is missing".to_string())),
+        _ => first
+            .and_then(|p| obu::av1_config_record(&p.data))
+            .ok_or_else(|| Error::Mux("First frame has no AV1 sequence header".to_string())),
+    }
+}
+
+/// Visual sample entry for a codec with its decoder configuration record
+/// (`avcC`, `hvcC` or `av1C` payload), tagged with the color space and the
+/// HDR10 metadata if any
+pub(super) fn sample_entry(
+    codec: Codec,
+    width: u32,
+    height: u32,
+    config_record: &[u8],
+    color: &ColorSpace,
+    hdr: Option<&Hdr10>,
+) -> Result<Vec<u8>> {
+    let (kind, config_kind) = match codec {
+        Codec::H264 => (b"avc1", b"avcC"),
+        Codec::Hevc => (b"hvc1", b"hvcC"),
+        Codec::Av1 => (b"av01", b"av1C"),
+        _ => {
+            return Err(Error::Mux(format!(
+                "Fragmented MP4 does not support codec {:?}",
+                codec
+            )))
+        }
+    };
+    let mut children = vec![make_box(config_kind, config_record), bmff::colr_nclx(color)];
+    if let Some(hdr) = hdr {
+        children.extend(bmff::hdr10_metadata(hdr));
+    }
+    Ok(bmff::visual_sample_entry(kind, width, height, &children))
+}
+
+/// Initialization segment with a single video track and no samples
+pub(super) fn init_segment(
+    sample_entry: Vec<u8>,
+    width: u32,
+    height: u32,
+    timescale: u32,
+) -> Vec<u8> {
+    let mut stsd = 1u32.to_be_bytes().to_vec();
+    stsd.extend(sample_entry);
+    let stbl = make_container(
+        b"stbl",
+        &[
+            make_full_box(b"stsd", 0, 0, &stsd),
+            make_full_box(b"stts", 0, 0, &0u32.to_be_bytes()),
+            make_full_box(b"stsc", 0, 0, &0u32.to_be_bytes()),
+            make_full_box(b"stsz", 0, 0, &[0; 8]),
+            make_full_box(b"stco", 0, 0, &0u32.to_be_bytes()),
+        ],
+    );
+
+    let minf = make_container(b"minf", &[bmff::vmhd(), bmff::dinf(), stbl]);
+    let mdia = make_container(
+        b"mdia",
+        &[
+            bmff::mdhd(timescale, 0),
+            bmff::hdlr(b"vide", "VideoHandler"),
+            minf,
+        ],
+    );
+    let trak = make_container(b"trak", &[bmff::tkhd(1, 0, width, height), mdia]);
+
+    // Track 1 uses the first sample entry; everything else is in the fragments
+    let mut trex = 1u32.to_be_bytes().to_vec();
+    trex.extend_from_slice(&1u32.to_be_bytes());
+    trex.extend_from_slice(&[0; 12]);
+    let mvex = make_container(b"mvex", &[make_full_box(b"trex", 0, 0, &trex)]);
+
+    let mut segment = bmff::ftyp(b"iso6", 0, &[b"iso6", b"mp41"]);
+    segment.extend(make_container(
+        b"moov",
+        &[bmff::mvhd(timescale, 0, 2), trak, mvex],
+    ));
+    segment
+}
+
+/// Media segment of one movie fragment
+///
+/// `sequence` numbers the fragments from 1, and `base_decode_time` is the
+/// decode time of the first sample in media timescale units.
+pub(super) fn media_segment(sequence: u32, base_decode_time: u64, samples: &[Sample]) -> Vec<u8> {
+    // Box sizes do not depend on the offset value, so measure first
+    let moof_len = moof(sequence, base_decode_time, samples, 0).len();
+    let mut segment = moof(sequence, base_decode_time, samples, moof_len as u32 + 8);
+
+    let media_size: usize = samples.iter().map(|s| s.data.len()).sum();
+    segment.extend_from_slice(&((media_size + 8) as u32).to_be_bytes());
+    segment.extend_from_slice(b"mdat");
+    for sample in samples {
+        segment.extend_from_slice(&sample.data);
+    }
+    segment
+}
+
+/// Movie fragment box, given the offset of the first sample from its start
+fn moof(sequence: u32, base_decode_time: u64, samples: &[Sample], data_offset: u32) -> Vec<u8> {
+    let mfhd = make_full_box(b"mfhd", 0, 0, &sequence.to_be_bytes());
+
+    // Sample data offsets are relative to the movie fragment box
+    let tfhd = make_full_box(b"tfhd", 0, 0x02_0000, &1u32.to_be_bytes());
+    let tfdt = make_full_box(b"tfdt", 1, 0, &base_decode_time.to_be_bytes());
+
+    // Data offset, and the duration, size and flags of each sample
+    let mut trun = (samples.len() as u32).to_be_bytes().to_vec();
+    trun.extend_from_slice(&data_offset.to_be_bytes());
+    for sample in samples {
+        let flags = if sample.is_sync {
+            SYNC_SAMPLE_FLAGS
+        } else {
+            NON_SYNC_SAMPLE_FLAGS
+        };
+        trun.extend_from_slice(&sample.duration.to_be_bytes());
+        trun.extend_from_slice(&(sample.data.len() as u32).to_be_bytes());
+        trun.extend_from_slice(&flags.to_be_bytes());
+    }
+    let trun = make_full_box(b"trun", 0, 0x0701, &trun);
+
+    let traf = make_container(b"traf", &[tfhd, tfdt, trun]);
+    make_container(b"moof", &[mfhd, traf])
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn read_u32(data: &[u8], at: usize) -> u32 {
+        u32::from_be_bytes([data[at], data[at + 1], data[at + 2], data[at + 3]])
+    }
+
+    #[test]
+    fn test_media_segment() {
+        let samples = [
+            Sample {
+                data: vec![1; 10],
+                duration: 30,
+                is_sync: true,
+            },
+            Sample {
+                data: vec![2; 5],
+                duration: 1,
+                is_sync: false,
+            },
+        ];
+        let segment = media_segment(3, 90, &samples);
+
+        let moof_len = read_u32(&segment, 0) as usize;
+        assert_eq!(&segment[4..8], b"moof");
+        assert_eq!(&segment[moof_len + 4..moof_len + 8], b"mdat");
+        assert_eq!(&segment[moof_len + 8..moof_len + 18], &[1; 10]);
+
+        // The trun data offset points at the first sample
+        let trun = segment.windows(4).position(|w| w == b"trun").unwrap();
+        assert_eq!(read_u32(&segment, trun + 8), 2);
+        assert_eq!(read_u32(&segment, trun + 12) as usize, moof_len + 8);
+        assert_eq!(read_u32(&segment, trun + 16), 30);
+        assert_eq!(read_u32(&segment, trun + 24), SYNC_SAMPLE_FLAGS);
+    }
+
+    #[test]
+    fn test_init_segment() {
+        let entry = sample_entry(
+            Codec::H264,
+            320,
+            240,
+            &[1, 2, 3],
+            &ColorSpace::default(),
+            None,
+        )
+        .unwrap();
+        let segment = init_segment(entry, 320, 240, 30);
+        assert_eq!(&segment[4..12], b"ftypiso6");
+        assert!(segment.windows(4).any(|w| w == b"avcC"));
+        assert!(segment.windows(4).any(|w| w == b"trex"));
+
+        assert!(sample_entry(Codec::Vp9, 320, 240, &[], &ColorSpace::default(), None).is_err());
+    }
+}
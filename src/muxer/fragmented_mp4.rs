@@ -0,0 +1,200 @@
+//! Fragmented MP4 muxer
+//!
+//! Writes a movie box without samples followed by one movie fragment per
+//! keyframe interval, like ffmpeg with `-movflags frag_keyframe+empty_moov`.
+//! Each fragment goes to the file as soon as the next keyframe arrives, so
+//! only one keyframe interval is held in memory, the file can be played
+//! while it is still being written or downloaded, and a truncated file stays
+//! playable up to its last complete fragment.
+
+use super::avc::AvcStream;
+use super::fmp4::{self, Sample};
+use super::{Muxer, MuxerConfig};
+use crate::encoder::Packet;
+use crate::{Codec, Error, Result};
+use std::fs::File;
+use std::io::{BufWriter, Write};
+use std::path::Path;
+
+/// Fragmented MP4 muxer
+pub struct FragmentedMp4Muxer {
+    writer: BufWriter<File>,
+    config: MuxerConfig,
+    avc: AvcStream,
+    /// Packets of the fragment being collected
+    pending: Vec<Packet>,
+    /// Start of the pending fragment in frame periods
+    start: u64,
+    /// Number of fragments written
+    fragments: u32,
+}
+
+impl FragmentedMp4Muxer {
+    pub fn new<P: AsRef<Path>>(output_path: P, config: MuxerConfig) -> Result<Self> {
+        if !matches!(config.codec, Codec::H264 | Codec::Hevc | Codec::Av1) {
+            return Err(Error::Mux(format!(
+                "Fragmented MP4 does not support codec {:?}",
+                config.codec
+            )));
+        }
+
+        let file = File::create(output_path.as_ref()).map_err(Error::Io)?;
+
+        Ok(Self {
+            writer: BufWriter::new(file),
+            avc: AvcStream::new(config.codec_config.clone(), config.pps.clone()),
+            config,
+            pending: Vec::new(),
+            start: 0,
+            fragments: 0,
+        })
+    }
+
+    /// Write the pending packets as the next fragment, after the
+    /// initialization segment for the first one
+    fn write_fragment(&mut self) -> Result<()> {
+        let packets = std::mem::take(&mut self.pending);
+
+        if self.fragments == 0 {
+            let record = fmp4::config_record(&self.config, &self.avc, packets.first())?;
+            let entry = fmp4::sample_entry(
+                self.config.codec,
+                self.config.width,
+                self.config.height,
+                &record,
+                &self.config.color,
+                self.config.hdr.as_ref(),
+            )?;
+            let init = fmp4::init_segment(
+                entry,
+                self.config.width,
+                self.config.height,
+                self.config.fps,
+            );
+            self.writer.write_all(&init).map_err(Error::Io)?;
+        }
+
+        let samples: Vec<Sample> = packets
+            .into_iter()
+            .map(|packet| Sample::new(self.config.codec, packet))
+            .collect();
+        let duration: u64 = samples.iter().map(|s| s.duration as u64).sum();
+
+        self.fragments += 1;
+        let fragment = fmp4::media_segment(self.fragments, self.start, &samples);
+        self.writer.write_all(&fragment).map_err(Error::Io)?;
+        // Readers of the growing file see whole fragments
+        self.writer.flush().map_err(Error::Io)?;
+        self.start += duration;
+        Ok(())
+    }
+}
+
+impl Muxer for FragmentedMp4Muxer {
+    fn write_packet(&mut self, packet: &Packet) -> Result<()> {
+        // H.264 packets without a picture are held for the next frame
+        let packet = match self.config.codec {
+            Codec::H264 => match self.avc.access_unit(packet) {
+                Some(packet) => packet,
+                None => return Ok(()),
+            },
+            _ => packet.clone(),
+        };
+
+        if packet.is_keyframe && !self.pending.is_empty() {
+            self.write_fragment()?;
+        }
+        self.pending.push(packet);
+        Ok(())
+    }
+
+    fn finalize(mut self: Box<Self>) -> Result<()> {
+        if !self.pending.is_empty() {
+            self.write_fragment()?;
+        }
+        if self.fragments == 0 {
+            return Err(Error::Mux("No frames to write".to_string()));
+        }
+        self.writer.flush().map_err(Error::Io)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colorspace::ColorSpace;
+    use crate::{HlsSegment, Mp4Layout, OutputMode};
+
+    fn config(codec: Codec) -> MuxerConfig {
+        MuxerConfig {
+            width: 320,
+            height: 240,
+            fps: 30,
+            codec,
+            codec_config: Some(vec![1; 23]),
+            pps: None,
+            alpha: false,
+            hls_segment: HlsSegment::Ts,
+            segment_ms: 0,
+            mp4_layout: Mp4Layout::Fragmented,
+            output_mode: OutputMode::Single,
+            hdr: None,
+            color: ColorSpace::default(),
+            chapters: Vec::new(),
+        }
+    }
+
+    fn packet(is_keyframe: bool) -> Packet {
+        Packet {
+            data: vec![0, 0, 0, 3, 0x26, 0x01, 0xAF],
+            pts: 0,
+            dts: 0,
+            is_keyframe,
+            duration: 1,
+            alpha: None,
+        }
+    }
+
+    fn boxes(data: &[u8]) -> Vec<[u8; 4]> {
+        let mut kinds = Vec::new();
+        let mut at = 0;
+        while at + 8 <= data.len() {
+            let size = u32::from_be_bytes([data[at], data[at + 1], data[at + 2], data[at + 3]]);
+            kinds.push([data[at + 4], data[at + 5], data[at + 6], data[at + 7]]);
+            at += size as usize;
+        }
+        kinds
+    }
+
+    #[test]
+    fn test_fragments() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("video.mp4");
+        let mut muxer = Box::new(FragmentedMp4Muxer::new(&path, config(Codec::Hevc)).unwrap());
+        for i in 0..25 {
+            muxer.write_packet(&packet(i % 10 == 0)).unwrap();
+        }
+
+        // Fragments are written once the next keyframe arrives
+        let written = std::fs::read(&path).unwrap();
+        assert_eq!(
+            boxes(&written),
+            [*b"ftyp", *b"moov", *b"moof", *b"mdat", *b"moof", *b"mdat"]
+        );
+
+        muxer.finalize().unwrap();
+        let data = std::fs::read(&path).unwrap();
+        assert_eq!(boxes(&data).len(), 8);
+        assert!(data.windows(4).any(|w| w == b"mvex"));
+    }
+
+    #[test]
+    fn test_empty() {
+        let dir = tempfile::tempdir().unwrap();
+        let muxer = FragmentedMp4Muxer::new(dir.path().join("video.mp4"), config(Codec::Hevc));
+        assert!(Box::new(muxer.unwrap()).finalize().is_err());
+
+        let muxer = FragmentedMp4Muxer::new(dir.path().join("video.mp4"), config(Codec::Vp9));
+        assert!(muxer.is_err());
+    }
+}
@@ -0,0 +1,108 @@
+//! MP4 muxer for H.265/HEVC
+//!
+//! The mp4 crate writes HEVC tracks as `hev1` without parameter sets, which
+//! Apple players reject. This muxer writes an `hvc1` track with the
+//! parameter sets in its `hvcC` box instead, using the same box helpers as
+//! the AVIF muxer. The movie box is moved before the media data, so
+//! playback can start before the whole file is downloaded.
+
+use super::bmff::{self, make_box, make_container};
+use super::mdat::MediaData;
+use super::{Muxer, MuxerConfig};
+use crate::encoder::Packet;
+use crate::{Codec, Error, Result};
+use std::path::Path;
+
+/// MP4 muxer for HEVC
+///
+/// Samples are written to the file as they arrive; only their sizes and
+/// durations are kept for the sample tables.
+pub struct HevcMp4Muxer {
+    config: MuxerConfig,
+    mdat: MediaData,
+    sizes: Vec<u32>,
+    /// Duration of each sample in frame periods
+    durations: Vec<u32>,
+    sync_samples: Vec<u32>,
+}
+
+impl HevcMp4Muxer {
+    pub fn new<P: AsRef<Path>>(output_path: P, config: MuxerConfig) -> Result<Self> {
+        if config.codec != Codec::Hevc {
+            return Err(Error::Mux(
+                "HEVC muxer only supports HEVC codec".to_string(),
+            ));
+        }
+
+        let ftyp = bmff::ftyp(b"isom", 512, &[b"isom", b"iso2", b"mp41"]);
+        Ok(Self {
+            mdat: MediaData::create(output_path.as_ref(), &ftyp)?,
+            config,
+            sizes: Vec::new(),
+            durations: Vec::new(),
+            sync_samples: Vec::new(),
+        })
+    }
+
+    /// Movie box with a single video track
+    fn build_moov(&self, hvcc: &[u8], data_offset: u32) -> Vec<u8> {
+        let width = self.config.width;
+        let height = self.config.height;
+        let timescale = self.config.fps;
+        let duration = self.durations.iter().sum();
+
+        let mut children = vec![make_box(b"hvcC", hvcc), bmff::colr_nclx(&self.config.color)];
+        if let Some(hdr) = &self.config.hdr {
+            children.extend(bmff::hdr10_metadata(hdr));
+        }
+        let sample_entry = bmff::visual_sample_entry(b"hvc1", width, height, &children);
+        let stbl = bmff::stbl(
+            sample_entry,
+            &self.sizes,
+            &self.durations,
+            &self.sync_samples,
+            data_offset,
+        );
+
+        let minf = make_container(b"minf", &[bmff::vmhd(), bmff::dinf(), stbl]);
+        let mdia = make_container(
+            b"mdia",
+            &[
+                bmff::mdhd(timescale, duration),
+                bmff::hdlr(b"vide", "VideoHandler"),
+                minf,
+            ],
+        );
+        let trak = make_container(b"trak", &[bmff::tkhd(1, duration, width, height), mdia]);
+
+        let mut children = vec![bmff::mvhd(timescale, duration, 2), trak];
+        if !self.config.chapters.is_empty() {
+            children.push(bmff::udta_chapters(&self.config.chapters));
+        }
+        make_container(b"moov", &children)
+    }
+}
+
+impl Muxer for HevcMp4Muxer {
+    fn write_packet(&mut self, packet: &Packet) -> Result<()> {
+        self.sizes.push(self.mdat.write_sample(&packet.data)?);
+        self.durations.push(packet.duration.max(1));
+        if packet.is_keyframe {
+            self.sync_samples.push(self.sizes.len() as u32);
+        }
+        Ok(())
+    }
+
+    fn finalize(self: Box<Self>) -> Result<()> {
+        if self.sizes.is_empty() {
+            return Err(Error::Mux("No frames to write".to_string()));
+        }
+        let hvcc = self
+            .config
+            .codec_config
+            .as_deref()
+            .ok_or_else(|| Error::Mux("HEVC configuration record is missing".to_string()))?;
+        let moov = self.build_moov(hvcc, self.mdat.data_offset());
+        self.mdat.finish(&moov)
+    }
+}
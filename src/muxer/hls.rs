@@ -0,0 +1,400 @@
+//! HTTP Live Streaming (HLS) muxer
+//!
+//! Writes a VOD media playlist (`.m3u8`) and, beside it, segment files named
+//! after the playlist: MPEG-TS segments (`name_00000.ts`), or fMP4 segments
+//! (`name_00000.m4s`) with an initialization segment (`name_init.mp4`).
+//! Segments start at keyframes, so a segment runs past the target duration
+//! until the next keyframe.
+
+use super::avc::AvcStream;
+use super::fmp4::{self, Sample};
+use super::ts::TsWriter;
+use super::{Muxer, MuxerConfig};
+use crate::encoder::Packet;
+use crate::{Codec, Error, HlsSegment, Result};
+use std::fmt::Write as _;
+use std::fs::File;
+use std::path::{Path, PathBuf};
+
+/// Segment duration used when none is set, as recommended for HLS
+pub const DEFAULT_SEGMENT_MS: u32 = 6000;
+
+/// HEVC access unit delimiter (any picture type)
+const HEVC_AUD: [u8; 3] = [0x46, 0x01, 0x50];
+/// H.264 access unit delimiter (any picture type)
+const H264_AUD: [u8; 2] = [0x09, 0xF0];
+
+/// HLS muxer
+pub struct HlsMuxer {
+    playlist_path: PathBuf,
+    /// Segment file names start with this
+    prefix: String,
+    config: MuxerConfig,
+    /// Target segment duration in frame periods
+    target: u64,
+    avc: AvcStream,
+    ts: Option<TsWriter>,
+    /// Packets of the segment being collected
+    pending: Vec<Packet>,
+    /// Start of the pending segment in frame periods
+    start: u64,
+    /// Duration of the pending segment in frame periods
+    duration: u64,
+    /// Duration of each written segment in frame periods
+    segments: Vec<u64>,
+}
+
+impl HlsMuxer {
+    pub fn new<P: AsRef<Path>>(output_path: P, config: MuxerConfig) -> Result<Self> {
+        let ts = match config.hls_segment {
+            HlsSegment::Ts => Some(TsWriter::new(config.codec)?),
+            HlsSegment::Fmp4 if matches!(config.codec, Codec::H264 | Codec::Hevc | Codec::Av1) => {
+                None
+            }
+            HlsSegment::Fmp4 => {
+                return Err(Error::Mux(format!(
+                    "HLS does not support codec {:?}",
+                    config.codec
+                )))
+            }
+        };
+
+        let playlist_path = output_path.as_ref().to_path_buf();
+        let prefix = playlist_path
+            .file_stem()
+            .and_then(|s| s.to_str())
+            .filter(|s| !s.is_empty())
+            .ok_or_else(|| Error::InvalidInput("HLS playlist needs a file name".to_string()))?
+            .to_string();
+
+        // Create the playlist now so an unwritable path fails early
+        File::create(&playlist_path).map_err(Error::Io)?;
+
+        let segment_ms = match config.segment_ms {
+            0 => DEFAULT_SEGMENT_MS,
+            ms => ms,
+        };
+        let target = (segment_ms as u64 * config.fps as u64 / 1000).max(1);
+
+        Ok(Self {
+            playlist_path,
+            prefix,
+            avc: AvcStream::new(config.codec_config.clone(), config.pps.clone()),
+            config,
+            target,
+            ts,
+            pending: Vec::new(),
+            start: 0,
+            duration: 0,
+            segments: Vec::new(),
+        })
+    }
+
+    /// Path of a file beside the playlist
+    fn sibling(&self, name: &str) -> PathBuf {
+        self.playlist_path.with_file_name(name)
+    }
+
+    /// File name of a media segment
+    fn segment_name(&self, index: usize) -> String {
+        let extension = match self.config.hls_segment {
+            HlsSegment::Ts => "ts",
+            HlsSegment::Fmp4 => "m4s",
+        };
+        format!("{}_{:05}.{}", self.prefix, index, extension)
+    }
+
+    /// File name of the fMP4 initialization segment
+    fn init_name(&self) -> String {
+        format!("{}_init.mp4", self.prefix)
+    }
+
+    /// Write the pending packets as the next segment
+    fn write_segment(&mut self) -> Result<()> {
+        let packets = std::mem::take(&mut self.pending);
+        let index = self.segments.len();
+
+        let data = match &mut self.ts {
+            Some(ts) => {
+                let parameter_sets = match self.config.codec {
+                    Codec::H264 => self.avc.parameter_sets(),
+                    _ => hvcc_parameter_sets(self.config.codec_config.as_deref().unwrap_or(&[])),
+                };
+                let aud: &[u8] = match self.config.codec {
+                    Codec::H264 => &H264_AUD,
+                    _ => &HEVC_AUD,
+                };
+
+                let mut data = ts.tables();
+                let mut time = self.start;
+                for packet in &packets {
+                    // Annex B with a delimiter, and the parameter sets
+                    // before each keyframe
+                    let mut access_unit = annex_b(&[aud]);
+                    if packet.is_keyframe {
+                        access_unit.extend(annex_b(&parameter_sets));
+                    }
+                    access_unit.extend(annex_b(&length_prefixed_nals(&packet.data)));
+
+                    let time_90k = time * 90_000 / self.config.fps as u64;
+                    data.extend(ts.frame(&access_unit, time_90k, packet.is_keyframe));
+                    time += packet.duration.max(1) as u64;
+                }
+                data
+            }
+            None => {
+                if index == 0 {
+                    let record = fmp4::config_record(&self.config, &self.avc, packets.first())?;
+                    let entry = fmp4::sample_entry(
+                        self.config.codec,
+                        self.config.width,
+                        self.config.height,
+                        &record,
+                        &self.config.color,
+                        self.config.hdr.as_ref(),
+                    )?;
+                    let init = fmp4::init_segment(
+                        entry,
+                        self.config.width,
+                        self.config.height,
+                        self.config.fps,
+                    );
+                    std::fs::write(self.sibling(&self.init_name()), init).map_err(Error::Io)?;
+                }
+
+                let samples: Vec<Sample> = packets
+                    .into_iter()
+                    .map(|packet| Sample::new(self.config.codec, packet))
+                    .collect();
+                fmp4::media_segment(index as u32 + 1, self.start, &samples)
+            }
+        };
+
+        std::fs::write(self.sibling(&self.segment_name(index)), data).map_err(Error::Io)?;
+        self.segments.push(self.duration);
+        self.start += self.duration;
+        self.duration = 0;
+        Ok(())
+    }
+
+    /// Media playlist of the written segments
+    fn playlist(&self) -> String {
+        let fps = self.config.fps as f64;
+        let longest = self.segments.iter().copied().max().unwrap_or(0) as f64 / fps;
+        let version = match self.config.hls_segment {
+            HlsSegment::Ts => 3,
+            HlsSegment::Fmp4 => 7,
+        };
+
+        let mut playlist = String::from("#EXTM3U\n");
+        let _ = writeln!(playlist, "#EXT-X-VERSION:{}", version);
+        let _ = writeln!(playlist, "#EXT-X-TARGETDURATION:{}", longest.ceil() as u64);
+        playlist.push_str("#EXT-X-MEDIA-SEQUENCE:0\n");
+        playlist.push_str("#EXT-X-PLAYLIST-TYPE:VOD\n");
+        playlist.push_str("#EXT-X-INDEPENDENT-SEGMENTS\n");
+        if self.config.hls_segment == HlsSegment::Fmp4 {
+            let _ = writeln!(playlist, "#EXT-X-MAP:URI=\"{}\"", self.init_name());
+        }
+        for (index, &duration) in self.segments.iter().enumerate() {
+            let _ = writeln!(playlist, "#EXTINF:{:.3},", duration as f64 / fps);
+            let _ = writeln!(playlist, "{}", self.segment_name(index));
+        }
+        playlist.push_str("#EXT-X-ENDLIST\n");
+        playlist
+    }
+}
+
+impl Muxer for HlsMuxer {
+    fn write_packet(&mut self, packet: &Packet) -> Result<()> {
+        // H.264 packets without a picture are held for the next frame
+        let packet = match self.config.codec {
+            Codec::H264 => match self.avc.access_unit(packet) {
+                Some(packet) => packet,
+                None => return Ok(()),
+            },
+            _ => packet.clone(),
+        };
+
+        // Segments end at the first keyframe after the target duration
+        if packet.is_keyframe && !self.pending.is_empty() && self.duration >= self.target {
+            self.write_segment()?;
+        }
+        self.duration += packet.duration.max(1) as u64;
+        self.pending.push(packet);
+        Ok(())
+    }
+
+    fn finalize(mut self: Box<Self>) -> Result<()> {
+        if !self.pending.is_empty() {
+            self.write_segment()?;
+        }
+        if self.segments.is_empty() {
+            return Err(Error::Mux("No frames to write".to_string()));
+        }
+        std::fs::write(&self.playlist_path, self.playlist()).map_err(Error::Io)
+    }
+}
+
+/// NAL units of a length-prefixed access unit
+fn length_prefixed_nals(data: &[u8]) -> Vec<&[u8]> {
+    let mut nals = Vec::new();
+    let mut rest = data;
+    while rest.len() >= 4 {
+        let len = u32::from_be_bytes([rest[0], rest[1], rest[2], rest[3]]) as usize;
+        let Some(nal) = rest.get(4..4 + len) else {
+            break;
+        };
+        nals.push(nal);
+        rest = &rest[4 + len..];
+    }
+    nals
+}
+
+/// Annex B stream of NAL units
+fn annex_b(nals: &[&[u8]]) -> Vec<u8> {
+    let mut data = Vec::new();
+    for nal in nals {
+        data.extend_from_slice(&[0, 0, 0, 1]);
+        data.extend_from_slice(nal);
+    }
+    data
+}
+
+/// Parameter sets (VPS, SPS and PPS) of an `hvcC` record
+fn hvcc_parameter_sets(hvcc: &[u8]) -> Vec<&[u8]> {
+    let mut nals = Vec::new();
+    let Some(&count) = hvcc.get(22) else {
+        return nals;
+    };
+    let mut at = 23;
+    for _ in 0..count {
+        let Some(header) = hvcc.get(at..at + 3) else {
+            break;
+        };
+        let nal_count = u16::from_be_bytes([header[1], header[2]]);
+        at += 3;
+        for _ in 0..nal_count {
+            let Some(len) = hvcc.get(at..at + 2) else {
+                return nals;
+            };
+            let len = u16::from_be_bytes([len[0], len[1]]) as usize;
+            let Some(nal) = hvcc.get(at + 2..at + 2 + len) else {
+                return nals;
+            };
+            nals.push(nal);
+            at += 2 + len;
+        }
+    }
+    nals
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colorspace::ColorSpace;
+    use crate::{Mp4Layout, OutputMode};
+
+    fn config(hls_segment: HlsSegment) -> MuxerConfig {
+        MuxerConfig {
+            width: 320,
+            height: 240,
+            fps: 30,
+            codec: Codec::Hevc,
+            codec_config: Some(hvcc()),
+            pps: None,
+            alpha: false,
+            hls_segment,
+            segment_ms: 1000,
+            mp4_layout: Mp4Layout::Faststart,
+            output_mode: OutputMode::Single,
+            hdr: None,
+            color: ColorSpace::default(),
+            chapters: Vec::new(),
+        }
+    }
+
+    /// Configuration record with one VPS, SPS and PPS
+    fn hvcc() -> Vec<u8> {
+        let mut record = vec![1; 22];
+        record.push(3);
+        for nal in [&[0x40, 0x01][..], &[0x42, 0x01, 0x01], &[0x44, 0x01]] {
+            record.push(0x80 | (nal[0] >> 1));
+            record.extend_from_slice(&1u16.to_be_bytes());
+            record.extend_from_slice(&(nal.len() as u16).to_be_bytes());
+            record.extend_from_slice(nal);
+        }
+        record
+    }
+
+    fn packet(is_keyframe: bool, duration: u32) -> Packet {
+        Packet {
+            data: vec![0, 0, 0, 3, 0x26, 0x01, 0xAF],
+            pts: 0,
+            dts: 0,
+            is_keyframe,
+            duration,
+            alpha: None,
+        }
+    }
+
+    fn write(muxer: HlsMuxer) {
+        let mut muxer = Box::new(muxer);
+        // Keyframes every 20 frames; one second is 30 frames
+        for i in 0..70 {
+            muxer.write_packet(&packet(i % 20 == 0, 1)).unwrap();
+        }
+        muxer.finalize().unwrap();
+    }
+
+    #[test]
+    fn test_ts_segments() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("video.m3u8");
+        write(HlsMuxer::new(&path, config(HlsSegment::Ts)).unwrap());
+
+        let playlist = std::fs::read_to_string(&path).unwrap();
+        assert!(playlist.starts_with("#EXTM3U\n#EXT-X-VERSION:3\n"));
+        assert!(playlist.contains("#EXT-X-TARGETDURATION:2\n"));
+        assert!(playlist.contains("#EXTINF:1.333,\nvideo_00000.ts\n"));
+        assert!(playlist.contains("#EXTINF:1.000,\nvideo_00001.ts\n"));
+        assert!(playlist.ends_with("video_00001.ts\n#EXT-X-ENDLIST\n"));
+
+        let segment = std::fs::read(dir.path().join("video_00001.ts")).unwrap();
+        assert_eq!(segment.len() % 188, 0);
+        // The keyframe carries the parameter sets
+        assert!(segment.windows(6).any(|w| w == [0, 0, 0, 1, 0x40, 0x01]));
+    }
+
+    #[test]
+    fn test_fmp4_segments() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("video.m3u8");
+        write(HlsMuxer::new(&path, config(HlsSegment::Fmp4)).unwrap());
+
+        let playlist = std::fs::read_to_string(&path).unwrap();
+        assert!(playlist.contains("#EXT-X-VERSION:7\n"));
+        assert!(playlist.contains("#EXT-X-MAP:URI=\"video_init.mp4\"\n"));
+        assert!(playlist.contains("video_00001.m4s\n"));
+
+        let init = std::fs::read(dir.path().join("video_init.mp4")).unwrap();
+        assert!(init.windows(4).any(|w| w == b"hvcC"));
+        let segment = std::fs::read(dir.path().join("video_00001.m4s")).unwrap();
+        assert_eq!(&segment[4..8], b"moof");
+    }
+
+    #[test]
+    fn test_hvcc_parameter_sets() {
+        let record = hvcc();
+        let nals = hvcc_parameter_sets(&record);
+        assert_eq!(nals.len(), 3);
+        assert_eq!(nals[1], &[0x42, 0x01, 0x01]);
+        assert!(hvcc_parameter_sets(&record[..30]).len() < 3);
+        assert!(hvcc_parameter_sets(&[]).is_empty());
+    }
+
+    #[test]
+    fn test_length_prefixed_nals() {
+        let data = [0, 0, 0, 2, 1, 2, 0, 0, 0, 1, 3, 0, 0];
+        assert_eq!(length_prefixed_nals(&data), vec![&[1, 2][..], &[3]]);
+    }
+}
@@ -0,0 +1,95 @@
+//! Media data written to the file as samples arrive
+//!
+//! Muxers that build their own sample tables stream each sample into a
+//! media data box with a 64-bit size, so neither memory nor the 32-bit box
+//! size limits the output. The size is patched once the samples are known,
+//! the movie box follows, and the faststart pass moves it to the front.
+
+use super::faststart;
+use crate::{Error, Result};
+use std::fs::File;
+use std::io::{BufWriter, Seek, SeekFrom, Write};
+use std::path::{Path, PathBuf};
+
+/// Size of the media data header: size 1, type, then the 64-bit size
+const HEADER_SIZE: u64 = 16;
+
+/// Media data box being written after the file type box
+pub(super) struct MediaData {
+    path: PathBuf,
+    writer: BufWriter<File>,
+    /// Offset of the media data box in the file
+    start: u64,
+    /// Bytes of samples written so far
+    len: u64,
+}
+
+impl MediaData {
+    /// Create the file at `path` with the file type box `ftyp`
+    pub(super) fn create(path: &Path, ftyp: &[u8]) -> Result<Self> {
+        let mut writer = BufWriter::new(File::create(path)?);
+        writer.write_all(ftyp)?;
+        writer.write_all(&1u32.to_be_bytes())?;
+        writer.write_all(b"mdat")?;
+        writer.write_all(&HEADER_SIZE.to_be_bytes())?;
+        Ok(Self {
+            path: path.to_path_buf(),
+            writer,
+            start: ftyp.len() as u64,
+            len: 0,
+        })
+    }
+
+    /// Append a sample, returning its size
+    pub(super) fn write_sample(&mut self, sample: &[u8]) -> Result<u32> {
+        let size = u32::try_from(sample.len())
+            .map_err(|_| Error::Mux("Sample exceeds 4 GiB".to_string()))?;
+        self.writer.write_all(sample)?;
+        self.len += sample.len() as u64;
+        Ok(size)
+    }
+
+    /// Offset of the first sample in the file
+    pub(super) fn data_offset(&self) -> u32 {
+        (self.start + HEADER_SIZE) as u32
+    }
+
+    /// Complete the media data box, then write `moov` after it and move it
+    /// to the front
+    pub(super) fn finish(mut self, moov: &[u8]) -> Result<()> {
+        self.writer.write_all(moov)?;
+        let mut file = self.writer.into_inner().map_err(|e| e.into_error())?;
+        file.seek(SeekFrom::Start(self.start + 8))?;
+        file.write_all(&(HEADER_SIZE + self.len).to_be_bytes())?;
+        drop(file);
+        faststart::rewrite_moov(&self.path, None, true)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::muxer::bmff::make_box;
+
+    #[test]
+    fn test_media_data() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("out.mov");
+        let ftyp = make_box(b"ftyp", b"qt  ");
+        let moov = make_box(b"moov", &[]);
+
+        let mut mdat = MediaData::create(&path, &ftyp).unwrap();
+        assert_eq!(mdat.data_offset(), ftyp.len() as u32 + 16);
+        assert_eq!(mdat.write_sample(&[1, 2]).unwrap(), 2);
+        assert_eq!(mdat.write_sample(&[3]).unwrap(), 1);
+        mdat.finish(&moov).unwrap();
+
+        let data = std::fs::read(&path).unwrap();
+        let mut expected = [ftyp, moov].concat();
+        expected.extend_from_slice(&1u32.to_be_bytes());
+        expected.extend_from_slice(b"mdat");
+        expected.extend_from_slice(&19u64.to_be_bytes());
+        expected.extend_from_slice(&[1, 2, 3]);
+        assert_eq!(data, expected);
+    }
+}
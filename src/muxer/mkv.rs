@@ -0,0 +1,128 @@
+//! Matroska (MKV) container muxer
+//!
+//! Matroska carries practically any codec, which suits archives. WebM is a
+//! subset of Matroska, so the file is written by the WebM muxer with the
+//! `matroska` DocType and the codec configuration as CodecPrivate.
+//!
+//! Matroska stores H.264 as length-prefixed access units with the parameter
+//! sets in the track header, so H.264 packets are converted and the file is
+//! started once the parameter sets are known. Like WebM, the file is written
+//! without seeking, so it may also be written to a pipe.
+
+use super::avc::AvcStream;
+use super::webm::WebmMuxer;
+use super::{Muxer, MuxerConfig};
+use crate::encoder::Packet;
+use crate::{Codec, Error, Result};
+use std::fs::File;
+use std::io::Write;
+use std::path::Path;
+
+/// Size of the ProRes frame header (frame size and `icpf`), which
+/// Matroska leaves out of each block
+const PRORES_FRAME_HEADER_SIZE: usize = 8;
+
+/// MKV muxer
+pub struct MkvMuxer {
+    config: MuxerConfig,
+    /// Output of the H.264 muxer until it is created
+    writer: Option<Box<dyn Write + Send>>,
+    /// Created once the codec configuration is known
+    inner: Option<WebmMuxer>,
+    avc: AvcStream,
+}
+
+impl MkvMuxer {
+    pub fn new<P: AsRef<Path>>(output_path: P, config: MuxerConfig) -> Result<Self> {
+        // Create the file now so an unwritable path fails early
+        let file = File::create(output_path.as_ref()).map_err(Error::Io)?;
+        Self::to_writer(Box::new(file), config)
+    }
+
+    /// Create a muxer writing to a writer, such as the input of a process
+    pub(crate) fn to_writer(writer: Box<dyn Write + Send>, config: MuxerConfig) -> Result<Self> {
+        let codec_private =
+            match config.codec {
+                Codec::Png => {
+                    return Err(Error::Mux(
+                        "MKV container does not support PNG; use APNG instead".to_string(),
+                    ))
+                }
+                Codec::Hevc => Some(config.codec_config.clone().ok_or_else(|| {
+                    Error::Mux("HEVC configuration record is missing".to_string())
+                })?),
+                // Sample entry type of the ProRes profile
+                Codec::Prores => config.codec_config.clone(),
+                _ => None,
+            };
+
+        let (writer, inner) = if config.codec == Codec::H264 {
+            (Some(writer), None)
+        } else {
+            let inner = WebmMuxer::create(writer, config.clone(), b"matroska", codec_private)?;
+            (None, Some(inner))
+        };
+
+        Ok(Self {
+            avc: AvcStream::new(config.codec_config.clone(), config.pps.clone()),
+            config,
+            writer,
+            inner,
+        })
+    }
+
+    /// Muxer of codecs whose configuration is known from the start
+    fn muxer(&mut self) -> Result<&mut WebmMuxer> {
+        self.inner
+            .as_mut()
+            .ok_or_else(|| Error::Mux("MKV muxer was not started".to_string()))
+    }
+}
+
+impl Muxer for MkvMuxer {
+    fn write_packet(&mut self, packet: &Packet) -> Result<()> {
+        match self.config.codec {
+            Codec::H264 => {
+                let Some(packet) = self.avc.access_unit(packet) else {
+                    return Ok(());
+                };
+                let inner = match &mut self.inner {
+                    Some(inner) => inner,
+                    None => {
+                        let record = self.avc.config_record()?;
+                        let writer = self
+                            .writer
+                            .take()
+                            .ok_or_else(|| Error::Mux("MKV muxer was not started".to_string()))?;
+                        self.inner.insert(WebmMuxer::create(
+                            writer,
+                            self.config.clone(),
+                            b"matroska",
+                            Some(record),
+                        )?)
+                    }
+                };
+                inner.write_packet(&packet)
+            }
+            Codec::Prores => {
+                let data = packet
+                    .data
+                    .get(PRORES_FRAME_HEADER_SIZE..)
+                    .ok_or_else(|| Error::Mux("Truncated ProRes frame".to_string()))?;
+                let packet = Packet {
+                    data: data.to_vec(),
+                    ..packet.clone()
+                };
+                self.muxer()?.write_packet(&packet)
+            }
+            _ => self.muxer()?.write_packet(packet),
+        }
+    }
+
+    fn finalize(mut self: Box<Self>) -> Result<()> {
+        match self.inner.take() {
+            Some(inner) => Box::new(inner).finalize(),
+            None => Err(Error::Mux("No frames to write".to_string())),
+        }
+    }
+}
@@ -1,10 +1,28 @@
 //! Video container muxers
 
+pub mod apng;
+mod avc;
+pub mod avif;
+mod bmff;
+pub mod dash;
+mod faststart;
+mod fmp4;
+pub mod fragmented_mp4;
+pub mod hevc_mp4;
+pub mod hls;
+mod mdat;
+pub mod mkv;
+pub mod mov;
 pub mod mp4;
+pub(crate) mod obu;
+pub mod segments;
+mod ts;
 pub mod webm;
 
+use crate::colorspace::ColorSpace;
 use crate::encoder::Packet;
-use crate::{Codec, Container, Result};
+use crate::hdr::Hdr10;
+use crate::{Chapter, Codec, Container, HlsSegment, Mp4Layout, OutputMode, Result};
 use std::path::Path;
 
 /// Video muxer trait
@@ -27,10 +45,28 @@ pub struct MuxerConfig {
     pub fps: u32,
     /// Video codec
     pub codec: Codec,
-    /// Codec-specific configuration data (SPS for H.264)
+    /// Codec-specific configuration data (SPS for H.264, `hvcC` record for
+    /// HEVC, sample entry type for ProRes)
     pub codec_config: Option<Vec<u8>>,
     /// Picture Parameter Set (PPS for H.264)
     pub pps: Option<Vec<u8>>,
+    /// Packets carry an alpha channel (VP8 and VP9 in WebM)
+    pub alpha: bool,
+    /// Segment format of HLS output
+    pub hls_segment: HlsSegment,
+    /// Target duration of streaming segments in milliseconds (0 for the
+    /// default)
+    pub segment_ms: u32,
+    /// Layout of MP4 output
+    pub mp4_layout: Mp4Layout,
+    /// Whether the video is written as one file or as segment files
+    pub output_mode: OutputMode,
+    /// HDR10 metadata tagged in the container (None for SDR)
+    pub hdr: Option<Hdr10>,
+    /// Color description tagged in the container
+    pub color: ColorSpace,
+    /// Chapter markers, written by the MP4, WebM and MKV muxers
+    pub chapters: Vec<Chapter>,
 }
 
 /// Create a muxer for the specified container format
@@ -40,7 +76,22 @@ pub fn create_muxer<P: AsRef<Path>>(
     config: MuxerConfig,
 ) -> Result<Box<dyn Muxer>> {
     match container {
+        _ if config.output_mode == OutputMode::Segments => Ok(Box::new(
+            segments::SegmentMuxer::new(container, output_path, config)?,
+        )),
+        Container::Mp4 if config.mp4_layout == Mp4Layout::Fragmented => Ok(Box::new(
+            fragmented_mp4::FragmentedMp4Muxer::new(output_path, config)?,
+        )),
+        Container::Mp4 if config.codec == Codec::Hevc => {
+            Ok(Box::new(hevc_mp4::HevcMp4Muxer::new(output_path, config)?))
+        }
         Container::Mp4 => Ok(Box::new(mp4::Mp4Muxer::new(output_path, config)?)),
         Container::WebM => Ok(Box::new(webm::WebmMuxer::new(output_path, config)?)),
+        Container::Avif => Ok(Box::new(avif::AvifMuxer::new(output_path, config)?)),
+        Container::Apng => Ok(Box::new(apng::ApngMuxer::new(output_path, config)?)),
+        Container::Mov => Ok(Box::new(mov::MovMuxer::new(output_path, config)?)),
+        Container::Mkv => Ok(Box::new(mkv::MkvMuxer::new(output_path, config)?)),
+        Container::Hls => Ok(Box::new(hls::HlsMuxer::new(output_path, config)?)),
+        Container::Dash => Ok(Box::new(dash::DashMuxer::new(output_path, config)?)),
     }
 }
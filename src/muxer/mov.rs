@@ -0,0 +1,151 @@
+//! QuickTime (MOV) muxer
+//!
+//! Editing tools such as Final Cut Pro and Premiere expect ProRes in a
+//! QuickTime file, and some reject the generic MP4 brands for H.264 and HEVC
+//! too. The layout follows the HEVC MP4 muxer, with the `qt  ` brand and the
+//! color and field boxes that QuickTime sample entries carry.
+
+use super::avc::AvcStream;
+use super::bmff::{self, make_box, make_container};
+use super::mdat::MediaData;
+use super::{Muxer, MuxerConfig};
+use crate::encoder::Packet;
+use crate::{Codec, Error, Result};
+use std::path::Path;
+
+/// QuickTime muxer
+///
+/// Samples are written to the file as they arrive; only their sizes and
+/// durations are kept for the sample tables.
+pub struct MovMuxer {
+    config: MuxerConfig,
+    mdat: MediaData,
+    sizes: Vec<u32>,
+    /// Duration of each sample in frame periods
+    durations: Vec<u32>,
+    sync_samples: Vec<u32>,
+    avc: AvcStream,
+}
+
+impl MovMuxer {
+    pub fn new<P: AsRef<Path>>(output_path: P, config: MuxerConfig) -> Result<Self> {
+        if !matches!(config.codec, Codec::Prores | Codec::H264 | Codec::Hevc) {
+            return Err(Error::Mux(
+                "MOV container only supports ProRes, H.264 and HEVC codecs".to_string(),
+            ));
+        }
+
+        let ftyp = bmff::ftyp(b"qt  ", 0x0200, &[b"qt  "]);
+        Ok(Self {
+            mdat: MediaData::create(output_path.as_ref(), &ftyp)?,
+            avc: AvcStream::new(config.codec_config.clone(), config.pps.clone()),
+            config,
+            sizes: Vec::new(),
+            durations: Vec::new(),
+            sync_samples: Vec::new(),
+        })
+    }
+
+    /// Sample entry of the track
+    ///
+    /// The ProRes encoder gives the sample entry type of its profile; H.264
+    /// and HEVC carry their parameter sets in a configuration box.
+    fn sample_entry(&self) -> Result<Vec<u8>> {
+        let (kind, config_box) = match self.config.codec {
+            Codec::H264 => (
+                *b"avc1",
+                Some(make_box(b"avcC", &self.avc.config_record()?)),
+            ),
+            Codec::Hevc => {
+                let hvcc = self.config.codec_config.as_deref().ok_or_else(|| {
+                    Error::Mux("HEVC configuration record is missing".to_string())
+                })?;
+                (*b"hvc1", Some(make_box(b"hvcC", hvcc)))
+            }
+            _ => match self.config.codec_config.as_deref() {
+                Some(&[a, b, c, d]) => ([a, b, c, d], None),
+                _ => return Err(Error::Mux("ProRes profile is missing".to_string())),
+            },
+        };
+
+        // Primaries, transfer and matrix (QuickTime's nclc has no range
+        // flag; players read the range from the bitstream), HDR10 mastering
+        // metadata, and progressive frames
+        let color = &self.config.color;
+        let mut colr = b"nclc".to_vec();
+        for index in [color.primaries, color.transfer, color.matrix] {
+            colr.extend_from_slice(&(index as u16).to_be_bytes());
+        }
+        let mut children: Vec<Vec<u8>> = config_box.into_iter().collect();
+        children.push(make_box(b"colr", &colr));
+        if let Some(hdr) = &self.config.hdr {
+            children.extend(bmff::hdr10_metadata(hdr));
+        }
+        children.push(make_box(b"fiel", &[1, 0]));
+
+        Ok(bmff::visual_sample_entry(
+            &kind,
+            self.config.width,
+            self.config.height,
+            &children,
+        ))
+    }
+
+    /// Movie box with a single video track
+    fn build_moov(&self, sample_entry: &[u8], data_offset: u32) -> Vec<u8> {
+        let width = self.config.width;
+        let height = self.config.height;
+        let timescale = self.config.fps;
+        let duration = self.durations.iter().sum();
+
+        let stbl = bmff::stbl(
+            sample_entry.to_vec(),
+            &self.sizes,
+            &self.durations,
+            &self.sync_samples,
+            data_offset,
+        );
+
+        let minf = make_container(b"minf", &[bmff::vmhd(), bmff::dinf(), stbl]);
+        let mdia = make_container(
+            b"mdia",
+            &[
+                bmff::mdhd(timescale, duration),
+                bmff::hdlr(b"vide", "VideoHandler"),
+                minf,
+            ],
+        );
+        let trak = make_container(b"trak", &[bmff::tkhd(1, duration, width, height), mdia]);
+
+        make_container(b"moov", &[bmff::mvhd(timescale, duration, 2), trak])
+    }
+}
+
+impl Muxer for MovMuxer {
+    fn write_packet(&mut self, packet: &Packet) -> Result<()> {
+        // H.264 packets without a picture are held for the next sample
+        let packet = match self.config.codec {
+            Codec::H264 => match self.avc.access_unit(packet) {
+                Some(packet) => packet,
+                None => return Ok(()),
+            },
+            _ => packet.clone(),
+        };
+
+        self.sizes.push(self.mdat.write_sample(&packet.data)?);
+        self.durations.push(packet.duration.max(1));
+        if packet.is_keyframe {
+            self.sync_samples.push(self.sizes.len() as u32);
+        }
+        Ok(())
+    }
+
+    fn finalize(self: Box<Self>) -> Result<()> {
+        if self.sizes.is_empty() {
+            return Err(Error::Mux("No frames to write".to_string()));
+        }
+        let sample_entry = self.sample_entry()?;
+        let moov = self.build_moov(&sample_entry, self.mdat.data_offset());
+        self.mdat.finish(&moov)
+    }
+}
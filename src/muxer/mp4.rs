@@ -1,20 +1,21 @@
 //! MP4 container muxer
 
-use super::{Muxer, MuxerConfig};
+use super::{bmff, faststart, Muxer, MuxerConfig};
 use crate::encoder::Packet;
-use crate::{Codec, Error, Result};
+use crate::{Codec, Error, Mp4Layout, Result};
 use mp4::{Mp4Config, Mp4Writer, TrackConfig};
 use std::fs::File;
 use std::io::BufWriter;
-use std::path::Path;
+use std::path::{Path, PathBuf};
 
-/// MP4 muxer (H.264 only)
+/// MP4 muxer (H.264 only; HEVC uses `HevcMp4Muxer`)
 pub struct Mp4Muxer {
     writer: Mp4Writer<BufWriter<File>>,
-    #[allow(dead_code)]
+    output_path: PathBuf,
     config: MuxerConfig,
     track_id: u32,
-    sample_count: u32,
+    /// Start of the next sample in frame periods
+    time: u64,
 }
 
 impl Mp4Muxer {
@@ -68,9 +69,10 @@ impl Mp4Muxer {
 
         Ok(Self {
             writer: mp4_writer,
+            output_path: output_path.as_ref().to_path_buf(),
             config,
             track_id,
-            sample_count: 0,
+            time: 0,
         })
     }
 }
@@ -78,8 +80,8 @@ impl Mp4Muxer {
 impl Muxer for Mp4Muxer {
     fn write_packet(&mut self, packet: &Packet) -> Result<()> {
         let sample = mp4::Mp4Sample {
-            start_time: self.sample_count as u64,
-            duration: 1,
+            start_time: self.time,
+            duration: packet.duration.max(1),
             rendering_offset: 0,
             is_sync: packet.is_keyframe,
             bytes: mp4::Bytes::copy_from_slice(&packet.data),
@@ -89,7 +91,7 @@ impl Muxer for Mp4Muxer {
             .write_sample(self.track_id, &sample)
             .map_err(|e| Error::Mux(format!("Failed to write sample: {}", e)))?;
 
-        self.sample_count += 1;
+        self.time += packet.duration.max(1) as u64;
         Ok(())
     }
 
@@ -98,6 +100,18 @@ impl Muxer for Mp4Muxer {
             .write_end()
             .map_err(|e| Error::Mux(format!("Failed to finalize MP4: {}", e)))?;
 
+        // Flush and close the file before its movie box is rewritten
+        self.writer
+            .into_writer()
+            .into_inner()
+            .map_err(|e| Error::Io(e.into_error()))?;
+
+        // The movie box is written last, after the sample tables are known;
+        // the mp4 crate cannot write chapters, so they are added to it here
+        let udta =
+            (!self.config.chapters.is_empty()).then(|| bmff::udta_chapters(&self.config.chapters));
+        let faststart = self.config.mp4_layout == Mp4Layout::Faststart;
+        faststart::rewrite_moov(&self.output_path, udta.as_deref(), faststart)?;
         Ok(())
     }
 }
@@ -0,0 +1,389 @@
+//! AV1 OBU (Open Bitstream Unit) helpers for ISOBMFF-based containers
+
+/// OBU type of a sequence header
+const OBU_SEQUENCE_HEADER: u8 = 1;
+
+/// OBU type of a temporal delimiter
+const OBU_TEMPORAL_DELIMITER: u8 = 2;
+
+/// OBU type of a frame header
+pub(crate) const OBU_FRAME_HEADER: u8 = 3;
+
+/// OBU type of a frame header followed by its tile groups
+pub(crate) const OBU_FRAME: u8 = 6;
+
+/// A single OBU located in a packet
+pub(crate) struct Obu<'a> {
+    /// OBU type
+    pub(crate) kind: u8,
+    /// Complete OBU bytes including the header
+    raw: &'a [u8],
+    /// OBU payload following the header and size field
+    pub(crate) payload: &'a [u8],
+}
+
+/// Split a packet into its OBUs
+///
+/// Returns None if the data is not a sequence of OBUs with size fields.
+pub(crate) fn parse_obus(data: &[u8]) -> Option<Vec<Obu<'_>>> {
+    let mut obus = Vec::new();
+    let mut pos = 0;
+
+    while pos < data.len() {
+        let header = data[pos];
+        let kind = (header >> 3) & 0x0F;
+        let has_extension = header & 0x04 != 0;
+        let has_size = header & 0x02 != 0;
+        if !has_size {
+            return None;
+        }
+
+        let mut offset = pos + 1 + has_extension as usize;
+        let (size, len) = read_leb128(data.get(offset..)?)?;
+        offset += len;
+
+        let end = offset.checked_add(size as usize)?;
+        let payload = data.get(offset..end)?;
+
+        obus.push(Obu {
+            kind,
+            raw: &data[pos..end],
+            payload,
+        });
+        pos = end;
+    }
+
+    Some(obus)
+}
+
+/// Read an unsigned LEB128 value, returning the value and its length in bytes
+pub(crate) fn read_leb128(data: &[u8]) -> Option<(u64, usize)> {
+    let mut value = 0u64;
+    for (i, byte) in data.iter().take(8).enumerate() {
+        value |= ((byte & 0x7F) as u64) << (i * 7);
+        if byte & 0x80 == 0 {
+            return Some((value, i + 1));
+        }
+    }
+    None
+}
+
+/// Remove temporal delimiter OBUs, which ISOBMFF samples must not contain
+pub fn strip_temporal_delimiters(data: &[u8]) -> Vec<u8> {
+    match parse_obus(data) {
+        Some(obus) => obus
+            .iter()
+            .filter(|o| o.kind != OBU_TEMPORAL_DELIMITER)
+            .flat_map(|o| o.raw.iter().copied())
+            .collect(),
+        None => data.to_vec(),
+    }
+}
+
+/// Fields of an AV1 sequence header needed for the codec configuration record
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct SequenceInfo {
+    pub profile: u8,
+    pub level: u8,
+    pub tier: u8,
+    pub high_bitdepth: bool,
+    pub twelve_bit: bool,
+    pub monochrome: bool,
+    pub subsampling_x: bool,
+    pub subsampling_y: bool,
+    pub chroma_sample_position: u8,
+}
+
+impl Default for SequenceInfo {
+    /// 8-bit 4:2:0 main profile, as produced by the rav1e encoder
+    fn default() -> Self {
+        Self {
+            profile: 0,
+            level: 31,
+            tier: 0,
+            high_bitdepth: false,
+            twelve_bit: false,
+            monochrome: false,
+            subsampling_x: true,
+            subsampling_y: true,
+            chroma_sample_position: 0,
+        }
+    }
+}
+
+/// Build an AV1CodecConfigurationRecord (the payload of an `av1C` box)
+///
+/// The sequence header OBU is taken from the given keyframe packet.
+/// Returns None if the packet carries no sequence header.
+pub fn av1_config_record(keyframe: &[u8]) -> Option<Vec<u8>> {
+    let obus = parse_obus(keyframe)?;
+    let seq = obus.iter().find(|o| o.kind == OBU_SEQUENCE_HEADER)?;
+    let info = parse_sequence_header(seq.payload).unwrap_or_default();
+
+    let mut record = vec![
+        0x81, // marker + version 1
+        (info.profile << 5) | (info.level & 0x1F),
+        (info.tier << 7)
+            | ((info.high_bitdepth as u8) << 6)
+            | ((info.twelve_bit as u8) << 5)
+            | ((info.monochrome as u8) << 4)
+            | ((info.subsampling_x as u8) << 3)
+            | ((info.subsampling_y as u8) << 2)
+            | (info.chroma_sample_position & 0x03),
+        0x00, // no initial presentation delay
+    ];
+    record.extend_from_slice(seq.raw);
+
+    Some(record)
+}
+
+/// Parse the fields of a sequence header OBU payload
+///
+/// Returns None for headers using features this parser does not follow
+/// (timing info), in which case callers fall back to the defaults.
+pub fn parse_sequence_header(payload: &[u8]) -> Option<SequenceInfo> {
+    let mut r = BitReader::new(payload);
+
+    let profile = r.bits(3)? as u8;
+    let _still_picture = r.bit()?;
+    let reduced = r.bit()?;
+
+    let (level, tier);
+    if reduced {
+        level = r.bits(5)? as u8;
+        tier = 0;
+    } else {
+        if r.bit()? {
+            // timing_info_present_flag
+            return None;
+        }
+        let initial_display_delay_present = r.bit()?;
+        let op_count = r.bits(5)? + 1;
+
+        let mut first = None;
+        for _ in 0..op_count {
+            let _idc = r.bits(12)?;
+            let op_level = r.bits(5)? as u8;
+            let op_tier = if op_level > 7 { r.bit()? as u8 } else { 0 };
+            if initial_display_delay_present && r.bit()? {
+                r.bits(4)?;
+            }
+            first.get_or_insert((op_level, op_tier));
+        }
+        (level, tier) = first?;
+    }
+
+    let width_bits = r.bits(4)? + 1;
+    let height_bits = r.bits(4)? + 1;
+    r.bits(width_bits)?;
+    r.bits(height_bits)?;
+
+    if !reduced && r.bit()? {
+        // frame_id_numbers_present_flag
+        r.bits(4)?;
+        r.bits(3)?;
+    }
+
+    // use_128x128_superblock, enable_filter_intra, enable_intra_edge_filter
+    r.bits(3)?;
+
+    if !reduced {
+        // interintra, masked compound, warped motion, dual filter
+        r.bits(4)?;
+        let enable_order_hint = r.bit()?;
+        if enable_order_hint {
+            // jnt_comp, ref_frame_mvs
+            r.bits(2)?;
+        }
+        let force_screen_content_tools = if r.bit()? { 2 } else { r.bits(1)? };
+        if force_screen_content_tools > 0 && !r.bit()? {
+            // seq_force_integer_mv
+            r.bits(1)?;
+        }
+        if enable_order_hint {
+            r.bits(3)?;
+        }
+    }
+
+    // enable_superres, enable_cdef, enable_restoration
+    r.bits(3)?;
+
+    // color_config
+    let high_bitdepth = r.bit()?;
+    let twelve_bit = profile == 2 && high_bitdepth && r.bit()?;
+    let monochrome = profile != 1 && r.bit()?;
+
+    let (mut cp, mut tc, mut mc) = (2, 2, 2);
+    if r.bit()? {
+        cp = r.bits(8)?;
+        tc = r.bits(8)?;
+        mc = r.bits(8)?;
+    }
+
+    let (subsampling_x, subsampling_y);
+    let mut chroma_sample_position = 0;
+    if monochrome {
+        subsampling_x = true;
+        subsampling_y = true;
+    } else if cp == 1 && tc == 13 && mc == 0 {
+        // sRGB implies 4:4:4
+        subsampling_x = false;
+        subsampling_y = false;
+    } else {
+        r.bit()?; // color_range
+        match profile {
+            0 => {
+                subsampling_x = true;
+                subsampling_y = true;
+            }
+            1 => {
+                subsampling_x = false;
+                subsampling_y = false;
+            }
+            _ => {
+                if twelve_bit {
+                    subsampling_x = r.bit()?;
+                    subsampling_y = subsampling_x && r.bit()?;
+                } else {
+                    subsampling_x = true;
+                    subsampling_y = false;
+                }
+            }
+        }
+        if subsampling_x && subsampling_y {
+            chroma_sample_position = r.bits(2)? as u8;
+        }
+    }
+
+    Some(SequenceInfo {
+        profile,
+        level,
+        tier,
+        high_bitdepth,
+        twelve_bit,
+        monochrome,
+        subsampling_x,
+        subsampling_y,
+        chroma_sample_position,
+    })
+}
+
+/// MSB-first bit reader
+struct BitReader<'a> {
+    data: &'a [u8],
+    pos: usize,
+}
+
+impl<'a> BitReader<'a> {
+    fn new(data: &'a [u8]) -> Self {
+        Self { data, pos: 0 }
+    }
+
+    fn bit(&mut self) -> Option<bool> {
+        let byte = *self.data.get(self.pos / 8)?;
+        let bit = (byte >> (7 - self.pos % 8)) & 1;
+        self.pos += 1;
+        Some(bit == 1)
+    }
+
+    fn bits(&mut self, n: u32) -> Option<u32> {
+        let mut value = 0;
+        for _ in 0..n {
+            value = (value << 1) | self.bit()? as u32;
+        }
+        Some(value)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Build bytes from (value, bit count) pairs, MSB first
+    fn pack_bits(fields: &[(u32, u32)]) -> Vec<u8> {
+        let mut bits = Vec::new();
+        for &(value, count) in fields {
+            for i in (0..count).rev() {
+                bits.push((value >> i) & 1 == 1);
+            }
+        }
+        bits.chunks(8)
+            .map(|c| {
+                c.iter()
+                    .enumerate()
+                    .fold(0u8, |b, (i, &v)| b | ((v as u8) << (7 - i)))
+            })
+            .collect()
+    }
+
+    /// Reduced still picture sequence header: profile 0, level 8, 64x64, 4:2:0
+    fn reduced_sequence_header() -> Vec<u8> {
+        pack_bits(&[
+            (0, 3),  // seq_profile
+            (1, 1),  // still_picture
+            (1, 1),  // reduced_still_picture_header
+            (8, 5),  // seq_level_idx
+            (5, 4),  // frame_width_bits_minus_1
+            (5, 4),  // frame_height_bits_minus_1
+            (63, 6), // max_frame_width_minus_1
+            (63, 6), // max_frame_height_minus_1
+            (0, 3),  // superblock / filter intra / intra edge
+            (0, 3),  // superres / cdef / restoration
+            (0, 1),  // high_bitdepth
+            (0, 1),  // mono_chrome
+            (0, 1),  // color_description_present_flag
+            (0, 1),  // color_range
+            (1, 2),  // chroma_sample_position
+            (0, 1),  // separate_uv_delta_q
+            (0, 1),  // film_grain_params_present
+        ])
+    }
+
+    fn obu(kind: u8, payload: &[u8]) -> Vec<u8> {
+        let mut data = vec![(kind << 3) | 0x02, payload.len() as u8];
+        data.extend_from_slice(payload);
+        data
+    }
+
+    #[test]
+    fn test_read_leb128() {
+        assert_eq!(read_leb128(&[0x05]), Some((5, 1)));
+        assert_eq!(read_leb128(&[0x80, 0x01]), Some((128, 2)));
+        assert_eq!(read_leb128(&[0x80]), None);
+    }
+
+    #[test]
+    fn test_strip_temporal_delimiters() {
+        let frame = obu(6, &[1, 2, 3]);
+        let mut packet = obu(OBU_TEMPORAL_DELIMITER, &[]);
+        packet.extend(&frame);
+
+        assert_eq!(strip_temporal_delimiters(&packet), frame);
+    }
+
+    #[test]
+    fn test_parse_reduced_sequence_header() {
+        let info = parse_sequence_header(&reduced_sequence_header()).unwrap();
+        assert_eq!(info.profile, 0);
+        assert_eq!(info.level, 8);
+        assert!(!info.high_bitdepth);
+        assert!(info.subsampling_x && info.subsampling_y);
+        assert_eq!(info.chroma_sample_position, 1);
+    }
+
+    #[test]
+    fn test_av1_config_record() {
+        let seq = obu(OBU_SEQUENCE_HEADER, &reduced_sequence_header());
+        let mut packet = obu(OBU_TEMPORAL_DELIMITER, &[]);
+        packet.extend(&seq);
+        packet.extend(obu(6, &[0xAA]));
+
+        let record = av1_config_record(&packet).unwrap();
+        assert_eq!(record[0], 0x81);
+        assert_eq!(record[1], 8);
+        assert_eq!(record[2], 0b0000_1101);
+        assert_eq!(&record[4..], &seq[..]);
+
+        assert!(av1_config_record(&obu(6, &[0xAA])).is_none());
+    }
+}
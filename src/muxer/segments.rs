@@ -0,0 +1,281 @@
+//! Segment file output
+//!
+//! Splits the video into standalone files of the segment duration, each
+//! written by the muxer of the container and starting with a keyframe, and
+//! writes a JSON manifest listing them to the output path, for packagers
+//! that take ready-made chunks instead of HLS or DASH. Segment files are
+//! named after the manifest (`name_00000.mp4`). A segment ends at the first
+//! keyframe from the next multiple of the segment duration, so it only runs
+//! longer when the encoder placed no keyframe there.
+
+use super::hls::DEFAULT_SEGMENT_MS;
+use super::{create_muxer, Muxer, MuxerConfig};
+use crate::encoder::Packet;
+use crate::{Container, Error, OutputMode, Result};
+use std::fmt::Write as _;
+use std::fs::File;
+use std::path::{Path, PathBuf};
+
+/// Segment file muxer
+pub struct SegmentMuxer {
+    manifest_path: PathBuf,
+    /// Segment file names start with this
+    prefix: String,
+    container: Container,
+    /// Configuration of the muxer of each segment
+    config: MuxerConfig,
+    /// Target segment duration in frame periods
+    target: u64,
+    /// Muxer of the segment being written
+    current: Option<Box<dyn Muxer>>,
+    /// Start of the current segment in frame periods
+    start: u64,
+    /// Start of the next packet in frame periods
+    time: u64,
+    /// Start and duration of each written segment in frame periods
+    segments: Vec<(u64, u64)>,
+}
+
+impl SegmentMuxer {
+    pub fn new<P: AsRef<Path>>(
+        container: Container,
+        output_path: P,
+        config: MuxerConfig,
+    ) -> Result<Self> {
+        if !matches!(
+            container,
+            Container::Mp4 | Container::Mov | Container::WebM | Container::Mkv
+        ) {
+            return Err(Error::Mux(format!(
+                "Segment output does not support container {:?}",
+                container
+            )));
+        }
+
+        let manifest_path = output_path.as_ref().to_path_buf();
+        let prefix = manifest_path
+            .file_stem()
+            .and_then(|s| s.to_str())
+            .filter(|s| !s.is_empty())
+            .ok_or_else(|| Error::InvalidInput("Segment manifest needs a file name".to_string()))?
+            .to_string();
+
+        // Create the manifest now so an unwritable path fails early
+        File::create(&manifest_path).map_err(Error::Io)?;
+
+        let segment_ms = match config.segment_ms {
+            0 => DEFAULT_SEGMENT_MS,
+            ms => ms,
+        };
+        let target = (segment_ms as u64 * config.fps as u64 / 1000).max(1);
+
+        Ok(Self {
+            manifest_path,
+            prefix,
+            container,
+            config: MuxerConfig {
+                output_mode: OutputMode::Single,
+                ..config
+            },
+            target,
+            current: None,
+            start: 0,
+            time: 0,
+            segments: Vec::new(),
+        })
+    }
+
+    /// File name of a segment
+    fn segment_name(&self, index: usize) -> String {
+        let extension = match self.container {
+            Container::Mov => "mov",
+            Container::WebM => "webm",
+            Container::Mkv => "mkv",
+            _ => "mp4",
+        };
+        format!("{}_{:05}.{}", self.prefix, index, extension)
+    }
+
+    /// Finalize the segment being written
+    fn finish_segment(&mut self) -> Result<()> {
+        if let Some(muxer) = self.current.take() {
+            muxer.finalize()?;
+            self.segments.push((self.start, self.time - self.start));
+            self.start = self.time;
+        }
+        Ok(())
+    }
+
+    /// JSON manifest of the written segments
+    fn manifest(&self) -> String {
+        let fps = self.config.fps.max(1) as u64;
+        let ms = |frames: u64| frames * 1000 / fps;
+
+        let mut manifest = String::from("{\n");
+        let _ = writeln!(
+            manifest,
+            "  \"container\": \"{}\",",
+            format!("{:?}", self.container).to_lowercase()
+        );
+        let _ = writeln!(
+            manifest,
+            "  \"codec\": \"{}\",",
+            format!("{:?}", self.config.codec).to_lowercase()
+        );
+        let _ = writeln!(manifest, "  \"width\": {},", self.config.width);
+        let _ = writeln!(manifest, "  \"height\": {},", self.config.height);
+        let _ = writeln!(manifest, "  \"fps\": {},", self.config.fps);
+        let _ = writeln!(manifest, "  \"segment_duration_ms\": {},", ms(self.target));
+        manifest.push_str("  \"segments\": [\n");
+        for (index, &(start, duration)) in self.segments.iter().enumerate() {
+            let separator = if index + 1 < self.segments.len() {
+                ","
+            } else {
+                ""
+            };
+            let _ = writeln!(
+                manifest,
+                "    {{\"file\": \"{}\", \"start_ms\": {}, \"duration_ms\": {}}}{}",
+                json_escape(&self.segment_name(index)),
+                ms(start),
+                ms(start + duration) - ms(start),
+                separator
+            );
+        }
+        manifest.push_str("  ]\n}\n");
+        manifest
+    }
+}
+
+impl Muxer for SegmentMuxer {
+    fn write_packet(&mut self, packet: &Packet) -> Result<()> {
+        // Segments end at the first keyframe from the next multiple of the
+        // target duration
+        let boundary = (self.start / self.target + 1) * self.target;
+        if packet.is_keyframe && self.time >= boundary {
+            self.finish_segment()?;
+        }
+
+        if self.current.is_none() {
+            let path = self
+                .manifest_path
+                .with_file_name(self.segment_name(self.segments.len()));
+            self.current = Some(create_muxer(self.container, path, self.config.clone())?);
+        }
+        if let Some(muxer) = &mut self.current {
+            muxer.write_packet(packet)?;
+        }
+        self.time += packet.duration.max(1) as u64;
+        Ok(())
+    }
+
+    fn finalize(mut self: Box<Self>) -> Result<()> {
+        self.finish_segment()?;
+        if self.segments.is_empty() {
+            return Err(Error::Mux("No frames to write".to_string()));
+        }
+        std::fs::write(&self.manifest_path, self.manifest()).map_err(Error::Io)
+    }
+}
+
+/// Text escaped for a JSON string
+fn json_escape(text: &str) -> String {
+    let mut escaped = String::with_capacity(text.len());
+    for c in text.chars() {
+        match c {
+            '"' => escaped.push_str("\\\""),
+            '\\' => escaped.push_str("\\\\"),
+            c if (c as u32) < 0x20 => {
+                let _ = write!(escaped, "\\u{:04x}", c as u32);
+            }
+            c => escaped.push(c),
+        }
+    }
+    escaped
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colorspace::ColorSpace;
+    use crate::{Codec, HlsSegment, Mp4Layout};
+
+    fn config() -> MuxerConfig {
+        MuxerConfig {
+            width: 320,
+            height: 240,
+            fps: 10,
+            codec: Codec::Vp9,
+            codec_config: None,
+            pps: None,
+            alpha: false,
+            hls_segment: HlsSegment::Ts,
+            segment_ms: 1000,
+            mp4_layout: Mp4Layout::Faststart,
+            output_mode: OutputMode::Segments,
+            hdr: None,
+            color: ColorSpace::default(),
+            chapters: Vec::new(),
+        }
+    }
+
+    fn packet(is_keyframe: bool) -> Packet {
+        Packet {
+            data: vec![0x82, 0x49, 0x83],
+            pts: 0,
+            dts: 0,
+            is_keyframe,
+            duration: 1,
+            alpha: None,
+        }
+    }
+
+    #[test]
+    fn test_segments() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("video.json");
+        let mut muxer = Box::new(SegmentMuxer::new(Container::WebM, &path, config()).unwrap());
+        // Keyframes every 10 frames, and one late at frame 25 after a
+        // missing keyframe at frame 20; the next segment ends on the grid
+        for i in 0..35 {
+            muxer
+                .write_packet(&packet(i == 25 || (i % 10 == 0 && i != 20)))
+                .unwrap();
+        }
+        muxer.finalize().unwrap();
+
+        for index in 0..4 {
+            let name = format!("video_{:05}.webm", index);
+            assert!(dir.path().join(&name).is_file(), "{} missing", name);
+        }
+        assert!(!dir.path().join("video_00004.webm").exists());
+
+        let manifest = std::fs::read_to_string(&path).unwrap();
+        assert!(manifest.contains("\"container\": \"webm\""));
+        assert!(manifest.contains("\"segment_duration_ms\": 1000"));
+        assert!(manifest.contains(
+            "{\"file\": \"video_00001.webm\", \"start_ms\": 1000, \"duration_ms\": 1500},"
+        ));
+        assert!(manifest.contains(
+            "{\"file\": \"video_00002.webm\", \"start_ms\": 2500, \"duration_ms\": 500},"
+        ));
+        assert!(manifest.contains(
+            "{\"file\": \"video_00003.webm\", \"start_ms\": 3000, \"duration_ms\": 500}\n"
+        ));
+    }
+
+    #[test]
+    fn test_invalid() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("video.json");
+        assert!(SegmentMuxer::new(Container::Hls, &path, config()).is_err());
+
+        let muxer = SegmentMuxer::new(Container::WebM, &path, config()).unwrap();
+        assert!(Box::new(muxer).finalize().is_err());
+    }
+
+    #[test]
+    fn test_json_escape() {
+        assert_eq!(json_escape("a\"b\\c\n"), "a\\\"b\\\\c\\u000a");
+    }
+}
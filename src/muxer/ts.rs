@@ -0,0 +1,228 @@
+//! MPEG transport stream (TS) packets for HLS segments
+//!
+//! Each segment starts with the program tables so it can be decoded on its
+//! own. Frames are carried in PES packets on a single video stream, which
+//! also carries the program clock.
+
+use crate::{Codec, Error, Result};
+
+/// Size of a transport stream packet
+const PACKET_SIZE: usize = 188;
+/// Size of a packet header
+const HEADER_SIZE: usize = 4;
+
+/// PID of the program association table
+const PAT_PID: u16 = 0x0000;
+/// PID of the program map table
+const PMT_PID: u16 = 0x1000;
+/// PID of the video stream
+const VIDEO_PID: u16 = 0x0100;
+
+/// Stream type of H.264 video
+const STREAM_TYPE_H264: u8 = 0x1B;
+/// Stream type of HEVC video
+const STREAM_TYPE_HEVC: u8 = 0x24;
+
+/// Delay of presentation times after the program clock in 90 kHz units,
+/// giving decoders time to buffer the first frame
+const PRESENTATION_DELAY: u64 = 63_000;
+
+/// Writer of transport stream packets
+pub(super) struct TsWriter {
+    stream_type: u8,
+    /// Continuity counters of the PAT, PMT and video PIDs
+    continuity: [u8; 3],
+}
+
+impl TsWriter {
+    pub fn new(codec: Codec) -> Result<Self> {
+        let stream_type = match codec {
+            Codec::H264 => STREAM_TYPE_H264,
+            Codec::Hevc => STREAM_TYPE_HEVC,
+            _ => {
+                return Err(Error::Mux(format!(
+                    "MPEG-TS does not support codec {:?}",
+                    codec
+                )))
+            }
+        };
+        Ok(Self {
+            stream_type,
+            continuity: [0; 3],
+        })
+    }
+
+    /// Program association and program map tables
+    pub fn tables(&mut self) -> Vec<u8> {
+        // Program 1 with its map on PMT_PID
+        let mut pat = vec![0x00, 0xB0, 13, 0x00, 0x01, 0xC1, 0x00, 0x00, 0x00, 0x01];
+        pat.extend_from_slice(&(0xE000 | PMT_PID).to_be_bytes());
+        let mut out = self.section(PAT_PID, pat);
+
+        // The video stream, which also carries the program clock
+        let mut pmt = vec![0x02, 0xB0, 18, 0x00, 0x01, 0xC1, 0x00, 0x00];
+        pmt.extend_from_slice(&(0xE000 | VIDEO_PID).to_be_bytes());
+        pmt.extend_from_slice(&[0xF0, 0x00, self.stream_type]);
+        pmt.extend_from_slice(&(0xE000 | VIDEO_PID).to_be_bytes());
+        pmt.extend_from_slice(&[0xF0, 0x00]);
+        out.extend(self.section(PMT_PID, pmt));
+        out
+    }
+
+    /// PES packet of an Annex B access unit shown at `time` in 90 kHz units
+    pub fn frame(&mut self, access_unit: &[u8], time: u64, is_keyframe: bool) -> Vec<u8> {
+        let pts = time + PRESENTATION_DELAY;
+        // Unbounded video PES with a presentation time only
+        let mut pes = vec![0x00, 0x00, 0x01, 0xE0, 0x00, 0x00, 0x80, 0x80, 5];
+        pes.extend_from_slice(&[
+            0x21 | ((pts >> 29) & 0x0E) as u8,
+            (pts >> 22) as u8,
+            0x01 | ((pts >> 14) & 0xFE) as u8,
+            (pts >> 7) as u8,
+            0x01 | ((pts << 1) & 0xFE) as u8,
+        ]);
+        pes.extend_from_slice(access_unit);
+
+        let mut out = Vec::new();
+        let mut offset = 0;
+        while offset < pes.len() {
+            // The first packet carries the program clock and marks keyframes
+            let mut field = Vec::new();
+            if offset == 0 {
+                field.push(if is_keyframe { 0x50 } else { 0x10 });
+                field.extend_from_slice(&[
+                    (time >> 25) as u8,
+                    (time >> 17) as u8,
+                    (time >> 9) as u8,
+                    (time >> 1) as u8,
+                    ((time & 1) << 7) as u8 | 0x7E,
+                    0x00,
+                ]);
+            }
+            let room =
+                PACKET_SIZE - HEADER_SIZE - if field.is_empty() { 0 } else { field.len() + 1 };
+            let take = (pes.len() - offset).min(room);
+            let chunk = &pes[offset..offset + take];
+            self.packet(&mut out, VIDEO_PID, offset == 0, field, room - take, chunk);
+            offset += take;
+        }
+        out
+    }
+
+    /// Single packet of a PSI section with its CRC
+    fn section(&mut self, pid: u16, mut section: Vec<u8>) -> Vec<u8> {
+        section.extend_from_slice(&crc32(&section).to_be_bytes());
+        let mut payload = vec![0]; // pointer_field
+        payload.extend(section);
+        payload.resize(PACKET_SIZE - HEADER_SIZE, 0xFF);
+
+        let mut out = Vec::with_capacity(PACKET_SIZE);
+        self.packet(&mut out, pid, true, Vec::new(), 0, &payload);
+        out
+    }
+
+    /// Append a packet with an adaptation field, padded by `stuffing` bytes
+    fn packet(
+        &mut self,
+        out: &mut Vec<u8>,
+        pid: u16,
+        unit_start: bool,
+        mut field: Vec<u8>,
+        mut stuffing: usize,
+        payload: &[u8],
+    ) {
+        let counter = match pid {
+            PAT_PID => &mut self.continuity[0],
+            PMT_PID => &mut self.continuity[1],
+            _ => &mut self.continuity[2],
+        };
+        let continuity = *counter;
+        *counter = (*counter + 1) & 0x0F;
+
+        // Padding goes in the adaptation field, adding one when needed
+        let mut has_field = !field.is_empty();
+        if stuffing > 0 && !has_field {
+            has_field = true;
+            stuffing -= 1;
+            if stuffing > 0 {
+                field.push(0x00);
+                stuffing -= 1;
+            }
+        }
+        field.resize(field.len() + stuffing, 0xFF);
+
+        out.push(0x47);
+        out.extend_from_slice(&(((unit_start as u16) << 14) | pid).to_be_bytes());
+        out.push(if has_field { 0x30 } else { 0x10 } | continuity);
+        if has_field {
+            out.push(field.len() as u8);
+            out.extend(field);
+        }
+        out.extend_from_slice(payload);
+    }
+}
+
+/// CRC-32 of PSI sections (MPEG-2 polynomial, no reflection)
+fn crc32(data: &[u8]) -> u32 {
+    let mut crc = 0xFFFF_FFFFu32;
+    for &byte in data {
+        crc ^= (byte as u32) << 24;
+        for _ in 0..8 {
+            crc = if crc & 0x8000_0000 != 0 {
+                (crc << 1) ^ 0x04C1_1DB7
+            } else {
+                crc << 1
+            };
+        }
+    }
+    crc
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_crc32() {
+        // Program association table written by common muxers
+        let pat = [
+            0x00, 0xB0, 0x0D, 0x00, 0x01, 0xC1, 0x00, 0x00, 0x00, 0x01, 0xF0, 0x00,
+        ];
+        assert_eq!(crc32(&pat), 0x2AB1_04B2);
+    }
+
+    #[test]
+    fn test_tables() {
+        let mut writer = TsWriter::new(Codec::H264).unwrap();
+        let tables = writer.tables();
+        assert_eq!(tables.len(), 2 * PACKET_SIZE);
+        assert_eq!(&tables[0..4], &[0x47, 0x40, 0x00, 0x10]);
+        assert_eq!(
+            &tables[PACKET_SIZE..PACKET_SIZE + 4],
+            &[0x47, 0x50, 0x00, 0x10]
+        );
+
+        // Continuity counters advance per PID
+        let tables = writer.tables();
+        assert_eq!(tables[3], 0x11);
+
+        assert!(TsWriter::new(Codec::Av1).is_err());
+    }
+
+    #[test]
+    fn test_frame() {
+        let mut writer = TsWriter::new(Codec::Hevc).unwrap();
+        for size in [10, 170, 171, 400] {
+            let data = writer.frame(&vec![7; size], 9000, true);
+            assert_eq!(data.len() % PACKET_SIZE, 0, "size {}", size);
+            for packet in data.chunks(PACKET_SIZE) {
+                assert_eq!(packet[0], 0x47);
+            }
+            // Random access and program clock in the first packet
+            assert_eq!(data[3] & 0x30, 0x30);
+            assert_eq!(data[5], 0x50);
+            // The payload ends the last packet
+            assert_eq!(data[data.len() - 1], 7);
+        }
+    }
+}
@@ -1,43 +1,67 @@
 //! WebM container muxer
 
 use super::{Muxer, MuxerConfig};
+use crate::colorspace::ColorSpace;
 use crate::encoder::Packet;
-use crate::{Codec, Error, Result};
+use crate::hdr::{self, Hdr10};
+use crate::{Chapter, Codec, Error, Result};
 use std::fs::File;
 use std::io::{BufWriter, Write};
 use std::path::Path;
 
 /// WebM muxer using simple EBML writing
+///
+/// Elements are written in order with unknown sizes, never seeking back, so
+/// the output may be a pipe.
 pub struct WebmMuxer {
-    writer: BufWriter<File>,
+    writer: BufWriter<Box<dyn Write + Send>>,
     config: MuxerConfig,
+    /// EBML DocType (`webm`, or `matroska` for MKV files)
+    doc_type: &'static [u8],
+    /// CodecPrivate data of the track
+    codec_private: Option<Vec<u8>>,
     cluster_start: u64,
     timecode: u64,
-    frame_duration_ms: u64,
+    /// Timecode of the previous block, referenced by non-keyframe groups
+    previous_timecode: u64,
+    /// Start of the next block in frame periods
+    frames: u64,
     cluster_open: bool,
     header_written: bool,
 }
 
 impl WebmMuxer {
     pub fn new<P: AsRef<Path>>(output_path: P, config: MuxerConfig) -> Result<Self> {
-        // WebM only supports AV1 (and VP8/VP9, but we only implement AV1)
-        if config.codec != Codec::Av1 {
+        // WebM supports AV1, VP9 and VP8
+        if !matches!(config.codec, Codec::Av1 | Codec::Vp9 | Codec::Vp8) {
             return Err(Error::Mux(
-                "WebM container only supports AV1 codec".to_string(),
+                "WebM container only supports AV1, VP9 and VP8 codecs".to_string(),
             ));
         }
 
         let file = File::create(output_path.as_ref()).map_err(Error::Io)?;
-        let writer = BufWriter::new(file);
+        Self::create(Box::new(file), config, b"webm", None)
+    }
 
-        let frame_duration_ms = 1000 / config.fps as u64;
+    /// Create a muxer writing Matroska with the DocType `doc_type` to a
+    /// writer, without checking that WebM supports the codec
+    pub(super) fn create(
+        writer: Box<dyn Write + Send>,
+        config: MuxerConfig,
+        doc_type: &'static [u8],
+        codec_private: Option<Vec<u8>>,
+    ) -> Result<Self> {
+        let writer = BufWriter::new(writer);
 
         let mut muxer = Self {
             writer,
             config,
+            doc_type,
+            codec_private,
             cluster_start: 0,
             timecode: 0,
-            frame_duration_ms,
+            previous_timecode: 0,
+            frames: 0,
             cluster_open: false,
             header_written: false,
         };
@@ -61,6 +85,11 @@ impl WebmMuxer {
         // Tracks
         self.write_ebml_element(0x1654AE6B, &self.create_tracks())?;
 
+        // Chapters
+        if !self.config.chapters.is_empty() {
+            self.write_ebml_element(0x1043A770, &create_chapters(&self.config.chapters))?;
+        }
+
         self.header_written = true;
         Ok(())
     }
@@ -76,8 +105,8 @@ impl WebmMuxer {
         data.extend(encode_ebml_element(0x42F2, &[4]));
         // EBMLMaxSizeLength = 8
         data.extend(encode_ebml_element(0x42F3, &[8]));
-        // DocType = "webm"
-        data.extend(encode_ebml_element(0x4282, b"webm"));
+        // DocType
+        data.extend(encode_ebml_element(0x4282, self.doc_type));
         // DocTypeVersion = 4
         data.extend(encode_ebml_element(0x4287, &[4]));
         // DocTypeReadVersion = 2
@@ -118,8 +147,24 @@ impl WebmMuxer {
         data.extend(encode_ebml_element(0x73C5, &encode_uint(1)));
         // TrackType = 1 (video)
         data.extend(encode_ebml_element(0x83, &[1]));
-        // CodecID = "V_AV1"
-        data.extend(encode_ebml_element(0x86, b"V_AV1"));
+        // CodecID
+        let codec_id: &[u8] = match self.config.codec {
+            Codec::Vp9 => b"V_VP9",
+            Codec::Vp8 => b"V_VP8",
+            Codec::H264 => b"V_MPEG4/ISO/AVC",
+            Codec::Hevc => b"V_MPEGH/ISO/HEVC",
+            Codec::Prores => b"V_PRORES",
+            _ => b"V_AV1",
+        };
+        data.extend(encode_ebml_element(0x86, codec_id));
+        if let Some(codec_private) = &self.codec_private {
+            // CodecPrivate
+            data.extend(encode_ebml_element(0x63A2, codec_private));
+        }
+        if self.config.alpha {
+            // MaxBlockAdditionID = 1 (alpha channel)
+            data.extend(encode_ebml_element(0x55EE, &[1]));
+        }
         // Video settings
         data.extend(encode_ebml_element(0xE0, &self.create_video_settings()));
 
@@ -139,6 +184,15 @@ impl WebmMuxer {
             0xBA,
             &encode_uint(self.config.height as u64),
         ));
+        if self.config.alpha {
+            // AlphaMode = 1 (BlockAdditional with ID 1 holds the alpha channel)
+            data.extend(encode_ebml_element(0x53C0, &[1]));
+        }
+        // Colour
+        data.extend(encode_ebml_element(
+            0x55B0,
+            &create_colour(&self.config.color, self.config.hdr.as_ref()),
+        ));
 
         data
     }
@@ -163,6 +217,47 @@ impl WebmMuxer {
     }
 
     fn write_simple_block(&mut self, packet: &Packet) -> Result<()> {
+        let block_data = self.create_block(packet, packet.is_keyframe);
+
+        // SimpleBlock element
+        self.write_ebml_element(0xA3, &block_data)?;
+
+        Ok(())
+    }
+
+    /// Write a packet shown for several frames or carrying an alpha channel
+    /// as a BlockGroup, which holds its duration and additions
+    fn write_block_group(&mut self, packet: &Packet, duration_ms: u64) -> Result<()> {
+        let mut group = Vec::new();
+
+        // Block (keyframe flag is not used; non-keyframes reference the
+        // previous block instead)
+        group.extend(encode_ebml_element(0xA1, &self.create_block(packet, false)));
+        // BlockDuration
+        group.extend(encode_ebml_element(0x9B, &encode_uint(duration_ms)));
+        if !packet.is_keyframe {
+            // ReferenceBlock (relative timecode of the previous block)
+            let reference = self.previous_timecode as i64 - self.timecode as i64;
+            group.extend(encode_ebml_element(0xFB, &encode_int(reference)));
+        }
+        if let Some(alpha) = &packet.alpha {
+            // BlockMore: BlockAddID = 1, BlockAdditional = alpha frame
+            let mut more = encode_ebml_element(0xEE, &[1]);
+            more.extend(encode_ebml_element(0xA5, alpha));
+            // BlockAdditions
+            group.extend(encode_ebml_element(
+                0x75A1,
+                &encode_ebml_element(0xA6, &more),
+            ));
+        }
+
+        // BlockGroup element
+        self.write_ebml_element(0xA0, &group)?;
+
+        Ok(())
+    }
+
+    fn create_block(&self, packet: &Packet, keyframe: bool) -> Vec<u8> {
         let relative_timecode = (self.timecode - self.cluster_start) as i16;
 
         let mut block_data = Vec::new();
@@ -175,16 +270,13 @@ impl WebmMuxer {
         block_data.push((relative_timecode & 0xFF) as u8);
 
         // Flags: keyframe if applicable
-        let flags = if packet.is_keyframe { 0x80 } else { 0x00 };
+        let flags = if keyframe { 0x80 } else { 0x00 };
         block_data.push(flags);
 
         // Frame data
         block_data.extend(&packet.data);
 
-        // SimpleBlock element
-        self.write_ebml_element(0xA3, &block_data)?;
-
-        Ok(())
+        block_data
     }
 
     fn write_ebml_id(&mut self, id: u32) -> Result<()> {
@@ -207,14 +299,27 @@ impl WebmMuxer {
 
 impl Muxer for WebmMuxer {
     fn write_packet(&mut self, packet: &Packet) -> Result<()> {
-        // Start a new cluster if needed (e.g., on keyframe or every few seconds)
-        if !self.cluster_open || (packet.is_keyframe && self.timecode > self.cluster_start) {
+        // Start a new cluster if needed (e.g., on keyframe, or when the
+        // relative timecode would overflow after long merged frames)
+        if !self.cluster_open
+            || (packet.is_keyframe && self.timecode > self.cluster_start)
+            || self.timecode - self.cluster_start > i16::MAX as u64
+        {
             self.cluster_open = false;
             self.start_cluster()?;
         }
 
-        self.write_simple_block(packet)?;
-        self.timecode += self.frame_duration_ms;
+        // Timecodes are rounded from the frame position, so frame rates that
+        // do not divide a second do not drift
+        self.frames += packet.duration.max(1) as u64;
+        let duration_ms = self.frames * 1000 / self.config.fps.max(1) as u64 - self.timecode;
+        if packet.duration > 1 || packet.alpha.is_some() {
+            self.write_block_group(packet, duration_ms)?;
+        } else {
+            self.write_simple_block(packet)?;
+        }
+        self.previous_timecode = self.timecode;
+        self.timecode += duration_ms;
 
         Ok(())
     }
@@ -309,6 +414,84 @@ fn encode_ebml_size(size: u64) -> Vec<u8> {
     }
 }
 
+/// Chapters element with one edition holding the chapters in order
+fn create_chapters(chapters: &[Chapter]) -> Vec<u8> {
+    let mut edition = Vec::new();
+    for (i, chapter) in chapters.iter().enumerate() {
+        let mut display = Vec::new();
+        // ChapString
+        display.extend(encode_ebml_element(0x85, chapter.title.as_bytes()));
+        // ChapLanguage = und
+        display.extend(encode_ebml_element(0x437C, b"und"));
+
+        let mut atom = Vec::new();
+        // ChapterUID, counting from 1 so the output is reproducible
+        atom.extend(encode_ebml_element(0x73C4, &encode_uint(i as u64 + 1)));
+        // ChapterTimeStart in nanoseconds
+        atom.extend(encode_ebml_element(
+            0x91,
+            &encode_uint(chapter.start_ms.saturating_mul(1_000_000)),
+        ));
+        // ChapterDisplay
+        atom.extend(encode_ebml_element(0x80, &display));
+
+        // ChapterAtom
+        edition.extend(encode_ebml_element(0xB6, &atom));
+    }
+
+    // EditionEntry
+    encode_ebml_element(0x45B9, &edition)
+}
+
+/// Colour element with the H.273 code points and range of a color space,
+/// and for HDR10 video the mastering display and content light levels
+fn create_colour(color: &ColorSpace, hdr: Option<&Hdr10>) -> Vec<u8> {
+    let mut data = Vec::new();
+
+    // MatrixCoefficients
+    data.extend(encode_ebml_element(0x55B1, &[color.matrix]));
+    // Range (1 = broadcast, 2 = full)
+    data.extend(encode_ebml_element(0x55B9, &[1 + color.full_range as u8]));
+    // TransferCharacteristics
+    data.extend(encode_ebml_element(0x55BA, &[color.transfer]));
+    // Primaries
+    data.extend(encode_ebml_element(0x55BB, &[color.primaries]));
+    let Some(hdr) = hdr else {
+        return data;
+    };
+
+    // MaxCLL and MaxFALL
+    data.extend(encode_ebml_element(
+        0x55BC,
+        &encode_uint(hdr.max_cll as u64),
+    ));
+    data.extend(encode_ebml_element(
+        0x55BD,
+        &encode_uint(hdr.max_fall as u64),
+    ));
+
+    // MasteringMetadata: chromaticities of the red, green and blue
+    // primaries and the white point, then the peak and black luminance
+    let mut mastering = Vec::new();
+    let points = hdr::PRIMARIES.iter().chain([&hdr::WHITE_POINT]);
+    for (i, &(x, y)) in points.enumerate() {
+        let id = 0x55D1 + 2 * i as u32;
+        mastering.extend(encode_ebml_element(id, &x.to_be_bytes()));
+        mastering.extend(encode_ebml_element(id + 1, &y.to_be_bytes()));
+    }
+    mastering.extend(encode_ebml_element(
+        0x55D9,
+        &(hdr.max_luminance as f64).to_be_bytes(),
+    ));
+    mastering.extend(encode_ebml_element(
+        0x55DA,
+        &hdr::MIN_LUMINANCE.to_be_bytes(),
+    ));
+    data.extend(encode_ebml_element(0x55D0, &mastering));
+
+    data
+}
+
 fn encode_ebml_element(id: u32, data: &[u8]) -> Vec<u8> {
     let mut result = encode_ebml_id(id);
     result.extend(encode_ebml_size(data.len() as u64));
@@ -331,3 +514,18 @@ fn encode_uint(value: u64) -> Vec<u8> {
 
     bytes
 }
+
+/// Encode a signed integer in the fewest big-endian two's complement bytes
+fn encode_int(value: i64) -> Vec<u8> {
+    let bytes = value.to_be_bytes();
+    let mut start = 0;
+    while start < 7 {
+        let redundant = (bytes[start] == 0x00 && bytes[start + 1] & 0x80 == 0)
+            || (bytes[start] == 0xFF && bytes[start + 1] & 0x80 != 0);
+        if !redundant {
+            break;
+        }
+        start += 1;
+    }
+    bytes[start..].to_vec()
+}
@@ -0,0 +1,113 @@
+//! Placement of overlays such as captions
+//!
+//! Overlays are positioned by [`Anchor`] inside a safe area: a margin on
+//! each edge given in percent of the frame size. Because the margin scales
+//! with the frame, an overlay keeps the same relative position when the
+//! same slides are rendered at different resolutions.
+
+use crate::Anchor;
+
+/// Default safe-area margin in percent of the frame size
+pub const DEFAULT_SAFE_AREA: u32 = 5;
+
+/// Largest safe-area margin in percent of the frame size
+pub const MAX_SAFE_AREA: u32 = 40;
+
+/// Safe area of a frame in pixels
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct SafeArea {
+    pub left: f32,
+    pub top: f32,
+    pub right: f32,
+    pub bottom: f32,
+}
+
+impl SafeArea {
+    /// Safe area with a margin of `percent` (0 for the default) on each edge
+    pub fn new(width: u32, height: u32, percent: u32) -> Self {
+        let percent = match percent {
+            0 => DEFAULT_SAFE_AREA,
+            p => p.min(MAX_SAFE_AREA),
+        } as f32;
+        let margin_x = width as f32 * percent / 100.0;
+        let margin_y = height as f32 * percent / 100.0;
+
+        Self {
+            left: margin_x,
+            top: margin_y,
+            right: width as f32 - margin_x,
+            bottom: height as f32 - margin_y,
+        }
+    }
+
+    /// Width of the safe area
+    pub fn width(&self) -> f32 {
+        self.right - self.left
+    }
+
+    /// Height of the safe area
+    pub fn height(&self) -> f32 {
+        self.bottom - self.top
+    }
+
+    /// Top-left corner of a box of the given size placed at the anchor
+    ///
+    /// `Anchor::Auto` is treated as bottom center.
+    pub fn place(&self, anchor: Anchor, width: f32, height: f32) -> (f32, f32) {
+        let (h, v) = anchor_fractions(anchor);
+        (
+            self.left + (self.width() - width) * h,
+            self.top + (self.height() - height) * v,
+        )
+    }
+}
+
+/// Horizontal and vertical position of an anchor (0.0 start, 1.0 end)
+fn anchor_fractions(anchor: Anchor) -> (f32, f32) {
+    match anchor {
+        Anchor::TopLeft => (0.0, 0.0),
+        Anchor::TopCenter => (0.5, 0.0),
+        Anchor::TopRight => (1.0, 0.0),
+        Anchor::MiddleLeft => (0.0, 0.5),
+        Anchor::Center => (0.5, 0.5),
+        Anchor::MiddleRight => (1.0, 0.5),
+        Anchor::BottomLeft => (0.0, 1.0),
+        Anchor::Auto | Anchor::BottomCenter => (0.5, 1.0),
+        Anchor::BottomRight => (1.0, 1.0),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_safe_area_default() {
+        let area = SafeArea::new(1000, 500, 0);
+        assert_eq!(area.left, 50.0);
+        assert_eq!(area.top, 25.0);
+        assert_eq!(area.right, 950.0);
+        assert_eq!(area.bottom, 475.0);
+    }
+
+    #[test]
+    fn test_place_corners() {
+        let area = SafeArea::new(1000, 500, 10);
+        assert_eq!(area.place(Anchor::TopLeft, 100.0, 50.0), (100.0, 50.0));
+        assert_eq!(area.place(Anchor::BottomRight, 100.0, 50.0), (800.0, 400.0));
+        assert_eq!(area.place(Anchor::Center, 100.0, 50.0), (450.0, 225.0));
+        assert_eq!(
+            area.place(Anchor::Auto, 100.0, 50.0),
+            area.place(Anchor::BottomCenter, 100.0, 50.0)
+        );
+    }
+
+    #[test]
+    fn test_place_scales_with_resolution() {
+        let small = SafeArea::new(640, 360, 0);
+        let large = SafeArea::new(1920, 1080, 0);
+        let (sx, sy) = small.place(Anchor::TopRight, 64.0, 36.0);
+        let (lx, ly) = large.place(Anchor::TopRight, 192.0, 108.0);
+        assert_eq!((sx * 3.0, sy * 3.0), (lx, ly));
+    }
+}
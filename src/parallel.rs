@@ -0,0 +1,84 @@
+//! Parallel preparation of slides
+//!
+//! Decoding and scaling high-resolution photos takes most of the time of
+//! large slideshows, so slides are prepared on scoped threads, one per core
+//! unless `EncodeOptions::threads` sets the number. Each thread takes the
+//! next slide when it finishes one, as photos differ widely in size.
+
+use std::panic;
+use std::sync::{Mutex, PoisonError};
+use std::thread;
+
+/// Number of threads for a thread option (0 for one per core)
+pub(crate) fn thread_count(threads: u32) -> usize {
+    match threads {
+        0 => thread::available_parallelism().map_or(1, |n| n.get()),
+        threads => threads as usize,
+    }
+}
+
+/// Map items with their index on up to `threads` threads, keeping their
+/// order; a panic in `f` is raised again on the calling thread
+pub(crate) fn map<T, R, F>(items: Vec<T>, threads: usize, f: F) -> Vec<R>
+where
+    T: Send,
+    R: Send,
+    F: Fn(usize, T) -> R + Sync,
+{
+    let threads = threads.clamp(1, items.len().max(1));
+    if threads == 1 {
+        return items
+            .into_iter()
+            .enumerate()
+            .map(|(i, item)| f(i, item))
+            .collect();
+    }
+
+    let queue = Mutex::new(items.into_iter().enumerate());
+    let mut results: Vec<(usize, R)> = thread::scope(|scope| {
+        let workers: Vec<_> = (0..threads)
+            .map(|_| {
+                scope.spawn(|| {
+                    let mut done = Vec::new();
+                    loop {
+                        let next = queue.lock().unwrap_or_else(PoisonError::into_inner).next();
+                        let Some((i, item)) = next else {
+                            return done;
+                        };
+                        done.push((i, f(i, item)));
+                    }
+                })
+            })
+            .collect();
+        workers
+            .into_iter()
+            .flat_map(|worker| worker.join().unwrap_or_else(|e| panic::resume_unwind(e)))
+            .collect()
+    });
+    results.sort_unstable_by_key(|&(i, _)| i);
+    results.into_iter().map(|(_, result)| result).collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_map_keeps_order() {
+        let items: Vec<u32> = (0..100).collect();
+        for threads in [1, 3, 8, 200] {
+            let results = map(items.clone(), threads, |i, item| (i, item * 2));
+            assert_eq!(results.len(), 100);
+            for (i, &(index, value)) in results.iter().enumerate() {
+                assert_eq!((index, value), (i, i as u32 * 2));
+            }
+        }
+        assert!(map(Vec::<u32>::new(), 4, |_, item| item).is_empty());
+    }
+
+    #[test]
+    fn test_thread_count() {
+        assert_eq!(thread_count(3), 3);
+        assert!(thread_count(0) >= 1);
+    }
+}
@@ -0,0 +1,120 @@
+//! Lower priority of encoding work
+//!
+//! With `EncodeOptions::priority`, a job runs on a thread of its own whose
+//! CPU and I/O priority is lowered before any work starts, so background
+//! video generation yields to other work on the host. Lowering the calling
+//! thread instead would be permanent, as unprivileged processes cannot raise
+//! their priority again. On Linux, the threads and ffmpeg processes the job
+//! starts inherit the priority; on macOS and Windows only the job's thread
+//! is lowered.
+
+use crate::Priority;
+use std::panic;
+use std::thread;
+
+/// Nice value of `Priority::Low`
+#[cfg(target_os = "linux")]
+const LOW_NICE: i32 = 10;
+
+/// Nice value of `Priority::Idle`, the lowest
+#[cfg(target_os = "linux")]
+const IDLE_NICE: i32 = 19;
+
+/// Run a job at a priority, on the calling thread for `Priority::Normal`;
+/// a panic of the job is raised again on the calling thread
+pub(crate) fn run<T: Send>(priority: Priority, job: impl FnOnce() -> T + Send) -> T {
+    if priority == Priority::Normal {
+        return job();
+    }
+    thread::scope(|scope| {
+        scope
+            .spawn(|| {
+                lower(priority);
+                job()
+            })
+            .join()
+            .unwrap_or_else(|e| panic::resume_unwind(e))
+    })
+}
+
+/// Lower the CPU priority of the current thread to a nice value, unless it
+/// is already lower, and its I/O priority to the lowest best-effort level or
+/// the idle class; failures leave the priority as it is
+#[cfg(target_os = "linux")]
+fn lower(priority: Priority) {
+    // I/O priority classes, shifted into place as in linux/ioprio.h
+    const IOPRIO_CLASS_BE: libc::c_long = 2 << 13;
+    const IOPRIO_CLASS_IDLE: libc::c_long = 3 << 13;
+    const IOPRIO_WHO_PROCESS: libc::c_long = 1;
+
+    let (nice, ioprio) = match priority {
+        Priority::Normal => return,
+        Priority::Low => (LOW_NICE, IOPRIO_CLASS_BE | 7),
+        Priority::Idle => (IDLE_NICE, IOPRIO_CLASS_IDLE),
+    };
+    // SAFETY: these calls only take integers and change the current thread,
+    // whose id gettid returns
+    unsafe {
+        let tid = libc::gettid();
+        let current = libc::getpriority(libc::PRIO_PROCESS, tid as libc::id_t);
+        libc::setpriority(libc::PRIO_PROCESS, tid as libc::id_t, nice.max(current));
+        libc::syscall(
+            libc::SYS_ioprio_set,
+            IOPRIO_WHO_PROCESS,
+            tid as libc::c_long,
+            ioprio,
+        );
+    }
+}
+
+/// Put the current thread in the background state, with low CPU and
+/// throttled I/O priority
+#[cfg(target_os = "macos")]
+fn lower(priority: Priority) {
+    if priority != Priority::Normal {
+        // SAFETY: the call only takes integers and changes the current thread
+        unsafe {
+            libc::setpriority(libc::PRIO_DARWIN_THREAD, 0, libc::PRIO_DARWIN_BG);
+        }
+    }
+}
+
+/// Put the current thread in background mode, with low CPU, I/O and memory
+/// priority
+#[cfg(target_os = "windows")]
+fn lower(priority: Priority) {
+    use windows::Win32::System::Threading::{
+        GetCurrentThread, SetThreadPriority, THREAD_MODE_BACKGROUND_BEGIN,
+    };
+
+    if priority != Priority::Normal {
+        // SAFETY: the pseudo handle of the current thread is always valid
+        let _ = unsafe { SetThreadPriority(GetCurrentThread(), THREAD_MODE_BACKGROUND_BEGIN) };
+    }
+}
+
+#[cfg(not(any(target_os = "linux", target_os = "macos", target_os = "windows")))]
+fn lower(_priority: Priority) {}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_run() {
+        assert_eq!(run(Priority::Normal, || 1), 1);
+        assert_eq!(run(Priority::Low, || 2), 2);
+    }
+
+    #[cfg(target_os = "linux")]
+    #[test]
+    fn test_lowers_nice() {
+        let nice =
+            || unsafe { libc::getpriority(libc::PRIO_PROCESS, libc::gettid() as libc::id_t) };
+        let before = nice();
+        assert!(run(Priority::Low, nice) >= LOW_NICE.max(before));
+        assert!(run(Priority::Idle, nice) >= IDLE_NICE.max(before));
+        // The calling thread keeps its priority
+        assert_eq!(nice(), before);
+    }
+}
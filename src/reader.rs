@@ -0,0 +1,171 @@
+//! Videos read frame by frame
+//!
+//! A `VideoReader` decodes a video with ffmpeg and yields its frames as RGBA
+//! with their times, for analysis such as diffing or OCR of rendered videos.
+//! Frames are streamed from ffmpeg one at a time, so memory use does not grow
+//! with the length of the video.
+
+use crate::ffmpeg::{find_ffmpeg, path_arg, probe_frame_rate, probe_video_size, ProcessLog};
+use crate::{tonemap, Error, Result, ToneMap};
+use std::io::{ErrorKind, Read};
+use std::path::Path;
+use std::process::{Child, ChildStdout, Command, Stdio};
+
+/// Frame decoded by a [`VideoReader`]
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct VideoFrame {
+    /// Time of the frame in milliseconds
+    pub time_ms: u64,
+    /// 8-bit RGBA pixels, row by row from the top left
+    pub data: Vec<u8>,
+}
+
+/// Reader of the frames of a video
+///
+/// Frames are sampled at a constant rate, the video's own frame rate unless
+/// another is given, so variable frame rate videos repeat or drop frames to
+/// fit it. HDR videos are tone mapped to SDR as in juxtaposed videos.
+pub struct VideoReader {
+    width: u32,
+    height: u32,
+    fps: f64,
+    process: Child,
+    log: ProcessLog,
+    stdout: ChildStdout,
+    frames: u64,
+    done: bool,
+}
+
+impl VideoReader {
+    /// Start decoding a video, at `fps` frames per second (0 for the frame
+    /// rate of the video), with ffmpeg found in PATH unless `ffmpeg_path`
+    /// is set
+    pub fn open<P: AsRef<Path>>(path: P, fps: f64, ffmpeg_path: Option<&str>) -> Result<Self> {
+        let path = path.as_ref();
+        if !path.is_file() {
+            return Err(Error::InvalidInput(format!(
+                "Input not found: {}",
+                path.display()
+            )));
+        }
+        if !fps.is_finite() || fps < 0.0 {
+            return Err(Error::InvalidInput(format!(
+                "Frame rate must not be negative, got {}",
+                fps
+            )));
+        }
+
+        let ffmpeg = find_ffmpeg(ffmpeg_path)?;
+        let (width, height) = probe_video_size(&ffmpeg, path)?;
+        let fps = if fps > 0.0 {
+            fps
+        } else {
+            probe_frame_rate(&ffmpeg, path)?
+        };
+        let mut filter = format!("fps={:.6}", fps);
+        if let Some(tone_map) = tonemap::video_filter(&ffmpeg, path, ToneMap::default()) {
+            filter = format!("{},{}", tone_map, filter);
+        }
+
+        let (mut process, log) = ProcessLog::spawn(
+            Command::new(&ffmpeg)
+                .args(["-v", "error", "-nostdin", "-i"])
+                .arg(path_arg(path))
+                .args(["-map", "0:v:0", "-vf", &filter])
+                .args(["-f", "rawvideo", "-pix_fmt", "rgba", "pipe:1"])
+                .stdout(Stdio::piped()),
+        )?;
+        let stdout = match process.stdout.take() {
+            Some(stdout) => stdout,
+            None => {
+                let _ = process.kill();
+                let _ = process.wait();
+                return Err(Error::Ffmpeg("Failed to read ffmpeg output".to_string()));
+            }
+        };
+
+        Ok(Self {
+            width,
+            height,
+            fps,
+            process,
+            log,
+            stdout,
+            frames: 0,
+            done: false,
+        })
+    }
+
+    /// Width of the frames in pixels
+    pub fn width(&self) -> u32 {
+        self.width
+    }
+
+    /// Height of the frames in pixels
+    pub fn height(&self) -> u32 {
+        self.height
+    }
+
+    /// Rate at which frames are sampled, in frames per second
+    pub fn fps(&self) -> f64 {
+        self.fps
+    }
+
+    /// Decode the next frame, or None after the last one
+    pub fn next_frame(&mut self) -> Result<Option<VideoFrame>> {
+        let mut data = vec![0u8; self.frame_size()];
+        Ok(self
+            .read_frame(&mut data)?
+            .map(|time_ms| VideoFrame { time_ms, data }))
+    }
+
+    /// Size of a frame in bytes
+    pub(crate) fn frame_size(&self) -> usize {
+        self.width as usize * self.height as usize * 4
+    }
+
+    /// Decode the next frame into `data`, of `frame_size` bytes, and return
+    /// its time in milliseconds, or None after the last frame
+    pub(crate) fn read_frame(&mut self, data: &mut [u8]) -> Result<Option<u64>> {
+        if data.len() != self.frame_size() {
+            return Err(Error::InvalidInput(format!(
+                "Frame buffer must have {} bytes for {}x{}, got {}",
+                self.frame_size(),
+                self.width,
+                self.height,
+                data.len()
+            )));
+        }
+        if self.done {
+            return Ok(None);
+        }
+        match self.stdout.read_exact(data) {
+            Ok(()) => {
+                let time_ms = (self.frames as f64 * 1000.0 / self.fps) as u64;
+                self.frames += 1;
+                Ok(Some(time_ms))
+            }
+            Err(e) if e.kind() == ErrorKind::UnexpectedEof => {
+                self.done = true;
+                // A failed decode also ends the output early
+                let status = self.process.wait()?;
+                if !status.success() || self.frames == 0 {
+                    let error = Error::Decode(format!(
+                        "Failed to decode video after {} frames ({})",
+                        self.frames, status
+                    ));
+                    return Err(self.log.error(&mut self.process, error));
+                }
+                Ok(None)
+            }
+            Err(e) => Err(Error::Decode(format!("Failed to read frame: {}", e))),
+        }
+    }
+}
+
+impl Drop for VideoReader {
+    fn drop(&mut self) {
+        let _ = self.process.kill();
+        let _ = self.process.wait();
+    }
+}
@@ -0,0 +1,161 @@
+//! Sessions keeping encoders warm across jobs
+//!
+//! Starting an encoder takes hundreds of milliseconds for the ffmpeg-based
+//! ones, which launch and initialise a process, and some for the native ones,
+//! which dominates short jobs such as thumbnail videos. A session starts a
+//! spare encoder in the background after each job takes one, so the next job
+//! of the same configuration finds it already running.
+
+use crate::encoder::{create_encoder, Encoder, EncoderConfig};
+use crate::{Codec, EncodeOptions, LogLevel, Result};
+use std::fmt;
+use std::sync::{Arc, Mutex, PoisonError};
+use std::thread;
+
+/// Most spare encoders a session keeps; the oldest is stopped for a new one
+const MAX_SPARES: usize = 4;
+
+/// Spare encoder with the codec and configuration it was created for
+type Spare = (Codec, EncoderConfig, Box<dyn Encoder>);
+
+/// Encoders kept warm for the jobs given the session in
+/// `EncodeOptions::session`
+///
+/// Sessions are shared by cloning and may be used by many jobs at once. A
+/// job takes the spare encoder of its codec and configuration if there is
+/// one; the session then starts another for the next job. Spares are stopped
+/// when the last clone is dropped or by `clear`.
+#[derive(Clone, Default)]
+pub struct Session {
+    spares: Arc<Mutex<Vec<Spare>>>,
+}
+
+impl Session {
+    /// Create a session without spare encoders
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Stop the spare encoders, as when jobs of other configurations follow
+    pub fn clear(&self) {
+        self.lock().clear();
+    }
+
+    /// Take the spare encoder of a configuration, or create one, then start
+    /// a spare for the next job in the background
+    fn take(&self, codec: Codec, config: EncoderConfig) -> Result<Box<dyn Encoder>> {
+        let spare = {
+            let mut spares = self.lock();
+            spares
+                .iter()
+                .position(|(c, spare_config, _)| *c == codec && *spare_config == config)
+                .map(|i| spares.remove(i).2)
+        };
+        let encoder = match spare {
+            Some(encoder) => encoder,
+            None => create_encoder(codec, config.clone())?,
+        };
+
+        let spares = Arc::clone(&self.spares);
+        thread::spawn(move || {
+            // Failures surface when a job creates its own encoder
+            if let Ok(encoder) = create_encoder(codec, config.clone()) {
+                let mut spares = spares.lock().unwrap_or_else(PoisonError::into_inner);
+                if spares.len() >= MAX_SPARES {
+                    spares.remove(0);
+                }
+                spares.push((codec, config, encoder));
+            }
+        });
+        Ok(encoder)
+    }
+
+    fn lock(&self) -> std::sync::MutexGuard<'_, Vec<Spare>> {
+        self.spares.lock().unwrap_or_else(PoisonError::into_inner)
+    }
+}
+
+impl fmt::Debug for Session {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str("Session")
+    }
+}
+
+/// Create the encoder of a job, from the session of its options if it has
+/// one; two-pass encoders, tied to their statistics files, are never kept
+pub(crate) fn encoder(options: &EncodeOptions, config: EncoderConfig) -> Result<Box<dyn Encoder>> {
+    let pass = config.two_pass.as_ref().map_or(0, |two_pass| two_pass.pass);
+    let hw_accel = config.hw_accel;
+    let encoder = match &options.session {
+        Some(session) if config.two_pass.is_none() => session.take(options.codec, config)?,
+        _ => create_encoder(options.codec, config)?,
+    };
+
+    let mut attrs = vec![
+        ("codec", format!("{:?}", options.codec).into()),
+        ("encoder", encoder.name().into()),
+        ("hw_accel", format!("{:?}", hw_accel).into()),
+        ("hardware", encoder.hardware().into()),
+    ];
+    if pass > 0 {
+        attrs.push(("pass", (pass as u32).into()));
+    }
+    options.log(LogLevel::Info, "encoder chosen", attrs);
+    Ok(encoder)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::colorspace::ColorSpace;
+    use crate::encoder::{Bitrate, Speed};
+    use crate::{Av1Backend, BitDepth, H264Preset, H264Profile, H264Tune, HwAccel, PixelFormat};
+    use std::time::Duration;
+
+    fn config() -> EncoderConfig {
+        EncoderConfig {
+            width: 64,
+            height: 64,
+            fps: 10,
+            quality: 50,
+            ffmpeg_path: None,
+            speed: Speed::Default,
+            lossless: true,
+            alpha: false,
+            two_pass: None,
+            bitrate: Bitrate::default(),
+            keyframe_interval: 0,
+            forced_keyframes: Vec::new(),
+            pixel_format: PixelFormat::Auto,
+            bit_depth: BitDepth::Eight,
+            hdr: None,
+            color: ColorSpace::default(),
+            hw_accel: HwAccel::Software,
+            threads: 0,
+            av1_backend: Av1Backend::Rav1e,
+            av1_preset: 0,
+            h264_profile: H264Profile::Auto,
+            h264_level: 0,
+            h264_preset: H264Preset::Auto,
+            h264_tune: H264Tune::None,
+            ffmpeg_args: Vec::new(),
+        }
+    }
+
+    #[test]
+    fn test_keeps_spare() {
+        let session = Session::new();
+        session.take(Codec::Png, config()).unwrap();
+        // The spare is started in the background
+        for _ in 0..100 {
+            if !session.lock().is_empty() {
+                break;
+            }
+            thread::sleep(Duration::from_millis(10));
+        }
+        assert_eq!(session.lock().len(), 1);
+
+        session.clear();
+        assert!(session.lock().is_empty());
+    }
+}
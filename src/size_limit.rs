@@ -0,0 +1,209 @@
+//! Output that fits a file size limit
+//!
+//! Mail servers and chat tools reject attachments above a fixed size. A
+//! target size is turned into a bitrate from the length of the video for a
+//! single constrained encode. For a guaranteed fit, the slideshow is
+//! rendered, and when the file is too large it is rendered again with a
+//! lower quality and then a lower resolution until it fits.
+
+use crate::encoder::Bitrate;
+use crate::slideshow::render;
+use crate::{EncodeOptions, Error, Result, SizedSettings, SlideEntry};
+
+/// Size limit of common mail services for attachments (8 MiB)
+pub const EMAIL_MAX_BYTES: u64 = 8 * 1024 * 1024;
+
+/// Lowest quality tried before the resolution is reduced
+const MIN_QUALITY: u8 = 20;
+
+/// Largest quality reduction per attempt
+const MAX_QUALITY_STEP: u8 = 25;
+
+/// Lowest resolution tried, in percent of the first slide
+const MIN_SCALE: u32 = 10;
+
+/// Number of renders before giving up
+const MAX_ATTEMPTS: usize = 10;
+
+/// Bitrate set aside for the audio of a target size in kbit/s, above the
+/// defaults of the AAC and Opus encoders
+const AUDIO_KBPS: u64 = 128;
+
+/// Share of a target size given to the streams, leaving room for container
+/// overhead and rate control overshoot
+const SIZE_MARGIN: f64 = 0.92;
+
+/// Lowest video bitrate a target size may give in kbit/s
+const MIN_VIDEO_KBPS: u64 = 16;
+
+/// Bitrate of a constrained encode fitting `max_bytes` for a video of
+/// `duration_ms`, with room for audio when `has_audio` is set
+///
+/// The average and the peak are both set to the bitrate, so the size stays
+/// close to the target and rarely exceeds it.
+pub(crate) fn fit_bitrate(max_bytes: u64, duration_ms: u64, has_audio: bool) -> Result<Bitrate> {
+    let total_kbps = (max_bytes as f64 * 8.0 / duration_ms.max(1) as f64 * SIZE_MARGIN) as u64;
+    let audio_kbps = if has_audio { AUDIO_KBPS } else { 0 };
+    let video_kbps = total_kbps.saturating_sub(audio_kbps);
+    if video_kbps < MIN_VIDEO_KBPS {
+        return Err(Error::InvalidInput(format!(
+            "Cannot fit {:.1} seconds of video in {} bytes",
+            duration_ms as f64 / 1000.0,
+            max_bytes
+        )));
+    }
+    let kbps = video_kbps.min(u32::MAX as u64) as u32;
+    Ok(Bitrate {
+        target_kbps: kbps,
+        max_kbps: kbps,
+        buffer_kbits: 0,
+    })
+}
+
+/// Create a slideshow no larger than `max_bytes`
+///
+/// Starts with `options.quality` at full resolution. Each attempt that is
+/// too large lowers the quality, down to 20, and then the resolution, in
+/// proportion to how far the file is over the limit. Returns the settings of
+/// the attempt that fit, whose file is left at `options.output_path`.
+pub fn slideshow_within_size(
+    entries: &[SlideEntry],
+    options: &EncodeOptions,
+    max_bytes: u64,
+) -> Result<SizedSettings> {
+    if max_bytes == 0 {
+        return Err(Error::InvalidInput(
+            "Size limit must be greater than 0".to_string(),
+        ));
+    }
+
+    if options.target_size_bytes > 0 {
+        return Err(Error::InvalidInput(
+            "Target size and size limit cannot be combined".to_string(),
+        ));
+    }
+
+    let mut options = options.clone();
+    let mut scale = 100;
+    let mut smallest = u64::MAX;
+
+    for _ in 0..MAX_ATTEMPTS {
+        let rendered = render(entries, &options, scale, false)?;
+        let size = std::fs::metadata(&options.output_path)
+            .map_err(Error::Io)?
+            .len();
+        let settings = SizedSettings {
+            quality: options.quality,
+            scale,
+            width: rendered.width,
+            height: rendered.height,
+            size,
+        };
+        if size <= max_bytes {
+            return Ok(settings);
+        }
+        smallest = smallest.min(size);
+
+        match next_settings(&settings, max_bytes) {
+            Some((quality, next_scale)) => {
+                options.quality = quality;
+                scale = next_scale;
+            }
+            None => break,
+        }
+    }
+
+    let _ = std::fs::remove_file(&options.output_path);
+    Err(Error::InvalidInput(format!(
+        "Cannot fit the slideshow in {} bytes; the smallest attempt was {} bytes",
+        max_bytes, smallest
+    )))
+}
+
+/// Quality and scale of the next attempt after an output that was too large
+///
+/// Returns None when both are already at their minimum.
+fn next_settings(settings: &SizedSettings, max_bytes: u64) -> Option<(u8, u32)> {
+    let ratio = max_bytes as f64 / settings.size as f64;
+
+    if settings.quality > MIN_QUALITY {
+        let step =
+            ((settings.quality as f64 * (1.0 - ratio)).ceil() as u8).clamp(5, MAX_QUALITY_STEP);
+        let quality = settings.quality.saturating_sub(step).max(MIN_QUALITY);
+        return Some((quality, settings.scale));
+    }
+
+    if settings.scale > MIN_SCALE {
+        // File size follows the pixel count, so scale both sides by the
+        // square root, with a margin for the fixed overhead
+        let scale = (settings.scale as f64 * ratio.sqrt() * 0.9) as u32;
+        let scale = scale.clamp(MIN_SCALE, settings.scale - 1);
+        return Some((settings.quality, scale));
+    }
+
+    None
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn settings(quality: u8, scale: u32, size: u64) -> SizedSettings {
+        SizedSettings {
+            quality,
+            scale,
+            width: 0,
+            height: 0,
+            size,
+        }
+    }
+
+    #[test]
+    fn test_fit_bitrate() {
+        // 8 MiB over 60 seconds is about 1118 kbit/s, less the margin
+        let bitrate = fit_bitrate(EMAIL_MAX_BYTES, 60_000, false).unwrap();
+        assert_eq!(bitrate.target_kbps, 1029);
+        assert_eq!(bitrate.max_kbps, bitrate.target_kbps);
+
+        // Audio takes its share
+        let with_audio = fit_bitrate(EMAIL_MAX_BYTES, 60_000, true).unwrap();
+        assert_eq!(with_audio.target_kbps, 1029 - AUDIO_KBPS as u32);
+
+        // Too long for the size
+        assert!(fit_bitrate(100_000, 600_000, true).is_err());
+    }
+
+    #[test]
+    fn test_next_settings_lowers_quality_first() {
+        // Slightly over: small quality step
+        assert_eq!(next_settings(&settings(50, 100, 105), 100), Some((45, 100)));
+        // Far over: step is capped
+        assert_eq!(
+            next_settings(&settings(90, 100, 1000), 100),
+            Some((65, 100))
+        );
+        // Never below the minimum quality
+        assert_eq!(
+            next_settings(&settings(22, 100, 1000), 100),
+            Some((MIN_QUALITY, 100))
+        );
+    }
+
+    #[test]
+    fn test_next_settings_then_scales() {
+        // 4x too large: about half the resolution
+        assert_eq!(
+            next_settings(&settings(MIN_QUALITY, 100, 400), 100),
+            Some((MIN_QUALITY, 45))
+        );
+        // Always makes progress
+        assert_eq!(
+            next_settings(&settings(MIN_QUALITY, 50, 101), 100),
+            Some((MIN_QUALITY, 44))
+        );
+        assert_eq!(
+            next_settings(&settings(MIN_QUALITY, MIN_SCALE, 400), 100),
+            None
+        );
+    }
+}
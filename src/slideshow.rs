@@ -1,109 +1,1421 @@
 //! Slideshow video generation
 
-use crate::encoder::{create_encoder, EncoderConfig, Frame, Packet};
-use crate::image_loader::LoadedImage;
-use crate::muxer::{create_muxer, MuxerConfig};
-use crate::{EncodeOptions, Error, Result, SlideEntry};
+use crate::audio::AudioMuxer;
+use crate::colorspace::ColorSpace;
+use crate::encoder::{
+    with_idle_frames, with_software_fallback, Encoder, EncoderConfig, Frame, Packet, PassStats,
+    Speed,
+};
+use crate::hdr::{self, Hdr10};
+use crate::image_loader::{self, LoadedImage};
+use crate::logo::LogoOverlay;
+use crate::muxer::{create_muxer, Muxer, MuxerConfig};
+use crate::verify::{Expected, OutputChecker};
+use crate::{
+    audio, budget, crop, disk, enhance, ffmpeg, font, parallel, priority, session, temp, text,
+    warning, Anchor, Chapter, ChapterMode, Codec, Compression, DynamicRange, EncodeOptions, Error,
+    HwFallback, LogLevel, OutputMode, Passes, PreviewLayout, PreviewOptions, Priority, Result,
+    SkippedSlide, SlideEntry, SlideFit, Transparency, WarningKind, WritingMode,
+};
+use ab_glyph::FontArc;
+use image::codecs::png::PngEncoder;
+use image::{DynamicImage, ExtendedColorType, ImageEncoder, Limits};
+use std::fs::File;
+use std::io::{BufWriter, Write};
+use std::path::{Path, PathBuf};
+use std::time::Instant;
 
-/// Default frame rate for slideshow videos
-const DEFAULT_FPS: u32 = 30;
+/// Slide number format used when the format is empty
+const DEFAULT_SLIDE_NUMBER_FORMAT: &str = "{n} / {total}";
+
+/// Smallest output width or height when scaling down
+const MIN_SCALED_SIZE: u32 = 16;
+
+/// Bytes a slide may take while it is decoded, converted and fitted, as for
+/// a 24-megapixel photo
+const DECODE_BYTES: u64 = 256 * MB;
+
+/// Bytes in a megabyte of the memory budget
+const MB: u64 = 1 << 20;
 
 /// Create a slideshow video from a sequence of images
 ///
 /// Each image is displayed for the specified duration (in milliseconds).
-/// All images are resized to match the dimensions of the first image.
+/// All images are fitted to the dimensions of the first image as set by
+/// `options.slide_fit`.
+/// Slides with a caption get it drawn near the bottom of the frame, and
+/// slide numbers, if set, are drawn in a corner away from the captions.
+/// A logo, if set, is drawn over the frames in its visible ranges.
+/// HDR10 output keeps the highlights of floating-point images and places
+/// other images and overlays at the HDR reference white.
+/// Background music, if set, is trimmed or looped to the video length, and
+/// slide narration starts with its slide.
+/// With a time budget, faster encoder presets and a lower resolution are
+/// chosen as needed to finish in time.
 pub fn slideshow(entries: &[SlideEntry], options: &EncodeOptions) -> Result<()> {
+    render(entries, options, 100, false).map(|_| ())
+}
+
+/// Create a slideshow, leaving out slides whose input cannot be used
+///
+/// Like [`slideshow`], but a slide whose image cannot be loaded or whose
+/// narration cannot be read is skipped instead of failing the whole video,
+/// so one corrupt file does not discard the work on the other slides. The
+/// remaining slides keep their durations and captions, and slide numbers
+/// count only them. Returns the skipped slides; fails when no slide can be
+/// used or on errors unrelated to a single slide.
+pub fn slideshow_skipping_invalid(
+    entries: &[SlideEntry],
+    options: &EncodeOptions,
+) -> Result<Vec<SkippedSlide>> {
+    render(entries, options, 100, true).map(|rendered| rendered.skipped)
+}
+
+/// Create a slideshow from slides taken one at a time from an iterator
+///
+/// Unlike [`slideshow`], which loads every slide before encoding, each slide
+/// is loaded, encoded and dropped before the next is taken, so very long
+/// slideshows can be generated lazily without holding all their images in
+/// memory. The first slide sets the output size. As the length of the video
+/// is only known at the end, slide number formats cannot use `{total}`,
+/// logo ranges cannot be measured from the end, time budgets, target sizes,
+/// two-pass encoding and software fallback are unavailable, and HDR10 output
+/// has no content light levels. An error yielded by `slides` stops the
+/// encode and is returned.
+pub fn slideshow_from_iter<I>(slides: I, options: &EncodeOptions) -> Result<()>
+where
+    I: IntoIterator<Item = Result<SlideEntry>>,
+{
+    render_stream(slides.into_iter(), options)
+}
+
+/// Image of a slide, fitted to the output once its size is known
+enum SlideImage {
+    /// Kept in memory
+    Loaded(Animation),
+    /// Loaded again when encoded, to keep within a memory budget
+    Deferred {
+        /// Index of the slide in the entries
+        index: usize,
+        width: u32,
+        height: u32,
+        /// Content light levels (MaxCLL, MaxFALL) of HDR10 output, measured
+        /// before fitting, which only darkens or crops the image
+        light: (u16, u16),
+    },
+}
+
+impl SlideImage {
+    /// Size of the image in pixels
+    fn size(&self) -> (u32, u32) {
+        match self {
+            SlideImage::Loaded(animation) => (animation.first().width, animation.first().height),
+            SlideImage::Deferred { width, height, .. } => (*width, *height),
+        }
+    }
+
+    /// Content light levels of the PQ-coded image of HDR10 output
+    fn content_light(&self) -> (u16, u16) {
+        match self {
+            SlideImage::Loaded(animation) => animation.content_light(),
+            SlideImage::Deferred { light, .. } => *light,
+        }
+    }
+}
+
+/// Frames of a slide with the time each ends within one cycle in
+/// milliseconds, played in a loop; still images have a single frame
+struct Animation {
+    frames: Vec<(LoadedImage, u64)>,
+}
+
+impl Animation {
+    /// Animation of a still image
+    fn still(img: LoadedImage) -> Self {
+        Self {
+            frames: vec![(img, 0)],
+        }
+    }
+
+    /// First frame, which sets the size of the slide
+    fn first(&self) -> &LoadedImage {
+        &self.frames[0].0
+    }
+
+    /// First frame, dropping the others
+    fn into_first(mut self) -> LoadedImage {
+        self.frames.swap_remove(0).0
+    }
+
+    /// Length of one cycle in milliseconds (0 for still images)
+    fn cycle_ms(&self) -> u64 {
+        self.frames.last().map_or(0, |(_, end_ms)| *end_ms)
+    }
+
+    /// Frame shown `ms` milliseconds into the slide
+    fn frame_at(&self, ms: u64) -> &LoadedImage {
+        let ms = ms.checked_rem(self.cycle_ms()).unwrap_or(0);
+        let index = self.frames.partition_point(|(_, end_ms)| *end_ms <= ms);
+        &self.frames[index.min(self.frames.len() - 1)].0
+    }
+
+    /// Content light levels of the PQ-coded frames of HDR10 output
+    fn content_light(&self) -> (u16, u16) {
+        hdr::content_light(self.frames.iter().map(|(img, _)| &img.data[..]))
+    }
+
+    /// Replace every frame, such as with the frame fitted to the output
+    fn map(self, mut f: impl FnMut(LoadedImage) -> LoadedImage) -> Self {
+        Self {
+            frames: self
+                .frames
+                .into_iter()
+                .map(|(img, end_ms)| (f(img), end_ms))
+                .collect(),
+        }
+    }
+}
+
+/// Output of a rendered slideshow
+pub(crate) struct Rendered {
+    /// Output width in pixels
+    pub width: u32,
+    /// Output height in pixels
+    pub height: u32,
+    /// Slides left out because their input could not be used
+    pub skipped: Vec<SkippedSlide>,
+}
+
+/// Render a slideshow with the output scaled to `scale` percent of the
+/// first usable image
+///
+/// With `skip_invalid`, slides whose input cannot be loaded are left out
+/// and reported instead of failing the render. A failed hardware encode is
+/// rendered again in software if the options fall back.
+pub(crate) fn render(
+    entries: &[SlideEntry],
+    options: &EncodeOptions,
+    scale: u32,
+    skip_invalid: bool,
+) -> Result<Rendered> {
+    priority::run(options.priority, || {
+        with_software_fallback(options, |options| {
+            render_with(entries, options, scale, skip_invalid)
+        })
+    })
+}
+
+/// Render a slideshow once with the encoder of the options
+fn render_with(
+    entries: &[SlideEntry],
+    options: &EncodeOptions,
+    scale: u32,
+    skip_invalid: bool,
+) -> Result<Rendered> {
+    let started = Instant::now();
+
     // Validate options
     options.validate()?;
+    let fps = options.frame_rate();
 
     if entries.is_empty() {
         return Err(Error::InvalidInput("No slides provided".to_string()));
     }
+    options.log(
+        LogLevel::Info,
+        "stage started",
+        vec![("stage", "load".into()), ("slides", entries.len().into())],
+    );
 
-    // Load and validate all images
-    let mut images: Vec<(LoadedImage, u32)> = Vec::new();
+    // Resolve the font for captions and slide numbers up front so a missing
+    // font fails fast
+    let text_font = if entries.iter().any(has_caption) || options.slide_number.is_some() {
+        Some(font::resolve_font(options.font.as_deref())?)
+    } else {
+        None
+    };
+    let caption_layout = text::CaptionLayout::from_options(options);
+    let slide_number_anchor = slide_number_anchor(options);
 
-    for entry in entries {
-        let img = LoadedImage::from_path(&entry.path)?;
-        images.push((img, entry.duration_ms));
+    // Check the audio files and ffmpeg before the slow encoding step
+    let has_narration = entries.iter().any(|e| e.audio.is_some());
+    if has_narration && options.output_mode == OutputMode::Segments {
+        return Err(Error::InvalidInput(
+            "Segment output does not support audio".to_string(),
+        ));
+    }
+    let ffmpeg = if options.background_music.is_some() || has_narration {
+        check_audio_container(options)?;
+        Some(ffmpeg::find_ffmpeg(options.ffmpeg_path.as_deref())?)
+    } else {
+        None
+    };
+    if let Some(music) = options.background_music.as_deref() {
+        check_audio_file(music)?;
+    }
+    let checker = OutputChecker::new(options)?;
+
+    // Load and validate all images, decoding them in parallel; with a
+    // memory budget, only their size is kept and each is loaded again when
+    // it is encoded
+    let threads = decode_threads(options);
+    let hdr10 = options.hdr10(0, 0).is_some();
+    let loaded = parallel::map(entries.iter().collect(), threads, |index, entry| {
+        let (animation, duration) = load_slide(entry, options, ffmpeg.as_deref())?;
+        let slide = match options.memory_budget_mb {
+            0 => SlideImage::Loaded(animation),
+            _ => SlideImage::Deferred {
+                index,
+                width: animation.first().width,
+                height: animation.first().height,
+                light: if hdr10 {
+                    animation.content_light()
+                } else {
+                    (0, 0)
+                },
+            },
+        };
+        Ok((slide, duration))
+    });
+    let mut images: Vec<(SlideImage, u32)> = Vec::new();
+    let mut skipped = Vec::new();
+
+    for ((index, entry), slide) in entries.iter().enumerate().zip(loaded) {
+        match slide {
+            Ok(slide) => images.push(slide),
+            Err(error) if skip_invalid => {
+                let slide = SkippedSlide { index, error };
+                options.log(
+                    LogLevel::Warn,
+                    "slide skipped",
+                    vec![
+                        ("index", index.into()),
+                        ("path", entry.path.as_str().into()),
+                        ("error", slide.error.to_string().into()),
+                    ],
+                );
+                options.warn(
+                    WarningKind::SlideSkipped,
+                    format!(
+                        "Slide {} ({}) skipped: {}",
+                        index + 1,
+                        entry.path,
+                        slide.error
+                    ),
+                );
+                skipped.push(slide);
+            }
+            Err(error) => return Err(error.in_slide(index, &entry.path)),
+        }
+    }
+    if images.is_empty() {
+        // Every slide was skipped; report why the first one failed
+        let first = skipped.remove(0);
+        return Err(first
+            .error
+            .in_slide(first.index, &entries[first.index].path));
+    }
+    let (indices, entries): (Vec<usize>, Vec<&SlideEntry>) = entries
+        .iter()
+        .enumerate()
+        .filter(|(index, _)| !skipped.iter().any(|s| s.index == *index))
+        .unzip();
+
+    // Number of frames of each slide (at least one)
+    let frame_counts: Vec<u64> = images
+        .iter()
+        .map(|(_, duration_ms)| ((*duration_ms as u64 * fps as u64) / 1000).max(1))
+        .collect();
+    let video_ms = frame_counts.iter().sum::<u64>() * 1000 / fps as u64;
+
+    // Pick the encoder speed and resolution for the time budget
+    let (first_width, first_height) = images[0].0.size();
+    let plan = budget::plan(
+        options.codec,
+        scaled_size(first_width, scale) as u64 * scaled_size(first_height, scale) as u64,
+        frame_counts.iter().sum(),
+        budget::remaining(options.time_budget_ms, started.elapsed()),
+    );
+    let scale = scale.min(100) * plan.scale / 100;
+    if options.time_budget_ms > 0 {
+        options.log(
+            LogLevel::Info,
+            "time budget planned",
+            vec![
+                ("speed", format!("{:?}", plan.speed).into()),
+                ("scale", plan.scale.into()),
+            ],
+        );
     }
 
     // Get target dimensions from the first image
-    let (target_width, target_height) = (images[0].0.width, images[0].0.height);
+    let (target_width, target_height) = (
+        scaled_size(first_width, scale),
+        scaled_size(first_height, scale),
+    );
 
     // Ensure dimensions are even (required for video encoding)
+    if target_width % 2 != 0 || target_height % 2 != 0 {
+        options.warn(
+            WarningKind::SizeRounded,
+            warning::size_rounded(target_width, target_height),
+        );
+    }
     let target_width = (target_width / 2) * 2;
     let target_height = (target_height / 2) * 2;
 
-    // Resize all images to match the first one
-    let images: Vec<(LoadedImage, u32)> = images
-        .into_iter()
-        .map(|(img, duration)| (img.resize(target_width, target_height), duration))
-        .collect();
+    // Fit all images to the first one and draw captions and slide numbers;
+    // with transparency kept, contained slides are padded with transparent
+    // pixels
+    let alpha = options.transparency == Transparency::Keep
+        && matches!(options.codec, Codec::Vp9 | Codec::Vp8);
+    let padding = if options.transparency == Transparency::Keep {
+        [0, 0, 0, 0]
+    } else {
+        [0, 0, 0, 255]
+    };
+    let style = SlideStyle {
+        width: target_width,
+        height: target_height,
+        padding,
+        font: text_font,
+        caption_layout,
+        slide_number_anchor,
+    };
+    let total = entries.len();
+    let images: Vec<(SlideImage, u32)> = parallel::map(
+        images.into_iter().zip(&entries).collect(),
+        threads,
+        |i, ((slide, duration), entry)| match slide {
+            SlideImage::Loaded(animation) => Ok((
+                SlideImage::Loaded(style.compose_slide(animation, entry, i + 1, total, options)?),
+                duration,
+            )),
+            deferred => Ok((deferred, duration)),
+        },
+    )
+    .into_iter()
+    .enumerate()
+    .map(|(i, slide)| slide.map_err(|e: Error| e.in_slide(indices[i], &entries[i].path)))
+    .collect::<Result<_>>()?;
+
+    // HDR10 metadata with the light levels of the slides; the logo is SDR
+    // and not brighter than them
+    let hdr = options.hdr10(0, 0).map(|hdr| {
+        let (max_cll, max_fall) = images
+            .iter()
+            .map(|(slide, _)| slide.content_light())
+            .fold((0, 0), |(cll, fall), (max_cll, max_fall)| {
+                (cll.max(max_cll), fall.max(max_fall))
+            });
+        Hdr10 {
+            max_cll,
+            max_fall,
+            ..hdr
+        }
+    });
+
+    let logo = match &options.logo {
+        Some(logo) => {
+            let mut logo = LogoOverlay::new(
+                logo,
+                target_width,
+                target_height,
+                options.safe_area,
+                video_ms,
+            )?;
+            if hdr.is_some() {
+                logo.convert_to_pq();
+            }
+            Some(logo)
+        }
+        None => None,
+    };
+
+    // Fail early when the output volume is too full
+    let estimate = disk::estimate_output(
+        options.codec,
+        options.quality,
+        options.compression == Compression::Lossless,
+        target_width as u64 * target_height as u64,
+        frame_counts.iter().sum(),
+    );
+    disk::check_space(
+        Path::new(&options.output_path),
+        disk::peak_space(options, estimate),
+    )?;
+
+    // Encode the frames of the whole slideshow, drawn again for each pass;
+    // slides kept out of memory are loaded again, one at a time
+    let encode_frames = |encode: &mut dyn FnMut(Frame) -> Result<()>| -> Result<()> {
+        let mut i: u64 = 0;
+        for (n, ((slide, _), &count)) in images.iter().zip(&frame_counts).enumerate() {
+            let reloaded;
+            let animation = match slide {
+                SlideImage::Loaded(animation) => animation,
+                &SlideImage::Deferred { index, .. } => {
+                    let entry = entries[n];
+                    reloaded = load_image(entry, options)
+                        .and_then(|animation| {
+                            style.compose_slide(animation, entry, n + 1, total, options)
+                        })
+                        .map_err(|e| e.in_slide(index, &entry.path))?;
+                    &reloaded
+                }
+            };
+            for frame in 0..count {
+                let pts_ms = i * 1000 / fps as u64;
+                let image = animation.frame_at(frame * 1000 / fps as u64);
+                let mut data = image.data.clone();
+                if let Some(logo) = &logo {
+                    logo.draw(&mut data, image.width, pts_ms);
+                }
+                encode(Frame {
+                    width: image.width,
+                    height: image.height,
+                    data,
+                    pts_ms,
+                })?;
+                i += 1;
+            }
+        }
+        Ok(())
+    };
+
+    options.log(
+        LogLevel::Info,
+        "stage started",
+        vec![
+            ("stage", "encode".into()),
+            ("width", target_width.into()),
+            ("height", target_height.into()),
+            ("frames", frame_counts.iter().sum::<u64>().into()),
+        ],
+    );
 
     // Create encoder
     let encoder_config = EncoderConfig {
         width: target_width,
         height: target_height,
-        fps: DEFAULT_FPS,
+        fps,
         quality: options.quality,
+        ffmpeg_path: options.ffmpeg_path.clone(),
+        speed: plan.speed,
+        lossless: options.compression == Compression::Lossless,
+        alpha,
+        two_pass: None,
+        bitrate: options.video_bitrate(
+            video_ms,
+            options.background_music.is_some() || has_narration,
+        )?,
+        keyframe_interval: options.keyframe_interval(fps),
+        forced_keyframes: options.forced_keyframes(fps),
+        pixel_format: options.pixel_format,
+        bit_depth: options.bit_depth,
+        hdr,
+        color: ColorSpace::new(options),
+        hw_accel: options.encoder_hw_accel(),
+        threads: options.encoder_threads(),
+        av1_backend: options.av1_backend,
+        av1_preset: options.av1_preset,
+        h264_profile: options.h264_profile,
+        h264_level: options.h264_level,
+        h264_preset: options.h264_preset,
+        h264_tune: options.h264_tune,
+        ffmpeg_args: options.ffmpeg_args.clone(),
     };
 
-    let mut encoder = create_encoder(options.codec, encoder_config.clone())?;
-
-    // Generate all frames and collect packets
-    // We need to encode at least one frame before creating the muxer
-    // so that H.264 encoders can extract SPS/PPS
-    let mut all_packets: Vec<Packet> = Vec::new();
-    let mut total_ms: u64 = 0;
+    // The first of two passes only gathers statistics for the second, which
+    // are removed when the encode ends
+    let stats = (options.passes == Passes::Two)
+        .then(|| PassStats::new(temp::path(options, temp::PASS_SUFFIX)));
+    let encoder_config = match &stats {
+        Some(stats) => {
+            let config = EncoderConfig {
+                two_pass: Some(stats.pass(1)),
+                ..encoder_config.clone()
+            };
+            let mut first =
+                with_idle_frames(session::encoder(options, config)?, options.idle_frames, fps);
+            encode_frames(&mut |frame| first.encode(&frame).map(|_| ()))?;
+            first.flush()?;
+            EncoderConfig {
+                two_pass: Some(stats.pass(2)),
+                ..encoder_config
+            }
+        }
+        None => encoder_config,
+    };
 
-    for (image, duration_ms) in &images {
-        // Calculate number of frames for this slide
-        let frame_count = (*duration_ms as u64 * DEFAULT_FPS as u64) / 1000;
-        let frame_count = frame_count.max(1); // At least one frame
+    let mut encoder = with_idle_frames(
+        session::encoder(options, encoder_config)?,
+        options.idle_frames,
+        fps,
+    );
 
-        for _ in 0..frame_count {
-            let frame = Frame {
-                width: image.width,
-                height: image.height,
-                data: image.data.clone(),
-                pts_ms: total_ms,
-            };
+    let slide_chapters = entries
+        .iter()
+        .zip(&frame_counts)
+        .enumerate()
+        .scan(0, |start_frame, (index, (entry, &frame_count))| {
+            let chapter = slide_chapter(entry, index + 1, *start_frame, fps);
+            *start_frame += frame_count;
+            Some(chapter)
+        })
+        .collect();
+    let chapters = output_chapters(options, slide_chapters)?;
+    let muxer_config = |encoder: &dyn Encoder| MuxerConfig {
+        width: target_width,
+        height: target_height,
+        fps,
+        codec: options.codec,
+        codec_config: encoder.codec_config(),
+        pps: encoder.pps(),
+        alpha,
+        hls_segment: options.hls_segment,
+        segment_ms: options.segment_duration_ms,
+        mp4_layout: options.mp4_layout,
+        output_mode: options.output_mode,
+        hdr,
+        color: ColorSpace::new(options),
+        chapters: chapters.clone(),
+    };
 
-            let packets = encoder.encode(&frame)?;
-            all_packets.extend(packets);
+    let mut audio = audio::SlideshowAudio {
+        music: options.background_music.as_ref().map(PathBuf::from),
+        narrations: Vec::new(),
+        loudness: (options.loudness != 0.0).then_some(options.loudness),
+        envelope: audio::Envelope {
+            gain_db: options.audio_gain,
+            fade_in_ms: options.audio_fade_in_ms,
+            fade_out_ms: options.audio_fade_out_ms,
+        },
+        ducking: audio::Ducking::new(options.duck_threshold, options.duck_amount),
+        pcm: None,
+    };
 
-            total_ms += 1000 / DEFAULT_FPS as u64;
+    let mut start_frame = 0;
+    for (entry, &frame_count) in entries.iter().zip(&frame_counts) {
+        if let Some(path) = &entry.audio {
+            audio.narrations.push(audio::Narration {
+                path: PathBuf::from(path),
+                start_ms: start_frame * 1000 / fps as u64,
+                duration_ms: frame_count * 1000 / fps as u64,
+            });
         }
+        start_frame += frame_count;
+    }
+    if let Some(ffmpeg) = &ffmpeg {
+        audio.warn_truncated(ffmpeg, video_ms, options);
     }
 
+    // The muxer is created with the first packets, once H.264 and HEVC
+    // encoders have their parameter sets, and packets are muxed as they are
+    // encoded rather than held until the end; with audio, the video is
+    // muxed into ffmpeg, which adds the audio
+    let new_muxer = |encoder: &dyn Encoder| -> Result<Box<dyn Muxer>> {
+        match &ffmpeg {
+            Some(ffmpeg) if !audio.is_empty() => Ok(Box::new(AudioMuxer::new(
+                ffmpeg,
+                &audio,
+                options,
+                video_ms,
+                muxer_config(encoder),
+            )?)),
+            _ => create_muxer(
+                options.container,
+                &options.output_path,
+                muxer_config(encoder),
+            ),
+        }
+    };
+    let mut muxer: Option<Box<dyn Muxer>> = None;
+    let mut write_packets = |encoder: &dyn Encoder, packets: Vec<Packet>| -> Result<()> {
+        if packets.is_empty() {
+            return Ok(());
+        }
+        let muxer = match &mut muxer {
+            Some(muxer) => muxer,
+            None => muxer.insert(new_muxer(encoder)?),
+        };
+        for packet in &packets {
+            muxer.write_packet(packet)?;
+        }
+        Ok(())
+    };
+
+    encode_frames(&mut |frame| {
+        let packets = encoder.encode(&frame)?;
+        write_packets(&*encoder, packets)
+    })?;
+
     // Flush encoder
     let flush_packets = encoder.flush()?;
-    all_packets.extend(flush_packets);
+    write_packets(&*encoder, flush_packets)?;
+    drop(stats);
 
-    // Now create muxer with SPS/PPS from encoder (available after encoding)
-    let muxer_config = MuxerConfig {
+    options.log(
+        LogLevel::Info,
+        "stage started",
+        vec![("stage", "mux".into())],
+    );
+
+    let muxer = match muxer {
+        Some(muxer) => muxer,
+        None => new_muxer(&*encoder)?,
+    };
+
+    // Finalize output
+    muxer.finalize()?;
+
+    checker.check(&Expected {
+        width: target_width,
+        height: target_height,
+        fps,
+        duration_ms: video_ms,
+    })?;
+    options.log(
+        LogLevel::Info,
+        "job finished",
+        vec![
+            ("elapsed_ms", (started.elapsed().as_millis() as u64).into()),
+            ("duration_ms", video_ms.into()),
+        ],
+    );
+
+    Ok(Rendered {
         width: target_width,
         height: target_height,
-        fps: DEFAULT_FPS,
+        skipped,
+    })
+}
+
+/// Render a slideshow from an iterator of slides, encoding each slide
+/// before the next is taken
+fn render_stream(
+    slides: impl Iterator<Item = Result<SlideEntry>>,
+    options: &EncodeOptions,
+) -> Result<()> {
+    let started = Instant::now();
+    options.validate()?;
+    check_streaming(options)?;
+    let fps = options.frame_rate();
+
+    // Captions and narration are only known as the slides come, so the font
+    // and ffmpeg are found when first needed
+    let mut text_font = match options.slide_number {
+        Some(_) => Some(font::resolve_font(options.font.as_deref())?),
+        None => None,
+    };
+    let mut ffmpeg = match options.background_music.as_deref() {
+        Some(music) => {
+            check_audio_container(options)?;
+            check_audio_file(music)?;
+            Some(ffmpeg::find_ffmpeg(options.ffmpeg_path.as_deref())?)
+        }
+        None => None,
+    };
+    let checker = OutputChecker::new(options)?;
+
+    let alpha = options.transparency == Transparency::Keep
+        && matches!(options.codec, Codec::Vp9 | Codec::Vp8);
+    let hdr = options.hdr10(0, 0);
+    let mut output: Option<(SlideStyle, Box<dyn Encoder>, Option<LogoOverlay>)> = None;
+    let mut packets: Vec<Packet> = Vec::new();
+    let mut narrations = Vec::new();
+    let mut slide_chapters = Vec::new();
+    let mut frame_index: u64 = 0;
+
+    for (index, entry) in slides.enumerate() {
+        let entry = entry?;
+        let slide = (|| {
+            if entry.audio.is_some() && ffmpeg.is_none() {
+                if options.output_mode == OutputMode::Segments {
+                    return Err(Error::InvalidInput(
+                        "Segment output does not support audio".to_string(),
+                    ));
+                }
+                check_audio_container(options)?;
+                ffmpeg = Some(ffmpeg::find_ffmpeg(options.ffmpeg_path.as_deref())?);
+            }
+            if has_caption(&entry) && text_font.is_none() {
+                text_font = Some(font::resolve_font(options.font.as_deref())?);
+            }
+            load_slide(&entry, options, ffmpeg.as_deref())
+        })();
+        let (animation, duration_ms) = slide.map_err(|e| e.in_slide(index, &entry.path))?;
+        let img = animation.first();
+
+        // The first slide sets the output size and starts the encoder
+        let (style, encoder, logo) = match &mut output {
+            Some(output) => output,
+            None => {
+                if img.width % 2 != 0 || img.height % 2 != 0 {
+                    options.warn(
+                        WarningKind::SizeRounded,
+                        warning::size_rounded(img.width, img.height),
+                    );
+                }
+                let style = SlideStyle {
+                    width: img.width / 2 * 2,
+                    height: img.height / 2 * 2,
+                    padding: match options.transparency {
+                        Transparency::Keep => [0, 0, 0, 0],
+                        _ => [0, 0, 0, 255],
+                    },
+                    font: None,
+                    caption_layout: text::CaptionLayout::from_options(options),
+                    slide_number_anchor: slide_number_anchor(options),
+                };
+                let logo = match &options.logo {
+                    Some(logo) => {
+                        let mut logo = LogoOverlay::new(
+                            logo,
+                            style.width,
+                            style.height,
+                            options.safe_area,
+                            u64::MAX,
+                        )?;
+                        if hdr.is_some() {
+                            logo.convert_to_pq();
+                        }
+                        Some(logo)
+                    }
+                    None => None,
+                };
+                let encoder_config = EncoderConfig {
+                    width: style.width,
+                    height: style.height,
+                    fps,
+                    quality: options.quality,
+                    ffmpeg_path: options.ffmpeg_path.clone(),
+                    speed: Speed::Default,
+                    lossless: options.compression == Compression::Lossless,
+                    alpha,
+                    two_pass: None,
+                    bitrate: options.bitrate(),
+                    keyframe_interval: options.keyframe_interval(fps),
+                    forced_keyframes: options.forced_keyframes(fps),
+                    pixel_format: options.pixel_format,
+                    bit_depth: options.bit_depth,
+                    hdr,
+                    color: ColorSpace::new(options),
+                    hw_accel: options.encoder_hw_accel(),
+                    threads: options.encoder_threads(),
+                    av1_backend: options.av1_backend,
+                    av1_preset: options.av1_preset,
+                    h264_profile: options.h264_profile,
+                    h264_level: options.h264_level,
+                    h264_preset: options.h264_preset,
+                    h264_tune: options.h264_tune,
+                    ffmpeg_args: options.ffmpeg_args.clone(),
+                };
+                options.log(
+                    LogLevel::Info,
+                    "stage started",
+                    vec![
+                        ("stage", "encode".into()),
+                        ("width", style.width.into()),
+                        ("height", style.height.into()),
+                    ],
+                );
+                let encoder = with_idle_frames(
+                    session::encoder(options, encoder_config)?,
+                    options.idle_frames,
+                    fps,
+                );
+                output.insert((style, encoder, logo))
+            }
+        };
+        style.font = text_font.clone();
+
+        // Slide numbers count from 1; the total is unknown while streaming
+        let animation = style
+            .compose_slide(animation, &entry, index + 1, 0, options)
+            .map_err(|e| e.in_slide(index, &entry.path))?;
+        let frame_count = ((duration_ms as u64 * fps as u64) / 1000).max(1);
+        slide_chapters.push(slide_chapter(&entry, index + 1, frame_index, fps));
+        if let Some(path) = &entry.audio {
+            narrations.push(audio::Narration {
+                path: PathBuf::from(path),
+                start_ms: frame_index * 1000 / fps as u64,
+                duration_ms: frame_count * 1000 / fps as u64,
+            });
+        }
+        for frame in 0..frame_count {
+            let pts_ms = frame_index * 1000 / fps as u64;
+            let img = animation.frame_at(frame * 1000 / fps as u64);
+            let mut data = img.data.clone();
+            if let Some(logo) = logo {
+                logo.draw(&mut data, img.width, pts_ms);
+            }
+            packets.extend(encoder.encode(&Frame {
+                width: img.width,
+                height: img.height,
+                data,
+                pts_ms,
+            })?);
+            frame_index += 1;
+        }
+    }
+
+    let Some((style, mut encoder, _)) = output else {
+        return Err(Error::InvalidInput("No slides provided".to_string()));
+    };
+    packets.extend(encoder.flush()?);
+    let video_ms = frame_index * 1000 / fps as u64;
+
+    let muxer_config = MuxerConfig {
+        width: style.width,
+        height: style.height,
+        fps,
         codec: options.codec,
         codec_config: encoder.codec_config(),
         pps: encoder.pps(),
+        alpha,
+        hls_segment: options.hls_segment,
+        segment_ms: options.segment_duration_ms,
+        mp4_layout: options.mp4_layout,
+        output_mode: options.output_mode,
+        hdr,
+        color: ColorSpace::new(options),
+        chapters: output_chapters(options, slide_chapters)?,
+    };
+    // With audio, the video is muxed into ffmpeg, which adds the audio
+    options.log(
+        LogLevel::Info,
+        "stage started",
+        vec![("stage", "mux".into())],
+    );
+    let has_audio = options.background_music.is_some() || !narrations.is_empty();
+    let mut muxer: Box<dyn Muxer> = match &ffmpeg {
+        Some(ffmpeg) if has_audio => {
+            let audio = audio::SlideshowAudio {
+                music: options.background_music.as_ref().map(PathBuf::from),
+                narrations,
+                loudness: (options.loudness != 0.0).then_some(options.loudness),
+                envelope: audio::Envelope {
+                    gain_db: options.audio_gain,
+                    fade_in_ms: options.audio_fade_in_ms,
+                    fade_out_ms: options.audio_fade_out_ms,
+                },
+                ducking: audio::Ducking::new(options.duck_threshold, options.duck_amount),
+                pcm: None,
+            };
+            audio.warn_truncated(ffmpeg, video_ms, options);
+            Box::new(AudioMuxer::new(
+                ffmpeg,
+                &audio,
+                options,
+                video_ms,
+                muxer_config,
+            )?)
+        }
+        _ => create_muxer(options.container, &options.output_path, muxer_config)?,
     };
+    for packet in packets {
+        muxer.write_packet(&packet)?;
+    }
+    muxer.finalize()?;
 
-    let mut muxer = create_muxer(options.container, &options.output_path, muxer_config)?;
+    checker.check(&Expected {
+        width: style.width,
+        height: style.height,
+        fps,
+        duration_ms: video_ms,
+    })?;
+    options.log(
+        LogLevel::Info,
+        "job finished",
+        vec![
+            ("elapsed_ms", (started.elapsed().as_millis() as u64).into()),
+            ("duration_ms", video_ms.into()),
+        ],
+    );
+    Ok(())
+}
 
-    // Write all packets
-    for packet in all_packets {
-        muxer.write_packet(&packet)?;
+/// Check that the options can be used without knowing the slides up front
+fn check_streaming(options: &EncodeOptions) -> Result<()> {
+    let unsupported = if options.time_budget_ms > 0 {
+        Some("time budgets")
+    } else if options.target_size_bytes > 0 {
+        Some("target sizes")
+    } else if options.passes == Passes::Two {
+        Some("two-pass encoding")
+    } else if options.hw_fallback == HwFallback::Software {
+        Some("software fallback")
+    } else if options.priority != Priority::Normal {
+        // The source is called on the calling thread, which keeps its priority
+        Some("lowered priorities")
+    } else if options
+        .slide_number
+        .as_deref()
+        .is_some_and(|format| format.is_empty() || format.contains("{total}"))
+    {
+        Some("slide numbers with {total}")
+    } else if options.logo.as_ref().is_some_and(|logo| {
+        logo.ranges
+            .iter()
+            .any(|range| range.start_ms < 0 || range.end_ms < 0)
+    }) {
+        Some("logo ranges measured from the end")
+    } else {
+        None
+    };
+    match unsupported {
+        Some(feature) => Err(Error::InvalidInput(format!(
+            "Streaming slideshows do not support {}",
+            feature
+        ))),
+        None => Ok(()),
     }
+}
 
-    // Finalize output
-    muxer.finalize()?;
+/// Check that the container of the options can hold audio
+fn check_audio_container(options: &EncodeOptions) -> Result<()> {
+    if !options.container.supports_audio() {
+        return Err(Error::InvalidInput(format!(
+            "Container {:?} does not support audio",
+            options.container
+        )));
+    }
+    Ok(())
+}
+
+/// Render the first frame of each slide as PNG, to preview a slideshow
+/// before encoding it
+///
+/// Slides are fitted, captioned, numbered and overlaid with the logo as by
+/// [`slideshow`] with the same options, at the size of the video, but
+/// nothing is encoded, so a preview takes a fraction of the time of the
+/// video. HDR10 output is previewed as SDR, and the time budget is ignored.
+/// Returns the paths of the written images.
+pub fn preview_slideshow(
+    entries: &[SlideEntry],
+    options: &EncodeOptions,
+    preview: &PreviewOptions,
+) -> Result<Vec<String>> {
+    priority::run(options.priority, || {
+        render_preview(entries, options, preview)
+    })
+}
+
+/// Render the preview images of a slideshow
+fn render_preview(
+    entries: &[SlideEntry],
+    options: &EncodeOptions,
+    preview: &PreviewOptions,
+) -> Result<Vec<String>> {
+    options.validate()?;
+    if entries.is_empty() {
+        return Err(Error::InvalidInput("No slides provided".to_string()));
+    }
+    if preview.output_path.is_empty() {
+        return Err(Error::InvalidInput("Preview path is empty".to_string()));
+    }
+    let options = &EncodeOptions {
+        dynamic_range: DynamicRange::Sdr,
+        ..options.clone()
+    };
 
+    let font = if entries.iter().any(has_caption) || options.slide_number.is_some() {
+        Some(font::resolve_font(options.font.as_deref())?)
+    } else {
+        None
+    };
+    // Slides timed by their narration only need it measured to place the
+    // logo in its visible ranges
+    let ffmpeg = if options.logo.is_some() && entries.iter().any(|e| e.audio.is_some()) {
+        Some(ffmpeg::find_ffmpeg(options.ffmpeg_path.as_deref())?)
+    } else {
+        None
+    };
+    let threads = decode_threads(options);
+    let images = parallel::map(entries.iter().collect(), threads, |index, entry| {
+        load_slide(entry, options, ffmpeg.as_deref()).map_err(|e| e.in_slide(index, &entry.path))
+    })
+    .into_iter()
+    .collect::<Result<Vec<_>>>()?;
+
+    let style = SlideStyle {
+        width: images[0].0.first().width / 2 * 2,
+        height: images[0].0.first().height / 2 * 2,
+        padding: match options.transparency {
+            Transparency::Keep => [0, 0, 0, 0],
+            _ => [0, 0, 0, 255],
+        },
+        font,
+        caption_layout: text::CaptionLayout::from_options(options),
+        slide_number_anchor: slide_number_anchor(options),
+    };
+    let video_ms = images.iter().map(|(_, ms)| *ms as u64).sum();
+    let logo = match &options.logo {
+        Some(logo) => Some(LogoOverlay::new(
+            logo,
+            style.width,
+            style.height,
+            options.safe_area,
+            video_ms,
+        )?),
+        None => None,
+    };
+
+    // Draw each slide at its start time, scaled to the preview height
+    let total = entries.len();
+    let mut start_ms = 0;
+    let slides = images
+        .into_iter()
+        .zip(entries)
+        .map(|((img, duration), entry)| {
+            let start = start_ms;
+            start_ms += duration as u64;
+            (img, entry, start)
+        })
+        .collect();
+    let slides = parallel::map(slides, threads, |i, (animation, entry, start_ms)| {
+        let animation = style
+            .compose_slide(animation, entry, i + 1, total, options)
+            .map_err(|e| e.in_slide(i, &entry.path))?;
+        let mut img = animation.into_first();
+        if let Some(logo) = &logo {
+            logo.draw(&mut img.data, img.width, start_ms);
+        }
+        if preview.height > 0 && preview.height != img.height {
+            let width = (img.width as u64 * preview.height as u64 / img.height as u64).max(1);
+            img = img.resize(width as u32, preview.height);
+        }
+        Ok(img)
+    })
+    .into_iter()
+    .collect::<Result<Vec<_>>>()?;
+
+    let path = Path::new(&preview.output_path);
+    match preview.layout {
+        PreviewLayout::Strip => {
+            let width = slides.iter().map(|s| s.width).sum();
+            let height = slides[0].height;
+            let mut strip = vec![0u8; width as usize * height as usize * 4];
+            let mut x = 0;
+            for slide in &slides {
+                let row = slide.width as usize * 4;
+                for (y, line) in slide.data.chunks_exact(row).enumerate() {
+                    let start = (y * width as usize + x) * 4;
+                    strip[start..start + row].copy_from_slice(line);
+                }
+                x += slide.width as usize;
+            }
+            save_png(path, width, height, &strip)?;
+            Ok(vec![preview.output_path.clone()])
+        }
+        PreviewLayout::Files => {
+            let stem = path.file_stem().and_then(|s| s.to_str()).unwrap_or("slide");
+            slides
+                .into_iter()
+                .enumerate()
+                .map(|(i, slide)| {
+                    let file = path.with_file_name(format!("{}_{:05}.png", stem, i + 1));
+                    save_png(&file, slide.width, slide.height, &slide.data)?;
+                    Ok(file.to_string_lossy().to_string())
+                })
+                .collect()
+        }
+    }
+}
+
+/// Write RGBA pixels as a PNG file
+fn save_png(path: &Path, width: u32, height: u32, data: &[u8]) -> Result<()> {
+    let mut file = BufWriter::new(File::create(path)?);
+    PngEncoder::new(&mut file).write_image(data, width, height, ExtendedColorType::Rgba8)?;
+    file.flush()?;
     Ok(())
 }
 
+/// How slides are fitted to the output and labeled
+struct SlideStyle {
+    /// Output width in pixels
+    width: u32,
+    /// Output height in pixels
+    height: u32,
+    /// Color of the bars around contained slides
+    padding: [u8; 4],
+    /// Font of captions and slide numbers (None when neither is drawn)
+    font: Option<FontArc>,
+    caption_layout: text::CaptionLayout,
+    slide_number_anchor: Anchor,
+}
+
+impl SlideStyle {
+    /// Fit the frames of a slide to the output and draw its caption and the
+    /// 1-based slide number
+    ///
+    /// SVG slides are rasterized again to cover the output, so that fitting
+    /// only crops or shrinks them and their edges stay crisp.
+    fn compose_slide(
+        &self,
+        animation: Animation,
+        entry: &SlideEntry,
+        number: usize,
+        total: usize,
+        options: &EncodeOptions,
+    ) -> Result<Animation> {
+        // The frames of an animation are all kept at the output size while
+        // the slide is encoded
+        if let (Some(max_alloc), true) = (decode_limit(options), animation.frames.len() > 1) {
+            let mut limits = Limits::default();
+            limits.max_alloc = Some(max_alloc);
+            limits.reserve(
+                animation.frames.len() as u64 * self.width as u64 * self.height as u64 * 4,
+            )?;
+        }
+        let animation = if image_loader::is_svg(&entry.path) {
+            let first = animation.first();
+            let (width, height) = cover_size(first.width, first.height, self.width, self.height);
+            let decoded = image_loader::rasterize_svg(
+                &entry.path,
+                options.ffmpeg_path.as_deref(),
+                width,
+                height,
+            )?;
+            Animation::still(prepare_image(decoded, options))
+        } else {
+            animation
+        };
+        Ok(animation.map(|img| self.compose(img, entry, number, total, options)))
+    }
+
+    /// Fit a slide image to the output and draw its caption and the
+    /// 1-based slide number
+    fn compose(
+        &self,
+        img: LoadedImage,
+        entry: &SlideEntry,
+        number: usize,
+        total: usize,
+        options: &EncodeOptions,
+    ) -> LoadedImage {
+        let mut img = match options.slide_fit {
+            SlideFit::Stretch => img.resize(self.width, self.height),
+            SlideFit::Cover => crop::cover(&img, self.width, self.height, options.crop_focus),
+            SlideFit::Contain => img.resize_fit(self.width, self.height, self.padding),
+        };
+        let draw_text = |img: &mut LoadedImage| {
+            if let (Some(font), Some(caption)) = (&self.font, entry.caption.as_deref()) {
+                text::draw_caption(img, font, caption, &self.caption_layout);
+            }
+            if let (Some(font), Some(format)) = (&self.font, options.slide_number.as_deref()) {
+                let label = slide_number_text(format, number, total);
+                text::draw_label(
+                    img,
+                    font,
+                    &label,
+                    self.slide_number_anchor,
+                    options.safe_area,
+                );
+            }
+        };
+        if options.hdr10(0, 0).is_some() {
+            hdr::draw_sdr(&mut img, draw_text);
+        } else {
+            draw_text(&mut img);
+        }
+        img
+    }
+}
+
+/// Smallest size of a `width` x `height` image scaled to cover a
+/// `target_width` x `target_height` frame
+fn cover_size(width: u32, height: u32, target_width: u32, target_height: u32) -> (u32, u32) {
+    let (width, height) = (width.max(1) as u64, height.max(1) as u64);
+    let (target_width, target_height) = (target_width as u64, target_height as u64);
+    if target_width * height >= target_height * width {
+        (
+            target_width as u32,
+            (target_width * height).div_ceil(width) as u32,
+        )
+    } else {
+        (
+            (target_height * width).div_ceil(height) as u32,
+            target_height as u32,
+        )
+    }
+}
+
+/// Load the image of a slide and find its duration
+///
+/// Animated slides without a duration or narration play their animation once.
+fn load_slide(
+    entry: &SlideEntry,
+    options: &EncodeOptions,
+    ffmpeg: Option<&str>,
+) -> Result<(Animation, u32)> {
+    let animation = load_image(entry, options)?;
+    let duration = match slide_duration(entry, ffmpeg)? {
+        0 => animation.cycle_ms().min(u32::MAX as u64) as u32,
+        ms => ms,
+    };
+    Ok((animation, duration))
+}
+
+/// Load the image of a slide, or the frames of an animated WebP image,
+/// ready to be fitted to the output
+fn load_image(entry: &SlideEntry, options: &EncodeOptions) -> Result<Animation> {
+    let max_alloc = decode_limit(options);
+    if let Some(frames) = image_loader::decode_animation(&entry.path, max_alloc)? {
+        let mut end_ms = 0;
+        let frames = frames
+            .into_iter()
+            .map(|(img, delay_ms)| {
+                end_ms += delay_ms;
+                (prepare_image(img, options), end_ms)
+            })
+            .collect();
+        return Ok(Animation { frames });
+    }
+
+    let decoded =
+        image_loader::decode_with(&entry.path, options.ffmpeg_path.as_deref(), max_alloc)?;
+    Ok(Animation::still(prepare_image(decoded, options)))
+}
+
+/// Bytes each thread may decode a slide into: with a memory budget, its
+/// share of half of it
+fn decode_limit(options: &EncodeOptions) -> Option<u64> {
+    match options.memory_budget_mb {
+        0 => None,
+        mb => Some(mb as u64 * MB / 2 / decode_threads(options) as u64),
+    }
+}
+
+/// Convert a decoded image to the RGBA pixels of the output
+fn prepare_image(decoded: DynamicImage, options: &EncodeOptions) -> LoadedImage {
+    // For HDR10 output, floating-point images keep their highlights and
+    // other images are enhanced as for SDR output before the conversion
+    let hdr = options.hdr10(0, 0);
+    let linear = hdr.and_then(|hdr| hdr::linear_image(&decoded, hdr.max_luminance));
+    match linear {
+        Some(img) => img,
+        None => {
+            let mut img = LoadedImage::from_dynamic_image_tone_mapped(decoded, options.tone_map);
+            enhance::auto_enhance(&mut img, options.enhance);
+            if hdr.is_some() {
+                hdr::sdr_to_pq(&mut img.data);
+            }
+            img
+        }
+    }
+}
+
+/// Threads decoding slides: with a memory budget, only as many as fit in
+/// half of it
+fn decode_threads(options: &EncodeOptions) -> usize {
+    let threads = parallel::thread_count(options.threads);
+    match options.memory_budget_mb {
+        0 => threads,
+        mb => threads.min((mb as u64 * MB / 2 / DECODE_BYTES).max(1) as usize),
+    }
+}
+
+/// Scale an output dimension by a percentage
+fn scaled_size(size: u32, scale: u32) -> u32 {
+    if scale >= 100 {
+        size
+    } else {
+        (size as u64 * scale as u64 / 100).max(MIN_SCALED_SIZE.min(size) as u64) as u32
+    }
+}
+
+/// Check that an audio file exists
+fn check_audio_file(path: &str) -> Result<()> {
+    if Path::new(path).is_file() {
+        Ok(())
+    } else {
+        Err(Error::InvalidInput(format!(
+            "Audio file not found: {}",
+            path
+        )))
+    }
+}
+
+/// Display duration of a slide in milliseconds
+///
+/// Slides with narration and no duration last as long as the narration.
+fn slide_duration(entry: &SlideEntry, ffmpeg: Option<&str>) -> Result<u32> {
+    let (audio, ffmpeg) = match (entry.audio.as_deref(), ffmpeg) {
+        (Some(audio), Some(ffmpeg)) => (audio, ffmpeg),
+        _ => return Ok(entry.duration_ms),
+    };
+
+    check_audio_file(audio)?;
+    if entry.duration_ms > 0 {
+        return Ok(entry.duration_ms);
+    }
+
+    let seconds = ffmpeg::probe_duration(ffmpeg, audio)?;
+    Ok((seconds * 1000.0).ceil().min(u32::MAX as f64) as u32)
+}
+
+/// Slide number text for a 1-based slide number
+fn slide_number_text(format: &str, n: usize, total: usize) -> String {
+    let format = if format.is_empty() {
+        DEFAULT_SLIDE_NUMBER_FORMAT
+    } else {
+        format
+    };
+    format
+        .replace("{n}", &n.to_string())
+        .replace("{total}", &total.to_string())
+}
+
+/// Position of slide numbers
+///
+/// `Anchor::Auto` selects the top right for horizontal captions and the
+/// bottom left for vertical captions, away from the default caption position.
+fn slide_number_anchor(options: &EncodeOptions) -> Anchor {
+    match (options.slide_number_anchor, options.writing_mode) {
+        (Anchor::Auto, WritingMode::Horizontal) => Anchor::TopRight,
+        (Anchor::Auto, WritingMode::Vertical) => Anchor::BottomLeft,
+        (anchor, _) => anchor,
+    }
+}
+
+/// Chapter of a 1-based slide number starting at `frame`, titled with the
+/// first line of its caption
+fn slide_chapter(entry: &SlideEntry, number: usize, frame: u64, fps: u32) -> Chapter {
+    let caption = entry
+        .caption
+        .as_deref()
+        .and_then(|c| c.lines().map(str::trim).find(|line| !line.is_empty()));
+    Chapter {
+        start_ms: frame * 1000 / fps as u64,
+        title: match caption {
+            Some(line) => line.to_string(),
+            None => format!("Slide {}", number),
+        },
+    }
+}
+
+/// Chapters written into the output: one per slide with
+/// `ChapterMode::Slides`, the listed ones otherwise
+fn output_chapters(options: &EncodeOptions, slide_chapters: Vec<Chapter>) -> Result<Vec<Chapter>> {
+    match options.chapter_mode {
+        ChapterMode::Slides => {
+            options.check_chapters(&slide_chapters)?;
+            Ok(slide_chapters)
+        }
+        ChapterMode::List => Ok(options.chapters.clone()),
+    }
+}
+
+/// Check whether a slide has a non-empty caption
+fn has_caption(entry: &SlideEntry) -> bool {
+    entry
+        .caption
+        .as_deref()
+        .map(|c| !c.trim().is_empty())
+        .unwrap_or(false)
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -116,9 +1428,75 @@ mod tests {
             codec: crate::Codec::Av1,
             quality: 50,
             ffmpeg_path: None,
+            ..Default::default()
         };
 
         let result = slideshow(&[], &options);
         assert!(result.is_err());
     }
+
+    #[test]
+    fn test_animation_frame_at() {
+        let frame = |width| LoadedImage {
+            width,
+            height: 1,
+            data: vec![0; width as usize * 4],
+        };
+        let animation = Animation {
+            frames: vec![(frame(1), 100), (frame(2), 300), (frame(3), 400)],
+        };
+        assert_eq!(animation.cycle_ms(), 400);
+        assert_eq!(animation.frame_at(0).width, 1);
+        assert_eq!(animation.frame_at(99).width, 1);
+        assert_eq!(animation.frame_at(100).width, 2);
+        assert_eq!(animation.frame_at(399).width, 3);
+        // Animations loop
+        assert_eq!(animation.frame_at(450).width, 1);
+        assert_eq!(animation.frame_at(1_300).width, 2);
+
+        let still = Animation::still(frame(5));
+        assert_eq!(still.cycle_ms(), 0);
+        assert_eq!(still.frame_at(10_000).width, 5);
+    }
+
+    #[test]
+    fn test_cover_size() {
+        // Wider images are scaled to the frame height, taller ones to its width
+        assert_eq!(cover_size(400, 100, 1280, 720), (2880, 720));
+        assert_eq!(cover_size(100, 400, 1280, 720), (1280, 5120));
+        assert_eq!(cover_size(16, 9, 1280, 720), (1280, 720));
+        // Fractional sizes round up to still cover the frame
+        assert_eq!(cover_size(3, 2, 100, 100), (150, 100));
+        assert_eq!(cover_size(3, 7, 100, 100), (100, 234));
+    }
+
+    #[test]
+    fn test_slide_number_text() {
+        assert_eq!(slide_number_text("", 3, 10), "3 / 10");
+        assert_eq!(
+            slide_number_text("Slide {n} of {total}", 1, 2),
+            "Slide 1 of 2"
+        );
+        assert_eq!(slide_number_text("{n}", 7, 9), "7");
+    }
+
+    #[test]
+    fn test_slide_number_anchor() {
+        let mut options = EncodeOptions::default();
+        assert_eq!(slide_number_anchor(&options), Anchor::TopRight);
+
+        options.writing_mode = WritingMode::Vertical;
+        assert_eq!(slide_number_anchor(&options), Anchor::BottomLeft);
+
+        options.slide_number_anchor = Anchor::BottomCenter;
+        assert_eq!(slide_number_anchor(&options), Anchor::BottomCenter);
+    }
+
+    #[test]
+    fn test_scaled_size() {
+        assert_eq!(scaled_size(1920, 100), 1920);
+        assert_eq!(scaled_size(1920, 50), 960);
+        assert_eq!(scaled_size(1920, 0), MIN_SCALED_SIZE);
+        assert_eq!(scaled_size(10, 10), 10);
+    }
 }
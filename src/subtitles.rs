@@ -0,0 +1,176 @@
+//! SRT and WebVTT subtitle files
+//!
+//! Only the cue timings and text are read. Cue numbers, WebVTT headers,
+//! notes, styles and cue settings are skipped, and formatting tags such as
+//! `<i>` and `{\an8}` are removed from the text.
+
+use crate::{Error, Result};
+use std::path::Path;
+
+/// A subtitle shown over a span of the video
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(crate) struct Cue {
+    /// Start of the cue in milliseconds
+    pub start_ms: u64,
+    /// End of the cue in milliseconds
+    pub end_ms: u64,
+    /// Lines of the cue separated by `\n`
+    pub text: String,
+}
+
+/// Read the cues of an SRT or WebVTT file
+pub(crate) fn load_subtitles(path: &Path) -> Result<Vec<Cue>> {
+    let data = std::fs::read(path).map_err(|e| {
+        Error::InvalidInput(format!("Cannot read subtitles {}: {}", path.display(), e))
+    })?;
+    let text = String::from_utf8_lossy(&data);
+    let cues = parse_subtitles(&text)?;
+    if cues.is_empty() {
+        return Err(Error::InvalidInput(format!(
+            "No subtitle cues in {}",
+            path.display()
+        )));
+    }
+    Ok(cues)
+}
+
+/// Parse the cues of SRT or WebVTT text
+fn parse_subtitles(text: &str) -> Result<Vec<Cue>> {
+    let text = text.trim_start_matches('\u{feff}').replace("\r\n", "\n");
+
+    let mut cues = Vec::new();
+    for block in text.split("\n\n") {
+        let mut lines = block.lines().skip_while(|line| !line.contains("-->"));
+        let Some(timing) = lines.next() else {
+            continue;
+        };
+        let (start, end) = timing.split_once("-->").unwrap_or_default();
+        // WebVTT cue settings follow the end time
+        let end = end.split_whitespace().next().unwrap_or_default();
+        let (Some(start_ms), Some(end_ms)) = (parse_timestamp(start.trim()), parse_timestamp(end))
+        else {
+            return Err(Error::InvalidInput(format!(
+                "Invalid subtitle timing: {}",
+                timing.trim()
+            )));
+        };
+
+        let text = lines
+            .map(strip_tags)
+            .map(|line| line.trim().to_string())
+            .filter(|line| !line.is_empty())
+            .collect::<Vec<_>>()
+            .join("\n");
+        if !text.is_empty() && end_ms > start_ms {
+            cues.push(Cue {
+                start_ms,
+                end_ms,
+                text,
+            });
+        }
+    }
+    Ok(cues)
+}
+
+/// Milliseconds of a `hh:mm:ss,mmm` (SRT) or `[hh:]mm:ss.mmm` (WebVTT)
+/// timestamp
+fn parse_timestamp(text: &str) -> Option<u64> {
+    let (clock, fraction) = text.split_once([',', '.'])?;
+    if fraction.is_empty() || fraction.len() > 3 || !fraction.bytes().all(|b| b.is_ascii_digit()) {
+        return None;
+    }
+    let millis = fraction.parse::<u64>().ok()? * 10u64.pow(3 - fraction.len() as u32);
+
+    let parts = clock
+        .split(':')
+        .map(|part| part.parse::<u64>().ok())
+        .collect::<Option<Vec<_>>>()?;
+    let seconds = match parts[..] {
+        [m, s] if s < 60 => m * 60 + s,
+        [h, m, s] if m < 60 && s < 60 => (h * 60 + m) * 60 + s,
+        _ => return None,
+    };
+    Some(seconds * 1000 + millis)
+}
+
+/// Remove HTML-like tags and ASS override blocks from a line
+fn strip_tags(line: &str) -> String {
+    let mut text = String::with_capacity(line.len());
+    let mut closing = None;
+    for c in line.chars() {
+        match (closing, c) {
+            (None, '<') => closing = Some('>'),
+            (None, '{') => closing = Some('}'),
+            (None, c) => text.push(c),
+            (Some(end), c) if c == end => closing = None,
+            _ => {}
+        }
+    }
+    text
+}
+
+/// Text of the cues shown at `time_ms`, one above the other (None when no
+/// cue is shown)
+pub(crate) fn text_at(cues: &[Cue], time_ms: u64) -> Option<String> {
+    let texts: Vec<&str> = cues
+        .iter()
+        .filter(|cue| cue.start_ms <= time_ms && time_ms < cue.end_ms)
+        .map(|cue| cue.text.as_str())
+        .collect();
+    (!texts.is_empty()).then(|| texts.join("\n"))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_srt() {
+        let srt = "\u{feff}1\r\n00:00:01,000 --> 00:00:02,500\r\nHello <i>world</i>\r\n\r\n\
+                   2\r\n00:00:03,000 --> 00:00:04,000\r\n{\\an8}Two\r\nlines\r\n";
+        let cues = parse_subtitles(srt).unwrap();
+        assert_eq!(
+            cues,
+            [
+                Cue {
+                    start_ms: 1000,
+                    end_ms: 2500,
+                    text: "Hello world".to_string(),
+                },
+                Cue {
+                    start_ms: 3000,
+                    end_ms: 4000,
+                    text: "Two\nlines".to_string(),
+                },
+            ]
+        );
+    }
+
+    #[test]
+    fn test_parse_vtt() {
+        let vtt = "WEBVTT\n\nNOTE a comment\n\nintro\n00:01.5 --> 01:02.250 align:start\n\
+                   <v Speaker>Hi\n\n01:00:00.000 --> 01:00:01.000\nLate\n";
+        let cues = parse_subtitles(vtt).unwrap();
+        assert_eq!(cues.len(), 2);
+        assert_eq!((cues[0].start_ms, cues[0].end_ms), (1500, 62_250));
+        assert_eq!(cues[0].text, "Hi");
+        assert_eq!(cues[1].start_ms, 3_600_000);
+
+        assert!(parse_subtitles("00:00:01 --> 00:00:02\nNo fraction").is_err());
+        assert!(parse_subtitles("WEBVTT\n").unwrap().is_empty());
+    }
+
+    #[test]
+    fn test_text_at() {
+        let cue = |start_ms, end_ms, text: &str| Cue {
+            start_ms,
+            end_ms,
+            text: text.to_string(),
+        };
+        let cues = [cue(0, 1000, "a"), cue(500, 1500, "b")];
+        assert_eq!(text_at(&cues, 0).as_deref(), Some("a"));
+        assert_eq!(text_at(&cues, 700).as_deref(), Some("a\nb"));
+        assert_eq!(text_at(&cues, 1000).as_deref(), Some("b"));
+        assert_eq!(text_at(&cues, 1500), None);
+    }
+}
@@ -0,0 +1,72 @@
+//! Temporary files of a job
+//!
+//! Video is piped to ffmpeg rather than written to intermediate files, so
+//! the temporary files here are the statistics of two-pass encodes, which
+//! libvpx reads back from a file, and the audio written to video writers.
+//! They are kept next to the output, or in
+//! `EncodeOptions::temp_dir`, such as a scratch volume larger than the
+//! output's, and removed when the encode ends. The copy of a MOV or MP4
+//! file whose movie box is moved to the front is always written next to the
+//! output instead, since it replaces the output by renaming.
+
+use crate::EncodeOptions;
+use std::path::{Path, PathBuf};
+use std::process;
+use std::sync::atomic::{AtomicU64, Ordering};
+
+/// Suffix of the statistics files of two-pass encodes
+pub(crate) const PASS_SUFFIX: &str = ".pass";
+
+/// Temporary paths named in a temporary directory, keeping apart the files
+/// of concurrent jobs with outputs of the same name
+static NAMED: AtomicU64 = AtomicU64::new(0);
+
+/// Path of a temporary file of the output of the options, named after the
+/// output with `suffix`
+pub(crate) fn path(options: &EncodeOptions, suffix: &str) -> PathBuf {
+    let output = Path::new(&options.output_path);
+    let mut name = match &options.temp_dir {
+        Some(dir) => {
+            let mut name = Path::new(dir)
+                .join(output.file_name().unwrap_or_default())
+                .into_os_string();
+            name.push(format!(
+                ".{}-{}",
+                process::id(),
+                NAMED.fetch_add(1, Ordering::Relaxed)
+            ));
+            name
+        }
+        None => output.as_os_str().to_os_string(),
+    };
+    name.push(suffix);
+    PathBuf::from(name)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_path() {
+        let options = EncodeOptions {
+            output_path: "/out/video.webm".to_string(),
+            ..Default::default()
+        };
+        assert_eq!(
+            path(&options, PASS_SUFFIX),
+            PathBuf::from("/out/video.webm.pass")
+        );
+
+        // Names in a temporary directory differ between calls
+        let options = EncodeOptions {
+            temp_dir: Some("/scratch".to_string()),
+            ..options
+        };
+        let (first, second) = (path(&options, PASS_SUFFIX), path(&options, PASS_SUFFIX));
+        assert_ne!(first, second);
+        assert!(first.starts_with("/scratch"));
+        let name = first.file_name().unwrap().to_string_lossy().into_owned();
+        assert!(name.starts_with("video.webm.") && name.ends_with(".pass"));
+    }
+}
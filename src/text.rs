@@ -0,0 +1,964 @@
+//! Text rendering for captions
+//!
+//! Text is shaped with rustybuzz, so ligatures, combining marks and
+//! contextual forms (such as Arabic joining) render correctly. Horizontal
+//! lines are reordered with the Unicode bidirectional algorithm for
+//! right-to-left scripts, and lines are broken at Unicode line break
+//! opportunities, which allows breaks between CJK characters.
+//!
+//! Emoji are shaped with the emoji font from [`font::emoji_font`]. Color
+//! bitmap glyphs from such fonts are scaled and composited in their own
+//! colors instead of the text color.
+
+use crate::font;
+use crate::image_loader::LoadedImage;
+use crate::overlay::SafeArea;
+use crate::{Anchor, EncodeOptions, TextAlign, TextFit, WritingMode};
+use ab_glyph::{point, Font, FontArc, GlyphId, Point, PxScale, ScaleFont};
+use image::imageops::FilterType;
+use std::ops::Range;
+use unicode_bidi::BidiInfo;
+use unicode_linebreak::{linebreaks, BreakOpportunity};
+
+/// Caption text color (RGBA)
+const CAPTION_TEXT_COLOR: [u8; 4] = [255, 255, 255, 255];
+
+/// Caption background box color (RGBA)
+const CAPTION_BOX_COLOR: [u8; 4] = [0, 0, 0, 160];
+
+/// Maximum share of the frame a shrunk caption may span across its lines
+const CAPTION_MAX_ACROSS: f32 = 1.0 / 3.0;
+
+/// Smallest font size captions are shrunk to, in pixels
+const CAPTION_MIN_SIZE: f32 = 8.0;
+
+/// Factor applied to the font size on each shrink step
+const CAPTION_SHRINK_STEP: f32 = 0.9;
+
+/// Font size of labels such as slide numbers relative to the frame height
+const LABEL_SIZE_RATIO: f32 = 1.0 / 30.0;
+
+/// Smallest font size of labels, in pixels
+const LABEL_MIN_SIZE: f32 = 10.0;
+
+/// A glyph positioned relative to the start of its line
+///
+/// For horizontal text `y` is the offset from the baseline; for vertical
+/// text `x` is the offset from the column center and `y` the baseline
+/// position below the top of the column.
+#[derive(Debug, Clone)]
+struct ShapedGlyph {
+    font: FontArc,
+    id: GlyphId,
+    x: f32,
+    y: f32,
+}
+
+/// A shaped line of text
+#[derive(Debug, Clone, Default)]
+struct ShapedLine {
+    glyphs: Vec<ShapedGlyph>,
+    /// Length of the line along the writing direction in pixels
+    advance: f32,
+}
+
+/// Measure the length of a single line of text in pixels
+///
+/// This is the width for horizontal text and the height for vertical text.
+pub fn measure_line(font: &FontArc, text: &str, size: f32, mode: WritingMode) -> f32 {
+    shape_line(font, text, size, mode).advance
+}
+
+/// Height of one line of text in pixels, including the line gap
+///
+/// Vertical text uses the same value as the width of a column.
+pub fn line_height(font: &FontArc, size: f32) -> f32 {
+    let scaled = font.as_scaled(PxScale::from(size));
+    scaled.height() + scaled.line_gap()
+}
+
+/// Break text into lines no longer than `max_extent` pixels
+///
+/// Explicit `\n` breaks are kept. Other breaks happen at Unicode line break
+/// opportunities; a segment longer than `max_extent` is split between characters.
+pub fn wrap_text(
+    font: &FontArc,
+    text: &str,
+    size: f32,
+    max_extent: f32,
+    mode: WritingMode,
+) -> Vec<String> {
+    let mut lines = Vec::new();
+
+    for paragraph in text.lines() {
+        let mut line = String::new();
+        let mut start = 0;
+
+        for (end, opportunity) in linebreaks(paragraph) {
+            let segment = &paragraph[start..end];
+            start = end;
+
+            let candidate = format!("{}{}", line, segment);
+            if line.is_empty() || measure_line(font, candidate.trim_end(), size, mode) <= max_extent
+            {
+                line = candidate;
+            } else {
+                lines.push(line.trim_end().to_string());
+                line = segment.to_string();
+            }
+
+            // Split a segment that cannot fit on a line of its own
+            while measure_line(font, line.trim_end(), size, mode) > max_extent {
+                let split = split_to_fit(font, &line, size, max_extent, mode);
+                if split == 0 || split >= line.len() {
+                    break;
+                }
+                lines.push(line[..split].to_string());
+                line = line[split..].to_string();
+            }
+
+            if opportunity == BreakOpportunity::Mandatory && !line.trim().is_empty() {
+                lines.push(line.trim_end().to_string());
+                line.clear();
+            }
+        }
+
+        if !line.trim().is_empty() {
+            lines.push(line.trim_end().to_string());
+        }
+    }
+
+    lines
+}
+
+/// Byte index of the longest prefix of `text` that fits in `max_extent`
+///
+/// Always keeps at least one character so wrapping makes progress.
+fn split_to_fit(
+    font: &FontArc,
+    text: &str,
+    size: f32,
+    max_extent: f32,
+    mode: WritingMode,
+) -> usize {
+    let mut fit = 0;
+    for (i, _) in text.char_indices().skip(1) {
+        if measure_line(font, &text[..i], size, mode) > max_extent {
+            break;
+        }
+        fit = i;
+    }
+    match fit {
+        0 => text.chars().next().map(char::len_utf8).unwrap_or(0),
+        n => n,
+    }
+}
+
+/// Shape a single line of text
+fn shape_line(font: &FontArc, text: &str, size: f32, mode: WritingMode) -> ShapedLine {
+    let mut line = ShapedLine::default();
+    if text.is_empty() {
+        return line;
+    }
+
+    match mode {
+        WritingMode::Horizontal => {
+            // Reorder into visual runs, each with a single direction
+            let bidi = BidiInfo::new(text, None);
+            for para in &bidi.paragraphs {
+                let (levels, runs) = bidi.visual_runs(para, para.range.clone());
+                for run in runs {
+                    let direction = if levels[run.start].is_rtl() {
+                        rustybuzz::Direction::RightToLeft
+                    } else {
+                        rustybuzz::Direction::LeftToRight
+                    };
+                    shape_run(font, &text[run], size, direction, &mut line);
+                }
+            }
+        }
+        WritingMode::Vertical => {
+            shape_run(
+                font,
+                text,
+                size,
+                rustybuzz::Direction::TopToBottom,
+                &mut line,
+            );
+        }
+    }
+
+    line
+}
+
+/// Shape a run of a single direction and append its glyphs to the line
+///
+/// The run is split further by font, so characters missing from the
+/// selected font are shaped with a fallback font.
+fn shape_run(
+    font: &FontArc,
+    text: &str,
+    size: f32,
+    direction: rustybuzz::Direction,
+    line: &mut ShapedLine,
+) {
+    let mut runs = font_runs(font, text);
+    if direction == rustybuzz::Direction::RightToLeft {
+        runs.reverse();
+    }
+    let vertical = direction == rustybuzz::Direction::TopToBottom;
+
+    for (run_font, range) in runs {
+        let scale = run_font.as_scaled(PxScale::from(size)).h_scale_factor();
+        let face = match rustybuzz::Face::from_slice(run_font.font_data(), 0) {
+            Some(f) => f,
+            None => continue,
+        };
+
+        let mut buffer = rustybuzz::UnicodeBuffer::new();
+        buffer.push_str(&text[range]);
+        buffer.set_direction(direction);
+        buffer.guess_segment_properties();
+
+        let shaped = rustybuzz::shape(&face, &[], buffer);
+
+        for (info, pos) in shaped.glyph_infos().iter().zip(shaped.glyph_positions()) {
+            let (x, y) = if vertical {
+                (
+                    pos.x_offset as f32 * scale,
+                    line.advance - pos.y_offset as f32 * scale,
+                )
+            } else {
+                (
+                    line.advance + pos.x_offset as f32 * scale,
+                    -pos.y_offset as f32 * scale,
+                )
+            };
+
+            line.glyphs.push(ShapedGlyph {
+                font: run_font.clone(),
+                id: GlyphId(info.glyph_id as u16),
+                x,
+                y,
+            });
+
+            line.advance += if vertical {
+                -pos.y_advance as f32 * scale
+            } else {
+                pos.x_advance as f32 * scale
+            };
+        }
+    }
+}
+
+/// Split text into byte ranges that share the font used to draw them
+fn font_runs(font: &FontArc, text: &str) -> Vec<(FontArc, Range<usize>)> {
+    let fallbacks = font::fallback_fonts();
+    let emoji = font::emoji_font();
+    let mut runs: Vec<(FontArc, Range<usize>)> = Vec::new();
+
+    let mut chars = text.char_indices().peekable();
+    while let Some((i, c)) = chars.next() {
+        let end = i + c.len_utf8();
+
+        // Spaces and marks stay with the current run to keep clusters intact
+        if let Some(last) = runs.last_mut() {
+            if c.is_whitespace() || is_combining(c) {
+                last.1.end = end;
+                continue;
+            }
+        }
+
+        // U+FE0F requests emoji presentation for characters such as ♥ or ©
+        let emoji_presentation = is_emoji(c) || matches!(chars.peek(), Some((_, '\u{FE0F}')));
+
+        let chosen = match &emoji {
+            Some(e) if emoji_presentation && has_glyph(e, c) => e.clone(),
+            _ if has_glyph(font, c) => font.clone(),
+            _ => fallbacks
+                .iter()
+                .find(|f| has_glyph(f, c))
+                .cloned()
+                .unwrap_or_else(|| font.clone()),
+        };
+
+        match runs.last_mut() {
+            Some(last) if same_font(&last.0, &chosen) => last.1.end = end,
+            _ => runs.push((chosen, i..end)),
+        }
+    }
+
+    runs
+}
+
+fn has_glyph(font: &FontArc, c: char) -> bool {
+    font.glyph_id(c).0 != 0
+}
+
+fn same_font(a: &FontArc, b: &FontArc) -> bool {
+    std::ptr::eq(a.font_data().as_ptr(), b.font_data().as_ptr())
+}
+
+/// Check for joiners, variation selectors and common combining marks
+///
+/// Emoji modifiers, tag characters and the keycap mark are included so
+/// skin tones, subdivision flags and keycaps stay in one emoji cluster.
+fn is_combining(c: char) -> bool {
+    matches!(
+        c as u32,
+        0x0300..=0x036F
+            | 0x0591..=0x05C7
+            | 0x064B..=0x065F
+            | 0x200C..=0x200D
+            | 0x20E3
+            | 0x3099..=0x309A
+            | 0xFE00..=0xFE0F
+            | 0x1F3FB..=0x1F3FF
+            | 0xE0020..=0xE007F
+            | 0xE0100..=0xE01EF
+    )
+}
+
+/// Check for characters that are presented as emoji by default
+///
+/// Symbols that default to text presentation (such as © or ↔) only use
+/// the emoji font when followed by U+FE0F.
+fn is_emoji(c: char) -> bool {
+    matches!(
+        c as u32,
+        0x231A..=0x231B
+            | 0x23E9..=0x23F3
+            | 0x23F8..=0x23FA
+            | 0x2600..=0x27BF
+            | 0x2B1B..=0x2B1C
+            | 0x2B50
+            | 0x2B55
+            | 0x1F000..=0x1FAFF
+    )
+}
+
+/// Draw a shaped line
+///
+/// For horizontal text (x, y) is the top-left corner of the line; for
+/// vertical text it is the top of the column center line.
+fn draw_shaped(
+    image: &mut LoadedImage,
+    line: &ShapedLine,
+    size: f32,
+    x: f32,
+    y: f32,
+    mode: WritingMode,
+    color: [u8; 4],
+) {
+    let scale = PxScale::from(size);
+
+    for g in &line.glyphs {
+        let origin = match mode {
+            WritingMode::Horizontal => {
+                let ascent = g.font.as_scaled(scale).ascent();
+                point(x + g.x, y + ascent + g.y)
+            }
+            WritingMode::Vertical => point(x + g.x, y + g.y),
+        };
+
+        if draw_raster_glyph(image, g, size, origin) {
+            continue;
+        }
+
+        let glyph = g.id.with_scale_and_position(scale, origin);
+        if let Some(outlined) = g.font.outline_glyph(glyph) {
+            let bounds = outlined.px_bounds();
+            outlined.draw(|gx, gy, coverage| {
+                let px = bounds.min.x as i64 + gx as i64;
+                let py = bounds.min.y as i64 + gy as i64;
+                blend_pixel(image, px, py, color, coverage);
+            });
+        }
+    }
+}
+
+/// Composite a color bitmap glyph (e.g. an emoji) at the glyph origin
+///
+/// Returns false when the font has no PNG bitmap for the glyph, in which
+/// case the outline should be drawn instead.
+fn draw_raster_glyph(image: &mut LoadedImage, g: &ShapedGlyph, size: f32, origin: Point) -> bool {
+    let face = match ttf_parser::Face::parse(g.font.font_data(), 0) {
+        Ok(f) => f,
+        Err(_) => return false,
+    };
+
+    // Pixels per em at the requested size, used to pick and scale a strike
+    let em = g.font.as_scaled(PxScale::from(size)).h_scale_factor() * face.units_per_em() as f32;
+    let strike = em.round().clamp(1.0, u16::MAX as f32) as u16;
+
+    let raster = match face.glyph_raster_image(ttf_parser::GlyphId(g.id.0), strike) {
+        Some(r)
+            if matches!(r.format, ttf_parser::RasterImageFormat::PNG) && r.pixels_per_em > 0 =>
+        {
+            r
+        }
+        _ => return false,
+    };
+    let bitmap = match image::load_from_memory(raster.data) {
+        Ok(img) => img.to_rgba8(),
+        Err(_) => return false,
+    };
+
+    // Bitmap offsets are measured from the origin with y pointing up
+    let k = em / raster.pixels_per_em as f32;
+    let width = (raster.width as f32 * k).round().max(1.0) as u32;
+    let height = (raster.height as f32 * k).round().max(1.0) as u32;
+    let left = (origin.x + raster.x as f32 * k).round() as i64;
+    let top = (origin.y - (raster.y as f32 + raster.height as f32) * k).round() as i64;
+
+    let scaled = image::imageops::resize(&bitmap, width, height, FilterType::Triangle);
+    for (px, py, pixel) in scaled.enumerate_pixels() {
+        let [r, g, b, a] = pixel.0;
+        blend_pixel(image, left + px as i64, top + py as i64, [r, g, b, a], 1.0);
+    }
+
+    true
+}
+
+/// Draw a single line of text
+///
+/// For horizontal text (x, y) is the top-left corner of the line; for
+/// vertical text it is the top of the column center line.
+#[allow(clippy::too_many_arguments)]
+pub fn draw_line(
+    image: &mut LoadedImage,
+    font: &FontArc,
+    text: &str,
+    size: f32,
+    x: f32,
+    y: f32,
+    mode: WritingMode,
+    color: [u8; 4],
+) {
+    let line = shape_line(font, text, size, mode);
+    draw_shaped(image, &line, size, x, y, mode, color);
+}
+
+/// Fill a rectangle, blending the color over the existing pixels
+pub fn fill_rect(image: &mut LoadedImage, x: i64, y: i64, width: i64, height: i64, color: [u8; 4]) {
+    let x0 = x.max(0);
+    let y0 = y.max(0);
+    let x1 = (x + width).min(image.width as i64);
+    let y1 = (y + height).min(image.height as i64);
+
+    for py in y0..y1 {
+        for px in x0..x1 {
+            blend_pixel(image, px, py, color, 1.0);
+        }
+    }
+}
+
+/// Layout settings for captions
+#[derive(Debug, Clone, Copy, PartialEq, Default)]
+pub struct CaptionLayout {
+    /// Writing mode
+    pub writing_mode: WritingMode,
+    /// Alignment of lines within the caption box
+    pub align: TextAlign,
+    /// How the caption is fitted into the frame
+    pub fit: TextFit,
+    /// Maximum length of the caption along its lines as a share of the
+    /// frame (0.0 for the length of the safe area)
+    pub max_width: f32,
+    /// Position of the caption box
+    pub anchor: Anchor,
+    /// Safe-area margin in percent of the frame size (0 for the default)
+    pub safe_area: u32,
+}
+
+impl CaptionLayout {
+    /// Caption layout from the encode options
+    pub fn from_options(options: &EncodeOptions) -> Self {
+        Self {
+            writing_mode: options.writing_mode,
+            align: options.caption_align,
+            fit: options.caption_fit,
+            max_width: options.caption_max_width as f32 / 100.0,
+            anchor: options.caption_anchor,
+            safe_area: options.safe_area,
+        }
+    }
+}
+
+/// Draw a caption on the image
+///
+/// The caption box is placed at the layout's anchor inside the safe area;
+/// by default horizontal captions sit at the bottom center and vertical
+/// captions, set in columns running right to left, at the top right. The caption
+/// is wrapped or shrunk to fit the frame according to the layout, and
+/// explicit `\n` breaks are kept. It is drawn in white on a translucent
+/// dark box so it stays readable on any slide.
+pub fn draw_caption(image: &mut LoadedImage, font: &FontArc, text: &str, layout: &CaptionLayout) {
+    let mode = layout.writing_mode;
+    let (size, lines) = fit_caption(image, font, text, layout);
+    if lines.is_empty() {
+        return;
+    }
+
+    let line_h = line_height(font, size);
+    let padding = size * 0.4;
+    let safe = SafeArea::new(image.width, image.height, layout.safe_area);
+
+    let longest = lines.iter().map(|l| l.advance).fold(0.0, f32::max);
+    let across = line_h * lines.len() as f32 + padding * 2.0;
+    let along = longest + padding * 2.0;
+
+    // Offset of each line within the box along the writing direction
+    let align = |line: &ShapedLine| {
+        let slack = longest - line.advance;
+        padding
+            + match layout.align {
+                TextAlign::Start => 0.0,
+                TextAlign::Center => slack / 2.0,
+                TextAlign::End => slack,
+            }
+    };
+
+    match mode {
+        WritingMode::Horizontal => {
+            let (box_x, box_y) = safe.place(layout.anchor, along, across);
+            fill_rect(
+                image,
+                box_x.round() as i64,
+                box_y.round() as i64,
+                along.round() as i64,
+                across.round() as i64,
+                CAPTION_BOX_COLOR,
+            );
+
+            for (i, line) in lines.iter().enumerate() {
+                let x = box_x + align(line);
+                let y = box_y + padding + line_h * i as f32;
+                draw_shaped(image, line, size, x, y, mode, CAPTION_TEXT_COLOR);
+            }
+        }
+        WritingMode::Vertical => {
+            let anchor = match layout.anchor {
+                Anchor::Auto => Anchor::TopRight,
+                a => a,
+            };
+            let (box_x, box_y) = safe.place(anchor, across, along);
+            fill_rect(
+                image,
+                box_x.round() as i64,
+                box_y.round() as i64,
+                across.round() as i64,
+                along.round() as i64,
+                CAPTION_BOX_COLOR,
+            );
+
+            // The first column is the rightmost one
+            for (i, line) in lines.iter().enumerate() {
+                let x = box_x + across - padding - line_h * (i as f32 + 0.5);
+                let y = box_y + align(line);
+                draw_shaped(image, line, size, x, y, mode, CAPTION_TEXT_COLOR);
+            }
+        }
+    }
+}
+
+/// Draw a short single-line label, such as a slide number, on the image
+///
+/// The label is smaller than captions, is never wrapped and is drawn in
+/// the caption colors at the anchor inside the safe area. `Anchor::Auto`
+/// is treated as bottom center.
+pub fn draw_label(
+    image: &mut LoadedImage,
+    font: &FontArc,
+    text: &str,
+    anchor: Anchor,
+    safe_area: u32,
+) {
+    let text = text.trim();
+    if text.is_empty() {
+        return;
+    }
+
+    let size = (image.height as f32 * LABEL_SIZE_RATIO).max(LABEL_MIN_SIZE);
+    let line = shape_line(font, text, size, WritingMode::Horizontal);
+    let padding = size * 0.4;
+    let width = line.advance + padding * 2.0;
+    let height = line_height(font, size) + padding * 2.0;
+
+    let safe = SafeArea::new(image.width, image.height, safe_area);
+    let (box_x, box_y) = safe.place(anchor, width, height);
+    fill_rect(
+        image,
+        box_x.round() as i64,
+        box_y.round() as i64,
+        width.round() as i64,
+        height.round() as i64,
+        CAPTION_BOX_COLOR,
+    );
+    draw_shaped(
+        image,
+        &line,
+        size,
+        box_x + padding,
+        box_y + padding,
+        WritingMode::Horizontal,
+        CAPTION_TEXT_COLOR,
+    );
+}
+
+/// Choose the font size and lines of a caption
+///
+/// The size starts proportional to the frame height and is reduced in
+/// steps, down to [`CAPTION_MIN_SIZE`], until the caption fits. Lines that
+/// are still too long at the minimum size are wrapped.
+fn fit_caption(
+    image: &LoadedImage,
+    font: &FontArc,
+    text: &str,
+    layout: &CaptionLayout,
+) -> (f32, Vec<ShapedLine>) {
+    let mode = layout.writing_mode;
+    let safe = SafeArea::new(image.width, image.height, layout.safe_area);
+    let (frame_along, frame_across, safe_along) = match mode {
+        WritingMode::Horizontal => (image.width as f32, image.height as f32, safe.width()),
+        WritingMode::Vertical => (image.height as f32, image.width as f32, safe.height()),
+    };
+    let limit = if layout.max_width > 0.0 {
+        frame_along * layout.max_width.min(1.0)
+    } else {
+        safe_along
+    };
+
+    let mut size = (image.height as f32 / 18.0).max(12.0);
+    loop {
+        let padding = size * 0.4;
+        let max_extent = (limit - padding * 2.0).max(size);
+        let at_min = size <= CAPTION_MIN_SIZE;
+
+        let wrapped = match layout.fit {
+            TextFit::Shrink if !at_min => text
+                .lines()
+                .map(str::trim_end)
+                .filter(|l| !l.trim().is_empty())
+                .map(str::to_string)
+                .collect(),
+            _ => wrap_text(font, text, size, max_extent, mode),
+        };
+        let lines: Vec<ShapedLine> = wrapped
+            .iter()
+            .map(|l| shape_line(font, l, size, mode))
+            .collect();
+
+        let fits = match layout.fit {
+            TextFit::Wrap => true,
+            TextFit::Shrink => lines.iter().all(|l| l.advance <= max_extent),
+            TextFit::WrapShrink => {
+                let across = line_height(font, size) * lines.len() as f32 + padding * 2.0;
+                across <= frame_across * CAPTION_MAX_ACROSS
+            }
+        };
+
+        if fits || at_min {
+            return (size, lines);
+        }
+        size = (size * CAPTION_SHRINK_STEP).max(CAPTION_MIN_SIZE);
+    }
+}
+
+/// Blend a color into a single pixel with the given coverage (0.0-1.0)
+fn blend_pixel(image: &mut LoadedImage, x: i64, y: i64, color: [u8; 4], coverage: f32) {
+    if x < 0 || y < 0 || x >= image.width as i64 || y >= image.height as i64 {
+        return;
+    }
+
+    let alpha = (color[3] as f32 / 255.0) * coverage.clamp(0.0, 1.0);
+    if alpha <= 0.0 {
+        return;
+    }
+
+    let idx = ((y as usize * image.width as usize) + x as usize) * 4;
+    let pixel = &mut image.data[idx..idx + 4];
+
+    for (dst, src) in pixel.iter_mut().zip(color.iter()).take(3) {
+        *dst = (*dst as f32 * (1.0 - alpha) + *src as f32 * alpha).round() as u8;
+    }
+    let dst_alpha = pixel[3] as f32 / 255.0;
+    pixel[3] = ((alpha + dst_alpha * (1.0 - alpha)) * 255.0).round() as u8;
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::font::default_font;
+
+    fn solid_image(width: u32, height: u32, rgba: [u8; 4]) -> LoadedImage {
+        LoadedImage {
+            width,
+            height,
+            data: rgba.repeat((width * height) as usize),
+        }
+    }
+
+    #[test]
+    fn test_measure_line() {
+        let font = default_font();
+        let short = measure_line(&font, "Hi", 24.0, WritingMode::Horizontal);
+        let long = measure_line(&font, "Hello, world", 24.0, WritingMode::Horizontal);
+        assert!(short > 0.0);
+        assert!(long > short);
+    }
+
+    #[test]
+    fn test_measure_vertical_line() {
+        let font = default_font();
+        let one = measure_line(&font, "A", 24.0, WritingMode::Vertical);
+        let three = measure_line(&font, "ABC", 24.0, WritingMode::Vertical);
+        assert!(one > 0.0);
+        assert!(three > one * 2.0);
+    }
+
+    #[test]
+    fn test_wrap_text() {
+        let font = default_font();
+        let max = measure_line(&font, "Hello world", 24.0, WritingMode::Horizontal);
+
+        let lines = wrap_text(
+            &font,
+            "Hello world Hello world",
+            24.0,
+            max,
+            WritingMode::Horizontal,
+        );
+        assert_eq!(lines, vec!["Hello world", "Hello world"]);
+
+        let lines = wrap_text(&font, "a\nb", 24.0, max, WritingMode::Horizontal);
+        assert_eq!(lines, vec!["a", "b"]);
+    }
+
+    #[test]
+    fn test_wrap_cjk_text() {
+        let font = default_font();
+        let text = "日本語の字幕はスペースなしで折り返されます";
+        let max = measure_line(&font, "日本語の字幕", 24.0, WritingMode::Horizontal);
+
+        let lines = wrap_text(&font, text, 24.0, max, WritingMode::Horizontal);
+        assert!(lines.len() > 1);
+        assert_eq!(lines.concat(), text);
+    }
+
+    #[test]
+    fn test_wrap_long_word() {
+        let font = default_font();
+        let max = measure_line(&font, "abc", 24.0, WritingMode::Horizontal);
+
+        let lines = wrap_text(&font, "abcdefghij", 24.0, max, WritingMode::Horizontal);
+        assert!(lines.len() > 1);
+        assert_eq!(lines.concat(), "abcdefghij");
+    }
+
+    #[test]
+    fn test_rtl_line_is_shaped() {
+        let font = default_font();
+        let line = shape_line(&font, "שלום abc", 24.0, WritingMode::Horizontal);
+        assert!(!line.glyphs.is_empty());
+        assert!(line.advance > 0.0);
+    }
+
+    #[test]
+    fn test_emoji_characters() {
+        assert!(is_emoji('😀'));
+        assert!(is_emoji('☀'));
+        assert!(!is_emoji('A'));
+        assert!(!is_emoji('あ'));
+        assert!(!is_emoji('©'));
+        // Skin tone modifiers and ZWJ join the preceding emoji
+        assert!(is_combining('\u{1F3FD}'));
+        assert!(is_combining('\u{200D}'));
+    }
+
+    #[test]
+    fn test_emoji_sequence_stays_in_one_run() {
+        let font = default_font();
+        // Family emoji joined with ZWJ, followed by a skin-toned thumbs up
+        let text = "\u{1F468}\u{200D}\u{1F469}\u{200D}\u{1F467} \u{1F44D}\u{1F3FD}";
+        let runs = font_runs(&font, text);
+        assert_eq!(runs.len(), 1);
+        assert_eq!(runs[0].1, 0..text.len());
+    }
+
+    #[test]
+    fn test_draw_caption_with_emoji() {
+        let font = default_font();
+        let mut img = solid_image(320, 240, [0, 128, 255, 255]);
+        let original = img.data.clone();
+
+        draw_caption(
+            &mut img,
+            &font,
+            "Party time 🎉😀",
+            &CaptionLayout::default(),
+        );
+
+        assert_ne!(img.data, original);
+    }
+
+    #[test]
+    fn test_draw_caption_changes_pixels() {
+        let font = default_font();
+        let mut img = solid_image(320, 240, [0, 128, 255, 255]);
+        let original = img.data.clone();
+
+        draw_caption(&mut img, &font, "Caption", &CaptionLayout::default());
+
+        assert_ne!(img.data, original);
+        // Top of the image stays untouched
+        assert_eq!(img.data[..320 * 4], original[..320 * 4]);
+    }
+
+    #[test]
+    fn test_draw_vertical_caption() {
+        let font = default_font();
+        let mut img = solid_image(320, 240, [0, 128, 255, 255]);
+        let original = img.data.clone();
+
+        draw_caption(
+            &mut img,
+            &font,
+            "縦書き",
+            &CaptionLayout {
+                writing_mode: WritingMode::Vertical,
+                ..Default::default()
+            },
+        );
+
+        assert_ne!(img.data, original);
+        // Left edge of the image stays untouched
+        for y in 0..240 {
+            let idx = y * 320 * 4;
+            assert_eq!(img.data[idx..idx + 4], original[idx..idx + 4]);
+        }
+    }
+
+    #[test]
+    fn test_shrink_caption_keeps_lines() {
+        let font = default_font();
+        let img = solid_image(320, 240, [0, 0, 0, 255]);
+        let text = "A caption that is far too long for a single line of this frame";
+        let layout = CaptionLayout {
+            fit: TextFit::Shrink,
+            ..Default::default()
+        };
+
+        let (wrapped_size, wrapped) = fit_caption(&img, &font, text, &CaptionLayout::default());
+        let (size, lines) = fit_caption(&img, &font, text, &layout);
+
+        assert!(wrapped.len() > 1);
+        assert!(size < wrapped_size);
+        assert!(size >= CAPTION_MIN_SIZE);
+        assert!(lines.iter().all(|l| l.advance <= 320.0));
+    }
+
+    #[test]
+    fn test_wrap_shrink_caption() {
+        let font = default_font();
+        let img = solid_image(320, 240, [0, 0, 0, 255]);
+        let text = "word ".repeat(60);
+        let layout = CaptionLayout {
+            fit: TextFit::WrapShrink,
+            ..Default::default()
+        };
+
+        let (wrapped_size, wrapped) = fit_caption(&img, &font, &text, &CaptionLayout::default());
+        let (size, lines) = fit_caption(&img, &font, &text, &layout);
+
+        assert!(size < wrapped_size);
+        assert!(lines.len() < wrapped.len());
+    }
+
+    #[test]
+    fn test_caption_max_width() {
+        let font = default_font();
+        let img = solid_image(320, 240, [0, 0, 0, 255]);
+        let text = "Hello world Hello world";
+        let narrow = CaptionLayout {
+            max_width: 0.4,
+            ..Default::default()
+        };
+
+        let (_, wide_lines) = fit_caption(&img, &font, text, &CaptionLayout::default());
+        let (_, narrow_lines) = fit_caption(&img, &font, text, &narrow);
+
+        assert!(narrow_lines.len() > wide_lines.len());
+        assert!(narrow_lines.iter().all(|l| l.advance <= 320.0 * 0.4));
+    }
+
+    #[test]
+    fn test_caption_alignment() {
+        let font = default_font();
+        let text = "Short\nA much longer line";
+        let draw = |align| {
+            let mut img = solid_image(320, 240, [0, 0, 0, 255]);
+            let layout = CaptionLayout {
+                align,
+                ..Default::default()
+            };
+            draw_caption(&mut img, &font, text, &layout);
+            img.data
+        };
+
+        let start = draw(TextAlign::Start);
+        let center = draw(TextAlign::Center);
+        let end = draw(TextAlign::End);
+        assert_ne!(start, center);
+        assert_ne!(center, end);
+    }
+
+    #[test]
+    fn test_caption_anchor() {
+        let font = default_font();
+        let mut img = solid_image(320, 240, [0, 0, 0, 255]);
+        let original = img.data.clone();
+        let layout = CaptionLayout {
+            anchor: Anchor::TopLeft,
+            ..Default::default()
+        };
+
+        draw_caption(&mut img, &font, "Top", &layout);
+
+        // The caption is inside the top-left safe area, away from the bottom
+        let changed: Vec<usize> = (0..320 * 240)
+            .filter(|i| img.data[i * 4..i * 4 + 4] != original[i * 4..i * 4 + 4])
+            .collect();
+        assert!(!changed.is_empty());
+        assert!(changed.iter().all(|i| i % 320 >= 16 && i / 320 >= 12));
+        assert!(changed.iter().all(|i| i / 320 < 120));
+    }
+
+    #[test]
+    fn test_draw_label() {
+        let font = default_font();
+        let mut img = solid_image(320, 240, [0, 0, 0, 255]);
+        let original = img.data.clone();
+
+        draw_label(&mut img, &font, "3 / 10", Anchor::BottomRight, 0);
+
+        // The label is inside the bottom-right safe area
+        let changed: Vec<usize> = (0..320 * 240)
+            .filter(|i| img.data[i * 4..i * 4 + 4] != original[i * 4..i * 4 + 4])
+            .collect();
+        assert!(!changed.is_empty());
+        assert!(changed.iter().all(|i| i % 320 >= 160 && i % 320 < 304));
+        assert!(changed.iter().all(|i| i / 320 >= 120 && i / 320 < 228));
+    }
+
+    #[test]
+    fn test_draw_empty_caption() {
+        let font = default_font();
+        let mut img = solid_image(64, 64, [10, 20, 30, 255]);
+        let original = img.data.clone();
+
+        draw_caption(&mut img, &font, "  \n ", &CaptionLayout::default());
+
+        assert_eq!(img.data, original);
+    }
+}
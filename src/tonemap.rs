@@ -0,0 +1,213 @@
+//! HDR to SDR tone mapping
+//!
+//! HDR videos (PQ or HLG transfer) are tone mapped by ffmpeg's `zscale` and
+//! `tonemap` filters while decoding. Floating-point images (Radiance HDR,
+//! OpenEXR) hold linear light and are tone mapped here before the 8-bit
+//! conversion. Without tone mapping, HDR inputs look washed out or blown out
+//! next to SDR inputs.
+
+use crate::ffmpeg::ffprobe_path;
+use crate::ToneMap;
+use std::path::Path;
+use std::process::{Command, Stdio};
+
+/// Transfer characteristics reported by ffprobe for HDR video
+const HDR_TRANSFERS: [&str; 2] = ["smpte2084", "arib-std-b67"];
+
+/// Parameter of the Reinhard operator (same as ffmpeg's default)
+const REINHARD_PARAM: f32 = 0.5;
+
+/// Linear part of the Mobius operator (same as ffmpeg's default)
+const MOBIUS_PARAM: f32 = 0.3;
+
+impl ToneMap {
+    /// Name of the operator in ffmpeg's `tonemap` filter
+    fn filter_name(self) -> &'static str {
+        match self {
+            ToneMap::Hable => "hable",
+            ToneMap::Reinhard => "reinhard",
+            ToneMap::Mobius => "mobius",
+            ToneMap::Clip => "clip",
+        }
+    }
+
+    /// Map a linear value to the SDR range (0.0-1.0) for a given peak
+    fn apply(self, value: f32, peak: f32) -> f32 {
+        let v = value.max(0.0);
+        let mapped = match self {
+            ToneMap::Hable => hable(v) / hable(peak),
+            ToneMap::Reinhard => v / (v + REINHARD_PARAM) * (peak + REINHARD_PARAM) / peak,
+            ToneMap::Mobius => mobius(v, peak),
+            ToneMap::Clip => v,
+        };
+        mapped.clamp(0.0, 1.0)
+    }
+}
+
+/// Filmic curve by John Hable
+fn hable(v: f32) -> f32 {
+    let (a, b, c, d, e, f) = (0.15, 0.50, 0.10, 0.20, 0.02, 0.30);
+    (v * (a * v + c * b) + d * e) / (v * (a * v + b) + d * f) - e / f
+}
+
+/// Linear below the threshold, smoothly compressed above it
+fn mobius(v: f32, peak: f32) -> f32 {
+    let j = MOBIUS_PARAM;
+    if v <= j || peak <= 1.0 {
+        return v;
+    }
+    let a = -j * j * (peak - 1.0) / (j * j - 2.0 * j + peak);
+    let b = (j * j - 2.0 * j * peak + peak) / (peak - 1.0);
+    (b * b + 2.0 * b * j + j * j) / (b - a) * (v + a) / (v + b)
+}
+
+/// sRGB transfer function
+fn srgb_encode(v: f32) -> f32 {
+    if v <= 0.003_130_8 {
+        v * 12.92
+    } else {
+        1.055 * v.powf(1.0 / 2.4) - 0.055
+    }
+}
+
+/// Tone map linear RGBA float pixels to 8-bit sRGB
+///
+/// The brightest channel of the image is used as the peak, so images that
+/// already fit in the SDR range only get the sRGB encoding.
+pub(crate) fn tone_map_pixels(pixels: &[f32], tone_map: ToneMap) -> Vec<u8> {
+    let peak = pixels
+        .chunks_exact(4)
+        .flat_map(|p| &p[..3])
+        .copied()
+        .filter(|v| v.is_finite())
+        .fold(1.0, f32::max);
+
+    let to_u8 = |v: f32| (v * 255.0).round() as u8;
+    pixels
+        .chunks_exact(4)
+        .flat_map(|p| {
+            let rgb = |i: usize| to_u8(srgb_encode(tone_map.apply(p[i], peak)));
+            [rgb(0), rgb(1), rgb(2), to_u8(p[3].clamp(0.0, 1.0))]
+        })
+        .collect()
+}
+
+/// ffmpeg filter chain that tone maps an HDR video to BT.709 SDR
+///
+/// Returns None when the video is SDR or when ffmpeg was built without the
+/// `zscale` filter (zimg), in which case the video is decoded unchanged.
+pub(crate) fn video_filter<P: AsRef<Path>>(
+    ffmpeg: &str,
+    path: P,
+    tone_map: ToneMap,
+) -> Option<String> {
+    if !is_hdr(ffmpeg, path) || !has_filter(ffmpeg, "zscale") {
+        return None;
+    }
+    Some(format!(
+        "zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709,\
+         tonemap=tonemap={}:desat=0,zscale=t=bt709:m=bt709:r=tv,format=yuv420p",
+        tone_map.filter_name()
+    ))
+}
+
+/// Check if the first video stream uses an HDR transfer function
+pub(crate) fn is_hdr<P: AsRef<Path>>(ffmpeg: &str, path: P) -> bool {
+    let output = Command::new(ffprobe_path(ffmpeg))
+        .args([
+            "-v",
+            "error",
+            "-select_streams",
+            "v:0",
+            "-show_entries",
+            "stream=color_transfer",
+            "-of",
+            "csv=p=0",
+        ])
+        .arg(path.as_ref())
+        .output();
+
+    match output {
+        Ok(output) => {
+            let transfer = String::from_utf8_lossy(&output.stdout);
+            HDR_TRANSFERS.contains(&transfer.trim())
+        }
+        Err(_) => false,
+    }
+}
+
+/// Check if ffmpeg provides a filter
+pub(crate) fn has_filter(ffmpeg: &str, name: &str) -> bool {
+    let output = Command::new(ffmpeg)
+        .args(["-hide_banner", "-filters"])
+        .stderr(Stdio::null())
+        .output();
+
+    match output {
+        Ok(output) => String::from_utf8_lossy(&output.stdout)
+            .lines()
+            .any(|line| line.split_whitespace().nth(1) == Some(name)),
+        Err(_) => false,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const OPERATORS: [ToneMap; 4] = [
+        ToneMap::Hable,
+        ToneMap::Reinhard,
+        ToneMap::Mobius,
+        ToneMap::Clip,
+    ];
+
+    #[test]
+    fn test_operators_map_peak_to_white() {
+        for op in OPERATORS {
+            assert!(op.apply(0.0, 8.0) < 1e-6, "{:?}", op);
+            assert!((op.apply(8.0, 8.0) - 1.0).abs() < 1e-3, "{:?}", op);
+            assert!(op.apply(100.0, 8.0) <= 1.0, "{:?}", op);
+        }
+    }
+
+    #[test]
+    fn test_operators_are_monotonic() {
+        for op in OPERATORS {
+            let mut last = 0.0;
+            for i in 1..=80 {
+                let v = op.apply(i as f32 / 10.0, 8.0);
+                assert!(v >= last, "{:?} at {}", op, i);
+                last = v;
+            }
+        }
+    }
+
+    #[test]
+    fn test_compression_keeps_highlights() {
+        // Clipping loses the difference between bright values; the others keep it
+        assert_eq!(ToneMap::Clip.apply(2.0, 8.0), ToneMap::Clip.apply(4.0, 8.0));
+        for op in [ToneMap::Hable, ToneMap::Reinhard, ToneMap::Mobius] {
+            assert!(op.apply(2.0, 8.0) < op.apply(4.0, 8.0), "{:?}", op);
+        }
+    }
+
+    #[test]
+    fn test_mobius_is_linear_in_shadows() {
+        assert_eq!(ToneMap::Mobius.apply(0.2, 8.0), 0.2);
+    }
+
+    #[test]
+    fn test_tone_map_pixels() {
+        let pixels = [0.0, 0.5, 4.0, 1.0, 4.0, 4.0, 4.0, 0.5];
+        let data = tone_map_pixels(&pixels, ToneMap::Hable);
+        assert_eq!(data.len(), 8);
+        assert_eq!(data[0], 0);
+        assert_eq!(&data[4..8], &[255, 255, 255, 128]);
+        assert!(data[1] > 0 && data[1] < 255);
+
+        // SDR-range images only get the sRGB encoding with Clip
+        let data = tone_map_pixels(&[0.5, 1.0, 0.0, 1.0], ToneMap::Clip);
+        assert_eq!(data, vec![188, 255, 0, 255]);
+    }
+}
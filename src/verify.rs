@@ -0,0 +1,224 @@
+//! Checks of rendered outputs
+//!
+//! With `OutputCheck::Probe`, the output is probed with ffprobe once it is
+//! written and compared with what was encoded, so an encoder or muxer that
+//! writes something else, as after an upgrade of a platform library, fails
+//! the job instead of shipping a wrong video.
+
+use crate::ffmpeg::{ffprobe_path, find_ffmpeg};
+use crate::{Codec, Container, EncodeOptions, Error, IdleFrames, OutputCheck, Result};
+use std::path::Path;
+use std::process::Command;
+
+/// Largest difference of the frame rate, in fractions of the expected rate
+const FPS_TOLERANCE: f64 = 0.01;
+
+/// Largest difference of the duration, in frames
+const DURATION_TOLERANCE_FRAMES: u64 = 2;
+
+/// What a rendered video is expected to be
+pub(crate) struct Expected {
+    /// Frame width in pixels
+    pub width: u32,
+    /// Frame height in pixels
+    pub height: u32,
+    /// Frame rate (frames per second)
+    pub fps: u32,
+    /// Duration in milliseconds
+    pub duration_ms: u64,
+}
+
+/// Checks the output of the options, if they ask for it
+pub(crate) struct OutputChecker<'a> {
+    options: &'a EncodeOptions,
+    /// ffmpeg path, None when the output is not checked
+    ffmpeg: Option<String>,
+}
+
+impl<'a> OutputChecker<'a> {
+    /// Create a checker, finding ffmpeg up front so a missing ffprobe fails
+    /// before the slow encoding step
+    pub fn new(options: &'a EncodeOptions) -> Result<Self> {
+        let ffmpeg = match options.output_check {
+            OutputCheck::None => None,
+            OutputCheck::Probe => Some(find_ffmpeg(options.ffmpeg_path.as_deref())?),
+        };
+        Ok(Self { options, ffmpeg })
+    }
+
+    /// Compare the output with what was encoded, failing with
+    /// `Error::OutputMismatch` listing every difference
+    pub fn check(&self, expected: &Expected) -> Result<()> {
+        let Some(ffmpeg) = &self.ffmpeg else {
+            return Ok(());
+        };
+        let probed = probe(ffmpeg, &self.options.output_path)?;
+        // Merged idle frames and held APNG frames have variable frame rates
+        let constant_rate = self.options.idle_frames == IdleFrames::Keep
+            && self.options.container != Container::Apng;
+        match mismatches(&probed, expected, self.options.codec, constant_rate) {
+            mismatches if mismatches.is_empty() => Ok(()),
+            mismatches => Err(Error::OutputMismatch(mismatches.join("; "))),
+        }
+    }
+}
+
+/// Properties of the first video stream of a file, as ffprobe reports them
+#[derive(Debug, Default, PartialEq)]
+struct Probed {
+    codec: String,
+    width: u32,
+    height: u32,
+    fps: f64,
+    duration_ms: u64,
+}
+
+/// Probe the first video stream of a file
+fn probe<P: AsRef<Path>>(ffmpeg: &str, path: P) -> Result<Probed> {
+    let output = Command::new(ffprobe_path(ffmpeg))
+        .args([
+            "-v",
+            "error",
+            "-select_streams",
+            "v:0",
+            "-show_entries",
+            "stream=codec_name,width,height,r_frame_rate:format=duration",
+            "-of",
+            "default=noprint_wrappers=1",
+        ])
+        .arg(path.as_ref())
+        .output()
+        .map_err(|e| Error::Decode(format!("Failed to run ffprobe: {}", e)))?;
+
+    parse_probe(&String::from_utf8_lossy(&output.stdout)).ok_or_else(|| {
+        Error::Decode(format!(
+            "Failed to probe the output {}",
+            path.as_ref().display()
+        ))
+    })
+}
+
+/// Parse the `key=value` lines of ffprobe
+fn parse_probe(text: &str) -> Option<Probed> {
+    let mut probed = Probed::default();
+    let mut seconds = None;
+    for line in text.lines() {
+        let Some((key, value)) = line.trim().split_once('=') else {
+            continue;
+        };
+        match key {
+            "codec_name" => probed.codec = value.to_string(),
+            "width" => probed.width = value.parse().ok()?,
+            "height" => probed.height = value.parse().ok()?,
+            "r_frame_rate" => {
+                let (num, den) = value.split_once('/')?;
+                let (num, den) = (num.parse::<f64>().ok()?, den.parse::<f64>().ok()?);
+                probed.fps = if den > 0.0 { num / den } else { 0.0 };
+            }
+            "duration" => seconds = value.parse::<f64>().ok(),
+            _ => {}
+        }
+    }
+    probed.duration_ms = (seconds.filter(|s| s.is_finite() && *s >= 0.0)? * 1000.0).round() as u64;
+    (!probed.codec.is_empty()).then_some(probed)
+}
+
+/// ffprobe name of the codec of a video
+fn codec_name(codec: Codec) -> &'static str {
+    match codec {
+        Codec::Av1 => "av1",
+        Codec::H264 => "h264",
+        Codec::Png => "apng",
+        Codec::Vp9 => "vp9",
+        Codec::Hevc => "hevc",
+        Codec::Vp8 => "vp8",
+        Codec::Prores => "prores",
+    }
+}
+
+/// Differences between a probed video and what was encoded
+fn mismatches(
+    probed: &Probed,
+    expected: &Expected,
+    codec: Codec,
+    constant_rate: bool,
+) -> Vec<String> {
+    let mut mismatches = Vec::new();
+    if probed.codec != codec_name(codec) {
+        mismatches.push(format!(
+            "codec {}, expected {}",
+            probed.codec,
+            codec_name(codec)
+        ));
+    }
+    if (probed.width, probed.height) != (expected.width, expected.height) {
+        mismatches.push(format!(
+            "size {}x{}, expected {}x{}",
+            probed.width, probed.height, expected.width, expected.height
+        ));
+    }
+    let fps = expected.fps as f64;
+    if constant_rate && (probed.fps - fps).abs() > fps * FPS_TOLERANCE {
+        mismatches.push(format!(
+            "frame rate {:.3}, expected {}",
+            probed.fps, expected.fps
+        ));
+    }
+    let tolerance_ms = (DURATION_TOLERANCE_FRAMES * 1000 / expected.fps.max(1) as u64)
+        .max(expected.duration_ms / 100);
+    if probed.duration_ms.abs_diff(expected.duration_ms) > tolerance_ms {
+        mismatches.push(format!(
+            "duration {} ms, expected {} ms",
+            probed.duration_ms, expected.duration_ms
+        ));
+    }
+    mismatches
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_probe() {
+        let text =
+            "codec_name=h264\nwidth=320\nheight=240\nr_frame_rate=30000/1001\nduration=2.002000\n";
+        let probed = parse_probe(text).unwrap();
+        assert_eq!(probed.codec, "h264");
+        assert_eq!((probed.width, probed.height), (320, 240));
+        assert!((probed.fps - 29.97).abs() < 0.01);
+        assert_eq!(probed.duration_ms, 2002);
+
+        assert!(parse_probe("").is_none());
+        assert!(parse_probe("codec_name=vp9\nduration=N/A\n").is_none());
+    }
+
+    #[test]
+    fn test_mismatches() {
+        let expected = Expected {
+            width: 320,
+            height: 240,
+            fps: 30,
+            duration_ms: 3000,
+        };
+        let probed = Probed {
+            codec: "h264".to_string(),
+            width: 320,
+            height: 240,
+            fps: 30.0,
+            duration_ms: 3040,
+        };
+        assert!(mismatches(&probed, &expected, Codec::H264, true).is_empty());
+
+        let wrong = Probed {
+            codec: "hevc".to_string(),
+            width: 318,
+            fps: 25.0,
+            duration_ms: 2500,
+            ..probed
+        };
+        assert_eq!(mismatches(&wrong, &expected, Codec::H264, true).len(), 4);
+        // Variable frame rates are not compared
+        assert_eq!(mismatches(&wrong, &expected, Codec::H264, false).len(), 3);
+    }
+}
@@ -0,0 +1,519 @@
+//! Videos of audio files
+//!
+//! ffmpeg renders the waveform or spectrum of an audio file into a band of
+//! transparent RGBA frames, which are drawn over a background color or
+//! image; a still image can also be shown on its own. The audio itself is
+//! muxed in afterwards, so the output can be published to video platforms
+//! that do not accept audio-only files.
+
+use crate::audio::AudioMuxer;
+use crate::colorspace::ColorSpace;
+use crate::encoder::{with_idle_frames, with_software_fallback, EncoderConfig, Frame, Packet};
+use crate::ffmpeg::{find_ffmpeg, path_arg, probe_duration};
+use crate::image_loader::LoadedImage;
+use crate::logo::LogoOverlay;
+use crate::muxer::{Muxer, MuxerConfig};
+use crate::overlay::SafeArea;
+use crate::verify::{Expected, OutputChecker};
+use crate::{
+    audio, budget, crop, disk, enhance, priority, session, Anchor, Color, Compression, CropFocus,
+    DynamicRange, EncodeOptions, Error, OutputMode, Result, VisualStyle, Visualization,
+};
+use std::io::Read;
+use std::path::{Path, PathBuf};
+use std::process::{Child, ChildStdout, Command, Stdio};
+use std::time::Instant;
+
+/// Output size used when the width or height is 0
+const DEFAULT_WIDTH: u32 = 1280;
+const DEFAULT_HEIGHT: u32 = 720;
+
+/// Height of the visualization band in fractions of the frame height
+const BAND_HEIGHT_FRACTION: u32 = 3;
+
+impl VisualStyle {
+    /// ffmpeg filter drawing the audio into `width`x`height` RGBA frames at
+    /// `fps`
+    fn filter(self, width: u32, height: u32, color: Color, fps: u32) -> String {
+        let color = format!("0x{:02x}{:02x}{:02x}", color.r, color.g, color.b);
+        match self {
+            VisualStyle::Waveform => format!(
+                "showwaves=s={}x{}:mode=cline:rate={}:colors={},format=rgba",
+                width, height, fps, color
+            ),
+            VisualStyle::Spectrum => format!(
+                "showfreqs=s={}x{}:mode=bar:fscale=log:ascale=log:colors={},fps={},format=rgba",
+                width, height, color, fps
+            ),
+        }
+    }
+}
+
+/// Create a video visualizing an audio file
+///
+/// The waveform or spectrum of the audio is drawn in a band along the
+/// bottom of the safe area, over the background image (cover-fitted to the
+/// frame) or the background color. The output lasts as long as the audio,
+/// which is muxed in with the loudness, gain and fades of the options. A
+/// logo, if set, is drawn over the frames. Requires ffmpeg.
+pub fn visualize_audio<P: AsRef<Path>>(
+    audio_path: P,
+    options: &EncodeOptions,
+    visual: &Visualization,
+) -> Result<()> {
+    let audio_path = audio_path.as_ref();
+    priority::run(options.priority, || {
+        let ffmpeg = check_audio_output(audio_path, options)?;
+
+        let (width, height) = frame_size(visual)?;
+        let background = background_frame(visual, width, height)?;
+
+        // The band spans the safe area horizontally at the bottom of the frame
+        let area = SafeArea::new(width, height, options.safe_area);
+        let band_width = (area.width() as u32 / 2 * 2).max(2);
+        let band_height = (height / BAND_HEIGHT_FRACTION / 2 * 2).max(2);
+        let (band_x, band_y) =
+            area.place(Anchor::BottomCenter, band_width as f32, band_height as f32);
+        let band = Band {
+            x: band_x.round().max(0.0) as u32,
+            y: band_y.round().max(0.0) as u32,
+            width: band_width,
+            height: band_height,
+        };
+        let filter =
+            visual
+                .style
+                .filter(band.width, band.height, visual.color, options.frame_rate());
+
+        with_software_fallback(options, |options| {
+            render(
+                &ffmpeg,
+                audio_path,
+                options,
+                &background,
+                Some((band, filter.clone())),
+            )
+        })
+    })
+}
+
+/// Create a video showing a still image for the length of an audio file
+///
+/// The image keeps its size (rounded down to even dimensions) and is tone
+/// mapped and enhanced as slides are. The output lasts as long as the
+/// audio, which is muxed in with the loudness, gain and fades of the
+/// options. A logo, if set, is drawn over the frames. Requires ffmpeg.
+pub fn image_with_audio<P: AsRef<Path>, Q: AsRef<Path>>(
+    image_path: P,
+    audio_path: Q,
+    options: &EncodeOptions,
+) -> Result<()> {
+    let (image_path, audio_path) = (image_path.as_ref(), audio_path.as_ref());
+    priority::run(options.priority, || {
+        let ffmpeg = check_audio_output(audio_path, options)?;
+
+        let mut image = LoadedImage::from_path_tone_mapped(image_path, options.tone_map)?;
+        enhance::auto_enhance(&mut image, options.enhance);
+        let image = image.resize((image.width / 2 * 2).max(2), (image.height / 2 * 2).max(2));
+
+        with_software_fallback(options, |options| {
+            render(&ffmpeg, audio_path, options, &image, None)
+        })
+    })
+}
+
+/// Validate the options and audio input, returning the ffmpeg path
+fn check_audio_output(audio_path: &Path, options: &EncodeOptions) -> Result<String> {
+    options.validate()?;
+    if !options.container.supports_audio() {
+        return Err(Error::InvalidInput(format!(
+            "Container {:?} does not support audio",
+            options.container
+        )));
+    }
+    if options.output_mode == OutputMode::Segments {
+        return Err(Error::InvalidInput(
+            "Segment output does not support audio".to_string(),
+        ));
+    }
+    if options.dynamic_range != DynamicRange::Sdr {
+        return Err(Error::InvalidInput(
+            "Audio visualizations do not support HDR10".to_string(),
+        ));
+    }
+    audio::check_input(audio_path, Path::new(&options.output_path), "Audio")?;
+    find_ffmpeg(options.ffmpeg_path.as_deref())
+}
+
+/// Encode frames for the length of the audio and mux the audio in
+///
+/// Each frame is the background with the band of the visualization filter,
+/// if any, drawn over it. The last frame covers the end of the audio, and
+/// the output is cut to the audio length. With a time budget, a faster
+/// encoder preset is chosen as needed to finish in time.
+fn render(
+    ffmpeg: &str,
+    audio_path: &Path,
+    options: &EncodeOptions,
+    background: &LoadedImage,
+    band: Option<(Band, String)>,
+) -> Result<()> {
+    let started = Instant::now();
+    let fps = options.frame_rate();
+    let (width, height) = (background.width, background.height);
+    let checker = OutputChecker::new(options)?;
+    let seconds = probe_duration(ffmpeg, audio_path)?;
+    let audio_ms = ((seconds * 1000.0).ceil() as u64).max(1);
+    let frame_count = (audio_ms * fps as u64).div_ceil(1000);
+
+    let logo = match &options.logo {
+        Some(logo) => Some(LogoOverlay::new(
+            logo,
+            width,
+            height,
+            options.safe_area,
+            audio_ms,
+        )?),
+        None => None,
+    };
+
+    let mut visualization = match band {
+        Some((band, filter)) => Some((band, BandDecoder::new(ffmpeg, audio_path, &filter)?)),
+        None => None,
+    };
+    let mut band_data = match &visualization {
+        Some((band, _)) => vec![0u8; (band.width * band.height * 4) as usize],
+        None => Vec::new(),
+    };
+
+    // Fail early when the output volume is too full
+    let estimate = disk::estimate_output(
+        options.codec,
+        options.quality,
+        options.compression == Compression::Lossless,
+        width as u64 * height as u64,
+        frame_count,
+    );
+    disk::check_space(
+        Path::new(&options.output_path),
+        disk::peak_space(options, estimate),
+    )?;
+
+    let plan = budget::plan(
+        options.codec,
+        width as u64 * height as u64,
+        frame_count,
+        budget::remaining(options.time_budget_ms, started.elapsed()),
+    );
+    let encoder_config = EncoderConfig {
+        width,
+        height,
+        fps,
+        quality: options.quality,
+        ffmpeg_path: options.ffmpeg_path.clone(),
+        speed: plan.speed,
+        lossless: options.compression == Compression::Lossless,
+        alpha: false,
+        two_pass: None,
+        bitrate: options.video_bitrate(frame_count * 1000 / fps as u64, true)?,
+        keyframe_interval: options.keyframe_interval(fps),
+        forced_keyframes: options.forced_keyframes(fps),
+        pixel_format: options.pixel_format,
+        bit_depth: options.bit_depth,
+        hdr: None,
+        color: ColorSpace::new(options),
+        hw_accel: options.encoder_hw_accel(),
+        threads: options.encoder_threads(),
+        av1_backend: options.av1_backend,
+        av1_preset: options.av1_preset,
+        h264_profile: options.h264_profile,
+        h264_level: options.h264_level,
+        h264_preset: options.h264_preset,
+        h264_tune: options.h264_tune,
+        ffmpeg_args: options.ffmpeg_args.clone(),
+    };
+    let mut encoder = with_idle_frames(
+        session::encoder(options, encoder_config)?,
+        options.idle_frames,
+        fps,
+    );
+
+    let mut all_packets: Vec<Packet> = Vec::new();
+    for i in 0..frame_count {
+        let time_ms = i * 1000 / fps as u64;
+        let mut data = background.data.clone();
+        if let Some((band, decoder)) = &mut visualization {
+            decoder.read(&mut band_data)?;
+            band.draw(&mut data, width, &band_data);
+        }
+        if let Some(logo) = &logo {
+            logo.draw(&mut data, width, time_ms);
+        }
+
+        let frame = Frame {
+            width,
+            height,
+            data,
+            pts_ms: time_ms,
+        };
+        all_packets.extend(encoder.encode(&frame)?);
+    }
+    drop(visualization);
+
+    all_packets.extend(encoder.flush()?);
+
+    let muxer_config = MuxerConfig {
+        width,
+        height,
+        fps,
+        codec: options.codec,
+        codec_config: encoder.codec_config(),
+        pps: encoder.pps(),
+        alpha: false,
+        hls_segment: options.hls_segment,
+        segment_ms: options.segment_duration_ms,
+        mp4_layout: options.mp4_layout,
+        output_mode: options.output_mode,
+        hdr: None,
+        color: ColorSpace::new(options),
+        chapters: options.chapters.clone(),
+    };
+
+    let track = audio::SlideshowAudio {
+        narrations: vec![audio::Narration {
+            path: PathBuf::from(audio_path),
+            start_ms: 0,
+            duration_ms: audio_ms,
+        }],
+        loudness: (options.loudness != 0.0).then_some(options.loudness),
+        envelope: audio::Envelope {
+            gain_db: options.audio_gain,
+            fade_in_ms: options.audio_fade_in_ms,
+            fade_out_ms: options.audio_fade_out_ms,
+        },
+        ..Default::default()
+    };
+    // The video is muxed into ffmpeg, which adds the audio
+    let mut muxer = Box::new(AudioMuxer::new(
+        ffmpeg,
+        &track,
+        options,
+        audio_ms,
+        muxer_config,
+    )?);
+    for packet in all_packets {
+        muxer.write_packet(&packet)?;
+    }
+    muxer.finalize()?;
+
+    checker.check(&Expected {
+        width,
+        height,
+        fps,
+        duration_ms: audio_ms,
+    })
+}
+
+/// Output frame size, rounded down to even dimensions
+fn frame_size(visual: &Visualization) -> Result<(u32, u32)> {
+    let width = match visual.width {
+        0 => DEFAULT_WIDTH,
+        w => w / 2 * 2,
+    };
+    let height = match visual.height {
+        0 => DEFAULT_HEIGHT,
+        h => h / 2 * 2,
+    };
+    if width < BAND_HEIGHT_FRACTION * 2 || height < BAND_HEIGHT_FRACTION * 2 {
+        return Err(Error::InvalidInput(format!(
+            "Visualization size is too small: {}x{}",
+            visual.width, visual.height
+        )));
+    }
+    Ok((width, height))
+}
+
+/// Background of every frame
+fn background_frame(visual: &Visualization, width: u32, height: u32) -> Result<LoadedImage> {
+    if let Some(path) = &visual.background_image {
+        let image = LoadedImage::from_path(path)?;
+        return Ok(crop::cover(&image, width, height, CropFocus::Center));
+    }
+
+    let color = visual.background.unwrap_or(Color { r: 0, g: 0, b: 0 });
+    Ok(LoadedImage {
+        width,
+        height,
+        data: [color.r, color.g, color.b, 255].repeat((width * height) as usize),
+    })
+}
+
+/// ffmpeg process rendering the visualization band
+struct BandDecoder {
+    process: Child,
+    stdout: Option<ChildStdout>,
+}
+
+impl BandDecoder {
+    fn new(ffmpeg: &str, audio_path: &Path, filter: &str) -> Result<Self> {
+        let mut process = Command::new(ffmpeg)
+            .args(["-v", "error", "-nostdin", "-i"])
+            .arg(path_arg(audio_path))
+            .args([
+                "-filter_complex",
+                &format!("[0:a:0]{}[v]", filter),
+                "-map",
+                "[v]",
+                "-f",
+                "rawvideo",
+                "-pix_fmt",
+                "rgba",
+                "pipe:1",
+            ])
+            .stdout(Stdio::piped())
+            .stderr(Stdio::null())
+            .spawn()
+            .map_err(|e| Error::Ffmpeg(format!("Failed to start ffmpeg: {}", e)))?;
+
+        let stdout = process.stdout.take();
+        if stdout.is_none() {
+            return Err(Error::Ffmpeg("Failed to read ffmpeg output".to_string()));
+        }
+        Ok(Self { process, stdout })
+    }
+
+    /// Read the next RGBA frame
+    ///
+    /// Frames missing at the end of the audio are left empty.
+    fn read(&mut self, buffer: &mut [u8]) -> Result<()> {
+        let stdout = match &mut self.stdout {
+            Some(stdout) => stdout,
+            None => return Ok(()),
+        };
+        match stdout.read_exact(buffer) {
+            Ok(()) => Ok(()),
+            Err(e) if e.kind() == std::io::ErrorKind::UnexpectedEof => {
+                self.stdout = None;
+                buffer.fill(0);
+                Ok(())
+            }
+            Err(e) => Err(Error::Decode(format!(
+                "Failed to read visualization frame: {}",
+                e
+            ))),
+        }
+    }
+}
+
+impl Drop for BandDecoder {
+    fn drop(&mut self) {
+        self.stdout = None;
+        let _ = self.process.kill();
+        let _ = self.process.wait();
+    }
+}
+
+/// Position of the visualization band in the frame
+#[derive(Debug, Clone, Copy)]
+struct Band {
+    x: u32,
+    y: u32,
+    width: u32,
+    height: u32,
+}
+
+impl Band {
+    /// Alpha blend RGBA band pixels over an RGBA frame
+    fn draw(&self, data: &mut [u8], frame_width: u32, band: &[u8]) {
+        let frame_height = (data.len() / 4 / frame_width.max(1) as usize) as u32;
+        for by in 0..self.height {
+            let y = self.y + by;
+            if y >= frame_height {
+                break;
+            }
+            for bx in 0..self.width {
+                let x = self.x + bx;
+                if x >= frame_width {
+                    break;
+                }
+
+                let src = ((by * self.width + bx) * 4) as usize;
+                let alpha = band[src + 3] as u32;
+                if alpha == 0 {
+                    continue;
+                }
+                let dst = ((y * frame_width + x) * 4) as usize;
+                for c in 0..3 {
+                    let blended =
+                        (band[src + c] as u32 * alpha + data[dst + c] as u32 * (255 - alpha)) / 255;
+                    data[dst + c] = blended as u8;
+                }
+            }
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_frame_size() {
+        let visual = Visualization::default();
+        assert_eq!(frame_size(&visual).unwrap(), (1280, 720));
+
+        let visual = Visualization {
+            width: 641,
+            height: 361,
+            ..Default::default()
+        };
+        assert_eq!(frame_size(&visual).unwrap(), (640, 360));
+
+        let visual = Visualization {
+            width: 3,
+            ..Default::default()
+        };
+        assert!(frame_size(&visual).is_err());
+    }
+
+    #[test]
+    fn test_filter() {
+        let white = Color::default();
+        assert_eq!(
+            VisualStyle::Waveform.filter(640, 120, white, 30),
+            "showwaves=s=640x120:mode=cline:rate=30:colors=0xffffff,format=rgba"
+        );
+        let red = Color { r: 255, g: 0, b: 0 };
+        assert!(VisualStyle::Spectrum
+            .filter(640, 120, red, 30)
+            .starts_with("showfreqs=s=640x120:mode=bar:"));
+        assert!(VisualStyle::Spectrum
+            .filter(640, 120, red, 30)
+            .contains("colors=0xff0000,fps=30"));
+    }
+
+    #[test]
+    fn test_band_draw() {
+        // 4x2 black frame with a 2x1 band at (1, 1)
+        let mut data = [0, 0, 0, 255].repeat(8);
+        let band = Band {
+            x: 1,
+            y: 1,
+            width: 2,
+            height: 1,
+        };
+        let pixels = [
+            255, 255, 255, 255, // opaque white
+            255, 255, 255, 0, // transparent
+        ];
+        band.draw(&mut data, 4, &pixels);
+
+        assert_eq!(&data[20..24], &[255, 255, 255, 255]);
+        assert_eq!(&data[24..28], &[0, 0, 0, 255]);
+        assert_eq!(&data[0..4], &[0, 0, 0, 255]);
+
+        let half = [255, 255, 255, 128, 0, 0, 0, 0];
+        band.draw(&mut data, 4, &half);
+        assert_eq!(&data[20..24], &[255, 255, 255, 255]);
+    }
+}
@@ -0,0 +1,98 @@
+//! Non-fatal warnings of an encode
+//!
+//! A `WarningHandler` in the options is told about changes the job made to
+//! its inputs that callers would otherwise not notice, such as a frame size
+//! rounded to even dimensions or audio cut to the length of the video. Unlike
+//! errors, warnings never stop the job.
+
+use std::fmt;
+use std::sync::Arc;
+
+/// What a warning is about
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+#[repr(C)]
+pub enum WarningKind {
+    /// The frame size was rounded down to even dimensions, cutting off the
+    /// last row or column of pixels
+    SizeRounded = 0,
+    /// Background music or narration was cut to the length of the video or
+    /// of its slide
+    AudioTruncated = 1,
+    /// A slide was left out as its image or narration could not be read
+    SlideSkipped = 2,
+    /// The hardware encoder failed and the video was encoded in software
+    HardwareFallback = 3,
+}
+
+/// Warning of a job reported to a [`WarningHandler`]
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Warning {
+    /// What the warning is about
+    pub kind: WarningKind,
+    /// Description of the warning, in English
+    pub message: String,
+}
+
+impl fmt::Display for Warning {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str(&self.message)
+    }
+}
+
+/// Called with the warnings of a job, possibly from another thread than the
+/// one that started it
+#[derive(Clone)]
+pub struct WarningHandler(Arc<dyn Fn(&Warning) + Send + Sync>);
+
+impl WarningHandler {
+    /// Wrap a function called with each warning
+    pub fn new(handler: impl Fn(&Warning) + Send + Sync + 'static) -> Self {
+        Self(Arc::new(handler))
+    }
+
+    /// Report a warning
+    pub(crate) fn call(&self, kind: WarningKind, message: String) {
+        (self.0)(&Warning { kind, message })
+    }
+}
+
+impl fmt::Debug for WarningHandler {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        f.write_str("WarningHandler")
+    }
+}
+
+/// Message of a frame size rounded down to even dimensions
+pub(crate) fn size_rounded(width: u32, height: u32) -> String {
+    format!(
+        "Frame size {}x{} rounded down to {}x{}, as video needs even dimensions",
+        width,
+        height,
+        width / 2 * 2,
+        height / 2 * 2
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::sync::Mutex;
+
+    #[test]
+    fn test_warning_handler() {
+        let warnings = Arc::new(Mutex::new(Vec::new()));
+        let handler = {
+            let warnings = warnings.clone();
+            WarningHandler::new(move |warning| warnings.lock().unwrap().push(warning.clone()))
+        };
+        handler.call(WarningKind::SizeRounded, size_rounded(1281, 720));
+
+        let warnings = warnings.lock().unwrap();
+        assert_eq!(warnings.len(), 1);
+        assert_eq!(warnings[0].kind, WarningKind::SizeRounded);
+        assert_eq!(
+            warnings[0].to_string(),
+            "Frame size 1281x720 rounded down to 1280x720, as video needs even dimensions"
+        );
+    }
+}
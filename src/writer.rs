@@ -0,0 +1,492 @@
+//! Videos written frame by frame
+//!
+//! A `VideoWriter` encodes RGBA frames as the program renders them, such as
+//! charts or animations, without writing images to disk first. Frames are
+//! encoded and muxed as they are written, so only the frames in the encoder
+//! are held in memory. Video with audio is muxed to a temporary Matroska
+//! file, which ffmpeg combines with the audio when the writer is finished.
+//! PCM audio written beside the frames, such as synthesized sound, goes to a
+//! temporary file as it comes, which ffmpeg reads beside the video.
+
+use crate::audio;
+use crate::colorspace::ColorSpace;
+use crate::encoder::{with_idle_frames, Encoder, EncoderConfig, Frame, Packet, Speed};
+use crate::ffmpeg;
+use crate::logo::LogoOverlay;
+use crate::muxer::{create_muxer, Muxer, MuxerConfig};
+use crate::temp;
+use crate::verify::{Expected, OutputChecker};
+use crate::{
+    session, Codec, Compression, Container, DynamicRange, EncodeOptions, Error, HwFallback,
+    Mp4Layout, OutputMode, Passes, Priority, Result, Transparency,
+};
+use std::fs::File;
+use std::io::{BufWriter, Write};
+use std::path::{Path, PathBuf};
+
+/// Supported sample rates of written audio in Hz
+const MIN_SAMPLE_RATE: u32 = 8000;
+const MAX_SAMPLE_RATE: u32 = 192_000;
+
+/// Largest number of channels of written audio
+const MAX_CHANNELS: u16 = 8;
+
+/// Suffix of the temporary file of written audio
+const AUDIO_SUFFIX: &str = ".pcm";
+
+/// Suffix of the temporary Matroska file of video with audio
+const VIDEO_SUFFIX: &str = ".video.mkv";
+
+/// Suffix of the output muxed before audio was written, which ffmpeg reads
+/// to add the audio
+const MUXED_SUFFIX: &str = ".video";
+
+/// Writer of a video from frames given one at a time
+///
+/// Frames are 8-bit RGBA at the size given to [`VideoWriter::new`], shown
+/// for one frame period each at the frame rate of the options. Audio samples
+/// given with [`VideoWriter::write_audio`] play from the start of the video.
+/// The logo and background music of the options are added, the music lowered
+/// under the written audio; options about slides, such as captions and
+/// transitions, do not apply. As the length of the video is
+/// only known at the end, logo ranges cannot be measured from the end, and
+/// time budgets, target sizes, two-pass encoding, software fallback,
+/// lowered priorities and HDR10 output are unavailable.
+///
+/// [`VideoWriter::finish`] completes the output. A writer dropped without
+/// finishing is abandoned and its output removed; fragmented MP4 output
+/// keeps the frames written so far.
+pub struct VideoWriter {
+    options: EncodeOptions,
+    width: u32,
+    height: u32,
+    fps: u32,
+    alpha: bool,
+    encoder: Box<dyn Encoder>,
+    logo: Option<LogoOverlay>,
+    ffmpeg: Option<String>,
+    /// Muxer started with the first packets
+    muxer: Option<Box<dyn Muxer>>,
+    /// Video muxed before the audio is added
+    video: Option<TempFile>,
+    frames: u64,
+    /// Audio written so far
+    audio: Option<PcmFile>,
+}
+
+impl VideoWriter {
+    /// Start writing a video of `width` by `height` pixels, both even, to the
+    /// output of the options
+    pub fn new(options: &EncodeOptions, width: u32, height: u32) -> Result<Self> {
+        options.validate()?;
+        check_options(options)?;
+        if width == 0 || height == 0 || !width.is_multiple_of(2) || !height.is_multiple_of(2) {
+            return Err(Error::InvalidInput(format!(
+                "Video size must be positive and even, got {}x{}",
+                width, height
+            )));
+        }
+        let ffmpeg = match options.background_music.as_deref() {
+            Some(music) => {
+                if !Path::new(music).is_file() {
+                    return Err(Error::InvalidInput(format!(
+                        "Audio file not found: {}",
+                        music
+                    )));
+                }
+                Some(ffmpeg::find_ffmpeg(options.ffmpeg_path.as_deref())?)
+            }
+            None => None,
+        };
+        // Fail before encoding when the output cannot be checked
+        OutputChecker::new(options)?;
+
+        let fps = options.frame_rate();
+        let alpha = options.transparency == Transparency::Keep
+            && matches!(options.codec, Codec::Vp9 | Codec::Vp8);
+        let logo = match &options.logo {
+            Some(logo) => Some(LogoOverlay::new(
+                logo,
+                width,
+                height,
+                options.safe_area,
+                u64::MAX,
+            )?),
+            None => None,
+        };
+        let encoder_config = EncoderConfig {
+            width,
+            height,
+            fps,
+            quality: options.quality,
+            ffmpeg_path: options.ffmpeg_path.clone(),
+            speed: Speed::Default,
+            lossless: options.compression == Compression::Lossless,
+            alpha,
+            two_pass: None,
+            bitrate: options.bitrate(),
+            keyframe_interval: options.keyframe_interval(fps),
+            forced_keyframes: options.forced_keyframes(fps),
+            pixel_format: options.pixel_format,
+            bit_depth: options.bit_depth,
+            hdr: None,
+            color: ColorSpace::new(options),
+            hw_accel: options.encoder_hw_accel(),
+            threads: options.encoder_threads(),
+            av1_backend: options.av1_backend,
+            av1_preset: options.av1_preset,
+            h264_profile: options.h264_profile,
+            h264_level: options.h264_level,
+            h264_preset: options.h264_preset,
+            h264_tune: options.h264_tune,
+            ffmpeg_args: options.ffmpeg_args.clone(),
+        };
+        let encoder = with_idle_frames(
+            session::encoder(options, encoder_config)?,
+            options.idle_frames,
+            fps,
+        );
+
+        Ok(Self {
+            options: options.clone(),
+            width,
+            height,
+            fps,
+            alpha,
+            encoder,
+            logo,
+            ffmpeg,
+            muxer: None,
+            video: None,
+            frames: 0,
+            audio: None,
+        })
+    }
+
+    /// Encode a frame of RGBA pixels, row by row from the top left
+    pub fn write_frame(&mut self, rgba: &[u8]) -> Result<()> {
+        let expected = self.width as usize * self.height as usize * 4;
+        if rgba.len() != expected {
+            return Err(Error::InvalidInput(format!(
+                "Frame must have {} bytes of RGBA pixels for {}x{}, got {}",
+                expected,
+                self.width,
+                self.height,
+                rgba.len()
+            )));
+        }
+        let pts_ms = self.frames * 1000 / self.fps as u64;
+        let mut data = rgba.to_vec();
+        if let Some(logo) = &self.logo {
+            logo.draw(&mut data, self.width, pts_ms);
+        }
+        let packets = self.encoder.encode(&Frame {
+            width: self.width,
+            height: self.height,
+            data,
+            pts_ms,
+        })?;
+        self.frames += 1;
+        self.mux(packets)
+    }
+
+    /// Add interleaved 16-bit PCM samples, one sample per channel at a time,
+    /// to the audio of the video (requires ffmpeg)
+    ///
+    /// Every call must give the same sample rate and number of channels. The
+    /// audio is cut or padded with silence to the length of the video. With
+    /// segment output, audio must be written before the first frame;
+    /// otherwise audio first written after frames makes the finished output
+    /// be muxed again with it.
+    pub fn write_audio(&mut self, samples: &[i16], sample_rate: u32, channels: u16) -> Result<()> {
+        if !(MIN_SAMPLE_RATE..=MAX_SAMPLE_RATE).contains(&sample_rate) {
+            return Err(Error::InvalidInput(format!(
+                "Sample rate must be {} to {} Hz, got {}",
+                MIN_SAMPLE_RATE, MAX_SAMPLE_RATE, sample_rate
+            )));
+        }
+        if !(1..=MAX_CHANNELS).contains(&channels) {
+            return Err(Error::InvalidInput(format!(
+                "Audio must have 1 to {} channels, got {}",
+                MAX_CHANNELS, channels
+            )));
+        }
+        if !samples.len().is_multiple_of(channels as usize) {
+            return Err(Error::InvalidInput(format!(
+                "Sample count {} is not a multiple of {} channels",
+                samples.len(),
+                channels
+            )));
+        }
+
+        match &mut self.audio {
+            Some(pcm) if pcm.sample_rate != sample_rate || pcm.channels != channels => {
+                Err(Error::InvalidInput(format!(
+                    "Audio was written at {} Hz with {} channels, got {} Hz with {}",
+                    pcm.sample_rate, pcm.channels, sample_rate, channels
+                )))
+            }
+            Some(pcm) => pcm.write(samples),
+            None => {
+                audio::audio_encoder(self.options.container)?;
+                if self.muxer.is_some() && self.options.output_mode == OutputMode::Segments {
+                    return Err(Error::InvalidInput(
+                        "Audio of segment output must be written before the first frame"
+                            .to_string(),
+                    ));
+                }
+                if self.ffmpeg.is_none() {
+                    self.ffmpeg = Some(ffmpeg::find_ffmpeg(self.options.ffmpeg_path.as_deref())?);
+                }
+                let pcm = self.audio.insert(PcmFile::create(
+                    temp::path(&self.options, AUDIO_SUFFIX),
+                    sample_rate,
+                    channels,
+                )?);
+                pcm.write(samples)
+            }
+        }
+    }
+
+    /// Number of frames written so far
+    pub fn frame_count(&self) -> u64 {
+        self.frames
+    }
+
+    /// Flush the encoder and write the output
+    pub fn finish(mut self) -> Result<()> {
+        if self.frames == 0 {
+            return Err(Error::InvalidInput("No frames written".to_string()));
+        }
+        let packets = self.encoder.flush()?;
+        self.mux(packets)?;
+
+        let muxer = match self.muxer.take() {
+            Some(muxer) => muxer,
+            None => self.new_muxer()?,
+        };
+        muxer.finalize()?;
+
+        let duration_ms = self.frames * 1000 / self.fps as u64;
+        if let Some(ffmpeg) = &self.ffmpeg {
+            let video = match &self.video {
+                Some(video) => video.0.clone(),
+                None => {
+                    // Audio came after frames were muxed to the output, which
+                    // is moved aside and muxed again with the audio
+                    let mut path = self.options.output_path.clone();
+                    path.push_str(MUXED_SUFFIX);
+                    std::fs::rename(&self.options.output_path, &path)?;
+                    self.video.insert(TempFile(PathBuf::from(path))).0.clone()
+                }
+            };
+            let pcm = match &mut self.audio {
+                Some(pcm) => Some(pcm.finish()?),
+                None => None,
+            };
+            let options = &self.options;
+            let track = audio::SlideshowAudio {
+                music: options.background_music.as_ref().map(PathBuf::from),
+                loudness: (options.loudness != 0.0).then_some(options.loudness),
+                envelope: audio::Envelope {
+                    gain_db: options.audio_gain,
+                    fade_in_ms: options.audio_fade_in_ms,
+                    fade_out_ms: options.audio_fade_out_ms,
+                },
+                ducking: audio::Ducking::new(options.duck_threshold, options.duck_amount),
+                pcm,
+                ..Default::default()
+            };
+            audio::mux_audio(ffmpeg, &video, &track, options, duration_ms)?;
+        }
+
+        OutputChecker::new(&self.options)?.check(&Expected {
+            width: self.width,
+            height: self.height,
+            fps: self.fps,
+            duration_ms,
+        })
+    }
+
+    /// Mux packets, starting the muxer with the first ones
+    fn mux(&mut self, packets: Vec<Packet>) -> Result<()> {
+        if packets.is_empty() {
+            return Ok(());
+        }
+        let muxer = match &mut self.muxer {
+            Some(muxer) => muxer,
+            None => {
+                let muxer = self.new_muxer()?;
+                self.muxer.insert(muxer)
+            }
+        };
+        for packet in &packets {
+            muxer.write_packet(packet)?;
+        }
+        Ok(())
+    }
+
+    /// Start a muxer writing the output, or a temporary Matroska file when
+    /// audio is added to it
+    fn new_muxer(&mut self) -> Result<Box<dyn Muxer>> {
+        if self.ffmpeg.is_none() {
+            return create_muxer(
+                self.options.container,
+                &self.options.output_path,
+                self.muxer_config(),
+            );
+        }
+        let path = temp::path(&self.options, VIDEO_SUFFIX);
+        let config = MuxerConfig {
+            output_mode: OutputMode::Single,
+            ..self.muxer_config()
+        };
+        let path = &self.video.insert(TempFile(path)).0;
+        create_muxer(Container::Mkv, path, config)
+    }
+
+    fn muxer_config(&self) -> MuxerConfig {
+        MuxerConfig {
+            width: self.width,
+            height: self.height,
+            fps: self.fps,
+            codec: self.options.codec,
+            codec_config: self.encoder.codec_config(),
+            pps: self.encoder.pps(),
+            alpha: self.alpha,
+            hls_segment: self.options.hls_segment,
+            segment_ms: self.options.segment_duration_ms,
+            mp4_layout: self.options.mp4_layout,
+            output_mode: self.options.output_mode,
+            hdr: None,
+            color: ColorSpace::new(&self.options),
+            chapters: self.options.chapters.clone(),
+        }
+    }
+}
+
+impl Drop for VideoWriter {
+    fn drop(&mut self) {
+        // The muxer is left when the writer is abandoned
+        let Some(muxer) = self.muxer.take() else {
+            return;
+        };
+        drop(muxer);
+        // Fragmented MP4 plays up to the last fragment muxed
+        let fragmented = self.options.container == Container::Mp4
+            && self.options.mp4_layout == Mp4Layout::Fragmented;
+        if self.video.is_none() && !fragmented {
+            let _ = std::fs::remove_file(&self.options.output_path);
+        }
+    }
+}
+
+/// Written audio in a temporary file of raw samples, removed when dropped
+struct PcmFile {
+    writer: BufWriter<File>,
+    /// Dropped after the writer, so the file is closed before it is removed
+    path: TempFile,
+    sample_rate: u32,
+    channels: u16,
+}
+
+impl PcmFile {
+    fn create(path: PathBuf, sample_rate: u32, channels: u16) -> Result<Self> {
+        let writer = BufWriter::new(File::create(&path)?);
+        Ok(Self {
+            writer,
+            path: TempFile(path),
+            sample_rate,
+            channels,
+        })
+    }
+
+    /// Append samples as 16-bit little-endian PCM
+    fn write(&mut self, samples: &[i16]) -> Result<()> {
+        for sample in samples {
+            self.writer.write_all(&sample.to_le_bytes())?;
+        }
+        Ok(())
+    }
+
+    /// Flush the samples written so far, returning the input to read them
+    fn finish(&mut self) -> Result<audio::PcmInput> {
+        self.writer.flush()?;
+        Ok(audio::PcmInput {
+            path: self.path.0.clone(),
+            sample_rate: self.sample_rate,
+            channels: self.channels,
+        })
+    }
+}
+
+/// Temporary file, removed when dropped
+struct TempFile(PathBuf);
+
+impl Drop for TempFile {
+    fn drop(&mut self) {
+        let _ = std::fs::remove_file(&self.0);
+    }
+}
+
+/// Check that the options can be used without knowing the frames up front
+fn check_options(options: &EncodeOptions) -> Result<()> {
+    let unsupported = if options.time_budget_ms > 0 {
+        Some("time budgets")
+    } else if options.target_size_bytes > 0 {
+        Some("target sizes")
+    } else if options.passes == Passes::Two {
+        Some("two-pass encoding")
+    } else if options.hw_fallback == HwFallback::Software {
+        Some("software fallback")
+    } else if options.priority != Priority::Normal {
+        // Frames are encoded on the calling thread, which keeps its priority
+        Some("lowered priorities")
+    } else if options.dynamic_range == DynamicRange::Hdr10 {
+        Some("HDR10 output")
+    } else if options.logo.as_ref().is_some_and(|logo| {
+        logo.ranges
+            .iter()
+            .any(|range| range.start_ms < 0 || range.end_ms < 0)
+    }) {
+        Some("logo ranges measured from the end")
+    } else {
+        None
+    };
+    match unsupported {
+        Some(feature) => Err(Error::InvalidInput(format!(
+            "Video writers do not support {}",
+            feature
+        ))),
+        None => Ok(()),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_check_options() {
+        assert!(check_options(&EncodeOptions::default()).is_ok());
+        for options in [
+            EncodeOptions {
+                passes: Passes::Two,
+                ..Default::default()
+            },
+            EncodeOptions {
+                priority: Priority::Low,
+                ..Default::default()
+            },
+            EncodeOptions {
+                dynamic_range: DynamicRange::Hdr10,
+                ..Default::default()
+            },
+        ] {
+            assert!(matches!(
+                check_options(&options),
+                Err(Error::InvalidInput(_))
+            ));
+        }
+    }
+}
@@ -0,0 +1,73 @@
+//! Integration tests for luma analysis
+
+mod common;
+
+use common::*;
+use minmpeg::{analyze_luma, slideshow, EncodeOptions, SlideEntry};
+use tempfile::TempDir;
+
+/// Test analyzing a bright image with clipped highlights
+#[test]
+fn test_analyze_image() {
+    let temp_dir = TempDir::new().unwrap();
+    let path = temp_dir.path().join("white.png");
+    let img = generate_test_image(64, 48, [255, 255, 255, 255]);
+    save_png(&img, &path).unwrap();
+
+    let analysis = analyze_luma(&path, None).unwrap();
+    assert_eq!(analysis.frames.len(), 1);
+    assert_eq!(analysis.aggregate.pixel_count, 64 * 48);
+    assert_eq!(analysis.frames[0], analysis.aggregate);
+    assert!(analysis.aggregate.highlight_clip > 0.9);
+    assert_eq!(analysis.aggregate.shadow_clip, 0.0);
+}
+
+/// Test analyzing a video frame by frame
+#[test]
+fn test_analyze_video() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let entries: Vec<SlideEntry> = [[0, 0, 0, 255], [255, 255, 255, 255]]
+        .iter()
+        .enumerate()
+        .map(|(i, color)| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&generate_test_image(64, 48, *color), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 500,
+                ..Default::default()
+            }
+        })
+        .collect();
+
+    let video = temp_dir.path().join("video.webm");
+    let options = EncodeOptions {
+        output_path: video.to_string_lossy().to_string(),
+        ..Default::default()
+    };
+    slideshow(&entries, &options).unwrap();
+
+    let analysis = analyze_luma(&video, None).unwrap();
+    assert!(analysis.frames.len() > 1);
+    let (first, last) = (
+        &analysis.frames[0],
+        &analysis.frames[analysis.frames.len() - 1],
+    );
+    assert!(first.mean < last.mean);
+    assert_eq!(
+        analysis.aggregate.pixel_count,
+        64 * 48 * analysis.frames.len() as u64
+    );
+}
+
+/// Test analyzing a missing file (should fail)
+#[test]
+fn test_analyze_missing_file() {
+    let temp_dir = TempDir::new().unwrap();
+    assert!(analyze_luma(temp_dir.path().join("missing.png"), None).is_err());
+}
@@ -0,0 +1,828 @@
+//! Integration tests for audio tracks
+
+mod common;
+
+use common::*;
+use minmpeg::{
+    extract_audio, image_with_audio, remove_audio, replace_audio, slideshow, visualize_audio,
+    AudioCodec, AudioFit, AudioOptions, Codec, Color, Container, EncodeOptions, Error, SlideEntry,
+    VisualStyle, Visualization,
+};
+use std::path::Path;
+use tempfile::TempDir;
+
+/// Create slide images and entries in the temp directory
+fn create_slides(temp_dir: &TempDir, count: u32, duration_ms: u32) -> Vec<SlideEntry> {
+    (0..count)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            let img = generate_numbered_image(320, 240, i);
+            save_png(&img, &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms,
+                ..Default::default()
+            }
+        })
+        .collect()
+}
+
+fn path_string(path: &Path) -> String {
+    path.to_string_lossy().to_string()
+}
+
+/// Create a silent WebM video of the given length in the temp directory
+fn create_video(temp_dir: &TempDir, duration_ms: u32) -> std::path::PathBuf {
+    let entries = create_slides(temp_dir, 1, duration_ms);
+    let path = temp_dir.path().join("video.webm");
+    let options = EncodeOptions {
+        output_path: path_string(&path),
+        ..Default::default()
+    };
+    slideshow(&entries, &options).unwrap();
+    path
+}
+
+// ============================================================================
+// Background music tests
+// ============================================================================
+
+/// Test looping short background music over a WebM slideshow
+#[test]
+fn test_background_music_looped_webm() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let entries = create_slides(&temp_dir, 3, 1000);
+
+    let music = temp_dir.path().join("music.wav");
+    save_sine_wav(&music, 700, 440.0).unwrap();
+
+    let output_path = temp_dir.path().join("output.webm");
+    let options = EncodeOptions {
+        output_path: path_string(&output_path),
+        background_music: Some(path_string(&music)),
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "Slideshow with music failed: {:?}", result);
+    assert!(verify_webm_header(&output_path));
+    assert_eq!(probe_audio_codecs(&output_path), vec!["opus"]);
+
+    let duration = probe_duration(&output_path).unwrap();
+    assert!(
+        (duration - 3.0).abs() < 0.2,
+        "Unexpected duration {}",
+        duration
+    );
+
+    // The video is piped to ffmpeg, leaving no file beside the output
+    let names: Vec<String> = std::fs::read_dir(temp_dir.path())
+        .unwrap()
+        .flatten()
+        .map(|e| e.file_name().to_string_lossy().into_owned())
+        .filter(|name| name.starts_with("output"))
+        .collect();
+    assert_eq!(names, ["output.webm"]);
+}
+
+/// Test that ffmpeg's error is reported when it stops reading the piped video
+#[test]
+fn test_background_music_unreadable() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let entries = create_slides(&temp_dir, 3, 1000);
+
+    let music = temp_dir.path().join("music.wav");
+    std::fs::write(&music, b"not audio").unwrap();
+
+    let options = EncodeOptions {
+        output_path: path_string(&temp_dir.path().join("output.webm")),
+        background_music: Some(path_string(&music)),
+        ..Default::default()
+    };
+    let result = slideshow(&entries, &options);
+    assert!(
+        matches!(result, Err(Error::Ffmpeg(_))),
+        "Unexpected result {:?}",
+        result
+    );
+}
+
+/// Test trimming long background music to the slideshow length
+#[test]
+fn test_background_music_trimmed() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let entries = create_slides(&temp_dir, 2, 500);
+
+    let music = temp_dir.path().join("music.wav");
+    save_sine_wav(&music, 5000, 440.0).unwrap();
+
+    let output_path = temp_dir.path().join("output.webm");
+    let options = EncodeOptions {
+        output_path: path_string(&output_path),
+        background_music: Some(path_string(&music)),
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "Slideshow with music failed: {:?}", result);
+
+    let duration = probe_duration(&output_path).unwrap();
+    assert!(duration < 1.5, "Music was not trimmed: {}", duration);
+}
+
+/// Test normalizing background music to a target loudness
+#[test]
+fn test_background_music_loudness() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let entries = create_slides(&temp_dir, 2, 1000);
+
+    let music = temp_dir.path().join("music.wav");
+    save_sine_wav(&music, 2000, 440.0).unwrap();
+
+    let output_path = temp_dir.path().join("output.webm");
+    let options = EncodeOptions {
+        output_path: path_string(&output_path),
+        background_music: Some(path_string(&music)),
+        loudness: -16.0,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(
+        result.is_ok(),
+        "Slideshow with loudness failed: {:?}",
+        result
+    );
+    assert_eq!(probe_audio_codecs(&output_path), vec!["opus"]);
+}
+
+/// Test an out-of-range loudness target (should fail)
+#[test]
+fn test_invalid_loudness() {
+    let temp_dir = TempDir::new().unwrap();
+    let entries = create_slides(&temp_dir, 1, 500);
+
+    let options = EncodeOptions {
+        output_path: path_string(&temp_dir.path().join("output.webm")),
+        loudness: 3.0,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_err(), "Positive loudness should fail");
+}
+
+/// Test background music with a missing audio file (should fail)
+#[test]
+fn test_background_music_missing_file() {
+    let temp_dir = TempDir::new().unwrap();
+    let entries = create_slides(&temp_dir, 1, 200);
+
+    let options = EncodeOptions {
+        output_path: path_string(&temp_dir.path().join("output.webm")),
+        background_music: Some(path_string(&temp_dir.path().join("missing.mp3"))),
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_err(), "Missing music file should fail");
+}
+
+/// Test background music with a container without audio (should fail)
+#[test]
+fn test_background_music_unsupported_container() {
+    let temp_dir = TempDir::new().unwrap();
+    let entries = create_slides(&temp_dir, 1, 200);
+
+    let music = temp_dir.path().join("music.wav");
+    save_sine_wav(&music, 500, 440.0).unwrap();
+
+    let options = EncodeOptions {
+        output_path: path_string(&temp_dir.path().join("output.png")),
+        container: Container::Apng,
+        codec: Codec::Png,
+        background_music: Some(path_string(&music)),
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_err(), "APNG with background music should fail");
+}
+
+// ============================================================================
+// Narration tests
+// ============================================================================
+
+/// Test slide durations derived from narration clips
+#[test]
+fn test_narration_sets_slide_duration() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let mut entries = create_slides(&temp_dir, 2, 0);
+    for (i, (entry, ms)) in entries.iter_mut().zip([1200, 800]).enumerate() {
+        let narration = temp_dir.path().join(format!("narration_{}.wav", i));
+        save_sine_wav(&narration, ms, 330.0).unwrap();
+        entry.audio = Some(path_string(&narration));
+    }
+
+    let output_path = temp_dir.path().join("output.webm");
+    let options = EncodeOptions {
+        output_path: path_string(&output_path),
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(
+        result.is_ok(),
+        "Slideshow with narration failed: {:?}",
+        result
+    );
+    assert_eq!(probe_audio_codecs(&output_path), vec!["opus"]);
+
+    let duration = probe_duration(&output_path).unwrap();
+    assert!(
+        (duration - 2.0).abs() < 0.2,
+        "Unexpected duration {}",
+        duration
+    );
+}
+
+/// Test narration mixed over background music with fixed slide durations
+#[test]
+fn test_narration_with_background_music_mp4() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+    if minmpeg::available(Codec::H264, None).is_err() {
+        println!("Skipping test: H.264 not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let mut entries = create_slides(&temp_dir, 3, 1000);
+
+    // Narration on the second slide only, longer than the slide
+    let narration = temp_dir.path().join("narration.wav");
+    save_sine_wav(&narration, 1500, 330.0).unwrap();
+    entries[1].audio = Some(path_string(&narration));
+
+    let music = temp_dir.path().join("music.wav");
+    save_sine_wav(&music, 1000, 440.0).unwrap();
+
+    let output_path = temp_dir.path().join("output.mp4");
+    let options = EncodeOptions {
+        output_path: path_string(&output_path),
+        container: Container::Mp4,
+        codec: Codec::H264,
+        background_music: Some(path_string(&music)),
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(
+        result.is_ok(),
+        "Slideshow with narration failed: {:?}",
+        result
+    );
+    assert!(verify_mp4_header(&output_path));
+    assert_eq!(probe_audio_codecs(&output_path), vec!["aac"]);
+
+    let duration = probe_duration(&output_path).unwrap();
+    assert!(
+        (duration - 3.0).abs() < 0.2,
+        "Unexpected duration {}",
+        duration
+    );
+}
+
+/// Test ducking background music under narration with custom settings
+#[test]
+fn test_narration_ducking_webm() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let mut entries = create_slides(&temp_dir, 2, 1000);
+
+    let narration = temp_dir.path().join("narration.wav");
+    save_sine_wav(&narration, 800, 330.0).unwrap();
+    entries[0].audio = Some(path_string(&narration));
+
+    let music = temp_dir.path().join("music.wav");
+    save_sine_wav(&music, 2000, 440.0).unwrap();
+
+    let output_path = temp_dir.path().join("output.webm");
+    let options = EncodeOptions {
+        output_path: path_string(&output_path),
+        background_music: Some(path_string(&music)),
+        duck_threshold: -40.0,
+        duck_amount: 18.0,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(
+        result.is_ok(),
+        "Slideshow with ducking failed: {:?}",
+        result
+    );
+    assert_eq!(probe_audio_codecs(&output_path), vec!["opus"]);
+}
+
+/// Test ducking settings outside the supported range (should fail)
+#[test]
+fn test_invalid_ducking() {
+    let temp_dir = TempDir::new().unwrap();
+    let entries = create_slides(&temp_dir, 1, 500);
+
+    for (threshold, amount) in [(5.0, 0.0), (-80.0, 0.0), (0.0, 25.0), (0.0, -6.0)] {
+        let options = EncodeOptions {
+            output_path: path_string(&temp_dir.path().join("output.webm")),
+            duck_threshold: threshold,
+            duck_amount: amount,
+            ..Default::default()
+        };
+        let result = slideshow(&entries, &options);
+        assert!(
+            result.is_err(),
+            "Ducking {} dBFS / {} dB should fail",
+            threshold,
+            amount
+        );
+    }
+}
+
+/// Test narration with a missing audio file (should fail)
+#[test]
+fn test_narration_missing_file() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let mut entries = create_slides(&temp_dir, 1, 0);
+    entries[0].audio = Some(path_string(&temp_dir.path().join("missing.wav")));
+
+    let options = EncodeOptions {
+        output_path: path_string(&temp_dir.path().join("output.webm")),
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_err(), "Missing narration file should fail");
+}
+
+/// Test narration with a container without audio (should fail)
+#[test]
+fn test_narration_unsupported_container() {
+    let temp_dir = TempDir::new().unwrap();
+    let mut entries = create_slides(&temp_dir, 1, 500);
+
+    let narration = temp_dir.path().join("narration.wav");
+    save_sine_wav(&narration, 500, 330.0).unwrap();
+    entries[0].audio = Some(path_string(&narration));
+
+    let options = EncodeOptions {
+        output_path: path_string(&temp_dir.path().join("output.avif")),
+        container: Container::Avif,
+        codec: Codec::Av1,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_err(), "AVIF with narration should fail");
+}
+
+// ============================================================================
+// Replace audio tests
+// ============================================================================
+
+/// Test looping short audio over an existing video
+#[test]
+fn test_replace_audio_looped() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let video = create_video(&temp_dir, 2000);
+
+    let audio = temp_dir.path().join("audio.wav");
+    save_sine_wav(&audio, 600, 440.0).unwrap();
+
+    let output_path = temp_dir.path().join("output.mp4");
+    let options = AudioOptions {
+        fit: AudioFit::Loop,
+        ..Default::default()
+    };
+
+    let result = replace_audio(&video, &audio, &output_path, &options);
+    assert!(result.is_ok(), "Replace audio failed: {:?}", result);
+    assert!(verify_mp4_header(&output_path));
+    assert_eq!(probe_audio_codecs(&output_path), vec!["aac"]);
+
+    let duration = probe_duration(&output_path).unwrap();
+    assert!(
+        (duration - 2.0).abs() < 0.2,
+        "Unexpected duration {}",
+        duration
+    );
+}
+
+/// Test trimming long audio to the video length
+#[test]
+fn test_replace_audio_trimmed() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let video = create_video(&temp_dir, 1000);
+
+    let audio = temp_dir.path().join("audio.wav");
+    save_sine_wav(&audio, 5000, 440.0).unwrap();
+
+    let output_path = temp_dir.path().join("output.webm");
+    let result = replace_audio(&video, &audio, &output_path, &AudioOptions::default());
+    assert!(result.is_ok(), "Replace audio failed: {:?}", result);
+    assert_eq!(probe_audio_codecs(&output_path), vec!["opus"]);
+
+    let duration = probe_duration(&output_path).unwrap();
+    assert!(duration < 1.5, "Audio was not trimmed: {}", duration);
+}
+
+/// Test replacing audio with gain and fades
+#[test]
+fn test_replace_audio_gain_and_fades() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let video = create_video(&temp_dir, 2000);
+
+    let audio = temp_dir.path().join("audio.wav");
+    save_sine_wav(&audio, 2000, 440.0).unwrap();
+
+    let output_path = temp_dir.path().join("output.webm");
+    let options = AudioOptions {
+        gain: -6.0,
+        fade_in_ms: 500,
+        fade_out_ms: 1000,
+        ..Default::default()
+    };
+
+    let result = replace_audio(&video, &audio, &output_path, &options);
+    assert!(result.is_ok(), "Replace audio failed: {:?}", result);
+    assert_eq!(probe_audio_codecs(&output_path), vec!["opus"]);
+
+    let duration = probe_duration(&output_path).unwrap();
+    assert!(
+        (duration - 2.0).abs() < 0.2,
+        "Unexpected duration {}",
+        duration
+    );
+}
+
+/// Test audio gain outside the supported range (should fail)
+#[test]
+fn test_invalid_audio_gain() {
+    let temp_dir = TempDir::new().unwrap();
+    let entries = create_slides(&temp_dir, 1, 500);
+
+    let options = EncodeOptions {
+        output_path: path_string(&temp_dir.path().join("output.webm")),
+        audio_gain: 30.0,
+        ..Default::default()
+    };
+    assert!(slideshow(&entries, &options).is_err());
+
+    let video = create_video(&temp_dir, 500);
+    let options = AudioOptions {
+        gain: -80.0,
+        ..Default::default()
+    };
+    let result = extract_audio(&video, &temp_dir.path().join("audio.wav"), &options);
+    assert!(result.is_err(), "Gain below -60 dB should fail");
+}
+
+/// Test replacing audio with a missing audio file (should fail)
+#[test]
+fn test_replace_audio_missing_file() {
+    let temp_dir = TempDir::new().unwrap();
+    let video = create_video(&temp_dir, 500);
+
+    let result = replace_audio(
+        &video,
+        &temp_dir.path().join("missing.wav"),
+        &temp_dir.path().join("output.webm"),
+        &AudioOptions::default(),
+    );
+    assert!(result.is_err(), "Missing audio file should fail");
+}
+
+/// Test replacing audio with an unsupported output format (should fail)
+#[test]
+fn test_replace_audio_unsupported_output() {
+    let temp_dir = TempDir::new().unwrap();
+    let video = create_video(&temp_dir, 500);
+
+    let audio = temp_dir.path().join("audio.wav");
+    save_sine_wav(&audio, 500, 440.0).unwrap();
+
+    let result = replace_audio(
+        &video,
+        &audio,
+        &temp_dir.path().join("output.avif"),
+        &AudioOptions::default(),
+    );
+    assert!(result.is_err(), "AVIF output should fail");
+
+    // Writing over the input is rejected
+    let result = replace_audio(&video, &audio, &video, &AudioOptions::default());
+    assert!(result.is_err(), "Output equal to the input should fail");
+}
+
+// ============================================================================
+// Remove audio tests
+// ============================================================================
+
+/// Test removing audio with a stream copy of the video
+#[test]
+fn test_remove_audio() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let video = create_video(&temp_dir, 1000);
+
+    let audio = temp_dir.path().join("audio.wav");
+    save_sine_wav(&audio, 1000, 440.0).unwrap();
+    let with_audio = temp_dir.path().join("with_audio.webm");
+    replace_audio(&video, &audio, &with_audio, &AudioOptions::default()).unwrap();
+    assert_eq!(probe_audio_codecs(&with_audio), vec!["opus"]);
+
+    let output_path = temp_dir.path().join("output.webm");
+    let result = remove_audio(&with_audio, &output_path, &AudioOptions::default());
+    assert!(result.is_ok(), "Remove audio failed: {:?}", result);
+    assert!(verify_webm_header(&output_path));
+    assert!(probe_audio_codecs(&output_path).is_empty());
+}
+
+/// Test removing audio from a missing video (should fail)
+#[test]
+fn test_remove_audio_missing_file() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let result = remove_audio(
+        &temp_dir.path().join("missing.mp4"),
+        &temp_dir.path().join("output.mp4"),
+        &AudioOptions::default(),
+    );
+    assert!(result.is_err(), "Missing video should fail");
+}
+
+// ============================================================================
+// Extract audio tests
+// ============================================================================
+
+/// Create a WebM video with a sine tone in the temp directory
+fn create_video_with_audio(temp_dir: &TempDir, duration_ms: u32) -> std::path::PathBuf {
+    let video = create_video(temp_dir, duration_ms);
+    let audio = temp_dir.path().join("tone.wav");
+    save_sine_wav(&audio, duration_ms, 440.0).unwrap();
+
+    let path = temp_dir.path().join("with_audio.webm");
+    replace_audio(&video, &audio, &path, &AudioOptions::default()).unwrap();
+    path
+}
+
+/// Test extracting audio with the codec chosen by extension
+#[test]
+fn test_extract_audio_by_extension() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let video = create_video_with_audio(&temp_dir, 1000);
+
+    for (name, codec) in [("audio.wav", "pcm_s16le"), ("audio.m4a", "aac")] {
+        let output_path = temp_dir.path().join(name);
+        let result = extract_audio(&video, &output_path, &AudioOptions::default());
+        assert!(result.is_ok(), "Extract to {} failed: {:?}", name, result);
+        assert_eq!(probe_audio_codecs(&output_path), vec![codec]);
+    }
+}
+
+/// Test extracting audio with an explicit codec and bitrate
+#[test]
+fn test_extract_audio_codec_and_bitrate() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let video = create_video_with_audio(&temp_dir, 1000);
+
+    let output_path = temp_dir.path().join("audio.opus");
+    let options = AudioOptions {
+        codec: AudioCodec::Opus,
+        bitrate: 48,
+        ..Default::default()
+    };
+
+    let result = extract_audio(&video, &output_path, &options);
+    assert!(result.is_ok(), "Extract audio failed: {:?}", result);
+    assert_eq!(probe_audio_codecs(&output_path), vec!["opus"]);
+}
+
+/// Test extracting audio with a codec that does not match the extension (should fail)
+#[test]
+fn test_extract_audio_codec_mismatch() {
+    let temp_dir = TempDir::new().unwrap();
+    let video = create_video(&temp_dir, 500);
+
+    let options = AudioOptions {
+        codec: AudioCodec::Mp3,
+        ..Default::default()
+    };
+
+    let result = extract_audio(&video, &temp_dir.path().join("audio.wav"), &options);
+    assert!(result.is_err(), "MP3 codec with a .wav file should fail");
+}
+
+// ============================================================================
+// Audio visualization tests
+// ============================================================================
+
+/// Test a waveform video lasting as long as its audio
+#[test]
+fn test_visualize_audio_waveform_webm() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let audio = temp_dir.path().join("podcast.wav");
+    save_sine_wav(&audio, 1500, 440.0).unwrap();
+
+    let output_path = temp_dir.path().join("waveform.webm");
+    let options = EncodeOptions {
+        output_path: path_string(&output_path),
+        ..Default::default()
+    };
+    let visual = Visualization {
+        width: 320,
+        height: 240,
+        color: Color {
+            r: 0,
+            g: 200,
+            b: 255,
+        },
+        ..Default::default()
+    };
+
+    let result = visualize_audio(&audio, &options, &visual);
+    assert!(result.is_ok(), "Visualize audio failed: {:?}", result);
+    assert!(verify_webm_header(&output_path));
+    assert_eq!(probe_audio_codecs(&output_path), vec!["opus"]);
+
+    let duration = probe_duration(&output_path).unwrap();
+    assert!((duration - 1.5).abs() < 0.1, "duration was {}", duration);
+}
+
+/// Test a spectrum video over a background image
+#[test]
+fn test_visualize_audio_spectrum_background_image() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let audio = temp_dir.path().join("podcast.wav");
+    save_sine_wav(&audio, 1000, 330.0).unwrap();
+    let cover = temp_dir.path().join("cover.png");
+    save_png(&generate_numbered_image(400, 400, 1), &cover).unwrap();
+
+    let output_path = temp_dir.path().join("spectrum.webm");
+    let options = EncodeOptions {
+        output_path: path_string(&output_path),
+        ..Default::default()
+    };
+    let visual = Visualization {
+        style: VisualStyle::Spectrum,
+        width: 320,
+        height: 180,
+        background_image: Some(path_string(&cover)),
+        ..Default::default()
+    };
+
+    let result = visualize_audio(&audio, &options, &visual);
+    assert!(result.is_ok(), "Visualize audio failed: {:?}", result);
+    assert!(verify_webm_header(&output_path));
+}
+
+/// Test a still image video lasting exactly as long as its audio
+#[test]
+fn test_image_with_audio_mp4() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let audio = temp_dir.path().join("song.wav");
+    save_sine_wav(&audio, 1234, 440.0).unwrap();
+    let cover = temp_dir.path().join("cover.png");
+    save_png(&generate_numbered_image(321, 241, 2), &cover).unwrap();
+
+    let output_path = temp_dir.path().join("song.mp4");
+    let options = EncodeOptions {
+        output_path: path_string(&output_path),
+        container: Container::Mp4,
+        codec: Codec::Av1,
+        ..Default::default()
+    };
+
+    let result = image_with_audio(&cover, &audio, &options);
+    assert!(result.is_ok(), "Image with audio failed: {:?}", result);
+    assert!(verify_mp4_header(&output_path));
+    assert_eq!(probe_audio_codecs(&output_path), vec!["aac"]);
+
+    let duration = probe_duration(&output_path).unwrap();
+    assert!((duration - 1.234).abs() < 0.05, "duration was {}", duration);
+}
+
+/// Test a still image video with a missing image (should fail)
+#[test]
+fn test_image_with_audio_missing_image() {
+    let temp_dir = TempDir::new().unwrap();
+    let audio = temp_dir.path().join("song.wav");
+    save_sine_wav(&audio, 500, 440.0).unwrap();
+
+    let options = EncodeOptions {
+        output_path: path_string(&temp_dir.path().join("song.webm")),
+        ffmpeg_path: Some("/nonexistent/ffmpeg".to_string()),
+        ..Default::default()
+    };
+
+    let missing = temp_dir.path().join("missing.png");
+    let result = image_with_audio(&missing, &audio, &options);
+    assert!(result.is_err(), "Missing image should fail");
+}
+
+/// Test visualizing into a container without audio (should fail)
+#[test]
+fn test_visualize_audio_unsupported_container() {
+    let temp_dir = TempDir::new().unwrap();
+    let audio = temp_dir.path().join("podcast.wav");
+    save_sine_wav(&audio, 500, 440.0).unwrap();
+
+    let options = EncodeOptions {
+        output_path: path_string(&temp_dir.path().join("waveform.avif")),
+        container: Container::Avif,
+        codec: Codec::Av1,
+        ..Default::default()
+    };
+
+    let result = visualize_audio(&audio, &options, &Visualization::default());
+    assert!(result.is_err(), "AVIF output should fail");
+}
@@ -0,0 +1,90 @@
+//! Integration tests for encoder benchmarks
+
+mod common;
+
+use common::*;
+use minmpeg::{benchmark, BenchmarkSpec, Codec, Container, Error};
+use tempfile::TempDir;
+
+/// Test measuring the lossless PNG codec
+#[test]
+fn test_benchmark_png() {
+    let temp_dir = TempDir::new().unwrap();
+    let report = benchmark(&BenchmarkSpec {
+        codecs: vec![Codec::Png],
+        width: 64,
+        height: 48,
+        duration_ms: 500,
+        fps: 10,
+        output_dir: Some(temp_dir.path().to_string_lossy().to_string()),
+        ..Default::default()
+    })
+    .unwrap();
+
+    assert_eq!((report.width, report.height, report.frames), (64, 48, 5));
+    assert_eq!(report.results.len(), 1);
+    let result = &report.results[0];
+    assert!(result.error.is_none(), "{:?}", result.error);
+    assert_eq!(result.container, Container::Apng);
+    assert_eq!(result.frames, 5);
+    assert!(result.encode_fps > 0.0);
+    assert!(result.output_bytes > 0 && result.bitrate_kbps > 0);
+    #[cfg(unix)]
+    assert!(result.cpu_percent.is_some());
+
+    // Lossless output decodes to the workload
+    if ffmpeg_available() {
+        assert_eq!(result.psnr, Some(100.0));
+        assert!((result.ssim.unwrap() - 1.0).abs() < 1e-6);
+    }
+
+    // The encoded files are removed
+    assert_eq!(std::fs::read_dir(temp_dir.path()).unwrap().count(), 0);
+}
+
+/// Test measuring lossy codecs
+#[test]
+fn test_benchmark_lossy() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let report = benchmark(&BenchmarkSpec {
+        codecs: vec![Codec::Av1, Codec::Vp9],
+        width: 160,
+        height: 120,
+        duration_ms: 1000,
+        ..Default::default()
+    })
+    .unwrap();
+
+    for result in &report.results {
+        assert!(result.error.is_none(), "{:?}", result.error);
+        let psnr = result.psnr.unwrap();
+        assert!(psnr > 20.0 && psnr < 100.0, "{:?}: {}", result.codec, psnr);
+        assert!(result.ssim.unwrap() > 0.5);
+    }
+}
+
+/// Test that failing codecs are reported without failing the benchmark
+#[test]
+fn test_benchmark_codec_error() {
+    let report = benchmark(&BenchmarkSpec {
+        codecs: vec![Codec::Vp9, Codec::Png],
+        width: 32,
+        height: 32,
+        duration_ms: 100,
+        fps: 10,
+        ffmpeg_path: Some("/nonexistent/ffmpeg".to_string()),
+        ..Default::default()
+    })
+    .unwrap();
+
+    assert!(matches!(
+        report.results[0].error,
+        Some(Error::CodecUnavailable(_) | Error::Ffmpeg(_))
+    ));
+    assert_eq!(report.results[0].frames, 0);
+    assert!(report.results[1].error.is_none());
+}
@@ -100,6 +100,206 @@ pub fn verify_mp4_header<P: AsRef<Path>>(path: P) -> bool {
     &header[4..8] == b"ftyp"
 }
 
+/// Parse AVIF header to verify it's an animated AVIF file
+pub fn verify_avif_header<P: AsRef<Path>>(path: P) -> bool {
+    use std::io::Read;
+
+    let mut file = match std::fs::File::open(path) {
+        Ok(f) => f,
+        Err(_) => return false,
+    };
+
+    let mut header = [0u8; 12];
+    if file.read_exact(&mut header).is_err() {
+        return false;
+    }
+
+    // Animated AVIF files have 'ftyp' box with major brand 'avis'
+    &header[4..8] == b"ftyp" && &header[8..12] == b"avis"
+}
+
+/// Parse PNG header to verify it's an animated PNG file
+pub fn verify_apng_header<P: AsRef<Path>>(path: P) -> bool {
+    let data = match std::fs::read(path) {
+        Ok(d) => d,
+        Err(_) => return false,
+    };
+
+    // PNG signature followed by IHDR, then acTL before the image data
+    data.len() > 45
+        && data[..8] == [0x89, b'P', b'N', b'G', 0x0D, 0x0A, 0x1A, 0x0A]
+        && &data[12..16] == b"IHDR"
+        && &data[37..41] == b"acTL"
+}
+
+/// Write a mono 16-bit PCM WAV file with a sine tone
+pub fn save_sine_wav<P: AsRef<Path>>(
+    path: P,
+    duration_ms: u32,
+    frequency: f32,
+) -> std::io::Result<()> {
+    const SAMPLE_RATE: u32 = 44100;
+    let samples = (SAMPLE_RATE as u64 * duration_ms as u64 / 1000) as u32;
+    let data_len = samples * 2;
+
+    let mut wav = Vec::with_capacity(44 + data_len as usize);
+    wav.extend_from_slice(b"RIFF");
+    wav.extend_from_slice(&(36 + data_len).to_le_bytes());
+    wav.extend_from_slice(b"WAVEfmt ");
+    wav.extend_from_slice(&16u32.to_le_bytes());
+    wav.extend_from_slice(&1u16.to_le_bytes()); // PCM
+    wav.extend_from_slice(&1u16.to_le_bytes()); // mono
+    wav.extend_from_slice(&SAMPLE_RATE.to_le_bytes());
+    wav.extend_from_slice(&(SAMPLE_RATE * 2).to_le_bytes());
+    wav.extend_from_slice(&2u16.to_le_bytes());
+    wav.extend_from_slice(&16u16.to_le_bytes());
+    wav.extend_from_slice(b"data");
+    wav.extend_from_slice(&data_len.to_le_bytes());
+
+    for i in 0..samples {
+        let t = i as f32 / SAMPLE_RATE as f32;
+        let v = (t * frequency * std::f32::consts::TAU).sin() * 0.5 * i16::MAX as f32;
+        wav.extend_from_slice(&(v as i16).to_le_bytes());
+    }
+
+    std::fs::write(path, wav)
+}
+
+/// Check if ffmpeg and ffprobe are available
+pub fn ffmpeg_available() -> bool {
+    ["ffmpeg", "ffprobe"].iter().all(|tool| {
+        std::process::Command::new(tool)
+            .arg("-version")
+            .output()
+            .map(|o| o.status.success())
+            .unwrap_or(false)
+    })
+}
+
+/// Get the codec names of the audio streams in a media file using ffprobe
+pub fn probe_audio_codecs<P: AsRef<Path>>(path: P) -> Vec<String> {
+    std::process::Command::new("ffprobe")
+        .args([
+            "-v",
+            "error",
+            "-select_streams",
+            "a",
+            "-show_entries",
+            "stream=codec_name",
+            "-of",
+            "csv=p=0",
+        ])
+        .arg(path.as_ref())
+        .output()
+        .map(|o| {
+            String::from_utf8_lossy(&o.stdout)
+                .lines()
+                .map(|l| l.trim().to_string())
+                .filter(|l| !l.is_empty())
+                .collect()
+        })
+        .unwrap_or_default()
+}
+
+/// Get the duration of a media file in seconds using ffprobe
+pub fn probe_duration<P: AsRef<Path>>(path: P) -> Option<f64> {
+    let output = std::process::Command::new("ffprobe")
+        .args([
+            "-v",
+            "error",
+            "-show_entries",
+            "format=duration",
+            "-of",
+            "csv=p=0",
+        ])
+        .arg(path.as_ref())
+        .output()
+        .ok()?;
+    String::from_utf8_lossy(&output.stdout).trim().parse().ok()
+}
+
+/// Get the times of the keyframes of a video in seconds using ffprobe
+pub fn probe_keyframe_times<P: AsRef<Path>>(path: P) -> Vec<f64> {
+    std::process::Command::new("ffprobe")
+        .args(["-v", "error", "-select_streams", "v:0"])
+        .args(["-skip_frame", "nokey", "-show_entries", "frame=pts_time"])
+        .args(["-of", "csv=p=0"])
+        .arg(path.as_ref())
+        .output()
+        .map(|output| {
+            String::from_utf8_lossy(&output.stdout)
+                .lines()
+                .filter_map(|line| line.trim().parse().ok())
+                .collect()
+        })
+        .unwrap_or_default()
+}
+
+/// Get the pixel format of the first video stream (`yuv420p10le`) using
+/// ffprobe
+pub fn probe_pixel_format<P: AsRef<Path>>(path: P) -> Option<String> {
+    let output = std::process::Command::new("ffprobe")
+        .args(["-v", "error", "-select_streams", "v:0"])
+        .args(["-show_entries", "stream=pix_fmt", "-of", "csv=p=0"])
+        .arg(path.as_ref())
+        .output()
+        .ok()?;
+    let format = String::from_utf8_lossy(&output.stdout).trim().to_string();
+    (!format.is_empty()).then_some(format)
+}
+
+/// Get the width and height of the first video stream using ffprobe
+pub fn probe_dimensions<P: AsRef<Path>>(path: P) -> Option<(u32, u32)> {
+    let output = std::process::Command::new("ffprobe")
+        .args(["-v", "error", "-select_streams", "v:0"])
+        .args(["-show_entries", "stream=width,height", "-of", "csv=p=0"])
+        .arg(path.as_ref())
+        .output()
+        .ok()?;
+    let info = String::from_utf8_lossy(&output.stdout);
+    let (width, height) = info.trim().split_once(',')?;
+    Some((width.parse().ok()?, height.parse().ok()?))
+}
+
+/// Probe the transfer characteristics of the first video stream with ffprobe
+pub fn probe_color_transfer<P: AsRef<Path>>(path: P) -> Option<String> {
+    let output = std::process::Command::new("ffprobe")
+        .args(["-v", "error", "-select_streams", "v:0"])
+        .args(["-show_entries", "stream=color_transfer", "-of", "csv=p=0"])
+        .arg(path.as_ref())
+        .output()
+        .ok()?;
+    let transfer = String::from_utf8_lossy(&output.stdout).trim().to_string();
+    (!transfer.is_empty()).then_some(transfer)
+}
+
+/// Probe the color range, matrix, transfer and primaries of the first video
+/// stream with ffprobe, as ffprobe names them
+pub fn probe_color_space<P: AsRef<Path>>(path: P) -> Option<[String; 4]> {
+    let output = std::process::Command::new("ffprobe")
+        .args(["-v", "error", "-select_streams", "v:0"])
+        .args([
+            "-show_entries",
+            "stream=color_range,color_space,color_transfer,color_primaries",
+        ])
+        .args(["-of", "default=noprint_wrappers=1"])
+        .arg(path.as_ref())
+        .output()
+        .ok()?;
+    let text = String::from_utf8_lossy(&output.stdout).to_string();
+    let value = |key: &str| {
+        text.lines()
+            .find_map(|line| line.strip_prefix(key)?.strip_prefix('='))
+            .map(str::to_string)
+    };
+    Some([
+        value("color_range")?,
+        value("color_space")?,
+        value("color_transfer")?,
+        value("color_primaries")?,
+    ])
+}
+
 /// Get file size in bytes
 pub fn get_file_size<P: AsRef<Path>>(path: P) -> Option<u64> {
     std::fs::metadata(path).ok().map(|m| m.len())
@@ -0,0 +1,86 @@
+//! Integration tests for frame hashes
+
+mod common;
+
+use common::*;
+use image::{ImageBuffer, Rgba, RgbaImage};
+use minmpeg::{frame_hashes, slideshow, EncodeOptions, SlideEntry};
+use tempfile::TempDir;
+
+/// Image with a bright left or right half
+fn half_bright_image(width: u32, height: u32, left: bool) -> RgbaImage {
+    ImageBuffer::from_fn(width, height, |x, _| {
+        let v = if (x < width / 2) == left { 230 } else { 30 };
+        Rgba([v, v, v, 255])
+    })
+}
+
+/// Test hashing an image
+#[test]
+fn test_frame_hashes_image() {
+    let temp_dir = TempDir::new().unwrap();
+    let path = temp_dir.path().join("left.png");
+    save_png(&half_bright_image(64, 48, true), &path).unwrap();
+
+    let hashes = frame_hashes(&path, 5, None).unwrap();
+    assert_eq!(hashes.len(), 1);
+    assert_eq!(hashes[0].time_ms, 0);
+
+    // The same content at another size matches
+    let large = temp_dir.path().join("large.png");
+    save_png(&half_bright_image(320, 240, true), &large).unwrap();
+    assert!(hashes[0].matches(&frame_hashes(&large, 1, None).unwrap()[0]));
+
+    let right = temp_dir.path().join("right.png");
+    save_png(&half_bright_image(64, 48, false), &right).unwrap();
+    assert!(!hashes[0].matches(&frame_hashes(&right, 1, None).unwrap()[0]));
+}
+
+/// Test hashing sampled frames of an encoded video
+#[test]
+fn test_frame_hashes_video() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let entries: Vec<SlideEntry> = [true, false]
+        .iter()
+        .enumerate()
+        .map(|(i, &left)| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&half_bright_image(64, 48, left), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 1000,
+                ..Default::default()
+            }
+        })
+        .collect();
+
+    let video = temp_dir.path().join("video.webm");
+    let options = EncodeOptions {
+        output_path: video.to_string_lossy().to_string(),
+        ..Default::default()
+    };
+    slideshow(&entries, &options).unwrap();
+
+    let hashes = frame_hashes(&video, 4, None).unwrap();
+    assert!(hashes.len() >= 2 && hashes.len() <= 4);
+    assert!(hashes.windows(2).all(|w| w[0].time_ms < w[1].time_ms));
+
+    // The encoded slides match their images
+    let first = frame_hashes(&entries[0].path, 1, None).unwrap()[0];
+    let last = frame_hashes(&entries[1].path, 1, None).unwrap()[0];
+    assert!(hashes[0].matches(&first));
+    assert!(hashes[hashes.len() - 1].matches(&last));
+    assert!(!hashes[0].matches(&hashes[hashes.len() - 1]));
+}
+
+/// Test hashing a missing file (should fail)
+#[test]
+fn test_frame_hashes_missing_file() {
+    let temp_dir = TempDir::new().unwrap();
+    assert!(frame_hashes(temp_dir.path().join("missing.mp4"), 1, None).is_err());
+}
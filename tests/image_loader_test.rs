@@ -3,7 +3,7 @@
 mod common;
 
 use common::*;
-use minmpeg::image_loader::LoadedImage;
+use minmpeg::image_loader::{decode_animation, is_avif, is_heif, is_svg, LoadedImage};
 use tempfile::TempDir;
 
 /// Test loading a JPEG image
@@ -38,6 +38,127 @@ fn test_load_png() {
     assert_eq!(loaded.data.len(), (200 * 150 * 4) as usize);
 }
 
+/// Test that JPEG photos are turned upright by their EXIF orientation
+#[test]
+fn test_load_jpeg_exif_orientation() {
+    let temp_dir = TempDir::new().unwrap();
+    let path = temp_dir.path().join("portrait.jpg");
+
+    // Stored sideways, as phones do, with orientation 6 (rotate 90° clockwise)
+    let original = generate_test_image(200, 150, [255, 128, 64, 255]);
+    save_jpeg(&original, &path, 85).unwrap();
+    let jpeg = std::fs::read(&path).unwrap();
+    std::fs::write(&path, with_exif_orientation(&jpeg, 6)).unwrap();
+
+    let loaded = LoadedImage::from_path(&path).unwrap();
+    assert_eq!(loaded.width, 150);
+    assert_eq!(loaded.height, 200);
+}
+
+/// Insert an EXIF segment with an orientation tag after the start of a JPEG
+fn with_exif_orientation(jpeg: &[u8], orientation: u16) -> Vec<u8> {
+    let mut exif = b"Exif\0\0".to_vec();
+    // Big-endian TIFF header, then one IFD entry: tag 0x0112, SHORT, count 1
+    exif.extend_from_slice(b"MM\0\x2a\0\0\0\x08\0\x01");
+    exif.extend_from_slice(&[0x01, 0x12, 0, 3, 0, 0, 0, 1]);
+    exif.extend_from_slice(&orientation.to_be_bytes());
+    exif.extend_from_slice(&[0; 6]); // padding and no next IFD
+
+    let mut data = jpeg[..2].to_vec();
+    data.extend_from_slice(&[0xFF, 0xE1]);
+    data.extend_from_slice(&(exif.len() as u16 + 2).to_be_bytes());
+    data.extend_from_slice(&exif);
+    data.extend_from_slice(&jpeg[2..]);
+    data
+}
+
+/// Test that HEIF images are told apart by the brand of their file type box
+#[test]
+fn test_is_heif() {
+    let temp_dir = TempDir::new().unwrap();
+    let file = |name: &str, data: &[u8]| {
+        let path = temp_dir.path().join(name);
+        std::fs::write(&path, data).unwrap();
+        path
+    };
+
+    assert!(is_heif(file(
+        "photo.heic",
+        b"\0\0\0\x18ftypheic\0\0\0\0mif1heic"
+    )));
+    // The brand decides, not the extension
+    assert!(is_heif(file(
+        "photo.jpg",
+        b"\0\0\0\x18ftypmif1\0\0\0\0mif1heic"
+    )));
+    assert!(!is_heif(file(
+        "video.mp4",
+        b"\0\0\0\x18ftypisom\0\0\0\0isomiso2"
+    )));
+    assert!(!is_heif(file("short.heic", b"ftyp")));
+    assert!(!is_heif(temp_dir.path().join("missing.heic")));
+}
+
+/// Test that AVIF images are told apart from HEIF images and videos
+#[test]
+fn test_is_avif() {
+    let temp_dir = TempDir::new().unwrap();
+    let file = |name: &str, data: &[u8]| {
+        let path = temp_dir.path().join(name);
+        std::fs::write(&path, data).unwrap();
+        path
+    };
+
+    let avif = file("photo.avif", b"\0\0\0\x1cftypavif\0\0\0\0avifmif1miaf");
+    assert!(is_avif(&avif));
+    assert!(!is_heif(&avif));
+    assert!(is_avif(file(
+        "clip.avifs",
+        b"\0\0\0\x1cftypavis\0\0\0\0avisavifmsf1"
+    )));
+    assert!(!is_avif(file(
+        "photo.heic",
+        b"\0\0\0\x18ftypheic\0\0\0\0mif1heic"
+    )));
+    assert!(!is_avif(temp_dir.path().join("missing.avif")));
+}
+
+/// Test that SVG images are told apart by their root element
+#[test]
+fn test_is_svg() {
+    let temp_dir = TempDir::new().unwrap();
+    let file = |name: &str, data: &[u8]| {
+        let path = temp_dir.path().join(name);
+        std::fs::write(&path, data).unwrap();
+        path
+    };
+
+    assert!(is_svg(file(
+        "card.svg",
+        br#"<svg xmlns="http://www.w3.org/2000/svg" width="640" height="360"/>"#
+    )));
+    assert!(is_svg(file(
+        "diagram.xml",
+        b"\xef\xbb\xbf<?xml version=\"1.0\"?>\n<!-- Exported -->\n<svg viewBox=\"0 0 16 9\"></svg>"
+    )));
+    assert!(!is_svg(file("notes.svg", b"Draw an <svg> here")));
+    assert!(!is_svg(file(
+        "page.html",
+        b"<!DOCTYPE html><html><body><p>No images</p></body></html>"
+    )));
+    assert!(!is_svg(temp_dir.path().join("missing.svg")));
+}
+
+/// Test that still images are not taken for animations
+#[test]
+fn test_decode_animation_still() {
+    let temp_dir = TempDir::new().unwrap();
+    let path = temp_dir.path().join("still.png");
+    save_png(&generate_test_image(64, 48, [0, 128, 255, 255]), &path).unwrap();
+
+    assert!(decode_animation(&path, None).unwrap().is_none());
+}
+
 /// Test loading a non-existent file
 #[test]
 fn test_load_nonexistent() {
@@ -3,7 +3,10 @@
 mod common;
 
 use common::*;
-use minmpeg::{juxtapose, slideshow, Codec, Color, Container, EncodeOptions, SlideEntry};
+use minmpeg::{
+    juxtapose, slideshow, Codec, Color, Container, DecodeMode, EncodeOptions, JuxtaposeLayout,
+    SlideEntry, ToneMap,
+};
 use std::process::Command;
 use tempfile::TempDir;
 
@@ -40,12 +43,19 @@ fn create_test_video(
         .map(|path| SlideEntry {
             path: path.to_string_lossy().to_string(),
             duration_ms: 200,
+            ..Default::default()
         })
         .collect();
 
     let ext = match container {
         Container::WebM => "webm",
         Container::Mp4 => "mp4",
+        Container::Avif => "avif",
+        Container::Apng => "png",
+        Container::Mov => "mov",
+        Container::Mkv => "mkv",
+        Container::Hls => "m3u8",
+        Container::Dash => "mpd",
     };
 
     let output_path = temp_dir.path().join(format!("{}.{}", name, ext));
@@ -56,6 +66,7 @@ fn create_test_video(
         codec,
         quality: 50,
         ffmpeg_path: None,
+        ..Default::default()
     };
 
     slideshow(&entries, &options).expect("Failed to create test video");
@@ -90,6 +101,7 @@ fn test_juxtapose_same_size_webm_av1() {
         codec: Codec::Av1,
         quality: 50,
         ffmpeg_path: None,
+        ..Default::default()
     };
 
     let result = juxtapose(&left_video, &right_video, &options, None);
@@ -130,6 +142,7 @@ fn test_juxtapose_same_size_mp4_h264_macos() {
         codec: Codec::H264,
         quality: 50,
         ffmpeg_path: None,
+        ..Default::default()
     };
 
     let result = juxtapose(&left_video, &right_video, &options, None);
@@ -166,6 +179,7 @@ fn test_juxtapose_same_size_mp4_h264_windows() {
         codec: Codec::H264,
         quality: 50,
         ffmpeg_path: None,
+        ..Default::default()
     };
 
     let result = juxtapose(&left_video, &right_video, &options, None);
@@ -205,6 +219,7 @@ fn test_juxtapose_different_size_webm_av1() {
         codec: Codec::Av1,
         quality: 50,
         ffmpeg_path: None,
+        ..Default::default()
     };
 
     // Use a custom background color
@@ -224,6 +239,202 @@ fn test_juxtapose_different_size_webm_av1() {
     assert!(verify_webm_header(&output_path));
 }
 
+/// Test juxtapose with software decoding forced
+#[test]
+fn test_juxtapose_software_decode() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+
+    let left_video = create_test_video(&temp_dir, "left", 160, 120, 2, Container::WebM, Codec::Av1);
+    let right_video =
+        create_test_video(&temp_dir, "right", 160, 120, 2, Container::WebM, Codec::Av1);
+
+    let output_path = temp_dir.path().join("output.webm");
+
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        decode_mode: DecodeMode::Software,
+        ..Default::default()
+    };
+
+    let result = juxtapose(&left_video, &right_video, &options, None);
+    assert!(
+        result.is_ok(),
+        "Juxtapose with software decoding failed: {:?}",
+        result
+    );
+    assert!(verify_webm_header(&output_path));
+}
+
+/// Test juxtapose of an HDR (PQ) video next to an SDR video
+#[test]
+fn test_juxtapose_hdr_tone_map() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+
+    // 10-bit BT.2020 test pattern tagged with the PQ transfer function
+    let hdr_video = temp_dir.path().join("hdr.mkv");
+    let status = Command::new("ffmpeg")
+        .args(["-y", "-v", "error", "-f", "lavfi", "-i"])
+        .arg("testsrc=size=160x120:rate=30:duration=1")
+        .args(["-pix_fmt", "yuv420p10le", "-color_primaries", "bt2020"])
+        .args(["-color_trc", "smpte2084", "-colorspace", "bt2020nc"])
+        .args(["-c:v", "ffv1"])
+        .arg(&hdr_video)
+        .status()
+        .unwrap();
+    assert!(status.success());
+
+    let sdr_video = create_test_video(&temp_dir, "sdr", 160, 120, 2, Container::WebM, Codec::Av1);
+
+    for (i, tone_map) in [ToneMap::Hable, ToneMap::Reinhard].into_iter().enumerate() {
+        let output_path = temp_dir.path().join(format!("output_{}.webm", i));
+
+        let options = EncodeOptions {
+            output_path: output_path.to_string_lossy().to_string(),
+            tone_map,
+            ..Default::default()
+        };
+
+        let hdr_path = hdr_video.to_string_lossy().to_string();
+        let result = juxtapose(&hdr_path, &sdr_video, &options, None);
+        assert!(
+            result.is_ok(),
+            "Juxtapose with {:?} tone mapping failed: {:?}",
+            tone_map,
+            result
+        );
+        assert!(verify_webm_header(&output_path));
+    }
+}
+
+/// Test stacking the videos and choosing the layout by their aspect ratios
+#[test]
+fn test_juxtapose_layout() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let landscape = create_test_video(
+        &temp_dir,
+        "landscape",
+        160,
+        120,
+        2,
+        Container::WebM,
+        Codec::Av1,
+    );
+    let portrait = create_test_video(
+        &temp_dir,
+        "portrait",
+        90,
+        160,
+        2,
+        Container::WebM,
+        Codec::Av1,
+    );
+
+    let cases = [
+        (&landscape, JuxtaposeLayout::Horizontal, (320, 120)),
+        (&landscape, JuxtaposeLayout::Vertical, (160, 240)),
+        (&landscape, JuxtaposeLayout::Auto, (160, 240)),
+        (&portrait, JuxtaposeLayout::Auto, (180, 160)),
+    ];
+    for (i, (video, layout, size)) in cases.into_iter().enumerate() {
+        let output_path = temp_dir.path().join(format!("output_{}.webm", i));
+        let options = EncodeOptions {
+            output_path: output_path.to_string_lossy().to_string(),
+            juxtapose_layout: layout,
+            ..Default::default()
+        };
+
+        let result = juxtapose(video, video, &options, None);
+        assert!(
+            result.is_ok(),
+            "Juxtapose {:?} failed: {:?}",
+            layout,
+            result
+        );
+        assert_eq!(probe_dimensions(&output_path), Some(size), "{:?}", layout);
+    }
+}
+
+/// Test juxtapose with different subtitles on each side
+#[test]
+fn test_juxtapose_subtitles() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+
+    let left_video = create_test_video(&temp_dir, "left", 160, 120, 2, Container::WebM, Codec::Av1);
+    let right_video =
+        create_test_video(&temp_dir, "right", 160, 120, 2, Container::WebM, Codec::Av1);
+
+    let left_subtitles = temp_dir.path().join("left.srt");
+    std::fs::write(
+        &left_subtitles,
+        "1\n00:00:00,000 --> 00:00:00,300\nhello world\n\n2\n00:00:00,300 --> 00:00:00,400\nbye\n",
+    )
+    .unwrap();
+    let right_subtitles = temp_dir.path().join("right.vtt");
+    std::fs::write(
+        &right_subtitles,
+        "WEBVTT\n\n00:00.000 --> 00:00.400\nhello word\n",
+    )
+    .unwrap();
+
+    let output_path = temp_dir.path().join("output.webm");
+
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        left_subtitles: Some(left_subtitles.to_string_lossy().to_string()),
+        right_subtitles: Some(right_subtitles.to_string_lossy().to_string()),
+        ..Default::default()
+    };
+
+    let result = juxtapose(&left_video, &right_video, &options, None);
+    assert!(
+        result.is_ok(),
+        "Juxtapose with subtitles failed: {:?}",
+        result
+    );
+    assert!(verify_webm_header(&output_path));
+
+    // Missing and malformed subtitle files are rejected
+    let missing = EncodeOptions {
+        left_subtitles: Some(
+            temp_dir
+                .path()
+                .join("missing.srt")
+                .to_string_lossy()
+                .to_string(),
+        ),
+        ..options.clone()
+    };
+    assert!(juxtapose(&left_video, &right_video, &missing, None).is_err());
+
+    let malformed_subtitles = temp_dir.path().join("malformed.srt");
+    std::fs::write(&malformed_subtitles, "1\n0:00 --> 0:01\nbad\n").unwrap();
+    let malformed = EncodeOptions {
+        right_subtitles: Some(malformed_subtitles.to_string_lossy().to_string()),
+        ..options
+    };
+    assert!(juxtapose(&left_video, &right_video, &malformed, None).is_err());
+}
+
 // ============================================================================
 // Different size composition tests (MP4 + H.264) - Platform specific
 // ============================================================================
@@ -252,6 +463,7 @@ fn test_juxtapose_different_size_mp4_h264_macos() {
         codec: Codec::H264,
         quality: 50,
         ffmpeg_path: None,
+        ..Default::default()
     };
 
     // Use a custom background color
@@ -295,6 +507,7 @@ fn test_juxtapose_different_size_mp4_h264_windows() {
         codec: Codec::H264,
         quality: 50,
         ffmpeg_path: None,
+        ..Default::default()
     };
 
     let bg = Color {
@@ -341,6 +554,7 @@ fn test_juxtapose_mixed_formats_to_webm_macos() {
         codec: Codec::Av1,
         quality: 50,
         ffmpeg_path: None,
+        ..Default::default()
     };
 
     let result = juxtapose(&left_video, &right_video, &options, None);
@@ -377,6 +591,7 @@ fn test_juxtapose_mixed_formats_to_mp4_macos() {
         codec: Codec::H264,
         quality: 50,
         ffmpeg_path: None,
+        ..Default::default()
     };
 
     let result = juxtapose(&left_video, &right_video, &options, None);
@@ -0,0 +1,70 @@
+//! Integration tests for videos read frame by frame
+
+mod common;
+
+use common::*;
+use minmpeg::{EncodeOptions, Error, VideoReader, VideoWriter};
+use tempfile::TempDir;
+
+/// Test reading back the frames of a written video
+#[test]
+fn test_reader_frames() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let video = temp_dir.path().join("video.webm");
+    let options = EncodeOptions {
+        output_path: video.to_string_lossy().to_string(),
+        fps: 10,
+        ..Default::default()
+    };
+    // Five dark frames, then five bright ones
+    let mut writer = VideoWriter::new(&options, 64, 48).unwrap();
+    for i in 0..10 {
+        let level = if i < 5 { 20 } else { 230 };
+        writer
+            .write_frame(&[level, level, level, 255].repeat(64 * 48))
+            .unwrap();
+    }
+    writer.finish().unwrap();
+
+    let mut reader = VideoReader::open(&video, 0.0, None).unwrap();
+    assert_eq!((reader.width(), reader.height()), (64, 48));
+    let mut frames = Vec::new();
+    while let Some(frame) = reader.next_frame().unwrap() {
+        assert_eq!(frame.data.len(), 64 * 48 * 4);
+        frames.push(frame);
+    }
+    assert_eq!(frames.len(), 10);
+    assert_eq!(frames[0].time_ms, 0);
+    assert_eq!(frames[5].time_ms, 500);
+    assert!(frames[0].data[0] < 60 && frames[9].data[0] > 190);
+    assert!(reader.next_frame().unwrap().is_none());
+
+    // Sampling at a lower rate
+    let mut reader = VideoReader::open(&video, 2.0, None).unwrap();
+    let mut count = 0;
+    while reader.next_frame().unwrap().is_some() {
+        count += 1;
+    }
+    assert_eq!(count, 2);
+}
+
+/// Test inputs a reader rejects
+#[test]
+fn test_reader_invalid() {
+    let temp_dir = TempDir::new().unwrap();
+    assert!(matches!(
+        VideoReader::open(temp_dir.path().join("missing.webm"), 0.0, None),
+        Err(Error::InvalidInput(_))
+    ));
+    let path = temp_dir.path().join("video.webm");
+    std::fs::write(&path, b"not a video").unwrap();
+    assert!(matches!(
+        VideoReader::open(&path, -1.0, None),
+        Err(Error::InvalidInput(_))
+    ));
+}
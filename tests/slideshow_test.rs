@@ -3,7 +3,19 @@
 mod common;
 
 use common::*;
-use minmpeg::{slideshow, Codec, Container, EncodeOptions, SlideEntry};
+use minmpeg::{
+    available, dedupe_slides, find_duplicate_slides, preview_slideshow, slideshow,
+    slideshow_from_iter, slideshow_skipping_invalid, slideshow_within_size, Anchor, Av1Backend,
+    BitDepth, Chapter, ChapterMode, Codec, ColorMatrix, ColorPrimaries, ColorRange, ColorTransfer,
+    Compression, Container, CropFocus, DuplicateMatch, DynamicRange, EncodeOptions, Error,
+    FallbackHandler, H264Preset, H264Profile, H264Tune, HlsSegment, HwAccel, HwFallback,
+    IdleFrames, LogEvent, LogHandler, LogLevel, LogValue, Logo, Mp4Layout, OutputCheck, OutputMode,
+    Passes, PixelFormat, PreviewLayout, PreviewOptions, Priority, Reproducibility, Session,
+    SlideEntry, SlideFit, TextAlign, TextFit, TimeRange, Transparency, Warning, WarningHandler,
+    WarningKind, MAX_FPS,
+};
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::{Arc, Mutex};
 use tempfile::TempDir;
 
 /// Test creating a slideshow with JPEG images
@@ -27,6 +39,7 @@ fn test_slideshow_jpeg_images() {
         .map(|path| SlideEntry {
             path: path.to_string_lossy().to_string(),
             duration_ms: 200, // Short duration for fast testing
+            ..Default::default()
         })
         .collect();
 
@@ -38,6 +51,7 @@ fn test_slideshow_jpeg_images() {
         codec: Codec::Av1,
         quality: 50,
         ffmpeg_path: None,
+        ..Default::default()
     };
 
     // Create slideshow
@@ -80,6 +94,7 @@ fn test_slideshow_png_images() {
         .map(|path| SlideEntry {
             path: path.to_string_lossy().to_string(),
             duration_ms: 200, // Short duration for fast testing
+            ..Default::default()
         })
         .collect();
 
@@ -91,6 +106,7 @@ fn test_slideshow_png_images() {
         codec: Codec::Av1,
         quality: 50,
         ffmpeg_path: None,
+        ..Default::default()
     };
 
     let result = slideshow(&entries, &options);
@@ -119,10 +135,12 @@ fn test_slideshow_mixed_formats() {
         SlideEntry {
             path: jpeg_path.to_string_lossy().to_string(),
             duration_ms: 200,
+            ..Default::default()
         },
         SlideEntry {
             path: png_path.to_string_lossy().to_string(),
             duration_ms: 200,
+            ..Default::default()
         },
     ];
 
@@ -134,6 +152,7 @@ fn test_slideshow_mixed_formats() {
         codec: Codec::Av1,
         quality: 50,
         ffmpeg_path: None,
+        ..Default::default()
     };
 
     let result = slideshow(&entries, &options);
@@ -165,6 +184,7 @@ fn test_slideshow_different_resolutions() {
         .map(|path| SlideEntry {
             path: path.to_string_lossy().to_string(),
             duration_ms: 200,
+            ..Default::default()
         })
         .collect();
 
@@ -176,6 +196,7 @@ fn test_slideshow_different_resolutions() {
         codec: Codec::Av1,
         quality: 50,
         ffmpeg_path: None,
+        ..Default::default()
     };
 
     let result = slideshow(&entries, &options);
@@ -184,6 +205,53 @@ fn test_slideshow_different_resolutions() {
     assert!(verify_file_exists_with_size(&output_path));
 }
 
+/// Test cover and contain fitting of slides with different aspect ratios
+#[test]
+fn test_slideshow_slide_fit() {
+    let temp_dir = TempDir::new().unwrap();
+
+    // Landscape output with a portrait and a panorama slide
+    let sizes = [(320, 240), (240, 320), (640, 160)];
+    let entries: Vec<SlideEntry> = sizes
+        .iter()
+        .enumerate()
+        .map(|(i, (w, h))| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&generate_numbered_image(*w, *h, i as u32), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 200,
+                ..Default::default()
+            }
+        })
+        .collect();
+
+    let fits = [
+        (SlideFit::Cover, CropFocus::Center),
+        (SlideFit::Cover, CropFocus::Salient),
+        (SlideFit::Contain, CropFocus::Center),
+    ];
+    for (i, (slide_fit, crop_focus)) in fits.into_iter().enumerate() {
+        let output_path = temp_dir.path().join(format!("output_{}.webm", i));
+        let options = EncodeOptions {
+            output_path: output_path.to_string_lossy().to_string(),
+            slide_fit,
+            crop_focus,
+            ..Default::default()
+        };
+
+        let result = slideshow(&entries, &options);
+        assert!(
+            result.is_ok(),
+            "Slideshow with {:?}/{:?} failed: {:?}",
+            slide_fit,
+            crop_focus,
+            result
+        );
+        assert!(verify_webm_header(&output_path));
+    }
+}
+
 /// Test slideshow with various durations
 #[test]
 fn test_slideshow_various_durations() {
@@ -206,6 +274,7 @@ fn test_slideshow_various_durations() {
         .map(|(path, duration)| SlideEntry {
             path: path.to_string_lossy().to_string(),
             duration_ms: *duration,
+            ..Default::default()
         })
         .collect();
 
@@ -217,6 +286,7 @@ fn test_slideshow_various_durations() {
         codec: Codec::Av1,
         quality: 50,
         ffmpeg_path: None,
+        ..Default::default()
     };
 
     let result = slideshow(&entries, &options);
@@ -237,6 +307,7 @@ fn test_slideshow_empty_entries() {
         codec: Codec::Av1,
         quality: 50,
         ffmpeg_path: None,
+        ..Default::default()
     };
 
     let result = slideshow(&[], &options);
@@ -252,6 +323,7 @@ fn test_slideshow_nonexistent_image() {
     let entries = vec![SlideEntry {
         path: "/nonexistent/path/image.jpg".to_string(),
         duration_ms: 1000,
+        ..Default::default()
     }];
 
     let options = EncodeOptions {
@@ -260,10 +332,60 @@ fn test_slideshow_nonexistent_image() {
         codec: Codec::Av1,
         quality: 50,
         ffmpeg_path: None,
+        ..Default::default()
     };
 
     let result = slideshow(&entries, &options);
     assert!(result.is_err(), "Non-existent image should fail");
+    let err = result.unwrap_err();
+    assert_eq!(err.slide(), Some((0, "/nonexistent/path/image.jpg")));
+}
+
+/// Test skipping slides whose image cannot be loaded
+#[test]
+fn test_slideshow_skipping_invalid() {
+    let temp_dir = TempDir::new().unwrap();
+    let output_path = temp_dir.path().join("output.webm");
+
+    let corrupt_path = temp_dir.path().join("corrupt.png");
+    std::fs::write(&corrupt_path, b"not an image").unwrap();
+    let mut entries: Vec<SlideEntry> = (0..2)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&generate_numbered_image(320, 240, i), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 300,
+                ..Default::default()
+            }
+        })
+        .collect();
+    entries.insert(
+        1,
+        SlideEntry {
+            path: corrupt_path.to_string_lossy().to_string(),
+            duration_ms: 300,
+            ..Default::default()
+        },
+    );
+
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::WebM,
+        codec: Codec::Av1,
+        ..Default::default()
+    };
+
+    // Without skipping the corrupt slide fails the video
+    assert!(slideshow(&entries, &options).is_err());
+
+    let skipped = slideshow_skipping_invalid(&entries, &options).unwrap();
+    assert_eq!(skipped.len(), 1);
+    assert_eq!(skipped[0].index, 1);
+    assert!(verify_webm_header(&output_path));
+
+    // Fails when no slide can be used
+    assert!(slideshow_skipping_invalid(&entries[1..2], &options).is_err());
 }
 
 /// Test slideshow with different quality settings
@@ -279,6 +401,7 @@ fn test_slideshow_quality_settings() {
     let entries = vec![SlideEntry {
         path: path.to_string_lossy().to_string(),
         duration_ms: 500,
+        ..Default::default()
     }];
 
     // Test different quality levels
@@ -291,6 +414,7 @@ fn test_slideshow_quality_settings() {
             codec: Codec::Av1,
             quality,
             ffmpeg_path: None,
+            ..Default::default()
         };
 
         let result = slideshow(&entries, &options);
@@ -316,6 +440,7 @@ fn test_slideshow_container_codec_mismatch() {
     let entries = vec![SlideEntry {
         path: path.to_string_lossy().to_string(),
         duration_ms: 500,
+        ..Default::default()
     }];
 
     let output_path = temp_dir.path().join("output.webm");
@@ -327,6 +452,7 @@ fn test_slideshow_container_codec_mismatch() {
         codec: Codec::H264,
         quality: 50,
         ffmpeg_path: None,
+        ..Default::default()
     };
 
     let result = slideshow(&entries, &options);
@@ -346,6 +472,7 @@ fn test_slideshow_large_resolution() {
     let entries = vec![SlideEntry {
         path: path.to_string_lossy().to_string(),
         duration_ms: 200,
+        ..Default::default()
     }];
 
     let output_path = temp_dir.path().join("output.webm");
@@ -356,6 +483,7 @@ fn test_slideshow_large_resolution() {
         codec: Codec::Av1,
         quality: 30, // Lower quality for faster encoding
         ffmpeg_path: None,
+        ..Default::default()
     };
 
     let result = slideshow(&entries, &options);
@@ -380,6 +508,7 @@ fn test_slideshow_small_resolution() {
     let entries = vec![SlideEntry {
         path: path.to_string_lossy().to_string(),
         duration_ms: 500,
+        ..Default::default()
     }];
 
     let output_path = temp_dir.path().join("output.webm");
@@ -390,6 +519,7 @@ fn test_slideshow_small_resolution() {
         codec: Codec::Av1,
         quality: 50,
         ffmpeg_path: None,
+        ..Default::default()
     };
 
     let result = slideshow(&entries, &options);
@@ -401,55 +531,41 @@ fn test_slideshow_small_resolution() {
     assert!(verify_file_exists_with_size(&output_path));
 }
 
-// ============================================================================
-// Container/Codec combination tests
-// Supported combinations:
-// - WebM + AV1 (all platforms, using rav1e)
-// - MP4 + H.264 (macOS: VideoToolbox, Windows: MediaFoundation, Linux: ffmpeg)
-// ============================================================================
-
-/// Test MP4 container with H.264 codec (platform-specific)
+/// Test slideshow with captions
 #[test]
-#[cfg(target_os = "macos")]
-fn test_slideshow_mp4_h264_macos() {
+fn test_slideshow_with_captions() {
     let temp_dir = TempDir::new().unwrap();
 
-    let path = temp_dir.path().join("slide.png");
-    let img = generate_numbered_image(320, 240, 0);
-    save_png(&img, &path).unwrap();
-
-    let entries = vec![SlideEntry {
-        path: path.to_string_lossy().to_string(),
-        duration_ms: 500,
-    }];
+    let entries: Vec<SlideEntry> = (0..2)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            let img = generate_numbered_image(320, 240, i);
+            save_png(&img, &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 200,
+                caption: Some(format!("Slide {}\nsecond line", i + 1)),
+                ..Default::default()
+            }
+        })
+        .collect();
 
-    let output_path = temp_dir.path().join("output.mp4");
+    let output_path = temp_dir.path().join("output.webm");
 
     let options = EncodeOptions {
         output_path: output_path.to_string_lossy().to_string(),
-        container: Container::Mp4,
-        codec: Codec::H264,
-        quality: 50,
-        ffmpeg_path: None,
+        font: Some(minmpeg::font::DEFAULT_FONT_NAME.to_string()),
+        ..Default::default()
     };
 
     let result = slideshow(&entries, &options);
-    assert!(
-        result.is_ok(),
-        "MP4+H.264 slideshow failed on macOS: {:?}",
-        result
-    );
-    assert!(verify_file_exists_with_size(&output_path));
-    assert!(
-        verify_mp4_header(&output_path),
-        "Output file is not a valid MP4"
-    );
+    assert!(result.is_ok(), "Captioned slideshow failed: {:?}", result);
+    assert!(verify_webm_header(&output_path));
 }
 
-/// Test MP4 container with H.264 codec on Windows
+/// Test slideshow with an unknown caption font (should fail)
 #[test]
-#[cfg(target_os = "windows")]
-fn test_slideshow_mp4_h264_windows() {
+fn test_slideshow_unknown_font() {
     let temp_dir = TempDir::new().unwrap();
 
     let path = temp_dir.path().join("slide.png");
@@ -458,44 +574,28 @@ fn test_slideshow_mp4_h264_windows() {
 
     let entries = vec![SlideEntry {
         path: path.to_string_lossy().to_string(),
-        duration_ms: 500,
+        duration_ms: 200,
+        caption: Some("Hello".to_string()),
+        ..Default::default()
     }];
 
-    let output_path = temp_dir.path().join("output.mp4");
-
     let options = EncodeOptions {
-        output_path: output_path.to_string_lossy().to_string(),
-        container: Container::Mp4,
-        codec: Codec::H264,
-        quality: 50,
-        ffmpeg_path: None,
+        output_path: temp_dir
+            .path()
+            .join("output.webm")
+            .to_string_lossy()
+            .to_string(),
+        font: Some("No Such Font 12345".to_string()),
+        ..Default::default()
     };
 
     let result = slideshow(&entries, &options);
-    assert!(
-        result.is_ok(),
-        "MP4+H.264 slideshow failed on Windows: {:?}",
-        result
-    );
-    assert!(verify_file_exists_with_size(&output_path));
-    assert!(
-        verify_mp4_header(&output_path),
-        "Output file is not a valid MP4"
-    );
+    assert!(result.is_err(), "Unknown font should fail");
 }
 
-/// Test MP4 container with H.264 codec on Linux (requires ffmpeg)
+/// Test slideshow with shrink-to-fit, aligned captions
 #[test]
-#[cfg(target_os = "linux")]
-fn test_slideshow_mp4_h264_linux() {
-    use minmpeg::available;
-
-    // First check if H.264 is available (requires ffmpeg)
-    if available(Codec::H264, None).is_err() {
-        println!("Skipping MP4+H.264 test on Linux: ffmpeg not available");
-        return;
-    }
-
+fn test_slideshow_caption_layout() {
     let temp_dir = TempDir::new().unwrap();
 
     let path = temp_dir.path().join("slide.png");
@@ -504,52 +604,47 @@ fn test_slideshow_mp4_h264_linux() {
 
     let entries = vec![SlideEntry {
         path: path.to_string_lossy().to_string(),
-        duration_ms: 500,
+        duration_ms: 200,
+        caption: Some("A caption that is much too long for one line\nShort".to_string()),
+        ..Default::default()
     }];
 
-    let output_path = temp_dir.path().join("output.mp4");
+    let output_path = temp_dir.path().join("output.webm");
 
     let options = EncodeOptions {
         output_path: output_path.to_string_lossy().to_string(),
-        container: Container::Mp4,
-        codec: Codec::H264,
-        quality: 50,
-        ffmpeg_path: None,
+        caption_align: TextAlign::Start,
+        caption_fit: TextFit::Shrink,
+        caption_max_width: 80,
+        caption_anchor: Anchor::TopLeft,
+        safe_area: 10,
+        ..Default::default()
     };
 
     let result = slideshow(&entries, &options);
     assert!(
         result.is_ok(),
-        "MP4+H.264 slideshow failed on Linux: {:?}",
+        "Caption layout slideshow failed: {:?}",
         result
     );
-    assert!(verify_file_exists_with_size(&output_path));
-    assert!(
-        verify_mp4_header(&output_path),
-        "Output file is not a valid MP4"
-    );
+    assert!(verify_webm_header(&output_path));
 }
 
-/// Test WebM container with AV1 codec (multiple slides to ensure encoding works)
+/// Test slideshow with slide numbers
 #[test]
-fn test_slideshow_webm_av1_multiple() {
+fn test_slideshow_slide_numbers() {
     let temp_dir = TempDir::new().unwrap();
 
-    // Create 5 slides with different colors
-    let image_paths: Vec<_> = (0..5)
+    let entries: Vec<SlideEntry> = (0..3)
         .map(|i| {
             let path = temp_dir.path().join(format!("slide_{}.png", i));
             let img = generate_numbered_image(320, 240, i);
             save_png(&img, &path).unwrap();
-            path
-        })
-        .collect();
-
-    let entries: Vec<SlideEntry> = image_paths
-        .iter()
-        .map(|path| SlideEntry {
-            path: path.to_string_lossy().to_string(),
-            duration_ms: 200,
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 200,
+                ..Default::default()
+            }
         })
         .collect();
 
@@ -557,123 +652,2587 @@ fn test_slideshow_webm_av1_multiple() {
 
     let options = EncodeOptions {
         output_path: output_path.to_string_lossy().to_string(),
-        container: Container::WebM,
-        codec: Codec::Av1,
-        quality: 50,
-        ffmpeg_path: None,
+        slide_number: Some("Slide {n} of {total}".to_string()),
+        slide_number_anchor: Anchor::BottomRight,
+        ..Default::default()
     };
 
     let result = slideshow(&entries, &options);
     assert!(
         result.is_ok(),
-        "WebM+AV1 multiple slides failed: {:?}",
+        "Slide number slideshow failed: {:?}",
         result
     );
-    assert!(verify_file_exists_with_size(&output_path));
     assert!(verify_webm_header(&output_path));
-
-    // Verify file has reasonable size for 5 slides
-    let size = get_file_size(&output_path).unwrap();
-    assert!(
-        size > 2000,
-        "Output file too small for 5 slides: {} bytes",
-        size
-    );
 }
 
-/// Test MP4 container with H.264 codec (multiple slides, macOS)
+/// Test slideshow with a logo that fades in and out
 #[test]
-#[cfg(target_os = "macos")]
-fn test_slideshow_mp4_h264_multiple_macos() {
+fn test_slideshow_logo() {
     let temp_dir = TempDir::new().unwrap();
 
-    // Create 5 slides with different colors
-    let image_paths: Vec<_> = (0..5)
+    let entries: Vec<SlideEntry> = (0..2)
         .map(|i| {
             let path = temp_dir.path().join(format!("slide_{}.png", i));
             let img = generate_numbered_image(320, 240, i);
             save_png(&img, &path).unwrap();
-            path
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 500,
+                ..Default::default()
+            }
         })
         .collect();
 
-    let entries: Vec<SlideEntry> = image_paths
+    let logo_path = temp_dir.path().join("logo.png");
+    let logo = generate_test_image(64, 32, [255, 255, 0, 255]);
+    save_png(&logo, &logo_path).unwrap();
+
+    let output_path = temp_dir.path().join("output.webm");
+
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        logo: Some(Logo {
+            path: logo_path.to_string_lossy().to_string(),
+            anchor: Anchor::BottomRight,
+            width: 20,
+            fade_ms: 200,
+            ranges: vec![TimeRange {
+                start_ms: 200,
+                end_ms: -200,
+            }],
+        }),
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "Logo slideshow failed: {:?}", result);
+    assert!(verify_webm_header(&output_path));
+}
+
+/// Test slideshow with a missing logo file (should fail)
+#[test]
+fn test_slideshow_logo_missing_file() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    let img = generate_numbered_image(320, 240, 0);
+    save_png(&img, &path).unwrap();
+
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 200,
+        ..Default::default()
+    }];
+
+    let options = EncodeOptions {
+        output_path: temp_dir
+            .path()
+            .join("output.webm")
+            .to_string_lossy()
+            .to_string(),
+        logo: Some(Logo {
+            path: temp_dir
+                .path()
+                .join("missing.png")
+                .to_string_lossy()
+                .to_string(),
+            ..Default::default()
+        }),
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_err(), "Missing logo should fail");
+}
+
+/// Test slideshow with auto-enhanced slides
+#[test]
+fn test_slideshow_auto_enhance() {
+    let temp_dir = TempDir::new().unwrap();
+
+    // A dull, warm-tinted photo and a normal slide
+    let colors = [[120, 90, 70, 255], [60, 120, 200, 255]];
+    let entries: Vec<SlideEntry> = colors
         .iter()
-        .map(|path| SlideEntry {
-            path: path.to_string_lossy().to_string(),
-            duration_ms: 200,
+        .enumerate()
+        .map(|(i, color)| {
+            let path = temp_dir.path().join(format!("photo_{}.png", i));
+            save_png(&generate_test_image(320, 240, *color), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 200,
+                ..Default::default()
+            }
         })
         .collect();
 
-    let output_path = temp_dir.path().join("output.mp4");
-
+    let output_path = temp_dir.path().join("output.webm");
     let options = EncodeOptions {
         output_path: output_path.to_string_lossy().to_string(),
-        container: Container::Mp4,
-        codec: Codec::H264,
-        quality: 50,
-        ffmpeg_path: None,
+        enhance: 80,
+        ..Default::default()
     };
 
     let result = slideshow(&entries, &options);
     assert!(
         result.is_ok(),
-        "MP4+H.264 multiple slides failed on macOS: {:?}",
+        "Auto-enhanced slideshow failed: {:?}",
         result
     );
-    assert!(verify_file_exists_with_size(&output_path));
-    assert!(verify_mp4_header(&output_path));
+    assert!(verify_webm_header(&output_path));
 
-    // Verify file has reasonable size for 5 slides
-    let size = get_file_size(&output_path).unwrap();
+    let options = EncodeOptions {
+        enhance: 150,
+        ..options
+    };
     assert!(
-        size > 2000,
-        "Output file too small for 5 slides: {} bytes",
-        size
+        slideshow(&entries, &options).is_err(),
+        "Enhance strength over 100 should fail"
     );
 }
 
-/// Test MP4 container with H.264 codec (multiple slides, Windows)
+/// Test merging idle frames of static slides
 #[test]
-#[cfg(target_os = "windows")]
-fn test_slideshow_mp4_h264_multiple_windows() {
+fn test_slideshow_idle_frames() {
     let temp_dir = TempDir::new().unwrap();
 
-    // Create 5 slides with different colors
-    let image_paths: Vec<_> = (0..5)
-        .map(|i| {
-            let path = temp_dir.path().join(format!("slide_{}.png", i));
-            let img = generate_numbered_image(320, 240, i);
-            save_png(&img, &path).unwrap();
-            path
-        })
-        .collect();
-
-    let entries: Vec<SlideEntry> = image_paths
+    let colors = [[255, 0, 0, 255], [0, 0, 255, 255]];
+    let entries: Vec<SlideEntry> = colors
         .iter()
-        .map(|path| SlideEntry {
-            path: path.to_string_lossy().to_string(),
-            duration_ms: 200,
+        .enumerate()
+        .map(|(i, color)| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&generate_test_image(320, 240, *color), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 1000,
+                ..Default::default()
+            }
         })
         .collect();
 
-    let output_path = temp_dir.path().join("output.mp4");
-
+    let kept_path = temp_dir.path().join("kept.webm");
     let options = EncodeOptions {
-        output_path: output_path.to_string_lossy().to_string(),
-        container: Container::Mp4,
-        codec: Codec::H264,
-        quality: 50,
-        ffmpeg_path: None,
+        output_path: kept_path.to_string_lossy().to_string(),
+        ..Default::default()
     };
+    slideshow(&entries, &options).unwrap();
 
+    let merged_path = temp_dir.path().join("merged.webm");
+    let options = EncodeOptions {
+        output_path: merged_path.to_string_lossy().to_string(),
+        idle_frames: IdleFrames::Merge,
+        ..Default::default()
+    };
     let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "Idle-frame slideshow failed: {:?}", result);
+    assert!(verify_webm_header(&merged_path));
+
+    let kept_size = std::fs::metadata(&kept_path).unwrap().len();
+    let merged_size = std::fs::metadata(&merged_path).unwrap().len();
     assert!(
-        result.is_ok(),
-        "MP4+H.264 multiple slides failed on Windows: {:?}",
-        result
+        merged_size < kept_size,
+        "Merged output ({} bytes) should be smaller than {} bytes",
+        merged_size,
+        kept_size
     );
-    assert!(verify_file_exists_with_size(&output_path));
+}
+
+/// Test slideshow with a time budget too short for the default preset
+#[test]
+fn test_slideshow_time_budget() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    let img = generate_numbered_image(1280, 720, 0);
+    save_png(&img, &path).unwrap();
+
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 2000,
+        ..Default::default()
+    }];
+
+    let output_path = temp_dir.path().join("output.webm");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        time_budget_ms: 1000,
+        ..Default::default()
+    };
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "Time-budget slideshow failed: {:?}", result);
+    assert!(verify_webm_header(&output_path));
+}
+
+/// Test lossless slideshows and codecs without a lossless mode
+#[test]
+fn test_slideshow_lossless() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    save_png(&generate_numbered_image(320, 240, 0), &path).unwrap();
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 300,
+        ..Default::default()
+    }];
+
+    let lossy_path = temp_dir.path().join("lossy.webm");
+    let options = EncodeOptions {
+        output_path: lossy_path.to_string_lossy().to_string(),
+        ..Default::default()
+    };
+    slideshow(&entries, &options).unwrap();
+
+    let lossless_path = temp_dir.path().join("lossless.webm");
+    let options = EncodeOptions {
+        output_path: lossless_path.to_string_lossy().to_string(),
+        compression: Compression::Lossless,
+        ..Default::default()
+    };
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "Lossless slideshow failed: {:?}", result);
+    assert!(verify_webm_header(&lossless_path));
+
+    let lossy_size = std::fs::metadata(&lossy_path).unwrap().len();
+    let lossless_size = std::fs::metadata(&lossless_path).unwrap().len();
+    assert!(
+        lossless_size > lossy_size,
+        "Lossless output ({} bytes) should be larger than {} bytes",
+        lossless_size,
+        lossy_size
+    );
+
+    // Codecs without a lossless mode are rejected
+    let options = EncodeOptions {
+        container: Container::WebM,
+        codec: Codec::Vp8,
+        ..options
+    };
+    assert!(slideshow(&entries, &options).is_err());
+}
+
+/// Test bitrate limits and invalid combinations
+#[test]
+fn test_slideshow_bitrate() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    save_png(&generate_numbered_image(320, 240, 0), &path).unwrap();
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 300,
+        ..Default::default()
+    }];
+
+    let output_path = temp_dir.path().join("output.webm");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        bitrate_kbps: 200,
+        max_bitrate_kbps: 400,
+        ..Default::default()
+    };
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "Bitrate slideshow failed: {:?}", result);
+    assert!(verify_webm_header(&output_path));
+
+    // The average must not exceed the peak
+    let invalid = EncodeOptions {
+        bitrate_kbps: 500,
+        ..options.clone()
+    };
+    assert!(slideshow(&entries, &invalid).is_err());
+
+    // A buffer size needs a peak
+    let invalid = EncodeOptions {
+        max_bitrate_kbps: 0,
+        buffer_size_kbits: 800,
+        ..options.clone()
+    };
+    assert!(slideshow(&entries, &invalid).is_err());
+
+    // Lossless output has no bitrate
+    let invalid = EncodeOptions {
+        compression: Compression::Lossless,
+        ..options
+    };
+    assert!(slideshow(&entries, &invalid).is_err());
+}
+
+/// Test falling back to the software encoder when a hardware encoder is
+/// missing
+#[test]
+fn test_slideshow_hw_fallback() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    save_png(&generate_test_image(320, 240, [200, 80, 40, 255]), &path).unwrap();
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 500,
+        ..Default::default()
+    }];
+
+    for codec in [Codec::H264, Codec::Hevc] {
+        if available(codec, None).is_err() {
+            println!("Skipping {:?}: codec not available", codec);
+            continue;
+        }
+        let fallbacks = Arc::new(AtomicUsize::new(0));
+        let counter = fallbacks.clone();
+        let output_path = temp_dir.path().join(format!("{:?}.mp4", codec));
+        let options = EncodeOptions {
+            output_path: output_path.to_string_lossy().to_string(),
+            container: Container::Mp4,
+            codec,
+            hw_accel: HwAccel::Amf,
+            hw_fallback: HwFallback::Software,
+            on_fallback: Some(FallbackHandler::new(move |_| {
+                counter.fetch_add(1, Ordering::SeqCst);
+            })),
+            ..Default::default()
+        };
+        let result = slideshow(&entries, &options);
+        assert!(result.is_ok(), "Fallback {:?} failed: {:?}", codec, result);
+        assert!(verify_mp4_header(&output_path));
+
+        // Without the fallback, the encode fails where AMF is missing
+        let options = EncodeOptions {
+            hw_fallback: HwFallback::Fail,
+            on_fallback: None,
+            ..options
+        };
+        let fell_back = fallbacks.load(Ordering::SeqCst) == 1;
+        assert_eq!(slideshow(&entries, &options).is_err(), fell_back);
+    }
+}
+
+/// Test checking the output against the job
+#[test]
+fn test_slideshow_output_check() {
+    if !ffmpeg_available() || available(Codec::Av1, None).is_err() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    save_png(&generate_test_image(320, 240, [40, 80, 200, 255]), &path).unwrap();
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 1000,
+        ..Default::default()
+    }];
+
+    let output_path = temp_dir.path().join("output.webm");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        output_check: OutputCheck::Probe,
+        ..Default::default()
+    };
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "Checked slideshow failed: {:?}", result);
+
+    // Segmented outputs have no single file to probe
+    let options = EncodeOptions {
+        output_mode: OutputMode::Segments,
+        ..options
+    };
+    assert!(slideshow(&entries, &options).is_err());
+}
+
+/// Test limiting the encoder to one thread
+#[test]
+fn test_slideshow_threads() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    save_png(&generate_test_image(320, 240, [80, 160, 40, 255]), &path).unwrap();
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 500,
+        ..Default::default()
+    }];
+
+    for (container, codec, name) in [
+        (Container::WebM, Codec::Av1, "av1.webm"),
+        (Container::WebM, Codec::Vp9, "vp9.webm"),
+        (Container::Mp4, Codec::Hevc, "hevc.mp4"),
+    ] {
+        if available(codec, None).is_err() {
+            println!("Skipping {:?}: codec not available", codec);
+            continue;
+        }
+        let output_path = temp_dir.path().join(name);
+        let options = EncodeOptions {
+            output_path: output_path.to_string_lossy().to_string(),
+            container,
+            codec,
+            threads: 1,
+            ..Default::default()
+        };
+        let result = slideshow(&entries, &options);
+        assert!(
+            result.is_ok(),
+            "{:?} with one thread failed: {:?}",
+            codec,
+            result
+        );
+        assert!(verify_file_exists_with_size(&output_path));
+    }
+}
+
+/// Test the AV1 backends with their presets, and presets they lack
+#[test]
+fn test_slideshow_av1_backend() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    save_png(&generate_test_image(320, 240, [120, 40, 160, 255]), &path).unwrap();
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 500,
+        ..Default::default()
+    }];
+
+    for (backend, preset) in [
+        (Av1Backend::Rav1e, 10),
+        (Av1Backend::SvtAv1, 12),
+        (Av1Backend::Aom, 8),
+    ] {
+        let output_path = temp_dir.path().join(format!("{:?}.webm", backend));
+        let options = EncodeOptions {
+            output_path: output_path.to_string_lossy().to_string(),
+            av1_backend: backend,
+            av1_preset: preset,
+            ..Default::default()
+        };
+        match slideshow(&entries, &options) {
+            Ok(_) => assert!(verify_webm_header(&output_path)),
+            Err(e @ (Error::CodecUnavailable(_) | Error::Ffmpeg(_))) => {
+                println!("Skipping {:?}: {}", backend, e);
+            }
+            Err(e) => panic!("{:?} failed: {:?}", backend, e),
+        }
+    }
+
+    let output_path = temp_dir.path().join("invalid.webm");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        av1_backend: Av1Backend::Aom,
+        av1_preset: 9,
+        ..Default::default()
+    };
+    assert!(slideshow(&entries, &options).is_err());
+    let invalid = EncodeOptions {
+        av1_backend: Av1Backend::SvtAv1,
+        av1_preset: 0,
+        compression: Compression::Lossless,
+        ..options.clone()
+    };
+    assert!(slideshow(&entries, &invalid).is_err());
+    let invalid = EncodeOptions {
+        codec: Codec::Vp9,
+        av1_preset: 4,
+        ..options
+    };
+    assert!(slideshow(&entries, &invalid).is_err());
+}
+
+/// Test extra ffmpeg arguments of the encoder
+#[test]
+fn test_slideshow_ffmpeg_args() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    save_png(&generate_test_image(320, 240, [60, 60, 180, 255]), &path).unwrap();
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 500,
+        ..Default::default()
+    }];
+
+    // rav1e does not run in ffmpeg
+    let output_path = temp_dir.path().join("output.webm");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        ffmpeg_args: vec!["-lag-in-frames".to_string(), "0".to_string()],
+        ..Default::default()
+    };
+    assert!(slideshow(&entries, &options).is_err());
+
+    if available(Codec::Vp9, None).is_err() {
+        println!("Skipping test: VP9 not available");
+        return;
+    }
+    let options = EncodeOptions {
+        codec: Codec::Vp9,
+        ..options
+    };
+    let result = slideshow(&entries, &options);
+    assert!(
+        result.is_ok(),
+        "VP9 with ffmpeg arguments failed: {:?}",
+        result
+    );
+    assert!(verify_webm_header(&output_path));
+
+    // ffmpeg rejects an unknown option
+    let options = EncodeOptions {
+        ffmpeg_args: vec!["-no-such-option".to_string(), "1".to_string()],
+        ..options
+    };
+    assert!(slideshow(&entries, &options).is_err());
+}
+
+/// Test H.264 profiles and levels, the libx264 preset and tune, and
+/// options H.264 does not take
+#[test]
+fn test_slideshow_h264_profile() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    save_png(&generate_test_image(320, 240, [40, 160, 120, 255]), &path).unwrap();
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 500,
+        ..Default::default()
+    }];
+
+    let output_path = temp_dir.path().join("output.mp4");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::Mp4,
+        codec: Codec::H264,
+        h264_profile: H264Profile::Baseline,
+        h264_level: 31,
+        ..Default::default()
+    };
+
+    // Not a level, and options of other codecs
+    let invalid = EncodeOptions {
+        h264_level: 35,
+        ..options.clone()
+    };
+    assert!(matches!(
+        slideshow(&entries, &invalid),
+        Err(Error::InvalidInput(_))
+    ));
+    let invalid = EncodeOptions {
+        bit_depth: BitDepth::Ten,
+        ..options.clone()
+    };
+    assert!(matches!(
+        slideshow(&entries, &invalid),
+        Err(Error::InvalidInput(_))
+    ));
+    let invalid = EncodeOptions {
+        h264_tune: H264Tune::StillImage,
+        hw_accel: HwAccel::Nvenc,
+        ..options.clone()
+    };
+    assert!(matches!(
+        slideshow(&entries, &invalid),
+        Err(Error::InvalidInput(_))
+    ));
+    let invalid = EncodeOptions {
+        output_path: temp_dir
+            .path()
+            .join("output.webm")
+            .to_string_lossy()
+            .to_string(),
+        container: Container::WebM,
+        codec: Codec::Vp9,
+        h264_profile: H264Profile::Auto,
+        h264_level: 0,
+        h264_tune: H264Tune::StillImage,
+        ..options.clone()
+    };
+    assert!(matches!(
+        slideshow(&entries, &invalid),
+        Err(Error::InvalidInput(_))
+    ));
+
+    if available(Codec::H264, None).is_err() {
+        println!("Skipping H.264 profiles: H.264 encoder not available");
+        return;
+    }
+    slideshow(&entries, &options).unwrap();
+    assert!(verify_mp4_header(&output_path));
+
+    let tuned = EncodeOptions {
+        h264_profile: H264Profile::High,
+        h264_level: 40,
+        h264_preset: H264Preset::Slow,
+        h264_tune: H264Tune::StillImage,
+        ..options
+    };
+    match slideshow(&entries, &tuned) {
+        Ok(_) => assert!(verify_mp4_header(&output_path)),
+        // Only libx264 on Linux takes presets and tunes
+        Err(Error::CodecUnavailable(e)) if !cfg!(target_os = "linux") => {
+            println!("Skipping tuning: {}", e);
+        }
+        Err(e) => panic!("Tuned H.264 failed: {:?}", e),
+    }
+}
+
+/// Test slides taken lazily from an iterator, and options that need the
+/// slides up front
+#[test]
+fn test_slideshow_from_iter() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let mut paths = Vec::new();
+    for (i, color) in [[200, 60, 60, 255], [60, 200, 60, 255], [60, 60, 200, 255]]
+        .into_iter()
+        .enumerate()
+    {
+        let path = temp_dir.path().join(format!("slide{}.png", i));
+        save_png(&generate_test_image(320, 240, color), &path).unwrap();
+        paths.push(path.to_string_lossy().to_string());
+    }
+    let slides = || {
+        paths.iter().map(|path| {
+            Ok(SlideEntry {
+                path: path.clone(),
+                duration_ms: 300,
+                ..Default::default()
+            })
+        })
+    };
+
+    let output_path = temp_dir.path().join("output.webm");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        ..Default::default()
+    };
+    slideshow_from_iter(slides(), &options).unwrap();
+    assert!(verify_webm_header(&output_path));
+
+    // The error of the source ends the slideshow
+    let failing = slides().take(1).chain(std::iter::once(Err(Error::Decode(
+        "source failed".to_string(),
+    ))));
+    assert!(matches!(
+        slideshow_from_iter(failing, &options),
+        Err(Error::Decode(_))
+    ));
+    assert!(matches!(
+        slideshow_from_iter(std::iter::empty(), &options),
+        Err(Error::InvalidInput(_))
+    ));
+
+    // The total number of slides is not known up front
+    let invalid = EncodeOptions {
+        slide_number: Some(String::new()),
+        ..options.clone()
+    };
+    assert!(matches!(
+        slideshow_from_iter(slides(), &invalid),
+        Err(Error::InvalidInput(_))
+    ));
+    let invalid = EncodeOptions {
+        time_budget_ms: 10_000,
+        ..options
+    };
+    assert!(matches!(
+        slideshow_from_iter(slides(), &invalid),
+        Err(Error::InvalidInput(_))
+    ));
+}
+
+/// Test that a memory budget, which loads slides again when encoding them,
+/// renders the same video
+#[test]
+fn test_slideshow_memory_budget() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let mut entries = Vec::new();
+    for (i, (width, height)) in [(320, 240), (200, 300), (320, 240)].into_iter().enumerate() {
+        let path = temp_dir.path().join(format!("slide{}.png", i));
+        let color = [60 * i as u8, 120, 200, 255];
+        save_png(&generate_test_image(width, height, color), &path).unwrap();
+        entries.push(SlideEntry {
+            path: path.to_string_lossy().to_string(),
+            duration_ms: 300,
+            ..Default::default()
+        });
+    }
+
+    let output_path = temp_dir.path().join("output.webm");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        slide_number: Some(String::new()),
+        threads: 1,
+        ..Default::default()
+    };
+    slideshow(&entries, &options).unwrap();
+    let expected = std::fs::read(&output_path).unwrap();
+
+    let budget_path = temp_dir.path().join("budget.webm");
+    let budget = EncodeOptions {
+        output_path: budget_path.to_string_lossy().to_string(),
+        memory_budget_mb: 512,
+        ..options
+    };
+    slideshow(&entries, &budget).unwrap();
+    assert_eq!(std::fs::read(&budget_path).unwrap(), expected);
+}
+
+/// Test that jobs of a session, taking its spare encoders, render the same
+/// videos as jobs without one
+#[test]
+fn test_slideshow_session() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    save_png(&generate_test_image(160, 120, [200, 80, 40, 255]), &path).unwrap();
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 300,
+        ..Default::default()
+    }];
+
+    let output_path = temp_dir.path().join("expected.webm");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        threads: 1,
+        ..Default::default()
+    };
+    slideshow(&entries, &options).unwrap();
+    let expected = std::fs::read(&output_path).unwrap();
+
+    let session = Session::new();
+    for i in 0..3 {
+        let output_path = temp_dir.path().join(format!("output{}.webm", i));
+        let options = EncodeOptions {
+            output_path: output_path.to_string_lossy().to_string(),
+            session: Some(session.clone()),
+            ..options.clone()
+        };
+        slideshow(&entries, &options).unwrap();
+        assert_eq!(std::fs::read(&output_path).unwrap(), expected);
+    }
+    session.clear();
+}
+
+/// Test that a lowered priority renders the same video, and is rejected by
+/// streaming slideshows
+#[test]
+fn test_slideshow_priority() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    save_png(&generate_test_image(160, 120, [80, 160, 40, 255]), &path).unwrap();
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 300,
+        ..Default::default()
+    }];
+
+    let output_path = temp_dir.path().join("expected.webm");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        threads: 1,
+        ..Default::default()
+    };
+    slideshow(&entries, &options).unwrap();
+    let expected = std::fs::read(&output_path).unwrap();
+
+    for priority in [Priority::Low, Priority::Idle] {
+        let output_path = temp_dir.path().join(format!("{:?}.webm", priority));
+        let options = EncodeOptions {
+            output_path: output_path.to_string_lossy().to_string(),
+            priority,
+            ..options.clone()
+        };
+        slideshow(&entries, &options).unwrap();
+        assert_eq!(std::fs::read(&output_path).unwrap(), expected);
+
+        let slides = entries.iter().cloned().map(Ok);
+        assert!(matches!(
+            slideshow_from_iter(slides, &options),
+            Err(Error::InvalidInput(_))
+        ));
+    }
+}
+
+/// Test temporary files written to a temporary directory
+#[test]
+fn test_slideshow_temp_dir() {
+    use minmpeg::available;
+
+    let temp_dir = TempDir::new().unwrap();
+    let scratch = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    save_png(&generate_test_image(160, 120, [40, 80, 160, 255]), &path).unwrap();
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 300,
+        ..Default::default()
+    }];
+
+    let output_path = temp_dir.path().join("output.webm");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        temp_dir: Some(
+            temp_dir
+                .path()
+                .join("missing")
+                .to_string_lossy()
+                .to_string(),
+        ),
+        ..Default::default()
+    };
+    assert!(matches!(
+        slideshow(&entries, &options),
+        Err(Error::InvalidInput(_))
+    ));
+
+    let options = EncodeOptions {
+        temp_dir: Some(scratch.path().to_string_lossy().to_string()),
+        ..options
+    };
+    slideshow(&entries, &options).unwrap();
+    assert!(verify_webm_header(&output_path));
+
+    if available(Codec::Vp9, None).is_err() {
+        println!("Skipping two-pass temp dir test: ffmpeg with libvpx-vp9 not available");
+        return;
+    }
+    let options = EncodeOptions {
+        codec: Codec::Vp9,
+        passes: Passes::Two,
+        ..options
+    };
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "Two-pass slideshow failed: {:?}", result);
+
+    // The statistics were written to the scratch directory and removed
+    assert_eq!(std::fs::read_dir(scratch.path()).unwrap().count(), 0);
+    let files: Vec<_> = std::fs::read_dir(temp_dir.path())
+        .unwrap()
+        .flatten()
+        .filter(|e| e.file_name().to_string_lossy().contains(".pass"))
+        .collect();
+    assert!(
+        files.is_empty(),
+        "Statistics beside the output: {:?}",
+        files
+    );
+}
+
+/// Test the structured events of a slideshow, with a skipped slide
+#[test]
+fn test_slideshow_logger() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    save_png(&generate_test_image(320, 240, [40, 160, 80, 255]), &path).unwrap();
+    let missing = temp_dir.path().join("missing.png");
+    let entries: Vec<SlideEntry> = [&path, &missing]
+        .iter()
+        .map(|path| SlideEntry {
+            path: path.to_string_lossy().to_string(),
+            duration_ms: 300,
+            ..Default::default()
+        })
+        .collect();
+
+    let events: Arc<Mutex<Vec<LogEvent>>> = Arc::default();
+    let log = events.clone();
+    let options = EncodeOptions {
+        output_path: temp_dir
+            .path()
+            .join("output.webm")
+            .to_string_lossy()
+            .to_string(),
+        container: Container::WebM,
+        codec: Codec::Av1,
+        logger: Some(LogHandler::new(move |event| {
+            log.lock().unwrap().push(event.clone())
+        })),
+        ..Default::default()
+    };
+    slideshow_skipping_invalid(&entries, &options).unwrap();
+
+    let events = events.lock().unwrap();
+    let stages: Vec<String> = events
+        .iter()
+        .filter(|e| e.message == "stage started")
+        .map(|e| e.attrs[0].1.to_string())
+        .collect();
+    assert_eq!(stages, ["load", "encode", "mux"]);
+    let chosen = events
+        .iter()
+        .find(|e| e.message == "encoder chosen")
+        .unwrap();
+    assert!(chosen.attrs.contains(&("encoder", LogValue::from("rav1e"))));
+    let skipped = events
+        .iter()
+        .find(|e| e.message == "slide skipped")
+        .unwrap();
+    assert_eq!(skipped.level, LogLevel::Warn);
+    assert!(skipped.attrs.contains(&("index", LogValue::Int(1))));
+    assert_eq!(events.last().unwrap().message, "job finished");
+}
+
+/// Test the warnings of a slideshow with an odd frame size and a skipped slide
+#[test]
+fn test_slideshow_warnings() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    save_png(&generate_test_image(321, 241, [40, 160, 80, 255]), &path).unwrap();
+    let missing = temp_dir.path().join("missing.png");
+    let entries: Vec<SlideEntry> = [&path, &missing]
+        .iter()
+        .map(|path| SlideEntry {
+            path: path.to_string_lossy().to_string(),
+            duration_ms: 300,
+            ..Default::default()
+        })
+        .collect();
+
+    let warnings: Arc<Mutex<Vec<Warning>>> = Arc::default();
+    let warned = warnings.clone();
+    let options = EncodeOptions {
+        output_path: temp_dir
+            .path()
+            .join("output.webm")
+            .to_string_lossy()
+            .to_string(),
+        container: Container::WebM,
+        codec: Codec::Av1,
+        on_warning: Some(WarningHandler::new(move |warning| {
+            warned.lock().unwrap().push(warning.clone())
+        })),
+        ..Default::default()
+    };
+    slideshow_skipping_invalid(&entries, &options).unwrap();
+
+    let warnings = warnings.lock().unwrap();
+    let kinds: Vec<WarningKind> = warnings.iter().map(|w| w.kind).collect();
+    assert_eq!(kinds, [WarningKind::SlideSkipped, WarningKind::SizeRounded]);
+    assert!(warnings[0].message.starts_with("Slide 2 ("));
+    assert!(warnings[1]
+        .message
+        .contains("321x241 rounded down to 320x240"));
+}
+
+/// Test chapter markers made from the slides and listed in the options
+#[test]
+fn test_slideshow_chapters() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let entries: Vec<SlideEntry> = (0..2)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&generate_numbered_image(320, 240, i), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 500,
+                caption: (i == 0).then(|| "\nOpening remarks\nby the host".to_string()),
+                ..Default::default()
+            }
+        })
+        .collect();
+    let contains = |data: &[u8], text: &str| data.windows(text.len()).any(|w| w == text.as_bytes());
+
+    // One chapter per slide, titled with the first line of its caption
+    let output = temp_dir.path().join("slides.webm");
+    let options = EncodeOptions {
+        output_path: output.to_string_lossy().to_string(),
+        container: Container::WebM,
+        codec: Codec::Av1,
+        chapter_mode: ChapterMode::Slides,
+        ..Default::default()
+    };
+    slideshow(&entries, &options).unwrap();
+    let data = std::fs::read(&output).unwrap();
+    assert!(contains(&data, "Opening remarks"));
+    assert!(!contains(&data, "by the host"));
+    assert!(contains(&data, "Slide 2"));
+
+    // Listed chapters replace them
+    let output = temp_dir.path().join("listed.mkv");
+    let options = EncodeOptions {
+        output_path: output.to_string_lossy().to_string(),
+        container: Container::Mkv,
+        codec: Codec::Av1,
+        chapters: vec![
+            Chapter {
+                start_ms: 0,
+                title: "Start".to_string(),
+            },
+            Chapter {
+                start_ms: 750,
+                title: "Middle".to_string(),
+            },
+        ],
+        ..Default::default()
+    };
+    slideshow(&entries, &options).unwrap();
+    let data = std::fs::read(&output).unwrap();
+    assert!(contains(&data, "Middle"));
+    assert!(!contains(&data, "Slide 2"));
+
+    // Chapters must be in order, in a container that carries them, and
+    // either listed or made from the slides
+    for invalid in [
+        EncodeOptions {
+            container: Container::Avif,
+            chapter_mode: ChapterMode::Slides,
+            ..Default::default()
+        },
+        EncodeOptions {
+            chapters: options.chapters.iter().rev().cloned().collect(),
+            ..options.clone()
+        },
+        EncodeOptions {
+            chapter_mode: ChapterMode::Slides,
+            ..options.clone()
+        },
+    ] {
+        let invalid = EncodeOptions {
+            output_path: temp_dir
+                .path()
+                .join("invalid.mkv")
+                .to_string_lossy()
+                .to_string(),
+            ..invalid
+        };
+        assert!(matches!(
+            slideshow(&entries, &invalid),
+            Err(Error::InvalidInput(_))
+        ));
+    }
+}
+
+/// Test that deterministic output is byte-identical between runs, and its
+/// options that depend on the host
+#[test]
+fn test_slideshow_deterministic() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    save_png(&generate_test_image(320, 240, [200, 120, 40, 255]), &path).unwrap();
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 500,
+        caption: Some("Golden".to_string()),
+        ..Default::default()
+    }];
+
+    let outputs: Vec<_> = ["first.webm", "second.webm"]
+        .iter()
+        .map(|name| temp_dir.path().join(name))
+        .collect();
+    for output in &outputs {
+        let options = EncodeOptions {
+            output_path: output.to_string_lossy().to_string(),
+            container: Container::WebM,
+            codec: Codec::Av1,
+            reproducibility: Reproducibility::Deterministic,
+            ..Default::default()
+        };
+        slideshow(&entries, &options).unwrap();
+    }
+    assert_eq!(
+        std::fs::read(&outputs[0]).unwrap(),
+        std::fs::read(&outputs[1]).unwrap()
+    );
+
+    // Hardware encoders and time budgets depend on the host
+    for invalid in [
+        EncodeOptions {
+            codec: Codec::H264,
+            container: Container::Mp4,
+            hw_accel: HwAccel::Nvenc,
+            ..Default::default()
+        },
+        EncodeOptions {
+            codec: Codec::Av1,
+            container: Container::WebM,
+            time_budget_ms: 5000,
+            ..Default::default()
+        },
+    ] {
+        let invalid = EncodeOptions {
+            output_path: temp_dir
+                .path()
+                .join("invalid.webm")
+                .to_string_lossy()
+                .to_string(),
+            reproducibility: Reproducibility::Deterministic,
+            ..invalid
+        };
+        assert!(matches!(
+            slideshow(&entries, &invalid),
+            Err(Error::InvalidInput(_))
+        ));
+    }
+}
+
+/// Test output frame rates and an out-of-range rate
+#[test]
+fn test_slideshow_frame_rate() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let entries: Vec<_> = (0..3)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&generate_numbered_image(320, 240, i), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 1000,
+                ..Default::default()
+            }
+        })
+        .collect();
+
+    for fps in [24, 60] {
+        let output_path = temp_dir.path().join(format!("output_{}.webm", fps));
+        let options = EncodeOptions {
+            output_path: output_path.to_string_lossy().to_string(),
+            fps,
+            ..Default::default()
+        };
+        let result = slideshow(&entries, &options);
+        assert!(result.is_ok(), "{} fps slideshow failed: {:?}", fps, result);
+        assert!(verify_webm_header(&output_path));
+        if ffmpeg_available() {
+            // Timecodes do not drift at rates that do not divide a second
+            let duration = probe_duration(&output_path).unwrap();
+            assert!((duration - 3.0).abs() < 0.05, "Duration was {}", duration);
+        }
+    }
+
+    let invalid = EncodeOptions {
+        output_path: temp_dir
+            .path()
+            .join("fast.webm")
+            .to_string_lossy()
+            .to_string(),
+        fps: MAX_FPS + 1,
+        ..Default::default()
+    };
+    assert!(slideshow(&entries, &invalid).is_err());
+}
+
+/// Test segment file output with a manifest
+#[test]
+fn test_slideshow_segments() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let entries: Vec<_> = (0..3)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&generate_numbered_image(320, 240, i), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 1000,
+                ..Default::default()
+            }
+        })
+        .collect();
+
+    let manifest_path = temp_dir.path().join("video.json");
+    let options = EncodeOptions {
+        output_path: manifest_path.to_string_lossy().to_string(),
+        output_mode: OutputMode::Segments,
+        segment_duration_ms: 1000,
+        ..Default::default()
+    };
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "Segment slideshow failed: {:?}", result);
+
+    // Keyframes are placed every second, so each second is a segment
+    let manifest = std::fs::read_to_string(&manifest_path).unwrap();
+    for i in 0..3 {
+        let name = format!("video_{:05}.webm", i);
+        assert!(verify_webm_header(&temp_dir.path().join(&name)));
+        assert!(manifest.contains(&format!(
+            "{{\"file\": \"{}\", \"start_ms\": {}, \"duration_ms\": 1000}}",
+            name,
+            i * 1000
+        )));
+    }
+    assert!(!temp_dir.path().join("video_00003.webm").exists());
+
+    // Streaming containers have their own segments
+    let invalid = EncodeOptions {
+        container: Container::Hls,
+        codec: Codec::H264,
+        ..options.clone()
+    };
+    assert!(slideshow(&entries, &invalid).is_err());
+
+    // Merged idle frames would not match the output time
+    let invalid = EncodeOptions {
+        idle_frames: IdleFrames::Merge,
+        ..options
+    };
+    assert!(slideshow(&entries, &invalid).is_err());
+}
+
+/// Test slideshow with a keyframe interval and forced keyframes
+#[test]
+fn test_slideshow_keyframes() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    save_png(&generate_numbered_image(320, 240, 0), &path).unwrap();
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 3000,
+        ..Default::default()
+    }];
+
+    for codec in [Codec::Av1, Codec::Vp9] {
+        let output_path = temp_dir.path().join(format!("{:?}.webm", codec));
+        let options = EncodeOptions {
+            output_path: output_path.to_string_lossy().to_string(),
+            codec,
+            keyframe_interval_ms: 1000,
+            keyframe_times_ms: vec![1500],
+            ..Default::default()
+        };
+        let result = slideshow(&entries, &options);
+        assert!(result.is_ok(), "{:?} slideshow failed: {:?}", codec, result);
+
+        // A single still slide needs no keyframe but the forced ones
+        let times = probe_keyframe_times(&output_path);
+        for expected in [0.0, 1.0, 1.5, 2.0] {
+            assert!(
+                times.iter().any(|t| (t - expected).abs() < 0.01),
+                "{:?}: no keyframe at {} in {:?}",
+                codec,
+                expected,
+                times
+            );
+        }
+    }
+
+    // The interval must divide the segments
+    let options = EncodeOptions {
+        output_path: temp_dir
+            .path()
+            .join("video.json")
+            .to_string_lossy()
+            .to_string(),
+        output_mode: OutputMode::Segments,
+        segment_duration_ms: 1000,
+        keyframe_interval_ms: 300,
+        ..Default::default()
+    };
+    assert!(slideshow(&entries, &options).is_err());
+
+    // Merged idle frames would shift the forced frames
+    let options = EncodeOptions {
+        output_path: temp_dir
+            .path()
+            .join("merged.webm")
+            .to_string_lossy()
+            .to_string(),
+        idle_frames: IdleFrames::Merge,
+        keyframe_times_ms: vec![1500],
+        ..Default::default()
+    };
+    assert!(slideshow(&entries, &options).is_err());
+}
+
+/// Test slideshow with pixel formats and 10-bit output
+#[test]
+fn test_slideshow_pixel_format() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    save_png(&generate_test_image(320, 240, [40, 90, 200, 255]), &path).unwrap();
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 500,
+        ..Default::default()
+    }];
+
+    let cases = [
+        (
+            Codec::Av1,
+            Container::WebM,
+            PixelFormat::Yuv444,
+            "yuv444p10le",
+        ),
+        (
+            Codec::Vp9,
+            Container::WebM,
+            PixelFormat::Yuv422,
+            "yuv422p10le",
+        ),
+        (
+            Codec::Hevc,
+            Container::Mp4,
+            PixelFormat::Auto,
+            "yuv420p10le",
+        ),
+    ];
+    for (codec, container, pixel_format, expected) in cases {
+        if available(codec, None).is_err() {
+            println!("Skipping {:?}: codec not available", codec);
+            continue;
+        }
+        let output_path = temp_dir.path().join(format!("{:?}.out", codec));
+        let options = EncodeOptions {
+            output_path: output_path.to_string_lossy().to_string(),
+            container,
+            codec,
+            pixel_format,
+            bit_depth: BitDepth::Ten,
+            ..Default::default()
+        };
+        let result = slideshow(&entries, &options);
+        assert!(result.is_ok(), "{:?} slideshow failed: {:?}", codec, result);
+        assert_eq!(
+            probe_pixel_format(&output_path).as_deref(),
+            Some(expected),
+            "{:?}",
+            codec
+        );
+    }
+
+    // Combinations the codecs or options cannot encode
+    let output_path = temp_dir.path().join("invalid.webm");
+    let invalid = [
+        (
+            Codec::Vp8,
+            PixelFormat::Yuv444,
+            BitDepth::Eight,
+            Compression::Lossy,
+        ),
+        (
+            Codec::Vp8,
+            PixelFormat::Auto,
+            BitDepth::Ten,
+            Compression::Lossy,
+        ),
+        (
+            Codec::Vp9,
+            PixelFormat::Yuv420,
+            BitDepth::Eight,
+            Compression::Lossless,
+        ),
+    ];
+    for (codec, pixel_format, bit_depth, compression) in invalid {
+        let options = EncodeOptions {
+            output_path: output_path.to_string_lossy().to_string(),
+            codec,
+            pixel_format,
+            bit_depth,
+            compression,
+            ..Default::default()
+        };
+        assert!(slideshow(&entries, &options).is_err(), "{:?}", codec);
+    }
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        codec: Codec::Vp9,
+        transparency: Transparency::Keep,
+        bit_depth: BitDepth::Ten,
+        ..Default::default()
+    };
+    assert!(slideshow(&entries, &options).is_err());
+}
+
+/// Test slideshow with color space options
+#[test]
+fn test_slideshow_color_space() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    save_png(&generate_test_image(320, 240, [220, 60, 40, 255]), &path).unwrap();
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 500,
+        ..Default::default()
+    }];
+
+    // Every video is tagged, BT.709 in the limited range by default
+    let cases = [
+        (Codec::Av1, Container::WebM),
+        (Codec::Vp9, Container::Mkv),
+        (Codec::H264, Container::Mp4),
+        (Codec::Hevc, Container::Mov),
+    ];
+    for (codec, container) in cases {
+        if available(codec, None).is_err() {
+            println!("Skipping {:?}: codec not available", codec);
+            continue;
+        }
+        let output_path = temp_dir
+            .path()
+            .join(format!("{:?}_{:?}.out", codec, container));
+        let mut options = EncodeOptions {
+            output_path: output_path.to_string_lossy().to_string(),
+            container,
+            codec,
+            ..Default::default()
+        };
+        let result = slideshow(&entries, &options);
+        assert!(result.is_ok(), "{:?} slideshow failed: {:?}", codec, result);
+        assert_eq!(
+            probe_color_space(&output_path),
+            Some(["tv", "bt709", "bt709", "bt709"].map(String::from)),
+            "{:?} in {:?}",
+            codec,
+            container
+        );
+
+        options.color_primaries = ColorPrimaries::Bt601;
+        options.color_matrix = ColorMatrix::Bt601;
+        options.color_range = ColorRange::Full;
+        if cfg!(target_os = "macos") && codec == Codec::H264 {
+            options.color_range = ColorRange::Limited;
+        }
+        let result = slideshow(&entries, &options);
+        assert!(result.is_ok(), "{:?} slideshow failed: {:?}", codec, result);
+        let range = match options.color_range {
+            ColorRange::Full => "pc",
+            _ => "tv",
+        };
+        assert_eq!(
+            probe_color_space(&output_path),
+            Some([range, "smpte170m", "bt709", "smpte170m"].map(String::from)),
+            "{:?} in {:?}",
+            codec,
+            container
+        );
+    }
+
+    // Options that cannot apply
+    let output_path = temp_dir.path().join("invalid.mov");
+    let invalid = [
+        EncodeOptions {
+            codec: Codec::Prores,
+            container: Container::Mov,
+            color_range: ColorRange::Full,
+            ..Default::default()
+        },
+        EncodeOptions {
+            codec: Codec::Png,
+            container: Container::Apng,
+            color_matrix: ColorMatrix::Bt709,
+            ..Default::default()
+        },
+        EncodeOptions {
+            bit_depth: BitDepth::Ten,
+            dynamic_range: DynamicRange::Hdr10,
+            color_transfer: ColorTransfer::Bt709,
+            ..Default::default()
+        },
+    ];
+    for options in invalid {
+        let options = EncodeOptions {
+            output_path: output_path.to_string_lossy().to_string(),
+            ..options
+        };
+        assert!(
+            slideshow(&entries, &options).is_err(),
+            "{:?}",
+            options.codec
+        );
+    }
+}
+
+/// Test slideshow with HDR10 output
+#[test]
+fn test_slideshow_hdr10() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    save_png(&generate_test_image(320, 240, [200, 160, 40, 255]), &path).unwrap();
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 500,
+        caption: Some("HDR".to_string()),
+        ..Default::default()
+    }];
+
+    let cases = [
+        (Codec::Av1, Container::WebM),
+        (Codec::Vp9, Container::WebM),
+        (Codec::Hevc, Container::Mp4),
+        (Codec::Hevc, Container::Mov),
+    ];
+    for (codec, container) in cases {
+        if available(codec, None).is_err() {
+            println!("Skipping {:?}: codec not available", codec);
+            continue;
+        }
+        let output_path = temp_dir
+            .path()
+            .join(format!("{:?}_{:?}.out", codec, container));
+        let options = EncodeOptions {
+            output_path: output_path.to_string_lossy().to_string(),
+            container,
+            codec,
+            bit_depth: BitDepth::Ten,
+            dynamic_range: DynamicRange::Hdr10,
+            max_luminance: 4000,
+            ..Default::default()
+        };
+        let result = slideshow(&entries, &options);
+        if codec == Codec::Hevc && result.is_err() {
+            // HDR10 HEVC needs libx265 rather than any HEVC encoder
+            println!("Skipping HEVC: {:?}", result);
+            continue;
+        }
+        assert!(result.is_ok(), "{:?} slideshow failed: {:?}", codec, result);
+        assert_eq!(
+            probe_color_transfer(&output_path).as_deref(),
+            Some("smpte2084"),
+            "{:?} in {:?}",
+            codec,
+            container
+        );
+    }
+
+    // Combinations HDR10 cannot encode
+    let output_path = temp_dir.path().join("invalid.webm");
+    let invalid = [
+        (Codec::Av1, Container::WebM, BitDepth::Eight, 0),
+        (Codec::H264, Container::Mp4, BitDepth::Ten, 0),
+        (Codec::Av1, Container::Avif, BitDepth::Ten, 0),
+        (Codec::Av1, Container::WebM, BitDepth::Ten, 100),
+        (Codec::Av1, Container::WebM, BitDepth::Ten, 20000),
+    ];
+    for (codec, container, bit_depth, max_luminance) in invalid {
+        let options = EncodeOptions {
+            output_path: output_path.to_string_lossy().to_string(),
+            container,
+            codec,
+            bit_depth,
+            dynamic_range: DynamicRange::Hdr10,
+            max_luminance,
+            ..Default::default()
+        };
+        assert!(
+            slideshow(&entries, &options).is_err(),
+            "{:?} in {:?} at {:?} with {} cd/m²",
+            codec,
+            container,
+            bit_depth,
+            max_luminance
+        );
+    }
+}
+
+/// Test slideshow with an out-of-range caption width (should fail)
+#[test]
+fn test_slideshow_invalid_caption_max_width() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    let img = generate_numbered_image(320, 240, 0);
+    save_png(&img, &path).unwrap();
+
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 200,
+        caption: Some("Hello".to_string()),
+        ..Default::default()
+    }];
+
+    let options = EncodeOptions {
+        output_path: temp_dir
+            .path()
+            .join("output.webm")
+            .to_string_lossy()
+            .to_string(),
+        caption_max_width: 150,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_err(), "Caption max width over 100 should fail");
+}
+
+/// Test slideshow with an out-of-range safe area (should fail)
+#[test]
+fn test_slideshow_invalid_safe_area() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    let img = generate_numbered_image(320, 240, 0);
+    save_png(&img, &path).unwrap();
+
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 200,
+        caption: Some("Hello".to_string()),
+        ..Default::default()
+    }];
+
+    let options = EncodeOptions {
+        output_path: temp_dir
+            .path()
+            .join("output.webm")
+            .to_string_lossy()
+            .to_string(),
+        safe_area: 50,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_err(), "Safe area over 40 should fail");
+}
+
+// ============================================================================
+// Container/Codec combination tests
+// Supported combinations:
+// - WebM + AV1 (all platforms, using rav1e)
+// - MP4 + H.264 (macOS: VideoToolbox, Windows: MediaFoundation, Linux: ffmpeg)
+// ============================================================================
+
+/// Test MP4 container with H.264 codec (platform-specific)
+#[test]
+#[cfg(target_os = "macos")]
+fn test_slideshow_mp4_h264_macos() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    let img = generate_numbered_image(320, 240, 0);
+    save_png(&img, &path).unwrap();
+
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 500,
+        ..Default::default()
+    }];
+
+    let output_path = temp_dir.path().join("output.mp4");
+
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::Mp4,
+        codec: Codec::H264,
+        quality: 50,
+        ffmpeg_path: None,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(
+        result.is_ok(),
+        "MP4+H.264 slideshow failed on macOS: {:?}",
+        result
+    );
+    assert!(verify_file_exists_with_size(&output_path));
+    assert!(
+        verify_mp4_header(&output_path),
+        "Output file is not a valid MP4"
+    );
+}
+
+/// Test MP4 container with H.264 codec on Windows
+#[test]
+#[cfg(target_os = "windows")]
+fn test_slideshow_mp4_h264_windows() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    let img = generate_numbered_image(320, 240, 0);
+    save_png(&img, &path).unwrap();
+
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 500,
+        ..Default::default()
+    }];
+
+    let output_path = temp_dir.path().join("output.mp4");
+
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::Mp4,
+        codec: Codec::H264,
+        quality: 50,
+        ffmpeg_path: None,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(
+        result.is_ok(),
+        "MP4+H.264 slideshow failed on Windows: {:?}",
+        result
+    );
+    assert!(verify_file_exists_with_size(&output_path));
+    assert!(
+        verify_mp4_header(&output_path),
+        "Output file is not a valid MP4"
+    );
+}
+
+/// Test MP4 container with H.264 codec on Linux (requires ffmpeg)
+#[test]
+#[cfg(target_os = "linux")]
+fn test_slideshow_mp4_h264_linux() {
+    use minmpeg::available;
+
+    // First check if H.264 is available (requires ffmpeg)
+    if available(Codec::H264, None).is_err() {
+        println!("Skipping MP4+H.264 test on Linux: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    let img = generate_numbered_image(320, 240, 0);
+    save_png(&img, &path).unwrap();
+
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 500,
+        ..Default::default()
+    }];
+
+    let output_path = temp_dir.path().join("output.mp4");
+
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::Mp4,
+        codec: Codec::H264,
+        quality: 50,
+        ffmpeg_path: None,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(
+        result.is_ok(),
+        "MP4+H.264 slideshow failed on Linux: {:?}",
+        result
+    );
+    assert!(verify_file_exists_with_size(&output_path));
+    assert!(
+        verify_mp4_header(&output_path),
+        "Output file is not a valid MP4"
+    );
+
+    // The movie box comes before the media data by default
+    let data = std::fs::read(&output_path).unwrap();
+    let position = |kind: &[u8]| data.windows(4).position(|w| w == kind).unwrap();
+    assert!(position(b"moov") < position(b"mdat"));
+}
+
+/// Test MP4 container with HEVC codec (requires ffmpeg with an HEVC encoder)
+#[test]
+fn test_slideshow_mp4_hevc() {
+    use minmpeg::available;
+
+    if available(Codec::Hevc, None).is_err() {
+        println!("Skipping MP4+HEVC test: no HEVC encoder available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+
+    let entries: Vec<SlideEntry> = (0..3)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&generate_numbered_image(320, 240, i), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 300,
+                ..Default::default()
+            }
+        })
+        .collect();
+
+    let output_path = temp_dir.path().join("output.mp4");
+
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::Mp4,
+        codec: Codec::Hevc,
+        quality: 50,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "MP4+HEVC slideshow failed: {:?}", result);
+    assert!(verify_file_exists_with_size(&output_path));
+    assert!(
+        verify_mp4_header(&output_path),
+        "Output file is not a valid MP4"
+    );
+    let data = std::fs::read(&output_path).unwrap();
+    assert!(
+        data.windows(4).any(|w| w == b"hvcC"),
+        "Output has no hvcC configuration"
+    );
+    if ffmpeg_available() {
+        let duration = probe_duration(&output_path).unwrap();
+        assert!((duration - 0.9).abs() < 0.1, "Duration was {}", duration);
+    }
+}
+
+/// Test MOV container with ProRes codec (requires ffmpeg with prores_ks)
+#[test]
+fn test_slideshow_mov_prores() {
+    use minmpeg::available;
+
+    if available(Codec::Prores, None).is_err() {
+        println!("Skipping MOV+ProRes test: ffmpeg with prores_ks not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+
+    let entries: Vec<SlideEntry> = (0..3)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&generate_numbered_image(320, 240, i), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 300,
+                ..Default::default()
+            }
+        })
+        .collect();
+
+    let output_path = temp_dir.path().join("output.mov");
+
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::Mov,
+        codec: Codec::Prores,
+        quality: 90,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "MOV+ProRes slideshow failed: {:?}", result);
+    assert!(verify_file_exists_with_size(&output_path));
+    let data = std::fs::read(&output_path).unwrap();
+    assert_eq!(&data[4..12], b"ftypqt  ", "Output is not a QuickTime file");
+    assert!(
+        data.windows(4).any(|w| w == b"apch"),
+        "Output has no ProRes 422 HQ track"
+    );
+    if ffmpeg_available() {
+        let duration = probe_duration(&output_path).unwrap();
+        assert!((duration - 0.9).abs() < 0.1, "Duration was {}", duration);
+    }
+
+    let options = EncodeOptions {
+        container: Container::Mp4,
+        ..options
+    };
+    assert!(
+        slideshow(&entries, &options).is_err(),
+        "MP4+ProRes should be rejected"
+    );
+}
+
+/// Test MOV container with H.264 codec
+#[test]
+fn test_slideshow_mov_h264() {
+    use minmpeg::available;
+
+    if available(Codec::H264, None).is_err() {
+        println!("Skipping MOV+H.264 test: H.264 encoder not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+
+    let entries: Vec<SlideEntry> = (0..2)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&generate_numbered_image(320, 240, i), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 300,
+                ..Default::default()
+            }
+        })
+        .collect();
+
+    let output_path = temp_dir.path().join("output.mov");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::Mov,
+        codec: Codec::H264,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "MOV+H.264 slideshow failed: {:?}", result);
+    let data = std::fs::read(&output_path).unwrap();
+    assert_eq!(&data[4..12], b"ftypqt  ", "Output is not a QuickTime file");
+    assert!(
+        data.windows(4).any(|w| w == b"avcC"),
+        "Output has no H.264 configuration"
+    );
+    if ffmpeg_available() {
+        let duration = probe_duration(&output_path).unwrap();
+        assert!((duration - 0.6).abs() < 0.1, "Duration was {}", duration);
+    }
+}
+
+/// Test WebM container with VP9 codec (requires ffmpeg with libvpx-vp9)
+#[test]
+fn test_slideshow_webm_vp9() {
+    use minmpeg::available;
+
+    if available(Codec::Vp9, None).is_err() {
+        println!("Skipping WebM+VP9 test: ffmpeg with libvpx-vp9 not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+
+    let entries: Vec<SlideEntry> = (0..3)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&generate_numbered_image(320, 240, i), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 300,
+                ..Default::default()
+            }
+        })
+        .collect();
+
+    let output_path = temp_dir.path().join("output.webm");
+
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::WebM,
+        codec: Codec::Vp9,
+        quality: 50,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "WebM+VP9 slideshow failed: {:?}", result);
+    assert!(verify_file_exists_with_size(&output_path));
+    assert!(
+        verify_webm_header(&output_path),
+        "Output file is not a valid WebM"
+    );
+
+    let options = EncodeOptions {
+        container: Container::Mp4,
+        ..options
+    };
+    assert!(
+        slideshow(&entries, &options).is_err(),
+        "MP4+VP9 should be rejected"
+    );
+}
+
+/// Test two-pass VP9 encoding (requires ffmpeg with libvpx-vp9)
+#[test]
+fn test_slideshow_two_pass() {
+    use minmpeg::available;
+
+    let temp_dir = TempDir::new().unwrap();
+
+    let entries: Vec<SlideEntry> = (0..2)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&generate_numbered_image(320, 240, i), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 300,
+                ..Default::default()
+            }
+        })
+        .collect();
+
+    let output_path = temp_dir.path().join("output.webm");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::WebM,
+        codec: Codec::Av1,
+        passes: Passes::Two,
+        ..Default::default()
+    };
+
+    // Only the libvpx encoders have two passes
+    assert!(slideshow(&entries, &options).is_err());
+
+    if available(Codec::Vp9, None).is_err() {
+        println!("Skipping two-pass test: ffmpeg with libvpx-vp9 not available");
+        return;
+    }
+    let options = EncodeOptions {
+        codec: Codec::Vp9,
+        ..options
+    };
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "Two-pass slideshow failed: {:?}", result);
+    assert!(verify_webm_header(&output_path));
+
+    // The statistics files are removed
+    let files: Vec<_> = std::fs::read_dir(temp_dir.path())
+        .unwrap()
+        .flatten()
+        .filter(|e| e.file_name().to_string_lossy().contains(".pass"))
+        .collect();
+    assert!(files.is_empty(), "Statistics left behind: {:?}", files);
+}
+
+/// Test MKV container with AV1 codec
+#[test]
+fn test_slideshow_mkv() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let entries: Vec<SlideEntry> = (0..2)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&generate_numbered_image(320, 240, i), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 300,
+                ..Default::default()
+            }
+        })
+        .collect();
+
+    let output_path = temp_dir.path().join("output.mkv");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::Mkv,
+        codec: Codec::Av1,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "MKV slideshow failed: {:?}", result);
+
+    // EBML header with the matroska DocType
+    let data = std::fs::read(&output_path).unwrap();
+    assert_eq!(&data[0..4], &[0x1A, 0x45, 0xDF, 0xA3]);
+    assert!(data.windows(8).any(|w| w == b"matroska"));
+
+    // PNG is not supported
+    let options = EncodeOptions {
+        codec: Codec::Png,
+        ..options
+    };
+    assert!(slideshow(&entries, &options).is_err());
+}
+
+/// Test HLS output with AV1 in fMP4 segments
+#[test]
+fn test_slideshow_hls() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let entries: Vec<SlideEntry> = (0..3)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&generate_numbered_image(320, 240, i), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 1000,
+                ..Default::default()
+            }
+        })
+        .collect();
+
+    let output_path = temp_dir.path().join("video.m3u8");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::Hls,
+        codec: Codec::Av1,
+        hls_segment: HlsSegment::Fmp4,
+        segment_duration_ms: 1000,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "HLS slideshow failed: {:?}", result);
+    let playlist = std::fs::read_to_string(&output_path).unwrap();
+    assert!(playlist.starts_with("#EXTM3U\n"));
+    assert!(playlist.contains("#EXT-X-MAP:URI=\"video_init.mp4\""));
+    assert!(playlist.ends_with("#EXT-X-ENDLIST\n"));
+    assert!(temp_dir.path().join("video_init.mp4").is_file());
+    assert!(temp_dir.path().join("video_00000.m4s").is_file());
+
+    // AV1 is not carried in MPEG-TS
+    let options = EncodeOptions {
+        hls_segment: HlsSegment::Ts,
+        ..options
+    };
+    assert!(slideshow(&entries, &options).is_err());
+}
+
+/// Test DASH output with AV1
+#[test]
+fn test_slideshow_dash() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let entries: Vec<SlideEntry> = (0..3)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&generate_numbered_image(320, 240, i), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 1000,
+                ..Default::default()
+            }
+        })
+        .collect();
+
+    let output_path = temp_dir.path().join("video.mpd");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::Dash,
+        codec: Codec::Av1,
+        segment_duration_ms: 1000,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "DASH slideshow failed: {:?}", result);
+    let manifest = std::fs::read_to_string(&output_path).unwrap();
+    assert!(manifest.contains("<MPD "));
+    assert!(manifest.contains("codecs=\"av01."));
+    assert!(manifest.contains("<SegmentTimeline>"));
+    assert!(temp_dir.path().join("video_init.mp4").is_file());
+    assert!(temp_dir.path().join("video_00000.m4s").is_file());
+}
+
+/// Test fragmented MP4 output with AV1
+#[test]
+fn test_slideshow_fragmented_mp4() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let entries: Vec<SlideEntry> = (0..2)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&generate_numbered_image(320, 240, i), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 500,
+                ..Default::default()
+            }
+        })
+        .collect();
+
+    let output_path = temp_dir.path().join("output.mp4");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::Mp4,
+        codec: Codec::Av1,
+        mp4_layout: Mp4Layout::Fragmented,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(
+        result.is_ok(),
+        "Fragmented MP4 slideshow failed: {:?}",
+        result
+    );
+    let data = std::fs::read(&output_path).unwrap();
+    assert_eq!(&data[4..8], b"ftyp");
+    assert!(data.windows(4).any(|w| w == b"mvex"));
+    assert!(data.windows(4).any(|w| w == b"moof"));
+}
+
+/// Test keeping the alpha channel of transparent slides (requires ffmpeg
+/// with libvpx-vp9)
+#[test]
+fn test_slideshow_transparency() {
+    use minmpeg::available;
+
+    let temp_dir = TempDir::new().unwrap();
+
+    // Opaque square on a half-transparent background
+    let mut img = generate_test_image(320, 240, [0, 0, 255, 128]);
+    for y in 80..160 {
+        for x in 120..200 {
+            img.put_pixel(x, y, image::Rgba([255, 0, 0, 255]));
+        }
+    }
+    let path = temp_dir.path().join("slide.png");
+    save_png(&img, &path).unwrap();
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 300,
+        ..Default::default()
+    }];
+
+    // AV1 has no alpha channel support
+    let output_path = temp_dir.path().join("output.webm");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        transparency: Transparency::Keep,
+        ..Default::default()
+    };
+    assert!(slideshow(&entries, &options).is_err());
+
+    if available(Codec::Vp9, None).is_err() {
+        println!("Skipping transparency test: ffmpeg with libvpx-vp9 not available");
+        return;
+    }
+
+    let options = EncodeOptions {
+        codec: Codec::Vp9,
+        ..options
+    };
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "Transparent slideshow failed: {:?}", result);
+    assert!(verify_webm_header(&output_path));
+
+    // AlphaMode = 1 and BlockAdditions holding the alpha frames
+    let data = std::fs::read(&output_path).unwrap();
+    assert!(data.windows(4).any(|w| w == [0x53, 0xC0, 0x81, 0x01]));
+    assert!(data.windows(2).any(|w| w == [0x75, 0xA1]));
+}
+
+/// Test WebM container with VP8 codec (requires ffmpeg with libvpx)
+#[test]
+fn test_slideshow_webm_vp8() {
+    use minmpeg::available;
+
+    if available(Codec::Vp8, None).is_err() {
+        println!("Skipping WebM+VP8 test: ffmpeg with libvpx not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+
+    let entries: Vec<SlideEntry> = (0..3)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&generate_numbered_image(320, 240, i), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 300,
+                ..Default::default()
+            }
+        })
+        .collect();
+
+    let output_path = temp_dir.path().join("output.webm");
+
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::WebM,
+        codec: Codec::Vp8,
+        quality: 50,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "WebM+VP8 slideshow failed: {:?}", result);
+    assert!(verify_file_exists_with_size(&output_path));
+    assert!(
+        verify_webm_header(&output_path),
+        "Output file is not a valid WebM"
+    );
+}
+
+/// Test WebM container with AV1 codec (multiple slides to ensure encoding works)
+#[test]
+fn test_slideshow_webm_av1_multiple() {
+    let temp_dir = TempDir::new().unwrap();
+
+    // Create 5 slides with different colors
+    let image_paths: Vec<_> = (0..5)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            let img = generate_numbered_image(320, 240, i);
+            save_png(&img, &path).unwrap();
+            path
+        })
+        .collect();
+
+    let entries: Vec<SlideEntry> = image_paths
+        .iter()
+        .map(|path| SlideEntry {
+            path: path.to_string_lossy().to_string(),
+            duration_ms: 200,
+            ..Default::default()
+        })
+        .collect();
+
+    let output_path = temp_dir.path().join("output.webm");
+
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::WebM,
+        codec: Codec::Av1,
+        quality: 50,
+        ffmpeg_path: None,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(
+        result.is_ok(),
+        "WebM+AV1 multiple slides failed: {:?}",
+        result
+    );
+    assert!(verify_file_exists_with_size(&output_path));
+    assert!(verify_webm_header(&output_path));
+
+    // Verify file has reasonable size for 5 slides
+    let size = get_file_size(&output_path).unwrap();
+    assert!(
+        size > 2000,
+        "Output file too small for 5 slides: {} bytes",
+        size
+    );
+}
+
+/// Test animated AVIF output with AV1 codec
+#[test]
+fn test_slideshow_avif_av1() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let entries: Vec<SlideEntry> = (0..3)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            let img = generate_numbered_image(320, 240, i);
+            save_png(&img, &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 200,
+                ..Default::default()
+            }
+        })
+        .collect();
+
+    let output_path = temp_dir.path().join("output.avif");
+
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::Avif,
+        codec: Codec::Av1,
+        quality: 50,
+        ffmpeg_path: None,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "AVIF+AV1 slideshow failed: {:?}", result);
+    assert!(verify_file_exists_with_size(&output_path));
+    assert!(
+        verify_avif_header(&output_path),
+        "Output file is not a valid animated AVIF"
+    );
+}
+
+/// Test AVIF container with H.264 codec (should fail)
+#[test]
+fn test_slideshow_avif_h264_mismatch() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    let img = generate_numbered_image(320, 240, 0);
+    save_png(&img, &path).unwrap();
+
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 200,
+        ..Default::default()
+    }];
+
+    let options = EncodeOptions {
+        output_path: temp_dir
+            .path()
+            .join("output.avif")
+            .to_string_lossy()
+            .to_string(),
+        container: Container::Avif,
+        codec: Codec::H264,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_err(), "AVIF+H.264 should fail");
+}
+
+/// Test APNG output with PNG codec (transparent slides)
+#[test]
+fn test_slideshow_apng_png() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let entries: Vec<SlideEntry> = (0..2)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            let img = generate_test_image(64, 64, [255, 0, 0, 128 * i as u8]);
+            save_png(&img, &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 500,
+                ..Default::default()
+            }
+        })
+        .collect();
+
+    let output_path = temp_dir.path().join("output.png");
+
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::Apng,
+        codec: Codec::Png,
+        quality: 50,
+        ffmpeg_path: None,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "APNG slideshow failed: {:?}", result);
+    assert!(
+        verify_apng_header(&output_path),
+        "Output file is not a valid APNG"
+    );
+
+    // Held slides are merged, so the file stays close to two PNG frames
+    let size = get_file_size(&output_path).unwrap();
+    assert!(size < 10_000, "APNG output is too large: {} bytes", size);
+}
+
+/// Test PNG codec in a video container (should fail)
+#[test]
+fn test_slideshow_png_codec_mismatch() {
+    let options = EncodeOptions {
+        output_path: "output.webm".to_string(),
+        container: Container::WebM,
+        codec: Codec::Png,
+        ..Default::default()
+    };
+
+    assert!(options.validate().is_err());
+}
+
+/// Test MP4 container with H.264 codec (multiple slides, macOS)
+#[test]
+#[cfg(target_os = "macos")]
+fn test_slideshow_mp4_h264_multiple_macos() {
+    let temp_dir = TempDir::new().unwrap();
+
+    // Create 5 slides with different colors
+    let image_paths: Vec<_> = (0..5)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            let img = generate_numbered_image(320, 240, i);
+            save_png(&img, &path).unwrap();
+            path
+        })
+        .collect();
+
+    let entries: Vec<SlideEntry> = image_paths
+        .iter()
+        .map(|path| SlideEntry {
+            path: path.to_string_lossy().to_string(),
+            duration_ms: 200,
+            ..Default::default()
+        })
+        .collect();
+
+    let output_path = temp_dir.path().join("output.mp4");
+
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::Mp4,
+        codec: Codec::H264,
+        quality: 50,
+        ffmpeg_path: None,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(
+        result.is_ok(),
+        "MP4+H.264 multiple slides failed on macOS: {:?}",
+        result
+    );
+    assert!(verify_file_exists_with_size(&output_path));
+    assert!(verify_mp4_header(&output_path));
+
+    // Verify file has reasonable size for 5 slides
+    let size = get_file_size(&output_path).unwrap();
+    assert!(
+        size > 2000,
+        "Output file too small for 5 slides: {} bytes",
+        size
+    );
+}
+
+/// Test MP4 container with H.264 codec (multiple slides, Windows)
+#[test]
+#[cfg(target_os = "windows")]
+fn test_slideshow_mp4_h264_multiple_windows() {
+    let temp_dir = TempDir::new().unwrap();
+
+    // Create 5 slides with different colors
+    let image_paths: Vec<_> = (0..5)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            let img = generate_numbered_image(320, 240, i);
+            save_png(&img, &path).unwrap();
+            path
+        })
+        .collect();
+
+    let entries: Vec<SlideEntry> = image_paths
+        .iter()
+        .map(|path| SlideEntry {
+            path: path.to_string_lossy().to_string(),
+            duration_ms: 200,
+            ..Default::default()
+        })
+        .collect();
+
+    let output_path = temp_dir.path().join("output.mp4");
+
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::Mp4,
+        codec: Codec::H264,
+        quality: 50,
+        ffmpeg_path: None,
+        ..Default::default()
+    };
+
+    let result = slideshow(&entries, &options);
+    assert!(
+        result.is_ok(),
+        "MP4+H.264 multiple slides failed on Windows: {:?}",
+        result
+    );
+    assert!(verify_file_exists_with_size(&output_path));
     assert!(verify_mp4_header(&output_path));
 
     // Verify file has reasonable size for 5 slides
@@ -684,3 +3243,256 @@ fn test_slideshow_mp4_h264_multiple_windows() {
         size
     );
 }
+
+/// Test fitting a slideshow in a size limit
+#[test]
+fn test_slideshow_within_size() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let entries: Vec<SlideEntry> = (0..3)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&generate_numbered_image(640, 480, i), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 300,
+                ..Default::default()
+            }
+        })
+        .collect();
+
+    let output_path = temp_dir.path().join("output.webm");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        quality: 90,
+        ..Default::default()
+    };
+    slideshow(&entries, &options).unwrap();
+    let full_size = get_file_size(&output_path).unwrap();
+
+    // Unlimited: the first attempt is kept
+    let settings = slideshow_within_size(&entries, &options, u64::MAX).unwrap();
+    assert_eq!(settings.quality, 90);
+    assert_eq!(settings.scale, 100);
+    assert_eq!((settings.width, settings.height), (640, 480));
+
+    let limit = full_size / 2;
+    let settings = slideshow_within_size(&entries, &options, limit).unwrap();
+    assert!(settings.size <= limit, "{:?} exceeds {}", settings, limit);
+    assert_eq!(get_file_size(&output_path), Some(settings.size));
+    assert!(settings.quality < 90 || settings.scale < 100);
+    assert!(verify_webm_header(&output_path));
+
+    // An impossible limit fails
+    assert!(slideshow_within_size(&entries, &options, 1).is_err());
+    assert!(slideshow_within_size(&entries, &options, 0).is_err());
+}
+
+/// Test a target size setting the bitrate
+#[test]
+fn test_slideshow_target_size() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let entries: Vec<SlideEntry> = (0..3)
+        .map(|i| {
+            let path = temp_dir.path().join(format!("slide_{}.png", i));
+            save_png(&generate_numbered_image(640, 480, i), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 1000,
+                ..Default::default()
+            }
+        })
+        .collect();
+
+    let output_path = temp_dir.path().join("output.webm");
+    let target = 40_000;
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        quality: 90,
+        target_size_bytes: target,
+        ..Default::default()
+    };
+    let result = slideshow(&entries, &options);
+    assert!(result.is_ok(), "Target size slideshow failed: {:?}", result);
+    assert!(verify_webm_header(&output_path));
+    let size = get_file_size(&output_path).unwrap();
+    assert!(size <= target * 11 / 10, "{} is far over {}", size, target);
+
+    // Too short a size for the length
+    let invalid = EncodeOptions {
+        target_size_bytes: 100,
+        ..options.clone()
+    };
+    assert!(slideshow(&entries, &invalid).is_err());
+
+    // The target size sets the bitrate itself
+    let invalid = EncodeOptions {
+        bitrate_kbps: 200,
+        ..options.clone()
+    };
+    assert!(slideshow(&entries, &invalid).is_err());
+    assert!(slideshow_within_size(&entries, &options, target).is_err());
+}
+
+/// Test merging duplicate consecutive slides
+#[test]
+fn test_dedupe_slides() {
+    let temp_dir = TempDir::new().unwrap();
+
+    // Slides 1-3 show the same image, the third re-compressed as JPEG
+    let same = generate_numbered_image(320, 240, 0);
+    let other = generate_numbered_image(320, 240, 1);
+    let paths = [
+        temp_dir.path().join("a.png"),
+        temp_dir.path().join("a_copy.png"),
+        temp_dir.path().join("a_copy.jpg"),
+        temp_dir.path().join("b.png"),
+    ];
+    save_png(&same, &paths[0]).unwrap();
+    save_png(&same, &paths[1]).unwrap();
+    save_jpeg(&same, &paths[2], 95).unwrap();
+    save_png(&other, &paths[3]).unwrap();
+
+    let entries: Vec<SlideEntry> = paths
+        .iter()
+        .map(|path| SlideEntry {
+            path: path.to_string_lossy().to_string(),
+            duration_ms: 1000,
+            ..Default::default()
+        })
+        .collect();
+
+    let exact = find_duplicate_slides(&entries, DuplicateMatch::Exact).unwrap();
+    assert_eq!(exact, vec![0, 0, 2, 3]);
+
+    let deduped = dedupe_slides(&entries, DuplicateMatch::Similar).unwrap();
+    assert_eq!(deduped.entries.len(), 2);
+    assert_eq!(deduped.entries[0].duration_ms, 3000);
+    assert_eq!(deduped.entries[1].duration_ms, 1000);
+    assert_eq!(deduped.warnings.len(), 1);
+    assert!(deduped.warnings[0].starts_with("Slides 1-3"));
+
+    // A different caption keeps the slide
+    let mut captioned = entries.clone();
+    captioned[1].caption = Some("Step 2".to_string());
+    let deduped = dedupe_slides(&captioned, DuplicateMatch::Exact).unwrap();
+    assert_eq!(deduped.entries.len(), 4);
+    assert!(deduped.warnings.is_empty());
+}
+
+/// Test previewing the first frame of each slide as PNG
+#[test]
+fn test_preview_slideshow() {
+    let temp_dir = TempDir::new().unwrap();
+
+    // The second slide is narrower and is cropped to the size of the first
+    let sizes = [(320, 240), (160, 240), (320, 240)];
+    let entries: Vec<SlideEntry> = sizes
+        .iter()
+        .enumerate()
+        .map(|(i, &(width, height))| {
+            let path = temp_dir.path().join(format!("slide{}.png", i));
+            save_png(&generate_numbered_image(width, height, i as u32), &path).unwrap();
+            SlideEntry {
+                path: path.to_string_lossy().to_string(),
+                duration_ms: 1000,
+                ..Default::default()
+            }
+        })
+        .collect();
+    let options = EncodeOptions {
+        slide_fit: SlideFit::Cover,
+        ..Default::default()
+    };
+
+    let strip_path = temp_dir.path().join("deck.png");
+    let preview = PreviewOptions {
+        output_path: strip_path.to_string_lossy().to_string(),
+        layout: PreviewLayout::Strip,
+        height: 120,
+    };
+    let paths = preview_slideshow(&entries, &options, &preview).unwrap();
+    assert_eq!(paths, vec![preview.output_path.clone()]);
+    let strip = image::open(&strip_path).unwrap();
+    assert_eq!((strip.width(), strip.height()), (480, 120));
+
+    // Slides at the video size, numbered after the preview path
+    let preview = PreviewOptions {
+        layout: PreviewLayout::Files,
+        height: 0,
+        ..preview
+    };
+    let paths = preview_slideshow(&entries, &options, &preview).unwrap();
+    assert_eq!(paths.len(), 3);
+    for (i, path) in paths.iter().enumerate() {
+        assert!(path.ends_with(&format!("deck_{:05}.png", i + 1)));
+        let slide = image::open(path).unwrap();
+        assert_eq!((slide.width(), slide.height()), (320, 240));
+    }
+
+    // Options are checked as for the video
+    let invalid = EncodeOptions {
+        safe_area: 100,
+        ..options.clone()
+    };
+    assert!(preview_slideshow(&entries, &invalid, &preview).is_err());
+    assert!(preview_slideshow(&[], &options, &preview).is_err());
+}
+
+/// Test choosing the encoder implementation of H.264 and HEVC
+#[test]
+fn test_slideshow_hw_accel() {
+    let temp_dir = TempDir::new().unwrap();
+
+    let path = temp_dir.path().join("slide.png");
+    save_png(&generate_test_image(320, 240, [40, 120, 200, 255]), &path).unwrap();
+    let entries = vec![SlideEntry {
+        path: path.to_string_lossy().to_string(),
+        duration_ms: 500,
+        ..Default::default()
+    }];
+
+    // Software encoders work wherever the codec does
+    for codec in [Codec::H264, Codec::Hevc] {
+        if available(codec, None).is_err() {
+            println!("Skipping {:?}: codec not available", codec);
+            continue;
+        }
+        let output_path = temp_dir.path().join(format!("{:?}.mp4", codec));
+        let options = EncodeOptions {
+            output_path: output_path.to_string_lossy().to_string(),
+            container: Container::Mp4,
+            codec,
+            hw_accel: HwAccel::Software,
+            ..Default::default()
+        };
+        let result = slideshow(&entries, &options);
+        assert!(result.is_ok(), "Software {:?} failed: {:?}", codec, result);
+        assert!(verify_mp4_header(&output_path));
+    }
+
+    // Hardware encoders take lossy 4:2:0 SDR video, and AV1 has none in
+    // VideoToolbox
+    let output_path = temp_dir.path().join("invalid.mp4");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::Mp4,
+        codec: Codec::Av1,
+        hw_accel: HwAccel::VideoToolbox,
+        ..Default::default()
+    };
+    assert!(slideshow(&entries, &options).is_err());
+    let invalid = EncodeOptions {
+        codec: Codec::Hevc,
+        pixel_format: PixelFormat::Yuv444,
+        ..options.clone()
+    };
+    assert!(slideshow(&entries, &invalid).is_err());
+    let invalid = EncodeOptions {
+        codec: Codec::H264,
+        bit_depth: BitDepth::Ten,
+        ..options
+    };
+    assert!(slideshow(&entries, &invalid).is_err());
+}
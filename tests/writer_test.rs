@@ -0,0 +1,144 @@
+//! Integration tests for videos written frame by frame
+
+mod common;
+
+use common::*;
+use minmpeg::{Codec, Container, EncodeOptions, Error, Passes, VideoWriter};
+use tempfile::TempDir;
+
+/// Frame of a solid color growing brighter with `index`
+fn frame(width: u32, height: u32, index: u32) -> Vec<u8> {
+    let level = (index * 20).min(255) as u8;
+    [level, 128, 255 - level, 255].repeat((width * height) as usize)
+}
+
+/// Test writing a WebM video frame by frame
+#[test]
+fn test_writer_webm() {
+    let temp_dir = TempDir::new().unwrap();
+    let output_path = temp_dir.path().join("output.webm");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        fps: 10,
+        ..Default::default()
+    };
+
+    let mut writer = VideoWriter::new(&options, 64, 48).unwrap();
+    for i in 0..10 {
+        writer.write_frame(&frame(64, 48, i)).unwrap();
+    }
+    assert_eq!(writer.frame_count(), 10);
+    writer.finish().unwrap();
+    assert!(verify_webm_header(&output_path));
+}
+
+/// Test writing an APNG frame by frame
+#[test]
+fn test_writer_apng() {
+    let temp_dir = TempDir::new().unwrap();
+    let output_path = temp_dir.path().join("output.png");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        container: Container::Apng,
+        codec: Codec::Png,
+        ..Default::default()
+    };
+
+    let mut writer = VideoWriter::new(&options, 32, 32).unwrap();
+    for i in 0..3 {
+        writer.write_frame(&frame(32, 32, i)).unwrap();
+    }
+    writer.finish().unwrap();
+    assert!(verify_apng_header(&output_path));
+}
+
+/// Test writing synthesized audio beside the frames
+#[test]
+fn test_writer_audio() {
+    if !ffmpeg_available() {
+        println!("Skipping test: ffmpeg not available");
+        return;
+    }
+
+    let temp_dir = TempDir::new().unwrap();
+    let output_path = temp_dir.path().join("output.webm");
+    let options = EncodeOptions {
+        output_path: output_path.to_string_lossy().to_string(),
+        fps: 10,
+        ..Default::default()
+    };
+
+    // Two seconds of video with a 440 Hz stereo tone written in pieces
+    let mut writer = VideoWriter::new(&options, 64, 48).unwrap();
+    for i in 0..20 {
+        writer.write_frame(&frame(64, 48, i)).unwrap();
+        let samples: Vec<i16> = (0..4800)
+            .flat_map(|n| {
+                let t = (i * 4800 + n) as f32 / 48000.0;
+                let v = ((t * 440.0 * std::f32::consts::TAU).sin() * 8000.0) as i16;
+                [v, v]
+            })
+            .collect();
+        writer.write_audio(&samples, 48000, 2).unwrap();
+    }
+    // Another format than the first audio
+    assert!(matches!(
+        writer.write_audio(&[0; 10], 44100, 2),
+        Err(Error::InvalidInput(_))
+    ));
+    writer.finish().unwrap();
+
+    assert!(verify_webm_header(&output_path));
+    assert_eq!(probe_audio_codecs(&output_path), vec!["opus"]);
+    let duration = probe_duration(&output_path).unwrap();
+    assert!(
+        (duration - 2.0).abs() < 0.2,
+        "Unexpected duration {}",
+        duration
+    );
+}
+
+/// Test sizes, frames and options a writer rejects
+#[test]
+fn test_writer_invalid() {
+    let temp_dir = TempDir::new().unwrap();
+    let options = EncodeOptions {
+        output_path: temp_dir
+            .path()
+            .join("output.webm")
+            .to_string_lossy()
+            .to_string(),
+        ..Default::default()
+    };
+
+    for (width, height) in [(0, 48), (63, 48), (64, 47)] {
+        assert!(matches!(
+            VideoWriter::new(&options, width, height),
+            Err(Error::InvalidInput(_))
+        ));
+    }
+    let two_pass = EncodeOptions {
+        passes: Passes::Two,
+        codec: Codec::Vp9,
+        ..options.clone()
+    };
+    assert!(matches!(
+        VideoWriter::new(&two_pass, 64, 48),
+        Err(Error::InvalidInput(_))
+    ));
+
+    let mut writer = VideoWriter::new(&options, 64, 48).unwrap();
+    assert!(matches!(
+        writer.write_frame(&frame(32, 32, 0)),
+        Err(Error::InvalidInput(_))
+    ));
+    // Unsupported rates and channels, and samples not filling every channel
+    for (samples, rate, channels) in [(4, 4000, 1), (4, 48000, 0), (3, 48000, 2)] {
+        assert!(matches!(
+            writer.write_audio(&vec![0; samples], rate, channels),
+            Err(Error::InvalidInput(_))
+        ));
+    }
+    // Nothing was written
+    assert!(matches!(writer.finish(), Err(Error::InvalidInput(_))));
+}